@@ -0,0 +1,505 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Admin serves a JSON API over HTTP for inspecting and controlling the
+// fakes attached to it, so that state can be poked at from a browser,
+// curl or a test suite in another language. Attach fakes by passing
+// Option to their constructors; one Admin can serve several fakes.
+//
+// The API, relative to URL:
+//
+//	GET    /api                            overview of every fake
+//	GET    /api/queues/{queue}/messages    messages waiting in a queue
+//	GET    /api/buckets/{bucket}/objects   keys in a bucket; ?prefix= filters
+//	GET    /api/buckets/{bucket}/objects/{key}  an object's content
+//	GET    /api/redis/keys                 keys in the redis test DB; ?match= filters
+//	POST   /api/reset                      reset every fake
+//	POST   /api/{queues/{queue},buckets/{bucket},redis}/reset  reset one fake
+//	GET    /api/faults                     the failure schedule and the faults fired
+//	PUT    /api/faults                     replace the failure schedule with the body
+//	DELETE /api/faults                     stop injecting faults
+//	POST   /api/partition                  cut the fakes off; ?for= heals after a duration
+//	DELETE /api/partition                  heal the partition
+//
+// EnableDashboard adds a web page for watching the fakes at URL.
+// The failure schedule uses the syntax of ParseFailureSchedule.
+// Listing a queue's messages receives them with a visibility timeout
+// of 0, so it counts as a receive.
+type Admin struct {
+	// URL is the admin API's base URL.
+	URL string
+
+	srv       *httptest.Server
+	partition *NetworkPartition
+
+	mu        sync.Mutex
+	queues    map[string]*FakeSQS
+	buckets   map[string]*FakeS3
+	redis     *FakeRedis
+	spec      string
+	schedule  *FailureSchedule
+	dashboard bool
+}
+
+// NewAdmin starts an Admin with no fakes attached.
+func NewAdmin() *Admin {
+	a := &Admin{
+		partition: NewNetworkPartition(),
+		queues:    make(map[string]*FakeSQS),
+		buckets:   make(map[string]*FakeS3),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api", a.serveOverview)
+	mux.HandleFunc("/api/reset", a.serveReset)
+	mux.HandleFunc("/api/queues/", a.serveQueue)
+	mux.HandleFunc("/api/buckets/", a.serveBucket)
+	mux.HandleFunc("/api/redis/", a.serveRedis)
+	mux.HandleFunc("/api/faults", a.serveFaults)
+	mux.HandleFunc("/api/partition", a.servePartition)
+	mux.HandleFunc("/", a.serveDashboard)
+	a.srv = httptest.NewServer(mux)
+	a.URL = a.srv.URL
+	return a
+}
+
+// Close stops the admin API. The fakes attached to it are left alone.
+func (a *Admin) Close() {
+	a.srv.Close()
+	a.partition.Heal()
+}
+
+// Option attaches the admin API to a fake, so that its state can be
+// inspected and faults injected into it.
+func (a *Admin) Option() Option {
+	return func(o *options) {
+		WithNetworkPartition(a.partition)(o)
+		o.sendHooks = append(o.sendHooks, a.sendHook)
+		o.admin = a
+	}
+}
+
+func (a *Admin) sendHook(r *request.Request) *http.Response {
+	a.mu.Lock()
+	s := a.schedule
+	a.mu.Unlock()
+	if s == nil {
+		return nil
+	}
+	return s.sendHook(r)
+}
+
+// addQueue attaches q, returning a function that detaches it.
+func (a *Admin) addQueue(name string, q *FakeSQS) func() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.queues[name] = q
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(a.queues, name)
+	}
+}
+
+// addBucket attaches s, returning a function that detaches it.
+func (a *Admin) addBucket(name string, s *FakeS3) func() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.buckets[name] = s
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(a.buckets, name)
+	}
+}
+
+// addRedis attaches r, returning a function that detaches it.
+func (a *Admin) addRedis(r *FakeRedis) func() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.redis = r
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.redis = nil
+	}
+}
+
+// AdminOverview is the state of the fakes attached to an Admin, as
+// served at /api.
+type AdminOverview struct {
+	Queues      []AdminQueue  `json:"queues"`
+	Buckets     []AdminBucket `json:"buckets"`
+	Redis       *AdminRedis   `json:"redis,omitempty"`
+	Faults      AdminFaults   `json:"faults"`
+	Partitioned bool          `json:"partitioned"`
+	Errors      []string      `json:"errors,omitempty"`
+}
+
+// AdminQueue is the state of a queue.
+type AdminQueue struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Messages int    `json:"messages"`
+	InFlight int    `json:"inFlight"`
+}
+
+// AdminBucket is the state of a bucket.
+type AdminBucket struct {
+	Name    string `json:"name"`
+	Objects int    `json:"objects"`
+}
+
+// AdminRedis is the state of the redis test DB.
+type AdminRedis struct {
+	Keys int `json:"keys"`
+}
+
+// AdminFaults is the failure schedule in effect, and the faults it
+// has injected.
+type AdminFaults struct {
+	Schedule string           `json:"schedule"`
+	Fired    []ScheduledFault `json:"fired"`
+}
+
+// Overview returns the state of the attached fakes. Errors reading the
+// state of a fake are listed in Errors.
+func (a *Admin) Overview() AdminOverview {
+	a.mu.Lock()
+	queues := sortedKeys(a.queues)
+	buckets := sortedKeys(a.buckets)
+	a.mu.Unlock()
+
+	ov := AdminOverview{Faults: a.faults(), Partitioned: a.partition.Partitioned()}
+	for _, name := range queues {
+		q := a.queue(name)
+		if q == nil {
+			continue
+		}
+		aq := AdminQueue{Name: name, URL: q.URL}
+		out, err := q.Client.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+			QueueUrl:       &q.URL,
+			AttributeNames: aws.StringSlice([]string{"ApproximateNumberOfMessages", "ApproximateNumberOfMessagesNotVisible"}),
+		})
+		if err != nil {
+			ov.Errors = append(ov.Errors, fmt.Sprintf("queue %s: %v", name, err))
+		} else {
+			aq.Messages, _ = strconv.Atoi(aws.StringValue(out.Attributes["ApproximateNumberOfMessages"]))
+			aq.InFlight, _ = strconv.Atoi(aws.StringValue(out.Attributes["ApproximateNumberOfMessagesNotVisible"]))
+		}
+		ov.Queues = append(ov.Queues, aq)
+	}
+	for _, name := range buckets {
+		s := a.bucket(name)
+		if s == nil {
+			continue
+		}
+		keys, err := s.listKeys(name, "")
+		if err != nil {
+			ov.Errors = append(ov.Errors, fmt.Sprintf("bucket %s: %v", name, err))
+		}
+		ov.Buckets = append(ov.Buckets, AdminBucket{Name: name, Objects: len(keys)})
+	}
+	if r := a.redisFake(); r != nil {
+		keys, err := r.Keys(nil)
+		if err != nil {
+			ov.Errors = append(ov.Errors, fmt.Sprintf("redis: %v", err))
+		}
+		ov.Redis = &AdminRedis{Keys: len(keys)}
+	}
+	return ov
+}
+
+// SetFailureSchedule replaces the faults injected into the attached AWS
+// fakes' requests with those spec describes, starting now. An empty
+// spec stops injecting faults.
+func (a *Admin) SetFailureSchedule(spec string) error {
+	var s *FailureSchedule
+	if strings.TrimSpace(spec) != "" {
+		var err error
+		if s, err = ParseFailureSchedule(spec); err != nil {
+			return err
+		}
+		s.Start(SystemClock)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.spec, a.schedule = strings.TrimSpace(spec), s
+	return nil
+}
+
+// Partition returns the NetworkPartition attached to the fakes, which
+// the API's /partition controls.
+func (a *Admin) Partition() *NetworkPartition {
+	return a.partition
+}
+
+func (a *Admin) faults() AdminFaults {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f := AdminFaults{Schedule: a.spec, Fired: []ScheduledFault{}}
+	if a.schedule != nil {
+		f.Fired = append(f.Fired, a.schedule.Fired()...)
+	}
+	return f
+}
+
+func (a *Admin) queue(name string) *FakeSQS {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.queues[name]
+}
+
+func (a *Admin) bucket(name string) *FakeS3 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.buckets[name]
+}
+
+func (a *Admin) redisFake() *FakeRedis {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.redis
+}
+
+func (a *Admin) serveOverview(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	writeAdminJSON(w, a.Overview())
+}
+
+func (a *Admin) serveReset(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	a.mu.Lock()
+	var resetters []Resetter
+	for _, name := range sortedKeys(a.queues) {
+		resetters = append(resetters, a.queues[name])
+	}
+	for _, name := range sortedKeys(a.buckets) {
+		resetters = append(resetters, a.buckets[name])
+	}
+	if a.redis != nil {
+		resetters = append(resetters, a.redis)
+	}
+	a.mu.Unlock()
+	for _, f := range resetters {
+		f.Reset()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveQueue serves /api/queues/{queue}/messages and
+// /api/queues/{queue}/reset.
+func (a *Admin) serveQueue(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/queues/"), "/", 2)
+	q := a.queue(parts[0])
+	if q == nil || len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	switch parts[1] {
+	case "messages":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		msgs, err := peekMessages(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeAdminJSON(w, msgs)
+	case "reset":
+		if allowMethods(w, r, http.MethodPost) {
+			q.Reset()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// peekMessages receives the messages waiting in q without hiding them,
+// until a receive returns none it hasn't seen.
+func peekMessages(q *FakeSQS) ([]*sqs.Message, error) {
+	seen := make(map[string]bool)
+	msgs := []*sqs.Message{}
+	for {
+		out, err := q.Client.ReceiveMessage(&sqs.ReceiveMessageInput{
+			QueueUrl:              &q.URL,
+			MaxNumberOfMessages:   aws.Int64(10),
+			VisibilityTimeout:     aws.Int64(0),
+			AttributeNames:        aws.StringSlice([]string{"All"}),
+			MessageAttributeNames: aws.StringSlice([]string{"All"}),
+		})
+		if err != nil {
+			return nil, err
+		}
+		added := false
+		for _, m := range out.Messages {
+			if id := aws.StringValue(m.MessageId); !seen[id] {
+				seen[id] = true
+				msgs = append(msgs, m)
+				added = true
+			}
+		}
+		if !added {
+			return msgs, nil
+		}
+	}
+}
+
+// serveBucket serves /api/buckets/{bucket}/objects,
+// /api/buckets/{bucket}/objects/{key} and /api/buckets/{bucket}/reset.
+func (a *Admin) serveBucket(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/buckets/"), "/", 3)
+	s := a.bucket(parts[0])
+	if s == nil || len(parts) < 2 {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case parts[1] == "objects" && len(parts) == 2:
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		keys, err := s.listKeys(parts[0], r.URL.Query().Get("prefix"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeAdminJSON(w, append([]string{}, keys...))
+	case parts[1] == "objects":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		body, err := s.GetBytes(parts[0], parts[2])
+		if isNotFound(err) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", contentType(parts[2], body))
+		w.Write(body)
+	case parts[1] == "reset" && len(parts) == 2:
+		if allowMethods(w, r, http.MethodPost) {
+			s.Reset()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveRedis serves /api/redis/keys and /api/redis/reset.
+func (a *Admin) serveRedis(w http.ResponseWriter, r *http.Request) {
+	fake := a.redisFake()
+	if fake == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.URL.Path {
+	case "/api/redis/keys":
+		if !allowMethods(w, r, http.MethodGet) {
+			return
+		}
+		match := r.URL.Query().Get("match")
+		keys, err := fake.Keys(func(k string) bool {
+			ok, _ := path.Match(match, k)
+			return match == "" || ok
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeAdminJSON(w, append([]string{}, keys...))
+	case "/api/redis/reset":
+		if allowMethods(w, r, http.MethodPost) {
+			fake.Reset()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (a *Admin) serveFaults(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPut, http.MethodDelete) {
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		spec, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := a.SetFailureSchedule(string(spec)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		a.SetFailureSchedule("")
+	}
+	writeAdminJSON(w, a.faults())
+}
+
+func (a *Admin) servePartition(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost, http.MethodDelete) {
+		return
+	}
+	if r.Method == http.MethodDelete {
+		a.partition.Heal()
+	} else if s := r.URL.Query().Get("for"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.partition.PartitionFor(d)
+	} else {
+		a.partition.Partition()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowMethods reports whether r uses one of methods, replying with
+// 405 Method Not Allowed if not.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	sort.Strings(methods)
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,216 @@
+package testutil
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// memSQS serves the SQS calls the admin API makes from a single
+// in-memory queue.
+type memSQS struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (s *memSQS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	action := r.Form.Get("Action")
+	fmt.Fprintf(w, "<%[1]sResponse><%[1]sResult>", action)
+	switch action {
+	case "CreateQueue":
+		fmt.Fprintf(w, "<QueueUrl>http://%s/%s</QueueUrl>", r.Host, r.Form.Get("QueueName"))
+	case "SendMessage":
+		body := r.Form.Get("MessageBody")
+		s.msgs = append(s.msgs, body)
+		fmt.Fprintf(w, "<MessageId>m%d</MessageId><MD5OfMessageBody>%x</MD5OfMessageBody>", len(s.msgs), md5.Sum([]byte(body)))
+	case "ReceiveMessage":
+		for i, body := range s.msgs {
+			fmt.Fprintf(w, "<Message><MessageId>m%d</MessageId><ReceiptHandle>rh%d</ReceiptHandle><Body>%s</Body><MD5OfBody>%x</MD5OfBody></Message>",
+				i+1, i+1, body, md5.Sum([]byte(body)))
+		}
+	case "GetQueueAttributes":
+		fmt.Fprintf(w, "<Attribute><Name>ApproximateNumberOfMessages</Name><Value>%d</Value></Attribute>"+
+			"<Attribute><Name>ApproximateNumberOfMessagesNotVisible</Name><Value>0</Value></Attribute>", len(s.msgs))
+	case "PurgeQueue":
+		s.msgs = nil
+	}
+	fmt.Fprintf(w, "</%[1]sResult><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></%[1]sResponse>", action)
+}
+
+// memS3 serves path-style S3 bucket and object calls from memory.
+type memS3 struct {
+	mu      sync.Mutex
+	objects map[string]string
+}
+
+func (s *memS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) == 1 {
+		if r.Method == http.MethodGet {
+			var keys []string
+			for k := range s.objects {
+				if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			fmt.Fprintf(w, "<ListBucketResult><Name>%s</Name><IsTruncated>false</IsTruncated>", parts[0])
+			for _, k := range keys {
+				fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", k)
+			}
+			fmt.Fprint(w, "</ListBucketResult>")
+		}
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		s.objects[parts[1]] = string(body)
+	case http.MethodGet:
+		body, ok := s.objects[parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code><Message>no such key</Message></Error>")
+			return
+		}
+		fmt.Fprint(w, body)
+	case http.MethodDelete:
+		delete(s.objects, parts[1])
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	admin := NewAdmin()
+	defer admin.Close()
+
+	sqsSrv := httptest.NewServer(&memSQS{})
+	defer sqsSrv.Close()
+	q := NewFakeSQS("jobs", WithEndpoint(sqsSrv.URL), WithLogger(t), admin.Option())
+	defer q.Close()
+	s3Srv := httptest.NewServer(&memS3{objects: make(map[string]string)})
+	defer s3Srv.Close()
+	s := NewFakeS3("assets", WithEndpoint(s3Srv.URL), WithLogger(t), admin.Option())
+	defer s.Close()
+
+	q.SendMessage("hello", nil)
+	s.PutString("assets", "a/1.txt", "one")
+	s.PutString("assets", "b/2.txt", "two")
+
+	get := func(path string, v interface{}) {
+		t.Helper()
+		resp, err := http.Get(admin.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: %s", path, resp.Status)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+	do := func(method, path, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, admin.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	var ov AdminOverview
+	get("/api", &ov)
+	if len(ov.Queues) != 1 || ov.Queues[0].Name != "jobs" || ov.Queues[0].Messages != 1 ||
+		len(ov.Buckets) != 1 || ov.Buckets[0] != (AdminBucket{Name: "assets", Objects: 2}) || ov.Redis != nil {
+		t.Errorf("overview = %+v", ov)
+	}
+
+	var msgs []sqs.Message
+	get("/api/queues/jobs/messages", &msgs)
+	if len(msgs) != 1 || aws.StringValue(msgs[0].Body) != "hello" {
+		t.Errorf("messages = %v", msgs)
+	}
+	var keys []string
+	get("/api/buckets/assets/objects?prefix=b/", &keys)
+	if fmt.Sprint(keys) != "[b/2.txt]" {
+		t.Errorf("keys = %q", keys)
+	}
+	resp, err := http.Get(admin.URL + "/api/buckets/assets/objects/a/1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "one" {
+		t.Errorf("object = %q", body)
+	}
+	for path, want := range map[string]int{
+		"/api/buckets/assets/objects/nope": http.StatusNotFound,
+		"/api/queues/other/messages":       http.StatusNotFound,
+		"/api/redis/keys":                  http.StatusNotFound,
+	} {
+		if got := do(http.MethodGet, path, ""); got != want {
+			t.Errorf("GET %s: %d, want %d", path, got, want)
+		}
+	}
+
+	if got := do(http.MethodPut, "/api/faults", "sqs:SendMessage errors InvalidParameterValue"); got != http.StatusOK {
+		t.Fatalf("PUT /api/faults: %d", got)
+	}
+	req, _ := q.Client.SendMessageRequest(&sqs.SendMessageInput{QueueUrl: &q.URL, MessageBody: aws.String("x")})
+	if err := req.Send(); err == nil {
+		t.Error("SendMessage succeeded despite the schedule")
+	}
+	var faults AdminFaults
+	get("/api/faults", &faults)
+	if faults.Schedule != "sqs:SendMessage errors InvalidParameterValue" || len(faults.Fired) == 0 {
+		t.Errorf("faults = %+v", faults)
+	}
+	if got := do(http.MethodPut, "/api/faults", "bogus"); got != http.StatusBadRequest {
+		t.Errorf("PUT /api/faults with a bad schedule: %d", got)
+	}
+	do(http.MethodDelete, "/api/faults", "")
+
+	do(http.MethodPost, "/api/partition", "")
+	if _, err := s.Client.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String("assets")}); err == nil {
+		t.Error("HeadBucket succeeded during the partition")
+	}
+	do(http.MethodDelete, "/api/partition", "")
+
+	if got := do(http.MethodGet, "/api/reset", ""); got != http.StatusMethodNotAllowed {
+		t.Errorf("GET /api/reset: %d", got)
+	}
+	if got := do(http.MethodPost, "/api/reset", ""); got != http.StatusNoContent {
+		t.Errorf("POST /api/reset: %d", got)
+	}
+	get("/api", &ov)
+	if ov.Queues[0].Messages != 0 || ov.Buckets[0].Objects != 0 {
+		t.Errorf("overview after reset = %+v", ov)
+	}
+
+	q.Close()
+	get("/api", &ov)
+	if len(ov.Queues) != 0 {
+		t.Errorf("closed queue still listed: %+v", ov.Queues)
+	}
+}
@@ -0,0 +1,191 @@
+package testutil
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/rainforestapp/testutil/internal/diff"
+)
+
+// Gzip returns b gzip-compressed.
+func Gzip(b []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+// Gunzip returns the decompressed contents of the gzip data b.
+func Gunzip(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// Tar returns a tar archive holding files, which maps file names to
+// contents. Files are added in name order so the archive is
+// reproducible.
+func Tar(files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, name := range sortedNames(files) {
+		w.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(files[name])),
+			Typeflag: tar.TypeReg,
+		})
+		w.Write([]byte(files[name]))
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+// TarGz returns a gzip-compressed tar archive holding files.
+func TarGz(files map[string]string) []byte {
+	return Gzip(Tar(files))
+}
+
+// Zip returns a zip archive holding files, which maps file names to
+// contents. Files are added in name order.
+func Zip(files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range sortedNames(files) {
+		f, _ := w.Create(name)
+		f.Write([]byte(files[name]))
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+// Unpack returns the regular files in archive, which may be a zip,
+// tar or gzip-compressed tar archive, as a map from file names to
+// contents. A gzip stream that doesn't contain a tar archive is
+// returned as a single file named "".
+func Unpack(archive []byte) (map[string]string, error) {
+	switch {
+	case bytes.HasPrefix(archive, []byte("PK\x03\x04")), bytes.HasPrefix(archive, []byte("PK\x05\x06")):
+		return unzip(archive)
+	case bytes.HasPrefix(archive, []byte{0x1f, 0x8b}):
+		b, err := Gunzip(archive)
+		if err != nil {
+			return nil, err
+		}
+		if isTar(b) {
+			return untar(b)
+		}
+		return map[string]string{"": string(b)}, nil
+	case isTar(archive):
+		return untar(archive)
+	}
+	return nil, fmt.Errorf("testutil: unrecognized archive format")
+}
+
+func isTar(b []byte) bool {
+	return len(b) >= 263 && bytes.Equal(b[257:262], []byte("ustar"))
+}
+
+func untar(b []byte) (map[string]string, error) {
+	files := make(map[string]string)
+	r := tar.NewReader(bytes.NewReader(b))
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !h.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		contents, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		files[h.Name] = string(contents)
+	}
+}
+
+func unzip(b []byte) (map[string]string, error) {
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		contents, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[f.Name] = string(contents)
+	}
+	return files, nil
+}
+
+// AssertArchive checks that archive, in any format Unpack understands,
+// holds exactly the files in want.
+func AssertArchive(t TestingT, archive []byte, want map[string]string) {
+	t.Helper()
+	got, err := Unpack(archive)
+	if err != nil {
+		t.Errorf("unpacking archive: %v", err)
+		return
+	}
+	if d := diff.Maps(got, want); d != "" {
+		t.Errorf("archive contents differ (- missing, + unexpected, ~ changed):\n%s", d)
+	}
+}
+
+// PutArchive stores archive as the object bucket/key, with a content
+// type sniffed from its contents.
+func (s *FakeS3) PutArchive(bucket, key string, archive []byte) error {
+	return s.put(bucket, key, archive, contentType(key, archive))
+}
+
+// AssertArchive checks that the object bucket/key is an archive
+// holding exactly the files in want.
+func (s *FakeS3) AssertArchive(t TestingT, bucket, key string, want map[string]string) {
+	t.Helper()
+	b, err := s.GetBytes(bucket, key)
+	if err != nil {
+		t.Errorf("reading s3 object %s/%s: %v", bucket, key, err)
+		return
+	}
+	got, err := Unpack(b)
+	if err != nil {
+		t.Errorf("unpacking s3 object %s/%s: %v", bucket, key, err)
+		return
+	}
+	if d := diff.Maps(got, want); d != "" {
+		t.Errorf("archive %s/%s differs (- missing, + unexpected, ~ changed):\n%s", bucket, key, d)
+	}
+}
+
+func sortedNames(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,50 @@
+package testutil
+
+import (
+	"testing"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	files := map[string]string{
+		"manifest.json":   `{"count":2}`,
+		"data/part-0.csv": "id\n1\n",
+		"data/empty.txt":  "",
+	}
+	for name, archive := range map[string][]byte{
+		"tar":    Tar(files),
+		"tar.gz": TarGz(files),
+		"zip":    Zip(files),
+	} {
+		rt := &recordingT{}
+		AssertArchive(rt, archive, files)
+		if len(rt.errors) != 0 {
+			t.Errorf("%s: unexpected errors %v", name, rt.errors)
+		}
+	}
+}
+
+func TestUnpackGzip(t *testing.T) {
+	got, err := Unpack(Gzip([]byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[""] != "hello" || len(got) != 1 {
+		t.Errorf("Unpack(Gzip) = %q", got)
+	}
+
+	if _, err := Unpack([]byte("plain text")); err == nil {
+		t.Error("expected an error unpacking plain text")
+	}
+}
+
+func TestAssertArchiveReportsDiff(t *testing.T) {
+	rt := &recordingT{}
+	AssertArchive(rt, Zip(map[string]string{"a": "1", "b": "2"}), map[string]string{"a": "1", "c": "3"})
+	if len(rt.errors) != 1 {
+		t.Fatalf("expected one error, got %v", rt.errors)
+	}
+	want := "archive contents differ (- missing, + unexpected, ~ changed):\n+ b: \"2\"\n- c: \"3\""
+	if rt.errors[0] != want {
+		t.Errorf("got error %q, want %q", rt.errors[0], want)
+	}
+}
@@ -0,0 +1,8 @@
+package testutil
+
+// TestingT is the part of testing.TB used by the assertion helpers.
+// *testing.T and *testing.B satisfy it.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
@@ -0,0 +1,89 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// awsError is an error returned by an in-process AWS fake. It is sent
+// to clients in the service's wire format, so the SDK turns it into an
+// awserr.RequestFailure with the same code.
+type awsError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *awsError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newAWSError(status int, code, format string, args ...interface{}) *awsError {
+	return &awsError{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// jsonRPCHandler serves an AWS JSON protocol API such as KMS, where
+// the operation is named by the X-Amz-Target header and requests and
+// responses are JSON objects. Each operation decodes the request body
+// itself and returns a value to encode as the response.
+type jsonRPCHandler struct {
+	targetPrefix string
+	operations   map[string]func(body []byte) (interface{}, error)
+}
+
+func (h *jsonRPCHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	op := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), h.targetPrefix+".")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, newAWSError(http.StatusBadRequest, "SerializationException", "%v", err))
+		return
+	}
+
+	fn, ok := h.operations[op]
+	if !ok {
+		writeJSONError(w, newAWSError(http.StatusBadRequest, "UnknownOperationException", "%s is not supported by the fake", op))
+		return
+	}
+	resp, err := fn(body)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// toAWSError returns err as an awsError. Errors from the SDK, such as
+// those of MemorySQS, keep their code and, if they have one, status.
+func toAWSError(err error) *awsError {
+	switch e := err.(type) {
+	case *awsError:
+		return e
+	case awserr.RequestFailure:
+		return &awsError{Status: e.StatusCode(), Code: e.Code(), Message: e.Message()}
+	case awserr.Error:
+		return &awsError{Status: http.StatusBadRequest, Code: e.Code(), Message: e.Message()}
+	}
+	return newAWSError(http.StatusInternalServerError, "InternalFailure", "%v", err)
+}
+
+func writeJSONError(w http.ResponseWriter, err error) {
+	e := toAWSError(err)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(map[string]string{"__type": e.Code, "message": e.Message})
+}
+
+// decodeJSONRequest decodes body into v, reporting malformed requests
+// the way AWS does.
+func decodeJSONRequest(body []byte, v interface{}) error {
+	if err := json.Unmarshal(body, v); err != nil {
+		return newAWSError(http.StatusBadRequest, "SerializationException", "%v", err)
+	}
+	return nil
+}
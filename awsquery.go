@@ -0,0 +1,150 @@
+package testutil
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// iso8601 is the timestamp format of the AWS query protocol.
+const iso8601 = "2006-01-02T15:04:05Z"
+
+// queryHandler serves an AWS query protocol API such as STS, where the
+// operation is named by the Action form parameter and each response is
+// an XML document wrapping the result in <ActionResult>. Each operation
+// reads the form itself and returns a value to encode as the result,
+// or nil for operations without one. Operations that wait, such as
+// long polls, go in waitOperations instead, and are given a context
+// that is done once the client goes away or stop is closed.
+type queryHandler struct {
+	xmlns          string
+	operations     map[string]func(form url.Values) (interface{}, error)
+	waitOperations map[string]func(ctx context.Context, form url.Values) (interface{}, error)
+	stop           <-chan struct{}
+}
+
+func (h *queryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeQueryError(w, newAWSError(http.StatusBadRequest, "MalformedQueryString", "%v", err))
+		return
+	}
+	action := r.Form.Get("Action")
+	var (
+		result interface{}
+		err    error
+	)
+	if wait, ok := h.waitOperations[action]; ok {
+		result, err = h.wait(r.Context(), wait, r.Form)
+	} else if fn, ok := h.operations[action]; ok {
+		result, err = fn(r.Form)
+	} else {
+		writeQueryError(w, newAWSError(http.StatusBadRequest, "InvalidAction", "%s is not supported by the fake", action))
+		return
+	}
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	enc := xml.NewEncoder(w)
+	start := xml.StartElement{
+		Name: xml.Name{Local: action + "Response"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: h.xmlns}},
+	}
+	enc.EncodeToken(start)
+	if result != nil {
+		enc.EncodeElement(result, xml.StartElement{Name: xml.Name{Local: action + "Result"}})
+	}
+	enc.EncodeElement(struct {
+		RequestID string `xml:"RequestId"`
+	}{randomUUID()}, xml.StartElement{Name: xml.Name{Local: "ResponseMetadata"}})
+	enc.EncodeToken(start.End())
+	enc.Flush()
+}
+
+// wait runs a waiting operation with a context that is done once ctx,
+// the request's, is done or h.stop is closed.
+func (h *queryHandler) wait(ctx context.Context, op func(context.Context, url.Values) (interface{}, error), form url.Values) (interface{}, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-h.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return op(ctx, form)
+}
+
+func writeQueryError(w http.ResponseWriter, err error) {
+	e := toAWSError(err)
+	kind := "Sender"
+	if e.Status >= 500 {
+		kind = "Receiver"
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(e.Status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName   xml.Name `xml:"ErrorResponse"`
+		Type      string   `xml:"Error>Type"`
+		Code      string   `xml:"Error>Code"`
+		Message   string   `xml:"Error>Message"`
+		RequestID string   `xml:"RequestId"`
+	}{Type: kind, Code: e.Code, Message: e.Message, RequestID: randomUUID()})
+}
+
+// formatISO8601 formats t for a query protocol response.
+func formatISO8601(t time.Time) string {
+	return t.UTC().Format(iso8601)
+}
+
+// queryMembers returns the prefixes of the members of the list
+// parameter name in form, such as "Attribute.1." and "Attribute.2.",
+// in order.
+func queryMembers(form url.Values, name string) []string {
+	var ret []string
+	for n := 1; ; n++ {
+		prefix := fmt.Sprintf("%s.%d", name, n)
+		found := false
+		for k := range form {
+			if k == prefix || strings.HasPrefix(k, prefix+".") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ret
+		}
+		ret = append(ret, prefix+".")
+	}
+}
+
+// queryStrings returns the values of the list parameter name in form.
+func queryStrings(form url.Values, name string) []*string {
+	var ret []*string
+	for _, m := range queryMembers(form, name) {
+		ret = append(ret, aws.String(form.Get(strings.TrimSuffix(m, "."))))
+	}
+	return ret
+}
+
+// queryMap returns the map parameter name in form, given as
+// name.N.Name and name.N.Value pairs.
+func queryMap(form url.Values, name string) map[string]*string {
+	var ret map[string]*string
+	for _, m := range queryMembers(form, name) {
+		if ret == nil {
+			ret = make(map[string]*string)
+		}
+		ret[form.Get(m+"Name")] = aws.String(form.Get(m + "Value"))
+	}
+	return ret
+}
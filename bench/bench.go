@@ -0,0 +1,127 @@
+// Package bench runs benchmarks against the testutil fakes, reporting
+// latency percentiles alongside the usual ns/op and allocation figures
+// so data-access layers can be benchmarked locally over realistic
+// protocols.
+package bench
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/garyburd/redigo/redis"
+	"github.com/rainforestapp/testutil"
+)
+
+// Option configures a benchmark run.
+type Option func(*config)
+
+type config struct {
+	warmup      int
+	percentiles []float64
+}
+
+// WithWarmup runs op n times before timing starts, so connection
+// setup and caches in the fakes don't skew the results. The default
+// is 10.
+func WithWarmup(n int) Option {
+	return func(c *config) {
+		c.warmup = n
+	}
+}
+
+// WithPercentiles sets the latency percentiles reported, as numbers
+// between 0 and 100. The default is 50, 90 and 99.
+func WithPercentiles(ps ...float64) Option {
+	return func(c *config) {
+		c.percentiles = ps
+	}
+}
+
+// Run calls op b.N times, failing the benchmark if it returns an
+// error. Besides the standard metrics it reports allocations and the
+// configured latency percentiles, for example as "p99-ns/op".
+func Run(b *testing.B, op func() error, opts ...Option) {
+	b.Helper()
+	c := &config{
+		warmup:      10,
+		percentiles: []float64{50, 90, 99},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	for i := 0; i < c.warmup; i++ {
+		if err := op(); err != nil {
+			b.Fatalf("warm-up failed: %v", err)
+		}
+	}
+
+	latencies := make([]time.Duration, b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		err := op()
+		latencies[i] = time.Since(start)
+		if err != nil {
+			b.Fatalf("operation %d failed: %v", i, err)
+		}
+	}
+	b.StopTimer()
+
+	for _, p := range c.percentiles {
+		b.ReportMetric(float64(Percentile(latencies, p)), percentileUnit(p))
+	}
+}
+
+// Redis benchmarks op against r, giving each call its own pooled
+// connection as a typical caller would.
+func Redis(b *testing.B, r *testutil.FakeRedis, op func(conn redis.Conn) error, opts ...Option) {
+	b.Helper()
+	Run(b, func() error {
+		conn := r.Pool.Get()
+		defer conn.Close()
+		return op(conn)
+	}, opts...)
+}
+
+// SQS benchmarks op against the queue of s.
+func SQS(b *testing.B, s *testutil.FakeSQS, op func(client *sqs.SQS, queueURL string) error, opts ...Option) {
+	b.Helper()
+	Run(b, func() error {
+		return op(s.Client, s.URL)
+	}, opts...)
+}
+
+// S3 benchmarks op against s.
+func S3(b *testing.B, s *testutil.FakeS3, op func(client *s3.S3) error, opts ...Option) {
+	b.Helper()
+	Run(b, func() error {
+		return op(s.Client)
+	}, opts...)
+}
+
+// Percentile returns the p-th percentile (0-100) of latencies using
+// the nearest-rank method. It sorts latencies in place.
+func Percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	rank := int(p/100*float64(len(latencies))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(latencies) {
+		rank = len(latencies) - 1
+	}
+	return latencies[rank]
+}
+
+func percentileUnit(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'g', -1, 64) + "-ns/op"
+}
@@ -0,0 +1,51 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/rainforestapp/testutil"
+)
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	cases := map[float64]time.Duration{
+		0:   1 * time.Millisecond,
+		50:  50 * time.Millisecond,
+		99:  99 * time.Millisecond,
+		100: 100 * time.Millisecond,
+	}
+	for p, want := range cases {
+		if got := Percentile(latencies, p); got != want {
+			t.Errorf("Percentile(%v) = %v, want %v", p, got, want)
+		}
+	}
+	if got := Percentile(nil, 50); got != 0 {
+		t.Errorf("Percentile of no latencies = %v, want 0", got)
+	}
+}
+
+func TestPercentileUnit(t *testing.T) {
+	if got := percentileUnit(99.9); got != "p99.9-ns/op" {
+		t.Errorf("percentileUnit(99.9) = %q", got)
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	Run(b, func() error { return nil })
+}
+
+func BenchmarkRedisSet(b *testing.B) {
+	r := testutil.NewFakeRedis(testutil.WithLogger(b))
+	defer r.Close()
+
+	Redis(b, r, func(conn redis.Conn) error {
+		_, err := conn.Do("SET", "key", "value")
+		return err
+	}, WithWarmup(100))
+}
@@ -0,0 +1,239 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/rainforestapp/testutil"
+)
+
+// LoadGen produces sustained traffic against the fakes at configured
+// rates, for soak-style integration tests of consumers. Add streams of
+// operations, Start it, exercise the code under test, and Stop it to
+// get a summary.
+type LoadGen struct {
+	// MaxInFlight is the most operations a single stream runs at once.
+	// When a stream's operations fall behind, ticks that would exceed
+	// it are counted as skipped instead of queueing up. It defaults to
+	// 16.
+	MaxInFlight int
+
+	streams []*stream
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	started time.Time
+}
+
+type stream struct {
+	name string
+	rate float64
+	op   func(i int) error
+
+	mu        sync.Mutex
+	sent      int
+	errors    int
+	skipped   int
+	lastErr   error
+	latencies []time.Duration
+}
+
+// NewLoadGen returns a LoadGen with no streams.
+func NewLoadGen() *LoadGen {
+	return &LoadGen{MaxInFlight: 16}
+}
+
+// Add adds a stream that calls op perSecond times a second. op is
+// passed a sequence number starting at 0. It returns g to allow
+// chaining.
+func (g *LoadGen) Add(name string, perSecond float64, op func(i int) error) *LoadGen {
+	g.streams = append(g.streams, &stream{name: name, rate: perSecond, op: op})
+	return g
+}
+
+// SQS adds a stream sending perSecond messages a second to q, with
+// bodies produced by body.
+func (g *LoadGen) SQS(q *testutil.FakeSQS, perSecond float64, body func(i int) string) *LoadGen {
+	return g.Add("sqs:"+q.URL, perSecond, func(i int) error {
+		b := body(i)
+		_, err := q.Client.SendMessage(&sqs.SendMessageInput{
+			QueueUrl:    &q.URL,
+			MessageBody: &b,
+		})
+		return err
+	})
+}
+
+// S3 adds a stream writing perSecond objects a second to bucket, with
+// keys and contents produced by object.
+func (g *LoadGen) S3(s *testutil.FakeS3, bucket string, perSecond float64, object func(i int) (key string, body []byte)) *LoadGen {
+	return g.Add("s3:"+bucket, perSecond, func(i int) error {
+		key, body := object(i)
+		_, err := s.Client.PutObject(&s3.PutObjectInput{
+			Bucket: &bucket,
+			Key:    &key,
+			Body:   bytes.NewReader(body),
+		})
+		return err
+	})
+}
+
+// Redis adds a stream running perSecond commands a second against r,
+// with commands produced by command.
+func (g *LoadGen) Redis(r *testutil.FakeRedis, perSecond float64, command func(i int) (cmd string, args []interface{})) *LoadGen {
+	return g.Add("redis", perSecond, func(i int) error {
+		conn := r.Pool.Get()
+		defer conn.Close()
+		cmd, args := command(i)
+		_, err := conn.Do(cmd, args...)
+		return err
+	})
+}
+
+// Start starts generating traffic in the background.
+func (g *LoadGen) Start() {
+	g.stop = make(chan struct{})
+	g.started = time.Now()
+	for _, s := range g.streams {
+		g.wg.Add(1)
+		go g.run(s)
+	}
+}
+
+// Stop stops generating traffic, waits for in-flight operations to
+// finish and returns a summary of the run.
+func (g *LoadGen) Stop() Summary {
+	close(g.stop)
+	g.wg.Wait()
+	return g.summary(time.Since(g.started))
+}
+
+// Run generates traffic for d and returns a summary of the run.
+func (g *LoadGen) Run(d time.Duration) Summary {
+	g.Start()
+	time.Sleep(d)
+	return g.Stop()
+}
+
+func (g *LoadGen) run(s *stream) {
+	defer g.wg.Done()
+	if s.rate <= 0 {
+		return
+	}
+
+	maxInFlight := g.MaxInFlight
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+	inFlight := make(chan struct{}, maxInFlight)
+	var ops sync.WaitGroup
+	defer ops.Wait()
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / s.rate))
+	defer ticker.Stop()
+	for i := 0; ; {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+		}
+
+		select {
+		case inFlight <- struct{}{}:
+		default:
+			s.mu.Lock()
+			s.skipped++
+			s.mu.Unlock()
+			continue
+		}
+
+		ops.Add(1)
+		go func(i int) {
+			defer ops.Done()
+			defer func() { <-inFlight }()
+			start := time.Now()
+			err := s.op(i)
+			latency := time.Since(start)
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.sent++
+			s.latencies = append(s.latencies, latency)
+			if err != nil {
+				s.errors++
+				s.lastErr = err
+			}
+		}(i)
+		i++
+	}
+}
+
+func (g *LoadGen) summary(d time.Duration) Summary {
+	sum := Summary{Duration: d}
+	for _, s := range g.streams {
+		s.mu.Lock()
+		st := StreamStats{
+			Name:       s.name,
+			TargetRate: s.rate,
+			Sent:       s.sent,
+			Errors:     s.errors,
+			Skipped:    s.skipped,
+			LastError:  s.lastErr,
+			P50:        Percentile(s.latencies, 50),
+			P99:        Percentile(s.latencies, 99),
+		}
+		s.mu.Unlock()
+		if d > 0 {
+			st.Rate = float64(st.Sent) / d.Seconds()
+		}
+		sum.Streams = append(sum.Streams, st)
+	}
+	return sum
+}
+
+// Summary describes a LoadGen run.
+type Summary struct {
+	Duration time.Duration
+	Streams  []StreamStats
+}
+
+// StreamStats describes the traffic of one LoadGen stream.
+type StreamStats struct {
+	Name string
+
+	// TargetRate is the configured rate and Rate the achieved one, in
+	// operations per second.
+	TargetRate float64
+	Rate       float64
+
+	// Sent is the number of operations run, of which Errors failed.
+	// Skipped is the number of ticks dropped because MaxInFlight
+	// operations were already running.
+	Sent    int
+	Errors  int
+	Skipped int
+
+	LastError error
+
+	// P50 and P99 are operation latency percentiles.
+	P50 time.Duration
+	P99 time.Duration
+}
+
+// String formats the summary as a table, one line per stream.
+func (s Summary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "load generated for %v\n", s.Duration.Round(time.Millisecond))
+	for _, st := range s.Streams {
+		fmt.Fprintf(&b, "%-30s %8.1f/s (target %.1f/s) sent=%d errors=%d skipped=%d p50=%v p99=%v\n",
+			st.Name, st.Rate, st.TargetRate, st.Sent, st.Errors, st.Skipped, st.P50, st.P99)
+		if st.LastError != nil {
+			fmt.Fprintf(&b, "%-30s last error: %v\n", "", st.LastError)
+		}
+	}
+	return b.String()
+}
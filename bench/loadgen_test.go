@@ -0,0 +1,55 @@
+package bench
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadGen(t *testing.T) {
+	var calls int32
+	g := NewLoadGen().
+		Add("ok", 200, func(i int) error {
+			atomic.AddInt32(&calls, 1)
+			return nil
+		}).
+		Add("failing", 100, func(i int) error {
+			return errors.New("boom")
+		})
+
+	sum := g.Run(200 * time.Millisecond)
+	if len(sum.Streams) != 2 {
+		t.Fatalf("got %d streams, want 2", len(sum.Streams))
+	}
+
+	ok := sum.Streams[0]
+	if ok.Sent == 0 || ok.Sent != int(atomic.LoadInt32(&calls)) {
+		t.Errorf("ok stream sent %d operations, op called %d times", ok.Sent, calls)
+	}
+	if ok.Errors != 0 {
+		t.Errorf("ok stream had %d errors", ok.Errors)
+	}
+
+	failing := sum.Streams[1]
+	if failing.Sent == 0 || failing.Errors != failing.Sent {
+		t.Errorf("failing stream: sent=%d errors=%d, want all failed", failing.Sent, failing.Errors)
+	}
+	if !strings.Contains(sum.String(), "last error: boom") {
+		t.Errorf("summary doesn't mention the error:\n%s", sum)
+	}
+}
+
+func TestLoadGenSkipsWhenSaturated(t *testing.T) {
+	g := NewLoadGen().Add("slow", 500, func(i int) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	g.MaxInFlight = 1
+
+	sum := g.Run(150 * time.Millisecond)
+	if sum.Streams[0].Skipped == 0 {
+		t.Errorf("expected ticks to be skipped, got %+v", sum.Streams[0])
+	}
+}
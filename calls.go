@@ -0,0 +1,264 @@
+package testutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// CallRecorder records the AWS API calls made through the fakes it is
+// attached to with WithCallRecorder, so tests can check how code talks
+// to AWS, not just what it ends up doing: for example that a batch
+// code path made one PutObject call instead of a hundred. Requests the
+// fakes make while they are being set up are not recorded.
+//
+// Operations are named as in Policy, for example "s3:PutObject", and
+// may be given as "s3:*" or "*" wherever an operation is expected.
+type CallRecorder struct {
+	mu    sync.Mutex
+	calls []APICall
+}
+
+// APICall is an AWS API call recorded by a CallRecorder.
+type APICall struct {
+	// Service and Operation name the call, for example "s3" and
+	// "PutObject".
+	Service   string
+	Operation string
+
+	// Resource is the resource the call acted on, named as in Policy.
+	Resource string
+
+	// ParamsDigest is a digest of the call's parameters. Calls with the
+	// same digest sent the same parameters.
+	ParamsDigest string
+
+	// Retries is how many times the SDK retried the call.
+	Retries int
+
+	// Duration is how long the call took, including retries.
+	Duration time.Duration
+
+	// Err is the error code the call finally failed with, or "" if it
+	// succeeded or hasn't finished.
+	Err string
+}
+
+// Name returns the call's service-qualified operation name, such as
+// "s3:PutObject".
+func (c APICall) Name() string {
+	return c.Service + ":" + c.Operation
+}
+
+// NewCallRecorder returns an empty CallRecorder.
+func NewCallRecorder() *CallRecorder {
+	return new(CallRecorder)
+}
+
+// WithCallRecorder attaches rec to an AWS fake.
+func WithCallRecorder(rec *CallRecorder) Option {
+	return func(o *options) {
+		o.signHooks = append(o.signHooks, rec.signHook)
+	}
+}
+
+// signHook records the first attempt of each request and arranges for
+// its outcome to be recorded. Requests are signed again before each
+// retry, so later attempts are skipped.
+func (rec *CallRecorder) signHook(r *request.Request) {
+	if r.RetryCount > 0 {
+		return
+	}
+	rec.mu.Lock()
+	i := len(rec.calls)
+	rec.calls = append(rec.calls, APICall{
+		Service:      r.ClientInfo.ServiceName,
+		Operation:    r.Operation.Name,
+		Resource:     resourceName(r),
+		ParamsDigest: paramsDigest(r.Params),
+	})
+	rec.mu.Unlock()
+
+	onFinish(r, func(r *request.Request, d time.Duration) {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		if i >= len(rec.calls) {
+			return // Reset since the call started
+		}
+		rec.calls[i].Retries = r.RetryCount
+		rec.calls[i].Duration = d
+		rec.calls[i].Err = errorCode(r.Error)
+	})
+}
+
+// onFinish arranges for fn to be called with r and the time since
+// onFinish was called once r has succeeded or finally failed. It is
+// meant to be called from a Sign handler on the first attempt.
+func onFinish(r *request.Request, fn func(*request.Request, time.Duration)) {
+	start := time.Now()
+	// AfterRetry runs after every failed attempt, and leaves an error
+	// only once the SDK gives up. Unmarshal runs after the successful
+	// attempt.
+	r.Handlers.AfterRetry.PushBack(func(r *request.Request) {
+		if r.Error != nil {
+			fn(r, time.Since(start))
+		}
+	})
+	r.Handlers.Unmarshal.PushBack(func(r *request.Request) {
+		fn(r, time.Since(start))
+	})
+}
+
+// errorCode returns the AWS error code of err, its message if it has
+// none, or "" if err is nil.
+func errorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return err.Error()
+}
+
+func paramsDigest(params interface{}) string {
+	b, err := json.Marshal(params)
+	if err != nil {
+		b = []byte(fmt.Sprintf("%#v", params))
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Calls returns the recorded calls, oldest first.
+func (rec *CallRecorder) Calls() []APICall {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]APICall(nil), rec.calls...)
+}
+
+// Count returns how many calls to operation were recorded.
+func (rec *CallRecorder) Count(operation string) int {
+	n := 0
+	for _, c := range rec.Calls() {
+		if matchOperation(operation, c.Name()) {
+			n++
+		}
+	}
+	return n
+}
+
+// Retries returns how many times the SDK retried calls to operation in
+// total.
+func (rec *CallRecorder) Retries(operation string) int {
+	n := 0
+	for _, c := range rec.Calls() {
+		if matchOperation(operation, c.Name()) {
+			n += c.Retries
+		}
+	}
+	return n
+}
+
+// RetriesByOperation returns the total retries of each operation that
+// was retried at least once.
+func (rec *CallRecorder) RetriesByOperation() map[string]int {
+	retries := make(map[string]int)
+	for _, c := range rec.Calls() {
+		if c.Retries > 0 {
+			retries[c.Name()] += c.Retries
+		}
+	}
+	return retries
+}
+
+// Reset forgets every recorded call.
+func (rec *CallRecorder) Reset() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.calls = nil
+}
+
+// AssertAPICall checks that operation was called exactly times times.
+func (rec *CallRecorder) AssertAPICall(t TestingT, operation string, times int) {
+	t.Helper()
+	if n := rec.Count(operation); n != times {
+		t.Errorf("%s was called %d times, want %d; calls made:\n%s", operation, n, times, rec.summary())
+	}
+}
+
+// AssertRetried checks that calls to operation were retried between
+// atLeast and atMost times in total. A negative atMost means there is
+// no upper limit.
+func (rec *CallRecorder) AssertRetried(t TestingT, operation string, atLeast, atMost int) {
+	t.Helper()
+	n := rec.Retries(operation)
+	if n >= atLeast && (atMost < 0 || n <= atMost) {
+		return
+	}
+	want := fmt.Sprintf("between %d and %d", atLeast, atMost)
+	switch {
+	case atMost < 0:
+		want = fmt.Sprintf("at least %d", atLeast)
+	case atLeast == atMost:
+		want = fmt.Sprint(atLeast)
+	}
+	var perCall []string
+	for _, c := range rec.Calls() {
+		if matchOperation(operation, c.Name()) {
+			perCall = append(perCall, fmt.Sprintf("  %s: %d retries%s", c.Name(), c.Retries, errSuffix(c.Err)))
+		}
+	}
+	if len(perCall) == 0 {
+		perCall = []string{"  (no calls)"}
+	}
+	t.Errorf("%s was retried %d times, want %s; calls:\n%s", operation, n, want, strings.Join(perCall, "\n"))
+}
+
+func errSuffix(code string) string {
+	if code == "" {
+		return ""
+	}
+	return ", failed with " + code
+}
+
+// summary lists the number of calls to each operation, in the order
+// they were first made.
+func (rec *CallRecorder) summary() string {
+	var names []string
+	counts := make(map[string]int)
+	for _, c := range rec.Calls() {
+		if counts[c.Name()] == 0 {
+			names = append(names, c.Name())
+		}
+		counts[c.Name()]++
+	}
+	if len(names) == 0 {
+		return "  (none)"
+	}
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = fmt.Sprintf("  %s x%d", name, counts[name])
+	}
+	return strings.Join(lines, "\n")
+}
+
+// matchOperation reports whether name matches pattern, which is an
+// operation name, "service:*" or "*".
+func matchOperation(pattern, name string) bool {
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasSuffix(pattern, ":*"):
+		return strings.HasPrefix(name, strings.TrimSuffix(pattern, "*"))
+	default:
+		return pattern == name
+	}
+}
@@ -0,0 +1,69 @@
+package testutil
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+func TestCallRecorder(t *testing.T) {
+	rec := NewCallRecorder()
+	th := NewThrottle().Limit("kms:Decrypt", 0, 1)
+	k := NewFakeKMS(WithCallRecorder(rec), WithThrottle(th))
+	defer k.Close()
+	key := k.CreateKey("")
+
+	var blobs [][]byte
+	for _, s := range []string{"a", "a", "b"} {
+		out, err := k.Client.Encrypt(&kms.EncryptInput{KeyId: aws.String(key), Plaintext: []byte(s)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		blobs = append(blobs, out.CiphertextBlob)
+	}
+	k.Client.Decrypt(&kms.DecryptInput{CiphertextBlob: blobs[0]})
+	req, _ := k.Client.DecryptRequest(&kms.DecryptInput{CiphertextBlob: blobs[1]})
+	req.Retryer = client.DefaultRetryer{NumMaxRetries: 1}
+	req.Send()
+
+	rec.AssertAPICall(t, "kms:Encrypt", 3)
+	rec.AssertAPICall(t, "kms:*", 5)
+	calls := rec.Calls()
+	if calls[0].ParamsDigest != calls[1].ParamsDigest || calls[0].ParamsDigest == calls[2].ParamsDigest {
+		t.Errorf("digests %s %s %s: want the first two equal and the third different",
+			calls[0].ParamsDigest, calls[1].ParamsDigest, calls[2].ParamsDigest)
+	}
+	if calls[0].Resource != key {
+		t.Errorf("Resource = %q, want %q", calls[0].Resource, key)
+	}
+	if c := calls[3]; c.Retries != 0 || c.Err != "" {
+		t.Errorf("first Decrypt: %d retries, error %q", c.Retries, c.Err)
+	}
+	if c := calls[4]; c.Retries != 1 || c.Err != "ThrottlingException" {
+		t.Errorf("throttled Decrypt: %d retries, error %q; want 1 and ThrottlingException", c.Retries, c.Err)
+	}
+
+	ft := &recordingT{}
+	rec.AssertAPICall(ft, "kms:Encrypt", 1)
+	if len(ft.errors) != 1 || !strings.Contains(ft.errors[0], "kms:Encrypt x3") {
+		t.Errorf("errors = %q, want one listing the calls made", ft.errors)
+	}
+
+	rec.AssertRetried(t, "kms:Decrypt", 1, 1)
+	rec.AssertRetried(t, "kms:*", 1, -1)
+	if got := rec.RetriesByOperation(); len(got) != 1 || got["kms:Decrypt"] != 1 {
+		t.Errorf("RetriesByOperation = %v", got)
+	}
+	ft = &recordingT{}
+	rec.AssertRetried(ft, "kms:Decrypt", 3, 5)
+	rec.AssertRetried(ft, "kms:Encrypt", 0, 0)
+	if len(ft.errors) != 1 || !strings.HasPrefix(ft.errors[0], "kms:Decrypt was retried 1 times, want between 3 and 5; calls:\n  kms:Decrypt: 0 retries\n  kms:Decrypt: 1 retries, failed with ThrottlingException") {
+		t.Errorf("errors = %q", ft.errors)
+	}
+
+	rec.Reset()
+	rec.AssertAPICall(t, "*", 0)
+}
@@ -0,0 +1,189 @@
+package testutil
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// Output is the output CaptureOutput captured.
+type Output struct {
+	// Stdout and Stderr are what was written to each stream. They are
+	// empty with WithCombinedOutput.
+	Stdout string
+	Stderr string
+
+	// Combined is both streams interleaved in the order they were
+	// written. It is only set with WithCombinedOutput.
+	Combined string
+}
+
+// CaptureStdOut takes a function that prints to os.Stdout and returns the
+// output as a string. If any error occurs when the given function is called,
+// an empty string and the error is returned to the caller of CaptureStdOut.
+func CaptureStdout(printFunction func() error, opts ...Option) (string, error) {
+	out, err := captureStrings(printFunction, newOptions(opts), &os.Stdout)
+	if err != nil {
+		return "", err
+	}
+	return out[0], nil
+}
+
+// CaptureStderr is like CaptureStdout, but for a function that prints to
+// os.Stderr.
+func CaptureStderr(printFunction func() error, opts ...Option) (string, error) {
+	out, err := captureStrings(printFunction, newOptions(opts), &os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return out[0], nil
+}
+
+// CaptureOutput is like CaptureStdout, but captures both os.Stdout and
+// os.Stderr, separately or, with WithCombinedOutput, interleaved.
+func CaptureOutput(printFunction func() error, opts ...Option) (Output, error) {
+	o := newOptions(opts)
+	if o.combinedOutput {
+		out, err := captureStrings(printFunction, o, &os.Stdout, &os.Stderr)
+		if err != nil {
+			return Output{}, err
+		}
+		return Output{Combined: out[0]}, nil
+	}
+	var stderr string
+	// Each stream is captured on its own pipe, nested so that both are
+	// restored however fn returns.
+	out, err := captureStrings(func() error {
+		out, err := captureStrings(printFunction, o, &os.Stderr)
+		if err == nil {
+			stderr = out[0]
+		}
+		return err
+	}, &options{}, &os.Stdout)
+	if err != nil {
+		return Output{}, err
+	}
+	return Output{Stdout: out[0], Stderr: stderr}, nil
+}
+
+// CaptureStdoutTo is like CaptureStdout, but copies the output to w as
+// the function prints it, rather than collecting it in memory.
+func CaptureStdoutTo(w io.Writer, printFunction func() error, opts ...Option) error {
+	return capture(printFunction, newOptions(opts), []io.Writer{w}, &os.Stdout)
+}
+
+// StreamStdout runs printFunction in a goroutine, capturing what it
+// prints to os.Stdout, and sends the output on the returned line channel
+// a line at a time as it is printed, without the newline, so a test can
+// check output while the function is still running. The function's
+// error, or a capture error, is then sent on the error channel, and both
+// channels are closed. Lines must be received until the channel closes,
+// or the function blocks once the pipe fills. Like the other capture
+// functions, it captures everything printed to os.Stdout while the
+// function runs, from any goroutine.
+func StreamStdout(printFunction func() error, opts ...Option) (<-chan string, <-chan error) {
+	lines := make(chan string)
+	errs := make(chan error, 1)
+	r, w := io.Pipe()
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		defer close(lines)
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadString('\n')
+			if line != "" {
+				lines <- strings.TrimSuffix(line, "\n")
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer close(errs)
+		err := CaptureStdoutTo(w, printFunction, opts...)
+		w.Close()
+		<-read
+		errs <- err
+	}()
+	return lines, errs
+}
+
+// captureStrings is capture into strings.
+func captureStrings(fn func() error, o *options, streams ...**os.File) ([]string, error) {
+	npipes := len(streams)
+	if o.combinedOutput {
+		npipes = 1
+	}
+	bufs := make([]bytes.Buffer, npipes)
+	sinks := make([]io.Writer, npipes)
+	for i := range bufs {
+		sinks[i] = &bufs[i]
+	}
+	if err := capture(fn, o, sinks, streams...); err != nil {
+		return nil, err
+	}
+	out := make([]string, npipes)
+	for i := range bufs {
+		out[i] = bufs[i].String()
+	}
+	return out, nil
+}
+
+// capture runs fn with each of streams pointed at a pipe of its own, or
+// all at one pipe with WithCombinedOutput, and copies what is written
+// to each pipe to its sink as fn runs, so fn may write more than a pipe
+// holds. With WithLogOutput the standard logger writes to the last pipe
+// too.
+func capture(fn func() error, o *options, sinks []io.Writer, streams ...**os.File) error {
+	npipes := len(sinks)
+	writers := make([]*os.File, npipes)
+	copied := make(chan error, npipes)
+	for i := range writers {
+		r, w, err := os.Pipe()
+		if err != nil {
+			for _, w := range writers[:i] {
+				w.Close()
+			}
+			return err
+		}
+		writers[i] = w
+		go func(sink io.Writer) {
+			_, err := io.Copy(sink, r)
+			r.Close()
+			copied <- err
+		}(sinks[i])
+	}
+
+	originals := make([]*os.File, len(streams))
+	for i, s := range streams {
+		originals[i] = *s
+		*s = writers[i%npipes]
+	}
+	logWriter := log.Writer()
+	if o.logOutput {
+		log.SetOutput(writers[npipes-1])
+	}
+	err := func() error {
+		defer func() {
+			for i, s := range streams {
+				*s = originals[i]
+			}
+			log.SetOutput(logWriter)
+			for _, w := range writers {
+				w.Close()
+			}
+		}()
+		return fn()
+	}()
+	for range writers {
+		if cerr := <-copied; err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
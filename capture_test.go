@@ -0,0 +1,127 @@
+package testutil
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func ExampleCaptureOutput() {
+	out, _ := CaptureOutput(func() error {
+		fmt.Println("to stdout")
+		fmt.Fprintln(os.Stderr, "to stderr")
+		return nil
+	})
+	fmt.Printf("%q %q\n", out.Stdout, out.Stderr)
+	// Output:
+	// "to stdout\n" "to stderr\n"
+}
+
+func TestCaptureStderr(t *testing.T) {
+	out, err := CaptureStderr(func() error {
+		fmt.Fprint(os.Stderr, "oops")
+		return nil
+	})
+	if err != nil || out != "oops" {
+		t.Errorf("CaptureStderr = %q, %v", out, err)
+	}
+
+	want := errors.New("failed")
+	out, err = CaptureStderr(func() error { return want })
+	if err != want || out != "" {
+		t.Errorf("CaptureStderr with an error = %q, %v", out, err)
+	}
+}
+
+func TestCaptureCombinedOutput(t *testing.T) {
+	out, err := CaptureOutput(func() error {
+		fmt.Print("1 ")
+		fmt.Fprint(os.Stderr, "2 ")
+		fmt.Print("3")
+		return nil
+	}, WithCombinedOutput())
+	if err != nil || out != (Output{Combined: "1 2 3"}) {
+		t.Errorf("CaptureOutput = %+v, %v", out, err)
+	}
+}
+
+func TestCaptureLogOutput(t *testing.T) {
+	flags := log.Flags()
+	log.SetFlags(0)
+	defer log.SetFlags(flags)
+	logf := func() error {
+		log.Print("logged")
+		return nil
+	}
+
+	if out, _ := CaptureStderr(logf, WithLogOutput()); out != "logged\n" {
+		t.Errorf("CaptureStderr with log output = %q", out)
+	}
+	if out, _ := CaptureOutput(logf, WithLogOutput()); out.Stderr != "logged\n" || out.Stdout != "" {
+		t.Errorf("CaptureOutput with log output = %+v", out)
+	}
+	if out, _ := CaptureStdout(logf, WithLogOutput()); out != "logged\n" {
+		t.Errorf("CaptureStdout with log output = %q", out)
+	}
+	// Without the option, the log package still writes to the real
+	// stderr.
+	var out string
+	captured, _ := CaptureStderr(func() error {
+		out, _ = CaptureStderr(logf)
+		return nil
+	}, WithLogOutput())
+	if out != "" || captured != "logged\n" {
+		t.Errorf("captured %q without WithLogOutput, %q with it", out, captured)
+	}
+}
+
+func TestCaptureLargeOutput(t *testing.T) {
+	big := strings.Repeat("x", 1<<20)
+	out, err := CaptureStdout(func() error {
+		fmt.Print(big)
+		return nil
+	})
+	if err != nil || out != big {
+		t.Errorf("captured %d bytes, %v; want %d", len(out), err, len(big))
+	}
+}
+
+func TestCaptureStdoutTo(t *testing.T) {
+	var buf strings.Builder
+	err := CaptureStdoutTo(&buf, func() error {
+		fmt.Print("hello")
+		return nil
+	})
+	if err != nil || buf.String() != "hello" {
+		t.Errorf("CaptureStdoutTo = %q, %v", buf.String(), err)
+	}
+}
+
+func TestStreamStdout(t *testing.T) {
+	next := make(chan struct{})
+	lines, errs := StreamStdout(func() error {
+		fmt.Println("first")
+		// The test sees the first line before the second is printed.
+		<-next
+		fmt.Print("second\nno newline")
+		return errors.New("done")
+	})
+
+	if line := <-lines; line != "first" {
+		t.Errorf("first line = %q", line)
+	}
+	close(next)
+	var rest []string
+	for line := range lines {
+		rest = append(rest, line)
+	}
+	if fmt.Sprint(rest) != "[second no newline]" {
+		t.Errorf("remaining lines = %q", rest)
+	}
+	if err := <-errs; err == nil || err.Error() != "done" {
+		t.Errorf("error = %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package testutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/garyburd/redigo/redis"
+)
+
+// ChaosProfile sets how often EnableChaos injects each kind of fault.
+// Rates are fractions of calls, from 0 to 1.
+type ChaosProfile struct {
+	// ErrorRate is the fraction of calls that fail with a retryable
+	// server error: 500 InternalError for S3, 503 ServiceUnavailable
+	// for other AWS services and an ERR reply for redis.
+	ErrorRate float64
+
+	// DropRate is the fraction of calls whose connection is dropped
+	// before a response arrives.
+	DropRate float64
+
+	// LatencyRate is the fraction of calls delayed by up to
+	// MaxLatency.
+	LatencyRate float64
+	MaxLatency  time.Duration
+
+	// Operations restricts faults to these operations, named as in
+	// Policy ("s3:PutObject", "sqs:*") or as "redis:GET". All
+	// operations are affected if it is empty.
+	Operations []string
+}
+
+// Chaos profiles for common uses.
+var (
+	// ChaosMild injects occasional faults that retries should hide.
+	ChaosMild = ChaosProfile{ErrorRate: 0.02, DropRate: 0.01, LatencyRate: 0.05, MaxLatency: 50 * time.Millisecond}
+
+	// ChaosHarsh injects faults often enough to exhaust retries now
+	// and then.
+	ChaosHarsh = ChaosProfile{ErrorRate: 0.2, DropRate: 0.1, LatencyRate: 0.2, MaxLatency: 200 * time.Millisecond}
+)
+
+// Chaos is a chaos run started by EnableChaos.
+type Chaos struct {
+	seed    int64
+	profile ChaosProfile
+
+	mu     sync.Mutex
+	counts map[string]uint64
+	faults []ChaosFault
+}
+
+// ChaosFault is a fault injected by a Chaos run.
+type ChaosFault struct {
+	// Operation is the operation affected, such as "sqs:SendMessage"
+	// or "redis:GET".
+	Operation string
+
+	// Call is which call to Operation was affected, counting from 1.
+	Call uint64
+
+	// Kind is "error", "drop" or "latency".
+	Kind string
+
+	// Latency is the delay added by a latency fault.
+	Latency time.Duration
+}
+
+func (f ChaosFault) String() string {
+	if f.Kind == "latency" {
+		return fmt.Sprintf("%s call %d: %v latency", f.Operation, f.Call, f.Latency)
+	}
+	return fmt.Sprintf("%s call %d: %s", f.Operation, f.Call, f.Kind)
+}
+
+// activeChaos holds the current *Chaos, or a nil one.
+var activeChaos atomic.Value
+
+// errChaosDrop is the cause of the errors of dropped calls.
+var errChaosDrop = errors.New("connection dropped by testutil")
+
+// EnableChaos starts injecting faults into every call made through the
+// fakes, including fakes created before it was called, until Disable
+// is called. Requests the fakes make while being set up are spared.
+//
+// Whether a call is affected depends only on the seed, its operation
+// and how many calls to that operation came before it, so a failure
+// can be replayed by running the same tests with the same seed, even
+// if operations run concurrently. A seed of 0 means the value of
+// $TESTUTIL_CHAOS_SEED, or a random seed if that isn't set; log Seed
+// so that failures in a nightly job can be replayed.
+func EnableChaos(seed int64, profile ChaosProfile) *Chaos {
+	if seed == 0 {
+		seed, _ = strconv.ParseInt(os.Getenv("TESTUTIL_CHAOS_SEED"), 10, 64)
+	}
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	c := &Chaos{seed: seed, profile: profile, counts: make(map[string]uint64)}
+	activeChaos.Store(c)
+	return c
+}
+
+// Seed returns the seed the run uses.
+func (c *Chaos) Seed() int64 {
+	return c.seed
+}
+
+// Faults returns the faults injected so far, in the order they were
+// injected.
+func (c *Chaos) Faults() []ChaosFault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]ChaosFault(nil), c.faults...)
+}
+
+// Disable stops the run, if it is still the active one.
+func (c *Chaos) Disable() {
+	if current() == c {
+		activeChaos.Store((*Chaos)(nil))
+	}
+}
+
+func (c *Chaos) String() string {
+	return fmt.Sprintf("chaos seed %d: %d faults injected", c.seed, len(c.Faults()))
+}
+
+func current() *Chaos {
+	c, _ := activeChaos.Load().(*Chaos)
+	return c
+}
+
+// decide returns the fault, if any, to inject into the next call to
+// operation.
+func (c *Chaos) decide(operation string) (ChaosFault, bool) {
+	if len(c.profile.Operations) > 0 {
+		matched := false
+		for _, pattern := range c.profile.Operations {
+			if matchOperation(pattern, operation) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return ChaosFault{}, false
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[operation]++
+	n := c.counts[operation]
+	h := fnv.New64a()
+	h.Write([]byte(operation))
+	x := splitmix64(uint64(c.seed) ^ h.Sum64() ^ splitmix64(n))
+
+	// One draw picks the kind of fault, another the latency.
+	roll := float64(x>>11) / (1 << 53)
+	f := ChaosFault{Operation: operation, Call: n}
+	p := c.profile
+	switch {
+	case roll < p.ErrorRate:
+		f.Kind = "error"
+	case roll < p.ErrorRate+p.DropRate:
+		f.Kind = "drop"
+	case roll < p.ErrorRate+p.DropRate+p.LatencyRate && p.MaxLatency > 0:
+		f.Kind = "latency"
+		f.Latency = time.Duration(splitmix64(x) % uint64(p.MaxLatency))
+	default:
+		return ChaosFault{}, false
+	}
+	c.faults = append(c.faults, f)
+	return f, true
+}
+
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// chaosSendHook injects the active run's faults into AWS requests.
+// Every fake installs it, so that chaos can be enabled at any time.
+func chaosSendHook(r *request.Request) *http.Response {
+	c := current()
+	if c == nil {
+		return nil
+	}
+	f, ok := c.decide(operationName(r))
+	if !ok {
+		return nil
+	}
+	switch f.Kind {
+	case "error":
+		return serverErrorResponse(r)
+	case "drop":
+		dropRequest(r, errChaosDrop)
+	case "latency":
+		time.Sleep(f.Latency)
+	}
+	return nil
+}
+
+// serverErrorResponse returns the retryable error r's service returns
+// when it fails internally.
+func serverErrorResponse(r *request.Request) *http.Response {
+	if r.ClientInfo.ServiceName == "s3" {
+		return errorResponse(r, http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again.")
+	}
+	return errorResponse(r, http.StatusServiceUnavailable, "ServiceUnavailable", "Service is unavailable")
+}
+
+// dropRequest fails r with cause as if its connection was dropped, the
+// way the SDK's send handler reports network errors.
+func dropRequest(r *request.Request, cause error) {
+	// The SDK's retry rules expect a response, so it sets an empty one.
+	r.HTTPResponse = &http.Response{Body: ioutil.NopCloser(bytes.NewReader(nil))}
+	r.Error = awserr.New("RequestError", "send request failed", cause)
+	r.Retryable = aws.Bool(true)
+}
+
+// chaosRedis injects the active run's faults into a redis command. It
+// returns the error to fail the command with, if any.
+func chaosRedis(conn redis.Conn, cmd string) error {
+	c := current()
+	if c == nil {
+		return nil
+	}
+	f, ok := c.decide("redis:" + strings.ToUpper(cmd))
+	if !ok {
+		return nil
+	}
+	switch f.Kind {
+	case "error":
+		return redis.Error("ERR injected failure (testutil chaos)")
+	case "drop":
+		conn.Close()
+		return errChaosDrop
+	case "latency":
+		time.Sleep(f.Latency)
+	}
+	return nil
+}
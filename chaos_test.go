@@ -0,0 +1,85 @@
+package testutil
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestEnableChaosReplays(t *testing.T) {
+	k := NewFakeKMS()
+	defer k.Close()
+	key := k.CreateKey("")
+
+	run := func(seed int64) ([]string, []ChaosFault) {
+		c := EnableChaos(seed, ChaosProfile{ErrorRate: 0.3, DropRate: 0.2, Operations: []string{"kms:Encrypt"}})
+		defer c.Disable()
+		var outcomes []string
+		for i := 0; i < 30; i++ {
+			req, _ := k.Client.EncryptRequest(&kms.EncryptInput{KeyId: aws.String(key), Plaintext: []byte("x")})
+			req.Retryer = client.DefaultRetryer{NumMaxRetries: 0}
+			outcomes = append(outcomes, errorCode(req.Send()))
+		}
+		// Decrypt isn't in Operations.
+		k.Client.Decrypt(&kms.DecryptInput{CiphertextBlob: []byte("garbage")})
+		return outcomes, c.Faults()
+	}
+
+	first, faults := run(42)
+	again, faultsAgain := run(42)
+	if !reflect.DeepEqual(first, again) || !reflect.DeepEqual(faults, faultsAgain) {
+		t.Errorf("seed 42 gave different runs:\n%q\n%q", first, again)
+	}
+	counts := make(map[string]int)
+	for _, o := range first {
+		counts[o]++
+	}
+	if counts[""] == 0 || counts["ServiceUnavailable"] == 0 || counts["RequestError"] == 0 {
+		t.Errorf("outcomes = %v, want successes, errors and drops", counts)
+	}
+	if len(faults) != counts["ServiceUnavailable"]+counts["RequestError"] {
+		t.Errorf("%d faults recorded for outcomes %v", len(faults), counts)
+	}
+	for _, f := range faults {
+		if f.Operation != "kms:Encrypt" {
+			t.Errorf("fault in %s", f)
+		}
+	}
+	if other, _ := run(43); reflect.DeepEqual(first, other) {
+		t.Error("seeds 42 and 43 gave the same run")
+	}
+
+	if _, err := k.Client.Encrypt(&kms.EncryptInput{KeyId: aws.String(key), Plaintext: []byte("x")}); err != nil {
+		t.Errorf("request after Disable: %v", err)
+	}
+}
+
+func TestEnableChaosRedis(t *testing.T) {
+	c := EnableChaos(1, ChaosProfile{ErrorRate: 1, Operations: []string{"redis:GET"}})
+	defer c.Disable()
+	r := newStubRedis()
+	defer r.Close()
+	conn := r.Pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("get", "a"); err == nil {
+		t.Error("GET succeeded")
+	} else if _, ok := err.(redis.Error); !ok {
+		t.Errorf("GET failed with %T %v, want a redis.Error", err, err)
+	}
+	if _, err := conn.Do("SET", "a", "1"); err != nil {
+		t.Errorf("SET: %v", err)
+	}
+}
+
+func TestEnableChaosSeedFromEnv(t *testing.T) {
+	t.Setenv("TESTUTIL_CHAOS_SEED", "1234")
+	c := EnableChaos(0, ChaosMild)
+	defer c.Disable()
+	if c.Seed() != 1234 {
+		t.Errorf("Seed = %d, want 1234", c.Seed())
+	}
+}
@@ -0,0 +1,64 @@
+package testutil
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time. Code under test that takes a Clock instead of
+// calling time.Now directly can be driven by a FakeClock.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is a Clock that returns the real time.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FakeClock is a Clock whose time only changes when told to. It is
+// safe for concurrent use.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the clock's current time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set sets the clock to now.
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// WithClock makes the in-process fakes, such as FakeCognito, read the
+// time from clock, so that token expiry and timestamps can be driven
+// by a FakeClock. It does not affect request signing; see
+// WithSigningClock.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
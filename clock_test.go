@@ -0,0 +1,22 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2016, 7, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+	if !c.Now().Equal(start) {
+		t.Errorf("Now() = %v, want %v", c.Now(), start)
+	}
+	c.Advance(time.Hour)
+	if want := start.Add(time.Hour); !c.Now().Equal(want) {
+		t.Errorf("after Advance, Now() = %v, want %v", c.Now(), want)
+	}
+	c.Set(start)
+	if !c.Now().Equal(start) {
+		t.Errorf("after Set, Now() = %v, want %v", c.Now(), start)
+	}
+}
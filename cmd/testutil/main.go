@@ -0,0 +1,189 @@
+// Command testutil runs the testutil fakes outside of Go tests, for
+// manual testing and for the test suites of services written in other
+// languages.
+//
+// Usage:
+//
+//	testutil serve [-env] [fake ...]
+//
+// serve starts the named in-process fakes, or all of them, prints their
+// endpoints and serves until interrupted. With -env it prints them as
+// shell exports instead, along with the credentials and region the AWS
+// fakes expect, for example to be sourced by a test script:
+//
+//	testutil serve -env kms sts > fakes.env &
+//
+// s3 and sqs are served from memory, as by WithInProcess, with a bucket
+// and a queue named testutil; more can be created through their APIs.
+// redis wraps an external server and can't be served; run
+// redis-server directly instead.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/rainforestapp/testutil"
+)
+
+// servedName is the name of the bucket and queue the s3 and sqs fakes
+// start with.
+const servedName = "testutil"
+
+// A fake is an in-process fake serve can start.
+type fake struct {
+	name string
+	// start starts the fake, returning its endpoint, its AWS config if
+	// it is an AWS fake, and a function that stops it.
+	start func() (endpoint string, cfg *aws.Config, stop func())
+}
+
+var fakes = []fake{
+	{"cognito", func() (string, *aws.Config, func()) {
+		f := testutil.NewFakeCognito()
+		return f.URL, f.Config, f.Close
+	}},
+	{"dns", func() (string, *aws.Config, func()) {
+		f := testutil.NewFakeDNS()
+		return f.Addr, nil, f.Close
+	}},
+	{"eventbridge", func() (string, *aws.Config, func()) {
+		f := testutil.NewFakeEventBridge()
+		return f.URL, f.Config, f.Close
+	}},
+	{"kms", func() (string, *aws.Config, func()) {
+		f := testutil.NewFakeKMS()
+		return f.URL, f.Config, f.Close
+	}},
+	{"s3", func() (string, *aws.Config, func()) {
+		f := testutil.NewFakeS3(servedName, testutil.WithInProcess())
+		return f.Endpoint, f.Config, f.Close
+	}},
+	{"sns", func() (string, *aws.Config, func()) {
+		f := testutil.NewFakeSNS()
+		return f.URL, f.Config, f.Close
+	}},
+	{"sqs", func() (string, *aws.Config, func()) {
+		f := testutil.NewFakeSQS(servedName, testutil.WithInProcess())
+		return f.Endpoint, f.Config, f.Close
+	}},
+	{"sts", func() (string, *aws.Config, func()) {
+		f := testutil.NewFakeSTS()
+		return f.URL, f.Config, f.Close
+	}},
+	{"time", func() (string, *aws.Config, func()) {
+		f := testutil.NewFakeTimeServer(testutil.SystemClock)
+		return f.URL, nil, f.Close
+	}},
+}
+
+// external are the fakes that wrap a server run outside the test
+// process, and that server.
+var external = map[string]string{
+	"redis": "redis-server",
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "serve" {
+		fmt.Fprintln(os.Stderr, "usage: testutil serve [-env] [fake ...]")
+		os.Exit(2)
+	}
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	if err := serve(os.Args[2:], os.Stdout, sigc); err != nil {
+		fmt.Fprintf(os.Stderr, "testutil serve: %v\n", err)
+		os.Exit(2)
+	}
+}
+
+// serve runs the serve subcommand with args, writing endpoints to out,
+// until stop receives.
+func serve(args []string, out io.Writer, stop <-chan os.Signal) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	env := flags.Bool("env", false, "print endpoints as shell exports")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: testutil serve [-env] [fake ...]\n\nfakes: %s\n", strings.Join(fakeNames(), ", "))
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	selected, err := selectFakes(flags.Args())
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	var awsConfig *aws.Config
+	for _, f := range selected {
+		endpoint, cfg, stopFake := f.start()
+		defer stopFake()
+		if cfg != nil && awsConfig == nil {
+			awsConfig = cfg
+		}
+		if *env {
+			fmt.Fprintf(w, "export TESTUTIL_%s_ENDPOINT=%s\n", strings.ToUpper(f.name), endpoint)
+		} else {
+			fmt.Fprintf(w, "%s\t%s\n", f.name, endpoint)
+		}
+	}
+	if *env && awsConfig != nil {
+		creds, err := awsConfig.Credentials.Get()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "export AWS_ACCESS_KEY_ID=%s\n", creds.AccessKeyID)
+		fmt.Fprintf(w, "export AWS_SECRET_ACCESS_KEY=%s\n", creds.SecretAccessKey)
+		fmt.Fprintf(w, "export AWS_REGION=%s\n", aws.StringValue(awsConfig.Region))
+	}
+	w.Flush()
+
+	<-stop
+	return nil
+}
+
+// selectFakes returns the fakes called names, in order, or every fake
+// if there are none.
+func selectFakes(names []string) ([]fake, error) {
+	if len(names) == 0 {
+		return fakes, nil
+	}
+	var ret []fake
+	for _, name := range names {
+		f, ok := findFake(name)
+		if !ok {
+			if server, ok := external[name]; ok {
+				return nil, fmt.Errorf("%s isn't an in-process fake; run %s instead", name, server)
+			}
+			return nil, fmt.Errorf("unknown fake %q (known: %s)", name, strings.Join(fakeNames(), ", "))
+		}
+		ret = append(ret, f)
+	}
+	return ret, nil
+}
+
+func findFake(name string) (fake, bool) {
+	for _, f := range fakes {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return fake{}, false
+}
+
+func fakeNames() []string {
+	names := make([]string, len(fakes))
+	for i, f := range fakes {
+		names[i] = f.name
+	}
+	sort.Strings(names)
+	return names
+}
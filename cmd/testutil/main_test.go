@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// syncBuffer is a bytes.Buffer safe to write and read concurrently.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestServe(t *testing.T) {
+	out := &syncBuffer{}
+	stop := make(chan os.Signal)
+	done := make(chan error)
+	go func() { done <- serve([]string{"time", "kms"}, out, stop) }()
+
+	var lines []string
+	for deadline := time.Now().Add(5 * time.Second); len(lines) < 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("output %q, want two endpoints", out.String())
+		}
+		lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	}
+	re := regexp.MustCompile(`^(time|kms) +(http://127\.0\.0\.1:\d+)$`)
+	for i, name := range []string{"time", "kms"} {
+		m := re.FindStringSubmatch(lines[i])
+		if m == nil || m[1] != name {
+			t.Fatalf("line %d = %q, want the %s endpoint", i, lines[i], name)
+		}
+		if i == 0 {
+			resp, err := http.Get(m[2])
+			if err != nil {
+				t.Fatalf("GET time server: %v", err)
+			}
+			resp.Body.Close()
+		}
+	}
+
+	stop <- os.Interrupt
+	if err := <-done; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+}
+
+func TestServeEnv(t *testing.T) {
+	out := &syncBuffer{}
+	stop := make(chan os.Signal, 1)
+	stop <- os.Interrupt
+	if err := serve([]string{"-env", "sts"}, out, stop); err != nil {
+		t.Fatal(err)
+	}
+	want := regexp.MustCompile(`^export TESTUTIL_STS_ENDPOINT=http://127\.0\.0\.1:\d+
+export AWS_ACCESS_KEY_ID=\w+
+export AWS_SECRET_ACCESS_KEY=\w+
+export AWS_REGION=us-east-1
+$`)
+	if !want.MatchString(out.String()) {
+		t.Errorf("output:\n%s", out)
+	}
+}
+
+func TestServeStorage(t *testing.T) {
+	out := &syncBuffer{}
+	stop := make(chan os.Signal)
+	done := make(chan error)
+	go func() { done <- serve([]string{"-env", "s3", "sqs", "sns"}, out, stop) }()
+
+	env := map[string]string{}
+	re := regexp.MustCompile(`(?m)^export (\w+)=(.*)$`)
+	for deadline := time.Now().Add(5 * time.Second); len(env) < 6; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("output %q, want three endpoints and the AWS settings", out.String())
+		}
+		for _, m := range re.FindAllStringSubmatch(out.String(), -1) {
+			env[m[1]] = m[2]
+		}
+	}
+	cfg := func(endpoint string) *aws.Config {
+		return &aws.Config{
+			Endpoint:         aws.String(env[endpoint]),
+			Region:           aws.String(env["AWS_REGION"]),
+			Credentials:      credentials.NewStaticCredentials(env["AWS_ACCESS_KEY_ID"], env["AWS_SECRET_ACCESS_KEY"], ""),
+			S3ForcePathStyle: aws.Bool(true),
+		}
+	}
+	sess := session.New()
+	if _, err := s3.New(sess, cfg("TESTUTIL_S3_ENDPOINT")).HeadBucket(&s3.HeadBucketInput{Bucket: aws.String("testutil")}); err != nil {
+		t.Errorf("HeadBucket: %v", err)
+	}
+	if _, err := sqs.New(sess, cfg("TESTUTIL_SQS_ENDPOINT")).GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: aws.String("testutil")}); err != nil {
+		t.Errorf("GetQueueUrl: %v", err)
+	}
+	if _, err := sns.New(sess, cfg("TESTUTIL_SNS_ENDPOINT")).CreateTopic(&sns.CreateTopicInput{Name: aws.String("testutil")}); err != nil {
+		t.Errorf("CreateTopic: %v", err)
+	}
+
+	stop <- os.Interrupt
+	if err := <-done; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+}
+
+func TestServeUnknown(t *testing.T) {
+	for args, want := range map[string]string{
+		"redis":  "redis isn't an in-process fake; run redis-server instead",
+		"nosuch": `unknown fake "nosuch" (known: cognito, dns, eventbridge, kms, s3, sns, sqs, sts, time)`,
+	} {
+		if err := serve([]string{args}, &syncBuffer{}, nil); err == nil || err.Error() != want {
+			t.Errorf("serve(%s) = %v, want %s", args, err, want)
+		}
+	}
+}
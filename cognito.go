@@ -0,0 +1,590 @@
+package testutil
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+)
+
+const (
+	cognitoTokenTTL          = time.Hour
+	cognitoTemporaryPassword = "TempPassw0rd!"
+	cognitoMinPasswordLength = 8
+)
+
+// Cognito user statuses.
+const (
+	CognitoUnconfirmed         = "UNCONFIRMED"
+	CognitoConfirmed           = "CONFIRMED"
+	CognitoForceChangePassword = "FORCE_CHANGE_PASSWORD"
+)
+
+// FakeCognito is an in-process fake of a Cognito user pool with a
+// single app client. It supports sign-up and confirmation,
+// AdminCreateUser with the NEW_PASSWORD_REQUIRED challenge, password
+// and refresh token auth through InitiateAuth and AdminInitiateAuth,
+// and issues RS256 ID and access tokens shaped like Cognito's. The
+// tokens verify against the key set served at JWKSURL, so
+// authentication middleware can be tested offline.
+type FakeCognito struct {
+	// Client is a Cognito Identity Provider client set up for the
+	// fake.
+	Client *cognitoidentityprovider.CognitoIdentityProvider
+
+	// Session is an AWS Session that uses the fake config.
+	Session *session.Session
+
+	// Config is the AWS config the Session was built from.
+	Config *aws.Config
+
+	// URL is the fake's endpoint.
+	URL string
+
+	// UserPoolID and ClientID identify the pool and its app client.
+	UserPoolID string
+	ClientID   string
+
+	// Issuer is the iss claim of issued tokens, and JWKSURL the URL of
+	// the key set that verifies them. The pool's OpenID configuration
+	// is served at Issuer + "/.well-known/openid-configuration".
+	Issuer  string
+	JWKSURL string
+
+	clock      Clock
+	signer     *jwtSigner
+	srv        *httptest.Server
+	unregister func()
+
+	mu            sync.Mutex
+	users         map[string]*cognitoUser
+	refreshTokens map[string]string
+	sessions      map[string]string
+	codes         int
+}
+
+type cognitoUser struct {
+	username   string
+	password   string
+	status     string
+	enabled    bool
+	attributes map[string]string
+	code       string
+	created    time.Time
+	modified   time.Time
+}
+
+// CognitoTokens are the tokens issued on a successful sign-in.
+type CognitoTokens struct {
+	IDToken      string
+	AccessToken  string
+	RefreshToken string
+}
+
+// NewFakeCognito starts a FakeCognito with an empty user pool.
+func NewFakeCognito(opts ...Option) *FakeCognito {
+	o := newOptions(opts)
+	region := o.region
+	if region == "" {
+		region = defaultRegion
+	}
+	c := &FakeCognito{
+		UserPoolID:    region + "_testpool",
+		ClientID:      "testutilclient",
+		clock:         o.clock,
+		users:         make(map[string]*cognitoUser),
+		refreshTokens: make(map[string]string),
+		sessions:      make(map[string]string),
+	}
+	signer, err := newJWTSigner("testutil-cognito")
+	if err != nil {
+		o.logger.Fatalf("Error generating Cognito signing key: %v", err)
+	}
+	c.signer = signer
+
+	mux := http.NewServeMux()
+	mux.Handle("/", &jsonRPCHandler{
+		targetPrefix: "AWSCognitoIdentityProviderService",
+		operations: map[string]func([]byte) (interface{}, error){
+			"SignUp":                      c.signUp,
+			"ConfirmSignUp":               c.confirmSignUp,
+			"AdminConfirmSignUp":          c.adminConfirmSignUp,
+			"AdminCreateUser":             c.adminCreateUser,
+			"AdminGetUser":                c.adminGetUser,
+			"AdminDeleteUser":             c.adminDeleteUser,
+			"AdminDisableUser":            c.adminSetEnabled(false),
+			"AdminEnableUser":             c.adminSetEnabled(true),
+			"InitiateAuth":                c.initiateAuth,
+			"AdminInitiateAuth":           c.initiateAuth,
+			"RespondToAuthChallenge":      c.respondToAuthChallenge,
+			"AdminRespondToAuthChallenge": c.respondToAuthChallenge,
+		},
+	})
+	c.srv = httptest.NewServer(mux)
+	c.URL = c.srv.URL
+	c.Issuer = c.URL + "/" + c.UserPoolID
+	c.JWKSURL = c.Issuer + "/.well-known/jwks.json"
+	mux.HandleFunc("/"+c.UserPoolID+"/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.signer.jwks())
+	})
+	mux.HandleFunc("/"+c.UserPoolID+"/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                c.Issuer,
+			"jwks_uri":                              c.JWKSURL,
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"subject_types_supported":               []string{"public"},
+			"response_types_supported":              []string{"code", "token"},
+		})
+	})
+
+	c.Config = fakeAWSConfig(c.URL, o)
+	c.Session = session.New(c.Config)
+	c.Client = cognitoidentityprovider.New(c.Session)
+	installHooks(o, &c.Session.Handlers, &c.Client.Handlers)
+	c.unregister = OnInterrupt(c.Close)
+	return c
+}
+
+// Close stops the fake.
+func (c *FakeCognito) Close() {
+	if c.unregister != nil {
+		c.unregister()
+	}
+	c.srv.Close()
+}
+
+// AddUser adds a confirmed user with the given password and attributes,
+// such as "email", for tests that only need someone to sign in as.
+func (c *FakeCognito) AddUser(username, password string, attributes map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u := c.newUser(username, attributes)
+	u.password = password
+	u.status = CognitoConfirmed
+}
+
+// ConfirmationCode returns the code sent to username on sign-up, for
+// passing to ConfirmSignUp.
+func (c *FakeCognito) ConfirmationCode(username string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if u, ok := c.users[username]; ok {
+		return u.code
+	}
+	return ""
+}
+
+// UserStatus returns username's status, such as CognitoConfirmed, or
+// "" if there is no such user.
+func (c *FakeCognito) UserStatus(username string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if u, ok := c.users[username]; ok {
+		return u.status
+	}
+	return ""
+}
+
+// IssueTokens signs username in without a password and returns their
+// tokens, for tests of code that only consumes tokens.
+func (c *FakeCognito) IssueTokens(username string) (*CognitoTokens, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, ok := c.users[username]
+	if !ok {
+		return nil, fmt.Errorf("testutil: no Cognito user %q", username)
+	}
+	return c.issue(u, true)
+}
+
+// newUser adds a user. c.mu must be held.
+func (c *FakeCognito) newUser(username string, attributes map[string]string) *cognitoUser {
+	now := c.clock.Now()
+	attrs := map[string]string{"sub": randomUUID()}
+	for k, v := range attributes {
+		attrs[k] = v
+	}
+	c.codes++
+	u := &cognitoUser{
+		username:   username,
+		enabled:    true,
+		attributes: attrs,
+		code:       fmt.Sprintf("%06d", c.codes),
+		created:    now,
+		modified:   now,
+	}
+	c.users[username] = u
+	return u
+}
+
+// issue returns fresh tokens for u, with a refresh token if refresh is
+// set. c.mu must be held.
+func (c *FakeCognito) issue(u *cognitoUser, refresh bool) (*CognitoTokens, error) {
+	now := c.clock.Now()
+	common := map[string]interface{}{
+		"sub":       u.attributes["sub"],
+		"iss":       c.Issuer,
+		"iat":       now.Unix(),
+		"exp":       now.Add(cognitoTokenTTL).Unix(),
+		"auth_time": now.Unix(),
+	}
+
+	id := map[string]interface{}{
+		"aud":              c.ClientID,
+		"token_use":        "id",
+		"cognito:username": u.username,
+	}
+	for k, v := range u.attributes {
+		id[k] = v
+	}
+	access := map[string]interface{}{
+		"client_id": c.ClientID,
+		"token_use": "access",
+		"scope":     "aws.cognito.signin.user.admin",
+		"username":  u.username,
+		"jti":       randomUUID(),
+	}
+	for k, v := range common {
+		id[k] = v
+		access[k] = v
+	}
+
+	var tokens CognitoTokens
+	var err error
+	if tokens.IDToken, err = c.signer.sign(id); err != nil {
+		return nil, err
+	}
+	if tokens.AccessToken, err = c.signer.sign(access); err != nil {
+		return nil, err
+	}
+	if refresh {
+		tokens.RefreshToken = randomToken()
+		c.refreshTokens[tokens.RefreshToken] = u.username
+	}
+	return &tokens, nil
+}
+
+type cognitoAttribute struct {
+	Name  string
+	Value string
+}
+
+type cognitoRequest struct {
+	UserPoolID         string `json:"UserPoolId"`
+	ClientID           string `json:"ClientId"`
+	Username           string
+	Password           string
+	ConfirmationCode   string
+	TemporaryPassword  string
+	UserAttributes     []cognitoAttribute
+	AuthFlow           string
+	AuthParameters     map[string]string
+	ChallengeName      string
+	ChallengeResponses map[string]string
+	Session            string
+}
+
+func (c *FakeCognito) decode(body []byte) (*cognitoRequest, error) {
+	var req cognitoRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	if req.ClientID != "" && req.ClientID != c.ClientID {
+		return nil, cognitoError("ResourceNotFoundException", "User pool client %s does not exist.", req.ClientID)
+	}
+	if req.UserPoolID != "" && req.UserPoolID != c.UserPoolID {
+		return nil, cognitoError("ResourceNotFoundException", "User pool %s does not exist.", req.UserPoolID)
+	}
+	return &req, nil
+}
+
+// user returns the user named in req. c.mu must be held.
+func (c *FakeCognito) user(username string) (*cognitoUser, error) {
+	u, ok := c.users[username]
+	if !ok {
+		return nil, cognitoError("UserNotFoundException", "User does not exist.")
+	}
+	return u, nil
+}
+
+func (c *FakeCognito) signUp(body []byte) (interface{}, error) {
+	req, err := c.decode(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Password) < cognitoMinPasswordLength {
+		return nil, cognitoError("InvalidPasswordException", "Password did not conform with policy: Password not long enough")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.users[req.Username]; ok {
+		return nil, cognitoError("UsernameExistsException", "User already exists")
+	}
+	u := c.newUser(req.Username, attributeMap(req.UserAttributes))
+	u.password = req.Password
+	u.status = CognitoUnconfirmed
+	return map[string]interface{}{
+		"UserConfirmed": false,
+		"UserSub":       u.attributes["sub"],
+		"CodeDeliveryDetails": map[string]string{
+			"AttributeName":  "email",
+			"DeliveryMedium": "EMAIL",
+			"Destination":    u.attributes["email"],
+		},
+	}, nil
+}
+
+func (c *FakeCognito) confirmSignUp(body []byte) (interface{}, error) {
+	req, err := c.decode(body)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, err := c.user(req.Username)
+	if err != nil {
+		return nil, err
+	}
+	if req.ConfirmationCode != u.code {
+		return nil, cognitoError("CodeMismatchException", "Invalid verification code provided, please try again.")
+	}
+	u.status = CognitoConfirmed
+	u.modified = c.clock.Now()
+	return struct{}{}, nil
+}
+
+func (c *FakeCognito) adminConfirmSignUp(body []byte) (interface{}, error) {
+	req, err := c.decode(body)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, err := c.user(req.Username)
+	if err != nil {
+		return nil, err
+	}
+	u.status = CognitoConfirmed
+	u.modified = c.clock.Now()
+	return struct{}{}, nil
+}
+
+func (c *FakeCognito) adminCreateUser(body []byte) (interface{}, error) {
+	req, err := c.decode(body)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.users[req.Username]; ok {
+		return nil, cognitoError("UsernameExistsException", "User account already exists")
+	}
+	u := c.newUser(req.Username, attributeMap(req.UserAttributes))
+	u.password = req.TemporaryPassword
+	if u.password == "" {
+		u.password = cognitoTemporaryPassword
+	}
+	u.status = CognitoForceChangePassword
+	return map[string]interface{}{"User": userType(u)}, nil
+}
+
+func (c *FakeCognito) adminGetUser(body []byte) (interface{}, error) {
+	req, err := c.decode(body)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, err := c.user(req.Username)
+	if err != nil {
+		return nil, err
+	}
+	resp := userType(u)
+	resp["UserAttributes"] = resp["Attributes"]
+	delete(resp, "Attributes")
+	return resp, nil
+}
+
+func (c *FakeCognito) adminDeleteUser(body []byte) (interface{}, error) {
+	req, err := c.decode(body)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.user(req.Username); err != nil {
+		return nil, err
+	}
+	delete(c.users, req.Username)
+	return struct{}{}, nil
+}
+
+func (c *FakeCognito) adminSetEnabled(enabled bool) func([]byte) (interface{}, error) {
+	return func(body []byte) (interface{}, error) {
+		req, err := c.decode(body)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		u, err := c.user(req.Username)
+		if err != nil {
+			return nil, err
+		}
+		u.enabled = enabled
+		return struct{}{}, nil
+	}
+}
+
+func (c *FakeCognito) initiateAuth(body []byte) (interface{}, error) {
+	req, err := c.decode(body)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch req.AuthFlow {
+	case "USER_PASSWORD_AUTH", "ADMIN_NO_SRP_AUTH", "ADMIN_USER_PASSWORD_AUTH":
+		u, err := c.user(req.AuthParameters["USERNAME"])
+		if err != nil {
+			return nil, err
+		}
+		if u.password != req.AuthParameters["PASSWORD"] {
+			return nil, cognitoError("NotAuthorizedException", "Incorrect username or password.")
+		}
+		if !u.enabled {
+			return nil, cognitoError("NotAuthorizedException", "User is disabled.")
+		}
+		switch u.status {
+		case CognitoUnconfirmed:
+			return nil, cognitoError("UserNotConfirmedException", "User is not confirmed.")
+		case CognitoForceChangePassword:
+			session := randomToken()
+			c.sessions[session] = u.username
+			attrs, _ := json.Marshal(u.attributes)
+			return map[string]interface{}{
+				"ChallengeName": "NEW_PASSWORD_REQUIRED",
+				"Session":       session,
+				"ChallengeParameters": map[string]string{
+					"USER_ID_FOR_SRP":    u.username,
+					"requiredAttributes": "[]",
+					"userAttributes":     string(attrs),
+				},
+			}, nil
+		}
+		return c.authResult(u, true)
+
+	case "REFRESH_TOKEN_AUTH", "REFRESH_TOKEN":
+		username, ok := c.refreshTokens[req.AuthParameters["REFRESH_TOKEN"]]
+		if !ok {
+			return nil, cognitoError("NotAuthorizedException", "Invalid Refresh Token")
+		}
+		u, err := c.user(username)
+		if err != nil {
+			return nil, err
+		}
+		return c.authResult(u, false)
+	}
+	return nil, cognitoError("InvalidParameterException", "Unsupported auth flow %s", req.AuthFlow)
+}
+
+func (c *FakeCognito) respondToAuthChallenge(body []byte) (interface{}, error) {
+	req, err := c.decode(body)
+	if err != nil {
+		return nil, err
+	}
+	if req.ChallengeName != "NEW_PASSWORD_REQUIRED" {
+		return nil, cognitoError("InvalidParameterException", "Unsupported challenge %s", req.ChallengeName)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	username, ok := c.sessions[req.Session]
+	if !ok || username != req.ChallengeResponses["USERNAME"] {
+		return nil, cognitoError("NotAuthorizedException", "Invalid session for the user.")
+	}
+	u, err := c.user(username)
+	if err != nil {
+		return nil, err
+	}
+	password := req.ChallengeResponses["NEW_PASSWORD"]
+	if len(password) < cognitoMinPasswordLength {
+		return nil, cognitoError("InvalidPasswordException", "Password did not conform with policy: Password not long enough")
+	}
+	delete(c.sessions, req.Session)
+	u.password = password
+	u.status = CognitoConfirmed
+	u.modified = c.clock.Now()
+	return c.authResult(u, true)
+}
+
+// authResult issues tokens for u in an InitiateAuth response. c.mu
+// must be held.
+func (c *FakeCognito) authResult(u *cognitoUser, refresh bool) (interface{}, error) {
+	tokens, err := c.issue(u, refresh)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{
+		"AccessToken": tokens.AccessToken,
+		"IdToken":     tokens.IDToken,
+		"ExpiresIn":   int64(cognitoTokenTTL / time.Second),
+		"TokenType":   "Bearer",
+	}
+	if tokens.RefreshToken != "" {
+		result["RefreshToken"] = tokens.RefreshToken
+	}
+	return map[string]interface{}{
+		"AuthenticationResult": result,
+		"ChallengeParameters":  map[string]string{},
+	}, nil
+}
+
+func userType(u *cognitoUser) map[string]interface{} {
+	attrs := make([]cognitoAttribute, 0, len(u.attributes))
+	for k, v := range u.attributes {
+		attrs = append(attrs, cognitoAttribute{k, v})
+	}
+	return map[string]interface{}{
+		"Username":             u.username,
+		"Attributes":           attrs,
+		"UserStatus":           u.status,
+		"Enabled":              u.enabled,
+		"UserCreateDate":       u.created.Unix(),
+		"UserLastModifiedDate": u.modified.Unix(),
+	}
+}
+
+func attributeMap(attrs []cognitoAttribute) map[string]string {
+	m := make(map[string]string, len(attrs))
+	for _, a := range attrs {
+		m[a.Name] = a.Value
+	}
+	return m
+}
+
+func cognitoError(code, format string, args ...interface{}) error {
+	return newAWSError(http.StatusBadRequest, code, format, args...)
+}
+
+func randomUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return formatUUID(b)
+}
+
+func randomToken() string {
+	var b [32]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,189 @@
+package testutil
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	cip "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+)
+
+// verifyJWT checks token's signature against the key set at jwksURL
+// and returns its claims.
+func verifyJWT(t *testing.T, token, jwksURL string) map[string]interface{} {
+	t.Helper()
+	resp, err := http.Get(jwksURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var jwks struct {
+		Keys []struct{ N, E string }
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil || len(jwks.Keys) != 1 {
+		t.Fatalf("decoding JWKS: %v %v", jwks, err)
+	}
+	n, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].N)
+	e, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].E)
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed token %q", token)
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig); err != nil {
+		t.Fatalf("token does not verify: %v", err)
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims map[string]interface{}
+	json.Unmarshal(payload, &claims)
+	return claims
+}
+
+func TestFakeCognitoSignUp(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1500000000, 0))
+	c := NewFakeCognito(WithClock(clock))
+	defer c.Close()
+
+	_, err := c.Client.SignUp(&cip.SignUpInput{
+		ClientId:       aws.String(c.ClientID),
+		Username:       aws.String("ada"),
+		Password:       aws.String("correct horse"),
+		UserAttributes: []*cip.AttributeType{{Name: aws.String("email"), Value: aws.String("ada@example.com")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := &cip.InitiateAuthInput{
+		ClientId: aws.String(c.ClientID),
+		AuthFlow: aws.String("USER_PASSWORD_AUTH"),
+		AuthParameters: map[string]*string{
+			"USERNAME": aws.String("ada"),
+			"PASSWORD": aws.String("correct horse"),
+		},
+	}
+	_, err = c.Client.InitiateAuth(auth)
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "UserNotConfirmedException" {
+		t.Errorf("expected UserNotConfirmedException, got %v", err)
+	}
+
+	_, err = c.Client.ConfirmSignUp(&cip.ConfirmSignUpInput{
+		ClientId:         aws.String(c.ClientID),
+		Username:         aws.String("ada"),
+		ConfirmationCode: aws.String(c.ConfirmationCode("ada")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := c.Client.InitiateAuth(auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := verifyJWT(t, aws.StringValue(out.AuthenticationResult.IdToken), c.JWKSURL)
+	if claims["email"] != "ada@example.com" || claims["iss"] != c.Issuer || claims["aud"] != c.ClientID {
+		t.Errorf("unexpected ID token claims %v", claims)
+	}
+	if exp := claims["exp"].(float64); int64(exp) != clock.Now().Add(time.Hour).Unix() {
+		t.Errorf("exp = %v, want an hour from the fake clock", exp)
+	}
+	access := verifyJWT(t, aws.StringValue(out.AuthenticationResult.AccessToken), c.JWKSURL)
+	if access["token_use"] != "access" || access["username"] != "ada" {
+		t.Errorf("unexpected access token claims %v", access)
+	}
+
+	refreshed, err := c.Client.InitiateAuth(&cip.InitiateAuthInput{
+		ClientId:       aws.String(c.ClientID),
+		AuthFlow:       aws.String("REFRESH_TOKEN_AUTH"),
+		AuthParameters: map[string]*string{"REFRESH_TOKEN": out.AuthenticationResult.RefreshToken},
+	})
+	if err != nil || refreshed.AuthenticationResult.IdToken == nil {
+		t.Errorf("refresh: %v, %v", refreshed, err)
+	}
+}
+
+func TestFakeCognitoAdminCreateUser(t *testing.T) {
+	c := NewFakeCognito()
+	defer c.Close()
+
+	_, err := c.Client.AdminCreateUser(&cip.AdminCreateUserInput{
+		UserPoolId:        aws.String(c.UserPoolID),
+		Username:          aws.String("grace"),
+		TemporaryPassword: aws.String("temporary1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := c.Client.AdminInitiateAuth(&cip.AdminInitiateAuthInput{
+		UserPoolId: aws.String(c.UserPoolID),
+		ClientId:   aws.String(c.ClientID),
+		AuthFlow:   aws.String("ADMIN_NO_SRP_AUTH"),
+		AuthParameters: map[string]*string{
+			"USERNAME": aws.String("grace"),
+			"PASSWORD": aws.String("temporary1"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(out.ChallengeName) != "NEW_PASSWORD_REQUIRED" {
+		t.Fatalf("ChallengeName = %v", out.ChallengeName)
+	}
+
+	resp, err := c.Client.AdminRespondToAuthChallenge(&cip.AdminRespondToAuthChallengeInput{
+		UserPoolId:    aws.String(c.UserPoolID),
+		ClientId:      aws.String(c.ClientID),
+		ChallengeName: aws.String("NEW_PASSWORD_REQUIRED"),
+		Session:       out.Session,
+		ChallengeResponses: map[string]*string{
+			"USERNAME":     aws.String("grace"),
+			"NEW_PASSWORD": aws.String("permanent1"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.AuthenticationResult == nil {
+		t.Fatal("expected tokens after answering the challenge")
+	}
+
+	user, err := c.Client.AdminGetUser(&cip.AdminGetUserInput{
+		UserPoolId: aws.String(c.UserPoolID),
+		Username:   aws.String("grace"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := aws.StringValue(user.UserStatus); got != CognitoConfirmed {
+		t.Errorf("UserStatus = %s, want %s", got, CognitoConfirmed)
+	}
+}
+
+func TestFakeCognitoIssueTokens(t *testing.T) {
+	c := NewFakeCognito()
+	defer c.Close()
+	c.AddUser("alan", "password1", map[string]string{"email": "alan@example.com"})
+
+	tokens, err := c.IssueTokens("alan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims := verifyJWT(t, tokens.IDToken, c.JWKSURL); claims["cognito:username"] != "alan" {
+		t.Errorf("unexpected claims %v", claims)
+	}
+	if _, err := c.IssueTokens("nobody"); err == nil {
+		t.Error("expected an error issuing tokens for an unknown user")
+	}
+}
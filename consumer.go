@@ -0,0 +1,343 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Outcome describes what happened to a message handed to a
+// ConsumerHarness handler.
+type Outcome int
+
+const (
+	// Acked means the handler returned nil and the message was
+	// deleted.
+	Acked Outcome = iota
+	// Nacked means the handler returned an error and the message was
+	// made visible again for redelivery.
+	Nacked
+	// TimedOut means the handler did not return within the harness's
+	// HandlerTimeout. The message is left alone, so it is redelivered
+	// once its visibility timeout expires.
+	TimedOut
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Acked:
+		return "acked"
+	case Nacked:
+		return "nacked"
+	case TimedOut:
+		return "timed out"
+	}
+	return fmt.Sprintf("Outcome(%d)", int(o))
+}
+
+// Delivery records one delivery of a message to a ConsumerHarness
+// handler.
+type Delivery struct {
+	MessageID string
+	Body      string
+	Outcome   Outcome
+
+	// Err is the error returned by the handler, if any.
+	Err error
+
+	// ReceiveCount is the message's ApproximateReceiveCount at the
+	// time of this delivery.
+	ReceiveCount int
+
+	Started  time.Time
+	Finished time.Time
+}
+
+// ConsumerHarness drives a handler against a queue the way a consumer
+// would, and records which deliveries were acked, nacked or
+// timed out, so consumer semantics such as at-least-once delivery and
+// retries can be asserted on directly.
+type ConsumerHarness struct {
+	// Queue is the queue messages are received from: a FakeSQS, or a
+	// MemoryQueue for fast unit tests.
+	Queue MessageQueue
+
+	// Handler processes a single message. Returning nil acks the
+	// message; returning an error nacks it.
+	Handler func(msg *sqs.Message) error
+
+	// HandlerContext, if set, is used instead of Handler, and is
+	// passed a context that is cancelled when HandlerTimeout elapses
+	// or the run's context is done.
+	HandlerContext func(ctx context.Context, msg *sqs.Message) error
+
+	// MaxConcurrency is the most messages handled at once. It
+	// defaults to 1, which makes processing order deterministic.
+	MaxConcurrency int
+
+	// HandlerTimeout is how long the handler may run before the
+	// delivery is recorded as TimedOut. A HandlerContext's context is
+	// then cancelled, and the harness waits up to handlerGracePeriod
+	// for it to return, so that it doesn't run on into later tests; a
+	// Handler, which takes no context, is left running. Zero means no
+	// limit.
+	HandlerTimeout time.Duration
+
+	// NackVisibility is the visibility timeout set on nacked
+	// messages, i.e. how long before they are redelivered. The
+	// default of zero redelivers them immediately.
+	NackVisibility time.Duration
+
+	mu         sync.Mutex
+	deliveries []Delivery
+}
+
+// RunUntil receives and handles messages until done returns true or
+// timeout elapses, in which case it returns an error. done is checked
+// after every delivery, and is passed the harness so it can inspect
+// the deliveries so far.
+func (h *ConsumerHarness) RunUntil(done func(h *ConsumerHarness) bool, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := h.RunUntilContext(ctx, done)
+	if err == context.DeadlineExceeded {
+		err = fmt.Errorf("consumer harness did not finish within %v: %s", timeout, h.summary())
+	}
+	return err
+}
+
+// RunUntilContext is like RunUntil, but runs until ctx is done rather
+// than for a fixed timeout, in which case it returns ctx's error. It
+// stops receiving promptly on cancellation and waits only for
+// handlers already running.
+func (h *ConsumerHarness) RunUntilContext(ctx context.Context, done func(h *ConsumerHarness) bool) error {
+	concurrency := h.MaxConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for !done(h) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Only receive as many messages as there are free workers, so
+		// messages aren't held invisible while waiting for one.
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		free := 1
+	acquire:
+		for free < concurrency {
+			select {
+			case sem <- struct{}{}:
+				free++
+			default:
+				break acquire
+			}
+		}
+
+		msgs, err := h.Queue.ReceiveMessagesContext(ctx, int64(free), time.Second)
+		if err != nil {
+			for i := 0; i < free; i++ {
+				<-sem
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		for i := len(msgs); i < free; i++ {
+			<-sem
+		}
+		for _, msg := range msgs {
+			wg.Add(1)
+			go func(msg *sqs.Message) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				h.handle(ctx, msg)
+			}(msg)
+		}
+		if concurrency == 1 {
+			// Wait for the handler so that done sees its delivery
+			// before the next receive.
+			wg.Wait()
+		}
+	}
+	return nil
+}
+
+// handlerGracePeriod is how long a HandlerContext handler has to
+// return once its context is cancelled on timeout.
+const handlerGracePeriod = time.Second
+
+// RunUntilAcked runs the harness until n distinct messages have been
+// acked.
+func (h *ConsumerHarness) RunUntilAcked(n int, timeout time.Duration) error {
+	return h.RunUntil(func(h *ConsumerHarness) bool {
+		return len(h.AckedMessageIDs()) >= n
+	}, timeout)
+}
+
+func (h *ConsumerHarness) handle(ctx context.Context, msg *sqs.Message) {
+	d := Delivery{
+		MessageID: aws.StringValue(msg.MessageId),
+		Body:      aws.StringValue(msg.Body),
+		Started:   time.Now(),
+	}
+	fmt.Sscan(aws.StringValue(msg.Attributes["ApproximateReceiveCount"]), &d.ReceiveCount)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		if h.HandlerContext != nil {
+			errc <- h.HandlerContext(ctx, msg)
+		} else {
+			errc <- h.Handler(msg)
+		}
+	}()
+
+	var timeoutc <-chan time.Time
+	if h.HandlerTimeout > 0 {
+		timer := time.NewTimer(h.HandlerTimeout)
+		defer timer.Stop()
+		timeoutc = timer.C
+	}
+
+	select {
+	case d.Err = <-errc:
+		if d.Err == nil {
+			d.Outcome = Acked
+			if err := h.Queue.DeleteMessage(aws.StringValue(msg.ReceiptHandle)); err != nil {
+				queueLogger(h.Queue).Errorf("Error deleting acked message %s: %v", d.MessageID, err)
+			}
+		} else {
+			d.Outcome = Nacked
+			if err := h.Queue.ChangeMessageVisibility(aws.StringValue(msg.ReceiptHandle), h.NackVisibility); err != nil {
+				queueLogger(h.Queue).Errorf("Error releasing nacked message %s: %v", d.MessageID, err)
+			}
+		}
+	case <-timeoutc:
+		d.Outcome = TimedOut
+		if h.HandlerContext != nil {
+			cancel()
+			grace := time.NewTimer(handlerGracePeriod)
+			select {
+			case d.Err = <-errc:
+			case <-grace.C:
+				queueLogger(h.Queue).Logf("Handler for message %s still running %v after its context was cancelled", d.MessageID, handlerGracePeriod)
+			}
+			grace.Stop()
+		}
+	}
+	d.Finished = time.Now()
+
+	h.mu.Lock()
+	h.deliveries = append(h.deliveries, d)
+	h.mu.Unlock()
+}
+
+// Deliveries returns every delivery so far in the order the handler
+// finished with them.
+func (h *ConsumerHarness) Deliveries() []Delivery {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Delivery(nil), h.deliveries...)
+}
+
+// DeliveriesOf returns the deliveries of the message with the given
+// ID.
+func (h *ConsumerHarness) DeliveriesOf(messageID string) []Delivery {
+	var ret []Delivery
+	for _, d := range h.Deliveries() {
+		if d.MessageID == messageID {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+// AckedMessageIDs returns the IDs of acked messages in the order they
+// were acked.
+func (h *ConsumerHarness) AckedMessageIDs() []string {
+	return h.messageIDs(Acked)
+}
+
+// NackedMessageIDs returns the IDs of messages that were nacked at
+// least once, in the order of their first nack.
+func (h *ConsumerHarness) NackedMessageIDs() []string {
+	return h.messageIDs(Nacked)
+}
+
+// TimedOutMessageIDs returns the IDs of messages whose handler timed
+// out at least once, in the order of their first timeout.
+func (h *ConsumerHarness) TimedOutMessageIDs() []string {
+	return h.messageIDs(TimedOut)
+}
+
+func (h *ConsumerHarness) messageIDs(o Outcome) []string {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, d := range h.Deliveries() {
+		if d.Outcome == o && !seen[d.MessageID] {
+			seen[d.MessageID] = true
+			ids = append(ids, d.MessageID)
+		}
+	}
+	return ids
+}
+
+// AssertAckedInOrder checks that messages with the given bodies were
+// acked in that order. Other acked messages may be interleaved.
+func (h *ConsumerHarness) AssertAckedInOrder(t TestingT, bodies ...string) {
+	t.Helper()
+	var acked []string
+	for _, d := range h.Deliveries() {
+		if d.Outcome == Acked {
+			acked = append(acked, d.Body)
+		}
+	}
+
+	next := 0
+	for _, body := range acked {
+		if next < len(bodies) && body == bodies[next] {
+			next++
+		}
+	}
+	if next < len(bodies) {
+		t.Errorf("expected messages to be acked in order %q, but they were acked in order %q",
+			bodies, acked)
+	}
+}
+
+// AssertDeliveredTimes checks that the message with the given body
+// was delivered to the handler exactly n times.
+func (h *ConsumerHarness) AssertDeliveredTimes(t TestingT, body string, n int) {
+	t.Helper()
+	var outcomes []string
+	for _, d := range h.Deliveries() {
+		if d.Body == body {
+			outcomes = append(outcomes, d.Outcome.String())
+		}
+	}
+	if len(outcomes) != n {
+		t.Errorf("message %q was delivered %d times (%s), want %d",
+			body, len(outcomes), strings.Join(outcomes, ", "), n)
+	}
+}
+
+func (h *ConsumerHarness) summary() string {
+	return fmt.Sprintf("%d deliveries, %d acked, %d nacked, %d timed out",
+		len(h.Deliveries()), len(h.AckedMessageIDs()), len(h.NackedMessageIDs()), len(h.TimedOutMessageIDs()))
+}
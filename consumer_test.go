@@ -0,0 +1,140 @@
+package testutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func ExampleConsumerHarness() {
+	s := NewFakeSQS("harness-queue")
+	defer s.Close()
+
+	s.SendMessage("first", nil)
+	s.SendMessage("flaky", nil)
+	s.SendMessage("last", nil)
+
+	failed := false
+	h := &ConsumerHarness{
+		Queue: s,
+		Handler: func(msg *sqs.Message) error {
+			// Fail the first attempt at the flaky message
+			if aws.StringValue(msg.Body) == "flaky" && !failed {
+				failed = true
+				return errors.New("temporary failure")
+			}
+			return nil
+		},
+	}
+	if err := h.RunUntilAcked(3, 10*time.Second); err != nil {
+		// handle error
+	}
+
+	fmt.Println(len(h.AckedMessageIDs()), len(h.NackedMessageIDs()))
+	// Output:
+	// 3 1
+}
+
+func TestConsumerHarnessTimeout(t *testing.T) {
+	s := NewFakeSQS("harness-timeout", WithInProcess(), WithLogger(t))
+	defer s.Close()
+	s.SendMessage("stuck", nil)
+	s.SendMessage("quick", nil)
+
+	exited := make(chan struct{})
+	h := &ConsumerHarness{
+		Queue:          s,
+		HandlerTimeout: 50 * time.Millisecond,
+		HandlerContext: func(ctx context.Context, msg *sqs.Message) error {
+			if aws.StringValue(msg.Body) != "stuck" {
+				return nil
+			}
+			<-ctx.Done()
+			close(exited)
+			return ctx.Err()
+		},
+	}
+	err := h.RunUntil(func(h *ConsumerHarness) bool {
+		return len(h.AckedMessageIDs()) == 1 && len(h.TimedOutMessageIDs()) == 1
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-exited:
+	default:
+		t.Error("the timed out handler was still running after RunUntil returned")
+	}
+	h.AssertDeliveredTimes(t, "stuck", 1)
+	if d := h.Deliveries()[0]; d.Body != "stuck" || d.Outcome != TimedOut || d.Err != context.Canceled {
+		t.Errorf("first delivery = %+v", d)
+	}
+}
+
+func TestConsumerHarnessTimeoutPlainHandler(t *testing.T) {
+	s := NewFakeSQS("harness-hang", WithInProcess(), WithLogger(t))
+	defer s.Close()
+	s.SendMessage("hang", nil)
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+	h := &ConsumerHarness{
+		Queue:          s,
+		HandlerTimeout: 50 * time.Millisecond,
+		Handler: func(msg *sqs.Message) error {
+			<-unblock
+			return nil
+		},
+	}
+	returned := make(chan error, 1)
+	go func() {
+		returned <- h.RunUntil(func(h *ConsumerHarness) bool {
+			return len(h.TimedOutMessageIDs()) == 1
+		}, 5*time.Second)
+	}()
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunUntil didn't return with a hanging Handler")
+	}
+}
+
+func TestConsumerHarnessCancelRun(t *testing.T) {
+	s := NewFakeSQS("harness-cancel", WithInProcess(), WithLogger(t))
+	defer s.Close()
+	s.SendMessage("slow", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := &ConsumerHarness{
+		Queue: s,
+		HandlerContext: func(hctx context.Context, msg *sqs.Message) error {
+			cancel()
+			<-hctx.Done()
+			return hctx.Err()
+		},
+	}
+	returned := make(chan error, 1)
+	go func() {
+		returned <- h.RunUntilContext(ctx, func(*ConsumerHarness) bool { return false })
+	}()
+	select {
+	case err := <-returned:
+		if err != context.Canceled {
+			t.Errorf("RunUntilContext = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelling the run didn't cancel the handler")
+	}
+	if d := h.Deliveries(); len(d) != 1 || d[0].Outcome != Nacked || d[0].Err != context.Canceled {
+		t.Errorf("deliveries = %+v", d)
+	}
+}
@@ -0,0 +1,25 @@
+package testutil
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// sendWithContext sends r with ctx attached to its HTTP request, so
+// that cancelling ctx aborts the request in flight. The SDK retries
+// failed sends, so r is also made non-retryable once ctx is done;
+// otherwise a cancelled request would back off and retry until it ran
+// out of attempts.
+func sendWithContext(ctx context.Context, r *request.Request) error {
+	r.HTTPRequest = r.HTTPRequest.WithContext(ctx)
+	r.Handlers.Retry.PushBack(func(r *request.Request) {
+		if err := ctx.Err(); err != nil {
+			r.Retryable = aws.Bool(false)
+			r.Error = awserr.New("RequestCanceled", "request context done", err)
+		}
+	})
+	return r.Send()
+}
@@ -0,0 +1,50 @@
+package testutil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestSendWithContextDoesNotRetryAfterCancel(t *testing.T) {
+	var hits int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	cfg := fakeAWSConfig(srv.URL, newOptions(nil)).WithMaxRetries(3)
+	client := sqs.New(session.New(cfg))
+	req, _ := client.SendMessageRequest(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(srv.URL + "/queue"),
+		MessageBody: aws.String("hello"),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	err := sendWithContext(ctx, req)
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "RequestCanceled" {
+		t.Fatalf("expected RequestCanceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled request took %v to return", elapsed)
+	}
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Errorf("server saw %d requests, want 1", got)
+	}
+}
@@ -0,0 +1,403 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// maxContractExamples is how many distinct examples a Contract keeps
+// for each channel. Every message or object still contributes to the
+// schema.
+const maxContractExamples = 20
+
+// A Contract describes the messages or objects a producer writes to a
+// queue or bucket, so that consumers in other repos can be tested
+// against what the producer really sends, Pact-style. Producer tests
+// record contracts with a ContractRecorder and check them into a
+// directory shared with consumers, and consumer tests run their
+// handlers over the examples with VerifyContract.
+type Contract struct {
+	// Channel is where the messages or objects are written:
+	// "sqs:{queue}" or "s3:{bucket}".
+	Channel string `json:"channel"`
+
+	// Schema describes the bodies if they are all JSON, or is nil.
+	Schema *ContractSchema `json:"schema,omitempty"`
+
+	// Examples are bodies the producer wrote, up to 20 distinct ones.
+	Examples []ContractExample `json:"examples"`
+}
+
+// ContractExample is a message or object a producer wrote.
+type ContractExample struct {
+	// Key is the object's key, for an S3 channel.
+	Key string `json:"key,omitempty"`
+
+	// Attributes are the message's string attributes, for an SQS
+	// channel.
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	Body string `json:"body"`
+}
+
+// ContractSchema is the subset of JSON Schema inferred from a
+// contract's examples. A property is required if every example has it.
+// An empty Type accepts any value, as when examples disagree.
+type ContractSchema struct {
+	Type       string                     `json:"type,omitempty"`
+	Properties map[string]*ContractSchema `json:"properties,omitempty"`
+	Required   []string                   `json:"required,omitempty"`
+	Items      *ContractSchema            `json:"items,omitempty"`
+}
+
+// ContractRecorder records the messages sent and objects put through
+// the fakes it is attached to, as Contracts.
+type ContractRecorder struct {
+	mu        sync.Mutex
+	contracts map[string]*Contract
+	nonJSON   map[string]bool
+}
+
+// NewContractRecorder returns a ContractRecorder that has recorded
+// nothing.
+func NewContractRecorder() *ContractRecorder {
+	return &ContractRecorder{
+		contracts: make(map[string]*Contract),
+		nonJSON:   make(map[string]bool),
+	}
+}
+
+// Option attaches the recorder to an SQS or S3 fake.
+func (c *ContractRecorder) Option() Option {
+	return func(o *options) {
+		o.signHooks = append(o.signHooks, c.signHook)
+	}
+}
+
+// signHook captures what the first attempt of a request writes, and
+// records it once the request succeeds.
+func (c *ContractRecorder) signHook(r *request.Request) {
+	if r.RetryCount > 0 {
+		return
+	}
+	var channel string
+	var examples []ContractExample
+	switch in := r.Params.(type) {
+	case *sqs.SendMessageInput:
+		channel = "sqs:" + queueNameFromURL(aws.StringValue(in.QueueUrl))
+		examples = append(examples, ContractExample{
+			Attributes: contractAttributes(in.MessageAttributes),
+			Body:       aws.StringValue(in.MessageBody),
+		})
+	case *sqs.SendMessageBatchInput:
+		channel = "sqs:" + queueNameFromURL(aws.StringValue(in.QueueUrl))
+		for _, e := range in.Entries {
+			examples = append(examples, ContractExample{
+				Attributes: contractAttributes(e.MessageAttributes),
+				Body:       aws.StringValue(e.MessageBody),
+			})
+		}
+	case *s3.PutObjectInput:
+		body, err := peekBody(in.Body)
+		if err != nil {
+			return
+		}
+		channel = "s3:" + aws.StringValue(in.Bucket)
+		examples = append(examples, ContractExample{Key: aws.StringValue(in.Key), Body: string(body)})
+	default:
+		return
+	}
+	onFinish(r, func(r *request.Request, _ time.Duration) {
+		if r.Error == nil {
+			c.add(channel, examples)
+		}
+	})
+}
+
+// peekBody reads body without moving its offset.
+func peekBody(body io.ReadSeeker) ([]byte, error) {
+	if body == nil {
+		return nil, nil
+	}
+	pos, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Seek(pos, io.SeekStart)
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(body)
+}
+
+func contractAttributes(attrs map[string]*sqs.MessageAttributeValue) map[string]string {
+	if len(attrs) == 0 {
+		return nil
+	}
+	ret := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		ret[k] = aws.StringValue(v.StringValue)
+	}
+	return ret
+}
+
+func (c *ContractRecorder) add(channel string, examples []ContractExample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ct := c.contracts[channel]
+	if ct == nil {
+		ct = &Contract{Channel: channel}
+		c.contracts[channel] = ct
+	}
+	for _, x := range examples {
+		var v interface{}
+		if json.Unmarshal([]byte(x.Body), &v) != nil {
+			c.nonJSON[channel] = true
+			ct.Schema = nil
+		} else if !c.nonJSON[channel] {
+			if len(ct.Examples) == 0 {
+				ct.Schema = inferSchema(v)
+			} else {
+				ct.Schema = mergeSchemas(ct.Schema, inferSchema(v))
+			}
+		}
+		if len(ct.Examples) < maxContractExamples && !hasExample(ct.Examples, x) {
+			ct.Examples = append(ct.Examples, x)
+		}
+	}
+}
+
+func hasExample(examples []ContractExample, x ContractExample) bool {
+	for _, e := range examples {
+		if e.Body == x.Body && e.Key == x.Key {
+			return true
+		}
+	}
+	return false
+}
+
+// Contracts returns the recorded contracts, by channel.
+func (c *ContractRecorder) Contracts() map[string]*Contract {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := make(map[string]*Contract, len(c.contracts))
+	for k, v := range c.contracts {
+		ct := *v
+		ct.Examples = append([]ContractExample(nil), v.Examples...)
+		ret[k] = &ct
+	}
+	return ret
+}
+
+// Check compares the recorded contracts with those checked in to dir,
+// failing t for each recorded example that breaks a checked-in schema,
+// as consumers written against it might not handle it. Contracts not
+// in dir yet are written there. With TESTUTIL_UPDATE_CONTRACTS=1 in the
+// environment every contract is rewritten instead, to accept a change
+// once consumers are ready for it.
+func (c *ContractRecorder) Check(t TestingT, dir string) {
+	t.Helper()
+	update := os.Getenv("TESTUTIL_UPDATE_CONTRACTS") == "1"
+	contracts := c.Contracts()
+	for _, channel := range sortedKeys(contracts) {
+		ct := contracts[channel]
+		old, err := LoadContract(dir, channel)
+		if err == nil && !update {
+			c.checkAgainst(t, old, ct)
+			continue
+		} else if err != nil && !os.IsNotExist(err) {
+			t.Errorf("%v", err)
+			continue
+		}
+		if err := writeContract(dir, ct); err != nil {
+			t.Errorf("writing contract for %s: %v", channel, err)
+		}
+	}
+}
+
+func (c *ContractRecorder) checkAgainst(t TestingT, old, ct *Contract) {
+	t.Helper()
+	if old.Schema == nil {
+		return
+	}
+	for _, x := range ct.Examples {
+		var v interface{}
+		if err := json.Unmarshal([]byte(x.Body), &v); err != nil {
+			t.Errorf("contract %s: the body isn't JSON any more: %.100q", ct.Channel, x.Body)
+			continue
+		}
+		if problems := old.Schema.validate("", v); len(problems) > 0 {
+			t.Errorf("contract %s: breaking change (rerun with TESTUTIL_UPDATE_CONTRACTS=1 to accept it):\n  %s\nin %.200s",
+				ct.Channel, strings.Join(problems, "\n  "), x.Body)
+		}
+	}
+}
+
+// contractFile returns the name of channel's contract file in dir.
+func contractFile(dir, channel string) string {
+	return filepath.Join(dir, strings.Replace(channel, ":", "-", 1)+".json")
+}
+
+func writeContract(dir string, ct *Contract) error {
+	b, err := json.MarshalIndent(ct, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(contractFile(dir, ct.Channel), append(b, '\n'), 0644)
+}
+
+// LoadContract reads the contract for channel, such as "sqs:jobs", from
+// dir.
+func LoadContract(dir, channel string) (*Contract, error) {
+	b, err := ioutil.ReadFile(contractFile(dir, channel))
+	if err != nil {
+		return nil, err
+	}
+	var ct Contract
+	if err := json.Unmarshal(b, &ct); err != nil {
+		return nil, fmt.Errorf("contract %s: %v", channel, err)
+	}
+	return &ct, nil
+}
+
+// VerifyContract runs handle over every example in the contract for
+// channel in dir, failing t for each one it returns an error for. It
+// is how a consumer checks it can handle what the producer sends.
+func VerifyContract(t TestingT, dir, channel string, handle func(ContractExample) error) {
+	t.Helper()
+	ct, err := LoadContract(dir, channel)
+	if err != nil {
+		t.Errorf("loading contract: %v", err)
+		return
+	}
+	if len(ct.Examples) == 0 {
+		t.Errorf("contract %s has no examples", channel)
+	}
+	for i, x := range ct.Examples {
+		if err := handle(x); err != nil {
+			t.Errorf("contract %s example %d: %v\nbody: %.200s", channel, i+1, err, x.Body)
+		}
+	}
+}
+
+// inferSchema returns the schema of the JSON value v.
+func inferSchema(v interface{}) *ContractSchema {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		s := &ContractSchema{Type: "object", Properties: make(map[string]*ContractSchema)}
+		for k, pv := range v {
+			s.Properties[k] = inferSchema(pv)
+			s.Required = append(s.Required, k)
+		}
+		sort.Strings(s.Required)
+		return s
+	case []interface{}:
+		s := &ContractSchema{Type: "array"}
+		for _, item := range v {
+			if s.Items == nil {
+				s.Items = inferSchema(item)
+			} else {
+				s.Items = mergeSchemas(s.Items, inferSchema(item))
+			}
+		}
+		return s
+	case string:
+		return &ContractSchema{Type: "string"}
+	case float64:
+		return &ContractSchema{Type: "number"}
+	case bool:
+		return &ContractSchema{Type: "boolean"}
+	default:
+		return &ContractSchema{Type: "null"}
+	}
+}
+
+// mergeSchemas returns a schema that accepts everything a or b does.
+func mergeSchemas(a, b *ContractSchema) *ContractSchema {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a.Type != b.Type:
+		return &ContractSchema{}
+	}
+	s := &ContractSchema{Type: a.Type, Items: mergeSchemas(a.Items, b.Items)}
+	if a.Type == "object" {
+		s.Properties = make(map[string]*ContractSchema)
+		for k, p := range a.Properties {
+			s.Properties[k] = mergeSchemas(p, b.Properties[k])
+		}
+		for k, p := range b.Properties {
+			if _, ok := a.Properties[k]; !ok {
+				s.Properties[k] = p
+			}
+		}
+		for _, k := range a.Required {
+			if containsString(b.Required, k) {
+				s.Required = append(s.Required, k)
+			}
+		}
+	}
+	return s
+}
+
+// validate returns how the JSON value v, found at path, doesn't match
+// s.
+func (s *ContractSchema) validate(path string, v interface{}) []string {
+	if s == nil || s.Type == "" {
+		return nil
+	}
+	if got := inferSchema(v).Type; got != s.Type {
+		return []string{fmt.Sprintf("%s is %s, was %s", jsonPath(path), got, s.Type)}
+	}
+	var problems []string
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, k := range s.Required {
+			if _, ok := v[k]; !ok {
+				problems = append(problems, fmt.Sprintf("%s is missing", jsonPath(path+"."+k)))
+			}
+		}
+		for _, k := range sortedKeys(v) {
+			problems = append(problems, s.Properties[k].validate(path+"."+k, v[k])...)
+		}
+	case []interface{}:
+		for i, item := range v {
+			problems = append(problems, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+		}
+	}
+	return problems
+}
+
+func jsonPath(path string) string {
+	if path == "" {
+		return "the body"
+	}
+	return strings.TrimPrefix(path, ".")
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package testutil
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestContracts(t *testing.T) {
+	srv := httptest.NewServer(&memSQS{})
+	defer srv.Close()
+	s3Srv := httptest.NewServer(&memS3{objects: make(map[string]string)})
+	defer s3Srv.Close()
+	dir := t.TempDir()
+
+	produce := func(bodies ...string) *ContractRecorder {
+		rec := NewContractRecorder()
+		q := NewFakeSQS("orders", WithEndpoint(srv.URL), WithLogger(t), rec.Option())
+		defer q.Close()
+		for _, body := range bodies {
+			if _, err := q.SendMessage(body, map[string]string{"version": "1"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec
+	}
+
+	rec := produce(`{"id":1,"name":"a","tags":["x"]}`, `{"id":2,"name":"b","tags":[],"gift":true}`, `{"id":1,"name":"a","tags":["x"]}`)
+	rt := &recordingT{}
+	rec.Check(rt, dir)
+	if len(rt.errors) > 0 {
+		t.Fatalf("first Check failed: %v", rt.errors)
+	}
+	ct, err := LoadContract(dir, "sqs:orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ct.Examples) != 2 || ct.Examples[0].Attributes["version"] != "1" {
+		t.Errorf("examples = %+v, want the 2 distinct messages", ct.Examples)
+	}
+	schema, _ := json.Marshal(ct.Schema)
+	if want := `{"type":"object","properties":{"gift":{"type":"boolean"},"id":{"type":"number"},"name":{"type":"string"},"tags":{"type":"array","items":{"type":"string"}}},"required":["id","name","tags"]}`; string(schema) != want {
+		t.Errorf("schema = %s\nwant %s", schema, want)
+	}
+
+	// Adding an optional field is fine; changing a type or dropping a
+	// required field isn't.
+	rt = &recordingT{}
+	produce(`{"id":3,"name":"c","tags":[],"note":"new"}`).Check(rt, dir)
+	if len(rt.errors) > 0 {
+		t.Errorf("compatible change failed Check: %v", rt.errors)
+	}
+	rt = &recordingT{}
+	produce(`{"id":"4","tags":[1]}`).Check(rt, dir)
+	if len(rt.errors) != 1 || !strings.Contains(rt.errors[0], "id is string, was number") ||
+		!strings.Contains(rt.errors[0], "name is missing") || !strings.Contains(rt.errors[0], "tags[0] is number, was string") {
+		t.Errorf("breaking change reported as %q", rt.errors)
+	}
+
+	t.Setenv("TESTUTIL_UPDATE_CONTRACTS", "1")
+	rt = &recordingT{}
+	produce(`"just a string"`).Check(rt, dir)
+	if ct, _ := LoadContract(dir, "sqs:orders"); len(rt.errors) > 0 || ct.Schema.Type != "string" {
+		t.Errorf("update: errors %v, schema %+v", rt.errors, ct.Schema)
+	}
+
+	rec = NewContractRecorder()
+	s := NewFakeS3("exports", WithEndpoint(s3Srv.URL), WithLogger(t), rec.Option())
+	defer s.Close()
+	s.PutString("exports", "a.csv", "id,name\n1,a\n")
+	rec.Check(t, dir)
+	ct, err = LoadContract(dir, "s3:exports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct.Schema != nil || len(ct.Examples) != 1 || ct.Examples[0].Key != "a.csv" {
+		t.Errorf("S3 contract = %+v", ct)
+	}
+}
+
+func TestVerifyContract(t *testing.T) {
+	dir := t.TempDir()
+	writeContract(dir, &Contract{Channel: "sqs:jobs", Examples: []ContractExample{{Body: "ok"}, {Body: "bad"}}})
+
+	rt := &recordingT{}
+	VerifyContract(rt, dir, "sqs:jobs", func(x ContractExample) error {
+		if x.Body == "bad" {
+			return errors.New("can't handle it")
+		}
+		return nil
+	})
+	if len(rt.errors) != 1 || !strings.Contains(rt.errors[0], "example 2: can't handle it") {
+		t.Errorf("errors = %q", rt.errors)
+	}
+
+	rt = &recordingT{}
+	VerifyContract(rt, dir, "sqs:missing", func(ContractExample) error { return nil })
+	if len(rt.errors) != 1 {
+		t.Errorf("missing contract reported as %q", rt.errors)
+	}
+}
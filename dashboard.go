@@ -0,0 +1,156 @@
+package testutil
+
+import "net/http"
+
+// EnableDashboard makes the Admin serve a web page at its URL showing
+// the state of its fakes, refreshed every few seconds: queue depths,
+// bucket contents, redis key counts and the faults injected, with a
+// form to change the failure schedule. It is meant for keeping an eye
+// on long soak tests. The page is self-contained, so it works without
+// network access. EnableDashboard returns the page's URL.
+func (a *Admin) EnableDashboard() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.dashboard = true
+	return a.URL + "/"
+}
+
+func (a *Admin) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	enabled := a.dashboard
+	a.mu.Unlock()
+	if !enabled || r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(dashboardHTML))
+}
+
+// dashboardHTML polls /api and renders it. Queue depths keep a short
+// history so trends show up as sparklines.
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>testutil fakes</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h2 { margin-top: 1.5em; font-size: 1.1em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 0.8em; text-align: left; border-bottom: 1px solid #ddd; }
+td.n { text-align: right; font-variant-numeric: tabular-nums; }
+#status { color: #888; }
+.bad { color: #b00; }
+textarea { width: 40em; height: 5em; font-family: monospace; }
+svg polyline { fill: none; stroke: #36c; stroke-width: 1.5; }
+</style>
+</head>
+<body>
+<h1>testutil fakes</h1>
+<p id="status">loading…</p>
+<p id="errors" class="bad"></p>
+<h2>Queues</h2>
+<table><thead><tr><th>Queue</th><th>Waiting</th><th>In flight</th><th>Trend</th><th></th></tr></thead><tbody id="queues"></tbody></table>
+<h2>Buckets</h2>
+<table><thead><tr><th>Bucket</th><th>Objects</th><th></th></tr></thead><tbody id="buckets"></tbody></table>
+<h2>Redis</h2>
+<p id="redis"></p>
+<h2>Faults</h2>
+<p id="partition"></p>
+<form id="schedule">
+<textarea name="spec" placeholder="3rd sqs:ReceiveMessage errors"></textarea><br>
+<button>Set schedule</button> <button type="button" id="clear">Clear</button>
+</form>
+<table><thead><tr><th>At</th><th>Operation</th><th>Call</th><th>Rule</th></tr></thead><tbody id="fired"></tbody></table>
+<script>
+"use strict";
+var depths = {}, historyLen = 60, editing = false;
+
+function el(tag, text, cls) {
+	var e = document.createElement(tag);
+	if (text !== undefined) e.textContent = text;
+	if (cls) e.className = cls;
+	return e;
+}
+
+function row(tbody, cells) {
+	var tr = el("tr");
+	cells.forEach(function (c) {
+		if (c instanceof Node) { var td = el("td"); td.appendChild(c); tr.appendChild(td); }
+		else tr.appendChild(el("td", c, typeof c === "number" ? "n" : ""));
+	});
+	tbody.appendChild(tr);
+}
+
+function sparkline(values) {
+	var ns = "http://www.w3.org/2000/svg", w = 120, h = 20;
+	var svg = document.createElementNS(ns, "svg"), line = document.createElementNS(ns, "polyline");
+	var max = Math.max.apply(null, values.concat([1]));
+	svg.setAttribute("width", w);
+	svg.setAttribute("height", h);
+	line.setAttribute("points", values.map(function (v, i) {
+		return (i * w / (historyLen - 1)).toFixed(1) + "," + (h - 1 - v * (h - 2) / max).toFixed(1);
+	}).join(" "));
+	svg.appendChild(line);
+	return svg;
+}
+
+function button(label, method, path) {
+	var b = el("button", label);
+	b.onclick = function () { fetch(path, {method: method}).then(refresh); };
+	return b;
+}
+
+function render(ov) {
+	var queues = document.getElementById("queues"), buckets = document.getElementById("buckets");
+	queues.textContent = "";
+	(ov.queues || []).forEach(function (q) {
+		var h = depths[q.name] = (depths[q.name] || []).concat([q.messages]).slice(-historyLen);
+		row(queues, [q.name, q.messages, q.inFlight, sparkline(h), button("Reset", "POST", "api/queues/" + encodeURIComponent(q.name) + "/reset")]);
+	});
+	buckets.textContent = "";
+	(ov.buckets || []).forEach(function (b) {
+		row(buckets, [b.name, b.objects, button("Reset", "POST", "api/buckets/" + encodeURIComponent(b.name) + "/reset")]);
+	});
+	document.getElementById("redis").textContent = ov.redis ? ov.redis.keys + " key(s) in the test DB" : "not attached";
+	document.getElementById("partition").textContent = ov.partitioned ? "Partitioned: the fakes are unreachable." : "Not partitioned.";
+	document.getElementById("partition").className = ov.partitioned ? "bad" : "";
+	if (!editing) document.querySelector("#schedule textarea").value = ov.faults.schedule;
+	var fired = document.getElementById("fired");
+	fired.textContent = "";
+	ov.faults.fired.slice(-50).reverse().forEach(function (f) {
+		row(fired, [(f.at / 1e9).toFixed(3) + "s", f.operation, f.call, f.rule]);
+	});
+	document.getElementById("errors").textContent = (ov.errors || []).join("\n");
+	document.getElementById("status").textContent = "Updated " + new Date().toLocaleTimeString();
+}
+
+function refresh() {
+	return fetch("api").then(function (r) { return r.json(); }).then(render, function (err) {
+		document.getElementById("status").textContent = "Can't reach the fakes: " + err;
+	});
+}
+
+document.querySelector("#schedule textarea").onfocus = function () { editing = true; };
+document.getElementById("schedule").onsubmit = function (e) {
+	e.preventDefault();
+	fetch("api/faults", {method: "PUT", body: this.spec.value}).then(function (r) {
+		if (!r.ok) return r.text().then(alert);
+		editing = false;
+		return refresh();
+	});
+};
+document.getElementById("clear").onclick = function () {
+	editing = false;
+	fetch("api/faults", {method: "DELETE"}).then(refresh);
+};
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
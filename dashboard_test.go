@@ -0,0 +1,42 @@
+package testutil
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDashboard(t *testing.T) {
+	admin := NewAdmin()
+	defer admin.Close()
+
+	get := func(url string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, _ := get(admin.URL + "/"); code != http.StatusNotFound {
+		t.Errorf("dashboard served before being enabled: %d", code)
+	}
+	url := admin.EnableDashboard()
+	if url != admin.URL+"/" {
+		t.Errorf("EnableDashboard() = %q", url)
+	}
+	code, body := get(url)
+	if code != http.StatusOK || !strings.Contains(body, `fetch("api")`) {
+		t.Errorf("GET %s: %d\n%s", url, code, body)
+	}
+	if code, _ := get(admin.URL + "/nope"); code != http.StatusNotFound {
+		t.Errorf("GET /nope: %d", code)
+	}
+	if code, _ := get(admin.URL + "/api"); code != http.StatusOK {
+		t.Errorf("GET /api: %d", code)
+	}
+}
@@ -0,0 +1,343 @@
+package testutil
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Response codes that can be given to FakeDNS.Fail.
+const (
+	DNSServerFailure = 2 // SERVFAIL
+	DNSNameError     = 3 // NXDOMAIN
+	DNSRefused       = 5 // REFUSED
+)
+
+const (
+	dnsTypeA     = 1
+	dnsTypeCNAME = 5
+	dnsTypeTXT   = 16
+	dnsTypeAAAA  = 28
+	dnsTypeSRV   = 33
+
+	dnsClassIN = 1
+	dnsTTL     = 30
+)
+
+// FakeDNS is an authoritative DNS server on a random local UDP port
+// with programmable A, CNAME, TXT and SRV records, for testing service
+// discovery and DNS failover. Point code at it with Resolver. Every
+// query is logged and available from Queries.
+//
+// Names are matched case-insensitively, with or without a trailing
+// dot. A name with no records gets NXDOMAIN; a name with records of
+// other types gets an empty answer.
+type FakeDNS struct {
+	// Addr is the host:port the server listens on.
+	Addr string
+
+	conn       net.PacketConn
+	logger     Logger
+	unregister func()
+
+	mu       sync.Mutex
+	records  map[string][]dnsRecord
+	failures map[string]int
+	queries  []DNSQuery
+}
+
+// DNSQuery is a query received by a FakeDNS.
+type DNSQuery struct {
+	// Name is the queried name, lower case and without a trailing dot.
+	Name string
+	// Type is the record type, such as "A" or "SRV".
+	Type string
+}
+
+type dnsRecord struct {
+	typ  uint16
+	data []byte
+}
+
+// NewFakeDNS starts a FakeDNS with no records.
+func NewFakeDNS(opts ...Option) *FakeDNS {
+	o := newOptions(opts)
+	d := &FakeDNS{
+		logger:   o.logger,
+		records:  make(map[string][]dnsRecord),
+		failures: make(map[string]int),
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		d.logger.Fatalf("Error starting fake DNS server: %v", err)
+	}
+	d.conn = conn
+	d.Addr = conn.LocalAddr().String()
+	go d.serve()
+	d.unregister = OnInterrupt(d.Close)
+	return d
+}
+
+// Close stops the server.
+func (d *FakeDNS) Close() {
+	if d.unregister != nil {
+		d.unregister()
+	}
+	d.conn.Close()
+}
+
+// Resolver returns a resolver that sends every query to d, for code
+// that accepts a *net.Resolver.
+func (d *FakeDNS) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "udp", d.Addr)
+		},
+	}
+}
+
+// AddA adds A records for name pointing at ips.
+func (d *FakeDNS) AddA(name string, ips ...string) error {
+	for _, s := range ips {
+		ip := net.ParseIP(s).To4()
+		if ip == nil {
+			return fmt.Errorf("testutil: %q is not an IPv4 address", s)
+		}
+		d.add(name, dnsRecord{dnsTypeA, ip})
+	}
+	return nil
+}
+
+// AddCNAME makes name an alias for target. Queries for name are
+// answered with the alias and target's records of the queried type.
+func (d *FakeDNS) AddCNAME(name, target string) {
+	d.add(name, dnsRecord{dnsTypeCNAME, encodeDNSName(target)})
+}
+
+// AddTXT adds a TXT record for name holding txt.
+func (d *FakeDNS) AddTXT(name string, txt string) {
+	var data []byte
+	for {
+		n := len(txt)
+		if n > 255 {
+			n = 255
+		}
+		data = append(data, byte(n))
+		data = append(data, txt[:n]...)
+		txt = txt[n:]
+		if txt == "" {
+			break
+		}
+	}
+	d.add(name, dnsRecord{dnsTypeTXT, data})
+}
+
+// AddSRV adds an SRV record for name, which is usually of the form
+// "_service._proto.domain", pointing at target:port.
+func (d *FakeDNS) AddSRV(name, target string, port, priority, weight uint16) {
+	data := make([]byte, 6)
+	binary.BigEndian.PutUint16(data[0:], priority)
+	binary.BigEndian.PutUint16(data[2:], weight)
+	binary.BigEndian.PutUint16(data[4:], port)
+	d.add(name, dnsRecord{dnsTypeSRV, append(data, encodeDNSName(target)...)})
+}
+
+// Remove deletes every record for name.
+func (d *FakeDNS) Remove(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.records, canonicalDNSName(name))
+}
+
+// Fail makes queries for name fail with rcode, such as
+// DNSServerFailure, until Recover is called.
+func (d *FakeDNS) Fail(name string, rcode int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failures[canonicalDNSName(name)] = rcode
+}
+
+// Recover undoes Fail, so that name resolves normally again.
+func (d *FakeDNS) Recover(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.failures, canonicalDNSName(name))
+}
+
+// Queries returns every query received so far, in order.
+func (d *FakeDNS) Queries() []DNSQuery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]DNSQuery(nil), d.queries...)
+}
+
+// QueryCount returns how many queries for name were received.
+func (d *FakeDNS) QueryCount(name string) int {
+	name = canonicalDNSName(name)
+	n := 0
+	for _, q := range d.Queries() {
+		if q.Name == name {
+			n++
+		}
+	}
+	return n
+}
+
+func (d *FakeDNS) add(name string, r dnsRecord) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	name = canonicalDNSName(name)
+	d.records[name] = append(d.records[name], r)
+}
+
+func (d *FakeDNS) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				d.logger.Errorf("fake DNS server: %v", err)
+			}
+			return
+		}
+		if resp := d.handle(buf[:n]); resp != nil {
+			d.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+// handle returns the response to the DNS message msg, or nil if msg
+// can't be parsed.
+func (d *FakeDNS) handle(msg []byte) []byte {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg[4:]) != 1 {
+		return nil
+	}
+	name, off, ok := decodeDNSName(msg, 12)
+	if !ok || off+4 > len(msg) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(msg[off:])
+	question := msg[12 : off+4]
+
+	d.mu.Lock()
+	d.queries = append(d.queries, DNSQuery{Name: name, Type: dnsTypeName(qtype)})
+	rcode, answers := d.answer(name, qtype)
+	d.mu.Unlock()
+
+	resp := make([]byte, 12, 512)
+	copy(resp, msg[:2])
+	// QR and AA set, RD copied from the query.
+	flags := uint16(0x8400) | binary.BigEndian.Uint16(msg[2:])&0x0100 | uint16(rcode)
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(answers)))
+	resp = append(resp, question...)
+	for _, a := range answers {
+		resp = append(resp, a...)
+	}
+	return resp
+}
+
+// answer returns the response code and answer records for a query.
+// d.mu must be held.
+func (d *FakeDNS) answer(name string, qtype uint16) (int, [][]byte) {
+	var answers [][]byte
+	for i := 0; i < 8; i++ {
+		if rcode, ok := d.failures[name]; ok {
+			return rcode, nil
+		}
+		records, ok := d.records[name]
+		if !ok {
+			if i == 0 {
+				return DNSNameError, nil
+			}
+			return 0, answers
+		}
+
+		var cname []byte
+		for _, r := range records {
+			if r.typ == qtype {
+				answers = append(answers, encodeDNSRecord(name, r))
+			} else if r.typ == dnsTypeCNAME && cname == nil {
+				cname = r.data
+			}
+		}
+		if cname == nil || qtype == dnsTypeCNAME {
+			return 0, answers
+		}
+		answers = append(answers, encodeDNSRecord(name, dnsRecord{dnsTypeCNAME, cname}))
+		name, _, _ = decodeDNSName(cname, 0)
+	}
+	return DNSServerFailure, nil
+}
+
+func encodeDNSRecord(name string, r dnsRecord) []byte {
+	b := encodeDNSName(name)
+	var fixed [10]byte
+	binary.BigEndian.PutUint16(fixed[0:], r.typ)
+	binary.BigEndian.PutUint16(fixed[2:], dnsClassIN)
+	binary.BigEndian.PutUint32(fixed[4:], dnsTTL)
+	binary.BigEndian.PutUint16(fixed[8:], uint16(len(r.data)))
+	b = append(b, fixed[:]...)
+	return append(b, r.data...)
+}
+
+func canonicalDNSName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+func encodeDNSName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(canonicalDNSName(name), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// decodeDNSName decodes the uncompressed name at msg[off:], returning
+// it in canonical form and the offset just past it.
+func decodeDNSName(msg []byte, off int) (string, int, bool) {
+	var labels []string
+	for {
+		if off >= len(msg) {
+			return "", 0, false
+		}
+		n := int(msg[off])
+		off++
+		if n == 0 {
+			break
+		}
+		if n&0xc0 != 0 || off+n > len(msg) {
+			return "", 0, false
+		}
+		labels = append(labels, string(msg[off:off+n]))
+		off += n
+	}
+	return canonicalDNSName(strings.Join(labels, ".")), off, true
+}
+
+func dnsTypeName(t uint16) string {
+	switch t {
+	case dnsTypeA:
+		return "A"
+	case dnsTypeCNAME:
+		return "CNAME"
+	case dnsTypeTXT:
+		return "TXT"
+	case dnsTypeAAAA:
+		return "AAAA"
+	case dnsTypeSRV:
+		return "SRV"
+	}
+	return fmt.Sprintf("TYPE%d", t)
+}
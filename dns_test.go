@@ -0,0 +1,68 @@
+package testutil
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFakeDNS(t *testing.T) {
+	d := NewFakeDNS(WithLogger(t))
+	defer d.Close()
+	r := d.Resolver()
+	ctx := context.Background()
+
+	if err := d.AddA("db-1.internal", "10.0.0.1", "10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	d.AddCNAME("db.internal", "db-1.internal")
+	d.AddTXT("db.internal", "role=primary")
+	d.AddSRV("_postgres._tcp.internal", "db-1.internal", 5432, 10, 5)
+
+	addrs, err := r.LookupHost(ctx, "DB.internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(addrs)
+	if want := []string{"10.0.0.1", "10.0.0.2"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("LookupHost = %v, want %v", addrs, want)
+	}
+
+	cname, err := r.LookupCNAME(ctx, "db.internal")
+	if err != nil || cname != "db-1.internal." {
+		t.Errorf("LookupCNAME = %q, %v", cname, err)
+	}
+
+	txt, err := r.LookupTXT(ctx, "db.internal")
+	if err != nil || !reflect.DeepEqual(txt, []string{"role=primary"}) {
+		t.Errorf("LookupTXT = %q, %v", txt, err)
+	}
+
+	_, srvs, err := r.LookupSRV(ctx, "postgres", "tcp", "internal")
+	if err != nil || len(srvs) != 1 {
+		t.Fatalf("LookupSRV = %v, %v", srvs, err)
+	}
+	if got := *srvs[0]; got != (net.SRV{Target: "db-1.internal.", Port: 5432, Priority: 10, Weight: 5}) {
+		t.Errorf("SRV = %+v", got)
+	}
+
+	_, err = r.LookupHost(ctx, "missing.internal")
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+		t.Errorf("expected not found error for missing name, got %v", err)
+	}
+
+	d.Fail("db-1.internal", DNSServerFailure)
+	if _, err := r.LookupHost(ctx, "db-1.internal"); err == nil {
+		t.Error("expected lookup of failed name to fail")
+	}
+	d.Recover("db-1.internal")
+	if _, err := r.LookupHost(ctx, "db-1.internal"); err != nil {
+		t.Errorf("lookup after Recover: %v", err)
+	}
+
+	if d.QueryCount("db.internal") == 0 {
+		t.Errorf("expected queries for db.internal to be logged, got %v", d.Queries())
+	}
+}
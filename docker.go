@@ -0,0 +1,136 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/rainforestapp/testutil/waitfor"
+)
+
+// dockerImage describes the container WithDocker runs for a fake.
+type dockerImage struct {
+	image   string
+	port    int
+	env     []string
+	backend string
+}
+
+var (
+	dockerS3    = dockerImage{"localstack/localstack:3", 4566, []string{"SERVICES=s3"}, "localstack"}
+	dockerSQS   = dockerImage{"softwaremill/elasticmq-native:1", 9324, nil, "elasticmq"}
+	dockerRedis = dockerImage{"redis:7-alpine", 6379, nil, "redis"}
+)
+
+// dockerReadyTimeout is how long a container has to start serving
+// once it is running. Image pulls happen before that, in docker run.
+const dockerReadyTimeout = 60 * time.Second
+
+// container is a Docker container run by a fake.
+type container struct {
+	docker string
+	id     string
+	image  string
+
+	// follow, if set, copies the container's logs to the Logger.
+	follow *process
+}
+
+// startContainer runs img, or the image given with WithDockerImage, with
+// its port published on a random loopback port, and returns the
+// container and the host:port to reach it on.
+func startContainer(ctx context.Context, img dockerImage, o *options) (*container, string, error) {
+	docker, err := exec.LookPath("docker")
+	if err != nil {
+		return nil, "", fmt.Errorf("WithDocker needs the docker CLI: %v", err)
+	}
+	c := &container{docker: docker, image: img.image}
+	if o.dockerImage != "" {
+		c.image = o.dockerImage
+	}
+	args := []string{"run", "-d", "--rm", "-p", fmt.Sprintf("127.0.0.1::%d", img.port)}
+	for _, e := range img.env {
+		args = append(args, "-e", e)
+	}
+	out, err := c.run(ctx, append(args, c.image)...)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not start %s: %v", c.image, err)
+	}
+	c.id = strings.TrimSpace(out)
+
+	out, err = c.run(ctx, "port", c.id, fmt.Sprintf("%d/tcp", img.port))
+	if err != nil {
+		c.stop()
+		return nil, "", fmt.Errorf("could not find the port %s is published on: %v", c.image, err)
+	}
+	addr := strings.TrimSpace(strings.SplitN(out, "\n", 2)[0])
+	if logs := o.processLogger(); logs != nil {
+		c.follow, _ = startProcess(c.image, exec.Command(docker, "logs", "-f", c.id), logs)
+	}
+	return c, addr, nil
+}
+
+// run runs the docker CLI with args and returns its output, or an error
+// including what it printed to stderr.
+func (c *container) run(ctx context.Context, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, c.docker, args...).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		err = fmt.Errorf("%v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return string(out), err
+}
+
+// waitReady calls try until it succeeds, for up to dockerReadyTimeout.
+// If it never does, the error includes the end of the container's
+// logs.
+func (c *container) waitReady(ctx context.Context, try func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, dockerReadyTimeout)
+	defer cancel()
+	var lastErr error
+	err := waitfor.Context(ctx, func() bool {
+		lastErr = try()
+		return lastErr == nil
+	}, 100*time.Millisecond)
+	if err == nil {
+		return nil
+	}
+	if lastErr != nil {
+		err = fmt.Errorf("%v (last error: %v)", err, lastErr)
+	}
+	logs, _ := c.run(context.Background(), "logs", "--tail", "50", c.id)
+	return fmt.Errorf("%s was not ready: %v; logs:\n%s", c.image, err, logs)
+}
+
+// backendVersion identifies the server in the container.
+func (c *container) backendVersion(img dockerImage) BackendVersion {
+	b := BackendVersion{Name: img.backend}
+	if i := strings.LastIndex(c.image, ":"); i > strings.LastIndex(c.image, "/") {
+		b.Version = c.image[i+1:]
+	}
+	return b
+}
+
+// stop removes the container.
+func (c *container) stop() {
+	if c.follow != nil {
+		c.follow.stop()
+	}
+	c.run(context.Background(), "rm", "-f", c.id)
+}
+
+// dockerRedisReady waits for the redis container published on addr to
+// answer PING.
+func dockerRedisReady(ctx context.Context, c *container, addr string) error {
+	return c.waitReady(ctx, func() error {
+		conn, err := redis.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = conn.Do("PING")
+		return err
+	})
+}
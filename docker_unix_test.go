@@ -0,0 +1,112 @@
+//go:build !windows
+// +build !windows
+
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeDockerScript stands in for the docker CLI. run starts
+// TestHelperFake, as fakes3 for localstack images and fake_sqs
+// otherwise, using the container name as its id.
+const fakeDockerScript = `#!/bin/sh
+state=%q
+echo "$@" >> "$state/calls"
+case "$1" in
+run)
+	for a; do image=$a; done
+	case "$image" in
+	localstack*) id=fakes3; port=%d;;
+	*) id=fake_sqs; port=%d;;
+	esac
+	TESTUTIL_HELPER_FAKE=$id %q -test.run='^TestHelperFake$' -- -p $port > "$state/$id.log" 2>&1 &
+	echo $! > "$state/$id.pid"
+	echo $port > "$state/$id.port"
+	echo $id;;
+port)
+	echo "127.0.0.1:$(cat "$state/$2.port")";;
+logs)
+	if [ "$2" = -f ]; then exec tail -f "$state/$3.log"; fi
+	cat "$state/$4.log";;
+rm)
+	kill "$(cat "$state/$3.pid")";;
+esac
+`
+
+// installFakeDocker puts a docker script first in the $PATH and
+// returns the directory it records its calls in.
+func installFakeDocker(t *testing.T) string {
+	bin, state := t.TempDir(), t.TempDir()
+	s3Port, err := FreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqsPort, err := FreePort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := fmt.Sprintf(fakeDockerScript, state, s3Port, sqsPort, os.Args[0])
+	if err := ioutil.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return state
+}
+
+func TestDocker(t *testing.T) {
+	state := installFakeDocker(t)
+	logger := &logLogger{}
+	opts := []Option{WithDocker(), WithProcessLogs(), WithLogger(logger)}
+
+	s := NewFakeS3("uploads", append(opts, WithDockerImage("localstack/localstack:3.5"))...)
+	if err := s.PutString("uploads", "a.txt", "A"); err != nil {
+		t.Fatal(err)
+	}
+	if want := (BackendVersion{Name: "localstack", Version: "3.5"}); s.backend != want {
+		t.Errorf("S3 backend = %+v; want %+v", s.backend, want)
+	}
+	q := NewFakeSQS("jobs", opts...)
+	if _, err := q.SendMessage("hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(q.URL, "/jobs") || q.backend.Name != "elasticmq" {
+		t.Errorf("SQS URL %s, backend %+v", q.URL, q.backend)
+	}
+	s.Close()
+	q.Close()
+
+	calls, err := ioutil.ReadFile(filepath.Join(state, "calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"run -d --rm -p 127.0.0.1::4566 -e SERVICES=s3 localstack/localstack:3.5",
+		"run -d --rm -p 127.0.0.1::9324 " + dockerSQS.image,
+		"port fakes3 4566/tcp",
+		"rm -f fakes3",
+		"rm -f fake_sqs",
+	} {
+		if !strings.Contains(string(calls), want+"\n") {
+			t.Errorf("docker not called with %q; calls:\n%s", want, calls)
+		}
+	}
+	logs := strings.Join(logger.lines(), "\n")
+	if !strings.Contains(logs, "localstack/localstack:3.5: fakes3 listening") {
+		t.Errorf("container logs not logged; got:\n%s", logs)
+	}
+}
+
+func TestDockerNotInstalled(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	_, err := StartFakeSQS(context.Background(), "jobs", WithDocker())
+	if err == nil || !strings.Contains(err.Error(), "WithDocker needs the docker CLI") {
+		t.Errorf("StartFakeSQS without docker = %v", err)
+	}
+}
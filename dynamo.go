@@ -0,0 +1,271 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/rainforestapp/testutil/waitfor"
+)
+
+// dynamoLocalURL is where DynamoDB Local is downloaded from when it
+// isn't already installed.
+var dynamoLocalURL = "https://s3.us-west-2.amazonaws.com/dynamodb-local/dynamodb_local_latest.tar.gz"
+
+const dynamoLocalJar = "DynamoDBLocal.jar"
+
+// tablePrefixes numbers the default table prefixes handed out in this
+// process.
+var tablePrefixes int64
+
+// FakeDynamo holds a DynamoDB client for DynamoDB Local. Unless
+// WithEndpoint points it at one already running, NewFakeDynamo
+// launches its own in-memory DynamoDB Local on a free port, which
+// needs java in the $PATH, and stops it on Close.
+//
+// Tests sharing a DynamoDB Local should name their tables with Table,
+// which adds the fake's TablePrefix, so that they don't see each
+// other's tables. Close deletes every table with the prefix.
+type FakeDynamo struct {
+	// Client is a DynamoDB client set up for DynamoDB Local.
+	Client *dynamodb.DynamoDB
+
+	// Session is an AWS Session that uses the fake config.
+	Session *session.Session
+
+	// Config is the AWS config the Session was built from.
+	Config *aws.Config
+
+	// URL is DynamoDB Local's endpoint.
+	URL string
+
+	// TablePrefix is prepended to table names by Table.
+	TablePrefix string
+
+	logger     Logger
+	proc       *process
+	created    *createdResources
+	unregister func()
+}
+
+// NewFakeDynamo connects to DynamoDB Local, launching it first if no
+// endpoint is given, and returns a FakeDynamo. DynamoDB Local is looked
+// for in the directory set with WithDynamoLocal, then in
+// $DYNAMODB_LOCAL_DIR, then in the user cache directory, and is
+// downloaded there if it isn't found.
+func NewFakeDynamo(opts ...Option) *FakeDynamo {
+	return NewFakeDynamoContext(context.Background(), opts...)
+}
+
+// NewFakeDynamoContext is like NewFakeDynamo, but stops waiting for
+// DynamoDB Local as soon as ctx is done, for suites run under a
+// deadline.
+func NewFakeDynamoContext(ctx context.Context, opts ...Option) *FakeDynamo {
+	o := newOptions(opts)
+	d := &FakeDynamo{
+		logger:      o.logger,
+		TablePrefix: o.tablePrefix,
+	}
+	if d.TablePrefix == "" {
+		d.TablePrefix = fmt.Sprintf("testutil_%d_%d_", os.Getpid(), atomic.AddInt64(&tablePrefixes, 1))
+	}
+
+	d.URL = o.endpoint
+	if d.URL == "" {
+		var err error
+		if d.proc, d.URL, err = startDynamoLocal(o.dynamoLocalDir, o.processLogger()); err != nil {
+			d.logger.Fatalf("DynamoDB Local failed to start: %v", err)
+			return d
+		}
+	}
+
+	d.Config = fakeAWSConfig(d.URL, o)
+	d.Session = session.New(d.Config)
+	d.Client = dynamodb.New(d.Session)
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	ready := func() bool {
+		req, _ := d.Client.ListTablesRequest(&dynamodb.ListTablesInput{Limit: aws.Int64(1)})
+		return sendWithContext(ctx, req) == nil
+	}
+	var err error
+	if d.proc != nil {
+		err = d.proc.waitReady(ctx, ready, 100*time.Millisecond)
+	} else {
+		err = waitfor.Context(ctx, ready, 100*time.Millisecond)
+	}
+	if err != nil {
+		d.Close()
+		d.logger.Fatalf("DynamoDB Local failed to start: %v", err)
+		return d
+	}
+
+	installHooks(o, &d.Session.Handlers, &d.Client.Handlers)
+	d.created = newCreatedResources(o, dynamoCreateOps, func(r *request.Request) string {
+		return paramString(r, "TableName")
+	}, &d.Session.Handlers, &d.Client.Handlers)
+	d.unregister = OnInterrupt(d.Close)
+	return d
+}
+
+// Table returns name with the fake's TablePrefix.
+func (d *FakeDynamo) Table(name string) string {
+	return d.TablePrefix + name
+}
+
+// Close deletes the fake's tables and stops DynamoDB Local if the
+// fake launched it. With WithStrictTeardown and a shared DynamoDB
+// Local, it also reports tables that weren't deleted.
+func (d *FakeDynamo) Close() {
+	if d.unregister != nil {
+		d.unregister()
+	}
+	if d.proc != nil {
+		// Everything is in memory, so there are no tables to delete.
+		d.proc.stop()
+		return
+	}
+	if d.Client == nil {
+		return
+	}
+	tables, err := d.tables()
+	if err != nil {
+		d.logger.Logf("Could not list DynamoDB tables to clean up: %v", err)
+	}
+	for _, name := range tables {
+		if _, err := d.Client.DeleteTable(&dynamodb.DeleteTableInput{TableName: aws.String(name)}); err != nil {
+			d.logger.Logf("Could not delete DynamoDB table %s: %v", name, err)
+		}
+	}
+	if d.created != nil {
+		d.verifyTeardown()
+	}
+}
+
+// tables returns the names of the tables with the fake's prefix.
+func (d *FakeDynamo) tables() ([]string, error) {
+	var names []string
+	err := d.Client.ListTablesPages(&dynamodb.ListTablesInput{}, func(page *dynamodb.ListTablesOutput, lastPage bool) bool {
+		for _, name := range page.TableNames {
+			if strings.HasPrefix(aws.StringValue(name), d.TablePrefix) {
+				names = append(names, aws.StringValue(name))
+			}
+		}
+		return true
+	})
+	return names, err
+}
+
+// startDynamoLocal launches an in-memory DynamoDB Local from dir on a
+// free port and returns the process and its endpoint.
+func startDynamoLocal(dir string, logs Logger) (*process, string, error) {
+	java, err := exec.LookPath("java")
+	if err != nil {
+		return nil, "", fmt.Errorf("DynamoDB Local needs java: %v", err)
+	}
+	if dir, err = ensureDynamoLocal(dir); err != nil {
+		return nil, "", err
+	}
+	port, err := FreePort()
+	if err != nil {
+		return nil, "", err
+	}
+	cmd := exec.Command(java,
+		"-Djava.library.path="+filepath.Join(dir, "DynamoDBLocal_lib"),
+		"-jar", filepath.Join(dir, dynamoLocalJar),
+		"-inMemory", "-port", strconv.Itoa(port))
+	cmd.Dir = dir
+	p, err := startProcess("DynamoDB Local", cmd, logs)
+	if err != nil {
+		return nil, "", err
+	}
+	return p, fmt.Sprintf("http://127.0.0.1:%d", port), nil
+}
+
+// ensureDynamoLocal returns the directory DynamoDB Local is installed
+// in, downloading it first if necessary.
+func ensureDynamoLocal(dir string) (string, error) {
+	if dir == "" {
+		dir = os.Getenv("DYNAMODB_LOCAL_DIR")
+	}
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("no DynamoDB Local directory given and %v", err)
+		}
+		dir = filepath.Join(cache, "testutil", "dynamodb-local")
+	}
+	if _, err := os.Stat(filepath.Join(dir, dynamoLocalJar)); err == nil {
+		return dir, nil
+	}
+
+	resp, err := http.Get(dynamoLocalURL)
+	if err != nil {
+		return "", fmt.Errorf("downloading DynamoDB Local: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("downloading DynamoDB Local: %s", resp.Status)
+	}
+	archive, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("downloading DynamoDB Local: %v", err)
+	}
+	unpacked, err := Unpack(archive)
+	if err != nil {
+		return "", fmt.Errorf("unpacking DynamoDB Local: %v", err)
+	}
+	files := make(map[string]string, len(unpacked))
+	for name, body := range unpacked {
+		name = path.Clean(name)
+		if path.IsAbs(name) || strings.HasPrefix(name, "../") {
+			continue
+		}
+		files[name] = body
+	}
+	if _, ok := files[dynamoLocalJar]; !ok {
+		return "", fmt.Errorf("unpacking DynamoDB Local: no %s in %s", dynamoLocalJar, dynamoLocalURL)
+	}
+
+	// Unpack next to dir and rename it into place, so that a
+	// concurrent or interrupted download never leaves a partial
+	// install behind.
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), ".dynamodb-local")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	for name, body := range files {
+		file := filepath.Join(tmp, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(file, []byte(body), 0644); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		if _, statErr := os.Stat(filepath.Join(dir, dynamoLocalJar)); statErr == nil {
+			return dir, nil
+		}
+		return "", err
+	}
+	return dir, nil
+}
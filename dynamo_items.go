@@ -0,0 +1,178 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/rainforestapp/testutil/internal/diff"
+)
+
+// batchWriteLimit is the most items BatchWriteItem accepts at once.
+const batchWriteLimit = 25
+
+// SeedTable writes items to table. items is either a slice of structs
+// or maps, marshaled with dynamodbattribute (so dynamodbav tags
+// apply), or a JSON array of objects as a string or []byte, such as
+// the contents of a fixture file.
+func (d *FakeDynamo) SeedTable(table string, items interface{}) error {
+	avs, err := marshalItems(items)
+	if err != nil {
+		return fmt.Errorf("seeding %s: %v", table, err)
+	}
+	for start := 0; start < len(avs); start += batchWriteLimit {
+		end := start + batchWriteLimit
+		if end > len(avs) {
+			end = len(avs)
+		}
+		var requests []*dynamodb.WriteRequest
+		for _, item := range avs[start:end] {
+			requests = append(requests, &dynamodb.WriteRequest{
+				PutRequest: &dynamodb.PutRequest{Item: item},
+			})
+		}
+		pending := map[string][]*dynamodb.WriteRequest{table: requests}
+		for len(pending) > 0 {
+			out, err := d.Client.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return fmt.Errorf("seeding %s: %v", table, err)
+			}
+			pending = out.UnprocessedItems
+		}
+	}
+	return nil
+}
+
+// marshalItems converts the items given to SeedTable to DynamoDB
+// items.
+func marshalItems(items interface{}) ([]map[string]*dynamodb.AttributeValue, error) {
+	switch b := items.(type) {
+	case string:
+		items = []byte(b)
+	case json.RawMessage:
+		items = []byte(b)
+	}
+	if b, ok := items.([]byte); ok {
+		var objects []map[string]interface{}
+		if err := json.Unmarshal(b, &objects); err != nil {
+			return nil, fmt.Errorf("items are not a JSON array of objects: %v", err)
+		}
+		items = objects
+	}
+
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("items must be a slice or a JSON array, not %T", items)
+	}
+	avs := make([]map[string]*dynamodb.AttributeValue, v.Len())
+	for i := range avs {
+		item, err := dynamodbattribute.MarshalMap(v.Index(i).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %v", i, err)
+		}
+		avs[i] = item
+	}
+	return avs, nil
+}
+
+// ScanAll scans every item in table, following pagination, and
+// unmarshals them into out, which must be a pointer to a slice.
+func (d *FakeDynamo) ScanAll(table string, out interface{}) error {
+	var items []map[string]*dynamodb.AttributeValue
+	err := d.Client.ScanPages(&dynamodb.ScanInput{
+		TableName:      aws.String(table),
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		items = append(items, page.Items...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return dynamodbattribute.UnmarshalListOfMaps(items, out)
+}
+
+// AssertItem checks that the item in table with the given key, a
+// struct or map holding just the key attributes, has exactly the
+// attributes of want, which is marshaled the same way as for
+// SeedTable.
+func (d *FakeDynamo) AssertItem(t TestingT, table string, key, want interface{}) {
+	t.Helper()
+	got, ok := d.getItem(t, table, key)
+	if !ok {
+		return
+	}
+	if got == nil {
+		t.Errorf("dynamodb %s has no item with key %s", table, describeItem(key))
+		return
+	}
+	wantItem, err := dynamodbattribute.MarshalMap(want)
+	if err != nil {
+		t.Errorf("marshaling the expected item: %v", err)
+		return
+	}
+	if d := diff.Maps(itemStrings(got), itemStrings(wantItem)); d != "" {
+		t.Errorf("dynamodb %s item %s differs (- missing, + unexpected, ~ changed):\n%s", table, describeItem(key), d)
+	}
+}
+
+// AssertNoItem checks that table has no item with the given key.
+func (d *FakeDynamo) AssertNoItem(t TestingT, table string, key interface{}) {
+	t.Helper()
+	got, ok := d.getItem(t, table, key)
+	if ok && got != nil {
+		t.Errorf("dynamodb %s has an item with key %s: %v", table, describeItem(key), itemStrings(got))
+	}
+}
+
+// getItem reads the item with key from table, reporting failures to t.
+// The item is nil if there is none.
+func (d *FakeDynamo) getItem(t TestingT, table string, key interface{}) (map[string]*dynamodb.AttributeValue, bool) {
+	t.Helper()
+	k, err := dynamodbattribute.MarshalMap(key)
+	if err != nil {
+		t.Errorf("marshaling dynamodb key: %v", err)
+		return nil, false
+	}
+	out, err := d.Client.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(table),
+		Key:            k,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		t.Errorf("reading dynamodb %s item %s: %v", table, describeItem(key), err)
+		return nil, false
+	}
+	return out.Item, true
+}
+
+// itemStrings renders each attribute of item as JSON, so that items can
+// be compared with diff.Maps.
+func itemStrings(item map[string]*dynamodb.AttributeValue) map[string]string {
+	strs := make(map[string]string, len(item))
+	for name, av := range item {
+		var v interface{}
+		if err := dynamodbattribute.Unmarshal(av, &v); err != nil {
+			strs[name] = av.String()
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			strs[name] = fmt.Sprint(v)
+			continue
+		}
+		strs[name] = string(b)
+	}
+	return strs
+}
+
+func describeItem(key interface{}) string {
+	b, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Sprint(key)
+	}
+	return string(b)
+}
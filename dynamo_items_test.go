@@ -0,0 +1,103 @@
+package testutil
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// newStubDynamo returns a FakeDynamo talking to a stub that stores
+// items by their "id" attribute, and the number of BatchWriteItem
+// calls it received.
+func newStubDynamo(t *testing.T) (*FakeDynamo, *int) {
+	items := make(map[string]map[string]*dynamodb.AttributeValue)
+	batches := 0
+	type request struct {
+		TableName    string
+		Key          map[string]*dynamodb.AttributeValue
+		RequestItems map[string][]*dynamodb.WriteRequest
+	}
+	srv := httptest.NewServer(&jsonRPCHandler{
+		targetPrefix: "DynamoDB_20120810",
+		operations: map[string]func([]byte) (interface{}, error){
+			"ListTables": func([]byte) (interface{}, error) {
+				return map[string][]string{"TableNames": {}}, nil
+			},
+			"BatchWriteItem": func(body []byte) (interface{}, error) {
+				var req request
+				if err := decodeJSONRequest(body, &req); err != nil {
+					return nil, err
+				}
+				batches++
+				for _, w := range req.RequestItems["people"] {
+					items[*w.PutRequest.Item["id"].S] = w.PutRequest.Item
+				}
+				return map[string]interface{}{}, nil
+			},
+			"GetItem": func(body []byte) (interface{}, error) {
+				var req request
+				if err := decodeJSONRequest(body, &req); err != nil {
+					return nil, err
+				}
+				if item, ok := items[*req.Key["id"].S]; ok {
+					return map[string]interface{}{"Item": item}, nil
+				}
+				return map[string]interface{}{}, nil
+			},
+			"Scan": func([]byte) (interface{}, error) {
+				var all []map[string]*dynamodb.AttributeValue
+				for _, item := range items {
+					all = append(all, item)
+				}
+				return map[string]interface{}{"Items": all}, nil
+			},
+		},
+	})
+	t.Cleanup(srv.Close)
+	d := NewFakeDynamo(WithEndpoint(srv.URL), WithLogger(t))
+	t.Cleanup(d.Close)
+	return d, &batches
+}
+
+type person struct {
+	ID   string `dynamodbav:"id"`
+	Name string `dynamodbav:"name"`
+	Age  int    `dynamodbav:"age,omitempty"`
+}
+
+func TestFakeDynamoSeedAndAssert(t *testing.T) {
+	d, batches := newStubDynamo(t)
+
+	var people []person
+	for i := 0; i < 30; i++ {
+		people = append(people, person{ID: strings.Repeat("p", i+1), Name: "someone"})
+	}
+	if err := d.SeedTable("people", people); err != nil {
+		t.Fatal(err)
+	}
+	if *batches != 2 {
+		t.Errorf("wrote %d batches, want 2", *batches)
+	}
+	if err := d.SeedTable("people", `[{"id": "ada", "name": "Ada", "age": 36}]`); err != nil {
+		t.Fatal(err)
+	}
+
+	d.AssertItem(t, "people", map[string]string{"id": "ada"}, person{ID: "ada", Name: "Ada", Age: 36})
+	d.AssertNoItem(t, "people", map[string]string{"id": "bob"})
+
+	var all []person
+	if err := d.ScanAll("people", &all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 31 {
+		t.Errorf("scanned %d items, want 31", len(all))
+	}
+
+	rec := &recordingT{}
+	d.AssertItem(rec, "people", map[string]string{"id": "ada"}, person{ID: "ada", Name: "Grace"})
+	if len(rec.errors) != 1 || !strings.Contains(rec.errors[0], `~ name: "\"Ada\"", want "\"Grace\""`) || !strings.Contains(rec.errors[0], "+ age") {
+		t.Errorf("unexpected failure report: %q", rec.errors)
+	}
+}
@@ -0,0 +1,276 @@
+package testutil
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// tableThroughput is the provisioned throughput of the tables and
+// indexes CreateTableFor creates. DynamoDB Local ignores it, but the
+// API requires it.
+var tableThroughput = &dynamodb.ProvisionedThroughput{
+	ReadCapacityUnits:  aws.Int64(5),
+	WriteCapacityUnits: aws.Int64(5),
+}
+
+// CreateTableFor creates table with the keys declared by the dynamokey
+// tags of v, a struct or a pointer to one, and waits for it to become
+// active. A field tagged `dynamokey:"hash"` is the table's hash key and
+// one tagged `dynamokey:"range"` its range key. "hash:NAME" and
+// "range:NAME" declare the keys of the global secondary index NAME,
+// which projects every attribute, and a field can have several roles,
+// separated by commas:
+//
+//	type Order struct {
+//		Customer string    `dynamodbav:"customer" dynamokey:"hash"`
+//		ID       string    `dynamodbav:"id" dynamokey:"range"`
+//		Email    string    `dynamodbav:"email" dynamokey:"hash:by-email"`
+//		Placed   time.Time `dynamodbav:"placed" dynamokey:"range:by-email"`
+//	}
+//
+// Attributes are named as dynamodbattribute names them, so SeedTable
+// and ScanAll work with the same struct. Key attributes are strings,
+// numbers or binary, following the field's type.
+func (d *FakeDynamo) CreateTableFor(table string, v interface{}) error {
+	in, err := tableSchema(table, v)
+	if err != nil {
+		return fmt.Errorf("creating table %s: %v", table, err)
+	}
+	if _, err := d.Client.CreateTable(in); err != nil {
+		return fmt.Errorf("creating table %s: %v", table, err)
+	}
+	if err := d.Client.WaitUntilTableExists(&dynamodb.DescribeTableInput{TableName: aws.String(table)}); err != nil {
+		return fmt.Errorf("creating table %s: %v", table, err)
+	}
+	return nil
+}
+
+// TruncateTable deletes every item in table, keeping the table, so
+// tests sharing it can start empty without recreating it.
+func (d *FakeDynamo) TruncateTable(table string) error {
+	desc, err := d.Client.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	if err != nil {
+		return fmt.Errorf("truncating %s: %v", table, err)
+	}
+	names := make(map[string]*string)
+	var projection []string
+	for i, k := range desc.Table.KeySchema {
+		placeholder := fmt.Sprintf("#k%d", i)
+		names[placeholder] = k.AttributeName
+		projection = append(projection, placeholder)
+	}
+
+	var keys []map[string]*dynamodb.AttributeValue
+	err = d.Client.ScanPages(&dynamodb.ScanInput{
+		TableName:                aws.String(table),
+		ConsistentRead:           aws.Bool(true),
+		ProjectionExpression:     aws.String(strings.Join(projection, ", ")),
+		ExpressionAttributeNames: names,
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		keys = append(keys, page.Items...)
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("truncating %s: %v", table, err)
+	}
+
+	for start := 0; start < len(keys); start += batchWriteLimit {
+		end := start + batchWriteLimit
+		if end > len(keys) {
+			end = len(keys)
+		}
+		var requests []*dynamodb.WriteRequest
+		for _, key := range keys[start:end] {
+			requests = append(requests, &dynamodb.WriteRequest{
+				DeleteRequest: &dynamodb.DeleteRequest{Key: key},
+			})
+		}
+		pending := map[string][]*dynamodb.WriteRequest{table: requests}
+		for len(pending) > 0 {
+			out, err := d.Client.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return fmt.Errorf("truncating %s: %v", table, err)
+			}
+			pending = out.UnprocessedItems
+		}
+	}
+	return nil
+}
+
+// Reset deletes every item in the tables with the fake's TablePrefix.
+func (d *FakeDynamo) Reset() {
+	tables, err := d.tables()
+	if err != nil {
+		d.logger.Errorf("Error resetting DynamoDB tables: %v", err)
+		return
+	}
+	for _, table := range tables {
+		if err := d.TruncateTable(table); err != nil {
+			d.logger.Errorf("Error resetting DynamoDB tables: %v", err)
+			return
+		}
+	}
+}
+
+// tableSchema returns the CreateTable request for table with the keys
+// declared by the dynamokey tags of v.
+func tableSchema(table string, v interface{}) (*dynamodb.CreateTableInput, error) {
+	typ := reflect.TypeOf(v)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("keys must be declared by a struct, not %T", v)
+	}
+
+	// Keys are gathered by index, "" being the table itself, in the
+	// order the indexes are first seen.
+	keys := map[string][]*dynamodb.KeySchemaElement{}
+	var indexes []string
+	attrTypes := map[string]string{}
+	var attrs []string
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag := f.Tag.Get("dynamokey")
+		if tag == "" {
+			continue
+		}
+		name := attributeName(f)
+		if name == "" {
+			return nil, fmt.Errorf("field %s is a key but is not marshaled", f.Name)
+		}
+		attrType, err := keyAttributeType(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range strings.Split(tag, ",") {
+			keyType, index := role, ""
+			if i := strings.Index(role, ":"); i >= 0 {
+				keyType, index = role[:i], role[i+1:]
+			}
+			keyType = strings.ToUpper(keyType)
+			if keyType != dynamodb.KeyTypeHash && keyType != dynamodb.KeyTypeRange {
+				return nil, fmt.Errorf("field %s: dynamokey role %q is not hash or range", f.Name, role)
+			}
+			if _, ok := keys[index]; !ok && index != "" {
+				indexes = append(indexes, index)
+			}
+			keys[index] = append(keys[index], &dynamodb.KeySchemaElement{
+				AttributeName: aws.String(name),
+				KeyType:       aws.String(keyType),
+			})
+		}
+		if _, ok := attrTypes[name]; !ok {
+			attrs = append(attrs, name)
+		}
+		attrTypes[name] = attrType
+	}
+
+	in := &dynamodb.CreateTableInput{
+		TableName:             aws.String(table),
+		ProvisionedThroughput: tableThroughput,
+	}
+	var err error
+	if in.KeySchema, err = keySchema("", keys[""]); err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		schema, err := keySchema(index, keys[index])
+		if err != nil {
+			return nil, err
+		}
+		in.GlobalSecondaryIndexes = append(in.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndex{
+			IndexName:             aws.String(index),
+			KeySchema:             schema,
+			Projection:            &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+			ProvisionedThroughput: tableThroughput,
+		})
+	}
+	for _, name := range attrs {
+		in.AttributeDefinitions = append(in.AttributeDefinitions, &dynamodb.AttributeDefinition{
+			AttributeName: aws.String(name),
+			AttributeType: aws.String(attrTypes[name]),
+		})
+	}
+	return in, nil
+}
+
+// keySchema checks and orders the keys of a table, or of index if it
+// isn't empty: one hash key, then at most one range key.
+func keySchema(index string, keys []*dynamodb.KeySchemaElement) ([]*dynamodb.KeySchemaElement, error) {
+	what := "the table"
+	if index != "" {
+		what = "index " + index
+	}
+	var hash, rng []*dynamodb.KeySchemaElement
+	for _, k := range keys {
+		if aws.StringValue(k.KeyType) == dynamodb.KeyTypeHash {
+			hash = append(hash, k)
+		} else {
+			rng = append(rng, k)
+		}
+	}
+	if len(hash) != 1 || len(rng) > 1 {
+		return nil, fmt.Errorf("%s must have one hash key and at most one range key, not %d and %d", what, len(hash), len(rng))
+	}
+	return append(hash, rng...), nil
+}
+
+// attributeName returns the name dynamodbattribute gives the attribute
+// for f, or "" if f isn't marshaled.
+func attributeName(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return ""
+	}
+	for _, key := range []string{"dynamodbav", "json"} {
+		tag := f.Tag.Get(key)
+		if tag == "" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+		break
+	}
+	return f.Name
+}
+
+// timeType is marshaled by dynamodbattribute as an RFC 3339 string.
+var timeType = reflect.TypeOf(time.Time{})
+
+// keyAttributeType returns the DynamoDB type of the key attribute for
+// f: S, N or B.
+func keyAttributeType(f reflect.StructField) (string, error) {
+	if strings.Contains(f.Tag.Get("dynamodbav"), ",string") {
+		return dynamodb.ScalarAttributeTypeS, nil
+	}
+	t := f.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return dynamodb.ScalarAttributeTypeS, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return dynamodb.ScalarAttributeTypeS, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return dynamodb.ScalarAttributeTypeN, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return dynamodb.ScalarAttributeTypeB, nil
+		}
+	}
+	return "", fmt.Errorf("field %s of type %s can't be a key", f.Name, f.Type)
+}
@@ -0,0 +1,166 @@
+package testutil
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type orderRow struct {
+	Customer string    `dynamodbav:"customer" dynamokey:"hash"`
+	ID       int64     `json:"id" dynamokey:"range"`
+	Email    string    `dynamodbav:"email,omitempty" dynamokey:"hash:by-email"`
+	Placed   time.Time `dynamokey:"range:by-email,hash:by-time"`
+	Note     string    `dynamodbav:"note"`
+}
+
+func TestTableSchema(t *testing.T) {
+	in, err := tableSchema("orders", &orderRow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := func(keys []*dynamodb.KeySchemaElement) string {
+		var s []string
+		for _, k := range keys {
+			s = append(s, aws.StringValue(k.AttributeName)+" "+aws.StringValue(k.KeyType))
+		}
+		return strings.Join(s, ", ")
+	}
+	if got := schema(in.KeySchema); got != "customer HASH, id RANGE" {
+		t.Errorf("key schema = %s", got)
+	}
+	if len(in.GlobalSecondaryIndexes) != 2 {
+		t.Fatalf("indexes = %v", in.GlobalSecondaryIndexes)
+	}
+	for i, want := range []string{"by-email: email HASH, Placed RANGE", "by-time: Placed HASH"} {
+		gsi := in.GlobalSecondaryIndexes[i]
+		if got := aws.StringValue(gsi.IndexName) + ": " + schema(gsi.KeySchema); got != want {
+			t.Errorf("index %d = %s, want %s", i, got, want)
+		}
+	}
+	var defs []string
+	for _, a := range in.AttributeDefinitions {
+		defs = append(defs, aws.StringValue(a.AttributeName)+" "+aws.StringValue(a.AttributeType))
+	}
+	if got := strings.Join(defs, ", "); got != "customer S, id N, email S, Placed S" {
+		t.Errorf("attribute definitions = %s", got)
+	}
+}
+
+func TestTableSchemaErrors(t *testing.T) {
+	for _, tc := range []struct {
+		v    interface{}
+		want string
+	}{
+		{"orders", "keys must be declared by a struct"},
+		{struct {
+			A string `dynamokey:"range"`
+		}{}, "the table must have one hash key"},
+		{struct {
+			A string `dynamokey:"hash"`
+			B string `dynamokey:"hash"`
+		}{}, "the table must have one hash key"},
+		{struct {
+			A string `dynamokey:"primary"`
+		}{}, `dynamokey role "primary" is not hash or range`},
+		{struct {
+			A bool `dynamokey:"hash"`
+		}{}, "field A of type bool can't be a key"},
+		{struct {
+			A string `dynamodbav:"-" dynamokey:"hash"`
+		}{}, "field A is a key but is not marshaled"},
+		{struct {
+			A string `dynamokey:"hash"`
+			B string `dynamokey:"range:by-b"`
+		}{}, "index by-b must have one hash key"},
+	} {
+		if _, err := tableSchema("t", tc.v); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("tableSchema(%#v) = %v, want %q", tc.v, err, tc.want)
+		}
+	}
+}
+
+func TestFakeDynamoCreateAndTruncate(t *testing.T) {
+	var (
+		created *dynamodb.CreateTableInput
+		items   = map[string]map[string]*dynamodb.AttributeValue{}
+		deletes int
+	)
+	type request struct {
+		TableName            string
+		ProjectionExpression string
+		RequestItems         map[string][]*dynamodb.WriteRequest
+	}
+	srv := httptest.NewServer(&jsonRPCHandler{
+		targetPrefix: "DynamoDB_20120810",
+		operations: map[string]func([]byte) (interface{}, error){
+			"ListTables": func([]byte) (interface{}, error) {
+				return map[string][]string{"TableNames": {}}, nil
+			},
+			"CreateTable": func(body []byte) (interface{}, error) {
+				created = &dynamodb.CreateTableInput{}
+				return map[string]interface{}{}, decodeJSONRequest(body, created)
+			},
+			"DescribeTable": func([]byte) (interface{}, error) {
+				return map[string]interface{}{"Table": map[string]interface{}{
+					"TableStatus": "ACTIVE",
+					"KeySchema":   created.KeySchema,
+				}}, nil
+			},
+			"Scan": func(body []byte) (interface{}, error) {
+				var req request
+				if err := decodeJSONRequest(body, &req); err != nil {
+					return nil, err
+				}
+				if req.ProjectionExpression != "#k0, #k1" {
+					return nil, fmt.Errorf("scanned with projection %q", req.ProjectionExpression)
+				}
+				var all []map[string]*dynamodb.AttributeValue
+				for _, item := range items {
+					all = append(all, item)
+				}
+				return map[string]interface{}{"Items": all}, nil
+			},
+			"BatchWriteItem": func(body []byte) (interface{}, error) {
+				var req request
+				if err := decodeJSONRequest(body, &req); err != nil {
+					return nil, err
+				}
+				for _, w := range req.RequestItems["orders"] {
+					deletes++
+					delete(items, *w.DeleteRequest.Key["customer"].S+"/"+*w.DeleteRequest.Key["id"].N)
+				}
+				return map[string]interface{}{}, nil
+			},
+		},
+	})
+	defer srv.Close()
+	d := NewFakeDynamo(WithEndpoint(srv.URL), WithLogger(t))
+	defer d.Close()
+
+	if err := d.CreateTableFor("orders", orderRow{}); err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(created.TableName) != "orders" || len(created.GlobalSecondaryIndexes) != 2 {
+		t.Errorf("created %v", created)
+	}
+
+	for i := 0; i < 30; i++ {
+		id := fmt.Sprint(i)
+		items["ada/"+id] = map[string]*dynamodb.AttributeValue{
+			"customer": {S: aws.String("ada")},
+			"id":       {N: aws.String(id)},
+		}
+	}
+	if err := d.TruncateTable("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 || deletes != 30 {
+		t.Errorf("%d items left after %d deletes", len(items), deletes)
+	}
+}
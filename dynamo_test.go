@@ -0,0 +1,80 @@
+package testutil
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEnsureDynamoLocalDownloads(t *testing.T) {
+	downloads := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		w.Write(TarGz(map[string]string{
+			"./DynamoDBLocal.jar":                 "jar",
+			"DynamoDBLocal_lib/libsqlite4java.so": "lib",
+			"../escape":                           "nope",
+		}))
+	}))
+	defer srv.Close()
+	defer func(u string) { dynamoLocalURL = u }(dynamoLocalURL)
+	dynamoLocalURL = srv.URL
+
+	dir := filepath.Join(t.TempDir(), "dynamodb-local")
+	for i := 0; i < 2; i++ {
+		got, err := ensureDynamoLocal(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != dir {
+			t.Errorf("installed in %s, want %s", got, dir)
+		}
+	}
+	if downloads != 1 {
+		t.Errorf("downloaded %d times, want once", downloads)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "DynamoDBLocal_lib", "libsqlite4java.so")); err != nil || string(b) != "lib" {
+		t.Errorf("library = %q, %v", b, err)
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(dir, "..", "escape")); err == nil {
+		t.Error("archive entry escaped the install directory")
+	}
+}
+
+func TestFakeDynamoCloseDeletesPrefixedTables(t *testing.T) {
+	var deleted []string
+	srv := httptest.NewServer(&jsonRPCHandler{
+		targetPrefix: "DynamoDB_20120810",
+		operations: map[string]func([]byte) (interface{}, error){
+			"ListTables": func([]byte) (interface{}, error) {
+				return map[string][]string{"TableNames": {"ci_orders", "other_orders", "ci_users"}}, nil
+			},
+			"DeleteTable": func(body []byte) (interface{}, error) {
+				var req struct{ TableName string }
+				decodeJSONRequest(body, &req)
+				deleted = append(deleted, req.TableName)
+				return struct{}{}, nil
+			},
+		},
+	})
+	defer srv.Close()
+
+	d := NewFakeDynamo(WithEndpoint(srv.URL), WithTablePrefix("ci_"), WithLogger(t))
+	if got := d.Table("orders"); got != "ci_orders" {
+		t.Errorf("Table = %q", got)
+	}
+	d.Close()
+	if want := []string{"ci_orders", "ci_users"}; !reflect.DeepEqual(deleted, want) {
+		t.Errorf("deleted %q, want %q", deleted, want)
+	}
+
+	a, b := NewFakeDynamo(WithEndpoint(srv.URL)), NewFakeDynamo(WithEndpoint(srv.URL))
+	defer a.Close()
+	defer b.Close()
+	if a.TablePrefix == b.TablePrefix {
+		t.Errorf("default table prefixes are both %q", a.TablePrefix)
+	}
+}
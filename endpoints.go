@@ -0,0 +1,132 @@
+package testutil
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// InstallFakeEndpoints makes every client built from cfg send its
+// requests for the given services to the fakes instead, leaving other
+// services untouched. endpoints maps service signing names, such as
+// "s3", "sqs", "kms", "dynamodb" or "events", to fake endpoints, for
+// example
+//
+//	testutil.InstallFakeEndpoints(cfg, map[string]string{
+//		"sqs": aws.StringValue(fakeSQS.Config.Endpoint),
+//		"kms": fakeKMS.URL,
+//	})
+//
+// Requests are redirected by cfg's HTTP client, after the SDK has
+// chosen its usual endpoint and signed the request, so application
+// code that builds its own sessions and clients from cfg needs no
+// changes. S3 requests addressed virtual-hosted style are rewritten to
+// path style. If cfg is nil a new config is returned. It panics if an
+// endpoint isn't a valid URL.
+func InstallFakeEndpoints(cfg *aws.Config, endpoints map[string]string) *aws.Config {
+	if cfg == nil {
+		cfg = &aws.Config{}
+	}
+	t := &endpointTransport{endpoints: make(map[string]*url.URL, len(endpoints))}
+	for service, endpoint := range endpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			panic(fmt.Sprintf("InstallFakeEndpoints: invalid endpoint %q for %s", endpoint, service))
+		}
+		t.endpoints[service] = u
+	}
+
+	client := http.Client{}
+	if cfg.HTTPClient != nil {
+		client = *cfg.HTTPClient
+	}
+	t.base = client.Transport
+	if t.base == nil {
+		t.base = http.DefaultTransport
+	}
+	client.Transport = t
+	cfg.HTTPClient = &client
+	return cfg
+}
+
+// endpointTransport sends requests for some services to other
+// endpoints.
+type endpointTransport struct {
+	base      http.RoundTripper
+	endpoints map[string]*url.URL
+}
+
+func (t *endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	service := requestService(req)
+	endpoint, ok := t.endpoints[service]
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+
+	// The SDK sets Opaque to "//host/path" to control how paths are
+	// escaped, so the path is rebuilt in that form.
+	u := *req.URL
+	path := u.EscapedPath()
+	if u.Opaque != "" {
+		path = strings.TrimPrefix(u.Opaque, "//"+u.Host)
+	}
+	if service == "s3" {
+		if bucket := virtualHostedBucket(u.Host); bucket != "" {
+			path = "/" + bucket + path
+		}
+	}
+	u.Scheme, u.Host = endpoint.Scheme, endpoint.Host
+	u.Opaque = "//" + u.Host + strings.TrimSuffix(endpoint.EscapedPath(), "/") + path
+
+	redirected := new(http.Request)
+	*redirected = *req
+	redirected.URL = &u
+	redirected.Host = u.Host
+	return t.base.RoundTrip(redirected)
+}
+
+// requestService returns the name of the service req is for, taken
+// from the credential scope of its signature, or from its host for
+// unsigned requests.
+func requestService(req *http.Request) string {
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		if i := strings.Index(auth, "Credential="); i >= 0 {
+			scope := auth[i+len("Credential="):]
+			if j := strings.IndexAny(scope, ", "); j >= 0 {
+				scope = scope[:j]
+			}
+			// AKID/date/region/service/aws4_request
+			if parts := strings.Split(scope, "/"); len(parts) == 5 {
+				return parts[3]
+			}
+		}
+	}
+	host := req.URL.Hostname()
+	if virtualHostedBucket(req.URL.Host) != "" {
+		return "s3"
+	}
+	if i := strings.IndexByte(host, '.'); i >= 0 {
+		host = host[:i]
+	}
+	if host == "s3" || strings.HasPrefix(host, "s3-") {
+		return "s3"
+	}
+	return host
+}
+
+// virtualHostedBucket returns the bucket in an S3 virtual-hosted style
+// host, such as "bucket.s3.amazonaws.com", or "" if host is not one.
+func virtualHostedBucket(host string) string {
+	if i := strings.LastIndexByte(host, ':'); i >= 0 {
+		host = host[:i]
+	}
+	for _, marker := range []string{".s3.", ".s3-"} {
+		if i := strings.Index(host, marker); i > 0 && strings.HasSuffix(host, ".amazonaws.com") {
+			return host[:i]
+		}
+	}
+	return ""
+}
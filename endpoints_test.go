@@ -0,0 +1,70 @@
+package testutil
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// refusingTransport fails requests to anywhere but the local fakes,
+// recording their hosts.
+type refusingTransport struct {
+	hosts []string
+}
+
+func (t *refusingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Hostname() == "127.0.0.1" {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	t.hosts = append(t.hosts, req.URL.Host)
+	return nil, errors.New("refused")
+}
+
+func TestInstallFakeEndpoints(t *testing.T) {
+	k := NewFakeKMS()
+	defer k.Close()
+	key := k.CreateKey("")
+
+	var s3Paths []string
+	fakeS3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s3Paths = append(s3Paths, r.URL.Path)
+	}))
+	defer fakeS3.Close()
+
+	base := &refusingTransport{}
+	cfg := InstallFakeEndpoints(&aws.Config{
+		Region:      aws.String("us-west-2"),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+		HTTPClient:  &http.Client{Transport: base},
+		MaxRetries:  aws.Int(0),
+	}, map[string]string{
+		"kms": k.URL,
+		"s3":  fakeS3.URL + "/",
+	})
+	sess := session.New(cfg)
+
+	if _, err := kms.New(sess).Encrypt(&kms.EncryptInput{KeyId: aws.String(key), Plaintext: []byte("x")}); err != nil {
+		t.Errorf("kms request was not sent to the fake: %v", err)
+	}
+	if _, err := s3.New(sess).PutObject(&s3.PutObjectInput{Bucket: aws.String("uploads"), Key: aws.String("a/b.txt")}); err != nil {
+		t.Errorf("s3 request was not sent to the fake: %v", err)
+	}
+	if want := "/uploads/a/b.txt"; len(s3Paths) != 1 || s3Paths[0] != want {
+		t.Errorf("s3 paths = %q, want [%q]", s3Paths, want)
+	}
+
+	if _, err := sqs.New(sess).ListQueues(&sqs.ListQueuesInput{}); err == nil {
+		t.Error("sqs request unexpectedly succeeded")
+	}
+	if want := "sqs.us-west-2.amazonaws.com"; len(base.hosts) != 1 || base.hosts[0] != want {
+		t.Errorf("requests left alone went to %q, want [%q]", base.hosts, want)
+	}
+}
@@ -0,0 +1,500 @@
+package testutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/garyburd/redigo/redis"
+)
+
+// EnvSpec declares the resources an Environment provisions.
+type EnvSpec struct {
+	Buckets []BucketSpec
+	Queues  []QueueSpec
+	Topics  []TopicSpec
+	Redis   []RedisSpec
+
+	// S3Options, SQSOptions and RedisOptions are passed to the
+	// respective fakes, after the options given to NewEnvironment, for
+	// settings such as WithEndpoint that only make sense for one of
+	// them.
+	S3Options    []Option
+	SQSOptions   []Option
+	RedisOptions []Option
+}
+
+// BucketSpec declares an S3 bucket.
+type BucketSpec struct {
+	Name string
+
+	// Objects seeds the bucket, mapping keys to contents.
+	Objects map[string]string
+}
+
+// QueueSpec declares an SQS queue.
+type QueueSpec struct {
+	Name string
+
+	// DeadLetter names another queue in the spec that messages move to
+	// after MaxReceiveCount receives (default 3).
+	DeadLetter      string
+	MaxReceiveCount int
+
+	// Attributes are passed to CreateQueue, as with
+	// WithQueueAttributes.
+	Attributes map[string]string
+
+	// Messages seeds the queue with these bodies.
+	Messages []string
+}
+
+// TopicSpec declares a topic that fans messages out to queues, as an
+// SNS topic with SQS subscriptions does.
+type TopicSpec struct {
+	Name string
+
+	// Subscribers names the queues in the spec subscribed to the topic.
+	Subscribers []string
+
+	// RawDelivery delivers bare messages instead of SNS notification
+	// envelopes.
+	RawDelivery bool
+}
+
+// RedisSpec declares a redis namespace: a key prefix of Namespace + ":".
+type RedisSpec struct {
+	Namespace string
+
+	// Seed sets these keys, without the namespace prefix, to string
+	// values.
+	Seed map[string]string
+}
+
+// Environment is a set of fake resources provisioned from an EnvSpec,
+// like a small CloudFormation stack for tests. Close tears everything
+// down again.
+type Environment struct {
+	// S3 serves every bucket. It is nil if the spec has no buckets.
+	S3 *FakeS3
+
+	// Redis holds every namespace. It is nil if the spec has no redis
+	// namespaces.
+	Redis *FakeRedis
+
+	logger  Logger
+	spec    EnvSpec
+	buckets map[string]bool
+	queues  map[string]*FakeSQS
+	order   []string
+	topics  map[string]*EnvTopic
+	spaces  map[string]bool
+}
+
+// NewEnvironment provisions the resources in spec, seeding them with
+// any fixtures given. opts are passed to every fake the environment
+// needs. If provisioning fails, whatever was created is torn down and
+// the error is returned.
+func NewEnvironment(spec EnvSpec, opts ...Option) (*Environment, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	e := &Environment{
+		logger:  newOptions(opts).logger,
+		spec:    spec,
+		buckets: make(map[string]bool),
+		queues:  make(map[string]*FakeSQS),
+		topics:  make(map[string]*EnvTopic),
+		spaces:  make(map[string]bool),
+	}
+	if err := e.provision(spec, opts); err != nil {
+		e.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// validate checks that names are unique and references resolve.
+func (spec EnvSpec) validate() error {
+	seen := make(map[string]bool)
+	unique := func(kind, name string) error {
+		if name == "" {
+			return fmt.Errorf("environment: a %s has no name", kind)
+		}
+		if seen[kind+" "+name] {
+			return fmt.Errorf("environment: %s %s is declared twice", kind, name)
+		}
+		seen[kind+" "+name] = true
+		return nil
+	}
+	for _, b := range spec.Buckets {
+		if err := unique("bucket", b.Name); err != nil {
+			return err
+		}
+	}
+	for _, q := range spec.Queues {
+		if err := unique("queue", q.Name); err != nil {
+			return err
+		}
+	}
+	for _, q := range spec.Queues {
+		if q.DeadLetter == "" {
+			continue
+		}
+		if !seen["queue "+q.DeadLetter] {
+			return fmt.Errorf("environment: queue %s: dead-letter queue %s is not declared", q.Name, q.DeadLetter)
+		}
+		if q.DeadLetter == q.Name {
+			return fmt.Errorf("environment: queue %s is its own dead-letter queue", q.Name)
+		}
+	}
+	for _, t := range spec.Topics {
+		if err := unique("topic", t.Name); err != nil {
+			return err
+		}
+		for _, sub := range t.Subscribers {
+			if !seen["queue "+sub] {
+				return fmt.Errorf("environment: topic %s: subscriber queue %s is not declared", t.Name, sub)
+			}
+		}
+	}
+	for _, r := range spec.Redis {
+		if err := unique("redis namespace", r.Namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Environment) provision(spec EnvSpec, opts []Option) error {
+	if len(spec.Buckets) > 0 {
+		fake, err := StartFakeS3(context.Background(), spec.Buckets[0].Name, append(append([]Option(nil), opts...), spec.S3Options...)...)
+		if err != nil {
+			return fmt.Errorf("environment: %v", err)
+		}
+		e.S3 = fake
+		for i, b := range spec.Buckets {
+			if i > 0 {
+				if _, err := e.S3.Client.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(b.Name)}); err != nil {
+					return fmt.Errorf("environment: creating bucket %s: %v", b.Name, err)
+				}
+			}
+			e.buckets[b.Name] = true
+		}
+	}
+
+	// Dead-letter queues are created before the queues that use them,
+	// which need their ARNs.
+	specs := make(map[string]QueueSpec, len(spec.Queues))
+	for _, q := range spec.Queues {
+		specs[q.Name] = q
+	}
+	var create func(q QueueSpec, path []string) error
+	create = func(q QueueSpec, path []string) error {
+		if _, ok := e.queues[q.Name]; ok {
+			return nil
+		}
+		for _, p := range path {
+			if p == q.Name {
+				return fmt.Errorf("environment: dead-letter queues form a cycle: %s", strings.Join(append(path, q.Name), " -> "))
+			}
+		}
+		attrs := make(map[string]string, len(q.Attributes)+1)
+		for k, v := range q.Attributes {
+			attrs[k] = v
+		}
+		if q.DeadLetter != "" {
+			if err := create(specs[q.DeadLetter], append(path, q.Name)); err != nil {
+				return err
+			}
+			arn, err := e.queueARN(e.queues[q.DeadLetter])
+			if err != nil {
+				return fmt.Errorf("environment: queue %s: %v", q.DeadLetter, err)
+			}
+			max := q.MaxReceiveCount
+			if max == 0 {
+				max = 3
+			}
+			policy, _ := json.Marshal(map[string]string{
+				"deadLetterTargetArn": arn,
+				"maxReceiveCount":     strconv.Itoa(max),
+			})
+			attrs["RedrivePolicy"] = string(policy)
+		}
+		qopts := append(append(append([]Option(nil), opts...), spec.SQSOptions...), WithQueueAttributes(attrs))
+		fake, err := StartFakeSQS(context.Background(), q.Name, qopts...)
+		if err != nil {
+			return fmt.Errorf("environment: queue %s: %v", q.Name, err)
+		}
+		e.queues[q.Name] = fake
+		e.order = append(e.order, q.Name)
+		return nil
+	}
+	for _, q := range spec.Queues {
+		if err := create(q, nil); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range spec.Topics {
+		topic := &EnvTopic{
+			Name: t.Name,
+			ARN:  fmt.Sprintf("arn:aws:sns:%s:%s:%s", defaultRegion, fakeAccountID, t.Name),
+			raw:  t.RawDelivery,
+		}
+		for _, sub := range t.Subscribers {
+			topic.subscribers = append(topic.subscribers, e.queues[sub])
+		}
+		e.topics[t.Name] = topic
+	}
+
+	if len(spec.Redis) > 0 {
+		r, err := StartFakeRedis(append(append([]Option(nil), opts...), spec.RedisOptions...)...)
+		if err != nil {
+			return fmt.Errorf("environment: %v", err)
+		}
+		e.Redis = r
+		for _, r := range spec.Redis {
+			e.spaces[r.Namespace] = true
+		}
+	}
+	return e.seed()
+}
+
+// seed stores the objects, messages and redis keys the spec declares.
+func (e *Environment) seed() error {
+	for _, b := range e.spec.Buckets {
+		for key, body := range b.Objects {
+			if err := e.S3.PutString(b.Name, key, body); err != nil {
+				return fmt.Errorf("environment: seeding s3://%s/%s: %v", b.Name, key, err)
+			}
+		}
+	}
+	for _, q := range e.spec.Queues {
+		for _, body := range q.Messages {
+			if _, err := e.queues[q.Name].SendMessage(body, nil); err != nil {
+				return fmt.Errorf("environment: seeding queue %s: %v", q.Name, err)
+			}
+		}
+	}
+	if len(e.spec.Redis) == 0 {
+		return nil
+	}
+	conn := e.Redis.Pool.Get()
+	defer conn.Close()
+	for _, r := range e.spec.Redis {
+		for key, value := range r.Seed {
+			if _, err := conn.Do("SET", e.RedisKey(r.Namespace, key), value); err != nil {
+				return fmt.Errorf("environment: seeding redis namespace %s: %v", r.Namespace, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Reset returns every resource to the state the spec declares, so
+// that tests sharing an Environment start from the same fixtures:
+// buckets are emptied, queues are purged and redis namespaces are
+// cleared, and then they are seeded again.
+func (e *Environment) Reset() error {
+	for _, b := range e.spec.Buckets {
+		bucket := e.Bucket(b.Name)
+		keys, err := bucket.Keys("")
+		if err != nil {
+			return fmt.Errorf("environment: emptying bucket %s: %v", b.Name, err)
+		}
+		for _, key := range keys {
+			if _, err := e.S3.Client.DeleteObject(&s3.DeleteObjectInput{Bucket: &bucket.Name, Key: aws.String(key)}); err != nil {
+				return fmt.Errorf("environment: emptying bucket %s: %v", b.Name, err)
+			}
+		}
+	}
+	for _, name := range e.order {
+		q := e.queues[name]
+		if _, err := q.Client.PurgeQueue(&sqs.PurgeQueueInput{QueueUrl: &q.URL}); err != nil {
+			return fmt.Errorf("environment: purging queue %s: %v", name, err)
+		}
+	}
+	if len(e.spec.Redis) > 0 {
+		conn := e.Redis.Pool.Get()
+		defer conn.Close()
+		for _, r := range e.spec.Redis {
+			keys, err := redis.Strings(conn.Do("KEYS", e.RedisKey(r.Namespace, "*")))
+			if err == nil && len(keys) > 0 {
+				_, err = conn.Do("DEL", redis.Args{}.AddFlat(keys)...)
+			}
+			if err != nil {
+				return fmt.Errorf("environment: clearing redis namespace %s: %v", r.Namespace, err)
+			}
+		}
+	}
+	return e.seed()
+}
+
+func (e *Environment) queueARN(q *FakeSQS) (string, error) {
+	out, err := q.Client.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       &q.URL,
+		AttributeNames: aws.StringSlice([]string{"QueueArn"}),
+	})
+	if err != nil {
+		return "", err
+	}
+	if arn := aws.StringValue(out.Attributes["QueueArn"]); arn != "" {
+		return arn, nil
+	}
+	return fmt.Sprintf("arn:aws:sqs:%s:%s:%s", defaultRegion, fakeAccountID, queueNameFromURL(q.URL)), nil
+}
+
+// Bucket returns the bucket name. It panics if the spec has no such
+// bucket.
+func (e *Environment) Bucket(name string) EnvBucket {
+	if !e.buckets[name] {
+		panic(fmt.Sprintf("environment: no bucket named %s", name))
+	}
+	return EnvBucket{S3: e.S3, Name: name}
+}
+
+// Queue returns the queue name. It panics if the spec has no such
+// queue.
+func (e *Environment) Queue(name string) *FakeSQS {
+	q, ok := e.queues[name]
+	if !ok {
+		panic(fmt.Sprintf("environment: no queue named %s", name))
+	}
+	return q
+}
+
+// Topic returns the topic name. It panics if the spec has no such
+// topic.
+func (e *Environment) Topic(name string) *EnvTopic {
+	t, ok := e.topics[name]
+	if !ok {
+		panic(fmt.Sprintf("environment: no topic named %s", name))
+	}
+	return t
+}
+
+// RedisKey returns key in the redis namespace. It panics if the spec
+// has no such namespace.
+func (e *Environment) RedisKey(namespace, key string) string {
+	if !e.spaces[namespace] {
+		panic(fmt.Sprintf("environment: no redis namespace %s", namespace))
+	}
+	return namespace + ":" + key
+}
+
+// Close tears down every resource: queues are deleted, buckets are
+// emptied and deleted, and the fakes are closed. Failures are reported
+// to the Logger.
+func (e *Environment) Close() {
+	for i := len(e.order) - 1; i >= 0; i-- {
+		q := e.queues[e.order[i]]
+		if _, err := q.Client.DeleteQueue(&sqs.DeleteQueueInput{QueueUrl: &q.URL}); err != nil {
+			e.logger.Logf("environment: deleting queue %s: %v", e.order[i], err)
+		}
+		q.Close()
+	}
+	if e.S3 != nil {
+		for name := range e.buckets {
+			if err := e.Bucket(name).destroy(); err != nil {
+				e.logger.Logf("environment: deleting bucket %s: %v", name, err)
+			}
+		}
+		e.S3.Close()
+	}
+	if e.Redis != nil {
+		e.Redis.Close()
+	}
+}
+
+// EnvBucket is a bucket in an Environment.
+type EnvBucket struct {
+	S3   *FakeS3
+	Name string
+}
+
+// PutString stores s as the object key.
+func (b EnvBucket) PutString(key, s string) error {
+	return b.S3.PutString(b.Name, key, s)
+}
+
+// GetString returns the contents of the object key.
+func (b EnvBucket) GetString(key string) (string, error) {
+	return b.S3.GetString(b.Name, key)
+}
+
+// Keys returns the keys of the objects in the bucket starting with
+// prefix.
+func (b EnvBucket) Keys(prefix string) ([]string, error) {
+	return b.S3.listKeys(b.Name, prefix)
+}
+
+func (b EnvBucket) destroy() error {
+	keys, err := b.Keys("")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, err := b.S3.Client.DeleteObject(&s3.DeleteObjectInput{Bucket: &b.Name, Key: aws.String(key)}); err != nil {
+			return err
+		}
+	}
+	_, err = b.S3.Client.DeleteBucket(&s3.DeleteBucketInput{Bucket: &b.Name})
+	return err
+}
+
+// EnvTopic is a topic in an Environment. Publishing to it sends the
+// message to every subscribed queue.
+type EnvTopic struct {
+	Name string
+	ARN  string
+
+	raw         bool
+	subscribers []*FakeSQS
+}
+
+// Publish sends message, with the given string attributes, to every
+// subscriber, wrapped in an SNS notification envelope unless the topic
+// uses raw delivery. It returns the message ID.
+func (t *EnvTopic) Publish(message string, attrs map[string]string) (string, error) {
+	id := randomUUID()
+	body := message
+	if !t.raw {
+		n := SNSMessage{
+			Type:             "Notification",
+			MessageID:        id,
+			TopicArn:         t.ARN,
+			Message:          message,
+			Timestamp:        time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+			SignatureVersion: "1",
+		}
+		if len(attrs) > 0 {
+			n.MessageAttributes = make(map[string]SNSMessageAttribute, len(attrs))
+			for k, v := range attrs {
+				n.MessageAttributes[k] = SNSMessageAttribute{Type: "String", Value: v}
+			}
+		}
+		b, err := json.Marshal(n)
+		if err != nil {
+			return "", err
+		}
+		body = string(b)
+	}
+
+	var msgAttrs map[string]string
+	if t.raw {
+		msgAttrs = attrs
+	}
+	for _, q := range t.subscribers {
+		if _, err := q.SendMessage(body, msgAttrs); err != nil {
+			return "", fmt.Errorf("publishing to %s: delivering to %s: %v", t.Name, q.URL, err)
+		}
+	}
+	return id, nil
+}
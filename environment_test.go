@@ -0,0 +1,99 @@
+package testutil
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestEnvSpecValidate(t *testing.T) {
+	for _, tc := range []struct {
+		spec EnvSpec
+		want string
+	}{
+		{EnvSpec{Queues: []QueueSpec{{Name: "jobs"}, {Name: "jobs"}}}, "declared twice"},
+		{EnvSpec{Queues: []QueueSpec{{Name: "jobs", DeadLetter: "dlq"}}}, "dead-letter queue dlq is not declared"},
+		{EnvSpec{Queues: []QueueSpec{{Name: "jobs", DeadLetter: "jobs"}}}, "its own dead-letter queue"},
+		{EnvSpec{Topics: []TopicSpec{{Name: "events", Subscribers: []string{"jobs"}}}}, "subscriber queue jobs is not declared"},
+		{EnvSpec{Redis: []RedisSpec{{}}}, "has no name"},
+	} {
+		err := tc.spec.validate()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("validate(%+v) = %v, want an error containing %q", tc.spec, err, tc.want)
+		}
+	}
+
+	ok := EnvSpec{
+		Buckets: []BucketSpec{{Name: "uploads"}},
+		Queues:  []QueueSpec{{Name: "jobs", DeadLetter: "jobs-dlq"}, {Name: "jobs-dlq"}},
+		Topics:  []TopicSpec{{Name: "events", Subscribers: []string{"jobs"}}},
+	}
+	if err := ok.validate(); err != nil {
+		t.Errorf("validate: %v", err)
+	}
+}
+
+func TestEnvTopicPublish(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		body := r.Form.Get("MessageBody")
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+		fmt.Fprintf(w, "<SendMessageResponse><SendMessageResult><MessageId>1</MessageId><MD5OfMessageBody>%x</MD5OfMessageBody></SendMessageResult></SendMessageResponse>", md5.Sum([]byte(body)))
+	}))
+	defer srv.Close()
+	q := &FakeSQS{URL: srv.URL + "/jobs"}
+	q.Client = sqs.New(session.New(fakeAWSConfig(srv.URL, newOptions(nil))))
+
+	topic := &EnvTopic{Name: "events", ARN: "arn:aws:sns:us-east-1:123456789012:events", subscribers: []*FakeSQS{q, q}}
+	id, err := topic.Publish("hello", map[string]string{"kind": "greeting"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("sent %d messages, want one per subscriber", len(bodies))
+	}
+	var n SNSMessage
+	if err := json.Unmarshal([]byte(bodies[0]), &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.Type != "Notification" || n.Message != "hello" || n.TopicArn != topic.ARN || n.MessageAttributes["kind"].Value != "greeting" {
+		t.Errorf("envelope = %+v", n)
+	}
+	if n.MessageID != id {
+		t.Errorf("MessageId = %s, want %s", n.MessageID, id)
+	}
+}
+
+func TestEnvTopicPublishRaw(t *testing.T) {
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		body = r.Form.Get("MessageBody")
+		fmt.Fprintf(w, "<SendMessageResponse><SendMessageResult><MessageId>1</MessageId><MD5OfMessageBody>%x</MD5OfMessageBody></SendMessageResult></SendMessageResponse>", md5.Sum([]byte(body)))
+	}))
+	defer srv.Close()
+	q := &FakeSQS{URL: srv.URL + "/jobs"}
+	q.Client = sqs.New(session.New(fakeAWSConfig(srv.URL, newOptions(nil))))
+
+	topic := &EnvTopic{Name: "events", raw: true, subscribers: []*FakeSQS{q}}
+	if _, err := topic.Publish("hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if body != "hello" {
+		t.Errorf("body = %q, want the bare message", body)
+	}
+}
@@ -0,0 +1,781 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchevents"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// FakeEventBridge is an in-process fake of EventBridge (CloudWatch
+// Events in this SDK) that evaluates rule patterns against the events
+// sent with PutEvents and delivers matching events to each rule's
+// targets, so that event routing configuration can be tested without
+// AWS. Targets can be FakeSQS queues, see RouteToQueue, or Go
+// functions, see RouteToFunc. Rules and targets can also be set up
+// through Client, the way the code under test would.
+//
+// Deliveries happen before PutEvents returns. Scheduled rules are
+// accepted but never fire.
+type FakeEventBridge struct {
+	// Client is a CloudWatch Events client set up for the fake.
+	Client *cloudwatchevents.CloudWatchEvents
+
+	// Session is an AWS Session that uses the fake config.
+	Session *session.Session
+
+	// Config is the AWS config the Session was built from.
+	Config *aws.Config
+
+	// URL is the fake's endpoint.
+	URL string
+
+	region     string
+	clock      Clock
+	logger     Logger
+	srv        *httptest.Server
+	unregister func()
+
+	mu         sync.Mutex
+	rules      map[string]*eventRule
+	queues     map[string]*FakeSQS
+	funcs      map[string]func(EventBridgeEvent)
+	events     []EventBridgeEvent
+	matches    map[string][]EventBridgeEvent
+	deliveries []EventBridgeDelivery
+}
+
+type eventRule struct {
+	name       string
+	pattern    string
+	schedule   string
+	desc       string
+	enabled    bool
+	compiled   map[string]interface{}
+	targets    []eventTarget
+	targetSeen map[string]bool
+}
+
+type eventTarget struct {
+	ID        string `json:"Id"`
+	Arn       string
+	Input     string `json:",omitempty"`
+	InputPath string `json:",omitempty"`
+}
+
+// EventBridgeEvent is an event as EventBridge delivers it to targets.
+type EventBridgeEvent struct {
+	Version    string          `json:"version"`
+	ID         string          `json:"id"`
+	DetailType string          `json:"detail-type"`
+	Source     string          `json:"source"`
+	Account    string          `json:"account"`
+	Time       time.Time       `json:"time"`
+	Region     string          `json:"region"`
+	Resources  []string        `json:"resources"`
+	Detail     json.RawMessage `json:"detail"`
+}
+
+// EventBridgeDelivery records an event delivered to a target.
+type EventBridgeDelivery struct {
+	Rule      string
+	TargetID  string
+	TargetArn string
+
+	// Payload is what the target received: the event, or the result
+	// of the target's Input or InputPath.
+	Payload []byte
+
+	// Err is set if the delivery failed, for example because no
+	// FakeSQS was registered for the target's ARN.
+	Err error
+}
+
+// NewFakeEventBridge starts a FakeEventBridge with no rules. Event
+// times come from the Clock set with WithClock.
+func NewFakeEventBridge(opts ...Option) *FakeEventBridge {
+	o := newOptions(opts)
+	e := &FakeEventBridge{
+		region:  o.region,
+		clock:   o.clock,
+		logger:  o.logger,
+		rules:   make(map[string]*eventRule),
+		queues:  make(map[string]*FakeSQS),
+		funcs:   make(map[string]func(EventBridgeEvent)),
+		matches: make(map[string][]EventBridgeEvent),
+	}
+	if e.region == "" {
+		e.region = defaultRegion
+	}
+	e.srv = httptest.NewServer(&jsonRPCHandler{
+		targetPrefix: "AWSEvents",
+		operations: map[string]func([]byte) (interface{}, error){
+			"PutRule":           e.putRule,
+			"DescribeRule":      e.describeRule,
+			"DeleteRule":        e.deleteRule,
+			"EnableRule":        e.enableRule,
+			"DisableRule":       e.disableRule,
+			"ListRules":         e.listRules,
+			"PutTargets":        e.putTargets,
+			"RemoveTargets":     e.removeTargets,
+			"ListTargetsByRule": e.listTargetsByRule,
+			"PutEvents":         e.putEvents,
+			"TestEventPattern":  e.testEventPattern,
+		},
+	})
+	e.URL = e.srv.URL
+	e.Config = fakeAWSConfig(e.URL, o)
+	e.Session = session.New(e.Config)
+	e.Client = cloudwatchevents.New(e.Session)
+	installHooks(o, &e.Session.Handlers, &e.Client.Handlers)
+	e.unregister = OnInterrupt(e.Close)
+	return e
+}
+
+// Close stops the fake.
+func (e *FakeEventBridge) Close() {
+	if e.unregister != nil {
+		e.unregister()
+	}
+	e.srv.Close()
+}
+
+// AddRule creates or replaces the enabled rule name with the given
+// event pattern, keeping any targets it already has.
+func (e *FakeEventBridge) AddRule(name, pattern string) error {
+	compiled, err := compileEventPattern(pattern)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	r := e.rule(name)
+	r.pattern, r.compiled, r.enabled = pattern, compiled, true
+	return nil
+}
+
+// RouteToQueue adds q as a target of rule. The target's ARN is also
+// registered, so targets added through Client with
+// QueueARN(q) reach q too.
+func (e *FakeEventBridge) RouteToQueue(rule string, q *FakeSQS) error {
+	arn := e.QueueARN(q)
+	return e.addTarget(rule, eventTarget{ID: queueNameFromURL(q.URL), Arn: arn})
+}
+
+// QueueARN registers q as a target the fake can deliver to and returns
+// its ARN.
+func (e *FakeEventBridge) QueueARN(q *FakeSQS) string {
+	arn := fmt.Sprintf("arn:aws:sqs:%s:%s:%s", e.region, fakeAccountID, queueNameFromURL(q.URL))
+	e.mu.Lock()
+	e.queues[arn] = q
+	e.mu.Unlock()
+	return arn
+}
+
+// RouteToFunc adds f as a target of rule. f is called with each
+// matching event before PutEvents returns.
+func (e *FakeEventBridge) RouteToFunc(rule string, f func(EventBridgeEvent)) error {
+	e.mu.Lock()
+	id := fmt.Sprintf("func-%d", len(e.funcs)+1)
+	arn := fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", e.region, fakeAccountID, id)
+	e.funcs[arn] = f
+	e.mu.Unlock()
+	return e.addTarget(rule, eventTarget{ID: id, Arn: arn})
+}
+
+func (e *FakeEventBridge) addTarget(rule string, t eventTarget) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	r, ok := e.rules[rule]
+	if !ok {
+		return fmt.Errorf("eventbridge: no rule named %s", rule)
+	}
+	r.putTarget(t)
+	return nil
+}
+
+// Events returns every event accepted by PutEvents, in order.
+func (e *FakeEventBridge) Events() []EventBridgeEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]EventBridgeEvent(nil), e.events...)
+}
+
+// Matches returns the events that matched rule, in order.
+func (e *FakeEventBridge) Matches(rule string) []EventBridgeEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]EventBridgeEvent(nil), e.matches[rule]...)
+}
+
+// Deliveries returns every delivery to a target, in order.
+func (e *FakeEventBridge) Deliveries() []EventBridgeDelivery {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]EventBridgeDelivery(nil), e.deliveries...)
+}
+
+// AssertMatched checks that exactly want events matched rule.
+func (e *FakeEventBridge) AssertMatched(t TestingT, rule string, want int) {
+	t.Helper()
+	if got := len(e.Matches(rule)); got != want {
+		t.Errorf("eventbridge rule %s matched %d events, want %d", rule, got, want)
+	}
+}
+
+// MatchEventPattern reports whether event, a JSON event in the shape
+// EventBridge delivers, matches pattern. It supports exact values,
+// nested fields, and the prefix, anything-but, numeric and exists
+// operators.
+func MatchEventPattern(pattern string, event []byte) (bool, error) {
+	compiled, err := compileEventPattern(pattern)
+	if err != nil {
+		return false, err
+	}
+	var ev map[string]interface{}
+	if err := json.Unmarshal(event, &ev); err != nil {
+		return false, fmt.Errorf("eventbridge: invalid event: %v", err)
+	}
+	return matchEventFields(compiled, ev), nil
+}
+
+// rule returns the rule name, creating a disabled one with no pattern
+// if there is none. e.mu must be held.
+func (e *FakeEventBridge) rule(name string) *eventRule {
+	r, ok := e.rules[name]
+	if !ok {
+		r = &eventRule{name: name, targetSeen: make(map[string]bool)}
+		e.rules[name] = r
+	}
+	return r
+}
+
+func (e *FakeEventBridge) ruleArn(name string) string {
+	return fmt.Sprintf("arn:aws:events:%s:%s:rule/%s", e.region, fakeAccountID, name)
+}
+
+func (r *eventRule) putTarget(t eventTarget) {
+	if r.targetSeen[t.ID] {
+		for i := range r.targets {
+			if r.targets[i].ID == t.ID {
+				r.targets[i] = t
+			}
+		}
+		return
+	}
+	r.targetSeen[t.ID] = true
+	r.targets = append(r.targets, t)
+}
+
+// lookupRule returns the rule name or a ResourceNotFoundException.
+// e.mu must be held.
+func (e *FakeEventBridge) lookupRule(name string) (*eventRule, error) {
+	r, ok := e.rules[name]
+	if !ok {
+		return nil, newAWSError(http.StatusBadRequest, "ResourceNotFoundException", "Rule %s does not exist.", name)
+	}
+	return r, nil
+}
+
+type eventsRequest struct {
+	Name               string
+	Rule               string
+	NamePrefix         string
+	EventPattern       string
+	ScheduleExpression string
+	State              string
+	Description        string
+	Targets            []eventTarget
+	Ids                []string
+	Event              string
+	Entries            []struct {
+		Source     string
+		DetailType string
+		Detail     string
+		Resources  []string
+		Time       *float64
+	}
+}
+
+func (e *FakeEventBridge) putRule(body []byte) (interface{}, error) {
+	var req eventsRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	if req.EventPattern == "" && req.ScheduleExpression == "" {
+		return nil, newAWSError(http.StatusBadRequest, "ValidationException", "Parameter(s) EventPattern or ScheduleExpression must be specified.")
+	}
+	var compiled map[string]interface{}
+	if req.EventPattern != "" {
+		var err error
+		if compiled, err = compileEventPattern(req.EventPattern); err != nil {
+			return nil, newAWSError(http.StatusBadRequest, "InvalidEventPatternException", "%v", err)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	r := e.rule(req.Name)
+	r.pattern, r.compiled = req.EventPattern, compiled
+	r.schedule, r.desc = req.ScheduleExpression, req.Description
+	r.enabled = req.State != "DISABLED"
+	return map[string]string{"RuleArn": e.ruleArn(req.Name)}, nil
+}
+
+func (e *FakeEventBridge) describeRule(body []byte) (interface{}, error) {
+	var req eventsRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	r, err := e.lookupRule(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return e.describe(r), nil
+}
+
+func (e *FakeEventBridge) describe(r *eventRule) map[string]string {
+	state := "DISABLED"
+	if r.enabled {
+		state = "ENABLED"
+	}
+	d := map[string]string{"Name": r.name, "Arn": e.ruleArn(r.name), "State": state}
+	for k, v := range map[string]string{"EventPattern": r.pattern, "ScheduleExpression": r.schedule, "Description": r.desc} {
+		if v != "" {
+			d[k] = v
+		}
+	}
+	return d
+}
+
+func (e *FakeEventBridge) deleteRule(body []byte) (interface{}, error) {
+	var req eventsRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if r, ok := e.rules[req.Name]; ok && len(r.targets) > 0 {
+		return nil, newAWSError(http.StatusBadRequest, "ValidationException", "Rule can't be deleted since it has targets.")
+	}
+	delete(e.rules, req.Name)
+	return struct{}{}, nil
+}
+
+func (e *FakeEventBridge) enableRule(body []byte) (interface{}, error) {
+	return e.setRuleState(body, true)
+}
+
+func (e *FakeEventBridge) disableRule(body []byte) (interface{}, error) {
+	return e.setRuleState(body, false)
+}
+
+func (e *FakeEventBridge) setRuleState(body []byte, enabled bool) (interface{}, error) {
+	var req eventsRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	r, err := e.lookupRule(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	r.enabled = enabled
+	return struct{}{}, nil
+}
+
+func (e *FakeEventBridge) listRules(body []byte) (interface{}, error) {
+	var req eventsRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var names []string
+	for name := range e.rules {
+		if strings.HasPrefix(name, req.NamePrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	rules := []map[string]string{}
+	for _, name := range names {
+		rules = append(rules, e.describe(e.rules[name]))
+	}
+	return map[string]interface{}{"Rules": rules}, nil
+}
+
+func (e *FakeEventBridge) putTargets(body []byte) (interface{}, error) {
+	var req eventsRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	r, err := e.lookupRule(req.Rule)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range req.Targets {
+		r.putTarget(t)
+	}
+	return map[string]interface{}{"FailedEntryCount": 0, "FailedEntries": []struct{}{}}, nil
+}
+
+func (e *FakeEventBridge) removeTargets(body []byte) (interface{}, error) {
+	var req eventsRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	r, err := e.lookupRule(req.Rule)
+	if err != nil {
+		return nil, err
+	}
+	remove := make(map[string]bool)
+	for _, id := range req.Ids {
+		remove[id] = true
+		delete(r.targetSeen, id)
+	}
+	kept := r.targets[:0]
+	for _, t := range r.targets {
+		if !remove[t.ID] {
+			kept = append(kept, t)
+		}
+	}
+	r.targets = kept
+	return map[string]interface{}{"FailedEntryCount": 0, "FailedEntries": []struct{}{}}, nil
+}
+
+func (e *FakeEventBridge) listTargetsByRule(body []byte) (interface{}, error) {
+	var req eventsRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	r, err := e.lookupRule(req.Rule)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"Targets": append([]eventTarget{}, r.targets...)}, nil
+}
+
+func (e *FakeEventBridge) testEventPattern(body []byte) (interface{}, error) {
+	var req eventsRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	ok, err := MatchEventPattern(req.EventPattern, []byte(req.Event))
+	if err != nil {
+		return nil, newAWSError(http.StatusBadRequest, "InvalidEventPatternException", "%v", err)
+	}
+	return map[string]bool{"Result": ok}, nil
+}
+
+// pendingDelivery is a delivery worked out under e.mu and made after
+// it is released.
+type pendingDelivery struct {
+	rule   string
+	target eventTarget
+	event  EventBridgeEvent
+	raw    map[string]interface{}
+}
+
+func (e *FakeEventBridge) putEvents(body []byte) (interface{}, error) {
+	var req eventsRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+
+	var (
+		entries []map[string]string
+		failed  int
+		pending []pendingDelivery
+	)
+	e.mu.Lock()
+	for _, entry := range req.Entries {
+		var detail map[string]interface{}
+		if entry.Source == "" || entry.DetailType == "" || json.Unmarshal([]byte(entry.Detail), &detail) != nil {
+			failed++
+			entries = append(entries, map[string]string{
+				"ErrorCode":    "MalformedDetail",
+				"ErrorMessage": "Source, DetailType and a JSON object Detail are required.",
+			})
+			continue
+		}
+		ev := EventBridgeEvent{
+			Version:    "0",
+			ID:         randomUUID(),
+			DetailType: entry.DetailType,
+			Source:     entry.Source,
+			Account:    fakeAccountID,
+			Time:       e.clock.Now().UTC().Truncate(time.Second),
+			Region:     e.region,
+			Resources:  entry.Resources,
+			Detail:     json.RawMessage(entry.Detail),
+		}
+		if ev.Resources == nil {
+			ev.Resources = []string{}
+		}
+		if entry.Time != nil {
+			ev.Time = time.Unix(int64(*entry.Time), 0).UTC()
+		}
+		e.events = append(e.events, ev)
+		entries = append(entries, map[string]string{"EventId": ev.ID})
+
+		raw, _ := json.Marshal(ev)
+		var fields map[string]interface{}
+		json.Unmarshal(raw, &fields)
+		for _, r := range e.rules {
+			if !r.enabled || r.compiled == nil || !matchEventFields(r.compiled, fields) {
+				continue
+			}
+			e.matches[r.name] = append(e.matches[r.name], ev)
+			for _, t := range r.targets {
+				pending = append(pending, pendingDelivery{rule: r.name, target: t, event: ev, raw: fields})
+			}
+		}
+	}
+	e.mu.Unlock()
+
+	for _, p := range pending {
+		e.deliver(p)
+	}
+	return map[string]interface{}{"FailedEntryCount": failed, "Entries": entries}, nil
+}
+
+// deliver sends an event to a target and records the delivery.
+func (e *FakeEventBridge) deliver(p pendingDelivery) {
+	d := EventBridgeDelivery{Rule: p.rule, TargetID: p.target.ID, TargetArn: p.target.Arn}
+	switch {
+	case p.target.Input != "":
+		d.Payload = []byte(p.target.Input)
+	case p.target.InputPath != "":
+		d.Payload, d.Err = jsonPathValue(p.raw, p.target.InputPath)
+	default:
+		d.Payload, d.Err = json.Marshal(p.event)
+	}
+
+	e.mu.Lock()
+	q := e.queues[p.target.Arn]
+	f := e.funcs[p.target.Arn]
+	e.mu.Unlock()
+
+	if d.Err == nil {
+		switch {
+		case q != nil:
+			_, d.Err = q.Client.SendMessage(&sqs.SendMessageInput{
+				QueueUrl:    &q.URL,
+				MessageBody: aws.String(string(d.Payload)),
+			})
+		case f != nil:
+			f(p.event)
+		default:
+			d.Err = fmt.Errorf("eventbridge: no target registered for %s", p.target.Arn)
+		}
+	}
+	if d.Err != nil {
+		e.logger.Logf("eventbridge: rule %s could not deliver to %s: %v", p.rule, p.target.Arn, d.Err)
+	}
+
+	e.mu.Lock()
+	e.deliveries = append(e.deliveries, d)
+	e.mu.Unlock()
+}
+
+// jsonPathValue returns the JSON encoding of the value at path, a
+// simple JSONPath such as "$.detail.id", in v.
+func jsonPathValue(v map[string]interface{}, path string) ([]byte, error) {
+	if path != "$" && !strings.HasPrefix(path, "$.") {
+		return nil, fmt.Errorf("eventbridge: unsupported InputPath %q", path)
+	}
+	var cur interface{} = v
+	if path != "$" {
+		for _, field := range strings.Split(strings.TrimPrefix(path, "$."), ".") {
+			m, ok := cur.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("eventbridge: InputPath %q does not match the event", path)
+			}
+			cur = m[field]
+		}
+	}
+	return json.Marshal(cur)
+}
+
+// compileEventPattern parses and checks an event pattern.
+func compileEventPattern(pattern string) (map[string]interface{}, error) {
+	var p map[string]interface{}
+	if err := json.Unmarshal([]byte(pattern), &p); err != nil {
+		return nil, fmt.Errorf("eventbridge: event pattern is not a JSON object: %v", err)
+	}
+	if err := checkEventPattern(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func checkEventPattern(p map[string]interface{}) error {
+	for field, v := range p {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if err := checkEventPattern(v); err != nil {
+				return err
+			}
+		case []interface{}:
+			for _, rule := range v {
+				op, ok := rule.(map[string]interface{})
+				if !ok {
+					if !isEventScalar(rule) {
+						return fmt.Errorf("eventbridge: %s: match values must be strings, numbers, booleans or null", field)
+					}
+					continue
+				}
+				if len(op) != 1 {
+					return fmt.Errorf("eventbridge: %s: a matcher must have exactly one operator", field)
+				}
+				for name, operand := range op {
+					switch name {
+					case "prefix", "numeric", "exists":
+					case "anything-but":
+						values, ok := operand.([]interface{})
+						if !ok {
+							values = []interface{}{operand}
+						}
+						for _, b := range values {
+							if !isEventScalar(b) {
+								return fmt.Errorf("eventbridge: %s: anything-but values must be strings, numbers, booleans or null", field)
+							}
+						}
+					default:
+						return fmt.Errorf("eventbridge: %s: unsupported operator %q", field, name)
+					}
+				}
+			}
+		default:
+			return fmt.Errorf("eventbridge: %s: match values must be in a JSON array", field)
+		}
+	}
+	return nil
+}
+
+// isEventScalar reports whether v, decoded from JSON, is a value a
+// pattern can match exactly.
+func isEventScalar(v interface{}) bool {
+	switch v.(type) {
+	case string, float64, bool, nil:
+		return true
+	}
+	return false
+}
+
+// matchEventFields reports whether the event fields ev match the
+// compiled pattern p.
+func matchEventFields(p, ev map[string]interface{}) bool {
+	for field, rule := range p {
+		v, present := ev[field]
+		switch rule := rule.(type) {
+		case map[string]interface{}:
+			sub, ok := v.(map[string]interface{})
+			if !ok || !matchEventFields(rule, sub) {
+				return false
+			}
+		case []interface{}:
+			if !matchEventValues(rule, v, present) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchEventValues reports whether any of rules matches v, or any
+// element of v if it is an array.
+func matchEventValues(rules []interface{}, v interface{}, present bool) bool {
+	values := []interface{}{v}
+	if arr, ok := v.([]interface{}); ok {
+		values = arr
+	}
+	for _, rule := range rules {
+		if op, ok := rule.(map[string]interface{}); ok {
+			if exists, ok := op["exists"]; ok {
+				if exists == present {
+					return true
+				}
+				continue
+			}
+		}
+		if !present {
+			continue
+		}
+		for _, value := range values {
+			if matchEventValue(rule, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchEventValue(rule, v interface{}) bool {
+	op, ok := rule.(map[string]interface{})
+	if !ok {
+		return rule == v
+	}
+	if prefix, ok := op["prefix"].(string); ok {
+		s, ok := v.(string)
+		return ok && strings.HasPrefix(s, prefix)
+	}
+	if but, ok := op["anything-but"]; ok {
+		if list, ok := but.([]interface{}); ok {
+			for _, b := range list {
+				if b == v {
+					return false
+				}
+			}
+			return true
+		}
+		return but != v
+	}
+	if numeric, ok := op["numeric"].([]interface{}); ok {
+		n, ok := v.(float64)
+		if !ok || len(numeric)%2 != 0 {
+			return false
+		}
+		for i := 0; i < len(numeric); i += 2 {
+			cmp, _ := numeric[i].(string)
+			bound, _ := numeric[i+1].(float64)
+			var ok bool
+			switch cmp {
+			case "=":
+				ok = n == bound
+			case "<":
+				ok = n < bound
+			case "<=":
+				ok = n <= bound
+			case ">":
+				ok = n > bound
+			case ">=":
+				ok = n >= bound
+			}
+			if !ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
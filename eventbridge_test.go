@@ -0,0 +1,161 @@
+package testutil
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchevents"
+)
+
+func TestFakeEventBridgeRouting(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 4, 9, 12, 0, 0, 0, time.UTC))
+	e := NewFakeEventBridge(WithClock(clock), WithLogger(t))
+	defer e.Close()
+
+	if err := e.AddRule("large-orders", `{
+		"source": ["shop.orders"],
+		"detail-type": [{"prefix": "Order"}],
+		"detail": {"total": [{"numeric": [">=", 100]}], "coupon": [{"exists": false}]}
+	}`); err != nil {
+		t.Fatal(err)
+	}
+	var got []EventBridgeEvent
+	if err := e.RouteToFunc("large-orders", func(ev EventBridgeEvent) { got = append(got, ev) }); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(detail string) {
+		t.Helper()
+		out, err := e.Client.PutEvents(&cloudwatchevents.PutEventsInput{
+			Entries: []*cloudwatchevents.PutEventsRequestEntry{{
+				Source:     aws.String("shop.orders"),
+				DetailType: aws.String("Order Placed"),
+				Detail:     aws.String(detail),
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := aws.Int64Value(out.FailedEntryCount); n != 0 {
+			t.Fatalf("%d entries failed", n)
+		}
+	}
+	put(`{"total": 250}`)
+	put(`{"total": 20}`)
+	put(`{"total": 500, "coupon": "HALF"}`)
+
+	e.AssertMatched(t, "large-orders", 1)
+	if len(got) != 1 || string(got[0].Detail) != `{"total": 250}` {
+		t.Fatalf("callback got %+v", got)
+	}
+	if !got[0].Time.Equal(clock.Now()) || got[0].Region != defaultRegion {
+		t.Errorf("event envelope = %+v", got[0])
+	}
+	if n := len(e.Events()); n != 3 {
+		t.Errorf("recorded %d events, want 3", n)
+	}
+}
+
+func TestFakeEventBridgeAPITargets(t *testing.T) {
+	e := NewFakeEventBridge(WithLogger(t))
+	defer e.Close()
+
+	_, err := e.Client.PutRule(&cloudwatchevents.PutRuleInput{
+		Name:         aws.String("audit"),
+		EventPattern: aws.String(`{"source": [{"anything-but": ["internal"]}]}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = e.Client.PutTargets(&cloudwatchevents.PutTargetsInput{
+		Rule: aws.String("audit"),
+		Targets: []*cloudwatchevents.Target{{
+			Id:        aws.String("missing-queue"),
+			Arn:       aws.String("arn:aws:sqs:us-east-1:123456789012:nowhere"),
+			InputPath: aws.String("$.detail"),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = e.Client.PutEvents(&cloudwatchevents.PutEventsInput{
+		Entries: []*cloudwatchevents.PutEventsRequestEntry{{
+			Source:     aws.String("billing"),
+			DetailType: aws.String("Invoice Paid"),
+			Detail:     aws.String(`{"id": 7}`),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries := e.Deliveries()
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	if d := deliveries[0]; d.Err == nil || string(d.Payload) != `{"id":7}` {
+		t.Errorf("delivery = %+v, want an error and the InputPath payload", d)
+	}
+
+	_, err = e.Client.DeleteRule(&cloudwatchevents.DeleteRuleInput{Name: aws.String("audit")})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "ValidationException" {
+		t.Errorf("expected ValidationException deleting a rule with targets, got %v", err)
+	}
+
+	_, err = e.Client.PutRule(&cloudwatchevents.PutRuleInput{
+		Name:         aws.String("bad"),
+		EventPattern: aws.String(`{"source": "not-an-array"}`),
+	})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "InvalidEventPatternException" {
+		t.Errorf("expected InvalidEventPatternException, got %v", err)
+	}
+}
+
+func TestMatchEventPattern(t *testing.T) {
+	event, _ := json.Marshal(map[string]interface{}{
+		"source":    "aws.s3",
+		"resources": []string{"arn:aws:s3:::uploads"},
+		"detail":    map[string]interface{}{"size": 10, "key": "reports/q1.csv"},
+	})
+	for _, tc := range []struct {
+		pattern string
+		want    bool
+	}{
+		{`{"source": ["aws.s3", "aws.sqs"]}`, true},
+		{`{"source": ["aws.sqs"]}`, false},
+		{`{"resources": ["arn:aws:s3:::uploads"]}`, true},
+		{`{"detail": {"key": [{"prefix": "reports/"}]}}`, true},
+		{`{"detail": {"size": [{"numeric": [">", 0, "<", 10]}]}}`, false},
+		{`{"detail": {"owner": [{"exists": true}]}}`, false},
+		{`{"detail": {"owner": [null]}}`, false},
+		{`{"source": [{"anything-but": "aws.sqs"}]}`, true},
+	} {
+		got, err := MatchEventPattern(tc.pattern, event)
+		if err != nil {
+			t.Errorf("%s: %v", tc.pattern, err)
+		} else if got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.pattern, got, tc.want)
+		}
+	}
+}
+
+func TestMatchEventPatternRejectsNestedValues(t *testing.T) {
+	for _, pattern := range []string{
+		`{"a": [[1]]}`,
+		`{"a": [{"b": 1}, 2]}`,
+		`{"a": [{"anything-but": [[1]]}]}`,
+		`{"a": [{"anything-but": {"b": 1}}]}`,
+	} {
+		if _, err := MatchEventPattern(pattern, []byte(`{"a": [[1]]}`)); err == nil {
+			t.Errorf("%s: matched without an error", pattern)
+		}
+	}
+
+	// Nested event values don't match scalars, rather than panicking.
+	if got, err := MatchEventPattern(`{"a": [1, "b"]}`, []byte(`{"a": [[1], {"b": 2}]}`)); err != nil || got {
+		t.Errorf("nested event values = %v, %v", got, err)
+	}
+}
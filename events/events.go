@@ -0,0 +1,372 @@
+// Package events builds Lambda event payloads for table tests of
+// Lambda handlers: API Gateway proxy requests and SQS, S3, SNS and
+// Kinesis events. The types mirror the JSON shape AWS delivers, field
+// for field, so Convert can turn them into the types a handler takes,
+// such as those in github.com/aws/aws-lambda-go/events, and turn a
+// handler's response back into an APIGatewayProxyResponse to inspect.
+package events
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	region    = "us-east-1"
+	accountID = "123456789012"
+)
+
+// Convert copies src into dst, which should be a pointer, by way of
+// their JSON encodings.
+func Convert(src, dst interface{}) error {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// APIGatewayProxyRequest is the event API Gateway sends to a Lambda
+// proxy integration.
+type APIGatewayProxyRequest struct {
+	Resource                        string                        `json:"resource"`
+	Path                            string                        `json:"path"`
+	HTTPMethod                      string                        `json:"httpMethod"`
+	Headers                         map[string]string             `json:"headers"`
+	MultiValueHeaders               map[string][]string           `json:"multiValueHeaders"`
+	QueryStringParameters           map[string]string             `json:"queryStringParameters"`
+	MultiValueQueryStringParameters map[string][]string           `json:"multiValueQueryStringParameters"`
+	PathParameters                  map[string]string             `json:"pathParameters"`
+	StageVariables                  map[string]string             `json:"stageVariables"`
+	RequestContext                  APIGatewayProxyRequestContext `json:"requestContext"`
+	Body                            string                        `json:"body"`
+	IsBase64Encoded                 bool                          `json:"isBase64Encoded"`
+}
+
+// APIGatewayProxyRequestContext is the requestContext of an
+// APIGatewayProxyRequest.
+type APIGatewayProxyRequestContext struct {
+	AccountID    string                 `json:"accountId"`
+	ResourcePath string                 `json:"resourcePath"`
+	Stage        string                 `json:"stage"`
+	RequestID    string                 `json:"requestId"`
+	HTTPMethod   string                 `json:"httpMethod"`
+	APIID        string                 `json:"apiId"`
+	Path         string                 `json:"path"`
+	Authorizer   map[string]interface{} `json:"authorizer,omitempty"`
+}
+
+// APIGatewayRequest returns a proxy request for method and target, a
+// path with an optional query string such as "/users?limit=10", with
+// the given body.
+func APIGatewayRequest(method, target, body string) APIGatewayProxyRequest {
+	path, query := target, ""
+	if i := strings.IndexByte(target, '?'); i >= 0 {
+		path, query = target[:i], target[i+1:]
+	}
+	req := APIGatewayProxyRequest{
+		Resource:   path,
+		Path:       path,
+		HTTPMethod: method,
+		Headers:    map[string]string{},
+		Body:       body,
+		RequestContext: APIGatewayProxyRequestContext{
+			AccountID:    accountID,
+			ResourcePath: path,
+			Stage:        "test",
+			RequestID:    "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+			HTTPMethod:   method,
+			APIID:        "testapi",
+			Path:         "/test" + path,
+		},
+	}
+	if values, err := url.ParseQuery(query); err == nil && len(values) > 0 {
+		req.QueryStringParameters = make(map[string]string, len(values))
+		req.MultiValueQueryStringParameters = values
+		for k, vs := range values {
+			req.QueryStringParameters[k] = vs[len(vs)-1]
+		}
+	}
+	return req
+}
+
+// WithHeader returns a copy of r with header name set to value.
+func (r APIGatewayProxyRequest) WithHeader(name, value string) APIGatewayProxyRequest {
+	headers := make(map[string]string, len(r.Headers)+1)
+	multi := make(map[string][]string, len(r.Headers)+1)
+	for k, v := range r.Headers {
+		headers[k] = v
+		multi[k] = []string{v}
+	}
+	headers[name] = value
+	multi[name] = []string{value}
+	r.Headers, r.MultiValueHeaders = headers, multi
+	return r
+}
+
+// WithPathParameters returns a copy of r with resource as its resource
+// template, such as "/users/{id}", and params as its path parameters.
+func (r APIGatewayProxyRequest) WithPathParameters(resource string, params map[string]string) APIGatewayProxyRequest {
+	r.Resource = resource
+	r.RequestContext.ResourcePath = resource
+	r.PathParameters = params
+	return r
+}
+
+// APIGatewayProxyResponse is the response a Lambda proxy integration
+// returns to API Gateway.
+type APIGatewayProxyResponse struct {
+	StatusCode        int                 `json:"statusCode"`
+	Headers           map[string]string   `json:"headers"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded,omitempty"`
+}
+
+// APIGatewayResponse converts resp, a handler's response of any type
+// with the proxy response's JSON shape, into an
+// APIGatewayProxyResponse.
+func APIGatewayResponse(resp interface{}) (APIGatewayProxyResponse, error) {
+	var r APIGatewayProxyResponse
+	err := Convert(resp, &r)
+	return r, err
+}
+
+// BodyBytes returns the response body, decoding it if it is base64
+// encoded.
+func (r APIGatewayProxyResponse) BodyBytes() ([]byte, error) {
+	if r.IsBase64Encoded {
+		return base64.StdEncoding.DecodeString(r.Body)
+	}
+	return []byte(r.Body), nil
+}
+
+// DecodeJSON unmarshals the response body into v.
+func (r APIGatewayProxyResponse) DecodeJSON(v interface{}) error {
+	b, err := r.BodyBytes()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// SQSEvent is the event an SQS trigger delivers.
+type SQSEvent struct {
+	Records []SQSMessage `json:"Records"`
+}
+
+// SQSMessage is a record of an SQSEvent.
+type SQSMessage struct {
+	MessageID         string                         `json:"messageId"`
+	ReceiptHandle     string                         `json:"receiptHandle"`
+	Body              string                         `json:"body"`
+	Md5OfBody         string                         `json:"md5OfBody"`
+	Attributes        map[string]string              `json:"attributes"`
+	MessageAttributes map[string]SQSMessageAttribute `json:"messageAttributes"`
+	EventSourceARN    string                         `json:"eventSourceARN"`
+	EventSource       string                         `json:"eventSource"`
+	AWSRegion         string                         `json:"awsRegion"`
+}
+
+// SQSMessageAttribute is a message attribute of an SQSMessage.
+type SQSMessageAttribute struct {
+	StringValue string `json:"stringValue,omitempty"`
+	DataType    string `json:"dataType"`
+}
+
+// SQS returns an event delivering a message with each of bodies from
+// the queue named queue.
+func SQS(queue string, bodies ...string) SQSEvent {
+	var e SQSEvent
+	for i, body := range bodies {
+		e.Records = append(e.Records, SQSMessage{
+			MessageID:     fmt.Sprintf("00000000-0000-4000-8000-%012x", i+1),
+			ReceiptHandle: fmt.Sprintf("receipt-%d", i+1),
+			Body:          body,
+			Attributes: map[string]string{
+				"ApproximateReceiveCount": "1",
+				"SentTimestamp":           "1523232000000",
+			},
+			MessageAttributes: map[string]SQSMessageAttribute{},
+			EventSourceARN:    fmt.Sprintf("arn:aws:sqs:%s:%s:%s", region, accountID, queue),
+			EventSource:       "aws:sqs",
+			AWSRegion:         region,
+		})
+	}
+	return e
+}
+
+// S3Event is the event an S3 notification delivers.
+type S3Event struct {
+	Records []S3EventRecord `json:"Records"`
+}
+
+// S3EventRecord is a record of an S3Event.
+type S3EventRecord struct {
+	EventVersion string    `json:"eventVersion"`
+	EventSource  string    `json:"eventSource"`
+	AWSRegion    string    `json:"awsRegion"`
+	EventTime    time.Time `json:"eventTime"`
+	EventName    string    `json:"eventName"`
+	S3           S3Entity  `json:"s3"`
+}
+
+// S3Entity describes the bucket and object of an S3EventRecord.
+type S3Entity struct {
+	SchemaVersion   string   `json:"s3SchemaVersion"`
+	ConfigurationID string   `json:"configurationId"`
+	Bucket          S3Bucket `json:"bucket"`
+	Object          S3Object `json:"object"`
+}
+
+// S3Bucket is the bucket of an S3Entity.
+type S3Bucket struct {
+	Name string `json:"name"`
+	Arn  string `json:"arn"`
+}
+
+// S3Object is the object of an S3Entity.
+type S3Object struct {
+	Key       string `json:"key"`
+	Size      int64  `json:"size"`
+	ETag      string `json:"eTag"`
+	Sequencer string `json:"sequencer"`
+}
+
+// S3 returns an event named eventName, such as "ObjectCreated:Put",
+// for each of keys in bucket. Keys are URL encoded in the event, as S3
+// sends them.
+func S3(eventName, bucket string, keys ...string) S3Event {
+	var e S3Event
+	for i, key := range keys {
+		e.Records = append(e.Records, S3EventRecord{
+			EventVersion: "2.1",
+			EventSource:  "aws:s3",
+			AWSRegion:    region,
+			EventTime:    time.Date(2018, 4, 9, 0, 0, 0, 0, time.UTC),
+			EventName:    eventName,
+			S3: S3Entity{
+				SchemaVersion:   "1.0",
+				ConfigurationID: "testConfigRule",
+				Bucket:          S3Bucket{Name: bucket, Arn: "arn:aws:s3:::" + bucket},
+				Object: S3Object{
+					Key:       url.QueryEscape(key),
+					ETag:      "0123456789abcdef0123456789abcdef",
+					Sequencer: fmt.Sprintf("%016X", i+1),
+				},
+			},
+		})
+	}
+	return e
+}
+
+// SNSEvent is the event an SNS subscription delivers.
+type SNSEvent struct {
+	Records []SNSEventRecord `json:"Records"`
+}
+
+// SNSEventRecord is a record of an SNSEvent.
+type SNSEventRecord struct {
+	EventVersion         string    `json:"EventVersion"`
+	EventSubscriptionArn string    `json:"EventSubscriptionArn"`
+	EventSource          string    `json:"EventSource"`
+	SNS                  SNSEntity `json:"Sns"`
+}
+
+// SNSEntity is the notification of an SNSEventRecord.
+type SNSEntity struct {
+	Signature         string                 `json:"Signature"`
+	MessageID         string                 `json:"MessageId"`
+	Type              string                 `json:"Type"`
+	TopicArn          string                 `json:"TopicArn"`
+	MessageAttributes map[string]interface{} `json:"MessageAttributes"`
+	SignatureVersion  string                 `json:"SignatureVersion"`
+	Timestamp         time.Time              `json:"Timestamp"`
+	SigningCertURL    string                 `json:"SigningCertUrl"`
+	Message           string                 `json:"Message"`
+	UnsubscribeURL    string                 `json:"UnsubscribeUrl"`
+	Subject           string                 `json:"Subject"`
+}
+
+// SNS returns an event delivering each of messages from the topic
+// named topic.
+func SNS(topic string, messages ...string) SNSEvent {
+	arn := fmt.Sprintf("arn:aws:sns:%s:%s:%s", region, accountID, topic)
+	var e SNSEvent
+	for i, msg := range messages {
+		e.Records = append(e.Records, SNSEventRecord{
+			EventVersion:         "1.0",
+			EventSubscriptionArn: arn + ":2bcfbf39-05c3-41de-beaa-fcfcc21c8f55",
+			EventSource:          "aws:sns",
+			SNS: SNSEntity{
+				MessageID:         fmt.Sprintf("00000000-0000-4000-8000-%012x", i+1),
+				Type:              "Notification",
+				TopicArn:          arn,
+				MessageAttributes: map[string]interface{}{},
+				SignatureVersion:  "1",
+				Timestamp:         time.Date(2018, 4, 9, 0, 0, 0, 0, time.UTC),
+				Message:           msg,
+			},
+		})
+	}
+	return e
+}
+
+// KinesisEvent is the event a Kinesis trigger delivers.
+type KinesisEvent struct {
+	Records []KinesisEventRecord `json:"Records"`
+}
+
+// KinesisEventRecord is a record of a KinesisEvent.
+type KinesisEventRecord struct {
+	AWSRegion         string        `json:"awsRegion"`
+	EventID           string        `json:"eventID"`
+	EventName         string        `json:"eventName"`
+	EventSource       string        `json:"eventSource"`
+	EventSourceArn    string        `json:"eventSourceARN"`
+	EventVersion      string        `json:"eventVersion"`
+	InvokeIdentityArn string        `json:"invokeIdentityArn"`
+	Kinesis           KinesisRecord `json:"kinesis"`
+}
+
+// KinesisRecord is the data of a KinesisEventRecord. Data is base64
+// encoded in JSON, as Kinesis sends it.
+type KinesisRecord struct {
+	ApproximateArrivalTimestamp float64 `json:"approximateArrivalTimestamp"`
+	Data                        []byte  `json:"data"`
+	EncryptionType              string  `json:"encryptionType,omitempty"`
+	PartitionKey                string  `json:"partitionKey"`
+	SequenceNumber              string  `json:"sequenceNumber"`
+	KinesisSchemaVersion        string  `json:"kinesisSchemaVersion"`
+}
+
+// Kinesis returns an event delivering a record with each of data from
+// the stream named stream.
+func Kinesis(stream string, data ...[]byte) KinesisEvent {
+	arn := fmt.Sprintf("arn:aws:kinesis:%s:%s:stream/%s", region, accountID, stream)
+	var e KinesisEvent
+	for i, d := range data {
+		seq := fmt.Sprintf("49590338271490256608559692538361571095921575989136588%03d", i+1)
+		e.Records = append(e.Records, KinesisEventRecord{
+			AWSRegion:         region,
+			EventID:           "shardId-000000000000:" + seq,
+			EventName:         "aws:kinesis:record",
+			EventSource:       "aws:kinesis",
+			EventSourceArn:    arn,
+			EventVersion:      "1.0",
+			InvokeIdentityArn: fmt.Sprintf("arn:aws:iam::%s:role/lambda-role", accountID),
+			Kinesis: KinesisRecord{
+				ApproximateArrivalTimestamp: 1523232000,
+				Data:                        d,
+				PartitionKey:                fmt.Sprintf("partition-%d", i+1),
+				SequenceNumber:              seq,
+				KinesisSchemaVersion:        "1.0",
+			},
+		})
+	}
+	return e
+}
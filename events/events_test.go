@@ -0,0 +1,85 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAPIGatewayRequest(t *testing.T) {
+	req := APIGatewayRequest("GET", "/users/42?fields=name&fields=email", "").
+		WithHeader("Authorization", "Bearer token").
+		WithPathParameters("/users/{id}", map[string]string{"id": "42"})
+
+	if req.Path != "/users/42" || req.Resource != "/users/{id}" {
+		t.Errorf("Path = %q, Resource = %q", req.Path, req.Resource)
+	}
+	if got := req.MultiValueQueryStringParameters["fields"]; len(got) != 2 {
+		t.Errorf("multi-value fields = %q", got)
+	}
+	if got := req.QueryStringParameters["fields"]; got != "email" {
+		t.Errorf("fields = %q, want the last value", got)
+	}
+	if req.Headers["Authorization"] != "Bearer token" {
+		t.Errorf("Headers = %v", req.Headers)
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"httpMethod":"GET"`, `"pathParameters":{"id":"42"}`, `"requestContext":{`} {
+		if !strings.Contains(string(b), field) {
+			t.Errorf("JSON %s does not contain %s", b, field)
+		}
+	}
+}
+
+func TestAPIGatewayResponse(t *testing.T) {
+	// A handler's response type, as it might be declared elsewhere.
+	type response struct {
+		StatusCode      int               `json:"statusCode"`
+		Headers         map[string]string `json:"headers"`
+		Body            string            `json:"body"`
+		IsBase64Encoded bool              `json:"isBase64Encoded"`
+	}
+	resp, err := APIGatewayResponse(response{StatusCode: 201, Body: "eyJpZCI6NDJ9", IsBase64Encoded: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct{ ID int }
+	if err := resp.DecodeJSON(&body); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 201 || body.ID != 42 {
+		t.Errorf("got %d %+v", resp.StatusCode, body)
+	}
+}
+
+func TestKinesisDataIsBase64(t *testing.T) {
+	b, err := json.Marshal(Kinesis("clicks", []byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"data":"aGVsbG8="`) {
+		t.Errorf("expected base64 data in %s", b)
+	}
+}
+
+func ExampleS3() {
+	e := S3("ObjectCreated:Put", "uploads", "reports/2018 Q1.csv")
+	r := e.Records[0]
+	fmt.Println(r.EventName, r.S3.Bucket.Name, r.S3.Object.Key)
+	// Output: ObjectCreated:Put uploads reports%2F2018+Q1.csv
+}
+
+func ExampleSQS() {
+	e := SQS("jobs", `{"id":1}`, `{"id":2}`)
+	for _, m := range e.Records {
+		fmt.Println(m.EventSourceARN, m.Body)
+	}
+	// Output:
+	// arn:aws:sqs:us-east-1:123456789012:jobs {"id":1}
+	// arn:aws:sqs:us-east-1:123456789012:jobs {"id":2}
+}
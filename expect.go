@@ -0,0 +1,368 @@
+package testutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/garyburd/redigo/redis"
+	"github.com/rainforestapp/testutil/waitfor"
+)
+
+// Expectation is the start of a fluent assertion on a fake, so that
+// integration tests read as specifications:
+//
+//	Expect(t).Queue(q).ToReceive(BodyContaining("order-1")).Within(5 * time.Second)
+//	Expect(t).Bucket(s3, "invoices").ToContainKey("2020/01/order-1.pdf")
+//	Expect(t).Redis(pool).Within(time.Second).ToHaveValue("order-1", "paid")
+//
+// Failures are reported with t.Errorf.
+type Expectation struct {
+	t TestingT
+}
+
+// Expect starts an assertion reported to t.
+func Expect(t TestingT) *Expectation {
+	return &Expectation{t: t}
+}
+
+// expectPollInterval is how often bucket and redis expectations given
+// a Within duration are checked.
+const expectPollInterval = 10 * time.Millisecond
+
+// eventually calls try until it returns true, for up to within. try is
+// always called at least once.
+func eventually(within time.Duration, try func() bool) bool {
+	if try() {
+		return true
+	}
+	if within <= 0 {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), within)
+	defer cancel()
+	return waitfor.Context(ctx, try, expectPollInterval) == nil
+}
+
+// withinString describes how long an expectation waited, for failure
+// messages.
+func withinString(within time.Duration) string {
+	if within <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" within %v", within)
+}
+
+// MessageMatcher matches SQS messages in queue expectations.
+type MessageMatcher struct {
+	desc  string
+	match func(msg *sqs.Message) bool
+}
+
+// Matches reports whether msg matches m.
+func (m MessageMatcher) Matches(msg *sqs.Message) bool {
+	return m.match(msg)
+}
+
+func (m MessageMatcher) String() string {
+	return m.desc
+}
+
+// AnyMessage matches every message.
+func AnyMessage() MessageMatcher {
+	return MessageMatcher{
+		desc:  "any message",
+		match: func(*sqs.Message) bool { return true },
+	}
+}
+
+// BodyContaining matches messages whose body contains s.
+func BodyContaining(s string) MessageMatcher {
+	return MessageMatcher{
+		desc:  fmt.Sprintf("body containing %q", s),
+		match: func(msg *sqs.Message) bool { return strings.Contains(aws.StringValue(msg.Body), s) },
+	}
+}
+
+// MessageJSON matches messages whose body is JSON containing want, in
+// the same sense as BodyJSON.
+func MessageJSON(want interface{}) MessageMatcher {
+	b, err := json.Marshal(want)
+	if err != nil {
+		panic(fmt.Sprintf("testutil: MessageJSON(%#v): %v", want, err))
+	}
+	var w interface{}
+	json.Unmarshal(b, &w)
+	return MessageMatcher{
+		desc: "JSON body containing " + string(b),
+		match: func(msg *sqs.Message) bool {
+			var got interface{}
+			if err := json.Unmarshal([]byte(aws.StringValue(msg.Body)), &got); err != nil {
+				return false
+			}
+			return jsonContains(got, w)
+		},
+	}
+}
+
+// MessageAttribute matches messages with the string message attribute
+// name set to value.
+func MessageAttribute(name, value string) MessageMatcher {
+	return MessageMatcher{
+		desc: fmt.Sprintf("attribute %s=%q", name, value),
+		match: func(msg *sqs.Message) bool {
+			attr, ok := msg.MessageAttributes[name]
+			return ok && aws.StringValue(attr.StringValue) == value
+		},
+	}
+}
+
+// AllMessages matches messages that match every one of ms.
+func AllMessages(ms ...MessageMatcher) MessageMatcher {
+	descs := make([]string, len(ms))
+	for i, m := range ms {
+		descs[i] = m.desc
+	}
+	return MessageMatcher{
+		desc: strings.Join(descs, ", "),
+		match: func(msg *sqs.Message) bool {
+			for _, m := range ms {
+				if !m.match(msg) {
+					return false
+				}
+			}
+			return true
+		},
+	}
+}
+
+// QueueExpectation is an assertion on a queue.
+type QueueExpectation struct {
+	t TestingT
+	q MessageQueue
+}
+
+// Queue starts an assertion on q.
+func (e *Expectation) Queue(q MessageQueue) *QueueExpectation {
+	return &QueueExpectation{t: e.t, q: q}
+}
+
+// ReceiveExpectation is an expectation that a queue receives a message.
+// Nothing is checked until Within is called.
+type ReceiveExpectation struct {
+	t TestingT
+	q MessageQueue
+	m MessageMatcher
+}
+
+// ToReceive expects a message matching every one of ms, or any message
+// if none are given. Finish it with Within.
+func (e *QueueExpectation) ToReceive(ms ...MessageMatcher) *ReceiveExpectation {
+	m := AnyMessage()
+	if len(ms) > 0 {
+		m = AllMessages(ms...)
+	}
+	return &ReceiveExpectation{t: e.t, q: e.q, m: m}
+}
+
+// Within checks that a matching message arrives on the queue within d,
+// deletes it, and returns it, or nil if the check failed. Other
+// messages received while waiting are made visible again straight
+// away.
+func (e *ReceiveExpectation) Within(d time.Duration) *sqs.Message {
+	e.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	var seen int
+	var others []*sqs.Message
+	for ctx.Err() == nil {
+		wait := maxReceiveWait
+		if deadline, _ := ctx.Deadline(); time.Until(deadline) < wait {
+			wait = time.Until(deadline)
+		}
+		msgs, err := e.q.ReceiveMessagesContext(ctx, 10, wait)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			e.t.Errorf("receiving from %s: %v", e.q, err)
+			return nil
+		}
+		var found *sqs.Message
+		for _, msg := range msgs {
+			if found == nil && e.m.match(msg) {
+				found = msg
+				continue
+			}
+			seen++
+			if len(others) < maxSampleBodies {
+				others = append(others, msg)
+			}
+			e.q.ChangeMessageVisibility(aws.StringValue(msg.ReceiptHandle), 0)
+		}
+		if found != nil {
+			if err := e.q.DeleteMessage(aws.StringValue(found.ReceiptHandle)); err != nil {
+				e.t.Errorf("deleting message %s from %s: %v", aws.StringValue(found.MessageId), e.q, err)
+			}
+			return found
+		}
+		switch {
+		case len(msgs) > 0:
+			// Don't spin receiving the same unwanted messages.
+			time.Sleep(expectPollInterval)
+		case wait < time.Second:
+			<-ctx.Done()
+		}
+	}
+	e.t.Errorf("no message with %s received on %s within %v (%d other receives)%s", e.m, e.q, d, seen, sampleBodies(others))
+	return nil
+}
+
+// maxSampleBodies is how many message bodies failure messages show.
+const maxSampleBodies = 3
+
+// sampleBodies describes the bodies of up to maxSampleBodies of msgs,
+// for failure messages.
+func sampleBodies(msgs []*sqs.Message) string {
+	if len(msgs) == 0 {
+		return ""
+	}
+	var bodies []string
+	for i, m := range msgs {
+		if i == maxSampleBodies {
+			bodies = append(bodies, "...")
+			break
+		}
+		body := aws.StringValue(m.Body)
+		if len(body) > 100 {
+			body = body[:100] + "..."
+		}
+		bodies = append(bodies, strconv.Quote(body))
+	}
+	return "; messages seen include " + strings.Join(bodies, ", ")
+}
+
+// BucketExpectation is an assertion on an S3 bucket.
+type BucketExpectation struct {
+	t      TestingT
+	s      *FakeS3
+	bucket string
+	within time.Duration
+}
+
+// Bucket starts an assertion on bucket in s.
+func (e *Expectation) Bucket(s *FakeS3, bucket string) *BucketExpectation {
+	return &BucketExpectation{t: e.t, s: s, bucket: bucket}
+}
+
+// Within makes the assertion that follows poll for up to d for it to
+// hold, rather than check once.
+func (e *BucketExpectation) Within(d time.Duration) *BucketExpectation {
+	e.within = d
+	return e
+}
+
+// ToContainKey checks that the bucket contains key.
+func (e *BucketExpectation) ToContainKey(key string) {
+	e.t.Helper()
+	var err error
+	if !eventually(e.within, func() bool {
+		_, err = e.s.GetBytes(e.bucket, key)
+		return err == nil
+	}) {
+		e.t.Errorf("s3://%s/%s does not exist%s: %v", e.bucket, key, withinString(e.within), err)
+	}
+}
+
+// NotToContainKey checks that the bucket doesn't contain key.
+func (e *BucketExpectation) NotToContainKey(key string) {
+	e.t.Helper()
+	if !eventually(e.within, func() bool {
+		_, err := e.s.GetBytes(e.bucket, key)
+		return isNotFound(err)
+	}) {
+		e.t.Errorf("s3://%s/%s still exists%s", e.bucket, key, withinString(e.within))
+	}
+}
+
+// ToContainString checks that key holds the string want.
+func (e *BucketExpectation) ToContainString(key, want string) {
+	e.t.Helper()
+	var got []byte
+	var err error
+	if !eventually(e.within, func() bool {
+		got, err = e.s.GetBytes(e.bucket, key)
+		return err == nil && string(got) == want
+	}) {
+		if err != nil {
+			e.t.Errorf("s3://%s/%s does not exist%s: %v", e.bucket, key, withinString(e.within), err)
+		} else {
+			e.t.Errorf("s3://%s/%s = %q, want %q", e.bucket, key, got, want)
+		}
+	}
+}
+
+// RedisExpectation is an assertion on a redis database.
+type RedisExpectation struct {
+	t      TestingT
+	pool   *redis.Pool
+	within time.Duration
+}
+
+// Redis starts an assertion on the database pool connects to.
+func (e *Expectation) Redis(pool *redis.Pool) *RedisExpectation {
+	return &RedisExpectation{t: e.t, pool: pool}
+}
+
+// Within makes the assertion that follows poll for up to d for it to
+// hold, rather than check once.
+func (e *RedisExpectation) Within(d time.Duration) *RedisExpectation {
+	e.within = d
+	return e
+}
+
+// ToHaveKey checks that key exists.
+func (e *RedisExpectation) ToHaveKey(key string) {
+	e.t.Helper()
+	var err error
+	if !eventually(e.within, func() bool {
+		conn := e.pool.Get()
+		defer conn.Close()
+		var exists bool
+		exists, err = redis.Bool(conn.Do("EXISTS", key))
+		return exists
+	}) {
+		msg := fmt.Sprintf("redis key %q does not exist%s", key, withinString(e.within))
+		if err != nil {
+			msg += fmt.Sprintf(" (last error: %v)", err)
+		}
+		e.t.Errorf("%s", msg)
+	}
+}
+
+// ToHaveValue checks that key holds the string want.
+func (e *RedisExpectation) ToHaveValue(key, want string) {
+	e.t.Helper()
+	var got string
+	var err error
+	if !eventually(e.within, func() bool {
+		conn := e.pool.Get()
+		defer conn.Close()
+		got, err = redis.String(conn.Do("GET", key))
+		return err == nil && got == want
+	}) {
+		switch err {
+		case nil:
+			e.t.Errorf("redis key %q = %q, want %q", key, got, want)
+		case redis.ErrNil:
+			e.t.Errorf("redis key %q does not exist%s, want %q", key, withinString(e.within), want)
+		default:
+			e.t.Errorf("redis key %q: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package testutil
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/rainforestapp/testutil/redistest"
+)
+
+func TestExpectQueue(t *testing.T) {
+	q := NewMemoryQueue("events")
+	q.SendMessage(`{"type":"created","id":1}`, nil)
+	q.SendMessage(`{"type":"paid","id":1}`, map[string]string{"tenant": "acme"})
+
+	msg := Expect(t).Queue(q).ToReceive(MessageJSON(map[string]string{"type": "paid"}), MessageAttribute("tenant", "acme")).Within(time.Second)
+	if msg == nil || aws.StringValue(msg.Body) != `{"type":"paid","id":1}` {
+		t.Errorf("received %v", msg)
+	}
+	if waiting, inFlight := q.Len(); waiting != 1 || inFlight != 0 {
+		t.Errorf("Len() = %d, %d; want the other message left visible", waiting, inFlight)
+	}
+
+	rt := &recordingT{}
+	if msg := Expect(rt).Queue(q).ToReceive(BodyContaining("refunded")).Within(50 * time.Millisecond); msg != nil {
+		t.Errorf("received %v", msg)
+	}
+	if len(rt.errors) != 1 {
+		t.Errorf("failures = %q, want 1", rt.errors)
+	}
+}
+
+func TestExpectBucket(t *testing.T) {
+	srv := httptest.NewServer(&memS3{objects: make(map[string]string)})
+	defer srv.Close()
+	s := NewFakeS3("invoices", WithEndpoint(srv.URL), WithLogger(t))
+	defer s.Close()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		s.PutString("invoices", "a.txt", "A")
+	}()
+	Expect(t).Bucket(s, "invoices").Within(time.Second).ToContainString("a.txt", "A")
+	Expect(t).Bucket(s, "invoices").NotToContainKey("b.txt")
+
+	rt := &recordingT{}
+	Expect(rt).Bucket(s, "invoices").ToContainKey("b.txt")
+	Expect(rt).Bucket(s, "invoices").ToContainString("a.txt", "B")
+	if len(rt.errors) != 2 {
+		t.Errorf("failures = %q, want 2", rt.errors)
+	}
+}
+
+func TestExpectRedis(t *testing.T) {
+	conn := redistest.NewScriptedRedisConn().
+		On("EXISTS", 0).
+		On("EXISTS order:1", 1).
+		On("GET order:1", "paid")
+	pool := redistest.ScriptedPool(conn)
+
+	Expect(t).Redis(pool).ToHaveKey("order:1")
+	Expect(t).Redis(pool).ToHaveValue("order:1", "paid")
+
+	rt := &recordingT{}
+	Expect(rt).Redis(pool).Within(30 * time.Millisecond).ToHaveKey("order:2")
+	Expect(rt).Redis(pool).ToHaveValue("order:1", "refunded")
+	if len(rt.errors) != 2 {
+		t.Errorf("failures = %q, want 2", rt.errors)
+	}
+}
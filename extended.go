@@ -0,0 +1,209 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	// maxSQSMessageSize is the largest message body plus attributes
+	// SQS accepts.
+	maxSQSMessageSize = 256 * 1024
+
+	// s3PointerClass is the class name the SQS Extended Client puts at
+	// the start of message bodies that point at S3 objects.
+	s3PointerClass = "software.amazon.payloadoffloading.PayloadS3Pointer"
+
+	// extendedPayloadSizeAttribute holds the size of the offloaded
+	// payload. Older versions of the Extended Client use
+	// "SQSLargePayloadSize".
+	extendedPayloadSizeAttribute = "ExtendedPayloadSize"
+)
+
+// ExtendedSQS sends and receives messages in the format of the Amazon
+// SQS Extended Client Library, which stores bodies too large for SQS
+// in S3 and sends a pointer to the object instead. It lets the
+// handling of large messages be tested against FakeSQS and FakeS3, and
+// interoperates with consumers and producers using the real library.
+type ExtendedSQS struct {
+	Queue *FakeSQS
+	Store *FakeS3
+
+	// Bucket is the bucket large payloads are stored in.
+	Bucket string
+
+	// Threshold is the body size in bytes above which payloads are
+	// stored in S3. It defaults to the SQS limit of 256KB. Set
+	// AlwaysThroughS3 to store every payload in S3.
+	Threshold       int
+	AlwaysThroughS3 bool
+
+	// Keys names the S3 objects payloads are stored in. It defaults to
+	// random UUIDs; use a UUIDSequence for predictable keys.
+	Keys IDSource
+}
+
+// S3Pointer is the body of a message whose payload is stored in S3.
+type S3Pointer struct {
+	Bucket string `json:"s3BucketName"`
+	Key    string `json:"s3Key"`
+}
+
+// ParseS3Pointer reports whether body is an Extended Client pointer to
+// an S3 object and, if so, returns it.
+func ParseS3Pointer(body string) (S3Pointer, bool) {
+	var parts []json.RawMessage
+	if err := json.Unmarshal([]byte(body), &parts); err != nil || len(parts) != 2 {
+		return S3Pointer{}, false
+	}
+	var class string
+	var p S3Pointer
+	if json.Unmarshal(parts[0], &class) != nil || class != s3PointerClass {
+		return S3Pointer{}, false
+	}
+	if json.Unmarshal(parts[1], &p) != nil || p.Bucket == "" || p.Key == "" {
+		return S3Pointer{}, false
+	}
+	return p, true
+}
+
+func (p S3Pointer) body() string {
+	b, _ := json.Marshal([]interface{}{s3PointerClass, p})
+	return string(b)
+}
+
+// SendMessage sends body with the given string attributes, storing it
+// in S3 first if it is too large to send directly.
+func (e *ExtendedSQS) SendMessage(body string, attrs map[string]string) (*sqs.SendMessageOutput, error) {
+	threshold := e.Threshold
+	if threshold <= 0 {
+		threshold = maxSQSMessageSize
+	}
+	if !e.AlwaysThroughS3 && len(body)+attributesSize(attrs) <= threshold {
+		return e.Queue.SendMessage(body, attrs)
+	}
+
+	p := S3Pointer{Bucket: e.Bucket, Key: e.nextKey()}
+	if err := e.Store.put(p.Bucket, p.Key, []byte(body), "text/plain; charset=utf-8"); err != nil {
+		return nil, fmt.Errorf("storing payload in s3: %v", err)
+	}
+	msgAttrs := StringAttributes(attrs)
+	if msgAttrs == nil {
+		msgAttrs = make(map[string]*sqs.MessageAttributeValue)
+	}
+	msgAttrs[extendedPayloadSizeAttribute] = &sqs.MessageAttributeValue{
+		DataType:    aws.String("Number"),
+		StringValue: aws.String(strconv.Itoa(len(body))),
+	}
+	return e.Queue.Client.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:          &e.Queue.URL,
+		MessageBody:       aws.String(p.body()),
+		MessageAttributes: msgAttrs,
+	})
+}
+
+// ReceiveMessages receives messages like FakeSQS.ReceiveMessages, then
+// replaces the body of each one pointing at S3 with the stored
+// payload. The receipt handles of those messages are rewritten the way
+// the Extended Client does, so that DeleteMessage can find the object.
+func (e *ExtendedSQS) ReceiveMessages(max int64, wait time.Duration) ([]*sqs.Message, error) {
+	msgs, err := e.Queue.ReceiveMessages(max, wait)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs {
+		p, ok := ParseS3Pointer(aws.StringValue(msg.Body))
+		if !ok {
+			continue
+		}
+		payload, err := e.Store.GetString(p.Bucket, p.Key)
+		if err != nil {
+			return nil, fmt.Errorf("reading payload of message %s from s3: %v", aws.StringValue(msg.MessageId), err)
+		}
+		msg.Body = aws.String(payload)
+		msg.ReceiptHandle = aws.String(p.receiptHandle(aws.StringValue(msg.ReceiptHandle)))
+	}
+	return msgs, nil
+}
+
+// DeleteMessage deletes msg, received with ReceiveMessages, and the S3
+// object holding its payload if there is one.
+func (e *ExtendedSQS) DeleteMessage(msg *sqs.Message) error {
+	p, handle, ok := parseExtendedReceiptHandle(aws.StringValue(msg.ReceiptHandle))
+	if !ok {
+		handle = aws.StringValue(msg.ReceiptHandle)
+	}
+	_, err := e.Queue.Client.DeleteMessage(&sqs.DeleteMessageInput{
+		QueueUrl:      &e.Queue.URL,
+		ReceiptHandle: &handle,
+	})
+	if err != nil || !ok {
+		return err
+	}
+	_, err = e.Store.Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: &p.Bucket,
+		Key:    &p.Key,
+	})
+	return err
+}
+
+// AssertOffloaded checks that msg, as received from the queue directly
+// rather than through ReceiveMessages, points at a payload stored in
+// S3, and returns the pointer.
+func AssertOffloaded(t TestingT, msg *sqs.Message) S3Pointer {
+	t.Helper()
+	p, ok := ParseS3Pointer(aws.StringValue(msg.Body))
+	if !ok {
+		t.Errorf("message %s was sent inline, want its payload stored in s3", aws.StringValue(msg.MessageId))
+	}
+	return p
+}
+
+func (e *ExtendedSQS) nextKey() string {
+	if e.Keys != nil {
+		return e.Keys.NextID()
+	}
+	return randomUUID()
+}
+
+const (
+	bucketMarker = "-..s3BucketName..-"
+	keyMarker    = "-..s3Key..-"
+)
+
+// receiptHandle embeds p in handle in the Extended Client's format.
+func (p S3Pointer) receiptHandle(handle string) string {
+	return bucketMarker + p.Bucket + bucketMarker + keyMarker + p.Key + keyMarker + handle
+}
+
+func parseExtendedReceiptHandle(h string) (S3Pointer, string, bool) {
+	if !strings.HasPrefix(h, bucketMarker) {
+		return S3Pointer{}, h, false
+	}
+	parts := strings.SplitN(h[len(bucketMarker):], bucketMarker+keyMarker, 2)
+	if len(parts) != 2 {
+		return S3Pointer{}, h, false
+	}
+	keyAndHandle := strings.SplitN(parts[1], keyMarker, 2)
+	if len(keyAndHandle) != 2 {
+		return S3Pointer{}, h, false
+	}
+	return S3Pointer{Bucket: parts[0], Key: keyAndHandle[0]}, keyAndHandle[1], true
+}
+
+// attributesSize returns the size SQS counts against the message size
+// limit for attrs sent as String attributes.
+func attributesSize(attrs map[string]string) int {
+	n := 0
+	for k, v := range attrs {
+		n += len(k) + len("String") + len(v)
+	}
+	return n
+}
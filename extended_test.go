@@ -0,0 +1,38 @@
+package testutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestS3PointerRoundTrip(t *testing.T) {
+	p := S3Pointer{Bucket: "payloads", Key: "00000000-0000-4000-8000-000000000001"}
+	body := p.body()
+	want := `["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"payloads","s3Key":"00000000-0000-4000-8000-000000000001"}]`
+	if body != want {
+		t.Errorf("body = %s, want %s", body, want)
+	}
+	if got, ok := ParseS3Pointer(body); !ok || got != p {
+		t.Errorf("ParseS3Pointer = %+v, %v", got, ok)
+	}
+	for _, b := range []string{"hello", `["other.Class",{"s3BucketName":"b","s3Key":"k"}]`, "[]"} {
+		if _, ok := ParseS3Pointer(b); ok {
+			t.Errorf("ParseS3Pointer(%q) reported a pointer", b)
+		}
+	}
+}
+
+func TestExtendedReceiptHandle(t *testing.T) {
+	p := S3Pointer{Bucket: "payloads", Key: "k1"}
+	h := p.receiptHandle("abc==")
+	if !strings.HasPrefix(h, "-..s3BucketName..-payloads-..s3BucketName..--..s3Key..-k1-..s3Key..-") {
+		t.Errorf("unexpected receipt handle %q", h)
+	}
+	got, handle, ok := parseExtendedReceiptHandle(h)
+	if !ok || got != p || handle != "abc==" {
+		t.Errorf("parseExtendedReceiptHandle = %+v, %q, %v", got, handle, ok)
+	}
+	if _, handle, ok := parseExtendedReceiptHandle("abc=="); ok || handle != "abc==" {
+		t.Errorf("plain handle parsed as extended: %q, %v", handle, ok)
+	}
+}
@@ -0,0 +1,123 @@
+// Package factory builds test data for domain structs from registered
+// defaults, so tests state only the fields they care about, and
+// persists the built values straight into the testutil fakes.
+//
+// A factory is defined once, typically in a package-level variable:
+//
+//	var users = factory.Define(func(n int) User {
+//		return User{
+//			ID:    factory.Sequence("user-%d")(n),
+//			Email: fmt.Sprintf("user%d@example.com", n),
+//			Plan:  "free",
+//		}
+//	})
+//
+// and used in tests with overrides for the fields that matter:
+//
+//	u := users.Build(func(u *User) { u.Plan = "pro" })
+package factory
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/rainforestapp/testutil"
+)
+
+// Factory builds values of type T.
+type Factory[T any] struct {
+	defaults func(n int) T
+
+	mu  sync.Mutex
+	seq int
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[reflect.Type]interface{})
+)
+
+// Define returns a factory whose values start out as defaults(n),
+// where n is 1 for the first value built, 2 for the second and so on,
+// and registers it as the factory for T, replacing any earlier one.
+func Define[T any](defaults func(n int) T) *Factory[T] {
+	f := &Factory[T]{defaults: defaults}
+	registryMu.Lock()
+	registry[reflect.TypeOf((*T)(nil)).Elem()] = f
+	registryMu.Unlock()
+	return f
+}
+
+// For returns the factory registered for T by Define. It panics if
+// there is none.
+func For[T any]() *Factory[T] {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	registryMu.Lock()
+	f, ok := registry[t]
+	registryMu.Unlock()
+	if !ok {
+		panic(fmt.Sprintf("factory: no factory defined for %v", t))
+	}
+	return f.(*Factory[T])
+}
+
+// Build returns the next value from f with overrides applied in order.
+func (f *Factory[T]) Build(overrides ...func(*T)) T {
+	f.mu.Lock()
+	f.seq++
+	n := f.seq
+	f.mu.Unlock()
+
+	v := f.defaults(n)
+	for _, o := range overrides {
+		o(&v)
+	}
+	return v
+}
+
+// BuildList returns the next n values from f, each with overrides
+// applied.
+func (f *Factory[T]) BuildList(n int, overrides ...func(*T)) []T {
+	vs := make([]T, n)
+	for i := range vs {
+		vs[i] = f.Build(overrides...)
+	}
+	return vs
+}
+
+// Reset restarts f's sequence, so the next value built is number 1
+// again.
+func (f *Factory[T]) Reset() {
+	f.mu.Lock()
+	f.seq = 0
+	f.mu.Unlock()
+}
+
+// PutJSON builds a value, stores it as a JSON object in bucket under
+// the key returned by key, and returns it.
+func (f *Factory[T]) PutJSON(s *testutil.FakeS3, bucket string, key func(T) string, overrides ...func(*T)) (T, error) {
+	v := f.Build(overrides...)
+	return v, s.PutJSON(bucket, key(v), v)
+}
+
+// SendJSON builds a value, sends it to q as a JSON message body, and
+// returns it.
+func (f *Factory[T]) SendJSON(q *testutil.FakeSQS, overrides ...func(*T)) (T, error) {
+	v := f.Build(overrides...)
+	body, err := json.Marshal(v)
+	if err != nil {
+		return v, err
+	}
+	_, err = q.SendMessage(string(body), nil)
+	return v, err
+}
+
+// Sequence returns a function that formats n with format, for
+// sequenced string fields such as IDs and email addresses.
+func Sequence(format string) func(n int) string {
+	return func(n int) string {
+		return fmt.Sprintf(format, n)
+	}
+}
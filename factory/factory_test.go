@@ -0,0 +1,60 @@
+package factory
+
+import (
+	"fmt"
+	"testing"
+)
+
+type user struct {
+	ID   string
+	Plan string
+}
+
+func TestBuild(t *testing.T) {
+	users := Define(func(n int) user {
+		return user{ID: Sequence("user-%d")(n), Plan: "free"}
+	})
+
+	if got, want := users.Build(), (user{"user-1", "free"}); got != want {
+		t.Errorf("Build() = %+v, want %+v", got, want)
+	}
+	got := users.Build(func(u *user) { u.Plan = "pro" })
+	if want := (user{"user-2", "pro"}); got != want {
+		t.Errorf("Build(override) = %+v, want %+v", got, want)
+	}
+
+	list := For[user]().BuildList(2)
+	if list[0].ID != "user-3" || list[1].ID != "user-4" {
+		t.Errorf("BuildList(2) = %+v, want user-3 and user-4", list)
+	}
+
+	users.Reset()
+	if got := users.Build().ID; got != "user-1" {
+		t.Errorf("after Reset, ID = %q, want user-1", got)
+	}
+}
+
+func TestForUndefined(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected For to panic for a type without a factory")
+		}
+	}()
+	For[struct{ X int }]()
+}
+
+func ExampleDefine() {
+	type order struct {
+		ID    string
+		Total int
+	}
+	orders := Define(func(n int) order {
+		return order{ID: Sequence("order-%d")(n), Total: 100}
+	})
+
+	fmt.Println(orders.Build())
+	fmt.Println(orders.Build(func(o *order) { o.Total = 0 }))
+	// Output:
+	// {order-1 100}
+	// {order-2 0}
+}
@@ -0,0 +1,149 @@
+package testutil
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+	"testing/fstest"
+	"time"
+
+	"github.com/rainforestapp/testutil/internal/diff"
+)
+
+// FakeFS is an in-memory filesystem for code that reads from an fs.FS
+// or stages files locally, for example before uploading them to S3.
+// It implements fs.FS, so it can be passed to such code directly, and
+// records what is written to it for assertions. Names are slash
+// separated and unrooted, as with fs.FS.
+//
+// A FakeFS is safe for concurrent use.
+type FakeFS struct {
+	mu    sync.Mutex
+	files fstest.MapFS
+}
+
+// NewFakeFS returns a FakeFS holding files, which maps file names to
+// contents. Parent directories are implied.
+func NewFakeFS(files map[string]string) *FakeFS {
+	f := &FakeFS{files: make(fstest.MapFS)}
+	for name, contents := range files {
+		f.files[name] = &fstest.MapFile{Data: []byte(contents), Mode: 0644, ModTime: time.Now()}
+	}
+	return f
+}
+
+// Open implements fs.FS.
+func (f *FakeFS) Open(name string) (fs.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.files.Open(name)
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (f *FakeFS) ReadFile(name string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.files.ReadFile(name)
+}
+
+// WriteFile writes data to the file name, creating it if necessary.
+func (f *FakeFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[name] = &fstest.MapFile{
+		Data:    append([]byte(nil), data...),
+		Mode:    perm,
+		ModTime: time.Now(),
+	}
+	return nil
+}
+
+// Create returns a writer for the file name. The file's contents are
+// replaced when the writer is closed.
+func (f *FakeFS) Create(name string) (io.WriteCloser, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrInvalid}
+	}
+	return &fakeFile{fs: f, name: name}, nil
+}
+
+// Remove deletes the file name.
+func (f *FakeFS) Remove(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(f.files, name)
+	return nil
+}
+
+// Files returns every file in f, mapping names to contents.
+func (f *FakeFS) Files() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	files := make(map[string]string, len(f.files))
+	for name, file := range f.files {
+		if !file.Mode.IsDir() {
+			files[name] = string(file.Data)
+		}
+	}
+	return files
+}
+
+// AssertFile checks that the file name holds want.
+func (f *FakeFS) AssertFile(t TestingT, name, want string) {
+	t.Helper()
+	got, err := f.ReadFile(name)
+	if err != nil {
+		t.Errorf("reading %s: %v", name, err)
+	} else if string(got) != want {
+		t.Errorf("file %s = %q, want %q", name, got, want)
+	}
+}
+
+// AssertNoFile checks that there is no file name.
+func (f *FakeFS) AssertNoFile(t TestingT, name string) {
+	t.Helper()
+	if _, err := f.ReadFile(name); err == nil {
+		t.Errorf("file %s exists, want it not to", name)
+	}
+}
+
+// AssertFiles checks that the files under dir are exactly those in
+// want, which maps names relative to dir to contents. A dir of "."
+// checks the whole filesystem.
+func (f *FakeFS) AssertFiles(t TestingT, dir string, want map[string]string) {
+	t.Helper()
+	got := make(map[string]string)
+	for name, contents := range f.Files() {
+		if dir == "." {
+			got[name] = contents
+		} else if prefix := path.Clean(dir) + "/"; strings.HasPrefix(name, prefix) {
+			got[strings.TrimPrefix(name, prefix)] = contents
+		}
+	}
+	if d := diff.Maps(got, want); d != "" {
+		t.Errorf("files under %s differ (- missing, + unexpected, ~ changed):\n%s", dir, d)
+	}
+}
+
+type fakeFile struct {
+	fs   *FakeFS
+	name string
+	buf  bytes.Buffer
+}
+
+func (w *fakeFile) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *fakeFile) Close() error {
+	return w.fs.WriteFile(w.name, w.buf.Bytes(), 0644)
+}
@@ -0,0 +1,43 @@
+package testutil
+
+import (
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFakeFS(t *testing.T) {
+	f := NewFakeFS(map[string]string{
+		"in/a.csv": "id\n1\n",
+		"in/b.csv": "id\n2\n",
+	})
+	if err := fstest.TestFS(f, "in/a.csv", "in/b.csv"); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := fs.Glob(f, "in/*.csv")
+	if err != nil || len(names) != 2 {
+		t.Fatalf("Glob = %v, %v", names, err)
+	}
+
+	w, err := f.Create("out/merged.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "id\n1\n2\n")
+	f.AssertNoFile(t, "out/merged.csv")
+	w.Close()
+	f.AssertFile(t, "out/merged.csv", "id\n1\n2\n")
+
+	if err := f.Remove("in/b.csv"); err != nil {
+		t.Fatal(err)
+	}
+	f.AssertFiles(t, "in", map[string]string{"a.csv": "id\n1\n"})
+
+	rt := &recordingT{}
+	f.AssertFiles(rt, ".", map[string]string{"in/a.csv": "id\n1\n"})
+	if len(rt.errors) != 1 {
+		t.Errorf("expected unexpected out/merged.csv to be reported, got %v", rt.errors)
+	}
+}
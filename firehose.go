@@ -0,0 +1,418 @@
+package testutil
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/firehose"
+)
+
+// FakeFirehose is an in-process fake of Kinesis Firehose that delivers
+// the records put to its delivery streams into a FakeS3 bucket, the way
+// an S3 destination does: buffered into objects, optionally gzipped,
+// under Prefix + "YYYY/MM/DD/HH/" in UTC. Pipelines writing through
+// Firehose can then be checked by reading the bucket.
+//
+// A stream's buffer is delivered once it reaches the stream's size
+// hint, on the first put after its interval hint has passed (as told
+// by the Clock set with WithClock), or when Flush is called. Nothing is
+// delivered in the background.
+type FakeFirehose struct {
+	// Client is a Firehose client set up for the fake.
+	Client *firehose.Firehose
+
+	// Session is an AWS Session that uses the fake config.
+	Session *session.Session
+
+	// Config is the AWS config the Session was built from.
+	Config *aws.Config
+
+	// URL is the fake's endpoint.
+	URL string
+
+	s3         *FakeS3
+	region     string
+	clock      Clock
+	logger     Logger
+	srv        *httptest.Server
+	unregister func()
+
+	mu      sync.Mutex
+	streams map[string]*firehoseStream
+}
+
+// FirehoseS3Destination configures where a delivery stream delivers.
+type FirehoseS3Destination struct {
+	Bucket string
+	Prefix string
+
+	// Gzip compresses each delivered object, which is then named with
+	// a .gz extension.
+	Gzip bool
+
+	// SizeInMBs and Interval are the buffering hints. They default to
+	// 5MB and 300s, as in Firehose.
+	SizeInMBs int
+	Interval  time.Duration
+}
+
+type firehoseStream struct {
+	name    string
+	dest    FirehoseS3Destination
+	created time.Time
+
+	buf      bytes.Buffer
+	bufStart time.Time
+	records  [][]byte
+	objects  []string
+}
+
+// NewFakeFirehose starts a FakeFirehose that delivers into buckets of
+// s3.
+func NewFakeFirehose(s3 *FakeS3, opts ...Option) *FakeFirehose {
+	o := newOptions(opts)
+	f := &FakeFirehose{
+		s3:      s3,
+		region:  o.region,
+		clock:   o.clock,
+		logger:  o.logger,
+		streams: make(map[string]*firehoseStream),
+	}
+	if f.region == "" {
+		f.region = defaultRegion
+	}
+	f.srv = httptest.NewServer(&jsonRPCHandler{
+		targetPrefix: "Firehose_20150804",
+		operations: map[string]func([]byte) (interface{}, error){
+			"CreateDeliveryStream":   f.createDeliveryStream,
+			"DescribeDeliveryStream": f.describeDeliveryStream,
+			"DeleteDeliveryStream":   f.deleteDeliveryStream,
+			"ListDeliveryStreams":    f.listDeliveryStreams,
+			"PutRecord":              f.putRecord,
+			"PutRecordBatch":         f.putRecordBatch,
+		},
+	})
+	f.URL = f.srv.URL
+	f.Config = fakeAWSConfig(f.URL, o)
+	f.Session = session.New(f.Config)
+	f.Client = firehose.New(f.Session)
+	installHooks(o, &f.Session.Handlers, &f.Client.Handlers)
+	f.unregister = OnInterrupt(f.Close)
+	return f
+}
+
+// Close stops the fake. Records still buffered are not delivered; call
+// Flush first to deliver them.
+func (f *FakeFirehose) Close() {
+	if f.unregister != nil {
+		f.unregister()
+	}
+	f.srv.Close()
+}
+
+// AddStream creates the delivery stream name, as CreateDeliveryStream
+// would.
+func (f *FakeFirehose) AddStream(name string, dest FirehoseS3Destination) {
+	if dest.SizeInMBs == 0 {
+		dest.SizeInMBs = 5
+	}
+	if dest.Interval == 0 {
+		dest.Interval = 300 * time.Second
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.streams[name] = &firehoseStream{name: name, dest: dest, created: f.clock.Now()}
+}
+
+// Flush delivers every stream's buffered records.
+func (f *FakeFirehose) Flush() error {
+	f.mu.Lock()
+	var pending []firehoseObject
+	for _, s := range f.streams {
+		if obj, ok := f.cut(s); ok {
+			pending = append(pending, obj)
+		}
+	}
+	f.mu.Unlock()
+
+	var errs []string
+	for _, obj := range pending {
+		if err := f.deliver(obj); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("firehose delivery failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Records returns every record put to stream, in order, whether or not
+// it has been delivered yet.
+func (f *FakeFirehose) Records(stream string) [][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.streams[stream]; ok {
+		return append([][]byte(nil), s.records...)
+	}
+	return nil
+}
+
+// Objects returns the keys of the objects stream has delivered, in
+// order.
+func (f *FakeFirehose) Objects(stream string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.streams[stream]; ok {
+		return append([]string(nil), s.objects...)
+	}
+	return nil
+}
+
+// firehoseObject is a buffer cut from a stream, waiting to be written.
+type firehoseObject struct {
+	stream, bucket, key string
+	body                []byte
+}
+
+// cut takes s's buffer as an object to deliver and records its key.
+// It reports false if the buffer is empty. f.mu must be held.
+func (f *FakeFirehose) cut(s *firehoseStream) (firehoseObject, bool) {
+	if s.buf.Len() == 0 {
+		return firehoseObject{}, false
+	}
+	now := f.clock.Now().UTC()
+	key := s.dest.Prefix + now.Format("2006/01/02/15/") +
+		fmt.Sprintf("%s-1-%s-%s", s.name, now.Format("2006-01-02-15-04-05"), randomUUID())
+	body := append([]byte(nil), s.buf.Bytes()...)
+	if s.dest.Gzip {
+		key += ".gz"
+		body = Gzip(body)
+	}
+	s.buf.Reset()
+	s.objects = append(s.objects, key)
+	return firehoseObject{stream: s.name, bucket: s.dest.Bucket, key: key, body: body}, true
+}
+
+func (f *FakeFirehose) deliver(obj firehoseObject) error {
+	if err := f.s3.put(obj.bucket, obj.key, obj.body, "application/octet-stream"); err != nil {
+		return fmt.Errorf("%s to s3://%s/%s: %v", obj.stream, obj.bucket, obj.key, err)
+	}
+	return nil
+}
+
+// maxFirehoseRecord is the largest record Firehose accepts.
+const maxFirehoseRecord = 1000 << 10
+
+// put buffers records on the stream name and delivers whatever the
+// buffering hints say is due.
+func (f *FakeFirehose) put(name string, records [][]byte) ([]string, error) {
+	for _, r := range records {
+		if len(r) > maxFirehoseRecord {
+			return nil, newAWSError(http.StatusBadRequest, "ValidationException", "Record size must be at most 1000 KiB")
+		}
+	}
+
+	var (
+		ids     []string
+		pending []firehoseObject
+	)
+	f.mu.Lock()
+	s, ok := f.streams[name]
+	if !ok {
+		f.mu.Unlock()
+		return nil, newAWSError(http.StatusBadRequest, "ResourceNotFoundException", "Stream %s under account %s not found.", name, fakeAccountID)
+	}
+	for _, r := range records {
+		now := f.clock.Now()
+		if s.buf.Len() > 0 && now.Sub(s.bufStart) >= s.dest.Interval {
+			if obj, ok := f.cut(s); ok {
+				pending = append(pending, obj)
+			}
+		}
+		if s.buf.Len() == 0 {
+			s.bufStart = now
+		}
+		s.buf.Write(r)
+		s.records = append(s.records, r)
+		ids = append(ids, randomToken())
+		if s.buf.Len() >= s.dest.SizeInMBs<<20 {
+			if obj, ok := f.cut(s); ok {
+				pending = append(pending, obj)
+			}
+		}
+	}
+	f.mu.Unlock()
+
+	for _, obj := range pending {
+		if err := f.deliver(obj); err != nil {
+			f.logger.Errorf("firehose delivery failed: %v", err)
+		}
+	}
+	return ids, nil
+}
+
+type firehoseRequest struct {
+	DeliveryStreamName               string
+	ExclusiveStartDeliveryStreamName string
+	Record                           struct{ Data []byte }
+	Records                          []struct{ Data []byte }
+	S3DestinationConfiguration       *struct {
+		BucketARN         string
+		Prefix            string
+		CompressionFormat string
+		BufferingHints    struct {
+			IntervalInSeconds int
+			SizeInMBs         int
+		}
+	}
+}
+
+func (f *FakeFirehose) createDeliveryStream(body []byte) (interface{}, error) {
+	var req firehoseRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	c := req.S3DestinationConfiguration
+	if c == nil {
+		return nil, newAWSError(http.StatusBadRequest, "InvalidArgumentException", "the fake only supports S3 destinations")
+	}
+	switch c.CompressionFormat {
+	case "", "UNCOMPRESSED", "GZIP":
+	default:
+		return nil, newAWSError(http.StatusBadRequest, "InvalidArgumentException", "the fake does not support %s compression", c.CompressionFormat)
+	}
+	f.mu.Lock()
+	_, exists := f.streams[req.DeliveryStreamName]
+	f.mu.Unlock()
+	if exists {
+		return nil, newAWSError(http.StatusBadRequest, "ResourceInUseException", "Firehose %s under accountId %s already exists", req.DeliveryStreamName, fakeAccountID)
+	}
+	f.AddStream(req.DeliveryStreamName, FirehoseS3Destination{
+		Bucket:    strings.TrimPrefix(c.BucketARN, "arn:aws:s3:::"),
+		Prefix:    c.Prefix,
+		Gzip:      c.CompressionFormat == "GZIP",
+		SizeInMBs: c.BufferingHints.SizeInMBs,
+		Interval:  time.Duration(c.BufferingHints.IntervalInSeconds) * time.Second,
+	})
+	return map[string]string{"DeliveryStreamARN": f.streamARN(req.DeliveryStreamName)}, nil
+}
+
+func (f *FakeFirehose) streamARN(name string) string {
+	return fmt.Sprintf("arn:aws:firehose:%s:%s:deliverystream/%s", f.region, fakeAccountID, name)
+}
+
+func (f *FakeFirehose) describeDeliveryStream(body []byte) (interface{}, error) {
+	var req firehoseRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.streams[req.DeliveryStreamName]
+	if !ok {
+		return nil, newAWSError(http.StatusBadRequest, "ResourceNotFoundException", "Firehose %s under account %s not found.", req.DeliveryStreamName, fakeAccountID)
+	}
+	compression := "UNCOMPRESSED"
+	if s.dest.Gzip {
+		compression = "GZIP"
+	}
+	return map[string]interface{}{
+		"DeliveryStreamDescription": map[string]interface{}{
+			"DeliveryStreamName":   s.name,
+			"DeliveryStreamARN":    f.streamARN(s.name),
+			"DeliveryStreamStatus": "ACTIVE",
+			"CreateTimestamp":      s.created.Unix(),
+			"VersionId":            "1",
+			"HasMoreDestinations":  false,
+			"Destinations": []interface{}{map[string]interface{}{
+				"DestinationId": "destinationId-000000000001",
+				"S3DestinationDescription": map[string]interface{}{
+					"BucketARN":               "arn:aws:s3:::" + s.dest.Bucket,
+					"Prefix":                  s.dest.Prefix,
+					"CompressionFormat":       compression,
+					"RoleARN":                 fmt.Sprintf("arn:aws:iam::%s:role/firehose_delivery_role", fakeAccountID),
+					"EncryptionConfiguration": map[string]string{"NoEncryptionConfig": "NoEncryption"},
+					"BufferingHints": map[string]int{
+						"SizeInMBs":         s.dest.SizeInMBs,
+						"IntervalInSeconds": int(s.dest.Interval / time.Second),
+					},
+				},
+			}},
+		},
+	}, nil
+}
+
+func (f *FakeFirehose) deleteDeliveryStream(body []byte) (interface{}, error) {
+	var req firehoseRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.streams[req.DeliveryStreamName]; !ok {
+		return nil, newAWSError(http.StatusBadRequest, "ResourceNotFoundException", "Firehose %s under account %s not found.", req.DeliveryStreamName, fakeAccountID)
+	}
+	delete(f.streams, req.DeliveryStreamName)
+	return struct{}{}, nil
+}
+
+func (f *FakeFirehose) listDeliveryStreams(body []byte) (interface{}, error) {
+	var req firehoseRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := []string{}
+	for name := range f.streams {
+		if name > req.ExclusiveStartDeliveryStreamName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return map[string]interface{}{"DeliveryStreamNames": names, "HasMoreDeliveryStreams": false}, nil
+}
+
+func (f *FakeFirehose) putRecord(body []byte) (interface{}, error) {
+	var req firehoseRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	ids, err := f.put(req.DeliveryStreamName, [][]byte{req.Record.Data})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"RecordId": ids[0]}, nil
+}
+
+func (f *FakeFirehose) putRecordBatch(body []byte) (interface{}, error) {
+	var req firehoseRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	if len(req.Records) > 500 {
+		return nil, newAWSError(http.StatusBadRequest, "ValidationException", "1 validation error detected: Value at 'records' failed to satisfy constraint: Member must have length less than or equal to 500")
+	}
+	records := make([][]byte, len(req.Records))
+	for i, r := range req.Records {
+		records[i] = r.Data
+	}
+	ids, err := f.put(req.DeliveryStreamName, records)
+	if err != nil {
+		return nil, err
+	}
+	responses := make([]map[string]string, len(ids))
+	for i, id := range ids {
+		responses[i] = map[string]string{"RecordId": id}
+	}
+	return map[string]interface{}{"FailedPutCount": 0, "RequestResponses": responses}, nil
+}
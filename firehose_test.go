@@ -0,0 +1,122 @@
+package testutil
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// newRecordingS3 returns a FakeS3 whose server just records the
+// objects put to it, by path.
+func newRecordingS3(t *testing.T) (*FakeS3, func() map[string]string) {
+	var mu sync.Mutex
+	objects := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		objects[r.URL.Path] = string(body)
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	return &FakeS3{Client: s3.New(session.New(fakeAWSConfig(srv.URL, newOptions(nil))))}, func() map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		copied := make(map[string]string, len(objects))
+		for k, v := range objects {
+			copied[k] = v
+		}
+		return copied
+	}
+}
+
+func TestFakeFirehoseDelivers(t *testing.T) {
+	s3, objects := newRecordingS3(t)
+	clock := NewFakeClock(time.Date(2018, 4, 9, 13, 5, 0, 0, time.UTC))
+	f := NewFakeFirehose(s3, WithClock(clock), WithLogger(t))
+	defer f.Close()
+
+	_, err := f.Client.CreateDeliveryStream(&firehose.CreateDeliveryStreamInput{
+		DeliveryStreamName: aws.String("clicks"),
+		S3DestinationConfiguration: &firehose.S3DestinationConfiguration{
+			BucketARN:         aws.String("arn:aws:s3:::analytics"),
+			RoleARN:           aws.String("arn:aws:iam::123456789012:role/firehose"),
+			Prefix:            aws.String("raw/"),
+			CompressionFormat: aws.String("GZIP"),
+			BufferingHints:    &firehose.BufferingHints{IntervalInSeconds: aws.Int64(60), SizeInMBs: aws.Int64(1)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(records ...string) {
+		t.Helper()
+		var entries []*firehose.Record
+		for _, r := range records {
+			entries = append(entries, &firehose.Record{Data: []byte(r)})
+		}
+		out, err := f.Client.PutRecordBatch(&firehose.PutRecordBatchInput{
+			DeliveryStreamName: aws.String("clicks"),
+			Records:            entries,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out.RequestResponses) != len(records) {
+			t.Fatalf("got %d responses for %d records", len(out.RequestResponses), len(records))
+		}
+	}
+
+	put("a\n", "b\n")
+	if n := len(objects()); n != 0 {
+		t.Fatalf("delivered %d objects before the buffer was due", n)
+	}
+	clock.Advance(time.Minute)
+	put("c\n")
+
+	delivered := objects()
+	keys := f.Objects("clicks")
+	if len(delivered) != 1 || len(keys) != 1 {
+		t.Fatalf("delivered %v (keys %q), want one object", delivered, keys)
+	}
+	if !strings.HasPrefix(keys[0], "raw/2018/04/09/13/clicks-1-2018-04-09-13-06-00-") || !strings.HasSuffix(keys[0], ".gz") {
+		t.Errorf("unexpected key %s", keys[0])
+	}
+	body, err := Gunzip([]byte(delivered["/analytics/"+keys[0]]))
+	if err != nil || string(body) != "a\nb\n" {
+		t.Errorf("object body = %q, %v", body, err)
+	}
+
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(objects()); n != 2 {
+		t.Errorf("Flush left %d objects, want 2", n)
+	}
+	if n := len(f.Records("clicks")); n != 3 {
+		t.Errorf("recorded %d records, want 3", n)
+	}
+}
+
+func TestFakeFirehoseUnknownStream(t *testing.T) {
+	s3, _ := newRecordingS3(t)
+	f := NewFakeFirehose(s3, WithLogger(t))
+	defer f.Close()
+
+	_, err := f.Client.PutRecord(&firehose.PutRecordInput{
+		DeliveryStreamName: aws.String("missing"),
+		Record:             &firehose.Record{Data: []byte("x")},
+	})
+	if err == nil || !strings.Contains(err.Error(), "ResourceNotFoundException") {
+		t.Errorf("expected ResourceNotFoundException, got %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package testutil
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxS3KeyLength is the longest object key S3 accepts, in bytes.
+const maxS3KeyLength = 1024
+
+// AdversarialBodies returns message bodies chosen to break parsers fed
+// from queues: empty and oversized bodies, bodies at and just over the
+// SQS size limit, invalid UTF-8, characters SQS rejects, deeply nested
+// and otherwise hostile JSON.
+func AdversarialBodies() [][]byte {
+	return [][]byte{
+		{},
+		[]byte(" "),
+		[]byte("null"),
+		[]byte("{}"),
+		[]byte("[]"),
+		[]byte(`"just a string"`),
+		[]byte("\xef\xbb\xbf{\"bom\":true}"),
+		[]byte("\xff\xfe\xfd"),
+		[]byte("{\"a\":\"\xc3\x28\"}"),
+		[]byte("{\"a\":\"\\ud800\"}"),
+		[]byte("nul\x00byte"),
+		[]byte("\x01\x02\x03\x1b[31mred\x1b[0m"),
+		[]byte(`{"a":1,"a":2}`),
+		[]byte(`{"n":1e999999}`),
+		[]byte(`{"n":-0.0000000000000000000000000000001}`),
+		[]byte(`{"n":123456789012345678901234567890}`),
+		[]byte(`{"unterminated":`),
+		[]byte(`{"a":1}{"b":2}`),
+		[]byte(`{"__proto__":{"admin":true}}`),
+		[]byte("\u202eevil\u202c"),
+		[]byte(strings.Repeat("[", 10000) + strings.Repeat("]", 10000)),
+		[]byte(strings.Repeat(`{"a":`, 10000) + "1" + strings.Repeat("}", 10000)),
+		[]byte(`{"s":"` + strings.Repeat("x", maxSQSMessageSize-8) + `"}`),
+		[]byte(strings.Repeat("x", maxSQSMessageSize)),
+		[]byte(strings.Repeat("x", maxSQSMessageSize+1)),
+		[]byte(strings.Repeat("\U0001F4A9", maxSQSMessageSize/4+1)),
+	}
+}
+
+// AdversarialKeys returns S3 object keys chosen to break code that
+// builds, parses or stores keys: path-like oddities, characters that
+// need escaping in URLs, unicode that normalizes or displays
+// differently, and keys at and just over the length limit.
+func AdversarialKeys() []string {
+	return []string{
+		"a",
+		" ",
+		"/leading",
+		"trailing/",
+		"double//slash",
+		"../escape",
+		"./dot",
+		"a/./b/../c",
+		"percent%2Fencoded",
+		"query?x=1",
+		"fragment#top",
+		"plus+and space",
+		"back\\slash",
+		"new\nline",
+		"tab\tkey",
+		"ctrl\x01char",
+		"caf\u00e9",
+		"cafe\u0301",
+		"\u202egnp.exe",
+		"zero\u200bwidth",
+		"\U0001F4A9/\U0001F4A9",
+		"\u65e5\u672c\u8a9e/\u30d5\u30a1\u30a4\u30eb",
+		"CON",
+		"*",
+		"~",
+		strings.Repeat("k", maxS3KeyLength),
+		strings.Repeat("k", maxS3KeyLength+1),
+		strings.Repeat("\u00e9", maxS3KeyLength/2),
+	}
+}
+
+// PayloadGenerator produces random adversarial message bodies and S3
+// keys, mixing the fixed ones from AdversarialBodies and AdversarialKeys
+// with generated ones. The same seed gives the same sequence. It is not
+// safe for concurrent use.
+type PayloadGenerator struct {
+	rnd    *rand.Rand
+	bodies [][]byte
+	keys   []string
+}
+
+// NewPayloadGenerator returns a PayloadGenerator seeded with seed.
+func NewPayloadGenerator(seed int64) *PayloadGenerator {
+	return &PayloadGenerator{
+		rnd:    rand.New(rand.NewSource(seed)),
+		bodies: AdversarialBodies(),
+		keys:   AdversarialKeys(),
+	}
+}
+
+// Body returns an adversarial message body.
+func (g *PayloadGenerator) Body() []byte {
+	switch g.rnd.Intn(4) {
+	case 0:
+		return append([]byte(nil), g.bodies[g.rnd.Intn(len(g.bodies))]...)
+	case 1:
+		b := make([]byte, g.rnd.Intn(1024))
+		g.rnd.Read(b)
+		return b
+	case 2:
+		return []byte(g.json(g.rnd.Intn(64)))
+	default:
+		return []byte(g.unicode(g.rnd.Intn(256)))
+	}
+}
+
+// Key returns an adversarial S3 key.
+func (g *PayloadGenerator) Key() string {
+	if g.rnd.Intn(2) == 0 {
+		return g.keys[g.rnd.Intn(len(g.keys))]
+	}
+	parts := make([]string, 1+g.rnd.Intn(4))
+	for i := range parts {
+		parts[i] = g.unicode(1 + g.rnd.Intn(16))
+	}
+	return strings.Join(parts, "/")
+}
+
+// json returns a random JSON-ish value nested up to depth levels. It
+// is valid JSON most of the time.
+func (g *PayloadGenerator) json(depth int) string {
+	if depth == 0 {
+		switch g.rnd.Intn(5) {
+		case 0:
+			return strconv.Quote(g.unicode(g.rnd.Intn(16)))
+		case 1:
+			return strconv.FormatFloat(g.rnd.NormFloat64()*1e12, 'g', -1, 64)
+		case 2:
+			return "null"
+		case 3:
+			return "true"
+		default:
+			return `"\ud800"`
+		}
+	}
+	if g.rnd.Intn(2) == 0 {
+		return "[" + g.json(depth-1) + "]"
+	}
+	return `{"` + strconv.Itoa(g.rnd.Intn(3)) + `":` + g.json(depth-1) + "}"
+}
+
+// unicodeRanges are the code point ranges unicode draws from: ASCII
+// controls and printables, combining marks, bidi controls and
+// zero-width characters, CJK and emoji.
+var unicodeRanges = [][2]rune{
+	{0x00, 0x7f},
+	{0x0300, 0x036f},
+	{0x200b, 0x200f},
+	{0x202a, 0x202e},
+	{0x4e00, 0x4e40},
+	{0x1f600, 0x1f64f},
+}
+
+func (g *PayloadGenerator) unicode(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		r := unicodeRanges[g.rnd.Intn(len(unicodeRanges))]
+		b.WriteRune(r[0] + rune(g.rnd.Intn(int(r[1]-r[0]+1))))
+	}
+	return b.String()
+}
+
+// FuzzSeeder is the part of *testing.F used by SeedFuzz.
+type FuzzSeeder interface {
+	Add(args ...interface{})
+}
+
+// SeedFuzz adds each input to f's seed corpus, for fuzz targets that
+// take a single []byte or string.
+func SeedFuzz[T []byte | string](f FuzzSeeder, inputs []T) {
+	for _, in := range inputs {
+		f.Add(in)
+	}
+}
+
+// WriteFuzzCorpus writes inputs to dir in the format go test -fuzz reads
+// its corpus in, one file per input, for fuzz targets that take a
+// single []byte or string. dir is usually testdata/fuzz/FuzzName, which
+// go test reads seeds from on every run.
+func WriteFuzzCorpus[T []byte | string](dir string, inputs []T) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, in := range inputs {
+		var v string
+		switch in := interface{}(in).(type) {
+		case []byte:
+			v = "[]byte(" + strconv.Quote(string(in)) + ")"
+		case string:
+			v = "string(" + strconv.Quote(in) + ")"
+		}
+		data := []byte("go test fuzz v1\n" + v + "\n")
+		name := fmt.Sprintf("%x", sha256.Sum256(data))[:16]
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
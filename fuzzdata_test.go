@@ -0,0 +1,69 @@
+package testutil
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestPayloadGenerator(t *testing.T) {
+	a, b := NewPayloadGenerator(7), NewPayloadGenerator(7)
+	invalid := 0
+	for i := 0; i < 200; i++ {
+		body := a.Body()
+		if !reflect.DeepEqual(body, b.Body()) || a.Key() != b.Key() {
+			t.Fatalf("generators with the same seed diverged at %d", i)
+		}
+		if !utf8.Valid(body) {
+			invalid++
+		}
+	}
+	if invalid == 0 {
+		t.Error("no invalid UTF-8 bodies generated")
+	}
+
+	var over bool
+	for _, k := range AdversarialKeys() {
+		over = over || len(k) > maxS3KeyLength
+	}
+	if !over {
+		t.Error("no key over the S3 length limit")
+	}
+}
+
+type seeds []interface{}
+
+func (s *seeds) Add(args ...interface{}) { *s = append(*s, args...) }
+
+func TestFuzzCorpus(t *testing.T) {
+	var s seeds
+	SeedFuzz(&s, AdversarialKeys())
+	if len(s) != len(AdversarialKeys()) {
+		t.Errorf("SeedFuzz added %d seeds", len(s))
+	}
+
+	dir := filepath.Join(t.TempDir(), "testdata", "fuzz", "FuzzParse")
+	if err := WriteFuzzCorpus(dir, [][]byte{[]byte("\xff\"x"), []byte("ok")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFuzzCorpus(dir, []string{"é"}); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	contents := make(map[string]bool)
+	for _, f := range files {
+		b, _ := ioutil.ReadFile(f)
+		contents[string(b)] = true
+	}
+	for _, want := range []string{
+		"go test fuzz v1\n[]byte(\"\\xff\\\"x\")\n",
+		"go test fuzz v1\n[]byte(\"ok\")\n",
+		"go test fuzz v1\nstring(\"é\")\n",
+	} {
+		if !contents[want] {
+			t.Errorf("no corpus file containing %q in %v", want, contents)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package testutil
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/garyburd/redigo/redis"
+)
+
+// GinkgoLogger returns a Logger for fakes created in Ginkgo nodes such
+// as BeforeSuite, which have no *testing.T to pass to WithLogger:
+//
+//	var _ = BeforeSuite(func() {
+//		q = testutil.NewFakeSQS("jobs", testutil.WithLogger(testutil.GinkgoLogger(Fail, GinkgoWriter)))
+//	})
+//
+// Progress is written to w. Ginkgo has no non-fatal failures, so
+// Errorf and Fatalf both call fail, which is ginkgo.Fail.
+func GinkgoLogger(fail func(message string, callerSkip ...int), w io.Writer) Logger {
+	return ginkgoLogger{fail, w}
+}
+
+type ginkgoLogger struct {
+	fail func(message string, callerSkip ...int)
+	w    io.Writer
+}
+
+func (l ginkgoLogger) Logf(format string, args ...interface{}) {
+	fmt.Fprintf(l.w, format+"\n", args...)
+}
+
+func (l ginkgoLogger) Errorf(format string, args ...interface{}) {
+	l.fail(fmt.Sprintf(format, args...), 1)
+}
+
+func (l ginkgoLogger) Fatalf(format string, args ...interface{}) {
+	l.fail(fmt.Sprintf(format, args...), 1)
+}
+
+// GomegaMatcher has the methods of Gomega's types.GomegaMatcher, so the
+// matchers below can be passed to Expect(...).To without this package
+// depending on Gomega.
+type GomegaMatcher interface {
+	Match(actual interface{}) (success bool, err error)
+	FailureMessage(actual interface{}) (message string)
+	NegatedFailureMessage(actual interface{}) (message string)
+}
+
+// fakeMatcher is a GomegaMatcher whose match function also describes
+// what it found, for failure messages.
+type fakeMatcher struct {
+	want  string
+	match func(actual interface{}) (ok bool, got string, err error)
+	got   string
+}
+
+func (m *fakeMatcher) Match(actual interface{}) (bool, error) {
+	ok, got, err := m.match(actual)
+	m.got = got
+	return ok, err
+}
+
+func (m *fakeMatcher) FailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected %v to %s, but it %s", actual, m.want, m.got)
+}
+
+func (m *fakeMatcher) NegatedFailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected %v not to %s, but it %s", actual, m.want, m.got)
+}
+
+// HaveQueueDepth matches a *FakeSQS or *MemoryQueue with n visible
+// messages waiting.
+func HaveQueueDepth(n int) GomegaMatcher {
+	return &fakeMatcher{
+		want: fmt.Sprintf("have %d messages waiting", n),
+		match: func(actual interface{}) (bool, string, error) {
+			depth, err := queueDepth(actual)
+			if err != nil {
+				return false, "", err
+			}
+			return depth == n, fmt.Sprintf("has %d", depth), nil
+		},
+	}
+}
+
+func queueDepth(q interface{}) (int, error) {
+	switch q := q.(type) {
+	case *MemoryQueue:
+		waiting, _ := q.Len()
+		return waiting, nil
+	case *FakeSQS:
+		out, err := q.Client.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+			QueueUrl:       &q.URL,
+			AttributeNames: aws.StringSlice([]string{"ApproximateNumberOfMessages"}),
+		})
+		if err != nil {
+			return 0, err
+		}
+		return strconv.Atoi(aws.StringValue(out.Attributes["ApproximateNumberOfMessages"]))
+	}
+	return 0, fmt.Errorf("HaveQueueDepth expects a *FakeSQS or *MemoryQueue, got %T", q)
+}
+
+// ContainObjectWithKey matches a *FakeS3, checking its bucket, or an
+// EnvBucket, that contains the object key.
+func ContainObjectWithKey(key string) GomegaMatcher {
+	return &fakeMatcher{
+		want: "contain object " + key,
+		match: func(actual interface{}) (bool, string, error) {
+			var s *FakeS3
+			var bucket string
+			switch a := actual.(type) {
+			case *FakeS3:
+				s, bucket = a, a.bucket
+			case EnvBucket:
+				s, bucket = a.S3, a.Name
+			default:
+				return false, "", fmt.Errorf("ContainObjectWithKey expects a *FakeS3 or EnvBucket, got %T", actual)
+			}
+			_, err := s.GetBytes(bucket, key)
+			switch {
+			case err == nil:
+				return true, "does", nil
+			case isNotFound(err):
+				return false, "doesn't", nil
+			}
+			return false, "", err
+		},
+	}
+}
+
+// HaveRedisKey matches a *FakeRedis or *redis.Pool whose database
+// contains key.
+func HaveRedisKey(key string) GomegaMatcher {
+	return &fakeMatcher{
+		want: "have redis key " + key,
+		match: func(actual interface{}) (bool, string, error) {
+			var pool *redis.Pool
+			switch a := actual.(type) {
+			case *FakeRedis:
+				pool = a.Pool
+			case *redis.Pool:
+				pool = a
+			default:
+				return false, "", fmt.Errorf("HaveRedisKey expects a *FakeRedis or *redis.Pool, got %T", actual)
+			}
+			conn := pool.Get()
+			defer conn.Close()
+			exists, err := redis.Bool(conn.Do("EXISTS", key))
+			if err != nil {
+				return false, "", err
+			}
+			if exists {
+				return true, "does", nil
+			}
+			return false, "doesn't", nil
+		},
+	}
+}
@@ -0,0 +1,65 @@
+package testutil
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rainforestapp/testutil/redistest"
+)
+
+func TestGinkgoLogger(t *testing.T) {
+	var failures []string
+	var buf bytes.Buffer
+	l := GinkgoLogger(func(message string, callerSkip ...int) { failures = append(failures, message) }, &buf)
+	l.Logf("started %s", "fake")
+	l.Errorf("teardown %s", "failed")
+	if buf.String() != "started fake\n" || len(failures) != 1 || failures[0] != "teardown failed" {
+		t.Errorf("logged %q, failed with %q", buf.String(), failures)
+	}
+}
+
+func TestGomegaMatchers(t *testing.T) {
+	q := NewMemoryQueue("jobs")
+	q.SendMessage("one", nil)
+	m := HaveQueueDepth(1)
+	if ok, err := m.Match(q); !ok || err != nil {
+		t.Errorf("HaveQueueDepth(1).Match = %v, %v", ok, err)
+	}
+	m = HaveQueueDepth(2)
+	if ok, _ := m.Match(q); ok {
+		t.Error("HaveQueueDepth(2) matched a queue with one message")
+	}
+	if msg := m.FailureMessage(q); !strings.HasSuffix(msg, "to have 2 messages waiting, but it has 1") {
+		t.Errorf("FailureMessage = %q", msg)
+	}
+	if _, err := m.Match("jobs"); err == nil {
+		t.Error("HaveQueueDepth matched a string")
+	}
+
+	srv := httptest.NewServer(&memS3{objects: make(map[string]string)})
+	defer srv.Close()
+	s := NewFakeS3("uploads", WithEndpoint(srv.URL), WithLogger(t))
+	defer s.Close()
+	s.PutString("uploads", "a.txt", "A")
+	if ok, err := ContainObjectWithKey("a.txt").Match(s); !ok || err != nil {
+		t.Errorf("ContainObjectWithKey(a.txt).Match = %v, %v", ok, err)
+	}
+	m = ContainObjectWithKey("b.txt")
+	if ok, err := m.Match(EnvBucket{S3: s, Name: "uploads"}); ok || err != nil {
+		t.Errorf("ContainObjectWithKey(b.txt).Match = %v, %v", ok, err)
+	}
+
+	pool := redistest.ScriptedPool(redistest.NewScriptedRedisConn().On("EXISTS", 0).On("EXISTS session:1", 1))
+	if ok, err := HaveRedisKey("session:1").Match(pool); !ok || err != nil {
+		t.Errorf("HaveRedisKey(session:1).Match = %v, %v", ok, err)
+	}
+	m = HaveRedisKey("session:2")
+	if ok, _ := m.Match(pool); ok {
+		t.Error("HaveRedisKey(session:2) matched")
+	}
+	if msg := m.NegatedFailureMessage(pool); !strings.Contains(msg, "not to have redis key session:2") {
+		t.Errorf("NegatedFailureMessage = %q", msg)
+	}
+}
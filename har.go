@@ -0,0 +1,342 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// maxRecordedBody is how much of each request and response body an
+// HTTPRecorder keeps.
+const maxRecordedBody = 1 << 20
+
+// redactedHeaders are the headers whose values an HTTPRecorder doesn't
+// keep, so that recordings can be shared as CI artifacts.
+var redactedHeaders = map[string]bool{
+	"Authorization":        true,
+	"X-Amz-Security-Token": true,
+	"Cookie":               true,
+	"Set-Cookie":           true,
+}
+
+// CleanupT is the part of testing.TB used by helpers that act when a
+// test finishes. *testing.T and *testing.B satisfy it.
+type CleanupT interface {
+	Name() string
+	Failed() bool
+	Cleanup(func())
+	Logf(format string, args ...interface{})
+}
+
+// HTTPRecorder is an http.RoundTripper that records every request sent
+// through it along with its response, and can export them as a HAR
+// file for browser dev tools and other HAR viewers. Attach it to the
+// AWS fakes with WithHTTPRecorder, or use it as the Transport of any
+// other http.Client.
+//
+// Bodies are kept up to 1MB, and credentials in headers are redacted.
+type HTTPRecorder struct {
+	// Transport sends the requests. It defaults to
+	// http.DefaultTransport.
+	Transport http.RoundTripper
+
+	mu        sync.Mutex
+	exchanges []HTTPExchange
+}
+
+// HTTPExchange is a request recorded by an HTTPRecorder and its
+// response.
+type HTTPExchange struct {
+	Started  time.Time
+	Duration time.Duration
+
+	Method        string
+	URL           string
+	RequestHeader http.Header
+	RequestBody   []byte
+
+	// StatusCode, ResponseHeader and ResponseBody are empty if Err is
+	// set.
+	StatusCode     int
+	ResponseHeader http.Header
+	ResponseBody   []byte
+
+	Err error
+}
+
+// NewHTTPRecorder returns an HTTPRecorder that sends requests with
+// transport, or http.DefaultTransport if it is nil.
+func NewHTTPRecorder(transport http.RoundTripper) *HTTPRecorder {
+	return &HTTPRecorder{Transport: transport}
+}
+
+// WithHTTPRecorder makes an AWS fake's clients send their requests
+// through rec, including those the fake makes while it is set up.
+func WithHTTPRecorder(rec *HTTPRecorder) Option {
+	return func(o *options) {
+		o.httpClient = &http.Client{Transport: rec}
+	}
+}
+
+// RoundTrip sends req and records it.
+func (rec *HTTPRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	x := HTTPExchange{
+		Started:       time.Now(),
+		Method:        req.Method,
+		URL:           req.URL.String(),
+		RequestHeader: redactHeader(req.Header),
+	}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		x.RequestBody = truncateBody(body)
+		sent := *req
+		sent.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req = &sent
+	}
+
+	transport := rec.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err == nil {
+		var body []byte
+		body, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		x.StatusCode = resp.StatusCode
+		x.ResponseHeader = redactHeader(resp.Header)
+		x.ResponseBody = truncateBody(body)
+	}
+	x.Err = err
+	x.Duration = time.Since(x.Started)
+
+	rec.mu.Lock()
+	rec.exchanges = append(rec.exchanges, x)
+	rec.mu.Unlock()
+	return resp, err
+}
+
+func redactHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h))
+	for k, vs := range h {
+		if redactedHeaders[http.CanonicalHeaderKey(k)] {
+			c[k] = []string{"REDACTED"}
+			continue
+		}
+		c[k] = append([]string(nil), vs...)
+	}
+	return c
+}
+
+func truncateBody(b []byte) []byte {
+	if len(b) > maxRecordedBody {
+		b = b[:maxRecordedBody]
+	}
+	return b
+}
+
+// Exchanges returns the recorded exchanges, oldest first.
+func (rec *HTTPRecorder) Exchanges() []HTTPExchange {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]HTTPExchange(nil), rec.exchanges...)
+}
+
+// Reset forgets every recorded exchange.
+func (rec *HTTPRecorder) Reset() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.exchanges = nil
+}
+
+// WriteHAR writes the recorded exchanges to w as a HAR 1.2 document.
+func (rec *HTTPRecorder) WriteHAR(w io.Writer) error {
+	doc := harLog{Version: "1.2", Creator: harCreator{Name: "testutil", Version: "1"}}
+	doc.Entries = []harEntry{}
+	for _, x := range rec.Exchanges() {
+		doc.Entries = append(doc.Entries, x.harEntry())
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Log harLog `json:"log"`
+	}{doc})
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// SaveHAROnFailure writes the exchanges recorded during t to a HAR file
+// in dir if t fails, and logs where. The file is named after the test.
+// If dir is empty, $TESTUTIL_HAR_DIR is used, or else the system's
+// temporary directory. Call it at the start of the test, usually with a
+// fresh recorder.
+func (rec *HTTPRecorder) SaveHAROnFailure(t CleanupT, dir string) {
+	t.Cleanup(func() {
+		if !t.Failed() {
+			return
+		}
+		if dir == "" {
+			dir = os.Getenv("TESTUTIL_HAR_DIR")
+		}
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		path := filepath.Join(dir, unsafeFileChars.ReplaceAllString(t.Name(), "_")+".har")
+		var buf bytes.Buffer
+		err := rec.WriteHAR(&buf)
+		if err == nil {
+			err = os.MkdirAll(dir, 0755)
+		}
+		if err == nil {
+			err = ioutil.WriteFile(path, buf.Bytes(), 0644)
+		}
+		if err != nil {
+			t.Logf("Could not save HTTP traffic: %v", err)
+			return
+		}
+		t.Logf("HTTP traffic saved to %s", path)
+	})
+}
+
+// The subset of HAR 1.2 that HTTPRecorder produces.
+type (
+	harLog struct {
+		Version string     `json:"version"`
+		Creator harCreator `json:"creator"`
+		Entries []harEntry `json:"entries"`
+	}
+	harCreator struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	harNameValue struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	harEntry struct {
+		StartedDateTime string      `json:"startedDateTime"`
+		Time            float64     `json:"time"`
+		Request         harRequest  `json:"request"`
+		Response        harResponse `json:"response"`
+		Cache           struct{}    `json:"cache"`
+		Timings         harTimings  `json:"timings"`
+		Comment         string      `json:"comment,omitempty"`
+	}
+	harRequest struct {
+		Method      string         `json:"method"`
+		URL         string         `json:"url"`
+		HTTPVersion string         `json:"httpVersion"`
+		Cookies     []harNameValue `json:"cookies"`
+		Headers     []harNameValue `json:"headers"`
+		QueryString []harNameValue `json:"queryString"`
+		PostData    *harContent    `json:"postData,omitempty"`
+		HeadersSize int            `json:"headersSize"`
+		BodySize    int            `json:"bodySize"`
+	}
+	harResponse struct {
+		Status      int            `json:"status"`
+		StatusText  string         `json:"statusText"`
+		HTTPVersion string         `json:"httpVersion"`
+		Cookies     []harNameValue `json:"cookies"`
+		Headers     []harNameValue `json:"headers"`
+		Content     harContent     `json:"content"`
+		RedirectURL string         `json:"redirectURL"`
+		HeadersSize int            `json:"headersSize"`
+		BodySize    int            `json:"bodySize"`
+	}
+	harContent struct {
+		Size     int    `json:"size"`
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text"`
+		Encoding string `json:"encoding,omitempty"`
+	}
+	harTimings struct {
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+	}
+)
+
+func (x HTTPExchange) harEntry() harEntry {
+	ms := float64(x.Duration) / float64(time.Millisecond)
+	e := harEntry{
+		StartedDateTime: x.Started.UTC().Format(time.RFC3339Nano),
+		Time:            ms,
+		Request: harRequest{
+			Method:      x.Method,
+			URL:         x.URL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(x.RequestHeader),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    len(x.RequestBody),
+		},
+		Response: harResponse{
+			Status:      x.StatusCode,
+			StatusText:  http.StatusText(x.StatusCode),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(x.ResponseHeader),
+			Content:     harBody(x.ResponseBody, x.ResponseHeader.Get("Content-Type")),
+			HeadersSize: -1,
+			BodySize:    len(x.ResponseBody),
+		},
+		Timings: harTimings{Wait: ms},
+	}
+	if u, err := url.Parse(x.URL); err == nil {
+		for k, vs := range u.Query() {
+			for _, v := range vs {
+				e.Request.QueryString = append(e.Request.QueryString, harNameValue{k, v})
+			}
+		}
+		sort.Slice(e.Request.QueryString, func(i, j int) bool {
+			return e.Request.QueryString[i].Name < e.Request.QueryString[j].Name
+		})
+	}
+	if len(x.RequestBody) > 0 {
+		c := harBody(x.RequestBody, x.RequestHeader.Get("Content-Type"))
+		e.Request.PostData = &c
+	}
+	if x.Err != nil {
+		e.Comment = x.Err.Error()
+	}
+	return e
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	nvs := []harNameValue{}
+	for k, vs := range h {
+		for _, v := range vs {
+			nvs = append(nvs, harNameValue{k, v})
+		}
+	}
+	sort.Slice(nvs, func(i, j int) bool { return nvs[i].Name < nvs[j].Name })
+	return nvs
+}
+
+func harBody(b []byte, mimeType string) harContent {
+	c := harContent{Size: len(b), MimeType: mimeType}
+	if utf8.Valid(b) {
+		c.Text = string(b)
+	} else {
+		c.Text, c.Encoding = base64.StdEncoding.EncodeToString(b), "base64"
+	}
+	return c
+}
@@ -0,0 +1,108 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+func TestHTTPRecorderWriteHAR(t *testing.T) {
+	rec := NewHTTPRecorder(nil)
+	k := NewFakeKMS(WithHTTPRecorder(rec))
+	defer k.Close()
+	key := k.CreateKey("")
+	if _, err := k.Client.Encrypt(&kms.EncryptInput{KeyId: aws.String(key), Plaintext: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	k.Client.Decrypt(&kms.DecryptInput{CiphertextBlob: []byte("garbage")})
+
+	var buf bytes.Buffer
+	if err := rec.WriteHAR(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var har struct {
+		Log struct {
+			Version string
+			Entries []struct {
+				Request struct {
+					Method   string
+					Headers  []harNameValue
+					PostData struct{ Text string }
+				}
+				Response struct {
+					Status  int
+					Content struct{ Text string }
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &har); err != nil {
+		t.Fatalf("invalid HAR: %v\n%s", err, buf.String())
+	}
+	if har.Log.Version != "1.2" || len(har.Log.Entries) != 2 {
+		t.Fatalf("HAR version %q with %d entries, want 1.2 with 2", har.Log.Version, len(har.Log.Entries))
+	}
+	enc, dec := har.Log.Entries[0], har.Log.Entries[1]
+	if enc.Request.Method != "POST" || !strings.Contains(enc.Request.PostData.Text, key) || enc.Response.Status != 200 {
+		t.Errorf("Encrypt entry = %+v", enc)
+	}
+	for _, h := range enc.Request.Headers {
+		if h.Name == "Authorization" && h.Value != "REDACTED" {
+			t.Errorf("Authorization header recorded as %q", h.Value)
+		}
+	}
+	if dec.Response.Status != 400 || !strings.Contains(dec.Response.Content.Text, "InvalidCiphertextException") {
+		t.Errorf("Decrypt entry = %+v", dec)
+	}
+}
+
+// failingT is a CleanupT for a test that failed.
+type failingT struct {
+	name     string
+	cleanups []func()
+	logs     []string
+}
+
+func (t *failingT) Name() string     { return t.name }
+func (t *failingT) Failed() bool     { return true }
+func (t *failingT) Cleanup(f func()) { t.cleanups = append(t.cleanups, f) }
+func (t *failingT) Logf(format string, args ...interface{}) {
+	t.logs = append(t.logs, format)
+}
+
+func TestSaveHAROnFailure(t *testing.T) {
+	dir := t.TempDir()
+	rec := NewHTTPRecorder(nil)
+	ft := &failingT{name: "TestUpload/big file"}
+	rec.SaveHAROnFailure(ft, dir)
+	for _, f := range ft.cleanups {
+		f()
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "TestUpload_big_file.har"))
+	if err != nil {
+		t.Fatalf("HAR not written: %v (logs %q)", err, ft.logs)
+	}
+	if !bytes.Contains(b, []byte(`"entries": []`)) {
+		t.Errorf("HAR = %s", b)
+	}
+
+	passed := &failingT{name: "TestPassed"}
+	NewHTTPRecorder(nil).SaveHAROnFailure(testPassed{passed}, dir)
+	for _, f := range passed.cleanups {
+		f()
+	}
+	if _, err := os.Stat(filepath.Join(dir, "TestPassed.har")); err == nil {
+		t.Error("HAR written for a passing test")
+	}
+}
+
+type testPassed struct{ *failingT }
+
+func (testPassed) Failed() bool { return false }
@@ -0,0 +1,157 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/corehandlers"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// A sendHook is consulted before each request made through a fake's
+// AWS clients is sent. If it returns a response, the request is not
+// sent and the SDK handles that response as if the fake had returned
+// it, so error unmarshaling and retries behave as they would against
+// a real service. A hook may instead set the request's Error, which
+// the SDK treats as a failure to send.
+type sendHook func(r *request.Request) *http.Response
+
+const sendHookHandlerName = "testutil.SendHook"
+
+// installHooks installs the request hooks configured in o on each set
+// of handlers. It must be called on the handlers of every client that
+// should be affected as well as on the session clients are later built
+// from. The fakes call it once they are set up, so that hooks don't
+// interfere with creating their queues and buckets.
+func installHooks(o *options, handlers ...*request.Handlers) {
+	for _, h := range handlers {
+		for _, hook := range o.signHooks {
+			h.Sign.PushFront(hook)
+		}
+	}
+	// The chaos hook goes last, so that requests rejected by a Policy
+	// or Throttle don't use up chaos decisions.
+	hooks := append(append([]sendHook(nil), o.sendHooks...), chaosSendHook)
+	addSendHooks(hooks, handlers...)
+}
+
+// addSendHooks installs hooks on each set of handlers. It replaces the
+// SDK's send handler with one that runs the hooks first.
+func addSendHooks(hooks []sendHook, handlers ...*request.Handlers) {
+	if len(hooks) == 0 {
+		return
+	}
+	for _, h := range handlers {
+		h.Send.Remove(corehandlers.SendHandler)
+		h.Send.PushBackNamed(request.NamedHandler{
+			Name: sendHookHandlerName,
+			Fn: func(r *request.Request) {
+				for _, hook := range hooks {
+					if resp := hook(r); resp != nil {
+						r.HTTPResponse = resp
+						return
+					}
+					if r.Error != nil {
+						return
+					}
+				}
+				corehandlers.SendHandler.Fn(r)
+			},
+		})
+	}
+}
+
+// operationName returns the service-qualified name of r's operation,
+// for example "s3:PutObject" or "sqs:SendMessage".
+func operationName(r *request.Request) string {
+	return r.ClientInfo.ServiceName + ":" + r.Operation.Name
+}
+
+// resourceName returns the name of the resource r acts on: "bucket" or
+// "bucket/key" for S3, the queue name for SQS and the key as given in
+// the request for KMS.
+func resourceName(r *request.Request) string {
+	if bucket := paramString(r, "Bucket"); bucket != "" {
+		if key := paramString(r, "Key"); key != "" {
+			return bucket + "/" + key
+		}
+		return bucket
+	}
+	if name := paramString(r, "QueueName"); name != "" {
+		return name
+	}
+	if u := paramString(r, "QueueUrl"); u != "" {
+		return queueNameFromURL(u)
+	}
+	if id := paramString(r, "KeyId"); id != "" {
+		return id
+	}
+	return ""
+}
+
+func paramString(r *request.Request, path string) string {
+	vs, err := awsutil.ValuesAtPath(r.Params, path)
+	if err != nil || len(vs) == 0 {
+		return ""
+	}
+	switch v := vs[0].(type) {
+	case *string:
+		if v != nil {
+			return *v
+		}
+	case string:
+		return v
+	}
+	return ""
+}
+
+func queueNameFromURL(u string) string {
+	for i := len(u) - 1; i >= 0; i-- {
+		if u[i] == '/' {
+			return u[i+1:]
+		}
+	}
+	return u
+}
+
+var fakeRequestID uint64
+
+// errorResponse builds an error response in the wire format of r's
+// service, so that the SDK unmarshals it into an awserr.RequestFailure
+// with the given status and code.
+func errorResponse(r *request.Request, status int, code, message string) *http.Response {
+	reqID := "testutil-" + strconv.FormatUint(atomic.AddUint64(&fakeRequestID, 1), 10)
+
+	var body, contentType string
+	switch {
+	case r.ClientInfo.JSONVersion != "":
+		b, _ := json.Marshal(map[string]string{"__type": code, "message": message})
+		body, contentType = string(b), "application/x-amz-json-"+r.ClientInfo.JSONVersion
+	case r.ClientInfo.ServiceName == "s3":
+		body = fmt.Sprintf("<Error><Code>%s</Code><Message>%s</Message><RequestId>%s</RequestId></Error>",
+			code, message, reqID)
+		contentType = "application/xml"
+	default:
+		body = fmt.Sprintf("<ErrorResponse><Error><Type>Sender</Type><Code>%s</Code><Message>%s</Message></Error><RequestId>%s</RequestId></ErrorResponse>",
+			code, message, reqID)
+		contentType = "application/xml"
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	header.Set("X-Amz-Request-Id", reqID)
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(body))),
+		ContentLength: int64(len(body)),
+		Request:       r.HTTPRequest,
+	}
+}
@@ -0,0 +1,236 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// RequestMatcher matches HTTP requests recorded by an HTTPRecorder, so
+// that expected requests can be asserted on declaratively:
+//
+//	rec.AssertRequest(t, Method("POST"), Path("/users/{id}/orders"),
+//		Header("Content-Type", "application/json"),
+//		BodyJSON(map[string]interface{}{"sku": "A1"}))
+type RequestMatcher struct {
+	desc  string
+	match func(x HTTPExchange) bool
+}
+
+// Matches reports whether x matches m.
+func (m RequestMatcher) Matches(x HTTPExchange) bool {
+	return m.match(x)
+}
+
+func (m RequestMatcher) String() string {
+	return m.desc
+}
+
+// Method matches requests with the given method.
+func Method(method string) RequestMatcher {
+	return RequestMatcher{
+		desc:  "method " + method,
+		match: func(x HTTPExchange) bool { return strings.EqualFold(x.Method, method) },
+	}
+}
+
+// Path matches requests whose URL path fits template. In the template,
+// a segment in braces such as {id} matches any one path segment, and a
+// final {name...} matches the rest of the path.
+func Path(template string) RequestMatcher {
+	want := strings.Split(strings.Trim(template, "/"), "/")
+	return RequestMatcher{
+		desc: "path " + template,
+		match: func(x HTTPExchange) bool {
+			u, err := url.Parse(x.URL)
+			if err != nil {
+				return false
+			}
+			got := strings.Split(strings.Trim(u.Path, "/"), "/")
+			for i, w := range want {
+				if strings.HasPrefix(w, "{") && strings.HasSuffix(w, "...}") {
+					return i < len(got)
+				}
+				if i >= len(got) {
+					return false
+				}
+				if strings.HasPrefix(w, "{") && strings.HasSuffix(w, "}") {
+					if got[i] == "" {
+						return false
+					}
+					continue
+				}
+				if got[i] != w {
+					return false
+				}
+			}
+			return len(got) == len(want)
+		},
+	}
+}
+
+// Query matches requests with query parameter key set to value.
+func Query(key, value string) RequestMatcher {
+	return RequestMatcher{
+		desc: fmt.Sprintf("query %s=%s", key, value),
+		match: func(x HTTPExchange) bool {
+			u, err := url.Parse(x.URL)
+			if err != nil {
+				return false
+			}
+			for _, v := range u.Query()[key] {
+				if v == value {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// Header matches requests with header key set to value. Redacted
+// headers can't be matched on their value.
+func Header(key, value string) RequestMatcher {
+	return RequestMatcher{
+		desc: fmt.Sprintf("header %s: %s", key, value),
+		match: func(x HTTPExchange) bool {
+			for _, v := range x.RequestHeader.Values(key) {
+				if v == value {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// BodyJSON matches requests whose body is JSON containing want: objects
+// must have at least want's fields, with matching values, while arrays
+// and other values must match exactly.
+func BodyJSON(want interface{}) RequestMatcher {
+	b, err := json.Marshal(want)
+	if err != nil {
+		panic(fmt.Sprintf("testutil: BodyJSON(%#v): %v", want, err))
+	}
+	var w interface{}
+	json.Unmarshal(b, &w)
+	return RequestMatcher{
+		desc: "JSON body containing " + string(b),
+		match: func(x HTTPExchange) bool {
+			var got interface{}
+			if err := json.Unmarshal(x.RequestBody, &got); err != nil {
+				return false
+			}
+			return jsonContains(got, w)
+		},
+	}
+}
+
+func jsonContains(got, want interface{}) bool {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for k, wv := range w {
+			gv, ok := g[k]
+			if !ok || !jsonContains(gv, wv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok || len(g) != len(w) {
+			return false
+		}
+		for i := range w {
+			if !jsonContains(g[i], w[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+// AllOf matches requests that match every one of ms.
+func AllOf(ms ...RequestMatcher) RequestMatcher {
+	descs := make([]string, len(ms))
+	for i, m := range ms {
+		descs[i] = m.desc
+	}
+	return RequestMatcher{
+		desc: strings.Join(descs, ", "),
+		match: func(x HTTPExchange) bool {
+			for _, m := range ms {
+				if !m.match(x) {
+					return false
+				}
+			}
+			return true
+		},
+	}
+}
+
+// Matching returns the recorded exchanges that match every one of ms.
+func (rec *HTTPRecorder) Matching(ms ...RequestMatcher) []HTTPExchange {
+	m := AllOf(ms...)
+	var ret []HTTPExchange
+	for _, x := range rec.Exchanges() {
+		if m.match(x) {
+			ret = append(ret, x)
+		}
+	}
+	return ret
+}
+
+// AssertRequest checks that at least one recorded request matches every
+// one of ms.
+func (rec *HTTPRecorder) AssertRequest(t TestingT, ms ...RequestMatcher) {
+	t.Helper()
+	if len(rec.Matching(ms...)) == 0 {
+		t.Errorf("no request with %s; recorded:\n%s", AllOf(ms...), rec.requestList())
+	}
+}
+
+// AssertRequestCount checks that exactly n recorded requests match every
+// one of ms.
+func (rec *HTTPRecorder) AssertRequestCount(t TestingT, n int, ms ...RequestMatcher) {
+	t.Helper()
+	if got := len(rec.Matching(ms...)); got != n {
+		t.Errorf("%d requests with %s, want %d; recorded:\n%s", got, AllOf(ms...), n, rec.requestList())
+	}
+}
+
+// AssertSequence checks that requests matching each of steps were made
+// in that order. Other requests may be interleaved. Combine matchers
+// for one step with AllOf.
+func (rec *HTTPRecorder) AssertSequence(t TestingT, steps ...RequestMatcher) {
+	t.Helper()
+	next := 0
+	for _, x := range rec.Exchanges() {
+		if next < len(steps) && steps[next].match(x) {
+			next++
+		}
+	}
+	if next < len(steps) {
+		t.Errorf("no request with %s after step %d of the sequence; recorded:\n%s",
+			steps[next], next, rec.requestList())
+	}
+}
+
+func (rec *HTTPRecorder) requestList() string {
+	xs := rec.Exchanges()
+	if len(xs) == 0 {
+		return "  (none)"
+	}
+	lines := make([]string, len(xs))
+	for i, x := range xs {
+		lines[i] = fmt.Sprintf("  %s %s", x.Method, x.URL)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,47 @@
+package testutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestMatchers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	rec := NewHTTPRecorder(nil)
+	client := &http.Client{Transport: rec}
+
+	req, _ := http.NewRequest("POST", srv.URL+"/users/42/orders?page=2",
+		strings.NewReader(`{"sku":"A1","qty":3,"tags":["x"],"extra":{"a":1}}`))
+	req.Header.Set("Content-Type", "application/json")
+	client.Do(req)
+	client.Get(srv.URL + "/users/42")
+	client.Get(srv.URL + "/static/css/site.css")
+
+	rt := &recordingT{}
+	rec.AssertRequest(rt, Method("POST"), Path("/users/{id}/orders"), Query("page", "2"),
+		Header("Content-Type", "application/json"),
+		BodyJSON(map[string]interface{}{"sku": "A1", "tags": []string{"x"}, "extra": map[string]int{}}))
+	rec.AssertRequestCount(rt, 2, Path("/users/{rest...}"))
+	rec.AssertRequestCount(rt, 1, Path("/static/{file...}"))
+	rec.AssertSequence(rt, Method("POST"), AllOf(Method("GET"), Path("/users/42")))
+	if len(rt.errors) != 0 {
+		t.Errorf("matching assertions failed: %q", rt.errors)
+	}
+
+	rec.AssertRequest(rt, Method("DELETE"))
+	rec.AssertRequest(rt, BodyJSON(map[string]int{"qty": 4}))
+	rec.AssertRequestCount(rt, 1, Path("/users/{id}"), Method("PUT"))
+	rec.AssertSequence(rt, Path("/users/42"), Method("POST"))
+	if len(rt.errors) != 4 {
+		t.Fatalf("got %d errors, want 4: %q", len(rt.errors), rt.errors)
+	}
+	if !strings.HasPrefix(rt.errors[0], "no request with method DELETE; recorded:\n  POST "+srv.URL+"/users/42/orders?page=2") {
+		t.Errorf("error = %q", rt.errors[0])
+	}
+	if !strings.HasPrefix(rt.errors[3], "no request with method POST after step 1 of the sequence") {
+		t.Errorf("error = %q", rt.errors[3])
+	}
+}
@@ -0,0 +1,189 @@
+package testutil
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/rainforestapp/testutil/internal/diff"
+)
+
+// errCrashed is the cause of the errors of calls made by a handler
+// after its simulated crash.
+var errCrashed = errors.New("consumer crashed (testutil idempotency harness)")
+
+// IdempotencyHarness checks that a message handler has the same effect
+// however many times a message is delivered, as at-least-once queues
+// require. It handles the messages given to Check once to learn the
+// expected state, then again under each of these scenarios, comparing
+// the state after each:
+//
+//   - every message delivered several times in a row;
+//   - the whole batch replayed after it was handled;
+//   - for each message and each AWS call its handler makes, the
+//     consumer crashing just before that call, and the message being
+//     redelivered.
+//
+// Crashes are only simulated for the AWS fakes the harness is attached
+// to with Option: from the crash on, the handler's requests fail as if
+// the process had died, and its result is ignored.
+type IdempotencyHarness struct {
+	// Handler processes a single message.
+	Handler func(msg *sqs.Message) error
+
+	// Reset restores the fakes to how they were before any message
+	// was handled. It is called before each scenario.
+	Reset func()
+
+	// State returns the side effects to compare, for example the
+	// objects written to S3 or the keys set in redis.
+	State func() map[string]string
+
+	// Deliveries is how many times messages are delivered in the
+	// duplicate scenarios. It defaults to 2.
+	Deliveries int
+
+	mu      sync.Mutex
+	calls   int
+	crashAt int
+}
+
+// Option attaches the harness to an AWS fake, so that crashes can be
+// simulated between the handler's calls to it.
+func (h *IdempotencyHarness) Option() Option {
+	return func(o *options) {
+		o.sendHooks = append(o.sendHooks, h.sendHook)
+	}
+}
+
+func (h *IdempotencyHarness) sendHook(r *request.Request) *http.Response {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if r.RetryCount == 0 {
+		h.calls++
+	}
+	if h.crashAt > 0 && h.calls >= h.crashAt {
+		dropRequest(r, errCrashed)
+		// A dead consumer doesn't retry.
+		r.Retryable = aws.Bool(false)
+	}
+	return nil
+}
+
+// deliver hands msg to the handler for the nth time, crashing before
+// its crashAt'th call if crashAt is positive. It returns how many calls
+// the handler made and the handler's error.
+func (h *IdempotencyHarness) deliver(msg *sqs.Message, n, crashAt int) (int, error) {
+	m := *msg
+	m.ReceiptHandle = aws.String(fmt.Sprintf("%s-%d", aws.StringValue(msg.MessageId), n))
+	m.Attributes = make(map[string]*string, len(msg.Attributes)+1)
+	for k, v := range msg.Attributes {
+		m.Attributes[k] = v
+	}
+	m.Attributes["ApproximateReceiveCount"] = aws.String(strconv.Itoa(n))
+
+	h.mu.Lock()
+	h.calls, h.crashAt = 0, crashAt
+	h.mu.Unlock()
+	err := h.Handler(&m)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	calls := h.calls
+	h.calls, h.crashAt = 0, 0
+	return calls, err
+}
+
+// Check runs every scenario with msgs and reports each one that leaves
+// a different state than handling each message once. Messages without
+// an ID are given one.
+func (h *IdempotencyHarness) Check(t TestingT, msgs ...*sqs.Message) {
+	t.Helper()
+	deliveries := h.Deliveries
+	if deliveries < 2 {
+		deliveries = 2
+	}
+	msgs = append([]*sqs.Message(nil), msgs...)
+	for i, msg := range msgs {
+		if msg.MessageId == nil {
+			m := *msg
+			m.MessageId = aws.String(fmt.Sprintf("idempotency-%d", i+1))
+			msgs[i] = &m
+		}
+	}
+
+	// Handling each message once gives the expected state, and how
+	// many calls each handler makes.
+	h.Reset()
+	calls := make([]int, len(msgs))
+	for i, msg := range msgs {
+		var err error
+		if calls[i], err = h.deliver(msg, 1, 0); err != nil {
+			t.Errorf("handling message %s once failed: %v", aws.StringValue(msg.MessageId), err)
+			return
+		}
+	}
+	want := h.State()
+
+	check := func(scenario string, run func() error) {
+		h.Reset()
+		if err := run(); err != nil {
+			t.Errorf("%s: %v", scenario, err)
+			return
+		}
+		if d := diff.Maps(h.State(), want); d != "" {
+			t.Errorf("%s left a different state than handling each message once:\n%s",
+				scenario, indent(d))
+		}
+	}
+
+	check(fmt.Sprintf("delivering each message %d times in a row", deliveries), func() error {
+		for _, msg := range msgs {
+			for n := 1; n <= deliveries; n++ {
+				if _, err := h.deliver(msg, n, 0); err != nil {
+					return fmt.Errorf("delivery %d of message %s failed: %v", n, aws.StringValue(msg.MessageId), err)
+				}
+			}
+		}
+		return nil
+	})
+
+	check(fmt.Sprintf("replaying the batch %d times", deliveries), func() error {
+		for n := 1; n <= deliveries; n++ {
+			for _, msg := range msgs {
+				if _, err := h.deliver(msg, n, 0); err != nil {
+					return fmt.Errorf("delivery %d of message %s failed: %v", n, aws.StringValue(msg.MessageId), err)
+				}
+			}
+		}
+		return nil
+	})
+
+	for i, crashed := range msgs {
+		for k := 1; k <= calls[i]; k++ {
+			scenario := fmt.Sprintf("crashing before call %d of %d handling message %s", k, calls[i], aws.StringValue(crashed.MessageId))
+			check(scenario, func() error {
+				for _, msg := range msgs {
+					n := 1
+					if msg == crashed {
+						h.deliver(msg, n, k)
+						n++
+					}
+					if _, err := h.deliver(msg, n, 0); err != nil {
+						return fmt.Errorf("delivery %d of message %s failed: %v", n, aws.StringValue(msg.MessageId), err)
+					}
+				}
+				return nil
+			})
+		}
+	}
+}
+
+func indent(s string) string {
+	return "  " + strings.ReplaceAll(s, "\n", "\n  ")
+}
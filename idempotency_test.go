@@ -0,0 +1,72 @@
+package testutil
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestIdempotencyHarness(t *testing.T) {
+	h := &IdempotencyHarness{}
+	k := NewFakeKMS(h.Option())
+	defer k.Close()
+	key := k.CreateKey("")
+
+	var store map[string]string
+	h.Reset = func() { store = make(map[string]string) }
+	h.State = func() map[string]string { return store }
+	encrypt := func(msg *sqs.Message) error {
+		_, err := k.Client.Encrypt(&kms.EncryptInput{KeyId: aws.String(key), Plaintext: []byte(aws.StringValue(msg.Body))})
+		return err
+	}
+	msgs := []*sqs.Message{{Body: aws.String("a")}, {Body: aws.String("b")}}
+
+	run := func(handler func(msg *sqs.Message) error) []string {
+		h.Handler = handler
+		rt := &recordingT{}
+		h.Check(rt, msgs...)
+		return rt.errors
+	}
+
+	// Keyed writes are idempotent.
+	if errs := run(func(msg *sqs.Message) error {
+		if err := encrypt(msg); err != nil {
+			return err
+		}
+		store[aws.StringValue(msg.MessageId)] = aws.StringValue(msg.Body)
+		return nil
+	}); len(errs) != 0 {
+		t.Errorf("idempotent handler: %q", errs)
+	}
+
+	// Appending isn't.
+	errs := run(func(msg *sqs.Message) error {
+		store["log"] += aws.StringValue(msg.Body)
+		return encrypt(msg)
+	})
+	if len(errs) != 4 || !strings.Contains(errs[0], "delivering each message 2 times in a row") ||
+		!strings.Contains(errs[0], `~ log: "aabb", want "ab"`) {
+		t.Errorf("appending handler: %q", errs)
+	}
+
+	// Deduplicating after the side effect only breaks if the consumer
+	// crashes in between.
+	errs = run(func(msg *sqs.Message) error {
+		id := aws.StringValue(msg.MessageId)
+		if store["done:"+id] != "" {
+			return nil
+		}
+		store["log"] += aws.StringValue(msg.Body)
+		if err := encrypt(msg); err != nil {
+			return err
+		}
+		store["done:"+id] = "yes"
+		return nil
+	})
+	if len(errs) != 2 || !strings.HasPrefix(errs[0], "crashing before call 1 of 1 handling message idempotency-1") {
+		t.Errorf("handler deduplicating too late: %q", errs)
+	}
+}
@@ -0,0 +1,120 @@
+package testutil
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+)
+
+// IDSource produces identifiers. Code under test that generates
+// message IDs, object keys or cache keys can take an IDSource (or a
+// func() string, see InstallIDSource) so that tests can substitute a
+// deterministic one and compare output against golden files.
+type IDSource interface {
+	NextID() string
+}
+
+// IDSourceFunc adapts an ordinary function to an IDSource.
+type IDSourceFunc func() string
+
+// NextID calls f.
+func (f IDSourceFunc) NextID() string {
+	return f()
+}
+
+// Sequence is an IDSource producing prefix1, prefix2, prefix3, ... It
+// is safe for concurrent use.
+type Sequence struct {
+	prefix string
+
+	mu sync.Mutex
+	n  int
+}
+
+// NewSequence returns a Sequence with the given prefix.
+func NewSequence(prefix string) *Sequence {
+	return &Sequence{prefix: prefix}
+}
+
+// NextID returns the next ID in the sequence.
+func (s *Sequence) NextID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n++
+	return s.prefix + strconv.Itoa(s.n)
+}
+
+// Reset restarts the sequence from 1.
+func (s *Sequence) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n = 0
+}
+
+// UUIDSequence is an IDSource producing well-formed version 4 UUIDs
+// that count up from 00000000-0000-4000-8000-000000000001, which keeps
+// them readable in golden files. It is safe for concurrent use.
+type UUIDSequence struct {
+	mu sync.Mutex
+	n  uint64
+}
+
+// NewUUIDSequence returns a UUIDSequence starting at 1.
+func NewUUIDSequence() *UUIDSequence {
+	return new(UUIDSequence)
+}
+
+// NextID returns the next UUID in the sequence.
+func (s *UUIDSequence) NextID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n++
+	return fmt.Sprintf("00000000-0000-4000-8000-%012x", s.n)
+}
+
+// SeededUUIDs is an IDSource producing random-looking version 4 UUIDs
+// from a seed, so that the same seed always yields the same sequence.
+// It is safe for concurrent use.
+type SeededUUIDs struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewSeededUUIDs returns a SeededUUIDs seeded with seed.
+func NewSeededUUIDs(seed int64) *SeededUUIDs {
+	return &SeededUUIDs{rnd: rand.New(rand.NewSource(seed))}
+}
+
+// NextID returns the next UUID.
+func (s *SeededUUIDs) NextID() string {
+	var b [16]byte
+	s.mu.Lock()
+	s.rnd.Read(b[:])
+	s.mu.Unlock()
+	return formatUUID(b)
+}
+
+// formatUUID sets the version 4 and variant bits on b and formats it.
+func formatUUID(b [16]byte) string {
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// InstallIDSource points the ID generator variable target, such as a
+// package-level
+//
+//	var newID = uuid.NewString
+//
+// in the code under test, at src, and returns a function that restores
+// the original generator:
+//
+//	defer testutil.InstallIDSource(&newID, testutil.NewUUIDSequence())()
+func InstallIDSource(target *func() string, src IDSource) (restore func()) {
+	orig := *target
+	*target = src.NextID
+	return func() {
+		*target = orig
+	}
+}
@@ -0,0 +1,50 @@
+package testutil
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestSeededUUIDs(t *testing.T) {
+	a, b := NewSeededUUIDs(42), NewSeededUUIDs(42)
+	for i := 0; i < 5; i++ {
+		ida, idb := a.NextID(), b.NextID()
+		if ida != idb {
+			t.Errorf("same seed produced %s and %s", ida, idb)
+		}
+		if !uuidPattern.MatchString(ida) {
+			t.Errorf("%s is not a version 4 UUID", ida)
+		}
+	}
+	if NewSeededUUIDs(1).NextID() == NewSeededUUIDs(2).NextID() {
+		t.Error("different seeds produced the same UUID")
+	}
+}
+
+func TestUUIDSequence(t *testing.T) {
+	s := NewUUIDSequence()
+	s.NextID()
+	id := s.NextID()
+	if id != "00000000-0000-4000-8000-000000000002" {
+		t.Errorf("second UUID = %s", id)
+	}
+	if !uuidPattern.MatchString(id) {
+		t.Errorf("%s is not a version 4 UUID", id)
+	}
+}
+
+var newOrderID = func() string { return "random" }
+
+func ExampleInstallIDSource() {
+	restore := InstallIDSource(&newOrderID, NewSequence("order-"))
+	fmt.Println(newOrderID(), newOrderID())
+
+	restore()
+	fmt.Println(newOrderID())
+	// Output:
+	// order-1 order-2
+	// random
+}
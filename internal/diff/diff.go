@@ -0,0 +1,65 @@
+// Package diff describes the differences between expected and actual
+// values for the assertions of testutil and its subpackages.
+package diff
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Maps describes how got differs from want, one line per key:
+// "- key: want" for missing keys, "+ key: got" for unexpected ones and
+// "~ key: got, want want" for keys whose values differ. It returns ""
+// if the maps are equal.
+func Maps(got, want map[string]string) string {
+	keys := make(map[string]bool)
+	for k := range got {
+		keys[k] = true
+	}
+	for k := range want {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var lines []string
+	for _, k := range sorted {
+		g, inGot := got[k]
+		w, inWant := want[k]
+		switch {
+		case !inGot:
+			lines = append(lines, fmt.Sprintf("- %s: %q", k, w))
+		case !inWant:
+			lines = append(lines, fmt.Sprintf("+ %s: %q", k, g))
+		case g != w:
+			lines = append(lines, fmt.Sprintf("~ %s: %q, want %q", k, g, w))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Lists describes how got differs from want, element by element.
+// It returns "" if the lists are equal.
+func Lists(got, want []string) string {
+	n := len(got)
+	if len(want) > n {
+		n = len(want)
+	}
+
+	var lines []string
+	for i := 0; i < n; i++ {
+		switch {
+		case i >= len(got):
+			lines = append(lines, fmt.Sprintf("- [%d]: %q", i, want[i]))
+		case i >= len(want):
+			lines = append(lines, fmt.Sprintf("+ [%d]: %q", i, got[i]))
+		case got[i] != want[i]:
+			lines = append(lines, fmt.Sprintf("~ [%d]: %q, want %q", i, got[i], want[i]))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,29 @@
+package diff
+
+import "testing"
+
+func TestMaps(t *testing.T) {
+	got := map[string]string{"a": "1", "b": "2", "c": "3"}
+	want := map[string]string{"a": "1", "b": "20", "d": "4"}
+
+	expected := `~ b: "2", want "20"
++ c: "3"
+- d: "4"`
+	if d := Maps(got, want); d != expected {
+		t.Errorf("got diff:\n%s\nwant:\n%s", d, expected)
+	}
+	if d := Maps(want, want); d != "" {
+		t.Errorf("expected no diff for equal maps, got:\n%s", d)
+	}
+}
+
+func TestLists(t *testing.T) {
+	expected := `~ [1]: "x", want "b"
+- [2]: "c"`
+	if d := Lists([]string{"a", "x"}, []string{"a", "b", "c"}); d != expected {
+		t.Errorf("got diff:\n%s\nwant:\n%s", d, expected)
+	}
+	if d := Lists([]string{"a"}, []string{"a"}); d != "" {
+		t.Errorf("expected no diff for equal lists, got:\n%s", d)
+	}
+}
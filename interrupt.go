@@ -0,0 +1,105 @@
+package testutil
+
+import (
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+var interrupts struct {
+	mu       sync.Mutex
+	next     int
+	cleanups map[int]func()
+}
+
+// OnInterrupt registers f to be run if the test run is interrupted
+// while HandleInterrupts is in effect, for child processes and temp
+// dirs that would otherwise outlive it. The fakes register themselves
+// when they are created. It returns a function that unregisters f,
+// which should be called once f's resources are cleaned up normally.
+func OnInterrupt(f func()) (unregister func()) {
+	interrupts.mu.Lock()
+	defer interrupts.mu.Unlock()
+	if interrupts.cleanups == nil {
+		interrupts.cleanups = make(map[int]func())
+	}
+	id := interrupts.next
+	interrupts.next++
+	interrupts.cleanups[id] = f
+	return func() {
+		interrupts.mu.Lock()
+		defer interrupts.mu.Unlock()
+		delete(interrupts.cleanups, id)
+	}
+}
+
+// HandleInterrupts catches SIGINT and SIGTERM until stop is called. On
+// either signal it closes every fake still open and runs everything
+// registered with OnInterrupt, most recent first, then exits, so
+// interrupting a local run doesn't strand fakes3, fake_sqs or test
+// data in redis. A second signal exits immediately. Call it from
+// TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		stop := testutil.HandleInterrupts()
+//		code := m.Run()
+//		stop()
+//		os.Exit(code)
+//	}
+func HandleInterrupts() (stop func()) {
+	sigc := make(chan os.Signal, 2)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigc:
+			go func() {
+				<-sigc
+				os.Exit(exitCode(sig))
+			}()
+			runInterruptCleanups()
+			os.Exit(exitCode(sig))
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sigc)
+			close(done)
+		})
+	}
+}
+
+// runInterruptCleanups runs and unregisters every registered cleanup,
+// most recently registered first.
+func runInterruptCleanups() {
+	interrupts.mu.Lock()
+	ids := make([]int, 0, len(interrupts.cleanups))
+	for id := range interrupts.cleanups {
+		ids = append(ids, id)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	fs := make([]func(), len(ids))
+	for i, id := range ids {
+		fs[i] = interrupts.cleanups[id]
+	}
+	interrupts.cleanups = nil
+	interrupts.mu.Unlock()
+
+	for _, f := range fs {
+		f()
+	}
+}
+
+// exitCode returns the conventional shell exit status for a process
+// killed by sig.
+func exitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
@@ -0,0 +1,24 @@
+package testutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRunInterruptCleanupsOrder(t *testing.T) {
+	var ran []int
+	OnInterrupt(func() { ran = append(ran, 1) })
+	unregister := OnInterrupt(func() { ran = append(ran, 2) })
+	OnInterrupt(func() { ran = append(ran, 3) })
+	unregister()
+
+	runInterruptCleanups()
+	if want := []int{3, 1}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("cleanups ran in order %v, want %v", ran, want)
+	}
+
+	runInterruptCleanups()
+	if len(ran) != 2 {
+		t.Errorf("cleanups ran again after already running: %v", ran)
+	}
+}
@@ -0,0 +1,38 @@
+//go:build !windows
+// +build !windows
+
+package testutil
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHandleInterrupts(t *testing.T) {
+	if os.Getenv("TESTUTIL_INTERRUPT_CHILD") == "1" {
+		stop := HandleInterrupts()
+		defer stop()
+		OnInterrupt(func() { os.Stdout.WriteString("cleaned up\n") })
+		syscall.Kill(os.Getpid(), syscall.SIGINT)
+		time.Sleep(10 * time.Second)
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHandleInterrupts$")
+	cmd.Env = append(os.Environ(), "TESTUTIL_INTERRUPT_CHILD=1")
+	out, err := cmd.Output()
+	if !strings.Contains(string(out), "cleaned up") {
+		t.Errorf("cleanup did not run; output: %q", out)
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		t.Fatalf("expected child to exit with an error, got %v", err)
+	}
+	if got, want := exitErr.ExitCode(), 128+int(syscall.SIGINT); got != want {
+		t.Errorf("exit code = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,388 @@
+package testutil
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+const (
+	fakeAccountID   = "123456789012"
+	kmsCiphertextV1 = "tkms1"
+)
+
+// FakeKMS is an in-process fake of the parts of KMS used for envelope
+// encryption: Encrypt, Decrypt, GenerateDataKey and
+// GenerateDataKeyWithoutPlaintext. Key material, data keys and
+// ciphertexts are derived deterministically from key IDs, so runs are
+// reproducible, and every call is recorded for assertions.
+//
+// Ciphertexts are only meaningful to the FakeKMS that produced them,
+// or to another one with the same keys.
+type FakeKMS struct {
+	// Client is a KMS client set up for the fake.
+	Client *kms.KMS
+
+	// Session is an AWS Session that uses the fake config.
+	Session *session.Session
+
+	// Config is the AWS config the Session was built from.
+	Config *aws.Config
+
+	// URL is the fake's endpoint.
+	URL string
+
+	region     string
+	srv        *httptest.Server
+	unregister func()
+
+	mu       sync.Mutex
+	keys     map[string]*kmsKey
+	aliases  map[string]string
+	nextKey  int
+	dataKeys int
+	calls    []KMSCall
+}
+
+type kmsKey struct {
+	id       string
+	material []byte
+	disabled bool
+}
+
+// KMSCall is a call made to a FakeKMS.
+type KMSCall struct {
+	// Operation is the KMS operation, such as "GenerateDataKey".
+	Operation string
+
+	// KeyID is the ID of the key the call used, whichever way the
+	// request referred to it. It is empty if the key wasn't found.
+	KeyID string
+
+	EncryptionContext map[string]string
+}
+
+// NewFakeKMS starts a FakeKMS with no keys.
+func NewFakeKMS(opts ...Option) *FakeKMS {
+	o := newOptions(opts)
+	k := &FakeKMS{
+		region:  o.region,
+		keys:    make(map[string]*kmsKey),
+		aliases: make(map[string]string),
+	}
+	if k.region == "" {
+		k.region = defaultRegion
+	}
+	k.srv = httptest.NewServer(&jsonRPCHandler{
+		targetPrefix: "TrentService",
+		operations: map[string]func([]byte) (interface{}, error){
+			"Encrypt":                         k.encrypt,
+			"Decrypt":                         k.decrypt,
+			"GenerateDataKey":                 k.generateDataKey,
+			"GenerateDataKeyWithoutPlaintext": k.generateDataKeyWithoutPlaintext,
+		},
+	})
+	k.URL = k.srv.URL
+	k.Config = fakeAWSConfig(k.URL, o)
+	k.Session = session.New(k.Config)
+	k.Client = kms.New(k.Session)
+	installHooks(o, &k.Session.Handlers, &k.Client.Handlers)
+	k.unregister = OnInterrupt(k.Close)
+	return k
+}
+
+// Close stops the fake.
+func (k *FakeKMS) Close() {
+	if k.unregister != nil {
+		k.unregister()
+	}
+	k.srv.Close()
+}
+
+// CreateKey creates a key and returns its ID. If alias is not empty,
+// the key can also be referred to as "alias/" + alias.
+func (k *FakeKMS) CreateKey(alias string) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.nextKey++
+	id := fmt.Sprintf("00000000-0000-4000-8000-%012x", k.nextKey)
+	sum := sha256.Sum256([]byte("testutil/kms/" + id))
+	k.keys[id] = &kmsKey{id: id, material: sum[:]}
+	if alias != "" {
+		k.aliases["alias/"+strings.TrimPrefix(alias, "alias/")] = id
+	}
+	return id
+}
+
+// managedKey returns alias, creating a key for it if there is none
+// yet, as AWS does for service managed keys such as alias/aws/s3.
+func (k *FakeKMS) managedKey(alias string) string {
+	k.mu.Lock()
+	_, ok := k.aliases[alias]
+	k.mu.Unlock()
+	if !ok {
+		k.CreateKey(alias)
+	}
+	return alias
+}
+
+// KeyARN returns the ARN of the key with the given ID.
+func (k *FakeKMS) KeyARN(keyID string) string {
+	return fmt.Sprintf("arn:aws:kms:%s:%s:key/%s", k.region, fakeAccountID, keyID)
+}
+
+// DisableKey makes requests using the key fail with DisabledException,
+// or succeed again if disabled is false.
+func (k *FakeKMS) DisableKey(keyID string, disabled bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.keys[keyID]; ok {
+		key.disabled = disabled
+	}
+}
+
+// Calls returns every call made so far, in order.
+func (k *FakeKMS) Calls() []KMSCall {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([]KMSCall(nil), k.calls...)
+}
+
+// CallsTo returns the calls made to operation.
+func (k *FakeKMS) CallsTo(operation string) []KMSCall {
+	var calls []KMSCall
+	for _, c := range k.Calls() {
+		if c.Operation == operation {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// AssertCalled checks that operation was called with the key keyID at
+// least once.
+func (k *FakeKMS) AssertCalled(t TestingT, operation, keyID string) {
+	t.Helper()
+	var used []string
+	for _, c := range k.CallsTo(operation) {
+		if c.KeyID == keyID {
+			return
+		}
+		used = append(used, c.KeyID)
+	}
+	t.Errorf("kms %s was not called with key %s (called with %q)", operation, keyID, used)
+}
+
+// lookup resolves ref, which may be a key ID, key ARN, alias name or
+// alias ARN, and records the call. k.mu must be held.
+func (k *FakeKMS) lookup(operation, ref string, encCtx map[string]string) (*kmsKey, error) {
+	id := ref
+	if i := strings.Index(ref, ":key/"); i >= 0 {
+		id = ref[i+len(":key/"):]
+	} else if i := strings.Index(ref, ":alias/"); i >= 0 {
+		id = k.aliases[ref[i+1:]]
+	} else if strings.HasPrefix(ref, "alias/") {
+		id = k.aliases[ref]
+	}
+	key := k.keys[id]
+
+	call := KMSCall{Operation: operation, EncryptionContext: encCtx}
+	if key != nil {
+		call.KeyID = key.id
+	}
+	k.calls = append(k.calls, call)
+
+	switch {
+	case key == nil:
+		return nil, newAWSError(http.StatusBadRequest, "NotFoundException", "Key '%s' does not exist", ref)
+	case key.disabled:
+		return nil, newAWSError(http.StatusBadRequest, "DisabledException", "%s is disabled.", k.KeyARN(key.id))
+	}
+	return key, nil
+}
+
+type kmsRequest struct {
+	KeyID             string `json:"KeyId"`
+	Plaintext         []byte
+	CiphertextBlob    []byte
+	EncryptionContext map[string]string
+	KeySpec           string
+	NumberOfBytes     int
+}
+
+func (k *FakeKMS) encrypt(body []byte) (interface{}, error) {
+	var req kmsRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	key, err := k.lookup("Encrypt", req.KeyID, req.EncryptionContext)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"KeyId":          k.KeyARN(key.id),
+		"CiphertextBlob": key.seal(req.Plaintext, req.EncryptionContext),
+	}, nil
+}
+
+func (k *FakeKMS) decrypt(body []byte) (interface{}, error) {
+	var req kmsRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	keyID, plaintext, err := k.decryptBlob("Decrypt", req.CiphertextBlob, req.EncryptionContext)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"KeyId":     k.KeyARN(keyID),
+		"Plaintext": plaintext,
+	}, nil
+}
+
+// decryptBlob decrypts a ciphertext produced by seal, recording the
+// call as operation, and returns it along with the ID of the key used.
+func (k *FakeKMS) decryptBlob(operation string, blob []byte, encCtx map[string]string) (string, []byte, error) {
+	invalid := newAWSError(http.StatusBadRequest, "InvalidCiphertextException", "")
+	if !bytes.HasPrefix(blob, []byte(kmsCiphertextV1)) || len(blob) < len(kmsCiphertextV1)+1 {
+		return "", nil, invalid
+	}
+	blob = blob[len(kmsCiphertextV1):]
+	n := int(blob[0])
+	if len(blob) < 1+n {
+		return "", nil, invalid
+	}
+	keyID := string(blob[1 : 1+n])
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	key, err := k.lookup(operation, keyID, encCtx)
+	if err != nil {
+		return "", nil, err
+	}
+	plaintext, ok := key.open(blob[1+n:], encCtx)
+	if !ok {
+		return "", nil, invalid
+	}
+	return key.id, plaintext, nil
+}
+
+func (k *FakeKMS) generateDataKey(body []byte) (interface{}, error) {
+	return k.dataKey("GenerateDataKey", body, true)
+}
+
+func (k *FakeKMS) generateDataKeyWithoutPlaintext(body []byte) (interface{}, error) {
+	return k.dataKey("GenerateDataKeyWithoutPlaintext", body, false)
+}
+
+func (k *FakeKMS) dataKey(operation string, body []byte, withPlaintext bool) (interface{}, error) {
+	var req kmsRequest
+	if err := decodeJSONRequest(body, &req); err != nil {
+		return nil, err
+	}
+	size := req.NumberOfBytes
+	switch req.KeySpec {
+	case "AES_256":
+		size = 32
+	case "AES_128":
+		size = 16
+	}
+	if size < 1 || size > 1024 {
+		return nil, newAWSError(http.StatusBadRequest, "ValidationException", "KeySpec or NumberOfBytes is required")
+	}
+
+	keyID, plaintext, blob, err := k.newDataKey(operation, req.KeyID, size, req.EncryptionContext)
+	if err != nil {
+		return nil, err
+	}
+	resp := map[string]interface{}{
+		"KeyId":          k.KeyARN(keyID),
+		"CiphertextBlob": blob,
+	}
+	if withPlaintext {
+		resp["Plaintext"] = plaintext
+	}
+	return resp, nil
+}
+
+// newDataKey generates a data key of size bytes under the key ref,
+// recording the call as operation. It returns the ID of the key used
+// and the data key in plaintext and encrypted.
+func (k *FakeKMS) newDataKey(operation, ref string, size int, encCtx map[string]string) (keyID string, plaintext, blob []byte, err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	key, err := k.lookup(operation, ref, encCtx)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	k.dataKeys++
+	plaintext = key.derive(fmt.Sprintf("data-key-%d", k.dataKeys), size)
+	return key.id, plaintext, key.seal(plaintext, encCtx), nil
+}
+
+// derive returns size bytes derived from the key material and label.
+func (key *kmsKey) derive(label string, size int) []byte {
+	var out []byte
+	for i := 0; len(out) < size; i++ {
+		mac := hmac.New(sha256.New, key.material)
+		fmt.Fprintf(mac, "%s/%d", label, i)
+		out = mac.Sum(out)
+	}
+	return out[:size]
+}
+
+// seal encrypts plaintext with AES-GCM, authenticating the encryption
+// context. The nonce is derived from the inputs, so the same inputs
+// always give the same ciphertext.
+func (key *kmsKey) seal(plaintext []byte, encCtx map[string]string) []byte {
+	aad := encryptionContextAAD(encCtx)
+	gcm := key.gcm()
+	nonce := key.derive(string(aad)+"/"+string(plaintext), gcm.NonceSize())
+
+	blob := append([]byte(kmsCiphertextV1), byte(len(key.id)))
+	blob = append(blob, key.id...)
+	blob = append(blob, nonce...)
+	return gcm.Seal(blob, nonce, plaintext, aad)
+}
+
+func (key *kmsKey) open(sealed []byte, encCtx map[string]string) ([]byte, bool) {
+	gcm := key.gcm()
+	if len(sealed) < gcm.NonceSize() {
+		return nil, false
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], encryptionContextAAD(encCtx))
+	return plaintext, err == nil
+}
+
+func (key *kmsKey) gcm() cipher.AEAD {
+	block, _ := aes.NewCipher(key.material)
+	gcm, _ := cipher.NewGCM(block)
+	return gcm
+}
+
+func encryptionContextAAD(encCtx map[string]string) []byte {
+	keys := make([]string, 0, len(encCtx))
+	for k := range encCtx {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", k, encCtx[k])
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,101 @@
+package testutil
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+func TestFakeKMSEnvelope(t *testing.T) {
+	k := NewFakeKMS()
+	defer k.Close()
+	keyID := k.CreateKey("uploads")
+	encCtx := map[string]*string{"bucket": aws.String("uploads")}
+
+	dk, err := k.Client.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:             aws.String("alias/uploads"),
+		KeySpec:           aws.String("AES_256"),
+		EncryptionContext: encCtx,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dk.Plaintext) != 32 {
+		t.Errorf("data key is %d bytes, want 32", len(dk.Plaintext))
+	}
+	if got := aws.StringValue(dk.KeyId); got != k.KeyARN(keyID) {
+		t.Errorf("KeyId = %s, want %s", got, k.KeyARN(keyID))
+	}
+
+	out, err := k.Client.Decrypt(&kms.DecryptInput{
+		CiphertextBlob:    dk.CiphertextBlob,
+		EncryptionContext: encCtx,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Plaintext, dk.Plaintext) {
+		t.Error("decrypted data key differs from the generated one")
+	}
+
+	_, err = k.Client.Decrypt(&kms.DecryptInput{CiphertextBlob: dk.CiphertextBlob})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "InvalidCiphertextException" {
+		t.Errorf("expected InvalidCiphertextException without the encryption context, got %v", err)
+	}
+
+	k.AssertCalled(t, "GenerateDataKey", keyID)
+	k.AssertCalled(t, "Decrypt", keyID)
+	if got := len(k.CallsTo("Decrypt")); got != 2 {
+		t.Errorf("recorded %d Decrypt calls, want 2", got)
+	}
+}
+
+func TestFakeKMSDeterministic(t *testing.T) {
+	encrypt := func() []byte {
+		k := NewFakeKMS()
+		defer k.Close()
+		keyID := k.CreateKey("")
+		out, err := k.Client.Encrypt(&kms.EncryptInput{
+			KeyId:     aws.String(keyID),
+			Plaintext: []byte("secret"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out.CiphertextBlob
+	}
+	if a, b := encrypt(), encrypt(); !bytes.Equal(a, b) {
+		t.Error("expected identical fakes to produce identical ciphertexts")
+	}
+}
+
+func TestFakeKMSErrors(t *testing.T) {
+	k := NewFakeKMS()
+	defer k.Close()
+	keyID := k.CreateKey("")
+
+	_, err := k.Client.Encrypt(&kms.EncryptInput{KeyId: aws.String("alias/missing"), Plaintext: []byte("x")})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "NotFoundException" {
+		t.Errorf("expected NotFoundException, got %v", err)
+	}
+
+	k.DisableKey(keyID, true)
+	_, err = k.Client.Encrypt(&kms.EncryptInput{KeyId: aws.String(keyID), Plaintext: []byte("x")})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "DisabledException" {
+		t.Errorf("expected DisabledException, got %v", err)
+	}
+}
+
+func TestFakeKMSPolicy(t *testing.T) {
+	k := NewFakeKMS(WithPolicy(NewPolicy()))
+	defer k.Close()
+	keyID := k.CreateKey("")
+
+	_, err := k.Client.Encrypt(&kms.EncryptInput{KeyId: aws.String(keyID), Plaintext: []byte("x")})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "AccessDenied" {
+		t.Errorf("expected AccessDenied, got %v", err)
+	}
+}
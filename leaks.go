@@ -0,0 +1,210 @@
+package testutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// LeakAudit looks for resources left behind in the fakes at the end of
+// a test suite: objects in any bucket, messages in any queue and keys
+// in the redis DB the tests share. It finds tests that don't clean up
+// after themselves and code that leaks objects. Run it from TestMain
+// once the tests are done, before closing the fakes it inspects:
+//
+//	var sharedRedis *testutil.FakeRedis
+//
+//	func TestMain(m *testing.M) {
+//		sharedRedis = testutil.NewFakeRedisDB(redistest.DB)
+//		audit := &testutil.LeakAudit{
+//			S3:              testutil.NewFakeS3("audit"),
+//			Redis:           sharedRedis,
+//			IgnoreRedisKeys: []string{"fixture:*"},
+//		}
+//		code := m.Run()
+//		os.Exit(audit.Check(code))
+//	}
+type LeakAudit struct {
+	// S3, SQS and Redis are the fakes to inspect. Any may be nil. The
+	// audit covers every bucket and queue on the fake, not just the
+	// one the FakeS3 or FakeSQS was created with.
+	//
+	// Redis must be on a database given with NewFakeRedisDB, the one
+	// the tests share. A FakeRedis that claims a database has it to
+	// itself and flushes it on Close, so nothing can leak into it from
+	// other tests; Run refuses one.
+	S3    *FakeS3
+	SQS   *FakeSQS
+	Redis *FakeRedis
+
+	// IgnoreRedisKeys are patterns, in the syntax of path.Match, of
+	// keys that are expected to outlive the tests, such as fixtures.
+	IgnoreRedisKeys []string
+
+	// FailOnLeak makes Check turn a passing exit code into a failing
+	// one when anything leaked.
+	FailOnLeak bool
+
+	// Output is where Check writes its report. It defaults to
+	// os.Stderr.
+	Output io.Writer
+}
+
+// LeakReport lists the resources a LeakAudit found.
+type LeakReport struct {
+	// Objects maps each non-empty bucket to the keys in it.
+	Objects map[string][]string
+
+	// Messages maps the URL of each non-empty queue to the approximate
+	// number of messages in it, including in-flight ones.
+	Messages map[string]int
+
+	// RedisKeys are the keys left in the redis test DB that don't
+	// match IgnoreRedisKeys.
+	RedisKeys []string
+}
+
+// Empty reports whether nothing leaked.
+func (r *LeakReport) Empty() bool {
+	return len(r.Objects) == 0 && len(r.Messages) == 0 && len(r.RedisKeys) == 0
+}
+
+func (r *LeakReport) String() string {
+	if r.Empty() {
+		return "no leaked resources"
+	}
+	var b strings.Builder
+	b.WriteString("leaked resources:\n")
+	buckets := make([]string, 0, len(r.Objects))
+	for bucket := range r.Objects {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	for _, bucket := range buckets {
+		fmt.Fprintf(&b, "  s3 bucket %q: %d objects %q\n", bucket, len(r.Objects[bucket]), r.Objects[bucket])
+	}
+	urls := make([]string, 0, len(r.Messages))
+	for u := range r.Messages {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+	for _, u := range urls {
+		fmt.Fprintf(&b, "  sqs queue %s: %d messages\n", u, r.Messages[u])
+	}
+	if len(r.RedisKeys) > 0 {
+		fmt.Fprintf(&b, "  redis: %d keys %q\n", len(r.RedisKeys), r.RedisKeys)
+	}
+	return b.String()
+}
+
+// Run inspects the fakes and returns what it found.
+func (a *LeakAudit) Run() (*LeakReport, error) {
+	report := &LeakReport{
+		Objects:  make(map[string][]string),
+		Messages: make(map[string]int),
+	}
+
+	if a.S3 != nil {
+		out, err := a.S3.Client.ListBuckets(nil)
+		if err != nil {
+			return nil, fmt.Errorf("listing buckets: %v", err)
+		}
+		for _, bucket := range out.Buckets {
+			name := aws.StringValue(bucket.Name)
+			keys, err := a.S3.listKeys(name, "")
+			if err != nil {
+				return nil, fmt.Errorf("listing objects in %s: %v", name, err)
+			}
+			if len(keys) > 0 {
+				report.Objects[name] = keys
+			}
+		}
+	}
+
+	if a.SQS != nil {
+		out, err := a.SQS.Client.ListQueues(&sqs.ListQueuesInput{})
+		if err != nil {
+			return nil, fmt.Errorf("listing queues: %v", err)
+		}
+		for _, u := range out.QueueUrls {
+			attrs, err := a.SQS.Client.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+				QueueUrl: u,
+				AttributeNames: aws.StringSlice([]string{
+					"ApproximateNumberOfMessages",
+					"ApproximateNumberOfMessagesNotVisible",
+				}),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("reading attributes of %s: %v", aws.StringValue(u), err)
+			}
+			n := 0
+			for _, v := range attrs.Attributes {
+				c, _ := strconv.Atoi(aws.StringValue(v))
+				n += c
+			}
+			if n > 0 {
+				report.Messages[aws.StringValue(u)] = n
+			}
+		}
+	}
+
+	if a.Redis != nil {
+		if a.Redis.Claimed() {
+			return nil, fmt.Errorf("the audit's FakeRedis claimed redis DB %d for itself, so no test can leak into it; create it with NewFakeRedisDB on the DB the tests share", a.Redis.DB)
+		}
+		keys, err := a.redisKeys()
+		if err != nil {
+			return nil, fmt.Errorf("scanning redis: %v", err)
+		}
+		report.RedisKeys = keys
+	}
+
+	return report, nil
+}
+
+func (a *LeakAudit) redisKeys() ([]string, error) {
+	return a.Redis.Keys(func(k string) bool { return !a.ignoredRedisKey(k) })
+}
+
+func (a *LeakAudit) ignoredRedisKey(key string) bool {
+	for _, pattern := range a.IgnoreRedisKeys {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Check runs the audit after a test run that finished with exit code
+// code, reports any leaks to Output, and returns the exit code the
+// test binary should use. The code is only changed if FailOnLeak is
+// set or the audit itself fails.
+func (a *LeakAudit) Check(code int) int {
+	out := a.Output
+	if out == nil {
+		out = os.Stderr
+	}
+	report, err := a.Run()
+	if err != nil {
+		fmt.Fprintf(out, "leak audit failed: %v\n", err)
+		if code == 0 {
+			code = 1
+		}
+		return code
+	}
+	if report.Empty() {
+		return code
+	}
+	fmt.Fprint(out, report)
+	if a.FailOnLeak && code == 0 {
+		code = 1
+	}
+	return code
+}
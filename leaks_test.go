@@ -0,0 +1,60 @@
+package testutil
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLeakReportString(t *testing.T) {
+	r := &LeakReport{
+		Objects:   map[string][]string{"uploads": {"a.json", "b.json"}},
+		Messages:  map[string]int{"http://0.0.0.0:4568/jobs": 3},
+		RedisKeys: []string{"session:1"},
+	}
+	if r.Empty() {
+		t.Fatal("expected report with leaks not to be empty")
+	}
+	got := r.String()
+	for _, want := range []string{
+		`s3 bucket "uploads": 2 objects ["a.json" "b.json"]`,
+		"sqs queue http://0.0.0.0:4568/jobs: 3 messages",
+		`redis: 1 keys ["session:1"]`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("report %q does not contain %q", got, want)
+		}
+	}
+
+	if !(&LeakReport{}).Empty() {
+		t.Error("expected zero report to be empty")
+	}
+}
+
+func TestLeakAuditIgnoredRedisKey(t *testing.T) {
+	a := &LeakAudit{IgnoreRedisKeys: []string{"fixture:*", "config"}}
+	for key, want := range map[string]bool{
+		"fixture:users": true,
+		"config":        true,
+		"config:x":      false,
+		"session:1":     false,
+	} {
+		if got := a.ignoredRedisKey(key); got != want {
+			t.Errorf("ignoredRedisKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestLeakAuditCheckWithoutLeaks(t *testing.T) {
+	var out bytes.Buffer
+	a := &LeakAudit{FailOnLeak: true, Output: &out}
+	if got := a.Check(0); got != 0 {
+		t.Errorf("Check(0) = %d, want 0", got)
+	}
+	if got := a.Check(2); got != 2 {
+		t.Errorf("Check(2) = %d, want 2", got)
+	}
+	if out.Len() != 0 {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
@@ -0,0 +1,29 @@
+package testutil
+
+import "log"
+
+// Logger is the interface the fakes use to report progress and
+// failures. *testing.T and *testing.B both satisfy it, so passing the
+// current test to WithLogger makes output land in that test and turns
+// fatal errors into test failures instead of process exits.
+type Logger interface {
+	Logf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+// stdLogger is the default Logger. It writes to the standard log
+// package, so Fatalf exits the process as before.
+type stdLogger struct{}
+
+func (stdLogger) Logf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+func (stdLogger) Errorf(format string, args ...interface{}) {
+	log.Printf("ERROR: "+format, args...)
+}
+
+func (stdLogger) Fatalf(format string, args ...interface{}) {
+	log.Fatalf(format, args...)
+}
@@ -0,0 +1,501 @@
+package testutil
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// MemoryS3 is an in-memory s3iface.S3API for unit tests of code written
+// against the interface, with no process or network involved. It
+// supports creating, listing and deleting buckets, and putting,
+// getting (including byte ranges), copying, listing and deleting
+// objects, with their content type and user metadata. Listings honor
+// prefixes, delimiters and pagination.
+//
+// Any other method, including the *Request variants, panics.
+type MemoryS3 struct {
+	s3iface.S3API
+
+	clock Clock
+
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+type memoryBucket struct {
+	created time.Time
+	objects map[string]*memoryObject
+}
+
+type memoryObject struct {
+	body         []byte
+	contentType  string
+	metadata     map[string]*string
+	etag         string
+	lastModified time.Time
+}
+
+// NewMemoryS3 returns a MemoryS3 with no buckets. It reads the time for
+// LastModified from the Clock given by WithClock.
+func NewMemoryS3(opts ...Option) *MemoryS3 {
+	o := newOptions(opts)
+	return &MemoryS3{clock: o.clock, buckets: make(map[string]*memoryBucket)}
+}
+
+func s3Error(status int, code, format string, args ...interface{}) error {
+	return awserr.NewRequestFailure(awserr.New(code, fmt.Sprintf(format, args...), nil), status, "")
+}
+
+// bucket returns the bucket called name. m.mu must be held.
+func (m *MemoryS3) bucket(name *string) (*memoryBucket, error) {
+	b := m.buckets[aws.StringValue(name)]
+	if b == nil {
+		return nil, s3Error(404, "NoSuchBucket", "The specified bucket does not exist")
+	}
+	return b, nil
+}
+
+// object returns the object key in bucket. m.mu must be held.
+func (m *MemoryS3) object(bucket, key *string) (*memoryObject, error) {
+	b, err := m.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	obj := b.objects[aws.StringValue(key)]
+	if obj == nil {
+		return nil, s3Error(404, "NoSuchKey", "The specified key does not exist.")
+	}
+	return obj, nil
+}
+
+// CreateBucket creates a bucket.
+func (m *MemoryS3) CreateBucket(in *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name := aws.StringValue(in.Bucket)
+	if len(name) < 3 || len(name) > 63 {
+		return nil, s3Error(400, "InvalidBucketName", "The specified bucket is not valid.")
+	}
+	if m.buckets[name] != nil {
+		return nil, s3Error(409, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it.")
+	}
+	m.buckets[name] = &memoryBucket{created: m.clock.Now(), objects: make(map[string]*memoryObject)}
+	return &s3.CreateBucketOutput{Location: aws.String("/" + name)}, nil
+}
+
+// DeleteBucket deletes an empty bucket.
+func (m *MemoryS3) DeleteBucket(in *s3.DeleteBucketInput) (*s3.DeleteBucketOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.objects) > 0 {
+		return nil, s3Error(409, "BucketNotEmpty", "The bucket you tried to delete is not empty")
+	}
+	delete(m.buckets, aws.StringValue(in.Bucket))
+	return &s3.DeleteBucketOutput{}, nil
+}
+
+// HeadBucket checks that a bucket exists.
+func (m *MemoryS3) HeadBucket(in *s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.bucket(in.Bucket); err != nil {
+		// HEAD responses have no body, so there is no error code.
+		return nil, s3Error(404, "NotFound", "Not Found")
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+// WaitUntilBucketExists returns an error if the bucket doesn't exist,
+// since nothing else could create it while waiting.
+func (m *MemoryS3) WaitUntilBucketExists(in *s3.HeadBucketInput) error {
+	_, err := m.HeadBucket(in)
+	return err
+}
+
+// ListBuckets lists the buckets by name.
+func (m *MemoryS3) ListBuckets(in *s3.ListBucketsInput) (*s3.ListBucketsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := &s3.ListBucketsOutput{Owner: &s3.Owner{ID: aws.String(fakeAccountID)}}
+	for _, name := range sortedKeys(m.buckets) {
+		out.Buckets = append(out.Buckets, &s3.Bucket{
+			Name:         aws.String(name),
+			CreationDate: aws.Time(m.buckets[name].created),
+		})
+	}
+	return out, nil
+}
+
+// PutObject stores an object.
+func (m *MemoryS3) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	var body []byte
+	if in.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(in.Body); err != nil {
+			return nil, err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(in.Key) == "" {
+		return nil, s3Error(400, "InvalidArgument", "the object key is empty")
+	}
+	obj := &memoryObject{
+		body:         body,
+		contentType:  aws.StringValue(in.ContentType),
+		metadata:     canonicalMetadata(in.Metadata),
+		etag:         `"` + md5Hex(string(body)) + `"`,
+		lastModified: m.clock.Now(),
+	}
+	if obj.contentType == "" {
+		obj.contentType = "binary/octet-stream"
+	}
+	b.objects[aws.StringValue(in.Key)] = obj
+	return &s3.PutObjectOutput{ETag: aws.String(obj.etag)}, nil
+}
+
+// canonicalMetadata returns metadata with its keys capitalized as the
+// SDK returns them from S3, which sends them as HTTP headers.
+func canonicalMetadata(metadata map[string]*string) map[string]*string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	ret := make(map[string]*string, len(metadata))
+	for k, v := range metadata {
+		ret[http.CanonicalHeaderKey(k)] = aws.String(aws.StringValue(v))
+	}
+	return ret
+}
+
+// GetObject returns an object, or the byte range of it given by Range.
+func (m *MemoryS3) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, err := m.object(in.Bucket, in.Key)
+	if err != nil {
+		return nil, err
+	}
+	out := &s3.GetObjectOutput{
+		AcceptRanges:  aws.String("bytes"),
+		ContentLength: aws.Int64(int64(len(obj.body))),
+		ContentType:   aws.String(obj.contentType),
+		ETag:          aws.String(obj.etag),
+		LastModified:  aws.Time(obj.lastModified),
+		Metadata:      canonicalMetadata(obj.metadata),
+	}
+	body := obj.body
+	if in.Range != nil {
+		start, end, err := parseByteRange(aws.StringValue(in.Range), int64(len(body)))
+		if err != nil {
+			return nil, err
+		}
+		body = body[start : end+1]
+		out.ContentLength = aws.Int64(end - start + 1)
+		out.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, len(obj.body)))
+	}
+	out.Body = ioutil.NopCloser(bytes.NewReader(append([]byte(nil), body...)))
+	return out, nil
+}
+
+// parseByteRange parses an HTTP Range header for a single byte range
+// of an object of the given size, returning its first and last
+// offsets.
+func parseByteRange(r string, size int64) (start, end int64, err error) {
+	invalid := s3Error(416, "InvalidRange", "The requested range is not satisfiable")
+	spec := strings.TrimPrefix(r, "bytes=")
+	i := strings.Index(spec, "-")
+	if spec == r || i < 0 || strings.Contains(spec, ",") {
+		return 0, 0, invalid
+	}
+	first, last := spec[:i], spec[i+1:]
+	switch {
+	case first == "":
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n == 0 {
+			return 0, 0, invalid
+		}
+		if n > size {
+			n = size
+		}
+		start, end = size-n, size-1
+	default:
+		if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+			return 0, 0, invalid
+		}
+		end = size - 1
+		if last != "" {
+			if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+				return 0, 0, invalid
+			}
+			if end >= size {
+				end = size - 1
+			}
+		}
+	}
+	if start >= size || start < 0 {
+		return 0, 0, invalid
+	}
+	return start, end, nil
+}
+
+// HeadObject returns an object's metadata.
+func (m *MemoryS3) HeadObject(in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, err := m.object(in.Bucket, in.Key)
+	if err != nil {
+		return nil, s3Error(404, "NotFound", "Not Found")
+	}
+	return &s3.HeadObjectOutput{
+		AcceptRanges:  aws.String("bytes"),
+		ContentLength: aws.Int64(int64(len(obj.body))),
+		ContentType:   aws.String(obj.contentType),
+		ETag:          aws.String(obj.etag),
+		LastModified:  aws.Time(obj.lastModified),
+		Metadata:      canonicalMetadata(obj.metadata),
+	}, nil
+}
+
+// WaitUntilObjectExists returns an error if the object doesn't exist,
+// since nothing else could create it while waiting.
+func (m *MemoryS3) WaitUntilObjectExists(in *s3.HeadObjectInput) error {
+	_, err := m.HeadObject(in)
+	return err
+}
+
+// CopyObject copies an object. Its content type and metadata are
+// copied too, unless MetadataDirective is REPLACE.
+func (m *MemoryS3) CopyObject(in *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	source, err := url.PathUnescape(strings.TrimPrefix(aws.StringValue(in.CopySource), "/"))
+	if err != nil {
+		return nil, s3Error(400, "InvalidArgument", "invalid copy source %q", aws.StringValue(in.CopySource))
+	}
+	parts := strings.SplitN(source, "/", 2)
+	if len(parts) != 2 {
+		return nil, s3Error(400, "InvalidArgument", "invalid copy source %q", aws.StringValue(in.CopySource))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	src, err := m.object(&parts[0], &parts[1])
+	if err != nil {
+		return nil, err
+	}
+	b, err := m.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	obj := *src
+	obj.lastModified = m.clock.Now()
+	if aws.StringValue(in.MetadataDirective) == "REPLACE" {
+		obj.contentType = aws.StringValue(in.ContentType)
+		if obj.contentType == "" {
+			obj.contentType = "binary/octet-stream"
+		}
+		obj.metadata = canonicalMetadata(in.Metadata)
+	}
+	b.objects[aws.StringValue(in.Key)] = &obj
+	return &s3.CopyObjectOutput{CopyObjectResult: &s3.CopyObjectResult{
+		ETag:         aws.String(obj.etag),
+		LastModified: aws.Time(obj.lastModified),
+	}}, nil
+}
+
+// DeleteObject deletes an object. Deleting a missing object succeeds,
+// as on S3.
+func (m *MemoryS3) DeleteObject(in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	delete(b.objects, aws.StringValue(in.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// DeleteObjects deletes up to 1000 objects.
+func (m *MemoryS3) DeleteObjects(in *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if in.Delete == nil || len(in.Delete.Objects) == 0 || len(in.Delete.Objects) > 1000 {
+		return nil, s3Error(400, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema")
+	}
+	out := &s3.DeleteObjectsOutput{}
+	for _, id := range in.Delete.Objects {
+		delete(b.objects, aws.StringValue(id.Key))
+		if !aws.BoolValue(in.Delete.Quiet) {
+			out.Deleted = append(out.Deleted, &s3.DeletedObject{Key: id.Key})
+		}
+	}
+	return out, nil
+}
+
+// list returns up to max of the objects in b whose keys start with
+// prefix and come after after, rolling up keys containing delimiter
+// after the prefix into common prefixes. It also returns whether there
+// are more, and the key or common prefix to continue after.
+func (b *memoryBucket) list(prefix, delimiter, after string, max int64) (objs []*s3.Object, prefixes []*s3.CommonPrefix, truncated bool, next string) {
+	if max <= 0 || max > 1000 {
+		max = 1000
+	}
+	keys := sortedKeys(b.objects)
+	i := sort.SearchStrings(keys, after)
+	seen := make(map[string]bool)
+	for ; i < len(keys); i++ {
+		key := keys[i]
+		if key <= after || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		common := ""
+		if delimiter != "" {
+			if j := strings.Index(key[len(prefix):], delimiter); j >= 0 {
+				common = key[:len(prefix)+j+len(delimiter)]
+			}
+		}
+		if common != "" && (seen[common] || common == after) {
+			continue
+		}
+		if int64(len(objs)+len(prefixes)) == max {
+			return objs, prefixes, true, next
+		}
+		if common != "" {
+			seen[common] = true
+			prefixes = append(prefixes, &s3.CommonPrefix{Prefix: aws.String(common)})
+			next = common
+			continue
+		}
+		obj := b.objects[key]
+		objs = append(objs, &s3.Object{
+			Key:          aws.String(key),
+			ETag:         aws.String(obj.etag),
+			Size:         aws.Int64(int64(len(obj.body))),
+			LastModified: aws.Time(obj.lastModified),
+			StorageClass: aws.String("STANDARD"),
+		})
+		next = key
+	}
+	return objs, prefixes, false, ""
+}
+
+// ListObjects lists the objects in a bucket.
+func (m *MemoryS3) ListObjects(in *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	objs, prefixes, truncated, next := b.list(aws.StringValue(in.Prefix), aws.StringValue(in.Delimiter), aws.StringValue(in.Marker), aws.Int64Value(in.MaxKeys))
+	out := &s3.ListObjectsOutput{
+		Name:           in.Bucket,
+		Prefix:         in.Prefix,
+		Delimiter:      in.Delimiter,
+		Marker:         in.Marker,
+		MaxKeys:        in.MaxKeys,
+		Contents:       objs,
+		CommonPrefixes: prefixes,
+		IsTruncated:    aws.Bool(truncated),
+	}
+	if truncated && in.Delimiter != nil {
+		// S3 only returns NextMarker with a delimiter; otherwise
+		// clients continue after the last key.
+		out.NextMarker = aws.String(next)
+	}
+	return out, nil
+}
+
+// ListObjectsPages calls fn with each page of ListObjects, until fn
+// returns false.
+func (m *MemoryS3) ListObjectsPages(in *s3.ListObjectsInput, fn func(*s3.ListObjectsOutput, bool) bool) error {
+	page := *in
+	for {
+		out, err := m.ListObjects(&page)
+		if err != nil {
+			return err
+		}
+		last := !aws.BoolValue(out.IsTruncated)
+		if !fn(out, last) || last {
+			return nil
+		}
+		if out.NextMarker != nil {
+			page.Marker = out.NextMarker
+		} else {
+			page.Marker = out.Contents[len(out.Contents)-1].Key
+		}
+	}
+}
+
+// ListObjectsV2 lists the objects in a bucket.
+func (m *MemoryS3) ListObjectsV2(in *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, err := m.bucket(in.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	after := aws.StringValue(in.StartAfter)
+	if in.ContinuationToken != nil {
+		after = aws.StringValue(in.ContinuationToken)
+	}
+	objs, prefixes, truncated, next := b.list(aws.StringValue(in.Prefix), aws.StringValue(in.Delimiter), after, aws.Int64Value(in.MaxKeys))
+	out := &s3.ListObjectsV2Output{
+		Name:              in.Bucket,
+		Prefix:            in.Prefix,
+		Delimiter:         in.Delimiter,
+		StartAfter:        in.StartAfter,
+		ContinuationToken: in.ContinuationToken,
+		MaxKeys:           in.MaxKeys,
+		Contents:          objs,
+		CommonPrefixes:    prefixes,
+		KeyCount:          aws.Int64(int64(len(objs) + len(prefixes))),
+		IsTruncated:       aws.Bool(truncated),
+	}
+	if truncated {
+		// Real continuation tokens are opaque; this one is the last
+		// key or prefix listed.
+		out.NextContinuationToken = aws.String(next)
+	}
+	return out, nil
+}
+
+// ListObjectsV2Pages calls fn with each page of ListObjectsV2, until fn
+// returns false.
+func (m *MemoryS3) ListObjectsV2Pages(in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	page := *in
+	for {
+		out, err := m.ListObjectsV2(&page)
+		if err != nil {
+			return err
+		}
+		last := !aws.BoolValue(out.IsTruncated)
+		if !fn(out, last) || last {
+			return nil
+		}
+		page.ContinuationToken = out.NextContinuationToken
+	}
+}
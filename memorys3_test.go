@@ -0,0 +1,109 @@
+package testutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+var _ s3iface.S3API = (*MemoryS3)(nil)
+
+func TestMemoryS3Objects(t *testing.T) {
+	var svc s3iface.S3API = NewMemoryS3()
+	if _, err := svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("uploads")}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String("uploads"),
+		Key:         aws.String("a.txt"),
+		Body:        strings.NewReader("hello world"),
+		ContentType: aws.String("text/plain"),
+		Metadata:    map[string]*string{"owner": aws.String("bob")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String("uploads"), Key: aws.String("a.txt"), Range: aws.String("bytes=6-")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(obj.Body)
+	if string(body) != "world" || *obj.ContentRange != "bytes 6-10/11" || *obj.ContentType != "text/plain" || *obj.Metadata["Owner"] != "bob" {
+		t.Errorf("GetObject = %q, %v", body, obj)
+	}
+
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String("uploads"),
+		Key:        aws.String("copy/b.txt"),
+		CopySource: aws.String("uploads/a.txt"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("uploads"), Key: aws.String("copy/b.txt")})
+	if err != nil || *head.ContentLength != 11 || *head.ContentType != "text/plain" {
+		t.Errorf("HeadObject = %v, %v", head, err)
+	}
+
+	if _, err := svc.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String("uploads")}); !isErrorCode(err, "BucketNotEmpty") {
+		t.Errorf("deleting a non-empty bucket: %v", err)
+	}
+	svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String("uploads"), Key: aws.String("a.txt")})
+	_, err = svc.GetObject(&s3.GetObjectInput{Bucket: aws.String("uploads"), Key: aws.String("a.txt")})
+	if !isErrorCode(err, "NoSuchKey") || !isNotFound(err) {
+		t.Errorf("getting a deleted object: %v", err)
+	}
+	_, err = svc.GetObject(&s3.GetObjectInput{Bucket: aws.String("missing"), Key: aws.String("a.txt")})
+	if !isErrorCode(err, "NoSuchBucket") {
+		t.Errorf("getting from a missing bucket: %v", err)
+	}
+}
+
+func TestMemoryS3List(t *testing.T) {
+	m := NewMemoryS3()
+	m.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("logs")})
+	for _, key := range []string{"a/1", "a/2", "b/1", "c", "d"} {
+		m.PutObject(&s3.PutObjectInput{Bucket: aws.String("logs"), Key: aws.String(key), Body: strings.NewReader(key)})
+	}
+
+	var pages []string
+	err := m.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String("logs"),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int64(2),
+	}, func(out *s3.ListObjectsV2Output, last bool) bool {
+		var page []string
+		for _, p := range out.CommonPrefixes {
+			page = append(page, *p.Prefix)
+		}
+		for _, obj := range out.Contents {
+			page = append(page, *obj.Key)
+		}
+		pages = append(pages, fmt.Sprint(page))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(pages), "[[a/ b/] [c d]]"; got != want {
+		t.Errorf("ListObjectsV2Pages = %s, want %s", got, want)
+	}
+
+	var keys []string
+	m.ListObjectsPages(&s3.ListObjectsInput{Bucket: aws.String("logs"), Prefix: aws.String("a/"), MaxKeys: aws.Int64(1)},
+		func(out *s3.ListObjectsOutput, last bool) bool {
+			for _, obj := range out.Contents {
+				keys = append(keys, *obj.Key)
+			}
+			return true
+		})
+	if got, want := fmt.Sprint(keys), "[a/1 a/2]"; got != want {
+		t.Errorf("ListObjectsPages = %s, want %s", got, want)
+	}
+}
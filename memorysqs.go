@@ -0,0 +1,471 @@
+package testutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// MemorySQS is an in-memory sqsiface.SQSAPI for unit tests of code
+// written against the interface, with no process or network involved.
+// Queues are MemoryQueues, so they share its semantics. It supports
+// creating, listing, configuring, purging and deleting queues, and
+// sending, receiving, deleting and changing the visibility of
+// messages, singly or in batches. Visibility timeouts and redrive
+// policies set as queue attributes are honored; other attributes are
+// ignored.
+//
+// Any other method, including the *Request variants, panics.
+type MemorySQS struct {
+	sqsiface.SQSAPI
+
+	region string
+	opts   []Option
+
+	// endpoint, if set, is the base of queue URLs, for an sqsServer.
+	endpoint string
+
+	mu     sync.Mutex
+	queues map[string]*MemoryQueue
+}
+
+// NewMemorySQS returns a MemorySQS with no queues. WithRegion sets the
+// region in queue URLs and ARNs, and WithClock and WithLogger are
+// passed on to its queues.
+func NewMemorySQS(opts ...Option) *MemorySQS {
+	o := newOptions(opts)
+	m := &MemorySQS{region: o.region, opts: opts, queues: make(map[string]*MemoryQueue)}
+	if m.region == "" {
+		m.region = defaultRegion
+	}
+	return m
+}
+
+// Queue returns the queue called name, or nil if there is none.
+func (m *MemorySQS) Queue(name string) *MemoryQueue {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queues[name]
+}
+
+func (m *MemorySQS) queueURL(name string) string {
+	if m.endpoint != "" {
+		return m.endpoint + "/" + name
+	}
+	return fmt.Sprintf("https://sqs.%s.amazonaws.com/%s/%s", m.region, fakeAccountID, name)
+}
+
+func (m *MemorySQS) queueARN(name string) string {
+	return fmt.Sprintf("arn:aws:sqs:%s:%s:%s", m.region, fakeAccountID, name)
+}
+
+// queue returns the queue at url.
+func (m *MemorySQS) queue(url *string) (*MemoryQueue, error) {
+	if q := m.Queue(queueNameFromURL(aws.StringValue(url))); q != nil {
+		return q, nil
+	}
+	return nil, awserr.NewRequestFailure(awserr.New("AWS.SimpleQueueService.NonExistentQueue",
+		"The specified queue does not exist for this wsdl version.", nil), 400, "")
+}
+
+func invalidParameter(format string, args ...interface{}) error {
+	return awserr.NewRequestFailure(awserr.New("InvalidParameterValue", fmt.Sprintf(format, args...), nil), 400, "")
+}
+
+// CreateQueue creates a queue, or returns the URL of an existing one.
+func (m *MemorySQS) CreateQueue(in *sqs.CreateQueueInput) (*sqs.CreateQueueOutput, error) {
+	name := aws.StringValue(in.QueueName)
+	if name == "" || len(name) > 80 {
+		return nil, invalidParameter("invalid queue name %q", name)
+	}
+	fifo := strings.HasSuffix(name, ".fifo")
+	if fifo != (aws.StringValue(in.Attributes["FifoQueue"]) == "true") {
+		return nil, invalidParameter("a FIFO queue must have the FifoQueue attribute and a name ending in .fifo")
+	}
+	m.mu.Lock()
+	q := m.queues[name]
+	if q == nil {
+		q = NewMemoryQueue(name, m.opts...)
+		q.fifo = fifo
+		m.queues[name] = q
+	}
+	m.mu.Unlock()
+	if err := m.setAttributes(q, in.Attributes); err != nil {
+		return nil, err
+	}
+	return &sqs.CreateQueueOutput{QueueUrl: aws.String(m.queueURL(name))}, nil
+}
+
+// GetQueueUrl returns the URL of a queue.
+func (m *MemorySQS) GetQueueUrl(in *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	name := aws.StringValue(in.QueueName)
+	if _, err := m.queue(&name); err != nil {
+		return nil, err
+	}
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String(m.queueURL(name))}, nil
+}
+
+// DeleteQueue deletes a queue and its messages.
+func (m *MemorySQS) DeleteQueue(in *sqs.DeleteQueueInput) (*sqs.DeleteQueueOutput, error) {
+	q, err := m.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	delete(m.queues, q.Name)
+	m.mu.Unlock()
+	return &sqs.DeleteQueueOutput{}, nil
+}
+
+// ListQueues lists the URLs of the queues whose names start with
+// QueueNamePrefix.
+func (m *MemorySQS) ListQueues(in *sqs.ListQueuesInput) (*sqs.ListQueuesOutput, error) {
+	m.mu.Lock()
+	names := sortedKeys(m.queues)
+	m.mu.Unlock()
+	out := &sqs.ListQueuesOutput{}
+	for _, name := range names {
+		if strings.HasPrefix(name, aws.StringValue(in.QueueNamePrefix)) {
+			out.QueueUrls = append(out.QueueUrls, aws.String(m.queueURL(name)))
+		}
+	}
+	return out, nil
+}
+
+// ListDeadLetterSourceQueues lists the URLs of the queues whose
+// redrive policy targets a queue.
+func (m *MemorySQS) ListDeadLetterSourceQueues(in *sqs.ListDeadLetterSourceQueuesInput) (*sqs.ListDeadLetterSourceQueuesOutput, error) {
+	dlq, err := m.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := &sqs.ListDeadLetterSourceQueuesOutput{QueueUrls: []*string{}}
+	for _, name := range sortedKeys(m.queues) {
+		q := m.queues[name]
+		q.mu.Lock()
+		if q.deadLetter == dlq {
+			out.QueueUrls = append(out.QueueUrls, aws.String(m.queueURL(name)))
+		}
+		q.mu.Unlock()
+	}
+	return out, nil
+}
+
+// redrivePolicy is the JSON of the RedrivePolicy queue attribute.
+type redrivePolicy struct {
+	DeadLetterTargetArn string      `json:"deadLetterTargetArn"`
+	MaxReceiveCount     json.Number `json:"maxReceiveCount"`
+}
+
+// setAttributes applies the VisibilityTimeout, RedrivePolicy and
+// ContentBasedDeduplication attributes in attrs to q.
+func (m *MemorySQS) setAttributes(q *MemoryQueue, attrs map[string]*string) error {
+	if v, ok := attrs["ContentBasedDeduplication"]; ok {
+		if !q.fifo {
+			return awserr.NewRequestFailure(awserr.New("InvalidAttributeName", "ContentBasedDeduplication is only for FIFO queues", nil), 400, "")
+		}
+		q.mu.Lock()
+		q.contentDedup = aws.StringValue(v) == "true"
+		q.mu.Unlock()
+	}
+	if v, ok := attrs["VisibilityTimeout"]; ok {
+		secs, err := strconv.Atoi(aws.StringValue(v))
+		if err != nil || secs < 0 || secs > 43200 {
+			return invalidParameter("invalid VisibilityTimeout %q", aws.StringValue(v))
+		}
+		q.mu.Lock()
+		q.visibility = time.Duration(secs) * time.Second
+		q.mu.Unlock()
+	}
+	if v, ok := attrs["RedrivePolicy"]; ok {
+		if aws.StringValue(v) == "" {
+			q.SetDeadLetterQueue(nil, 0)
+			return nil
+		}
+		var p redrivePolicy
+		if err := json.Unmarshal([]byte(aws.StringValue(v)), &p); err != nil {
+			return invalidParameter("invalid RedrivePolicy: %v", err)
+		}
+		max, err := p.MaxReceiveCount.Int64()
+		if err != nil || max < 1 {
+			return invalidParameter("invalid RedrivePolicy maxReceiveCount %q", p.MaxReceiveCount)
+		}
+		arn := p.DeadLetterTargetArn
+		dlq := m.Queue(arn[strings.LastIndex(arn, ":")+1:])
+		if dlq == nil {
+			return invalidParameter("dead-letter target %s does not exist", arn)
+		}
+		q.SetDeadLetterQueue(dlq, int(max))
+	}
+	return nil
+}
+
+// SetQueueAttributes sets a queue's attributes.
+func (m *MemorySQS) SetQueueAttributes(in *sqs.SetQueueAttributesInput) (*sqs.SetQueueAttributesOutput, error) {
+	q, err := m.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.setAttributes(q, in.Attributes); err != nil {
+		return nil, err
+	}
+	return &sqs.SetQueueAttributesOutput{}, nil
+}
+
+// GetQueueAttributes returns a queue's message counts, ARN, visibility
+// timeout and redrive policy, as requested.
+func (m *MemorySQS) GetQueueAttributes(in *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+	q, err := m.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	waiting, inFlight := q.Len()
+	q.mu.Lock()
+	attrs := map[string]string{
+		"ApproximateNumberOfMessages":           strconv.Itoa(waiting),
+		"ApproximateNumberOfMessagesNotVisible": strconv.Itoa(inFlight),
+		"ApproximateNumberOfMessagesDelayed":    "0",
+		"QueueArn":                              m.queueARN(q.Name),
+		"VisibilityTimeout":                     strconv.Itoa(int(q.visibility / time.Second)),
+	}
+	if q.fifo {
+		attrs["FifoQueue"] = "true"
+		attrs["ContentBasedDeduplication"] = strconv.FormatBool(q.contentDedup)
+	}
+	if q.deadLetter != nil {
+		b, _ := json.Marshal(redrivePolicy{m.queueARN(q.deadLetter.Name), json.Number(strconv.Itoa(q.maxReceiveCount))})
+		attrs["RedrivePolicy"] = string(b)
+	}
+	q.mu.Unlock()
+
+	out := &sqs.GetQueueAttributesOutput{Attributes: make(map[string]*string)}
+	for k, v := range attrs {
+		if wantAttribute(in.AttributeNames, k) {
+			out.Attributes[k] = aws.String(v)
+		}
+	}
+	return out, nil
+}
+
+// wantAttribute reports whether names, as given in a request, ask for
+// the attribute name. Names may be "All", or end in ".*" to ask for
+// every attribute with that prefix.
+func wantAttribute(names []*string, name string) bool {
+	for _, n := range aws.StringValueSlice(names) {
+		if n == "All" || n == name || n == ".*" || strings.HasSuffix(n, ".*") && strings.HasPrefix(name, strings.TrimSuffix(n, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// PurgeQueue deletes every message in a queue.
+func (m *MemorySQS) PurgeQueue(in *sqs.PurgeQueueInput) (*sqs.PurgeQueueOutput, error) {
+	q, err := m.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	q.Reset()
+	return &sqs.PurgeQueueOutput{}, nil
+}
+
+// SendMessage sends a message.
+func (m *MemorySQS) SendMessage(in *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	q, err := m.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(in.MessageBody) == "" {
+		return nil, awserr.NewRequestFailure(awserr.New("MissingParameter", "The request must contain the parameter MessageBody.", nil), 400, "")
+	}
+	if q.fifo {
+		// The SDK this package vendors predates FIFO queues, so its
+		// inputs have no MessageGroupId.
+		return nil, errMissingMessageGroup
+	}
+	msg := q.send(aws.StringValue(in.MessageBody), in.MessageAttributes)
+	return &sqs.SendMessageOutput{
+		MessageId:        aws.String(msg.id),
+		MD5OfMessageBody: aws.String(md5Hex(msg.body)),
+	}, nil
+}
+
+// SendMessageBatch sends up to 10 messages.
+func (m *MemorySQS) SendMessageBatch(in *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	q, err := m.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBatch(len(in.Entries)); err != nil {
+		return nil, err
+	}
+	if q.fifo {
+		return nil, errMissingMessageGroup
+	}
+	out := &sqs.SendMessageBatchOutput{}
+	for _, e := range in.Entries {
+		if aws.StringValue(e.MessageBody) == "" {
+			out.Failed = append(out.Failed, batchFailure(e.Id, "MissingParameter", "The message body is empty."))
+			continue
+		}
+		msg := q.send(aws.StringValue(e.MessageBody), e.MessageAttributes)
+		out.Successful = append(out.Successful, &sqs.SendMessageBatchResultEntry{
+			Id:               e.Id,
+			MessageId:        aws.String(msg.id),
+			MD5OfMessageBody: aws.String(md5Hex(msg.body)),
+		})
+	}
+	return out, nil
+}
+
+func checkBatch(n int) error {
+	switch {
+	case n == 0:
+		return awserr.NewRequestFailure(awserr.New("AWS.SimpleQueueService.EmptyBatchRequest", "There should be at least one entry in the request.", nil), 400, "")
+	case n > 10:
+		return awserr.NewRequestFailure(awserr.New("AWS.SimpleQueueService.TooManyEntriesInBatchRequest", "Maximum number of entries per request are 10.", nil), 400, "")
+	}
+	return nil
+}
+
+func batchFailure(id *string, code, message string) *sqs.BatchResultErrorEntry {
+	return &sqs.BatchResultErrorEntry{Id: id, Code: aws.String(code), Message: aws.String(message), SenderFault: aws.Bool(true)}
+}
+
+// batchError returns the failure entry for err from entry id.
+func batchError(id *string, err error) *sqs.BatchResultErrorEntry {
+	code := errorCode(err)
+	msg := err.Error()
+	if aerr, ok := err.(awserr.Error); ok {
+		msg = aerr.Message()
+	}
+	return batchFailure(id, code, msg)
+}
+
+// ReceiveMessage receives up to MaxNumberOfMessages messages, long
+// polling for WaitTimeSeconds. Only the attributes asked for are
+// returned, as from SQS.
+func (m *MemorySQS) ReceiveMessage(in *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	return m.receiveMessage(context.Background(), in)
+}
+
+// receiveMessage is ReceiveMessage, but stops long polling when ctx
+// is done.
+func (m *MemorySQS) receiveMessage(ctx context.Context, in *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	q, err := m.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	max := aws.Int64Value(in.MaxNumberOfMessages)
+	if max == 0 {
+		max = 1
+	}
+	visibility := time.Duration(-1)
+	if in.VisibilityTimeout != nil {
+		visibility = time.Duration(*in.VisibilityTimeout) * time.Second
+	}
+	msgs, err := q.receiveWait(ctx, max, time.Duration(aws.Int64Value(in.WaitTimeSeconds))*time.Second, visibility)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs {
+		for k := range msg.Attributes {
+			if !wantAttribute(in.AttributeNames, k) {
+				delete(msg.Attributes, k)
+			}
+		}
+		attrs := make(map[string]*sqs.MessageAttributeValue)
+		for k, v := range msg.MessageAttributes {
+			if wantAttribute(in.MessageAttributeNames, k) {
+				attrs[k] = v
+			}
+		}
+		msg.MessageAttributes = nil
+		if len(attrs) > 0 {
+			msg.MessageAttributes = attrs
+		}
+		if len(msg.Attributes) == 0 {
+			msg.Attributes = nil
+		}
+	}
+	return &sqs.ReceiveMessageOutput{Messages: msgs}, nil
+}
+
+// DeleteMessage deletes a received message.
+func (m *MemorySQS) DeleteMessage(in *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	q, err := m.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.DeleteMessage(aws.StringValue(in.ReceiptHandle)); err != nil {
+		return nil, err
+	}
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+// DeleteMessageBatch deletes up to 10 received messages.
+func (m *MemorySQS) DeleteMessageBatch(in *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	q, err := m.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBatch(len(in.Entries)); err != nil {
+		return nil, err
+	}
+	out := &sqs.DeleteMessageBatchOutput{}
+	for _, e := range in.Entries {
+		if err := q.DeleteMessage(aws.StringValue(e.ReceiptHandle)); err != nil {
+			out.Failed = append(out.Failed, batchError(e.Id, err))
+		} else {
+			out.Successful = append(out.Successful, &sqs.DeleteMessageBatchResultEntry{Id: e.Id})
+		}
+	}
+	return out, nil
+}
+
+// ChangeMessageVisibility changes the visibility timeout of a message
+// in flight.
+func (m *MemorySQS) ChangeMessageVisibility(in *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	q, err := m.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	err = q.ChangeMessageVisibility(aws.StringValue(in.ReceiptHandle), time.Duration(aws.Int64Value(in.VisibilityTimeout))*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// ChangeMessageVisibilityBatch changes the visibility timeout of up to
+// 10 messages in flight.
+func (m *MemorySQS) ChangeMessageVisibilityBatch(in *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	q, err := m.queue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBatch(len(in.Entries)); err != nil {
+		return nil, err
+	}
+	out := &sqs.ChangeMessageVisibilityBatchOutput{}
+	for _, e := range in.Entries {
+		err := q.ChangeMessageVisibility(aws.StringValue(e.ReceiptHandle), time.Duration(aws.Int64Value(e.VisibilityTimeout))*time.Second)
+		if err != nil {
+			out.Failed = append(out.Failed, batchError(e.Id, err))
+		} else {
+			out.Successful = append(out.Successful, &sqs.ChangeMessageVisibilityBatchResultEntry{Id: e.Id})
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,132 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+var _ sqsiface.SQSAPI = (*MemorySQS)(nil)
+
+func TestMemorySQS(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	var svc sqsiface.SQSAPI = NewMemorySQS(WithClock(clock))
+
+	created, err := svc.CreateQueue(&sqs.CreateQueueInput{
+		QueueName:  aws.String("jobs"),
+		Attributes: map[string]*string{"VisibilityTimeout": aws.String("5")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := created.QueueUrl
+	got, err := svc.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: aws.String("jobs")})
+	if err != nil || *got.QueueUrl != *url {
+		t.Fatalf("GetQueueUrl = %v, %v; want %s", got, err, *url)
+	}
+
+	_, err = svc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:          url,
+		MessageBody:       aws.String("one"),
+		MessageAttributes: StringAttributes(map[string]string{"trace": "t1", "other": "x"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch, err := svc.SendMessageBatch(&sqs.SendMessageBatchInput{
+		QueueUrl: url,
+		Entries: []*sqs.SendMessageBatchRequestEntry{
+			{Id: aws.String("a"), MessageBody: aws.String("two")},
+			{Id: aws.String("b"), MessageBody: aws.String("")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch.Successful) != 1 || len(batch.Failed) != 1 || *batch.Failed[0].Id != "b" {
+		t.Errorf("SendMessageBatch = %v", batch)
+	}
+
+	out, err := svc.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:              url,
+		MaxNumberOfMessages:   aws.Int64(10),
+		MessageAttributeNames: aws.StringSlice([]string{"trace"}),
+		AttributeNames:        aws.StringSlice([]string{"ApproximateReceiveCount"}),
+	})
+	if err != nil || len(out.Messages) != 2 {
+		t.Fatalf("ReceiveMessage = %v, %v", out, err)
+	}
+	msg := out.Messages[0]
+	if *msg.Body != "one" || len(msg.MessageAttributes) != 1 || len(msg.Attributes) != 1 {
+		t.Errorf("attributes not filtered: %v", msg)
+	}
+	AssertAttribute(t, msg, "trace", "t1")
+
+	attrs, err := svc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       url,
+		AttributeNames: aws.StringSlice([]string{"All"}),
+	})
+	if err != nil || *attrs.Attributes["ApproximateNumberOfMessagesNotVisible"] != "2" || *attrs.Attributes["VisibilityTimeout"] != "5" {
+		t.Errorf("GetQueueAttributes = %v, %v", attrs, err)
+	}
+
+	del, err := svc.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
+		QueueUrl: url,
+		Entries: []*sqs.DeleteMessageBatchRequestEntry{
+			{Id: aws.String("1"), ReceiptHandle: msg.ReceiptHandle},
+			{Id: aws.String("2"), ReceiptHandle: aws.String("bogus")},
+		},
+	})
+	if err != nil || len(del.Successful) != 1 || len(del.Failed) != 1 {
+		t.Errorf("DeleteMessageBatch = %v, %v", del, err)
+	}
+
+	clock.Advance(5 * time.Second)
+	out, err = svc.ReceiveMessage(&sqs.ReceiveMessageInput{QueueUrl: url, MaxNumberOfMessages: aws.Int64(10)})
+	if err != nil || len(out.Messages) != 1 || *out.Messages[0].Body != "two" {
+		t.Errorf("after the visibility timeout, ReceiveMessage = %v, %v", out, err)
+	}
+
+	if _, err := svc.DeleteQueue(&sqs.DeleteQueueInput{QueueUrl: url}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = svc.SendMessage(&sqs.SendMessageInput{QueueUrl: url, MessageBody: aws.String("x")})
+	if !isErrorCode(err, "AWS.SimpleQueueService.NonExistentQueue") {
+		t.Errorf("sending to a deleted queue: %v", err)
+	}
+}
+
+func TestMemorySQSRedrive(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	m := NewMemorySQS(WithClock(clock))
+	dlq, _ := m.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String("jobs-dlq")})
+	arn, _ := m.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       dlq.QueueUrl,
+		AttributeNames: aws.StringSlice([]string{"QueueArn"}),
+	})
+	q, _ := m.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String("jobs")})
+	_, err := m.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		QueueUrl: q.QueueUrl,
+		Attributes: map[string]*string{
+			"RedrivePolicy": aws.String(`{"deadLetterTargetArn":"` + *arn.Attributes["QueueArn"] + `","maxReceiveCount":"1"}`),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources, err := m.ListDeadLetterSourceQueues(&sqs.ListDeadLetterSourceQueuesInput{QueueUrl: dlq.QueueUrl})
+	if err != nil || len(sources.QueueUrls) != 1 || *sources.QueueUrls[0] != *q.QueueUrl {
+		t.Errorf("ListDeadLetterSourceQueues = %v, %v", sources, err)
+	}
+
+	m.SendMessage(&sqs.SendMessageInput{QueueUrl: q.QueueUrl, MessageBody: aws.String("poison")})
+	for i := 0; i < 2; i++ {
+		m.ReceiveMessage(&sqs.ReceiveMessageInput{QueueUrl: q.QueueUrl, VisibilityTimeout: aws.Int64(0)})
+	}
+	if waiting, _ := m.Queue("jobs-dlq").Len(); waiting != 1 {
+		t.Errorf("dead-letter queue has %d messages, want 1", waiting)
+	}
+}
@@ -0,0 +1,178 @@
+package testutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+)
+
+// MockT is the part of testing.TB used by MockS3 and MockSQS.
+// *testing.T and *testing.B satisfy it.
+type MockT interface {
+	TestingT
+	Cleanup(func())
+}
+
+// MockCall is a call expected by MockS3 or MockSQS. Set up the output
+// with Return; by default the call succeeds with an empty output.
+type MockCall struct {
+	// Operation is the method the call is expected to, for example
+	// "PutObject".
+	Operation string
+
+	input  interface{}
+	match  func(interface{}) bool
+	output interface{}
+	err    error
+	times  int
+	calls  int
+}
+
+// Return sets the output and error the call returns. out must be a
+// pointer to the operation's output type, such as *s3.PutObjectOutput,
+// or nil.
+func (c *MockCall) Return(out interface{}, err error) *MockCall {
+	c.output, c.err = out, err
+	return c
+}
+
+// Match replaces the check of the call's input against the expected
+// one with fn, which is passed the input, such as *s3.PutObjectInput.
+func (c *MockCall) Match(fn func(in interface{}) bool) *MockCall {
+	c.match = fn
+	return c
+}
+
+// Times expects the call n times in a row rather than once.
+func (c *MockCall) Times(n int) *MockCall {
+	c.times = n
+	return c
+}
+
+func (c *MockCall) String() string {
+	s := c.Operation
+	if c.input != nil {
+		s += " " + strings.Join(strings.Fields(awsutil.Prettify(c.input)), " ")
+	}
+	if c.times > 1 {
+		s += fmt.Sprintf(" (%d of %d times)", c.calls, c.times)
+	}
+	return s
+}
+
+// matches reports whether a call to op with in satisfies c.
+func (c *MockCall) matches(op string, in interface{}) bool {
+	if op != c.Operation {
+		return false
+	}
+	if c.match != nil {
+		return c.match(in)
+	}
+	return c.input == nil || mockInputsEqual(c.input, in)
+}
+
+// mockInputsEqual reports whether two inputs of the same type are
+// equal, comparing the contents of request bodies rather than the
+// readers themselves. Bodies read are rewound.
+func mockInputsEqual(want, got interface{}) bool {
+	wv, gv := reflect.ValueOf(want), reflect.ValueOf(got)
+	if wv.Type() != gv.Type() || wv.IsNil() || gv.IsNil() {
+		return wv.Type() == gv.Type() && wv.IsNil() == gv.IsNil()
+	}
+	w, g := reflect.New(wv.Type().Elem()).Elem(), reflect.New(gv.Type().Elem()).Elem()
+	w.Set(wv.Elem())
+	g.Set(gv.Elem())
+	for i := 0; i < w.NumField(); i++ {
+		if w.Type().Field(i).PkgPath != "" {
+			continue
+		}
+		wb, ok1 := w.Field(i).Interface().(io.ReadSeeker)
+		gb, ok2 := g.Field(i).Interface().(io.ReadSeeker)
+		if !ok1 || !ok2 {
+			continue
+		}
+		if !bytes.Equal(readAndRewind(wb), readAndRewind(gb)) {
+			return false
+		}
+		w.Field(i).Set(reflect.Zero(w.Field(i).Type()))
+		g.Field(i).Set(reflect.Zero(g.Field(i).Type()))
+	}
+	return reflect.DeepEqual(w.Interface(), g.Interface())
+}
+
+func readAndRewind(r io.ReadSeeker) []byte {
+	b, _ := ioutil.ReadAll(r)
+	r.Seek(0, io.SeekStart)
+	return b
+}
+
+// mock matches calls against a script of expected calls, in order.
+type mock struct {
+	t       MockT
+	service string
+
+	mu       sync.Mutex
+	expected []*MockCall
+}
+
+func newMock(t MockT, service string) *mock {
+	m := &mock{t: t, service: service}
+	t.Cleanup(func() {
+		t.Helper()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for _, c := range m.expected {
+			t.Errorf("%s mock: expected call %s was not made", m.service, c)
+		}
+	})
+	return m
+}
+
+// expect adds a call to the end of the script.
+func (m *mock) expect(op string, in interface{}) *MockCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := &MockCall{Operation: op, input: in, times: 1}
+	if in != nil && reflect.ValueOf(in).IsNil() {
+		c.input = nil
+	}
+	m.expected = append(m.expected, c)
+	return c
+}
+
+// call checks a call to op with in against the next expected call, and
+// fills in out with the expected call's output. Unexpected calls fail
+// the test and return an error.
+func (m *mock) call(op string, in, out interface{}) error {
+	m.t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.expected) == 0 {
+		m.t.Errorf("%s mock: unexpected call %s %s", m.service, op, awsutil.Prettify(in))
+		return awserr.New("MockUnexpectedCall", "unexpected call to "+op, nil)
+	}
+	c := m.expected[0]
+	if !c.matches(op, in) {
+		m.t.Errorf("%s mock: got call %s %s\nwant %s", m.service, op, awsutil.Prettify(in), c)
+		return awserr.New("MockUnexpectedCall", "unexpected call to "+op, nil)
+	}
+	if c.calls++; c.calls == c.times {
+		m.expected = m.expected[1:]
+	}
+	if c.output != nil {
+		cv := reflect.ValueOf(c.output)
+		if cv.Type() != reflect.TypeOf(out) {
+			m.t.Errorf("%s mock: %s returns %T, not %T", m.service, op, out, c.output)
+		} else if !cv.IsNil() {
+			reflect.ValueOf(out).Elem().Set(cv.Elem())
+		}
+	}
+	return c.err
+}
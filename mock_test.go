@@ -0,0 +1,102 @@
+package testutil
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+var (
+	_ s3iface.S3API   = (*MockS3)(nil)
+	_ sqsiface.SQSAPI = (*MockSQS)(nil)
+)
+
+// cleanupT is a recordingT that runs its cleanups when asked.
+type cleanupT struct {
+	recordingT
+	cleanups []func()
+}
+
+func (t *cleanupT) Cleanup(fn func()) { t.cleanups = append(t.cleanups, fn) }
+
+func (t *cleanupT) finish() {
+	for i := len(t.cleanups) - 1; i >= 0; i-- {
+		t.cleanups[i]()
+	}
+}
+
+func TestMockS3(t *testing.T) {
+	mt := &cleanupT{}
+	m := NewMockS3(mt)
+	m.Expect("PutObject", &s3.PutObjectInput{
+		Bucket: aws.String("uploads"),
+		Key:    aws.String("a.txt"),
+		Body:   strings.NewReader("hello"),
+	}).Return(&s3.PutObjectOutput{ETag: aws.String(`"etag"`)}, nil)
+	m.Expect("GetObject", nil).Return(nil, errors.New("boom")).Times(2)
+
+	out, err := m.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String("uploads"),
+		Key:    aws.String("a.txt"),
+		Body:   strings.NewReader("hello"),
+	})
+	if err != nil || aws.StringValue(out.ETag) != `"etag"` {
+		t.Errorf("PutObject = %v, %v", out, err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := m.GetObject(&s3.GetObjectInput{Bucket: aws.String("uploads")}); err == nil || err.Error() != "boom" {
+			t.Errorf("GetObject error = %v, want boom", err)
+		}
+	}
+	mt.finish()
+	if len(mt.errors) != 0 {
+		t.Errorf("unexpected failures: %v", mt.errors)
+	}
+}
+
+func TestMockS3Unexpected(t *testing.T) {
+	mt := &cleanupT{}
+	m := NewMockS3(mt)
+	m.Expect("PutObject", &s3.PutObjectInput{Key: aws.String("a"), Body: strings.NewReader("x")})
+	m.Expect("DeleteObject", nil)
+	m.Expect("NoSuchMethod", nil)
+
+	if _, err := m.PutObject(&s3.PutObjectInput{Key: aws.String("a"), Body: strings.NewReader("y")}); !isErrorCode(err, "MockUnexpectedCall") {
+		t.Errorf("PutObject with the wrong body: %v", err)
+	}
+	mt.finish()
+	// NoSuchMethod, the PutObject mismatch, and the three calls not made.
+	if len(mt.errors) != 5 {
+		t.Errorf("got %d failures, want 5: %q", len(mt.errors), mt.errors)
+	}
+}
+
+func TestMockSQS(t *testing.T) {
+	mt := &cleanupT{}
+	m := NewMockSQS(mt)
+	m.Expect("ReceiveMessage", nil).Match(func(in interface{}) bool {
+		return aws.Int64Value(in.(*sqs.ReceiveMessageInput).WaitTimeSeconds) == 20
+	}).Return(&sqs.ReceiveMessageOutput{Messages: []*sqs.Message{{Body: aws.String("hi")}}}, nil)
+	m.Expect("DeleteMessage", &sqs.DeleteMessageInput{QueueUrl: aws.String("q"), ReceiptHandle: aws.String("r")})
+
+	out, err := m.ReceiveMessage(&sqs.ReceiveMessageInput{QueueUrl: aws.String("q"), WaitTimeSeconds: aws.Int64(20)})
+	if err != nil || len(out.Messages) != 1 {
+		t.Fatalf("ReceiveMessage = %v, %v", out, err)
+	}
+	if _, err := m.DeleteMessage(&sqs.DeleteMessageInput{QueueUrl: aws.String("q"), ReceiptHandle: aws.String("r")}); err != nil {
+		t.Error(err)
+	}
+	if _, err := m.SendMessage(&sqs.SendMessageInput{}); err == nil {
+		t.Error("unexpected SendMessage succeeded")
+	}
+	mt.finish()
+	if len(mt.errors) != 1 || !strings.Contains(mt.errors[0], "unexpected call SendMessage") {
+		t.Errorf("failures = %q", mt.errors)
+	}
+}
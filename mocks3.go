@@ -0,0 +1,151 @@
+package testutil
+
+import (
+	"reflect"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// MockS3 is an s3iface.S3API that returns canned outputs for an
+// ordered script of expected calls, for unit tests where even MemoryS3
+// is more than needed. Unexpected calls, and expected calls not made by
+// the end of the test, fail it.
+//
+// MockS3 implements the same methods as MemoryS3; any other method
+// panics.
+type MockS3 struct {
+	s3iface.S3API
+
+	mock *mock
+}
+
+// NewMockS3 returns a MockS3 with no expected calls, which fails t at
+// the end of the test if any calls it expects haven't been made.
+func NewMockS3(t MockT) *MockS3 {
+	return &MockS3{mock: newMock(t, "s3")}
+}
+
+// Expect adds a call to op, such as "CreateBucket", to the calls the
+// mock expects, after any already expected. The call's input must
+// equal in, compared field by field with request bodies compared by
+// content, unless in is nil or the call is given a Match function.
+func (m *MockS3) Expect(op string, in interface{}) *MockCall {
+	if _, ok := reflect.TypeOf(m).MethodByName(op); !ok {
+		m.mock.t.Helper()
+		m.mock.t.Errorf("s3 mock: MockS3 doesn't implement %s", op)
+	}
+	return m.mock.expect(op, in)
+}
+
+// CreateBucket returns the next expected output.
+func (m *MockS3) CreateBucket(in *s3.CreateBucketInput) (*s3.CreateBucketOutput, error) {
+	out := new(s3.CreateBucketOutput)
+	return out, m.mock.call("CreateBucket", in, out)
+}
+
+// DeleteBucket returns the next expected output.
+func (m *MockS3) DeleteBucket(in *s3.DeleteBucketInput) (*s3.DeleteBucketOutput, error) {
+	out := new(s3.DeleteBucketOutput)
+	return out, m.mock.call("DeleteBucket", in, out)
+}
+
+// HeadBucket returns the next expected output.
+func (m *MockS3) HeadBucket(in *s3.HeadBucketInput) (*s3.HeadBucketOutput, error) {
+	out := new(s3.HeadBucketOutput)
+	return out, m.mock.call("HeadBucket", in, out)
+}
+
+// ListBuckets returns the next expected output.
+func (m *MockS3) ListBuckets(in *s3.ListBucketsInput) (*s3.ListBucketsOutput, error) {
+	out := new(s3.ListBucketsOutput)
+	return out, m.mock.call("ListBuckets", in, out)
+}
+
+// PutObject returns the next expected output.
+func (m *MockS3) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	out := new(s3.PutObjectOutput)
+	return out, m.mock.call("PutObject", in, out)
+}
+
+// GetObject returns the next expected output.
+func (m *MockS3) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	out := new(s3.GetObjectOutput)
+	return out, m.mock.call("GetObject", in, out)
+}
+
+// HeadObject returns the next expected output.
+func (m *MockS3) HeadObject(in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	out := new(s3.HeadObjectOutput)
+	return out, m.mock.call("HeadObject", in, out)
+}
+
+// CopyObject returns the next expected output.
+func (m *MockS3) CopyObject(in *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	out := new(s3.CopyObjectOutput)
+	return out, m.mock.call("CopyObject", in, out)
+}
+
+// DeleteObject returns the next expected output.
+func (m *MockS3) DeleteObject(in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	out := new(s3.DeleteObjectOutput)
+	return out, m.mock.call("DeleteObject", in, out)
+}
+
+// DeleteObjects returns the next expected output.
+func (m *MockS3) DeleteObjects(in *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	out := new(s3.DeleteObjectsOutput)
+	return out, m.mock.call("DeleteObjects", in, out)
+}
+
+// ListObjects returns the next expected output.
+func (m *MockS3) ListObjects(in *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
+	out := new(s3.ListObjectsOutput)
+	return out, m.mock.call("ListObjects", in, out)
+}
+
+// ListObjectsV2 returns the next expected output.
+func (m *MockS3) ListObjectsV2(in *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	out := new(s3.ListObjectsV2Output)
+	return out, m.mock.call("ListObjectsV2", in, out)
+}
+
+// ListObjectsPages calls fn with the outputs of ListObjects calls,
+// which must be expected, until one isn't truncated or fn returns
+// false.
+func (m *MockS3) ListObjectsPages(in *s3.ListObjectsInput, fn func(*s3.ListObjectsOutput, bool) bool) error {
+	page := *in
+	for {
+		out, err := m.ListObjects(&page)
+		if err != nil {
+			return err
+		}
+		last := !aws.BoolValue(out.IsTruncated)
+		if !fn(out, last) || last {
+			return nil
+		}
+		page.Marker = out.NextMarker
+		if page.Marker == nil && len(out.Contents) > 0 {
+			page.Marker = out.Contents[len(out.Contents)-1].Key
+		}
+	}
+}
+
+// ListObjectsV2Pages calls fn with the outputs of ListObjectsV2 calls,
+// which must be expected, until one isn't truncated or fn returns
+// false.
+func (m *MockS3) ListObjectsV2Pages(in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	page := *in
+	for {
+		out, err := m.ListObjectsV2(&page)
+		if err != nil {
+			return err
+		}
+		last := !aws.BoolValue(out.IsTruncated)
+		if !fn(out, last) || last {
+			return nil
+		}
+		page.ContinuationToken = out.NextContinuationToken
+	}
+}
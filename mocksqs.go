@@ -0,0 +1,129 @@
+package testutil
+
+import (
+	"reflect"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// MockSQS is an sqsiface.SQSAPI that returns canned outputs for an
+// ordered script of expected calls, for unit tests where even MemorySQS
+// is more than needed. Unexpected calls, and expected calls not made by
+// the end of the test, fail it.
+//
+// MockSQS implements the same methods as MemorySQS; any other method
+// panics.
+type MockSQS struct {
+	sqsiface.SQSAPI
+
+	mock *mock
+}
+
+// NewMockSQS returns a MockSQS with no expected calls, which fails t at
+// the end of the test if any calls it expects haven't been made.
+func NewMockSQS(t MockT) *MockSQS {
+	return &MockSQS{mock: newMock(t, "sqs")}
+}
+
+// Expect adds a call to op, such as "SendMessage", to the calls the
+// mock expects, after any already expected. The call's input must
+// equal in, compared field by field with request bodies compared by
+// content, unless in is nil or the call is given a Match function.
+func (m *MockSQS) Expect(op string, in interface{}) *MockCall {
+	if _, ok := reflect.TypeOf(m).MethodByName(op); !ok {
+		m.mock.t.Helper()
+		m.mock.t.Errorf("sqs mock: MockSQS doesn't implement %s", op)
+	}
+	return m.mock.expect(op, in)
+}
+
+// CreateQueue returns the next expected output.
+func (m *MockSQS) CreateQueue(in *sqs.CreateQueueInput) (*sqs.CreateQueueOutput, error) {
+	out := new(sqs.CreateQueueOutput)
+	return out, m.mock.call("CreateQueue", in, out)
+}
+
+// GetQueueUrl returns the next expected output.
+func (m *MockSQS) GetQueueUrl(in *sqs.GetQueueUrlInput) (*sqs.GetQueueUrlOutput, error) {
+	out := new(sqs.GetQueueUrlOutput)
+	return out, m.mock.call("GetQueueUrl", in, out)
+}
+
+// DeleteQueue returns the next expected output.
+func (m *MockSQS) DeleteQueue(in *sqs.DeleteQueueInput) (*sqs.DeleteQueueOutput, error) {
+	out := new(sqs.DeleteQueueOutput)
+	return out, m.mock.call("DeleteQueue", in, out)
+}
+
+// ListQueues returns the next expected output.
+func (m *MockSQS) ListQueues(in *sqs.ListQueuesInput) (*sqs.ListQueuesOutput, error) {
+	out := new(sqs.ListQueuesOutput)
+	return out, m.mock.call("ListQueues", in, out)
+}
+
+// ListDeadLetterSourceQueues returns the next expected output.
+func (m *MockSQS) ListDeadLetterSourceQueues(in *sqs.ListDeadLetterSourceQueuesInput) (*sqs.ListDeadLetterSourceQueuesOutput, error) {
+	out := new(sqs.ListDeadLetterSourceQueuesOutput)
+	return out, m.mock.call("ListDeadLetterSourceQueues", in, out)
+}
+
+// GetQueueAttributes returns the next expected output.
+func (m *MockSQS) GetQueueAttributes(in *sqs.GetQueueAttributesInput) (*sqs.GetQueueAttributesOutput, error) {
+	out := new(sqs.GetQueueAttributesOutput)
+	return out, m.mock.call("GetQueueAttributes", in, out)
+}
+
+// SetQueueAttributes returns the next expected output.
+func (m *MockSQS) SetQueueAttributes(in *sqs.SetQueueAttributesInput) (*sqs.SetQueueAttributesOutput, error) {
+	out := new(sqs.SetQueueAttributesOutput)
+	return out, m.mock.call("SetQueueAttributes", in, out)
+}
+
+// PurgeQueue returns the next expected output.
+func (m *MockSQS) PurgeQueue(in *sqs.PurgeQueueInput) (*sqs.PurgeQueueOutput, error) {
+	out := new(sqs.PurgeQueueOutput)
+	return out, m.mock.call("PurgeQueue", in, out)
+}
+
+// SendMessage returns the next expected output.
+func (m *MockSQS) SendMessage(in *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	out := new(sqs.SendMessageOutput)
+	return out, m.mock.call("SendMessage", in, out)
+}
+
+// SendMessageBatch returns the next expected output.
+func (m *MockSQS) SendMessageBatch(in *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	out := new(sqs.SendMessageBatchOutput)
+	return out, m.mock.call("SendMessageBatch", in, out)
+}
+
+// ReceiveMessage returns the next expected output.
+func (m *MockSQS) ReceiveMessage(in *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	out := new(sqs.ReceiveMessageOutput)
+	return out, m.mock.call("ReceiveMessage", in, out)
+}
+
+// DeleteMessage returns the next expected output.
+func (m *MockSQS) DeleteMessage(in *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	out := new(sqs.DeleteMessageOutput)
+	return out, m.mock.call("DeleteMessage", in, out)
+}
+
+// DeleteMessageBatch returns the next expected output.
+func (m *MockSQS) DeleteMessageBatch(in *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	out := new(sqs.DeleteMessageBatchOutput)
+	return out, m.mock.call("DeleteMessageBatch", in, out)
+}
+
+// ChangeMessageVisibility returns the next expected output.
+func (m *MockSQS) ChangeMessageVisibility(in *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	out := new(sqs.ChangeMessageVisibilityOutput)
+	return out, m.mock.call("ChangeMessageVisibility", in, out)
+}
+
+// ChangeMessageVisibilityBatch returns the next expected output.
+func (m *MockSQS) ChangeMessageVisibilityBatch(in *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	out := new(sqs.ChangeMessageVisibilityBatchOutput)
+	return out, m.mock.call("ChangeMessageVisibilityBatch", in, out)
+}
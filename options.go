@@ -0,0 +1,299 @@
+package testutil
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Option configures a fake. Options that don't apply to a particular
+// fake are ignored by it.
+type Option func(*options)
+
+type options struct {
+	logger       Logger
+	dialAttempts int
+	dialBackoff  time.Duration
+	redisDB      int
+
+	combinedOutput bool
+	logOutput      bool
+
+	endpoint        string
+	region          string
+	credentials     *credentials.Credentials
+	pathStyle       bool
+	queueAttributes map[string]*string
+
+	multipartCopyThreshold int64
+	copyPartSize           int64
+	assertTimeout          time.Duration
+
+	signHooks []func(*request.Request)
+	sendHooks []sendHook
+
+	kms   *FakeKMS
+	clock Clock
+
+	dynamoLocalDir string
+	tablePrefix    string
+
+	strictTeardown bool
+	httpClient     *http.Client
+	reporter       *Reporter
+	partition      *NetworkPartition
+
+	skipIncompatible SkipT
+	admin            *Admin
+
+	pollInterval time.Duration
+	pollBackoff  time.Duration
+
+	launch      bool
+	processLogs bool
+	docker      bool
+	dockerImage string
+	inProcess   bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		logger:       stdLogger{},
+		dialAttempts: 5,
+		dialBackoff:  50 * time.Millisecond,
+		pathStyle:    true,
+		clock:        SystemClock,
+		pollInterval: 10 * time.Millisecond,
+		redisDB:      -1,
+
+		multipartCopyThreshold: 5 << 30,
+		copyPartSize:           512 << 20,
+		assertTimeout:          5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithLogger sets the Logger used by a fake. It is usually passed the
+// current *testing.T so that failures are reported on that test.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithDialRetry sets how many times FakeRedis tries to open a
+// connection before giving up, and the delay before the first retry.
+// The delay doubles after each failed attempt. The default is 5
+// attempts starting at 50ms.
+func WithDialRetry(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		if attempts < 1 {
+			attempts = 1
+		}
+		o.dialAttempts = attempts
+		o.dialBackoff = backoff
+	}
+}
+
+// WithEndpoint points an AWS fake at endpoint (for example
+// "http://localhost:4568") instead of its default port. Together with
+// distinct queue names this lets one test binary talk to several
+// fakes at once.
+func WithEndpoint(endpoint string) Option {
+	return func(o *options) {
+		o.endpoint = endpoint
+	}
+}
+
+// WithRegion sets the region used by an AWS fake's config. The default
+// is us-east-1.
+func WithRegion(region string) Option {
+	return func(o *options) {
+		o.region = region
+	}
+}
+
+// WithCredentials sets static credentials on an AWS fake's config.
+func WithCredentials(accessKeyID, secretAccessKey string) Option {
+	return func(o *options) {
+		o.credentials = credentials.NewStaticCredentials(accessKeyID, secretAccessKey, "")
+	}
+}
+
+// WithCredentialsProvider sets the provider an AWS fake's config gets
+// credentials from, for tests that exercise a particular provider
+// (for example one that expires). Without this or WithCredentials the
+// fakes use fixed static credentials and never read the environment.
+func WithCredentialsProvider(p credentials.Provider) Option {
+	return func(o *options) {
+		o.credentials = credentials.NewCredentials(p)
+	}
+}
+
+// WithPathStyle controls whether FakeS3 addresses buckets path-style
+// (http://host/bucket/key, the default) or virtual-hosted style
+// (http://bucket.host/key). Virtual-hosted addressing requires the
+// bucket subdomain to resolve to the fake.
+func WithPathStyle(pathStyle bool) Option {
+	return func(o *options) {
+		o.pathStyle = pathStyle
+	}
+}
+
+// WithQueueAttributes sets the attributes FakeSQS creates its queue
+// with, using the names from the SQS CreateQueue API, for example
+// "VisibilityTimeout" or "MessageRetentionPeriod". Values are given in
+// the API's string form ("30", "345600").
+func WithQueueAttributes(attrs map[string]string) Option {
+	return func(o *options) {
+		if o.queueAttributes == nil {
+			o.queueAttributes = make(map[string]*string)
+		}
+		for k, v := range attrs {
+			v := v
+			o.queueAttributes[k] = &v
+		}
+	}
+}
+
+// WithDynamoLocal sets the directory FakeDynamo runs DynamoDB Local
+// from, and downloads it to if DynamoDBLocal.jar isn't there.
+func WithDynamoLocal(dir string) Option {
+	return func(o *options) {
+		o.dynamoLocalDir = dir
+	}
+}
+
+// WithTablePrefix sets the prefix FakeDynamo.Table adds to table
+// names. By default each FakeDynamo gets a prefix unique to it.
+func WithTablePrefix(prefix string) Option {
+	return func(o *options) {
+		o.tablePrefix = prefix
+	}
+}
+
+// WithMultipartCopy sets the size above which FakeS3.CopyObject copies
+// objects in parts, and the size of each part. Lowering the threshold
+// lets tests exercise the multipart path with small objects. Note that
+// S3 requires every part but the last to be at least 5MB. A negative
+// threshold or a part size that isn't positive keeps the default.
+func WithMultipartCopy(threshold, partSize int64) Option {
+	return func(o *options) {
+		if threshold >= 0 {
+			o.multipartCopyThreshold = threshold
+		}
+		if partSize > 0 {
+			o.copyPartSize = partSize
+		}
+	}
+}
+
+// WithAssertTimeout sets how long FakeS3's object assertions, such as
+// AssertObjectExists, wait for the object to reach the expected state,
+// for code under test that uploads asynchronously. The default is 5s;
+// zero checks once.
+func WithAssertTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.assertTimeout = d
+	}
+}
+
+// WithPollInterval sets how often WaitForValue and WaitForContext
+// poll. The default is 10ms.
+func WithPollInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.pollInterval = d
+	}
+}
+
+// WithPollBackoff makes WaitForValue and WaitForContext back off
+// exponentially, doubling the poll interval after each try up to max,
+// with jitter, for conditions that are expensive to check.
+func WithPollBackoff(max time.Duration) Option {
+	return func(o *options) {
+		o.pollBackoff = max
+	}
+}
+
+// WithLaunch makes NewFakeS3, NewFakeSQS and NewFakeRedis launch their
+// own fakes3, fake_sqs or redis-server on a free port, keeping its data
+// in a temporary directory, rather than connecting to one already
+// running, so parallel test binaries don't share state. Close stops the
+// process and removes the directory. The executable must be in the
+// $PATH, except that S3 and SQS are served in process, as with
+// WithInProcess, if fakes3 or fake_sqs isn't. It is ignored by the AWS
+// fakes if WithEndpoint is given.
+func WithLaunch() Option {
+	return func(o *options) {
+		o.launch = true
+	}
+}
+
+// WithProcessLogs sends the output of child processes a fake launches,
+// such as fakes3 with WithLaunch or DynamoDB Local, to the Logger line
+// by line, for debugging them.
+func WithProcessLogs() Option {
+	return func(o *options) {
+		o.processLogs = true
+	}
+}
+
+// WithDocker makes NewFakeS3, NewFakeSQS and NewFakeRedis run their
+// server in a Docker container, published on a random local port, and
+// remove it on Close, so that tests need neither Ruby nor redis
+// installed, only Docker. FakeS3 runs LocalStack, FakeSQS runs
+// ElasticMQ and FakeRedis runs redis. It is ignored if WithEndpoint is
+// given.
+func WithDocker() Option {
+	return func(o *options) {
+		o.docker = true
+	}
+}
+
+// WithDockerImage sets the image WithDocker runs, for example to pin a
+// version or use a mirror. It must serve the same API on the same
+// container port as the default image.
+func WithDockerImage(image string) Option {
+	return func(o *options) {
+		o.dockerImage = image
+	}
+}
+
+// WithInProcess makes NewFakeS3 and NewFakeSQS serve S3 or SQS from
+// memory in the test process, on a local httptest server, so that
+// tests need no fakes3, fake_sqs, Docker or anything else installed.
+// S3 supports buckets, putting, getting, copying, listing and deleting
+// objects, and multipart uploads, always with path-style addressing.
+// SQS supports multiple queues, sending, long polling, deleting,
+// visibility timeouts, purging and FIFO queues with deduplication,
+// with the semantics of MemorySQS. It is ignored if WithEndpoint is
+// given.
+func WithInProcess() Option {
+	return func(o *options) {
+		o.inProcess = true
+	}
+}
+
+// WithCombinedOutput makes CaptureOutput point os.Stdout and os.Stderr
+// at the same pipe, like 2>&1 in a shell, so the output is interleaved
+// in the order it was written.
+func WithCombinedOutput() Option {
+	return func(o *options) {
+		o.combinedOutput = true
+	}
+}
+
+// WithLogOutput makes the capture functions also capture what is
+// written through the standard log package, which otherwise keeps
+// writing to the real stderr. It is captured with stderr, or with
+// stdout by CaptureStdout.
+func WithLogOutput() Option {
+	return func(o *options) {
+		o.logOutput = true
+	}
+}
@@ -0,0 +1,101 @@
+package testutil
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+func TestFakeAWSConfigOptions(t *testing.T) {
+	o := newOptions([]Option{
+		WithRegion("eu-west-1"),
+		WithCredentials("AKID", "SECRET"),
+	})
+	cfg := fakeAWSConfig("http://localhost:1234", o)
+
+	if got := aws.StringValue(cfg.Region); got != "eu-west-1" {
+		t.Errorf("region = %q, want eu-west-1", got)
+	}
+	if got := aws.StringValue(cfg.Endpoint); got != "http://localhost:1234" {
+		t.Errorf("endpoint = %q, want http://localhost:1234", got)
+	}
+	if !aws.BoolValue(cfg.S3ForcePathStyle) {
+		t.Error("expected path-style addressing by default")
+	}
+	v, err := cfg.Credentials.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.AccessKeyID != "AKID" || v.SecretAccessKey != "SECRET" {
+		t.Errorf("credentials = %+v, want AKID/SECRET", v)
+	}
+}
+
+func TestWithQueueAttributes(t *testing.T) {
+	o := newOptions([]Option{
+		WithQueueAttributes(map[string]string{"VisibilityTimeout": "5"}),
+		WithQueueAttributes(map[string]string{"MessageRetentionPeriod": "60"}),
+	})
+
+	want := map[string]string{"VisibilityTimeout": "5", "MessageRetentionPeriod": "60"}
+	if len(o.queueAttributes) != len(want) {
+		t.Fatalf("got %d attributes, want %d", len(o.queueAttributes), len(want))
+	}
+	for k, v := range want {
+		if got := aws.StringValue(o.queueAttributes[k]); got != v {
+			t.Errorf("attribute %s = %q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestWithMultipartCopy(t *testing.T) {
+	for _, c := range []struct {
+		threshold, partSize int64
+		wantThreshold       int64
+		wantPartSize        int64
+	}{
+		{4, 4, 4, 4},
+		{0, 0, 0, 512 << 20},
+		{-1, -4, 5 << 30, 512 << 20},
+	} {
+		o := newOptions([]Option{WithMultipartCopy(c.threshold, c.partSize)})
+		if o.multipartCopyThreshold != c.wantThreshold || o.copyPartSize != c.wantPartSize {
+			t.Errorf("WithMultipartCopy(%d, %d) set %d, %d, want %d, %d", c.threshold, c.partSize,
+				o.multipartCopyThreshold, o.copyPartSize, c.wantThreshold, c.wantPartSize)
+		}
+	}
+}
+
+func TestWithPathStyle(t *testing.T) {
+	cfg := fakeAWSConfig("http://localhost:1234", newOptions([]Option{WithPathStyle(false)}))
+	if aws.BoolValue(cfg.S3ForcePathStyle) {
+		t.Error("expected virtual-hosted addressing")
+	}
+}
+
+func TestFakeAWSConfigDefaultCredentials(t *testing.T) {
+	cfg := fakeAWSConfig("http://localhost:1234", newOptions(nil))
+	v, err := cfg.Credentials.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.AccessKeyID != fakeAccessKeyID {
+		t.Errorf("access key = %q, want %q", v.AccessKeyID, fakeAccessKeyID)
+	}
+}
+
+func TestWithCredentialsProvider(t *testing.T) {
+	p := &credentials.StaticProvider{Value: credentials.Value{
+		AccessKeyID:     "PROVIDED",
+		SecretAccessKey: "SECRET",
+	}}
+	cfg := fakeAWSConfig("http://localhost:1234", newOptions([]Option{WithCredentialsProvider(p)}))
+	v, err := cfg.Credentials.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.AccessKeyID != "PROVIDED" {
+		t.Errorf("access key = %q, want PROVIDED", v.AccessKeyID)
+	}
+}
@@ -0,0 +1,80 @@
+package testutil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ordered is the set of types whose values can be compared with <.
+type ordered interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64 | ~string
+}
+
+// integer is the set of integer types.
+type integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// maxReported is how many problems an assertion lists before
+// summarizing the rest.
+const maxReported = 10
+
+// AssertOrdered checks that msgs, for example the messages drained from
+// a FIFO queue, arrived in order of the key that key extracts from
+// each, such as a sequence number in the body. Equal keys may appear in
+// any order.
+func AssertOrdered[M any, K ordered](t TestingT, msgs []M, key func(M) K) {
+	t.Helper()
+	var problems []string
+	for i := 1; i < len(msgs); i++ {
+		prev, cur := key(msgs[i-1]), key(msgs[i])
+		if cur < prev {
+			problems = append(problems, fmt.Sprintf("message %d (key %v) came after message %d (key %v)", i, cur, i-1, prev))
+		}
+	}
+	if len(problems) > 0 {
+		t.Errorf("%d of %d messages out of order:\n  %s", len(problems), len(msgs), strings.Join(truncateList(problems), "\n  "))
+	}
+}
+
+// AssertNoGaps checks that seqs, in any order, cover every number from
+// the lowest to the highest. Duplicates, as at-least-once delivery
+// produces, are allowed.
+func AssertNoGaps[N integer](t TestingT, seqs []N) {
+	t.Helper()
+	if len(seqs) == 0 {
+		return
+	}
+	sorted := append([]N(nil), seqs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var gaps []string
+	missing := 0
+	for i := 1; i < len(sorted); i++ {
+		prev, cur := sorted[i-1], sorted[i]
+		if cur-prev <= 1 {
+			continue
+		}
+		missing += int(cur - prev - 1)
+		if cur-prev == 2 {
+			gaps = append(gaps, fmt.Sprint(prev+1))
+		} else {
+			gaps = append(gaps, fmt.Sprintf("%v-%v", prev+1, cur-1))
+		}
+	}
+	if len(gaps) > 0 {
+		t.Errorf("%d sequence numbers missing between %v and %v: %s",
+			missing, sorted[0], sorted[len(sorted)-1], strings.Join(truncateList(gaps), ", "))
+	}
+}
+
+func truncateList(items []string) []string {
+	if len(items) <= maxReported {
+		return items
+	}
+	return append(items[:maxReported:maxReported], fmt.Sprintf("and %d more", len(items)-maxReported))
+}
@@ -0,0 +1,46 @@
+package testutil
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestAssertOrdered(t *testing.T) {
+	msgs := []*sqs.Message{
+		{Body: aws.String("1")}, {Body: aws.String("2")}, {Body: aws.String("2")}, {Body: aws.String("4")},
+	}
+	seq := func(m *sqs.Message) int {
+		n, _ := strconv.Atoi(aws.StringValue(m.Body))
+		return n
+	}
+
+	rt := &recordingT{}
+	AssertOrdered(rt, msgs, seq)
+	if len(rt.errors) != 0 {
+		t.Errorf("ordered messages: %q", rt.errors)
+	}
+
+	msgs[1], msgs[3] = msgs[3], msgs[1]
+	AssertOrdered(rt, msgs, seq)
+	if len(rt.errors) != 1 || !strings.Contains(rt.errors[0], "message 2 (key 2) came after message 1 (key 4)") {
+		t.Errorf("out of order messages: %q", rt.errors)
+	}
+}
+
+func TestAssertNoGaps(t *testing.T) {
+	rt := &recordingT{}
+	AssertNoGaps(rt, []int64{3, 1, 2, 2, 4})
+	AssertNoGaps(rt, []int64(nil))
+	if len(rt.errors) != 0 {
+		t.Errorf("contiguous sequence: %q", rt.errors)
+	}
+
+	AssertNoGaps(rt, []uint{10, 1, 2, 4, 5})
+	if len(rt.errors) != 1 || !strings.HasSuffix(rt.errors[0], "5 sequence numbers missing between 1 and 10: 3, 6-9") {
+		t.Errorf("gaps: %q", rt.errors)
+	}
+}
@@ -0,0 +1,111 @@
+package testutil
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// errPartitioned is the cause of the errors of calls made during a
+// partition.
+var errPartitioned = errors.New("network is unreachable (testutil partition)")
+
+// NetworkPartition cuts the fakes it is attached to off from their
+// clients while it is in effect, so that queue backoff, circuit
+// breakers and other partition handling can be exercised. Attach it
+// with WithNetworkPartition; one NetworkPartition may be attached to
+// several fakes to cut them off together.
+//
+// During a partition, AWS requests fail with a retryable RequestError
+// as if the endpoint could not be reached, new redis connections can't
+// be dialed, and commands on open redis connections fail and close
+// them. Nothing reaches the fakes, so no state changes.
+type NetworkPartition struct {
+	mu      sync.Mutex
+	active  bool
+	heal    *time.Timer
+	refused int
+}
+
+// NewNetworkPartition returns a NetworkPartition that is not in effect.
+func NewNetworkPartition() *NetworkPartition {
+	return &NetworkPartition{}
+}
+
+// WithNetworkPartition attaches np to a fake. Requests the fake makes
+// while it is being set up always get through.
+func WithNetworkPartition(np *NetworkPartition) Option {
+	return func(o *options) {
+		// Partitioned requests never reach a Policy or Throttle.
+		o.sendHooks = append([]sendHook{np.sendHook}, o.sendHooks...)
+		o.partition = np
+	}
+}
+
+// Partition makes the fakes unreachable until Heal is called.
+func (np *NetworkPartition) Partition() {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+	np.stopTimer()
+	np.active = true
+}
+
+// PartitionFor makes the fakes unreachable for d, after which the
+// partition heals by itself.
+func (np *NetworkPartition) PartitionFor(d time.Duration) {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+	np.stopTimer()
+	np.active = true
+	np.heal = time.AfterFunc(d, np.Heal)
+}
+
+// Heal ends the partition.
+func (np *NetworkPartition) Heal() {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+	np.stopTimer()
+	np.active = false
+}
+
+func (np *NetworkPartition) stopTimer() {
+	if np.heal != nil {
+		np.heal.Stop()
+		np.heal = nil
+	}
+}
+
+// Partitioned reports whether the partition is in effect.
+func (np *NetworkPartition) Partitioned() bool {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+	return np.active
+}
+
+// Refused returns how many requests, dials and commands failed because
+// of the partition.
+func (np *NetworkPartition) Refused() int {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+	return np.refused
+}
+
+// refuse reports whether a call should fail, counting it if so.
+func (np *NetworkPartition) refuse() bool {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+	if np.active {
+		np.refused++
+	}
+	return np.active
+}
+
+func (np *NetworkPartition) sendHook(r *request.Request) *http.Response {
+	if np.refuse() {
+		dropRequest(r, errPartitioned)
+	}
+	return nil
+}
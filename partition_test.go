@@ -0,0 +1,70 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+func TestNetworkPartition(t *testing.T) {
+	np := NewNetworkPartition()
+	k := NewFakeKMS(WithNetworkPartition(np))
+	defer k.Close()
+	key := k.CreateKey("")
+
+	encrypt := func() error {
+		req, _ := k.Client.EncryptRequest(&kms.EncryptInput{KeyId: aws.String(key), Plaintext: []byte("x")})
+		req.Retryer = client.DefaultRetryer{NumMaxRetries: 1}
+		return req.Send()
+	}
+
+	np.Partition()
+	if !np.Partitioned() {
+		t.Error("Partitioned = false after Partition")
+	}
+	if code := errorCode(encrypt()); code != "RequestError" {
+		t.Errorf("Encrypt during partition failed with %q, want RequestError", code)
+	}
+	if got := np.Refused(); got != 2 {
+		t.Errorf("Refused = %d, want 2 for a request and its retry", got)
+	}
+
+	np.Heal()
+	if err := encrypt(); err != nil {
+		t.Errorf("Encrypt after Heal: %v", err)
+	}
+
+	np.PartitionFor(200 * time.Millisecond)
+	if encrypt() == nil {
+		t.Error("Encrypt succeeded during PartitionFor")
+	}
+	WaitFor(func() bool { return !np.Partitioned() }, func() {
+		t.Fatal("partition did not heal")
+	}, time.Second)
+	if err := encrypt(); err != nil {
+		t.Errorf("Encrypt after the partition healed: %v", err)
+	}
+}
+
+func TestNetworkPartitionRedis(t *testing.T) {
+	np := NewNetworkPartition()
+	r := newStubRedis(WithNetworkPartition(np))
+	defer r.Close()
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("GET", "a"); err != nil {
+		t.Errorf("GET before the partition: %v", err)
+	}
+	np.Partition()
+	if _, err := conn.Do("GET", "a"); err != errPartitioned {
+		t.Errorf("GET during the partition returned %v", err)
+	}
+	np.Heal()
+	if np.Refused() != 1 {
+		t.Errorf("Refused = %d, want 1", np.Refused())
+	}
+}
@@ -0,0 +1,112 @@
+package testutil
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// AnyPrincipal matches every caller in a Policy statement.
+const AnyPrincipal = "*"
+
+// Policy is a minimal stand-in for IAM that can be attached to the AWS
+// fakes with WithPolicy. Once attached, every request made through the
+// fake's clients is denied with an AccessDenied error unless a
+// statement allows it, which gives deterministic local coverage of
+// access-denied handling.
+//
+// Principals are access key IDs (see WithCredentials) or AnyPrincipal.
+// Actions are service-qualified operation names such as "s3:GetObject"
+// or "sqs:SendMessage", with "*" and "s3:*" style wildcards. Resources
+// are "bucket/key" for S3, the queue name for SQS and the key ID or
+// alias as given in the request for KMS, and statements match on
+// resource prefix. As in IAM, an explicit Deny overrides any
+// Allow.
+//
+// A Policy may be changed while it is in use.
+type Policy struct {
+	mu    sync.Mutex
+	allow []policyStatement
+	deny  []policyStatement
+}
+
+type policyStatement struct {
+	principal      string
+	action         string
+	resourcePrefix string
+}
+
+// NewPolicy returns a Policy that denies everything.
+func NewPolicy() *Policy {
+	return new(Policy)
+}
+
+// Allow permits principal to perform action on resources beginning
+// with resourcePrefix. It returns p to allow chaining.
+func (p *Policy) Allow(principal, action, resourcePrefix string) *Policy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.allow = append(p.allow, policyStatement{principal, action, resourcePrefix})
+	return p
+}
+
+// Deny forbids principal from performing action on resources beginning
+// with resourcePrefix, even if another statement allows it. It returns
+// p to allow chaining.
+func (p *Policy) Deny(principal, action, resourcePrefix string) *Policy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deny = append(p.deny, policyStatement{principal, action, resourcePrefix})
+	return p
+}
+
+// Allowed reports whether p permits principal to perform action on
+// resource.
+func (p *Policy) Allowed(principal, action, resource string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, st := range p.deny {
+		if st.matches(principal, action, resource) {
+			return false
+		}
+	}
+	for _, st := range p.allow {
+		if st.matches(principal, action, resource) {
+			return true
+		}
+	}
+	return false
+}
+
+func (st policyStatement) matches(principal, action, resource string) bool {
+	if st.principal != AnyPrincipal && st.principal != principal {
+		return false
+	}
+	if !strings.HasPrefix(resource, st.resourcePrefix) {
+		return false
+	}
+	return matchOperation(st.action, action)
+}
+
+// sendHook denies r unless p allows it. A request whose caller can't
+// be told, because it has no credentials or they can't be retrieved,
+// is denied.
+func (p *Policy) sendHook(r *request.Request) *http.Response {
+	if r.Config.Credentials != nil {
+		creds, err := r.Config.Credentials.Get()
+		if err == nil && p.Allowed(creds.AccessKeyID, operationName(r), resourceName(r)) {
+			return nil
+		}
+	}
+	return errorResponse(r, http.StatusForbidden, "AccessDenied", "Access Denied")
+}
+
+// WithPolicy attaches p to an AWS fake. Requests the fake makes while
+// it is being set up are not subject to the policy.
+func WithPolicy(p *Policy) Option {
+	return func(o *options) {
+		o.sendHooks = append(o.sendHooks, p.sendHook)
+	}
+}
@@ -0,0 +1,98 @@
+package testutil
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestPolicyAllowed(t *testing.T) {
+	p := NewPolicy().
+		Allow("reader", "s3:GetObject", "bucket/public/").
+		Allow("admin", "s3:*", "").
+		Allow(AnyPrincipal, "sqs:SendMessage", "events").
+		Deny("admin", "s3:DeleteObject", "bucket/locked/")
+
+	cases := []struct {
+		principal, action, resource string
+		want                        bool
+	}{
+		{"reader", "s3:GetObject", "bucket/public/a.txt", true},
+		{"reader", "s3:GetObject", "bucket/private/a.txt", false},
+		{"reader", "s3:PutObject", "bucket/public/a.txt", false},
+		{"admin", "s3:PutObject", "other/key", true},
+		{"admin", "s3:DeleteObject", "bucket/locked/key", false},
+		{"admin", "sqs:SendMessage", "jobs", false},
+		{"anyone", "sqs:SendMessage", "events-high", true},
+	}
+	for _, c := range cases {
+		if got := p.Allowed(c.principal, c.action, c.resource); got != c.want {
+			t.Errorf("Allowed(%q, %q, %q) = %v, want %v", c.principal, c.action, c.resource, got, c.want)
+		}
+	}
+}
+
+func TestPolicySendHook(t *testing.T) {
+	o := newOptions([]Option{
+		WithCredentials("reader", "secret"),
+		WithPolicy(NewPolicy().Allow("reader", "s3:GetObject", "bucket/public/")),
+	})
+	cfg := fakeAWSConfig("http://127.0.0.1:1", o).WithMaxRetries(0)
+	sess := session.New(cfg)
+	client := s3.New(sess)
+	addSendHooks(o.sendHooks, &client.Handlers)
+
+	_, err := client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("private/secret.txt"),
+	})
+	reqErr, ok := err.(awserr.RequestFailure)
+	if !ok {
+		t.Fatalf("expected a request failure, got %v", err)
+	}
+	if reqErr.Code() != "AccessDenied" || reqErr.StatusCode() != 403 {
+		t.Errorf("got %s (%d), want AccessDenied (403)", reqErr.Code(), reqErr.StatusCode())
+	}
+
+	// The allowed request is sent, and fails only because nothing is
+	// listening.
+	_, err = client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("public/index.html"),
+	})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() == "AccessDenied" {
+		t.Errorf("expected a connection error, got %v", err)
+	}
+}
+
+// failingProvider is a credentials provider that always fails.
+type failingProvider struct{}
+
+func (failingProvider) Retrieve() (credentials.Value, error) {
+	return credentials.Value{}, errors.New("no credentials here")
+}
+
+func (failingProvider) IsExpired() bool { return true }
+
+func TestPolicySendHookWithoutCredentials(t *testing.T) {
+	p := NewPolicy().Allow(AnyPrincipal, "s3:*", "")
+	for i, creds := range []*credentials.Credentials{
+		credentials.NewCredentials(failingProvider{}),
+		credentials.AnonymousCredentials,
+	} {
+		cfg := fakeAWSConfig("http://127.0.0.1:1", newOptions(nil)).WithMaxRetries(0).WithCredentials(creds)
+		req, _ := s3.New(session.New(cfg)).GetObjectRequest(&s3.GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("a.txt"),
+		})
+		resp := p.sendHook(req)
+		if resp == nil || resp.StatusCode != 403 {
+			t.Errorf("request %d, without usable credentials, let through: %v", i, resp)
+		}
+	}
+}
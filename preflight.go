@@ -0,0 +1,244 @@
+package testutil
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rainforestapp/testutil/redistest"
+)
+
+// Dependency is something a fake needs from the machine the tests run
+// on: an executable, a server listening on a port, or both.
+type Dependency struct {
+	// Name names the dependency in messages, for example "fake_sqs".
+	Name string
+
+	// Binary, if set, is an executable that must be in $PATH.
+	Binary string
+
+	// Addr, if set, is the host:port the dependency must be listening
+	// on.
+	Addr string
+
+	// Processes, if set, are the command names the process listening
+	// on Addr may have. Another process holding a local Addr is
+	// reported, with its PID. Processes that forward ports, such as
+	// docker-proxy, are always accepted.
+	Processes []string
+
+	// Hint says how to install or start the dependency.
+	Hint string
+}
+
+// The dependencies of the fakes that need one, at their default
+// addresses.
+var (
+	RedisDependency = Dependency{
+		Name:      "redis",
+		Addr:      "127.0.0.1:" + redistest.Port,
+		Processes: []string{"redis-server", "redis-stack-server", "keydb-server"},
+		Hint:      "start it with `redis-server`",
+	}
+	FakeSQSDependency = Dependency{
+		Name:      "fake_sqs",
+		Addr:      "127.0.0.1:4568",
+		Processes: []string{"fake_sqs", "ruby"},
+		Hint:      "install it with `gem install fake_sqs` and start it with `fake_sqs -p 4568`",
+	}
+	FakeS3Dependency = Dependency{
+		Name:      "fakes3",
+		Addr:      "127.0.0.1:4569",
+		Processes: []string{"fakes3", "ruby"},
+		Hint:      "install it with `gem install fakes3` and start it with `fakes3 -r $TMPDIR/fakes3 -p 4569`",
+	}
+	DynamoDBLocalDependency = Dependency{
+		Name:   "DynamoDB Local",
+		Binary: "java",
+		Hint:   "install a Java runtime",
+	}
+)
+
+// portForwarders are processes that hold ports on behalf of a server
+// running elsewhere, such as in a container.
+var portForwarders = []string{"docker-proxy", "com.docker.backend", "vpnkit", "rootlesskit", "rootlessport", "kubectl", "ssh", "gvproxy"}
+
+// PreflightError lists the problems Preflight found.
+type PreflightError struct {
+	Problems []string
+}
+
+func (e *PreflightError) Error() string {
+	return "preflight checks failed:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Preflight checks that deps are available, and returns a
+// *PreflightError describing every problem found, with what to do
+// about it, or nil. Call it from TestMain to fail fast; the fakes also
+// run it to explain a failure to connect.
+func Preflight(deps ...Dependency) error {
+	var problems []string
+	for _, d := range deps {
+		problems = append(problems, d.check()...)
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &PreflightError{Problems: problems}
+}
+
+// preflightHint returns the problems Preflight finds with dep, on a
+// new line, or "" if there are none.
+func preflightHint(dep Dependency) string {
+	if err := Preflight(dep); err != nil {
+		return "\n" + err.Error()
+	}
+	return ""
+}
+
+// missing reports whether a fake with no endpoint should do without d
+// and serve in process instead: d's executable, named by d.Name, isn't
+// in $PATH and, unless the fake was to launch it, nothing is listening
+// on d.Addr either, so it can't be run elsewhere.
+func (d Dependency) missing(launch bool) bool {
+	if _, err := exec.LookPath(d.Name); err == nil {
+		return false
+	}
+	if launch {
+		return true
+	}
+	c, err := net.DialTimeout("tcp", d.Addr, time.Second)
+	if err != nil {
+		return true
+	}
+	c.Close()
+	return false
+}
+
+func (d Dependency) check() []string {
+	var problems []string
+	if d.Binary != "" {
+		if _, err := exec.LookPath(d.Binary); err != nil {
+			problems = append(problems, fmt.Sprintf("%s needs %s, which is not in $PATH: %s", d.Name, d.Binary, d.Hint))
+		}
+	}
+	if d.Addr == "" {
+		return problems
+	}
+	host, port, err := net.SplitHostPort(d.Addr)
+	if err != nil {
+		return append(problems, fmt.Sprintf("%s: invalid address %q", d.Name, d.Addr))
+	}
+	c, err := net.DialTimeout("tcp", d.Addr, time.Second)
+	if err != nil {
+		return append(problems, fmt.Sprintf("nothing is listening on port %s for %s: %s, or point the fake elsewhere with WithEndpoint", port, d.Name, d.Hint))
+	}
+	c.Close()
+	if len(d.Processes) == 0 || !isLoopback(host) {
+		return problems
+	}
+	n, _ := strconv.Atoi(port)
+	pid, command := portOwner(n)
+	if command == "" || matchesCommand(command, d.Processes) || matchesCommand(command, portForwarders) {
+		return problems
+	}
+	return append(problems, fmt.Sprintf("port %s is held by PID %d: %s, not %s; kill it or point the fake elsewhere with WithEndpoint", port, pid, command, d.Name))
+}
+
+func matchesCommand(command string, names []string) bool {
+	base := filepath.Base(strings.Fields(command + " ")[0])
+	for _, name := range names {
+		if base == name || strings.Contains(command, "/"+name+" ") || strings.HasSuffix(command, "/"+name) {
+			return true
+		}
+	}
+	return false
+}
+
+func isLoopback(host string) bool {
+	if host == "" || host == "localhost" || host == "0.0.0.0" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
+}
+
+// endpointDependency returns dep at endpoint's address.
+func endpointDependency(dep Dependency, endpoint string) Dependency {
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		dep.Addr = u.Host
+		if host, port, err := net.SplitHostPort(u.Host); err == nil && isLoopback(host) {
+			dep.Addr = "127.0.0.1:" + port
+		}
+	}
+	return dep
+}
+
+// portOwner returns the PID and command line of the process listening
+// on TCP port, if it can be found: from /proc on Linux, or with lsof
+// elsewhere.
+func portOwner(port int) (int, string) {
+	if pid := procPortOwner(port); pid > 0 {
+		cmdline, _ := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+		return pid, strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+	}
+	out, err := exec.Command("lsof", "-nP", "-iTCP:"+strconv.Itoa(port), "-sTCP:LISTEN", "-Fpc").Output()
+	if err != nil {
+		return 0, ""
+	}
+	var pid int
+	var command string
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "p") && pid == 0:
+			pid, _ = strconv.Atoi(line[1:])
+		case strings.HasPrefix(line, "c") && command == "":
+			command = line[1:]
+		}
+	}
+	return pid, command
+}
+
+// procPortOwner finds the process listening on port from the socket
+// tables in /proc.
+func procPortOwner(port int) int {
+	inodes := make(map[string]bool)
+	for _, table := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		f, err := os.Open(table)
+		if err != nil {
+			continue
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			fields := strings.Fields(sc.Text())
+			// sl local_address rem_address st ... inode
+			if len(fields) < 10 || fields[3] != "0A" {
+				continue
+			}
+			i := strings.LastIndex(fields[1], ":")
+			if p, err := strconv.ParseInt(fields[1][i+1:], 16, 32); err == nil && int(p) == port {
+				inodes["socket:["+fields[9]+"]"] = true
+			}
+		}
+		f.Close()
+	}
+	if len(inodes) == 0 {
+		return 0
+	}
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fd := range fds {
+		if target, err := os.Readlink(fd); err == nil && inodes[target] {
+			pid, _ := strconv.Atoi(strings.Split(fd, "/")[2])
+			return pid
+		}
+	}
+	return 0
+}
@@ -0,0 +1,53 @@
+package testutil
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPreflight(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	held := l.Addr().String()
+	_, port, _ := net.SplitHostPort(held)
+	free, _ := FreePort()
+
+	if err := Preflight(Dependency{Name: "anything", Addr: held}); err != nil {
+		t.Errorf("port held by any process: %v", err)
+	}
+
+	err = Preflight(
+		Dependency{Name: "fake_sqs", Addr: held, Processes: []string{"fake_sqs"}},
+		Dependency{Name: "fakes3", Addr: fmt.Sprintf("127.0.0.1:%d", free), Hint: "start fakes3"},
+		Dependency{Name: "thing", Binary: "no-such-binary-testutil", Hint: "install thing"},
+	)
+	perr, ok := err.(*PreflightError)
+	if !ok || len(perr.Problems) != 3 {
+		t.Fatalf("Preflight = %v, want 3 problems", err)
+	}
+	if want := fmt.Sprintf("port %s is held by PID %d: ", port, os.Getpid()); !strings.HasPrefix(perr.Problems[0], want) ||
+		!strings.HasSuffix(perr.Problems[0], "not fake_sqs; kill it or point the fake elsewhere with WithEndpoint") {
+		t.Errorf("held port: %q", perr.Problems[0])
+	}
+	if want := fmt.Sprintf("nothing is listening on port %d for fakes3: start fakes3", free); !strings.HasPrefix(perr.Problems[1], want) {
+		t.Errorf("free port: %q", perr.Problems[1])
+	}
+	if want := "thing needs no-such-binary-testutil, which is not in $PATH: install thing"; perr.Problems[2] != want {
+		t.Errorf("missing binary: %q", perr.Problems[2])
+	}
+}
+
+func TestEndpointDependency(t *testing.T) {
+	if d := endpointDependency(FakeSQSDependency, "http://0.0.0.0:9324"); d.Addr != "127.0.0.1:9324" {
+		t.Errorf("Addr = %q", d.Addr)
+	}
+	if d := endpointDependency(FakeSQSDependency, "http://sqs.internal:4568"); d.Addr != "sqs.internal:4568" {
+		t.Errorf("Addr = %q", d.Addr)
+	}
+}
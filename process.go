@@ -0,0 +1,213 @@
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/rainforestapp/testutil/waitfor"
+)
+
+// process is a child process run by a fake, such as DynamoDB Local.
+// Its combined output is kept so that a failure to start can be
+// reported with whatever the process printed.
+type process struct {
+	name string
+	cmd  *exec.Cmd
+
+	// dir, if set, is a temporary directory removed when the process
+	// is stopped.
+	dir string
+
+	out    tailBuffer
+	exited chan struct{}
+	err    error
+}
+
+// startProcess starts cmd. name is used in error messages. If logs
+// isn't nil, each line the process prints is also logged to it.
+func startProcess(name string, cmd *exec.Cmd, logs Logger) (*process, error) {
+	p := &process{name: name, cmd: cmd, exited: make(chan struct{})}
+	var out io.Writer = &p.out
+	if logs != nil {
+		out = io.MultiWriter(&p.out, &lineLogger{prefix: name + ": ", logger: logs})
+	}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start %s: %v", name, err)
+	}
+	// Wait returns only once the process's output has been copied, so
+	// nothing is logged after exited is closed, and so after stop.
+	go func() {
+		p.err = cmd.Wait()
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+// waitReady calls ready every interval until it returns true, ctx is
+// done or the process exits.
+func (p *process) waitReady(ctx context.Context, ready func() bool, interval time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-p.exited:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	err := waitfor.Context(ctx, ready, interval)
+	select {
+	case <-p.exited:
+		return fmt.Errorf("%s exited before it was ready (%v); output:\n%s", p.name, p.err, p.out.String())
+	default:
+	}
+	if err != nil {
+		return fmt.Errorf("%s was not ready: %v; output:\n%s", p.name, err, p.out.String())
+	}
+	return nil
+}
+
+// stop interrupts the process, kills it if it hasn't exited after a
+// few seconds, and removes its directory.
+func (p *process) stop() {
+	select {
+	case <-p.exited:
+	default:
+		interruptProcess(p.cmd.Process)
+		select {
+		case <-p.exited:
+		case <-time.After(5 * time.Second):
+			p.cmd.Process.Kill()
+			<-p.exited
+		}
+	}
+	if p.dir != "" {
+		os.RemoveAll(p.dir)
+	}
+}
+
+// processLogger returns the Logger child processes' output goes to
+// under WithProcessLogs, or nil.
+func (o *options) processLogger() Logger {
+	if o.processLogs {
+		return o.logger
+	}
+	return nil
+}
+
+// launchFake starts the fake whose executable is dep.Name on a free
+// port, with a new temporary directory for its data, and returns it
+// with its endpoint. args returns the fake's arguments for the port
+// and directory.
+func launchFake(dep Dependency, args func(port int, dir string) []string, logs Logger) (*process, string, error) {
+	bin, err := exec.LookPath(dep.Name)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not launch %s: %v; %s", dep.Name, err, dep.Hint)
+	}
+	port, err := FreePort()
+	if err != nil {
+		return nil, "", err
+	}
+	dir, err := ioutil.TempDir("", dep.Name)
+	if err != nil {
+		return nil, "", err
+	}
+	p, err := startProcess(dep.Name, exec.Command(bin, args(port, dir)...), logs)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, "", err
+	}
+	p.dir = dir
+	return p, fmt.Sprintf("http://127.0.0.1:%d", port), nil
+}
+
+// allocatedPorts are the ports FreePort has returned.
+var allocatedPorts = struct {
+	sync.Mutex
+	m map[int]bool
+}{m: make(map[int]bool)}
+
+// FreePort returns a TCP port that was free on the loopback interface
+// when it was checked, for a server a test is about to start. The
+// kernel picks it at random from the ephemeral range, so test binaries
+// run in parallel rarely collide, and FreePort never returns the same
+// port twice in one process, so fakes started concurrently don't
+// either.
+func FreePort() (int, error) {
+	for i := 0; i < 100; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return 0, err
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+
+		allocatedPorts.Lock()
+		taken := allocatedPorts.m[port]
+		allocatedPorts.m[port] = true
+		allocatedPorts.Unlock()
+		if !taken {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free port found")
+}
+
+// lineLogger is an io.Writer that logs each complete line written to
+// it.
+type lineLogger struct {
+	prefix string
+	logger Logger
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.logger.Logf("%s%s", l.prefix, bytes.TrimRight(l.partial[:i], "\r"))
+		l.partial = l.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// tailBuffer is an io.Writer that keeps the last 8KB written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+const tailBufferSize = 8 << 10
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if n := len(b.buf) - tailBufferSize; n > 0 {
+		b.buf = append(b.buf[:0], b.buf[n:]...)
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
@@ -0,0 +1,96 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helperProcess returns a command running this test binary as a child
+// that prints output and then exits with code, or sleeps if code is
+// negative.
+func helperProcess(output string, code int) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(),
+		"TESTUTIL_HELPER_PROCESS=1",
+		"TESTUTIL_HELPER_OUTPUT="+output,
+		fmt.Sprintf("TESTUTIL_HELPER_EXIT=%d", code))
+	return cmd
+}
+
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("TESTUTIL_HELPER_PROCESS") != "1" {
+		return
+	}
+	fmt.Println(os.Getenv("TESTUTIL_HELPER_OUTPUT"))
+	var code int
+	fmt.Sscan(os.Getenv("TESTUTIL_HELPER_EXIT"), &code)
+	if code < 0 {
+		time.Sleep(time.Minute)
+	}
+	os.Exit(code)
+}
+
+func TestProcessStop(t *testing.T) {
+	p, err := startProcess("helper", helperProcess("listening", -1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.dir = t.TempDir()
+
+	ready := func() bool { return strings.Contains(p.out.String(), "listening") }
+	if err := p.waitReady(context.Background(), ready, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	p.stop()
+	select {
+	case <-p.exited:
+	default:
+		t.Error("process still running after stop")
+	}
+	if _, err := os.Stat(p.dir); !os.IsNotExist(err) {
+		t.Errorf("process directory not removed: %v", err)
+	}
+}
+
+func TestProcessExitsBeforeReady(t *testing.T) {
+	p, err := startProcess("helper", helperProcess("address already in use", 3), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = p.waitReady(ctx, func() bool { return false }, 10*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "exited before it was ready") || !strings.Contains(err.Error(), "address already in use") {
+		t.Errorf("expected an exit error with the process output, got %v", err)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	var b tailBuffer
+	b.Write([]byte(strings.Repeat("x", tailBufferSize)))
+	b.Write([]byte("end"))
+	if s := b.String(); len(s) != tailBufferSize || !strings.HasSuffix(s, "xend") {
+		t.Errorf("kept %d bytes ending %q", len(s), s[len(s)-4:])
+	}
+}
+
+func TestFreePort(t *testing.T) {
+	seen := map[int]bool{}
+	for i := 0; i < 20; i++ {
+		port, err := FreePort()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[port] {
+			t.Fatalf("FreePort returned %d twice", port)
+		}
+		seen[port] = true
+	}
+}
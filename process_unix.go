@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package testutil
+
+import "os"
+
+// interruptProcess asks p to exit.
+func interruptProcess(p *os.Process) error {
+	return p.Signal(os.Interrupt)
+}
@@ -0,0 +1,114 @@
+//go:build !windows
+// +build !windows
+
+package testutil
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestHelperFake stands in for fakes3 and fake_sqs when run by the
+// scripts installFakeBinaries writes, serving memS3 or memSQS on the
+// port given with -p.
+func TestHelperFake(t *testing.T) {
+	kind := os.Getenv("TESTUTIL_HELPER_FAKE")
+	if kind == "" {
+		return
+	}
+	args := flag.Args()
+	var port, dir string
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-p":
+			port = args[i+1]
+		case "-r":
+			dir = args[i+1]
+		case "--database":
+			dir = filepath.Dir(args[i+1])
+		}
+	}
+	var h http.Handler = &memSQS{}
+	if kind == "fakes3" {
+		h = &memS3{objects: make(map[string]string)}
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s listening, data in %s\n", kind, dir)
+	http.Serve(l, h)
+}
+
+// installFakeBinaries puts fakes3 and fake_sqs scripts that run
+// TestHelperFake first in the $PATH.
+func installFakeBinaries(t *testing.T) {
+	bin := t.TempDir()
+	for _, name := range []string{"fakes3", "fake_sqs"} {
+		script := fmt.Sprintf("#!/bin/sh\nTESTUTIL_HELPER_FAKE=%s exec %q -test.run='^TestHelperFake$' -- \"$@\"\n", name, os.Args[0])
+		if err := ioutil.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestLaunch(t *testing.T) {
+	installFakeBinaries(t)
+	logger := &logLogger{}
+	opts := []Option{WithLaunch(), WithProcessLogs(), WithLogger(logger)}
+
+	s := NewFakeS3("uploads", opts...)
+	if err := s.PutString("uploads", "a.txt", "A"); err != nil {
+		t.Fatal(err)
+	}
+	q := NewFakeSQS("jobs", opts...)
+	if _, err := q.SendMessage("hello", nil); err != nil {
+		t.Fatal(err)
+	}
+	if q.Config.Endpoint == s.Config.Endpoint || strings.HasSuffix(*s.Config.Endpoint, ":4569") {
+		t.Errorf("fakes not launched on their own free ports: %s, %s", *s.Config.Endpoint, *q.Config.Endpoint)
+	}
+	dirs := []string{s.proc.dir, q.proc.dir}
+	s.Close()
+	q.Close()
+
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("data directory %s not removed: %v", dir, err)
+		}
+	}
+	logs := strings.Join(logger.lines(), "\n")
+	if !strings.Contains(logs, "fakes3: fakes3 listening, data in "+dirs[0]) || !strings.Contains(logs, "fake_sqs: fake_sqs listening") {
+		t.Errorf("process output not logged; got:\n%s", logs)
+	}
+}
+
+func TestLaunchNotInstalled(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	s3, err := StartFakeS3(context.Background(), "uploads", WithLaunch(), WithLogger(t))
+	if err != nil {
+		t.Fatalf("StartFakeS3 without fakes3 = %v", err)
+	}
+	defer s3.Close()
+	if s3.srv == nil || s3.proc != nil {
+		t.Errorf("StartFakeS3 without fakes3 didn't serve S3 in process")
+	}
+	sqs, err := StartFakeSQS(context.Background(), "jobs", WithLaunch(), WithLogger(t))
+	if err != nil {
+		t.Fatalf("StartFakeSQS without fake_sqs = %v", err)
+	}
+	defer sqs.Close()
+	if sqs.srv == nil || sqs.proc != nil {
+		t.Errorf("StartFakeSQS without fake_sqs didn't serve SQS in process")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package testutil
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// interruptProcess asks p to exit. Windows can't send another process
+// an interrupt, so this ends p's process tree with taskkill, which also
+// stops the interpreters .bat shims start.
+func interruptProcess(p *os.Process) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(p.Pid)).Run()
+}
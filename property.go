@@ -0,0 +1,194 @@
+package testutil
+
+import (
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Gen generates random values of type T for RunProperty.
+type Gen[T any] func(rnd *rand.Rand) T
+
+// Resetter is a fake that can be emptied between property cases.
+// FakeRedis, FakeS3 and FakeSQS are Resetters.
+type Resetter interface {
+	Reset()
+}
+
+// defaultPropertyCases is how many cases RunProperty tries unless
+// $TESTUTIL_PROPERTY_CASES says otherwise.
+const defaultPropertyCases = 100
+
+// RunProperty checks prop against values from gen, resetting each of
+// fakes before every case. It stops at the first case prop returns an
+// error for and reports it with the input and the seed, which can be
+// set in $TESTUTIL_PROPERTY_SEED to replay the run. The number of cases
+// defaults to 100 and can be set in $TESTUTIL_PROPERTY_CASES.
+func RunProperty[T any](t TestingT, gen Gen[T], prop func(T) error, fakes ...Resetter) {
+	t.Helper()
+	seed, _ := strconv.ParseInt(os.Getenv("TESTUTIL_PROPERTY_SEED"), 10, 64)
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	cases, _ := strconv.Atoi(os.Getenv("TESTUTIL_PROPERTY_CASES"))
+	if cases <= 0 {
+		cases = defaultPropertyCases
+	}
+
+	rnd := rand.New(rand.NewSource(seed))
+	for i := 1; i <= cases; i++ {
+		for _, f := range fakes {
+			f.Reset()
+		}
+		in := gen(rnd)
+		if err := prop(in); err != nil {
+			t.Errorf("property failed on case %d of %d (TESTUTIL_PROPERTY_SEED=%d): %v\ninput: %#v",
+				i, cases, seed, err, in)
+			return
+		}
+	}
+}
+
+const (
+	lowerAlnum = "abcdefghijklmnopqrstuvwxyz0123456789"
+	alnum      = lowerAlnum + "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+func randomString(rnd *rand.Rand, chars string, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = chars[rnd.Intn(len(chars))]
+	}
+	return string(b)
+}
+
+// GenBucketName generates valid S3 bucket names: 3 to 63 lowercase
+// letters, digits, hyphens and dots, starting and ending with a letter
+// or digit, with a letter or digit on both sides of every dot, and
+// never shaped like an IP address or using a reserved prefix.
+func GenBucketName() Gen[string] {
+	return func(rnd *rand.Rand) string {
+		n := 3 + rnd.Intn(61)
+		b := []byte(randomString(rnd, lowerAlnum, n))
+		for i := 1; i < n-2; i++ {
+			switch rnd.Intn(8) {
+			case 0:
+				if b[i-1] != '.' {
+					b[i] = '-'
+				}
+			case 1:
+				if b[i-1] != '.' && b[i-1] != '-' {
+					b[i] = '.'
+				}
+			}
+		}
+		// A letter first rules out IP addresses.
+		b[0] = lowerAlnum[rnd.Intn(26)]
+		name := string(b)
+		if strings.HasPrefix(name, "xn--") || strings.HasPrefix(name, "sthree-") {
+			name = "a" + name[1:]
+		}
+		return name
+	}
+}
+
+// GenQueueName generates valid SQS queue names: 1 to 80 letters,
+// digits, hyphens and underscores.
+func GenQueueName() Gen[string] {
+	return func(rnd *rand.Rand) string {
+		return randomString(rnd, alnum+"-_", 1+rnd.Intn(80))
+	}
+}
+
+// keyRunes are the characters GenObjectKey draws from: the ones S3
+// documents as safe, plus some that need escaping and some non-ASCII.
+var keyRunes = []rune(alnum + "!-_.*'() &$@=;:+,?" + "éß日本語\U0001F600")
+
+// GenObjectKey generates valid S3 object keys of up to 1024 bytes,
+// made of path segments.
+func GenObjectKey() Gen[string] {
+	return func(rnd *rand.Rand) string {
+		parts := make([]string, 1+rnd.Intn(4))
+		for i := range parts {
+			r := make([]rune, 1+rnd.Intn(20))
+			for j := range r {
+				r[j] = keyRunes[rnd.Intn(len(keyRunes))]
+			}
+			parts[i] = string(r)
+		}
+		key := strings.Join(parts, "/")
+		for len(key) > maxS3KeyLength {
+			key = string([]rune(key)[:len([]rune(key))-1])
+		}
+		return key
+	}
+}
+
+// GenMessageBatch generates between 1 and max message bodies, as many
+// as SendMessageBatch takes when max is 10. Bodies are 1 to 1024
+// characters SQS accepts.
+func GenMessageBatch(max int) Gen[[]string] {
+	return func(rnd *rand.Rand) []string {
+		bodies := make([]string, 1+rnd.Intn(max))
+		for i := range bodies {
+			r := make([]rune, 1+rnd.Intn(1024))
+			for j := range r {
+				r[j] = keyRunes[rnd.Intn(len(keyRunes))]
+				if rnd.Intn(16) == 0 {
+					r[j] = []rune{'\t', '\n', '\r', ' ', '{', '}', '"'}[rnd.Intn(7)]
+				}
+			}
+			bodies[i] = string(r)
+		}
+		return bodies
+	}
+}
+
+// GenObjectTree generates up to max objects, as a map from key to
+// content, whose keys share prefixes the way directory trees do.
+func GenObjectTree(max int) Gen[map[string]string] {
+	key := GenObjectKey()
+	return func(rnd *rand.Rand) map[string]string {
+		dirs := []string{""}
+		tree := make(map[string]string)
+		for n := rnd.Intn(max + 1); len(tree) < n; {
+			dir := dirs[rnd.Intn(len(dirs))]
+			k := dir + key(rnd)
+			if len(k) > maxS3KeyLength {
+				continue
+			}
+			tree[k] = randomString(rnd, alnum, rnd.Intn(256))
+			if i := strings.LastIndex(k, "/"); i > 0 {
+				dirs = append(dirs, k[:i+1])
+			}
+		}
+		return tree
+	}
+}
+
+// Reset deletes every object in the fake's bucket.
+func (s *FakeS3) Reset() {
+	keys, err := s.listKeys(s.bucket, "")
+	if err != nil {
+		s.logger.Errorf("Error resetting bucket %s: %v", s.bucket, err)
+		return
+	}
+	for _, key := range keys {
+		key := key
+		if _, err := s.Client.DeleteObject(&s3.DeleteObjectInput{Bucket: &s.bucket, Key: &key}); err != nil {
+			s.logger.Errorf("Error resetting bucket %s: %v", s.bucket, err)
+			return
+		}
+	}
+}
+
+// Reset deletes every message in the queue, including those in flight.
+func (s *FakeSQS) Reset() {
+	if err := s.PurgeQueue(); err != nil {
+		s.logger.Errorf("Error resetting queue %s: %v", s.URL, err)
+	}
+}
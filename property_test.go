@@ -0,0 +1,76 @@
+package testutil
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+type countingResetter int
+
+func (c *countingResetter) Reset() { *c++ }
+
+func TestRunProperty(t *testing.T) {
+	t.Setenv("TESTUTIL_PROPERTY_SEED", "99")
+	t.Setenv("TESTUTIL_PROPERTY_CASES", "50")
+
+	var resets countingResetter
+	var seen []string
+	RunProperty(t, GenQueueName(), func(name string) error {
+		seen = append(seen, name)
+		return nil
+	}, &resets)
+	if resets != 50 || len(seen) != 50 {
+		t.Errorf("%d resets and %d cases, want 50", resets, len(seen))
+	}
+
+	rt := &recordingT{}
+	var replay []string
+	RunProperty(rt, GenQueueName(), func(name string) error {
+		replay = append(replay, name)
+		if len(replay) == 3 {
+			return errors.New("too many queues")
+		}
+		return nil
+	})
+	if len(rt.errors) != 1 || !strings.HasPrefix(rt.errors[0], "property failed on case 3 of 50 (TESTUTIL_PROPERTY_SEED=99): too many queues") ||
+		!strings.Contains(rt.errors[0], seen[2]) {
+		t.Errorf("errors = %q", rt.errors)
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	bucket := regexp.MustCompile(`^[a-z][a-z0-9.-]{1,61}[a-z0-9]$`)
+	queue := regexp.MustCompile(`^[A-Za-z0-9_-]{1,80}$`)
+	RunProperty(t, GenBucketName(), func(name string) error {
+		if !bucket.MatchString(name) || strings.Contains(name, "..") || strings.Contains(name, ".-") || strings.Contains(name, "-.") {
+			return errors.New("invalid bucket name")
+		}
+		return nil
+	})
+	RunProperty(t, GenQueueName(), func(name string) error {
+		if !queue.MatchString(name) {
+			return errors.New("invalid queue name")
+		}
+		return nil
+	})
+	RunProperty(t, GenMessageBatch(10), func(batch []string) error {
+		if len(batch) < 1 || len(batch) > 10 {
+			return errors.New("batch size out of range")
+		}
+		return nil
+	})
+	RunProperty(t, GenObjectTree(20), func(tree map[string]string) error {
+		if len(tree) > 20 {
+			return errors.New("too many objects")
+		}
+		for k := range tree {
+			if k == "" || len(k) > maxS3KeyLength || !utf8.ValidString(k) {
+				return errors.New("invalid key " + k)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,387 @@
+package testutil
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// MessageQueue is what a consumer needs from a queue. FakeSQS and
+// MemoryQueue both implement it, so tests written against a
+// MemoryQueue can graduate to a FakeSQS unchanged.
+type MessageQueue interface {
+	// SendMessage sends body with the given string message attributes.
+	SendMessage(body string, attrs map[string]string) (*sqs.SendMessageOutput, error)
+
+	// ReceiveMessagesContext receives up to max messages, waiting up to
+	// wait for them to arrive, with every attribute set.
+	ReceiveMessagesContext(ctx context.Context, max int64, wait time.Duration) ([]*sqs.Message, error)
+
+	// DeleteMessage deletes a received message.
+	DeleteMessage(receiptHandle string) error
+
+	// ChangeMessageVisibility makes a received message visible again
+	// after visibility.
+	ChangeMessageVisibility(receiptHandle string, visibility time.Duration) error
+
+	// String names the queue in failure messages.
+	String() string
+}
+
+// queueLogger returns the Logger q reports problems to.
+func queueLogger(q MessageQueue) Logger {
+	switch q := q.(type) {
+	case *FakeSQS:
+		return q.logger
+	case *MemoryQueue:
+		return q.logger
+	}
+	return stdLogger{}
+}
+
+// defaultVisibilityTimeout is SQS's default visibility timeout.
+const defaultVisibilityTimeout = 30 * time.Second
+
+// MemoryQueue is an in-memory MessageQueue for fast unit tests, with no
+// network or server involved. It follows SQS semantics: received
+// messages are hidden for the visibility timeout and redelivered if
+// not deleted, receives are counted, and with SetDeadLetterQueue
+// messages received too often are moved to a dead-letter queue.
+// Messages carry the same system attributes as FakeSQS's, so
+// AssertSystemAttribute and the other assertions work on both.
+//
+// Delivery is FIFO among visible messages, which real SQS doesn't
+// promise for standard queues. Queues created through a MemorySQS
+// with the FifoQueue attribute also keep SQS's FIFO semantics: a
+// message group with a message in flight delivers nothing else until
+// it is deleted or visible again, and messages with a deduplication ID
+// already seen within five minutes are dropped.
+type MemoryQueue struct {
+	// Name is the queue's name.
+	Name string
+
+	logger     Logger
+	clock      Clock
+	visibility time.Duration
+
+	mu              sync.Mutex
+	messages        []*memoryMessage
+	nextID          int
+	deadLetter      *MemoryQueue
+	maxReceiveCount int
+
+	// fifo is set for FIFO queues, which deliver each message group
+	// in order and drop messages sent again within the deduplication
+	// interval.
+	fifo         bool
+	contentDedup bool
+	deduplicated map[string]*memoryMessage
+}
+
+type memoryMessage struct {
+	id           string
+	body         string
+	attrs        map[string]*sqs.MessageAttributeValue
+	sent         time.Time
+	firstReceive time.Time
+	receives     int
+	visibleAt    time.Time
+	handle       string
+
+	// group, dedupID and sequence are set for messages sent to FIFO
+	// queues.
+	group    string
+	dedupID  string
+	sequence string
+}
+
+// errMissingMessageGroup is the error for a message sent to a FIFO
+// queue without a message group.
+var errMissingMessageGroup = awserr.NewRequestFailure(awserr.New("MissingParameter", "The request must contain the parameter MessageGroupId.", nil), 400, "")
+
+// fifoDeduplicationInterval is how long a FIFO queue remembers
+// deduplication IDs.
+const fifoDeduplicationInterval = 5 * time.Minute
+
+// NewMemoryQueue returns an empty MemoryQueue called name. Its
+// visibility timeout is 30 seconds unless set by WithQueueAttributes,
+// and it reads the time from the Clock given by WithClock, so a
+// FakeClock can make messages reappear without waiting.
+func NewMemoryQueue(name string, opts ...Option) *MemoryQueue {
+	o := newOptions(opts)
+	q := &MemoryQueue{
+		Name:       name,
+		logger:     o.logger,
+		clock:      o.clock,
+		visibility: defaultVisibilityTimeout,
+	}
+	if v, ok := o.queueAttributes["VisibilityTimeout"]; ok {
+		secs, err := strconv.Atoi(aws.StringValue(v))
+		if err != nil {
+			q.logger.Fatalf("MemoryQueue %s: invalid VisibilityTimeout %q", name, aws.StringValue(v))
+		}
+		q.visibility = time.Duration(secs) * time.Second
+	}
+	return q
+}
+
+// SetDeadLetterQueue makes the queue move messages that have been
+// received maxReceiveCount times to dlq, the next time they would be
+// delivered, as an SQS redrive policy does.
+func (q *MemoryQueue) SetDeadLetterQueue(dlq *MemoryQueue, maxReceiveCount int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.deadLetter, q.maxReceiveCount = dlq, maxReceiveCount
+}
+
+// String returns the queue's name.
+func (q *MemoryQueue) String() string {
+	return q.Name
+}
+
+// SendMessage adds body to the queue with the given string message
+// attributes.
+func (q *MemoryQueue) SendMessage(body string, attrs map[string]string) (*sqs.SendMessageOutput, error) {
+	m := q.send(body, StringAttributes(attrs))
+	return &sqs.SendMessageOutput{
+		MessageId:        aws.String(m.id),
+		MD5OfMessageBody: aws.String(md5Hex(body)),
+	}, nil
+}
+
+func (q *MemoryQueue) send(body string, attrs map[string]*sqs.MessageAttributeValue) *memoryMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.add(&memoryMessage{body: body, attrs: attrs})
+}
+
+// sendFIFO sends body to a FIFO queue in message group group. If a
+// message with the same deduplication ID was sent within the
+// deduplication interval, it returns that message instead. With
+// content-based deduplication, dedupID defaults to a hash of body.
+func (q *MemoryQueue) sendFIFO(body string, attrs map[string]*sqs.MessageAttributeValue, group, dedupID string) (*memoryMessage, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if group == "" {
+		return nil, errMissingMessageGroup
+	}
+	if dedupID == "" && q.contentDedup {
+		sum := sha256.Sum256([]byte(body))
+		dedupID = hex.EncodeToString(sum[:])
+	}
+	if dedupID == "" {
+		return nil, invalidParameter("The queue should either have ContentBasedDeduplication enabled or MessageDeduplicationId provided explicitly")
+	}
+	if prev := q.deduplicated[dedupID]; prev != nil && q.clock.Now().Sub(prev.sent) < fifoDeduplicationInterval {
+		return prev, nil
+	}
+	m := q.add(&memoryMessage{body: body, attrs: attrs, group: group, dedupID: dedupID})
+	m.sequence = fmt.Sprintf("%020d", q.nextID)
+	if q.deduplicated == nil {
+		q.deduplicated = make(map[string]*memoryMessage)
+	}
+	q.deduplicated[dedupID] = m
+	return m, nil
+}
+
+// add gives m an ID and appends it to the queue. q.mu must be held.
+func (q *MemoryQueue) add(m *memoryMessage) *memoryMessage {
+	q.nextID++
+	m.id = fmt.Sprintf("%s-%08d", q.Name, q.nextID)
+	m.sent = q.clock.Now()
+	q.messages = append(q.messages, m)
+	return m
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// ReceiveMessages receives up to max messages, waiting up to wait for
+// them to arrive.
+func (q *MemoryQueue) ReceiveMessages(max int64, wait time.Duration) ([]*sqs.Message, error) {
+	return q.ReceiveMessagesContext(context.Background(), max, wait)
+}
+
+// ReceiveMessagesContext is like ReceiveMessages, but gives up waiting
+// as soon as ctx is done, returning no messages.
+func (q *MemoryQueue) ReceiveMessagesContext(ctx context.Context, max int64, wait time.Duration) ([]*sqs.Message, error) {
+	return q.receiveWait(ctx, max, wait, -1)
+}
+
+// receiveWait receives up to max messages, hiding them for visibility
+// or, if it is negative, the queue's visibility timeout.
+func (q *MemoryQueue) receiveWait(ctx context.Context, max int64, wait, visibility time.Duration) ([]*sqs.Message, error) {
+	if max < 1 || max > 10 {
+		return nil, awserr.New("ReadCountOutOfRange", "MaxNumberOfMessages must be between 1 and 10", nil)
+	}
+	// Poll rather than wait for a signal, since messages become
+	// visible again as the clock moves, which may be a FakeClock.
+	deadline := time.Now().Add(wait)
+	for {
+		if msgs := q.receive(max, visibility); len(msgs) > 0 {
+			return msgs, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (q *MemoryQueue) receive(max int64, visibility time.Duration) []*sqs.Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if visibility < 0 {
+		visibility = q.visibility
+	}
+	now := q.clock.Now()
+	var ret []*sqs.Message
+	kept := q.messages[:0]
+	// blocked holds the FIFO message groups with a message in
+	// flight, whose later messages must wait for it.
+	blocked := make(map[string]bool)
+	for _, m := range q.messages {
+		switch {
+		case q.fifo && blocked[m.group]:
+		case q.fifo && now.Before(m.visibleAt):
+			blocked[m.group] = true
+		case int64(len(ret)) == max || now.Before(m.visibleAt):
+		case q.deadLetter != nil && m.receives >= q.maxReceiveCount:
+			m.visibleAt, m.handle = time.Time{}, ""
+			q.deadLetter.mu.Lock()
+			q.deadLetter.messages = append(q.deadLetter.messages, m)
+			q.deadLetter.mu.Unlock()
+			continue
+		default:
+			m.receives++
+			if m.firstReceive.IsZero() {
+				m.firstReceive = now
+			}
+			m.visibleAt = now.Add(visibility)
+			m.handle = fmt.Sprintf("%s#%d", m.id, m.receives)
+			ret = append(ret, m.message())
+		}
+		kept = append(kept, m)
+	}
+	q.messages = kept
+	return ret
+}
+
+func (m *memoryMessage) message() *sqs.Message {
+	ms := func(t time.Time) *string {
+		return aws.String(strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10))
+	}
+	msg := &sqs.Message{
+		MessageId:         aws.String(m.id),
+		ReceiptHandle:     aws.String(m.handle),
+		Body:              aws.String(m.body),
+		MD5OfBody:         aws.String(md5Hex(m.body)),
+		MessageAttributes: m.attrs,
+		Attributes: map[string]*string{
+			"ApproximateReceiveCount":          aws.String(strconv.Itoa(m.receives)),
+			"SentTimestamp":                    ms(m.sent),
+			"ApproximateFirstReceiveTimestamp": ms(m.firstReceive),
+		},
+	}
+	if m.group != "" {
+		msg.Attributes["MessageGroupId"] = aws.String(m.group)
+		msg.Attributes["MessageDeduplicationId"] = aws.String(m.dedupID)
+		msg.Attributes["SequenceNumber"] = aws.String(m.sequence)
+	}
+	return msg
+}
+
+// inFlight returns the message received with handle, which must still
+// be in flight.
+func (q *MemoryQueue) inFlight(handle string) (int, error) {
+	for i, m := range q.messages {
+		if m.handle == handle && handle != "" {
+			if !q.clock.Now().Before(m.visibleAt) {
+				return 0, awserr.New("AWS.SimpleQueueService.MessageNotInflight", "message "+m.id+" is not in flight", nil)
+			}
+			return i, nil
+		}
+	}
+	return 0, awserr.New("ReceiptHandleIsInvalid", fmt.Sprintf("receipt handle %q is invalid", handle), nil)
+}
+
+// DeleteMessage deletes the message received with receiptHandle. As on
+// SQS, a message that has been received again since can't be deleted
+// with an older receipt handle.
+func (q *MemoryQueue) DeleteMessage(receiptHandle string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, m := range q.messages {
+		if m.handle == receiptHandle && receiptHandle != "" {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			return nil
+		}
+	}
+	return awserr.New("ReceiptHandleIsInvalid", fmt.Sprintf("receipt handle %q is invalid", receiptHandle), nil)
+}
+
+// ChangeMessageVisibility makes the message received with
+// receiptHandle visible again after visibility. The message must still
+// be in flight.
+func (q *MemoryQueue) ChangeMessageVisibility(receiptHandle string, visibility time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i, err := q.inFlight(receiptHandle)
+	if err != nil {
+		return err
+	}
+	q.messages[i].visibleAt = q.clock.Now().Add(visibility)
+	return nil
+}
+
+// Len returns how many messages are waiting and how many are in
+// flight.
+func (q *MemoryQueue) Len() (waiting, inFlight int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.clock.Now()
+	for _, m := range q.messages {
+		if now.Before(m.visibleAt) {
+			inFlight++
+		} else {
+			waiting++
+		}
+	}
+	return waiting, inFlight
+}
+
+// Reset deletes every message, including those in flight.
+func (q *MemoryQueue) Reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.messages = nil
+}
+
+// AssertMessageRedelivered is FakeSQS.AssertMessageRedelivered for a
+// MemoryQueue.
+func (q *MemoryQueue) AssertMessageRedelivered(t TestingT, msgID string, within time.Duration) *sqs.Message {
+	t.Helper()
+	return assertMessageRedelivered(t, q, msgID, within)
+}
+
+// AssertMessageNotRedelivered is FakeSQS.AssertMessageNotRedelivered
+// for a MemoryQueue.
+func (q *MemoryQueue) AssertMessageNotRedelivered(t TestingT, msgID string, within time.Duration) {
+	t.Helper()
+	assertMessageNotRedelivered(t, q, msgID, within)
+}
@@ -0,0 +1,128 @@
+package testutil
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestMemoryQueueVisibility(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	q := NewMemoryQueue("jobs", WithClock(clock), WithQueueAttributes(map[string]string{"VisibilityTimeout": "10"}))
+
+	sent, _ := q.SendMessage("hello", map[string]string{"trace": "t1"})
+	msgs, err := q.ReceiveMessages(10, 0)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("ReceiveMessages = %v, %v", msgs, err)
+	}
+	msg := msgs[0]
+	if *msg.MessageId != *sent.MessageId || *msg.MD5OfBody != *sent.MD5OfMessageBody {
+		t.Errorf("received %v, sent %v", msg, sent)
+	}
+	AssertAttribute(t, msg, "trace", "t1")
+	AssertSystemAttribute(t, msg, "ApproximateReceiveCount", "1")
+
+	if msgs, _ := q.ReceiveMessages(10, 0); len(msgs) != 0 {
+		t.Errorf("in-flight message received again: %v", msgs)
+	}
+	if waiting, inFlight := q.Len(); waiting != 0 || inFlight != 1 {
+		t.Errorf("Len() = %d, %d", waiting, inFlight)
+	}
+
+	clock.Advance(10 * time.Second)
+	msgs, _ = q.ReceiveMessages(10, 0)
+	if len(msgs) != 1 {
+		t.Fatalf("message not redelivered after the visibility timeout")
+	}
+	AssertSystemAttribute(t, msgs[0], "ApproximateReceiveCount", "2")
+	if err := q.DeleteMessage(*msg.ReceiptHandle); !isErrorCode(err, "ReceiptHandleIsInvalid") {
+		t.Errorf("deleting with a stale receipt handle: %v", err)
+	}
+	if err := q.DeleteMessage(*msgs[0].ReceiptHandle); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	if msgs, _ := q.ReceiveMessages(10, 0); len(msgs) != 0 {
+		t.Errorf("deleted message redelivered: %v", msgs)
+	}
+}
+
+func isErrorCode(err error, code string) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == code
+}
+
+func TestMemoryQueueDeadLetter(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	q := NewMemoryQueue("jobs", WithClock(clock))
+	dlq := NewMemoryQueue("jobs-dlq", WithClock(clock))
+	q.SetDeadLetterQueue(dlq, 2)
+
+	q.SendMessage("poison", nil)
+	for i := 0; i < 2; i++ {
+		msgs, _ := q.ReceiveMessages(1, 0)
+		if len(msgs) != 1 {
+			t.Fatalf("receive %d got %d messages", i+1, len(msgs))
+		}
+		q.ChangeMessageVisibility(*msgs[0].ReceiptHandle, 0)
+	}
+	if msgs, _ := q.ReceiveMessages(1, 0); len(msgs) != 0 {
+		t.Errorf("message delivered past maxReceiveCount: %v", msgs)
+	}
+	msgs, _ := dlq.ReceiveMessages(1, 0)
+	if len(msgs) != 1 || *msgs[0].Body != "poison" {
+		t.Errorf("dead-letter queue holds %v", msgs)
+	}
+}
+
+func TestMemoryQueueWait(t *testing.T) {
+	q := NewMemoryQueue("jobs")
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		q.SendMessage("late", nil)
+	}()
+	msgs, err := q.ReceiveMessages(1, time.Second)
+	if err != nil || len(msgs) != 1 {
+		t.Errorf("long poll returned %v, %v", msgs, err)
+	}
+	if _, err := q.ReceiveMessages(11, 0); !isErrorCode(err, "ReadCountOutOfRange") {
+		t.Errorf("receiving 11 messages: %v", err)
+	}
+}
+
+func TestMemoryQueueHarness(t *testing.T) {
+	q := NewMemoryQueue("jobs")
+	q.SendMessage("first", nil)
+	q.SendMessage("flaky", nil)
+
+	failed := false
+	h := &ConsumerHarness{
+		Queue: q,
+		Handler: func(msg *sqs.Message) error {
+			if aws.StringValue(msg.Body) == "flaky" && !failed {
+				failed = true
+				return errors.New("temporary failure")
+			}
+			return nil
+		},
+	}
+	if err := h.RunUntilAcked(2, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	h.AssertAckedInOrder(t, "first", "flaky")
+	h.AssertDeliveredTimes(t, "flaky", 2)
+
+	sent, _ := q.SendMessage("abandoned", nil)
+	q.ReceiveMessages(1, 0)
+	q.AssertMessageNotRedelivered(t, *sent.MessageId, 50*time.Millisecond)
+
+	type job struct{ ID int }
+	q.SendMessage(`{"ID":7}`, nil)
+	if j, err := ReceiveJSON[job](q, time.Second); err != nil || j.ID != 7 {
+		t.Errorf("ReceiveJSON = %+v, %v", j, err)
+	}
+}
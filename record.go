@@ -0,0 +1,388 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// RecordingProxy forwards S3 or SQS calls to real AWS, such as a
+// sandbox account, and records what it sees, so that realistic
+// fixtures can be bootstrapped from production-like data. Point clients
+// at it with Config: they sign requests with the fakes' credentials,
+// and the proxy signs them again with the real ones, which never reach
+// the code under test or the recording.
+//
+// The objects and messages seen can be written with SaveFixtures in the
+// layout FakeS3.Load and FakeSQS.Load read, and Recorder holds every
+// exchange with AWS for use as a HAR cassette.
+type RecordingProxy struct {
+	// URL is the proxy's endpoint.
+	URL string
+
+	// Config is an AWS config for clients that talk to AWS through the
+	// proxy.
+	Config *aws.Config
+
+	// Recorder records every request forwarded to AWS and its
+	// response, with the real credentials scrubbed.
+	Recorder *HTTPRecorder
+
+	service string
+	region  string
+	target  *url.URL
+	signer  *v4.Signer
+	keyID   string
+	client  *http.Client
+	logger  Logger
+	srv     *httptest.Server
+
+	mu       sync.Mutex
+	objects  map[string]map[string][]byte
+	messages map[string][]savedMessage
+	seen     map[string]bool
+}
+
+// NewRecordingProxy starts a RecordingProxy for service, "s3" or
+// "sqs". By default it forwards to the service's regional endpoint,
+// signing with the credentials and region the AWS SDK would find in
+// the environment; WithEndpoint, WithCredentials and WithRegion
+// override them. S3 clients must use path-style addressing, which
+// Config does.
+func NewRecordingProxy(service string, opts ...Option) *RecordingProxy {
+	o := newOptions(opts)
+	p := &RecordingProxy{
+		service:  service,
+		logger:   o.logger,
+		objects:  make(map[string]map[string][]byte),
+		messages: make(map[string][]savedMessage),
+		seen:     make(map[string]bool),
+	}
+	if service != "s3" && service != "sqs" {
+		p.logger.Fatalf("RecordingProxy: unsupported service %q; use s3 or sqs", service)
+		return nil
+	}
+
+	env := session.New()
+	creds, region := o.credentials, o.region
+	if creds == nil {
+		creds = env.Config.Credentials
+	}
+	if region == "" {
+		region = aws.StringValue(env.Config.Region)
+	}
+	if region == "" {
+		region = defaultRegion
+	}
+	p.region = region
+	endpoint := o.endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", service, region)
+	}
+	target, err := url.Parse(endpoint)
+	if err != nil {
+		p.logger.Fatalf("RecordingProxy: invalid endpoint %q: %v", endpoint, err)
+		return nil
+	}
+	p.target = target
+	v, err := creds.Get()
+	if err != nil {
+		p.logger.Fatalf("RecordingProxy: no AWS credentials to forward with: %v", err)
+		return nil
+	}
+	p.keyID = v.AccessKeyID
+	p.signer = v4.NewSigner(creds, func(s *v4.Signer) {
+		// S3 paths are escaped once, not twice as for other services.
+		s.DisableURIPathEscaping = service == "s3"
+	})
+
+	var transport http.RoundTripper = http.DefaultTransport
+	if o.httpClient != nil && o.httpClient.Transport != nil {
+		transport = o.httpClient.Transport
+	}
+	p.Recorder = NewHTTPRecorder(scrubbingTransport{transport, p.keyID})
+	p.client = &http.Client{Transport: p.Recorder}
+
+	p.srv = httptest.NewServer(http.HandlerFunc(p.serveHTTP))
+	p.URL = p.srv.URL
+	clientOpts := *o
+	clientOpts.credentials, clientOpts.httpClient, clientOpts.pathStyle = nil, nil, true
+	p.Config = fakeAWSConfig(p.URL, &clientOpts)
+	p.Config.Region = aws.String(region)
+	return p
+}
+
+// Close stops the proxy.
+func (p *RecordingProxy) Close() {
+	p.srv.Close()
+}
+
+func (p *RecordingProxy) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	u := *p.target
+	u.Path = strings.TrimSuffix(u.Path, "/") + r.URL.Path
+	u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/") + r.URL.EscapedPath()
+	u.RawQuery = r.URL.RawQuery
+	out, err := http.NewRequest(r.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for k, vs := range r.Header {
+		switch k {
+		case "Authorization", "X-Amz-Date", "X-Amz-Security-Token", "X-Amz-Content-Sha256", "Content-Length":
+		default:
+			out.Header[k] = vs
+		}
+	}
+	if _, err := p.signer.Sign(out, bytes.NewReader(body), p.service, p.region, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := p.client.Do(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if resp.StatusCode/100 == 2 {
+		if p.service == "s3" {
+			p.recordS3(r, body, respBody)
+		} else {
+			p.recordSQS(body, respBody)
+		}
+	}
+
+	for k, vs := range resp.Header {
+		if k != "Content-Length" {
+			w.Header()[k] = vs
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+}
+
+// recordS3 notes the objects a successful S3 call stored, fetched or
+// deleted. Multipart uploads and ranged gets aren't recorded.
+func (p *RecordingProxy) recordS3(r *http.Request, body, respBody []byte) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) != 2 || parts[1] == "" || r.URL.RawQuery != "" {
+		return
+	}
+	bucket, key := parts[0], parts[1]
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		src, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+		if err != nil {
+			return
+		}
+		srcParts := strings.SplitN(src, "/", 2)
+		if len(srcParts) == 2 {
+			if b, ok := p.objects[srcParts[0]][srcParts[1]]; ok {
+				p.setObject(bucket, key, b)
+			}
+		}
+	case r.Method == http.MethodPut:
+		p.setObject(bucket, key, body)
+	case r.Method == http.MethodGet && r.Header.Get("Range") == "":
+		p.setObject(bucket, key, respBody)
+	case r.Method == http.MethodDelete:
+		p.setObject(bucket, key, nil)
+	}
+}
+
+// setObject records bucket/key's content, or its deletion if body is
+// nil.
+func (p *RecordingProxy) setObject(bucket, key string, body []byte) {
+	if p.objects[bucket] == nil {
+		p.objects[bucket] = make(map[string][]byte)
+	}
+	p.objects[bucket][key] = body
+}
+
+type (
+	sqsSendResponse struct {
+		MessageID string `xml:"SendMessageResult>MessageId"`
+	}
+	sqsSendBatchResponse struct {
+		Entries []struct {
+			ID        string `xml:"Id"`
+			MessageID string `xml:"MessageId"`
+		} `xml:"SendMessageBatchResult>SendMessageBatchResultEntry"`
+	}
+	sqsReceiveResponse struct {
+		Messages []struct {
+			MessageID  string `xml:"MessageId"`
+			Body       string
+			Attributes []struct {
+				Name  string
+				Value struct {
+					DataType    string
+					StringValue *string
+					BinaryValue []byte
+				}
+			} `xml:"MessageAttribute"`
+		} `xml:"ReceiveMessageResult>Message"`
+	}
+)
+
+// recordSQS notes the messages a successful SQS call sent or
+// received. Each message is recorded once, when first seen.
+func (p *RecordingProxy) recordSQS(body, respBody []byte) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return
+	}
+	queue := queueNameFromURL(form.Get("QueueUrl"))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch form.Get("Action") {
+	case "SendMessage":
+		var resp sqsSendResponse
+		if xml.Unmarshal(respBody, &resp) == nil {
+			p.addMessage(queue, resp.MessageID, formMessage(form, ""))
+		}
+	case "SendMessageBatch":
+		var resp sqsSendBatchResponse
+		if xml.Unmarshal(respBody, &resp) != nil {
+			return
+		}
+		sent := make(map[string]string)
+		for _, e := range resp.Entries {
+			sent[e.ID] = e.MessageID
+		}
+		for i := 1; form.Get(fmt.Sprintf("SendMessageBatchRequestEntry.%d.Id", i)) != ""; i++ {
+			prefix := fmt.Sprintf("SendMessageBatchRequestEntry.%d.", i)
+			if id, ok := sent[form.Get(prefix+"Id")]; ok {
+				p.addMessage(queue, id, formMessage(form, prefix))
+			}
+		}
+	case "ReceiveMessage":
+		var resp sqsReceiveResponse
+		if xml.Unmarshal(respBody, &resp) != nil {
+			return
+		}
+		for _, m := range resp.Messages {
+			saved := savedMessage{Body: m.Body}
+			for _, a := range m.Attributes {
+				if saved.Attributes == nil {
+					saved.Attributes = make(map[string]*sqs.MessageAttributeValue)
+				}
+				saved.Attributes[a.Name] = &sqs.MessageAttributeValue{
+					DataType:    aws.String(a.Value.DataType),
+					StringValue: a.Value.StringValue,
+					BinaryValue: a.Value.BinaryValue,
+				}
+			}
+			p.addMessage(queue, m.MessageID, saved)
+		}
+	}
+}
+
+func (p *RecordingProxy) addMessage(queue, id string, m savedMessage) {
+	if id == "" || p.seen[id] {
+		return
+	}
+	p.seen[id] = true
+	p.messages[queue] = append(p.messages[queue], m)
+}
+
+// formMessage returns the message sent in form's parameters starting
+// with prefix.
+func formMessage(form url.Values, prefix string) savedMessage {
+	m := savedMessage{Body: form.Get(prefix + "MessageBody")}
+	for i := 1; ; i++ {
+		attr := fmt.Sprintf("%sMessageAttribute.%d.", prefix, i)
+		name := form.Get(attr + "Name")
+		if name == "" {
+			return m
+		}
+		v := &sqs.MessageAttributeValue{DataType: aws.String(form.Get(attr + "Value.DataType"))}
+		if s, ok := form[attr+"Value.StringValue"]; ok && len(s) > 0 {
+			v.StringValue = aws.String(s[0])
+		}
+		if b, err := base64.StdEncoding.DecodeString(form.Get(attr + "Value.BinaryValue")); err == nil && len(b) > 0 {
+			v.BinaryValue = b
+		}
+		if m.Attributes == nil {
+			m.Attributes = make(map[string]*sqs.MessageAttributeValue)
+		}
+		m.Attributes[name] = v
+	}
+}
+
+// SaveFixtures writes the objects and messages the proxy has seen to
+// dir, in the layout FakeS3.Load and FakeSQS.Load read. Objects are
+// saved as last stored or fetched, and deleted objects are left out.
+// Messages are saved in the order first seen, whether or not they have
+// since been deleted.
+func (p *RecordingProxy) SaveFixtures(dir string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, bucket := range sortedKeys(p.objects) {
+		for _, key := range sortedKeys(p.objects[bucket]) {
+			body := p.objects[bucket][key]
+			if body == nil {
+				continue
+			}
+			if err := saveObject(dir, bucket, key, body); err != nil {
+				return fmt.Errorf("saving bucket %s: %v", bucket, err)
+			}
+		}
+	}
+	for _, queue := range sortedKeys(p.messages) {
+		if err := saveMessages(dir, queue, p.messages[queue]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scrubbingTransport replaces an access key ID in responses, such as
+// in the errors AWS returns for bad signatures, with the fakes' key.
+type scrubbingTransport struct {
+	transport http.RoundTripper
+	keyID     string
+}
+
+func (t scrubbingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil || t.keyID == "" {
+		return resp, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body = bytes.Replace(body, []byte(t.keyID), []byte(fakeAccessKeyID), -1)
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
@@ -0,0 +1,130 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// realAWS stands in for AWS, rejecting requests not signed with
+// AKIDREAL the way AWS does, by echoing the key they were signed with.
+func realAWS(h http.Handler) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.Contains(auth, "Credential=AKIDREAL/") {
+			keyID := strings.SplitN(auth[strings.Index(auth, "Credential=")+len("Credential="):], "/", 2)[0]
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, "<Error><Code>InvalidAccessKeyId</Code><AWSAccessKeyId>%s</AWSAccessKeyId></Error>", keyID)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+}
+
+func TestRecordingProxyS3(t *testing.T) {
+	aws3 := realAWS(&memS3{objects: make(map[string]string)})
+	defer aws3.Close()
+	p := NewRecordingProxy("s3", WithEndpoint(aws3.URL), WithCredentials("AKIDREAL", "secret"), WithLogger(t))
+	defer p.Close()
+	client := s3.New(session.New(p.Config))
+
+	put := func(key, body string) {
+		t.Helper()
+		_, err := client.PutObject(&s3.PutObjectInput{Bucket: aws.String("b"), Key: aws.String(key), Body: strings.NewReader(body)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("keep.txt", "kept")
+	put("dir/gone.txt", "gone")
+	if _, err := client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String("b"), Key: aws.String("dir/gone.txt")}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := p.SaveFixtures(dir); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "s3", "b", "keep.txt")); err != nil || string(b) != "kept" {
+		t.Errorf("saved keep.txt = %q, %v", b, err)
+	}
+	if _, err := ioutil.ReadFile(filepath.Join(dir, "s3", "b", "dir", "gone.txt")); err == nil {
+		t.Error("saved a deleted object")
+	}
+
+	var har bytes.Buffer
+	p.Recorder.WriteHAR(&har)
+	if strings.Contains(har.String(), "AKIDREAL") {
+		t.Errorf("recording contains the real access key ID:\n%s", har.String())
+	}
+}
+
+func TestRecordingProxySQS(t *testing.T) {
+	awsSQS := realAWS(&memSQS{})
+	defer awsSQS.Close()
+	p := NewRecordingProxy("sqs", WithEndpoint(awsSQS.URL), WithCredentials("AKIDREAL", "secret"), WithLogger(t))
+	defer p.Close()
+	client := sqs.New(session.New(p.Config))
+
+	q, err := client.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String("jobs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:          q.QueueUrl,
+		MessageBody:       aws.String("one"),
+		MessageAttributes: StringAttributes(map[string]string{"trace": "t1"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Received messages that were already sent through the proxy
+	// aren't recorded again.
+	if _, err := client.ReceiveMessage(&sqs.ReceiveMessageInput{QueueUrl: q.QueueUrl}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := p.SaveFixtures(dir); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "sqs", "jobs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []savedMessage
+	if err := json.Unmarshal(b, &msgs); err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Body != "one" || aws.StringValue(msgs[0].Attributes["trace"].StringValue) != "t1" {
+		t.Errorf("saved messages:\n%s", b)
+	}
+}
+
+func TestRecordingProxyScrubsErrors(t *testing.T) {
+	aws3 := realAWS(&memS3{objects: make(map[string]string)})
+	defer aws3.Close()
+	p := NewRecordingProxy("s3", WithEndpoint(aws3.URL), WithCredentials("AKIDWRONG", "secret"), WithLogger(t))
+	defer p.Close()
+
+	resp, err := http.Get(p.URL + "/b/k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || strings.Contains(string(body), "AKIDWRONG") {
+		t.Errorf("error response %d %s", resp.StatusCode, body)
+	}
+}
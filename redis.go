@@ -0,0 +1,60 @@
+package testutil
+
+import (
+	"context"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/rainforestapp/testutil/redistest"
+)
+
+// The redis helpers live in redistest, which doesn't depend on the AWS
+// SDK. These names are kept for compatibility.
+type (
+	PoolStats       = redistest.PoolStats
+	RESP3Conn       = redistest.RESP3Conn
+	RESP3Error      = redistest.RESP3Error
+	SlowSubscriber  = redistest.SlowSubscriber
+	SubscriberStats = redistest.SubscriberStats
+	RedisTorture    = redistest.Torture
+	TortureStats    = redistest.TortureStats
+)
+
+// WaitForKey waits up to timeout for key to exist in the DB pool
+// connects to. See redistest.WaitForKey.
+func WaitForKey(pool *redis.Pool, key string, timeout time.Duration) error {
+	return redistest.WaitForKey(pool, key, timeout)
+}
+
+// WaitForKeyContext is like WaitForKey, but waits until ctx is done
+// rather than for a fixed timeout.
+func WaitForKeyContext(ctx context.Context, pool *redis.Pool, key string) error {
+	return redistest.WaitForKeyContext(ctx, pool, key)
+}
+
+// WaitForKeyValue waits up to timeout for key to hold the string value
+// want. See redistest.WaitForKeyValue.
+func WaitForKeyValue(pool *redis.Pool, key, want string, timeout time.Duration) error {
+	return redistest.WaitForKeyValue(pool, key, want, timeout)
+}
+
+// WaitForKeyValueContext is like WaitForKeyValue, but waits until ctx
+// is done rather than for a fixed timeout.
+func WaitForKeyValueContext(ctx context.Context, pool *redis.Pool, key, want string) error {
+	return redistest.WaitForKeyValueContext(ctx, pool, key, want)
+}
+
+// NewSlowSubscriber subscribes to redis channel c with a subscriber
+// that handles a message every delay and buffers up to buffer
+// messages. See redistest.NewSlowSubscriber.
+func NewSlowSubscriber(pool *redis.Pool, c string, buffer int, delay time.Duration, opts ...Option) *SlowSubscriber {
+	o := newOptions(opts)
+	return redistest.NewSlowSubscriber(pool, c, buffer, delay, redistest.WithLogger(o.logger))
+}
+
+// TortureRedis runs a RedisTorture with the default settings against
+// pool for d, reporting any errors or inconsistencies on t.
+func TortureRedis(t TestingT, pool *redis.Pool, d time.Duration) TortureStats {
+	t.Helper()
+	return redistest.RunTorture(t, pool, d)
+}
@@ -0,0 +1,228 @@
+package redistest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/rainforestapp/testutil/internal/diff"
+	"github.com/rainforestapp/testutil/waitfor"
+)
+
+// AssertString checks that key holds the string value want.
+func (r *FakeRedis) AssertString(t TestingT, key, want string) {
+	t.Helper()
+	got, err := r.do(redis.String, "GET", key)
+	if err == redis.ErrNil {
+		t.Errorf("redis key %q does not exist, want %q", key, want)
+	} else if err != nil {
+		t.Errorf("reading redis key %q: %v", key, err)
+	} else if got != want {
+		t.Errorf("redis key %q = %q, want %q", key, got, want)
+	}
+}
+
+// AssertHashEquals checks that the hash at key holds exactly the
+// fields and values in want.
+func (r *FakeRedis) AssertHashEquals(t TestingT, key string, want map[string]string) {
+	t.Helper()
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	got, err := redis.StringMap(conn.Do("HGETALL", key))
+	if err != nil {
+		t.Errorf("reading redis hash %q: %v", key, err)
+		return
+	}
+	if d := diff.Maps(got, want); d != "" {
+		t.Errorf("redis hash %q differs (- missing, + unexpected, ~ changed):\n%s", key, d)
+	}
+}
+
+// AssertListEquals checks that the list at key holds exactly want, in
+// order.
+func (r *FakeRedis) AssertListEquals(t TestingT, key string, want []string) {
+	t.Helper()
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	got, err := redis.Strings(conn.Do("LRANGE", key, 0, -1))
+	if err != nil {
+		t.Errorf("reading redis list %q: %v", key, err)
+		return
+	}
+	if d := diff.Lists(got, want); d != "" {
+		t.Errorf("redis list %q differs (- missing, + unexpected, ~ changed):\n%s", key, d)
+	}
+}
+
+// AssertZSetScores checks that the sorted set at key holds exactly the
+// members in want, with the given scores.
+func (r *FakeRedis) AssertZSetScores(t TestingT, key string, want map[string]float64) {
+	t.Helper()
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	values, err := redis.Strings(conn.Do("ZRANGE", key, 0, -1, "WITHSCORES"))
+	if err != nil {
+		t.Errorf("reading redis sorted set %q: %v", key, err)
+		return
+	}
+
+	got := make(map[string]string, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		got[values[i]] = normalizeScore(values[i+1])
+	}
+	wantStrings := make(map[string]string, len(want))
+	for member, score := range want {
+		wantStrings[member] = strconv.FormatFloat(score, 'g', -1, 64)
+	}
+	if d := diff.Maps(got, wantStrings); d != "" {
+		t.Errorf("redis sorted set %q differs (- missing, + unexpected, ~ changed):\n%s", key, d)
+	}
+}
+
+// AssertTTLWithin checks that key exists and expires in want, give or
+// take tolerance.
+func (r *FakeRedis) AssertTTLWithin(t TestingT, key string, want, tolerance time.Duration) {
+	t.Helper()
+	ttl, ok := r.pttl(t, key)
+	if !ok {
+		return
+	}
+	switch {
+	case ttl == -2*time.Millisecond:
+		t.Errorf("redis key %q does not exist, want TTL %v", key, want)
+	case ttl == -1*time.Millisecond:
+		t.Errorf("redis key %q has no expiry, want TTL %v (±%v)", key, want, tolerance)
+	case ttl < want-tolerance || ttl > want+tolerance:
+		t.Errorf("redis key %q has TTL %v, want %v (±%v)", key, ttl, want, tolerance)
+	}
+}
+
+// AssertPersistent checks that key exists and has no expiry.
+func (r *FakeRedis) AssertPersistent(t TestingT, key string) {
+	t.Helper()
+	ttl, ok := r.pttl(t, key)
+	if !ok {
+		return
+	}
+	switch {
+	case ttl == -2*time.Millisecond:
+		t.Errorf("redis key %q does not exist, want a persistent key", key)
+	case ttl != -1*time.Millisecond:
+		t.Errorf("redis key %q expires in %v, want a persistent key", key, ttl)
+	}
+}
+
+// pttl returns key's PTTL reply as a duration. As with PTTL, -2ms
+// means the key doesn't exist and -1ms that it has no expiry.
+func (r *FakeRedis) pttl(t TestingT, key string) (time.Duration, bool) {
+	t.Helper()
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	ms, err := redis.Int64(conn.Do("PTTL", key))
+	if err != nil {
+		t.Errorf("reading TTL of redis key %q: %v", key, err)
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// redisPollInterval is how often WaitForKey and WaitForKeyValue check
+// redis.
+const redisPollInterval = 10 * time.Millisecond
+
+// WaitForKey waits up to timeout for key to exist in the DB pool
+// connects to, for tests where a background worker writes to redis
+// asynchronously.
+func WaitForKey(pool *redis.Pool, key string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return waitForKey(ctx, pool, key, fmt.Sprintf("within %v", timeout))
+}
+
+// WaitForKeyContext is like WaitForKey, but waits until ctx is done
+// rather than for a fixed timeout.
+func WaitForKeyContext(ctx context.Context, pool *redis.Pool, key string) error {
+	return waitForKey(ctx, pool, key, "before context was done")
+}
+
+func waitForKey(ctx context.Context, pool *redis.Pool, key, within string) error {
+	var lastErr error
+	try := func() bool {
+		conn := pool.Get()
+		defer conn.Close()
+		exists, err := redis.Bool(conn.Do("EXISTS", key))
+		lastErr = err
+		return exists
+	}
+
+	if waitfor.Context(ctx, try, redisPollInterval) == nil {
+		return nil
+	}
+	err := fmt.Errorf("redis key %q did not appear %s", key, within)
+	if lastErr != nil {
+		err = fmt.Errorf("%v (last error: %v)", err, lastErr)
+	}
+	return err
+}
+
+// WaitForKeyValue waits up to timeout for key to hold the string value
+// want.
+func WaitForKeyValue(pool *redis.Pool, key, want string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return waitForKeyValue(ctx, pool, key, want, fmt.Sprintf("within %v", timeout), fmt.Sprintf("after %v", timeout))
+}
+
+// WaitForKeyValueContext is like WaitForKeyValue, but waits until ctx
+// is done rather than for a fixed timeout.
+func WaitForKeyValueContext(ctx context.Context, pool *redis.Pool, key, want string) error {
+	return waitForKeyValue(ctx, pool, key, want, "before context was done", "when context was done")
+}
+
+func waitForKeyValue(ctx context.Context, pool *redis.Pool, key, want, within, after string) error {
+	var got string
+	var lastErr error
+	try := func() bool {
+		conn := pool.Get()
+		defer conn.Close()
+		got, lastErr = redis.String(conn.Do("GET", key))
+		return got == want && lastErr == nil
+	}
+
+	if waitfor.Context(ctx, try, redisPollInterval) == nil {
+		return nil
+	}
+	switch lastErr {
+	case redis.ErrNil:
+		return fmt.Errorf("redis key %q did not appear %s, want %q", key, within, want)
+	case nil:
+		return fmt.Errorf("redis key %q = %q %s, want %q", key, got, after, want)
+	default:
+		return fmt.Errorf("redis key %q did not become %q %s (last error: %v)", key, want, within, lastErr)
+	}
+}
+
+// normalizeScore formats a score returned by redis the same way as
+// scores given to AssertZSetScores, so that "1" and "1.0" compare
+// equal.
+func normalizeScore(s string) string {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return s
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// do runs a single command on a pooled connection and converts the
+// reply with conv.
+func (r *FakeRedis) do(conv func(interface{}, error) (string, error), cmd string, args ...interface{}) (string, error) {
+	conn := r.Pool.Get()
+	defer conn.Close()
+	return conv(conn.Do(cmd, args...))
+}
@@ -0,0 +1,85 @@
+package redistest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNormalizeScore(t *testing.T) {
+	for in, want := range map[string]string{"1": "1", "1.5": "1.5", "inf": "+Inf", "abc": "abc"} {
+		if got := normalizeScore(in); got != want {
+			t.Errorf("normalizeScore(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func ExampleFakeRedis_AssertHashEquals() {
+	r := New()
+	defer r.Close()
+
+	conn := r.Pool.Get()
+	conn.Do("HSET", "user:1", "name", "Ada")
+	conn.Do("HSET", "user:1", "role", "admin")
+	conn.Close()
+
+	t := &recordingT{}
+	r.AssertHashEquals(t, "user:1", map[string]string{"name": "Ada", "role": "user"})
+
+	// In a real test t would be the *testing.T, which reports this.
+	fmt.Println(t.errors[0])
+	// Output:
+	// redis hash "user:1" differs (- missing, + unexpected, ~ changed):
+	// ~ role: "admin", want "user"
+}
+
+func ExampleFakeRedis_AssertTTLWithin() {
+	r := New()
+	defer r.Close()
+
+	conn := r.Pool.Get()
+	conn.Do("SET", "session:1", "data", "EX", 3600)
+	conn.Do("SET", "config", "data")
+	conn.Close()
+
+	t := &recordingT{}
+	r.AssertTTLWithin(t, "session:1", time.Hour, time.Second)
+	r.AssertPersistent(t, "config")
+	r.AssertPersistent(t, "session:1")
+
+	// In a real test t would be the *testing.T, which reports this.
+	fmt.Println(len(t.errors))
+	// Output:
+	// 1
+}
+
+func ExampleWaitForKeyValue() {
+	r := New()
+	defer r.Close()
+
+	// A background worker that eventually writes its result
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn := r.Pool.Get()
+		conn.Do("SET", "job:1:status", "done")
+		conn.Close()
+	}()
+
+	err := WaitForKeyValue(r.Pool, "job:1:status", "done", time.Second)
+	fmt.Println(err)
+	// Output:
+	// <nil>
+}
+
+func ExampleRunTorture() {
+	r := New()
+	defer r.Close()
+
+	// In a real test t would be the *testing.T.
+	t := &recordingT{}
+	stats := RunTorture(t, r.Pool, time.Second)
+
+	fmt.Println(len(t.errors), stats.Operations > 0)
+	// Output:
+	// 0 true
+}
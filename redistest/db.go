@@ -0,0 +1,112 @@
+package redistest
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	// FirstDB and LastDB bound the databases New claims for tests.
+	// Those other than the test databases, set with WithTestDBs, are
+	// only claimed while empty. DB 0, usually where real data lives,
+	// is never used.
+	FirstDB = 1
+	LastDB  = 15
+)
+
+// nextDB is where the next claim in this process starts looking among
+// the empty databases, so fakes created one after another use
+// different ones.
+var nextDB uint32
+
+// dbClaim is a database claimed by a FakeRedis. A claim is held by
+// naming a connection after the database, so no key is written to
+// any database for it, and it ends when the connection closes, even
+// if the test binary is killed.
+type dbClaim struct {
+	conn redis.Conn
+	db   int
+}
+
+func claimName(db int) string {
+	return fmt.Sprintf("redistest:db:%d", db)
+}
+
+// claimDB claims a database on the server conn is connected to: the
+// first free one of testDBs, or else an empty one from FirstDB to
+// LastDB, starting from a different one each call. The claim holds
+// conn, which must not be used for anything else until the claim is
+// released.
+func claimDB(conn redis.Conn, testDBs []int) (*dbClaim, error) {
+	isTestDB := make(map[int]bool, len(testDBs))
+	candidates := make([]int, 0, LastDB-FirstDB+1)
+	for _, db := range testDBs {
+		if db >= FirstDB && db <= LastDB && !isTestDB[db] {
+			isTestDB[db] = true
+			candidates = append(candidates, db)
+		}
+	}
+	n := LastDB - FirstDB + 1
+	start := int(atomic.AddUint32(&nextDB, 1)-1) + os.Getpid()
+	for i := 0; i < n; i++ {
+		if db := FirstDB + (start+i)%n; !isTestDB[db] {
+			candidates = append(candidates, db)
+		}
+	}
+
+	for _, db := range candidates {
+		if !isTestDB[db] {
+			if _, err := conn.Do("SELECT", db); err != nil {
+				return nil, fmt.Errorf("claiming redis DB %d: %v", db, err)
+			}
+			size, err := redis.Int(conn.Do("DBSIZE"))
+			if err != nil {
+				return nil, fmt.Errorf("claiming redis DB %d: %v", db, err)
+			}
+			if size > 0 {
+				continue
+			}
+		}
+		ok, err := tryClaim(conn, db)
+		if err != nil {
+			return nil, fmt.Errorf("claiming redis DB %d: %v", db, err)
+		}
+		if ok {
+			return &dbClaim{conn: conn, db: db}, nil
+		}
+	}
+	conn.Do("CLIENT", "SETNAME", "")
+	return nil, fmt.Errorf("every redis test DB %v is in use by other tests, and DBs %d to %d are in use or hold data; wait for the other tests, or choose a DB with WithDB or WithTestDBs", testDBs, FirstDB, LastDB)
+}
+
+// tryClaim names conn after db, and reports whether it is the only
+// connection with that name. When two connections take the name at
+// once, both give it up, so at most one ever holds a claim.
+func tryClaim(conn redis.Conn, db int) (bool, error) {
+	name := claimName(db)
+	if _, err := conn.Do("CLIENT", "SETNAME", name); err != nil {
+		return false, err
+	}
+	clients, err := redis.String(conn.Do("CLIENT", "LIST"))
+	if err != nil {
+		return false, err
+	}
+	holders := 0
+	for _, line := range strings.Split(clients, "\n") {
+		for _, field := range strings.Fields(line) {
+			if field == "name="+name {
+				holders++
+			}
+		}
+	}
+	return holders == 1, nil
+}
+
+// release gives up the claim by closing its connection.
+func (c *dbClaim) release() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,145 @@
+package redistest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// claimServer scripts a connection to a server whose databases hold
+// sizes[db] keys, and where the connections named in holders already
+// hold claims.
+func claimServer(sizes map[int]int, holders ...string) *ScriptedRedisConn {
+	var db int
+	var name string
+	conn := NewScriptedRedisConn()
+	conn.OnFunc("SELECT", func(args []interface{}) interface{} {
+		db = args[0].(int)
+		return "OK"
+	})
+	conn.OnFunc("DBSIZE", func([]interface{}) interface{} { return sizes[db] })
+	conn.OnFunc("CLIENT SETNAME *", func(args []interface{}) interface{} {
+		name = args[1].(string)
+		return "OK"
+	})
+	conn.OnFunc("CLIENT LIST", func([]interface{}) interface{} {
+		var lines []string
+		for i, n := range append(holders, name) {
+			lines = append(lines, fmt.Sprintf("id=%d addr=127.0.0.1:%d fd=8 name=%s db=0", i+1, 5000+i, n))
+		}
+		return strings.Join(lines, "\n") + "\n"
+	})
+	return conn
+}
+
+func TestClaimDB(t *testing.T) {
+	// The test DB is claimed whatever it holds.
+	conn := claimServer(map[int]int{DB: 3})
+	c, err := claimDB(conn, []int{DB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.db != DB {
+		t.Errorf("claimed DB %d, want the test DB %d", c.db, DB)
+	}
+	conn.AssertCalled(t, "CLIENT SETNAME redistest:db:9")
+	if err := c.release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Do("PING"); err != errScriptedClosed {
+		t.Errorf("claim connection still open after release: %v", err)
+	}
+}
+
+func TestClaimDBSkipsDBsWithData(t *testing.T) {
+	sizes := map[int]int{}
+	for db := FirstDB; db <= LastDB; db++ {
+		sizes[db] = 1
+	}
+	sizes[4] = 0
+	conn := claimServer(sizes, claimName(DB))
+	c, err := claimDB(conn, []int{DB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.db != 4 {
+		t.Errorf("claimed DB %d, want the only empty one, 4", c.db)
+	}
+	// DBs holding data are neither named nor flushed.
+	for _, call := range conn.Calls() {
+		line := call.String()
+		if line == "FLUSHDB" {
+			t.Errorf("flushed a DB while claiming")
+		}
+		if strings.HasPrefix(line, "CLIENT SETNAME") && line != "CLIENT SETNAME "+claimName(DB) && line != "CLIENT SETNAME "+claimName(4) {
+			t.Errorf("%s sent while claiming", line)
+		}
+	}
+}
+
+func TestClaimDBNoneFree(t *testing.T) {
+	var holders []string
+	for db := FirstDB; db <= LastDB; db++ {
+		holders = append(holders, claimName(db))
+	}
+	conn := claimServer(nil, holders...)
+	if _, err := claimDB(conn, []int{DB}); err == nil || !strings.Contains(err.Error(), "WithDB") {
+		t.Errorf("claimDB with every DB taken = %v", err)
+	}
+	conn.AssertCalled(t, "CLIENT SETNAME ")
+}
+
+func TestClaimDBRoundRobin(t *testing.T) {
+	a, err := claimDB(claimServer(nil, claimName(DB)), []int{DB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := claimDB(claimServer(nil, claimName(DB)), []int{DB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.db == b.db || a.db == DB || b.db == DB {
+		t.Errorf("consecutive claims got DBs %d and %d", a.db, b.db)
+	}
+}
+
+func TestWithDB(t *testing.T) {
+	for _, tt := range []struct {
+		opts []Option
+		want int
+	}{
+		{nil, DB},
+		{[]Option{WithDB(3)}, 3},
+	} {
+		conn := NewScriptedRedisConn().On("FLUSHDB", "OK").On("INFO", "")
+		r, err := Start(append(tt.opts, WithDialer(func() (redis.Conn, error) { return conn, nil }))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.DB != tt.want {
+			t.Errorf("DB = %d, want %d", r.DB, tt.want)
+		}
+		if r.Claimed() {
+			t.Errorf("Claimed with a dialer")
+		}
+		r.Close()
+		for _, call := range conn.Calls() {
+			if call.Command == "CLIENT" {
+				t.Errorf("claimed a DB with a dialer: %s", call)
+			}
+		}
+	}
+}
+
+func TestWithDBZero(t *testing.T) {
+	conn := NewScriptedRedisConn().On("FLUSHDB", "OK").On("INFO", "")
+	_, err := Start(WithDB(0), WithDialer(func() (redis.Conn, error) { return conn, nil }))
+	if err == nil || !strings.Contains(err.Error(), "DB 0") {
+		t.Errorf("Start with DB 0 = %v", err)
+	}
+	if n := len(conn.Calls()); n != 0 {
+		t.Errorf("sent %d commands to DB 0", n)
+	}
+}
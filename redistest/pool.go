@@ -0,0 +1,139 @@
+package redistest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// PoolStats describes the connections of a FakeRedis pool.
+//
+// The pool keeps no idle connections and has no limit on active ones:
+// every Pool.Get dials a connection, and closing it closes the
+// connection, which is how returns are observed. So there are no idle
+// connections or waits to count. Raising the pool's MaxIdle makes
+// idle connections count as in use.
+type PoolStats struct {
+	// Dials is the number of connections opened, one per Pool.Get.
+	Dials int
+	// InUse is the number of connections handed out and not yet
+	// closed by the caller.
+	InUse int
+}
+
+// Stats returns the current statistics for r's pool.
+func (r *FakeRedis) Stats() PoolStats {
+	return r.stats.snapshot()
+}
+
+// AssertNoLeakedConnections checks that every connection taken from
+// r's pool has been closed. It is best called at the end of a test,
+// for example with
+//
+//	defer r.AssertNoLeakedConnections(t)
+//
+// so that connections leaked by the code under test are reported
+// instead of surfacing as pool exhaustion in production.
+func (r *FakeRedis) AssertNoLeakedConnections(t TestingT) {
+	t.Helper()
+	if st := r.Stats(); st.InUse > 0 {
+		t.Errorf("%d redis connection(s) were not closed (%d dials)", st.InUse, st.Dials)
+	}
+}
+
+// poolStats tracks a pool's connections. A connection is counted as in
+// use from when it is dialed until it is closed: an empty command,
+// which the pool sends on return, is also how callers flush a
+// connection, so it can't tell returns apart.
+type poolStats struct {
+	mu    sync.Mutex
+	dials int
+	open  int
+
+	// The hooks set with WithDialHook, WithCommandHook and
+	// WithObserver, if any.
+	dialFault    func() error
+	commandFault func(conn redis.Conn, cmd string) error
+	observe      func(cmd string, d time.Duration)
+}
+
+func (s *poolStats) track(c redis.Conn) redis.Conn {
+	s.mu.Lock()
+	s.dials++
+	s.open++
+	s.mu.Unlock()
+	return &trackedConn{Conn: c, stats: s}
+}
+
+func (s *poolStats) closed() {
+	s.mu.Lock()
+	s.open--
+	s.mu.Unlock()
+}
+
+func (s *poolStats) setHooks(dial func() error, command func(conn redis.Conn, cmd string) error, observe func(cmd string, d time.Duration)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dialFault, s.commandFault, s.observe = dial, command, observe
+}
+
+func (s *poolStats) hooks() (func(conn redis.Conn, cmd string) error, func(cmd string, d time.Duration)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commandFault, s.observe
+}
+
+// dialHook runs the dial hook, if any.
+func (s *poolStats) dialHook() error {
+	s.mu.Lock()
+	hook := s.dialFault
+	s.mu.Unlock()
+	if hook == nil {
+		return nil
+	}
+	return hook()
+}
+
+func (s *poolStats) snapshot() PoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return PoolStats{Dials: s.dials, InUse: s.open}
+}
+
+type trackedConn struct {
+	redis.Conn
+	stats *poolStats
+
+	mu       sync.Mutex
+	isClosed bool
+}
+
+func (c *trackedConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "" {
+		// A flush, by the caller or by the pool on return.
+		return c.Conn.Do(cmd, args...)
+	}
+	fault, observe := c.stats.hooks()
+	if observe != nil {
+		start := time.Now()
+		defer func() { observe(cmd, time.Since(start)) }()
+	}
+	if fault != nil {
+		if err := fault(c.Conn, cmd); err != nil {
+			return nil, err
+		}
+	}
+	return c.Conn.Do(cmd, args...)
+}
+
+// Close closes the connection, which the pool does as it is returned.
+func (c *trackedConn) Close() error {
+	c.mu.Lock()
+	if !c.isClosed {
+		c.isClosed = true
+		c.stats.closed()
+	}
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
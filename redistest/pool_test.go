@@ -0,0 +1,60 @@
+package redistest
+
+import (
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// nopConn is a redis.Conn that accepts every command and replies with
+// nil.
+type nopConn struct{}
+
+func (nopConn) Close() error                                   { return nil }
+func (nopConn) Err() error                                     { return nil }
+func (nopConn) Do(string, ...interface{}) (interface{}, error) { return nil, nil }
+func (nopConn) Send(string, ...interface{}) error              { return nil }
+func (nopConn) Flush() error                                   { return nil }
+func (nopConn) Receive() (interface{}, error)                  { return nil, nil }
+
+func TestPoolStats(t *testing.T) {
+	r := &FakeRedis{}
+	r.Pool = &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return r.stats.track(nopConn{}), nil
+		},
+	}
+
+	a := r.Pool.Get()
+	b := r.Pool.Get()
+	a.Do("PING")
+	// A flush by the caller is not a return.
+	b.Do("")
+	a.Close()
+
+	want := PoolStats{Dials: 2, InUse: 1}
+	if got := r.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	rt := &recordingT{}
+	r.AssertNoLeakedConnections(rt)
+	if len(rt.errors) != 1 {
+		t.Errorf("expected the open connection to be reported as leaked, got %v", rt.errors)
+	}
+
+	b.Close()
+	b.Close()
+	c := r.Pool.Get()
+	c.Close()
+
+	want = PoolStats{Dials: 3}
+	if got := r.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+	rt = &recordingT{}
+	r.AssertNoLeakedConnections(rt)
+	if len(rt.errors) != 0 {
+		t.Errorf("unexpected leak reported: %v", rt.errors)
+	}
+}
@@ -0,0 +1,214 @@
+package redistest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// SlowSubscriber is a redis pub/sub subscriber that takes Delay to
+// handle each message and holds at most Buffer messages waiting to be
+// handled, dropping messages that arrive while the buffer is full. It
+// stands in for an overloaded consumer, so that a publisher's
+// backpressure handling can be exercised and its effect measured with
+// Stats.
+type SlowSubscriber struct {
+	logger Logger
+	delay  time.Duration
+	psc    redis.PubSubConn
+	buf    chan []byte
+	stop   chan struct{}
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	stats     SubscriberStats
+	delivered []string
+}
+
+// SubscriberStats counts what happened to the messages a
+// SlowSubscriber received.
+type SubscriberStats struct {
+	// Received is the number of messages read from redis.
+	Received int
+
+	// Delivered is the number of messages handled.
+	Delivered int
+
+	// Dropped is the number of messages discarded because the buffer
+	// was full.
+	Dropped int
+
+	// Queued is the number of messages waiting in the buffer, and
+	// MaxQueued the most that ever waited at once.
+	Queued    int
+	MaxQueued int
+}
+
+// NewSlowSubscriber subscribes to redis channel c with a subscriber
+// that handles a message every delay and buffers up to buffer
+// messages. Subscription errors are reported to the configured Logger
+// and stop the subscriber. Call Close when done.
+func NewSlowSubscriber(pool *redis.Pool, c string, buffer int, delay time.Duration, opts ...Option) *SlowSubscriber {
+	o := newOptions(opts)
+	s := &SlowSubscriber{
+		logger: o.logger,
+		delay:  delay,
+		psc:    redis.PubSubConn{Conn: pool.Get()},
+		buf:    make(chan []byte, buffer),
+		stop:   make(chan struct{}),
+	}
+	if err := s.psc.Subscribe(c); err != nil {
+		s.logger.Errorf("Subscription error: %v", err)
+	}
+	s.wg.Add(2)
+	go s.receive()
+	go s.consume()
+	return s
+}
+
+func (s *SlowSubscriber) receive() {
+	defer s.wg.Done()
+	for {
+		switch v := s.psc.Receive().(type) {
+		case redis.Message:
+			s.offer(v.Data)
+		case redis.Subscription:
+			if v.Count == 0 {
+				return
+			}
+		case error:
+			select {
+			case <-s.stop:
+			default:
+				s.logger.Errorf("Subscription error: %v", v)
+			}
+			return
+		}
+	}
+}
+
+// offer buffers msg, or drops it if the buffer is full.
+func (s *SlowSubscriber) offer(msg []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Received++
+	select {
+	case s.buf <- msg:
+		s.stats.Queued++
+		if s.stats.Queued > s.stats.MaxQueued {
+			s.stats.MaxQueued = s.stats.Queued
+		}
+	default:
+		s.stats.Dropped++
+	}
+}
+
+func (s *SlowSubscriber) consume() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case msg := <-s.buf:
+			s.mu.Lock()
+			s.stats.Queued--
+			s.mu.Unlock()
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(s.delay):
+			}
+			s.mu.Lock()
+			s.stats.Delivered++
+			s.delivered = append(s.delivered, string(msg))
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Stats returns the subscriber's counts so far.
+func (s *SlowSubscriber) Stats() SubscriberStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Messages returns the messages handled so far, in the order they were
+// handled.
+func (s *SlowSubscriber) Messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.delivered...)
+}
+
+// Close unsubscribes and stops handling messages. Messages still in the
+// buffer stay counted as queued; one being handled is lost.
+func (s *SlowSubscriber) Close() {
+	close(s.stop)
+	// As in ListenMessages, unsubscribing lets receive read the rest of
+	// the replies and return before the connection is closed.
+	s.psc.Unsubscribe()
+	s.wg.Wait()
+	s.psc.Close()
+}
+
+// ListenMessages subscribes to redis channel c and sends the messages
+// published to it, with their channel and payload, on the returned
+// message channel. Canceling ctx unsubscribes and stops the listener,
+// dropping any message the caller isn't waiting for. A subscription
+// error also stops it, and is sent on the returned error channel. Both
+// channels are closed once the listener has stopped.
+func ListenMessages(ctx context.Context, pool *redis.Pool, c string) (<-chan redis.Message, <-chan error) {
+	msgs := make(chan redis.Message)
+	errs := make(chan error, 1)
+	psc := redis.PubSubConn{Conn: pool.Get()}
+	if err := psc.Subscribe(c); err != nil {
+		psc.Close()
+		errs <- err
+		close(errs)
+		close(msgs)
+		return msgs, errs
+	}
+	done, unwatched := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer func() {
+			close(done)
+			<-unwatched
+			psc.Close()
+			close(msgs)
+			close(errs)
+		}()
+
+		for {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				select {
+				case msgs <- v:
+				case <-ctx.Done():
+				}
+			case redis.Subscription:
+				if v.Count == 0 {
+					return
+				}
+			case error:
+				if ctx.Err() == nil {
+					errs <- v
+				}
+				return
+			}
+		}
+	}()
+	// Unsubscribing rather than closing the connection lets the
+	// listener read the rest of the replies and stop cleanly.
+	go func() {
+		defer close(unwatched)
+		select {
+		case <-ctx.Done():
+			psc.Unsubscribe()
+		case <-done:
+		}
+	}()
+	return msgs, errs
+}
@@ -0,0 +1,159 @@
+package redistest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/rainforestapp/testutil/waitfor"
+)
+
+// pubsubConn is a redis.Conn that replies to Receive with the messages
+// sent on its channel, as if they were published to "events".
+type pubsubConn struct {
+	nopConn
+	msgs chan string
+	once *sync.Once
+}
+
+func (c pubsubConn) Receive() (interface{}, error) {
+	msg, ok := <-c.msgs
+	if !ok {
+		return nil, errors.New("closed")
+	}
+	return []interface{}{[]byte("message"), []byte("events"), []byte(msg)}, nil
+}
+
+// Send ends the messages when the subscriber, or the pool before
+// reusing the connection, unsubscribes.
+func (c pubsubConn) Send(cmd string, args ...interface{}) error {
+	if cmd == "UNSUBSCRIBE" {
+		c.once.Do(func() { close(c.msgs) })
+	}
+	return nil
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	conn := pubsubConn{msgs: make(chan string), once: &sync.Once{}}
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return conn, nil }}
+	logger := &recordingLogger{}
+	s := NewSlowSubscriber(pool, "events", 2, 50*time.Millisecond, WithLogger(logger))
+
+	// The first message is taken straight away, the next two wait in
+	// the buffer and the rest are dropped.
+	for i := 0; i < 6; i++ {
+		conn.msgs <- fmt.Sprint(i)
+		if i == 0 {
+			waitfor.Condition(func() bool { return s.Stats().Queued == 0 }, func() { t.Fatal("first message not taken") }, time.Second)
+		}
+	}
+	waitfor.Condition(func() bool { return s.Stats().Delivered == 3 }, func() {
+		t.Fatalf("Stats = %+v, want 3 delivered", s.Stats())
+	}, time.Second)
+	s.Close()
+
+	want := SubscriberStats{Received: 6, Delivered: 3, Dropped: 3, MaxQueued: 2}
+	if got := s.Stats(); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+	if got := fmt.Sprint(s.Messages()); got != "[0 1 2]" {
+		t.Errorf("Messages = %s", got)
+	}
+	if len(logger.errors) != 0 {
+		t.Errorf("logged %q after Close", logger.errors)
+	}
+}
+
+// subConn is a redis.Conn that acknowledges SUBSCRIBE and UNSUBSCRIBE
+// as a server would, and replies to Receive with what is sent on
+// replies in between.
+type subConn struct {
+	nopConn
+	replies chan interface{}
+	once    *sync.Once
+}
+
+func newSubConn() subConn {
+	return subConn{replies: make(chan interface{}, 10), once: &sync.Once{}}
+}
+
+func (c subConn) Receive() (interface{}, error) {
+	v, ok := <-c.replies
+	if !ok {
+		return nil, errors.New("closed")
+	}
+	if err, ok := v.(error); ok {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (c subConn) Send(cmd string, args ...interface{}) error {
+	switch cmd {
+	case "SUBSCRIBE":
+		c.replies <- []interface{}{[]byte("subscribe"), []byte("events"), int64(1)}
+	case "UNSUBSCRIBE":
+		c.once.Do(func() {
+			c.replies <- []interface{}{[]byte("unsubscribe"), []byte("events"), int64(0)}
+			close(c.replies)
+		})
+	}
+	return nil
+}
+
+func (c subConn) publish(msg string) {
+	c.replies <- []interface{}{[]byte("message"), []byte("events"), []byte(msg)}
+}
+
+func TestListenMessages(t *testing.T) {
+	conn := newSubConn()
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return conn, nil }}
+	ctx, cancel := context.WithCancel(context.Background())
+	msgs, errs := ListenMessages(ctx, pool, "events")
+
+	conn.publish("hello")
+	select {
+	case m := <-msgs:
+		if m.Channel != "events" || string(m.Data) != "hello" {
+			t.Errorf("received %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+
+	// A message nobody waits for doesn't hold up unsubscribing.
+	conn.publish("unread")
+	cancel()
+	for range msgs {
+	}
+	if err, ok := <-errs; ok {
+		t.Errorf("error after cancel: %v", err)
+	}
+}
+
+func TestListenMessagesError(t *testing.T) {
+	conn := newSubConn()
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) { return conn, nil }}
+	msgs, errs := ListenMessages(context.Background(), pool, "events")
+
+	conn.replies <- errors.New("connection reset")
+	if err := <-errs; err == nil || err.Error() != "connection reset" {
+		t.Errorf("error = %v", err)
+	}
+	if _, ok := <-msgs; ok {
+		t.Error("messages still open after an error")
+	}
+
+	pool = &redis.Pool{Dial: func() (redis.Conn, error) { return nil, errors.New("connection refused") }}
+	msgs, errs = ListenMessages(context.Background(), pool, "events")
+	if err := <-errs; err == nil || err.Error() != "connection refused" {
+		t.Errorf("subscribe error = %v", err)
+	}
+	if _, ok := <-msgs; ok {
+		t.Error("messages open after subscribing failed")
+	}
+}
@@ -0,0 +1,491 @@
+// Package redistest contains a redis fake and assertions for
+// integration-style tests. It requires a local redis server to be
+// installed and running, and unlike the testutil package it doesn't
+// depend on the AWS SDK, so importing it keeps test binaries small.
+package redistest
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	// Port is the port the redis server is expected on.
+	Port = "6379"
+
+	// DB is the default test database: the one claimed first, even
+	// if it holds data, and the one selected by default on
+	// connections from a dialer given with WithDialer.
+	DB = 9
+)
+
+// Logger is the interface the fake uses to report progress and
+// failures. *testing.T and *testing.B both satisfy it, as does
+// testutil.Logger.
+type Logger interface {
+	Logf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+// stdLogger is the default Logger. It writes to the standard log
+// package, so Fatalf exits the process.
+type stdLogger struct{}
+
+func (stdLogger) Logf(format string, args ...interface{}) {
+	log.Printf(format, args...)
+}
+
+func (stdLogger) Errorf(format string, args ...interface{}) {
+	log.Printf("ERROR: "+format, args...)
+}
+
+func (stdLogger) Fatalf(format string, args ...interface{}) {
+	log.Fatalf(format, args...)
+}
+
+// TestingT is the part of testing.TB the assertions use. *testing.T
+// and *testing.B satisfy it, as does testutil.TestingT.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Option configures a FakeRedis.
+type Option func(*options)
+
+type options struct {
+	logger         Logger
+	addr           string
+	db             int
+	testDBs        []int
+	dialAttempts   int
+	dialBackoff    time.Duration
+	strictTeardown bool
+	dial           func() (redis.Conn, error)
+	dialHint       func() string
+	dialHook       func() error
+	commandHook    func(conn redis.Conn, cmd string) error
+	observer       func(cmd string, d time.Duration)
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		logger:       stdLogger{},
+		addr:         ":" + Port,
+		db:           -1,
+		testDBs:      []int{DB},
+		dialAttempts: 5,
+		dialBackoff:  50 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithLogger sets the Logger used by the fake. It is usually passed the
+// current *testing.T so that failures are reported on that test.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithAddr sets the host:port of the redis server, for one started on
+// a port of its own. The default is Port on localhost.
+func WithAddr(addr string) Option {
+	return func(o *options) {
+		o.addr = addr
+	}
+}
+
+// WithDB makes the fake use database db rather than claiming a free
+// one. It is an escape hatch for tests that need a particular
+// database; nothing stops other tests using it at the same time. DB 0
+// is refused, since it is where redis keeps data by default.
+func WithDB(db int) Option {
+	return func(o *options) {
+		o.db = db
+	}
+}
+
+// WithTestDBs sets the databases the fake may claim and flush even if
+// they hold data, tried in order before any empty database. The
+// default is DB alone.
+func WithTestDBs(dbs ...int) Option {
+	return func(o *options) {
+		o.testDBs = dbs
+	}
+}
+
+// WithDialRetry sets how many times the fake tries to open a connection
+// before giving up, and the delay before the first retry. The delay
+// doubles after each failed attempt. The default is 5 attempts starting
+// at 50ms.
+func WithDialRetry(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		if attempts < 1 {
+			attempts = 1
+		}
+		o.dialAttempts = attempts
+		o.dialBackoff = backoff
+	}
+}
+
+// WithStrictTeardown makes Close report every key the test left
+// behind, and check that the test DB really is empty afterwards.
+func WithStrictTeardown() Option {
+	return func(o *options) {
+		o.strictTeardown = true
+	}
+}
+
+// WithDialer replaces how the fake opens connections, which by default
+// is by connecting to the address set with WithAddr and selecting the
+// fake's database. The fake then doesn't claim a database, and uses the
+// one set with WithDB, or DB. Connections are still tracked and hooked.
+func WithDialer(dial func() (redis.Conn, error)) Option {
+	return func(o *options) {
+		o.dial = dial
+	}
+}
+
+// WithDialHint adds the string hint returns to the error reported when
+// the fake can't connect, to say what to do about it.
+func WithDialHint(hint func() string) Option {
+	return func(o *options) {
+		o.dialHint = hint
+	}
+}
+
+// WithDialHook calls hook before each connection is opened, failing the
+// dial with its error if it returns one. It lets faults, such as a
+// network partition, be injected.
+func WithDialHook(hook func() error) Option {
+	return func(o *options) {
+		o.dialHook = hook
+	}
+}
+
+// WithCommandHook calls hook before each command is sent, failing the
+// command with its error if it returns one. The hook may close conn to
+// simulate a dropped connection.
+func WithCommandHook(hook func(conn redis.Conn, cmd string) error) Option {
+	return func(o *options) {
+		o.commandHook = hook
+	}
+}
+
+// WithObserver tells observe about each command sent and how long it
+// took, including any time spent in the command hook.
+func WithObserver(observe func(cmd string, d time.Duration)) Option {
+	return func(o *options) {
+		o.observer = observe
+	}
+}
+
+// FakeRedis holds a redis pool for for testing. It requires a local
+// redis server to be installed and running. Each FakeRedis claims a
+// database that no other FakeRedis, in this test binary or another, is
+// using, and flushes it before and after usage: one of the test
+// databases set with WithTestDBs, by default DB, whatever it holds, or
+// else an empty one from FirstDB to LastDB. So do not run against a
+// server where you need values from the test databases! Claims are
+// held by naming a connection, and write nothing to the server.
+type FakeRedis struct {
+	Pool *redis.Pool
+
+	// Addr is the host:port of the redis server.
+	Addr string
+
+	// DB is the database the fake's connections select.
+	DB int
+
+	logger       Logger
+	dialAttempts int
+	dialBackoff  time.Duration
+	dialHint     func() string
+	stats        poolStats
+	strict       bool
+	version      string
+	claim        *dbClaim
+}
+
+// New sets up a redis DB for testing and returns a pointer to a
+// FakeRedis object.
+func New(opts ...Option) *FakeRedis {
+	r, err := Start(opts...)
+	if err != nil {
+		newOptions(opts).logger.Fatalf("%v", err)
+	}
+	return r
+}
+
+// Start is like New, but returns an error if the test DB can't be
+// prepared, rather than reporting it to the Logger with Fatalf, which
+// by default exits the test binary.
+func Start(opts ...Option) (*FakeRedis, error) {
+	o := newOptions(opts)
+	r := &FakeRedis{
+		Addr:         o.addr,
+		logger:       o.logger,
+		dialAttempts: o.dialAttempts,
+		dialBackoff:  o.dialBackoff,
+		dialHint:     o.dialHint,
+		strict:       o.strictTeardown,
+	}
+	dial := o.dial
+	switch {
+	case o.db == 0:
+		// DB 0 is where redis keeps data by default.
+		return nil, fmt.Errorf("preparing redis test DB: DB 0 is redis's default DB and can't be used by a fake")
+	case o.db > 0:
+		r.DB = o.db
+	case dial != nil:
+		r.DB = DB
+	default:
+		conn, err := r.dialDB(0)
+		if err != nil {
+			return nil, fmt.Errorf("preparing redis test DB: %v", err)
+		}
+		if r.claim, err = claimDB(conn, o.testDBs); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		r.DB = r.claim.db
+	}
+	if dial == nil {
+		dial = r.dial
+	}
+	r.Pool = &redis.Pool{
+		// Returned connections are closed rather than kept idle, so
+		// the stats see every return; see PoolStats.
+		MaxIdle: 0,
+		Dial: func() (redis.Conn, error) {
+			if err := r.stats.dialHook(); err != nil {
+				return nil, err
+			}
+			c, err := dial()
+			if err != nil {
+				return nil, err
+			}
+			return r.stats.track(c), nil
+		},
+	}
+
+	c := r.Pool.Get()
+	_, err := c.Do("FLUSHDB")
+	if err == nil {
+		r.version = serverVersion(c)
+	}
+	c.Close()
+	if err != nil {
+		r.Pool.Close()
+		r.release()
+		return nil, fmt.Errorf("preparing redis test DB: %v", err)
+	}
+	// Faults are only injected once the test DB is ready.
+	r.stats.setHooks(o.dialHook, o.commandHook, o.observer)
+
+	return r, nil
+}
+
+// dial connects to redis and selects the test DB.
+func (r *FakeRedis) dial() (redis.Conn, error) {
+	return r.dialDB(r.DB)
+}
+
+// dialDB connects to redis and selects db. Connection failures are
+// retried with exponential backoff; if every attempt fails the failure
+// is reported to the Logger and the last error is returned to the pool
+// rather than exiting.
+func (r *FakeRedis) dialDB(db int) (redis.Conn, error) {
+	var errs []string
+	backoff := r.dialBackoff
+	for attempt := 1; attempt <= r.dialAttempts; attempt++ {
+		c, err := redis.Dial("tcp", r.Addr)
+		if err == nil {
+			_, err = c.Do("SELECT", db)
+			if err == nil {
+				return c, nil
+			}
+			c.Close()
+		}
+		errs = append(errs, fmt.Sprintf("attempt %d: %v", attempt, err))
+		if attempt < r.dialAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	var hint string
+	if r.dialHint != nil {
+		hint = r.dialHint()
+	}
+	err := fmt.Errorf("could not connect to redis at %s after %d attempts (is it running?):\n\t%s%s",
+		r.Addr, r.dialAttempts, strings.Join(errs, "\n\t"), hint)
+	r.logger.Errorf("%v", err)
+	return nil, err
+}
+
+// Close cleans up after a redis test. With WithStrictTeardown it
+// first reports any keys the test left behind.
+func (r *FakeRedis) Close() {
+	if r.strict {
+		r.verifyTeardown()
+	}
+	conn := r.Pool.Get()
+	conn.Do("FLUSHDB")
+	if r.strict {
+		r.verifyFlushed(conn)
+	}
+	conn.Close()
+
+	r.Pool.Close()
+	r.release()
+}
+
+// Claimed reports whether the fake claimed a free database, rather
+// than being given one with WithDB or WithDialer. No other test uses a
+// claimed database while the fake is open.
+func (r *FakeRedis) Claimed() bool {
+	return r.claim != nil
+}
+
+// release gives up the database the fake claimed, if any, for other
+// tests to use.
+func (r *FakeRedis) release() {
+	if r.claim == nil {
+		return
+	}
+	if err := r.claim.release(); err != nil {
+		r.logger.Errorf("FakeRedis: releasing redis DB %d: %v", r.DB, err)
+	}
+	r.claim = nil
+}
+
+// Reset empties the test DB.
+func (r *FakeRedis) Reset() {
+	conn := r.Pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("FLUSHDB"); err != nil {
+		r.logger.Errorf("Error resetting redis test DB: %v", err)
+	}
+}
+
+// ServerVersion returns the version of the redis server, or "" if it
+// couldn't be read.
+func (r *FakeRedis) ServerVersion() string {
+	return r.version
+}
+
+// serverVersion returns the version of the server conn is connected to,
+// or "".
+func serverVersion(conn redis.Conn) string {
+	info, err := redis.String(conn.Do("INFO", "server"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(info, "\n") {
+		if strings.HasPrefix(line, "redis_version:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "redis_version:"))
+		}
+	}
+	return ""
+}
+
+// Keys returns every key in the test DB, sorted, for which keep returns
+// true. A nil keep keeps every key.
+func (r *FakeRedis) Keys(keep func(key string) bool) ([]string, error) {
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	var ret []string
+	cursor := "0"
+	for {
+		vs, err := redis.Values(conn.Do("SCAN", cursor, "COUNT", 1000))
+		if err != nil {
+			return nil, err
+		}
+		if len(vs) != 2 {
+			return nil, fmt.Errorf("unexpected SCAN reply %v", vs)
+		}
+		if cursor, err = redis.String(vs[0], nil); err != nil {
+			return nil, err
+		}
+		keys, err := redis.Strings(vs[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			if keep == nil || keep(k) {
+				ret = append(ret, k)
+			}
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// verifyTeardown reports the keys left in the test DB, which were all
+// created since New flushed it. It is called before Close flushes the
+// DB again.
+func (r *FakeRedis) verifyTeardown() {
+	keys, err := r.Keys(nil)
+	if err != nil {
+		r.logger.Errorf("FakeRedis: could not check for keys left behind: %v", err)
+		return
+	}
+	if len(keys) > 0 {
+		r.logger.Errorf("FakeRedis: %d resource(s) left behind after teardown:\n  redis key %s",
+			len(keys), strings.Join(keys, "\n  redis key "))
+	}
+}
+
+// verifyFlushed checks that the test DB is empty after Close flushed
+// it.
+func (r *FakeRedis) verifyFlushed(conn redis.Conn) {
+	n, err := redis.Int(conn.Do("DBSIZE"))
+	if err != nil {
+		r.logger.Errorf("FakeRedis: could not check that the test DB was flushed: %v", err)
+	} else if n > 0 {
+		r.logger.Errorf("FakeRedis: %d key(s) remain in the test DB after flushing", n)
+	}
+}
+
+// ListenChan subscribes to redis channel c and signals the returned
+// channel when it receives messages. Subscription errors are reported
+// to the configured Logger and stop the listener. It listens until the
+// test binary exits; ListenMessages gives the payloads and can be
+// stopped.
+func ListenChan(pool *redis.Pool, c string, opts ...Option) chan struct{} {
+	o := newOptions(opts)
+	ret := make(chan struct{})
+	go func() {
+		psc := redis.PubSubConn{Conn: pool.Get()}
+		defer psc.Close()
+
+		psc.Subscribe(c)
+		for {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				ret <- struct{}{}
+			case redis.Subscription:
+			case error:
+				o.logger.Errorf("Subscription error: %v", v)
+				return
+			}
+		}
+	}()
+
+	return ret
+}
@@ -0,0 +1,124 @@
+package redistest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// recordingT is a TestingT that records errors instead of failing.
+type recordingT struct {
+	errors []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+// recordingLogger is a Logger that records errors instead of failing.
+type recordingLogger struct {
+	mu     sync.Mutex
+	errors []string
+}
+
+func (l *recordingLogger) Logf(format string, args ...interface{}) {}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Fatalf(format string, args ...interface{}) {
+	l.Errorf(format, args...)
+}
+
+// infoConn is a redis.Conn that replies to every command with info.
+type infoConn struct {
+	nopConn
+	info string
+}
+
+func (c infoConn) Do(string, ...interface{}) (interface{}, error) {
+	return []byte(c.info), nil
+}
+
+func TestServerVersion(t *testing.T) {
+	conn := infoConn{info: "# Server\r\nredis_version:7.2.4\r\nredis_git_sha1:00000000\r\n"}
+	if got := serverVersion(conn); got != "7.2.4" {
+		t.Errorf("serverVersion = %q, want 7.2.4", got)
+	}
+	if got := serverVersion(nopConn{}); got != "" {
+		t.Errorf("serverVersion without INFO = %q", got)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var dialErr error
+	var cmds []string
+	r := New(
+		WithDialer(func() (redis.Conn, error) { return nopConn{}, nil }),
+		WithDialHook(func() error { return dialErr }),
+		WithCommandHook(func(conn redis.Conn, cmd string) error {
+			if cmd == "FAIL" {
+				return errors.New("injected")
+			}
+			return nil
+		}),
+		WithObserver(func(cmd string, d time.Duration) { cmds = append(cmds, cmd) }),
+	)
+	defer r.Close()
+
+	conn := r.Pool.Get()
+	if _, err := conn.Do("GET", "a"); err != nil {
+		t.Errorf("GET failed: %v", err)
+	}
+	if _, err := conn.Do("FAIL"); err == nil || err.Error() != "injected" {
+		t.Errorf("FAIL returned %v, want the injected error", err)
+	}
+	conn.Close()
+	if got := fmt.Sprint(cmds); got != "[GET FAIL]" {
+		t.Errorf("observed %s, want the commands after New, including failed ones", got)
+	}
+
+	dialErr = errors.New("partitioned")
+	a, b := r.Pool.Get(), r.Pool.Get()
+	defer a.Close()
+	defer b.Close()
+	if err := b.Err(); err != dialErr {
+		t.Errorf("second connection's Err() = %v, want the dial hook's error", err)
+	}
+}
+
+func TestStartError(t *testing.T) {
+	l := &recordingLogger{}
+	r, err := Start(WithLogger(l), WithDialer(func() (redis.Conn, error) { return nil, errors.New("connection refused") }))
+	if r != nil || err == nil || err.Error() != "preparing redis test DB: connection refused" {
+		t.Errorf("Start = %v, %v", r, err)
+	}
+	if len(l.errors) != 0 {
+		t.Errorf("Start reported %q to the Logger", l.errors)
+	}
+}
+
+func TestWithAddr(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	r, err := Start(WithAddr(addr), WithDialRetry(1, 0), WithLogger(&recordingLogger{}))
+	if r != nil || err == nil || !strings.Contains(err.Error(), "redis at "+addr) {
+		t.Errorf("Start = %v, %v; want an error naming %s", r, err, addr)
+	}
+}
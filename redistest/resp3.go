@@ -0,0 +1,295 @@
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RESP3Conn is a minimal redis connection speaking RESP3, the protocol
+// needed for client-side caching: with tracking enabled, the server
+// sends invalidation push messages on the same connection, which
+// redigo can't receive. It's meant for asserting on those messages,
+// not as a general purpose client.
+//
+// Replies are decoded to Go values: simple and bulk strings to string,
+// integers to int64, doubles to float64, booleans to bool, null to
+// nil, arrays and sets to []interface{} and maps to
+// map[string]interface{}. Error replies are returned as RESP3Error.
+type RESP3Conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	mu     sync.Mutex
+	pushes [][]interface{}
+}
+
+// RESP3Error is an error reply from redis.
+type RESP3Error string
+
+func (e RESP3Error) Error() string { return string(e) }
+
+// DialRESP3 opens a RESP3 connection to the redis test DB.
+func (r *FakeRedis) DialRESP3() (*RESP3Conn, error) {
+	conn, err := net.Dial("tcp", r.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := newRESP3Conn(conn)
+	if _, err := c.Do("HELLO", "3"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("switching to RESP3 (redis 6 or later is required): %v", err)
+	}
+	if _, err := c.Do("SELECT", r.DB); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func newRESP3Conn(conn net.Conn) *RESP3Conn {
+	return &RESP3Conn{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// Close closes the connection.
+func (c *RESP3Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Do sends a command and returns its reply. Push messages that arrive
+// before the reply are kept for Pushes and the invalidation helpers.
+func (c *RESP3Conn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args)+1)
+	for _, a := range append([]interface{}{cmd}, args...) {
+		s := fmt.Sprint(a)
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(s), s)
+	}
+	c.conn.SetDeadline(time.Time{})
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		return nil, err
+	}
+
+	for {
+		v, push, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		if push {
+			c.pushes = append(c.pushes, v.([]interface{}))
+			continue
+		}
+		if e, ok := v.(RESP3Error); ok {
+			return nil, e
+		}
+		return v, nil
+	}
+}
+
+// EnableTracking turns on client-side caching for the connection with
+// CLIENT TRACKING ON, followed by any options such as "BCAST" or
+// "PREFIX", "user:".
+func (c *RESP3Conn) EnableTracking(options ...interface{}) error {
+	_, err := c.Do("CLIENT", append([]interface{}{"TRACKING", "ON"}, options...)...)
+	return err
+}
+
+// Pushes returns every push message received so far.
+func (c *RESP3Conn) Pushes() [][]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]interface{}(nil), c.pushes...)
+}
+
+// Invalidations returns the keys named in invalidation messages
+// received so far, waiting up to wait for more to arrive first. A
+// flush of the whole database is reported as a nil key list, which
+// appears here as the single key "*".
+func (c *RESP3Conn) Invalidations(wait time.Duration) ([]string, error) {
+	if err := c.receivePushes(wait); err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, p := range c.Pushes() {
+		if len(p) != 2 || p[0] != "invalidate" {
+			continue
+		}
+		ks, ok := p[1].([]interface{})
+		if !ok {
+			keys = append(keys, "*")
+			continue
+		}
+		for _, k := range ks {
+			keys = append(keys, fmt.Sprint(k))
+		}
+	}
+	return keys, nil
+}
+
+// receivePushes reads push messages until wait elapses.
+func (c *RESP3Conn) receivePushes(wait time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.conn.SetDeadline(time.Time{})
+
+	deadline := time.Now().Add(wait)
+	for {
+		c.conn.SetReadDeadline(deadline)
+		v, push, err := c.read()
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !push {
+			return fmt.Errorf("unexpected reply %v while waiting for push messages", v)
+		}
+		c.pushes = append(c.pushes, v.([]interface{}))
+	}
+}
+
+// AssertInvalidated checks that invalidation messages for each of keys
+// arrive within timeout.
+func (c *RESP3Conn) AssertInvalidated(t TestingT, timeout time.Duration, keys ...string) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		got, err := c.Invalidations(10 * time.Millisecond)
+		if err != nil {
+			t.Errorf("reading invalidation messages: %v", err)
+			return
+		}
+		missing := missingKeys(got, keys)
+		if len(missing) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("no invalidation for %q within %v (invalidated: %q)", missing, timeout, got)
+			return
+		}
+	}
+}
+
+// AssertNotInvalidated checks that no invalidation message for key
+// arrives within wait.
+func (c *RESP3Conn) AssertNotInvalidated(t TestingT, wait time.Duration, key string) {
+	t.Helper()
+	got, err := c.Invalidations(wait)
+	if err != nil {
+		t.Errorf("reading invalidation messages: %v", err)
+		return
+	}
+	if len(missingKeys(got, []string{key})) == 0 {
+		t.Errorf("redis key %q was invalidated, want it not to be", key)
+	}
+}
+
+func missingKeys(got, want []string) []string {
+	seen := make(map[string]bool, len(got))
+	for _, k := range got {
+		seen[k] = true
+	}
+	var missing []string
+	for _, k := range want {
+		if !seen[k] && !seen["*"] {
+			missing = append(missing, k)
+		}
+	}
+	return missing
+}
+
+// read reads one RESP3 value, reporting whether it was a push message.
+func (c *RESP3Conn) read() (v interface{}, push bool, err error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, false, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, false, fmt.Errorf("malformed RESP3 line %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+', '(':
+		return body, false, nil
+	case '-':
+		return RESP3Error(body), false, nil
+	case ':':
+		n, err := strconv.ParseInt(body, 10, 64)
+		return n, false, err
+	case ',':
+		f, err := strconv.ParseFloat(body, 64)
+		return f, false, err
+	case '#':
+		return body == "t", false, nil
+	case '_':
+		return nil, false, nil
+	case '$', '!', '=':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, false, err
+		}
+		if n < 0 {
+			return nil, false, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, false, err
+		}
+		s := string(buf[:n])
+		switch kind {
+		case '!':
+			return RESP3Error(s), false, nil
+		case '=':
+			// Verbatim strings are prefixed with a format such as "txt:".
+			if len(s) >= 4 {
+				s = s[4:]
+			}
+		}
+		return s, false, nil
+	case '*', '~', '>', '%', '|':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, false, err
+		}
+		if n < 0 {
+			return nil, false, nil
+		}
+		if kind == '%' || kind == '|' {
+			m := make(map[string]interface{}, n)
+			for i := 0; i < n; i++ {
+				k, _, err := c.read()
+				if err != nil {
+					return nil, false, err
+				}
+				v, _, err := c.read()
+				if err != nil {
+					return nil, false, err
+				}
+				m[fmt.Sprint(k)] = v
+			}
+			if kind == '|' {
+				// Attributes annotate the reply that follows them.
+				return c.read()
+			}
+			return m, false, nil
+		}
+		vs := make([]interface{}, n)
+		for i := range vs {
+			if vs[i], _, err = c.read(); err != nil {
+				return nil, false, err
+			}
+		}
+		return vs, kind == '>', nil
+	}
+	return nil, false, fmt.Errorf("unknown RESP3 type %q", kind)
+}
@@ -0,0 +1,79 @@
+package redistest
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeRESP3Server answers each command read from conn with the next of
+// replies, written verbatim.
+func fakeRESP3Server(conn net.Conn, replies ...string) {
+	c := newRESP3Conn(conn)
+	for _, reply := range replies {
+		if _, _, err := c.read(); err != nil {
+			return
+		}
+		conn.Write([]byte(reply))
+	}
+}
+
+func TestRESP3ConnDo(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go fakeRESP3Server(server,
+		"%2\r\n+server\r\n$5\r\nredis\r\n+proto\r\n:3\r\n",
+		">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n$3\r\nbar\r\n",
+		"-ERR wrong type\r\n",
+		"*4\r\n,1.5\r\n#t\r\n_\r\n=8\r\ntxt:text\r\n",
+	)
+	c := newRESP3Conn(client)
+
+	v, err := c.Do("HELLO", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"server": "redis", "proto": int64(3)}; !reflect.DeepEqual(v, want) {
+		t.Errorf("HELLO = %#v, want %#v", v, want)
+	}
+
+	v, err = c.Do("GET", "foo")
+	if err != nil || v != "bar" {
+		t.Errorf("GET = %#v, %v", v, err)
+	}
+	if got := c.Pushes(); len(got) != 1 {
+		t.Errorf("expected the invalidation before the reply to be kept, got %v", got)
+	}
+
+	if _, err := c.Do("INCR", "foo"); err != RESP3Error("ERR wrong type") {
+		t.Errorf("expected error reply, got %v", err)
+	}
+
+	v, err = c.Do("X")
+	if want := []interface{}{1.5, true, nil, "text"}; err != nil || !reflect.DeepEqual(v, want) {
+		t.Errorf("X = %#v, %v, want %#v", v, err, want)
+	}
+}
+
+func TestRESP3ConnAssertInvalidated(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		server.Write([]byte(">2\r\n$10\r\ninvalidate\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n"))
+	}()
+	c := newRESP3Conn(client)
+
+	c.AssertInvalidated(t, time.Second, "a", "b")
+
+	rt := &recordingT{}
+	c.AssertNotInvalidated(rt, 10*time.Millisecond, "a")
+	if len(rt.errors) != 1 {
+		t.Errorf("expected invalidated key to be reported, got %v", rt.errors)
+	}
+	rt = &recordingT{}
+	c.AssertInvalidated(rt, 10*time.Millisecond, "c")
+	if len(rt.errors) != 1 {
+		t.Errorf("expected missing invalidation to be reported, got %v", rt.errors)
+	}
+}
@@ -0,0 +1,287 @@
+package redistest
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ScriptedRedisConn is a redis.Conn that answers commands with canned
+// replies and records them, for unit tests of code wrapping redigo on
+// machines without redis-server. Commands with no reply scripted fail
+// with an error.
+//
+// Replies are given as Go values and converted to what redigo returns
+// from a server: strings and numbers become bulk strings, except that
+// ints become integers; slices become arrays; a redis.Error is
+// returned as both the reply and the error; and any other error is
+// returned as a connection error.
+type ScriptedRedisConn struct {
+	mu      sync.Mutex
+	replies []scriptedReply
+	calls   []RedisCall
+	pending []RedisCall
+	closed  bool
+}
+
+type scriptedReply struct {
+	pattern *regexp.Regexp
+	reply   func(args []interface{}) interface{}
+}
+
+// RedisCall is a command received by a ScriptedRedisConn.
+type RedisCall struct {
+	Command string
+	Args    []interface{}
+}
+
+// String returns the command as it would be typed into redis-cli.
+func (c RedisCall) String() string {
+	parts := []string{c.Command}
+	for _, arg := range c.Args {
+		parts = append(parts, argString(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+func argString(arg interface{}) string {
+	switch arg := arg.(type) {
+	case []byte:
+		return string(arg)
+	case float64:
+		return strconv.FormatFloat(arg, 'g', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(arg)
+	}
+}
+
+// NewScriptedRedisConn returns a ScriptedRedisConn with no replies
+// scripted.
+func NewScriptedRedisConn() *ScriptedRedisConn {
+	return &ScriptedRedisConn{}
+}
+
+// On scripts reply as the answer to commands matching pattern. A
+// pattern with only a command name, such as "GET", matches every call
+// to that command; otherwise it matches the command and its arguments
+// joined by spaces, where * matches any run of characters, as in
+// "HGET user:* name". Command names are case-insensitive. When several
+// patterns match, the one scripted last wins.
+func (c *ScriptedRedisConn) On(pattern string, reply interface{}) *ScriptedRedisConn {
+	return c.OnFunc(pattern, func([]interface{}) interface{} { return reply })
+}
+
+// OnFunc is like On, but computes the reply from the command's
+// arguments.
+func (c *ScriptedRedisConn) OnFunc(pattern string, reply func(args []interface{}) interface{}) *ScriptedRedisConn {
+	fields := strings.SplitN(pattern, " ", 2)
+	expr := regexp.QuoteMeta(strings.ToUpper(fields[0]))
+	if len(fields) == 1 {
+		expr += "( .*)?"
+	} else {
+		expr += " " + strings.Replace(regexp.QuoteMeta(fields[1]), `\*`, ".*", -1)
+	}
+	re := regexp.MustCompile("^" + expr + "$")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.replies = append(c.replies, scriptedReply{re, reply})
+	return c
+}
+
+// Calls returns the commands received so far, in order.
+func (c *ScriptedRedisConn) Calls() []RedisCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]RedisCall(nil), c.calls...)
+}
+
+// AssertCalled fails t unless a command matching pattern, as for On,
+// was received.
+func (c *ScriptedRedisConn) AssertCalled(t TestingT, pattern string) {
+	t.Helper()
+	probe := NewScriptedRedisConn().On(pattern, nil)
+	for _, call := range c.Calls() {
+		if probe.match(call) != nil {
+			return
+		}
+	}
+	t.Errorf("no command matching %q in %q", pattern, c.Calls())
+}
+
+// Reset forgets the commands received, keeping the script.
+func (c *ScriptedRedisConn) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls, c.pending = nil, nil
+}
+
+// match returns the reply scripted for call, or nil. c.mu must be held
+// unless c isn't shared.
+func (c *ScriptedRedisConn) match(call RedisCall) *scriptedReply {
+	line := strings.ToUpper(call.Command)
+	for _, arg := range call.Args {
+		line += " " + argString(arg)
+	}
+	for i := len(c.replies) - 1; i >= 0; i-- {
+		if c.replies[i].pattern.MatchString(line) {
+			return &c.replies[i]
+		}
+	}
+	return nil
+}
+
+// reply records call and returns its scripted reply. c.mu must be held.
+func (c *ScriptedRedisConn) reply(call RedisCall) (interface{}, error) {
+	c.calls = append(c.calls, call)
+	r := c.match(call)
+	if r == nil {
+		return nil, fmt.Errorf("redistest: no reply scripted for %s", call)
+	}
+	reply := scriptedValue(r.reply(call.Args))
+	switch reply := reply.(type) {
+	case redis.Error:
+		return reply, reply
+	case error:
+		return nil, reply
+	}
+	return reply, nil
+}
+
+// scriptedValue converts a canned reply to the value redigo would
+// return for it.
+func scriptedValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	case string:
+		return []byte(v)
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'g', -1, 64))
+	case time.Duration:
+		return int64(v / time.Second)
+	case []string:
+		ret := make([]interface{}, len(v))
+		for i, s := range v {
+			ret[i] = []byte(s)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, x := range v {
+			ret[i] = scriptedValue(x)
+		}
+		return ret
+	}
+	return v
+}
+
+var errScriptedClosed = errors.New("redistest: use of closed ScriptedRedisConn")
+
+// Do answers a command from the script, after the replies to any
+// commands sent with Send. As with a real connection, Do("") returns
+// just the replies to the commands sent.
+func (c *ScriptedRedisConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, errScriptedClosed
+	}
+	pending := c.pending
+	c.pending = nil
+	var replies []interface{}
+	for _, call := range pending {
+		reply, err := c.reply(call)
+		if err != nil {
+			if _, ok := err.(redis.Error); !ok {
+				return nil, err
+			}
+		}
+		replies = append(replies, reply)
+	}
+	if cmd == "" {
+		return replies, nil
+	}
+	return c.reply(RedisCall{cmd, args})
+}
+
+// Send queues a command to be answered by Receive or Do.
+func (c *ScriptedRedisConn) Send(cmd string, args ...interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errScriptedClosed
+	}
+	c.pending = append(c.pending, RedisCall{cmd, args})
+	return nil
+}
+
+// Flush does nothing; commands sent are answered as they are received.
+func (c *ScriptedRedisConn) Flush() error {
+	return c.Err()
+}
+
+// Receive answers the oldest command sent with Send.
+func (c *ScriptedRedisConn) Receive() (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, errScriptedClosed
+	}
+	if len(c.pending) == 0 {
+		return nil, errors.New("redistest: Receive with no commands sent")
+	}
+	call := c.pending[0]
+	c.pending = c.pending[1:]
+	return c.reply(call)
+}
+
+// Err returns an error once the connection is closed.
+func (c *ScriptedRedisConn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errScriptedClosed
+	}
+	return nil
+}
+
+// Close closes the connection. Commands sent after it fail.
+func (c *ScriptedRedisConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+// ScriptedPool returns a redis.Pool whose connections all share c's
+// script and call record. Closing them returns them to the pool
+// without closing c.
+func ScriptedPool(c *ScriptedRedisConn) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle: 1,
+		Dial: func() (redis.Conn, error) {
+			return scriptedPoolConn{c}, nil
+		},
+	}
+}
+
+// scriptedPoolConn is a ScriptedRedisConn that can be closed without
+// closing the conn it wraps.
+type scriptedPoolConn struct {
+	*ScriptedRedisConn
+}
+
+func (scriptedPoolConn) Close() error { return nil }
@@ -0,0 +1,83 @@
+package redistest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func TestScriptedRedisConn(t *testing.T) {
+	c := NewScriptedRedisConn().
+		On("GET", nil).
+		On("GET user:*", "alice").
+		On("INCR", 3).
+		On("LPUSH jobs *", redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value")).
+		On("LRANGE", []string{"a", "b"})
+
+	if v, err := redis.String(c.Do("GET", "user:1")); err != nil || v != "alice" {
+		t.Errorf("GET user:1 = %q, %v", v, err)
+	}
+	if _, err := redis.String(c.Do("get", "other")); err != redis.ErrNil {
+		t.Errorf("GET other: err = %v, want ErrNil", err)
+	}
+	if n, err := redis.Int(c.Do("INCR", "n")); err != nil || n != 3 {
+		t.Errorf("INCR = %d, %v", n, err)
+	}
+	if _, err := c.Do("LPUSH", "jobs", "x"); err == nil || err.Error()[:9] != "WRONGTYPE" {
+		t.Errorf("LPUSH err = %v", err)
+	}
+	if _, err := c.Do("DEL", "x"); err == nil {
+		t.Error("unscripted DEL succeeded")
+	}
+
+	c.Send("INCR", "n")
+	c.Send("LRANGE", "l", 0, -1)
+	c.Flush()
+	if n, err := redis.Int(c.Receive()); err != nil || n != 3 {
+		t.Errorf("received INCR = %d, %v", n, err)
+	}
+	if l, err := redis.Strings(c.Receive()); err != nil || len(l) != 2 || l[1] != "b" {
+		t.Errorf("received LRANGE = %q, %v", l, err)
+	}
+
+	if got := len(c.Calls()); got != 7 {
+		t.Errorf("recorded %d calls, want 7: %v", got, c.Calls())
+	}
+	if got := c.Calls()[6].String(); got != "LRANGE l 0 -1" {
+		t.Errorf("last call = %q", got)
+	}
+	rt := &recordingT{}
+	c.AssertCalled(rt, "INCR n")
+	c.AssertCalled(rt, "SET *")
+	if len(rt.errors) != 1 {
+		t.Errorf("AssertCalled failures = %q, want one for SET", rt.errors)
+	}
+}
+
+func TestScriptedPool(t *testing.T) {
+	c := NewScriptedRedisConn().OnFunc("ECHO", func(args []interface{}) interface{} {
+		if len(args) == 0 {
+			return errors.New("connection reset")
+		}
+		return args[0]
+	})
+	pool := ScriptedPool(c)
+	defer pool.Close()
+
+	for i := 0; i < 2; i++ {
+		conn := pool.Get()
+		if v, err := redis.String(conn.Do("ECHO", "hi")); err != nil || v != "hi" {
+			t.Errorf("ECHO = %q, %v", v, err)
+		}
+		conn.Close()
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("ECHO"); err == nil || err.Error() != "connection reset" {
+		t.Errorf("ECHO with no args: err = %v", err)
+	}
+	if c.Err() != nil {
+		t.Error("closing a pooled connection closed the script")
+	}
+}
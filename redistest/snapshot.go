@@ -0,0 +1,86 @@
+package redistest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// savedKey is a key as stored by Save.
+type savedKey struct {
+	Key string `json:"key"`
+	// TTL is the key's remaining time to live in milliseconds, or 0 if
+	// it doesn't expire.
+	TTL int64 `json:"ttl,omitempty"`
+	// Dump is the key's value serialized by DUMP.
+	Dump []byte `json:"dump"`
+}
+
+// Save writes every key in the test DB, with its remaining time to
+// live, to dir/redis.json. Values are stored in redis's DUMP format,
+// which newer servers can restore but older ones may not.
+func (r *FakeRedis) Save(dir string) error {
+	keys, err := r.Keys(nil)
+	if err != nil {
+		return fmt.Errorf("saving redis: %v", err)
+	}
+	conn := r.Pool.Get()
+	defer conn.Close()
+
+	saved := []savedKey{}
+	for _, k := range keys {
+		dump, err := redis.Bytes(conn.Do("DUMP", k))
+		if err == redis.ErrNil {
+			// Expired since it was listed.
+			continue
+		} else if err != nil {
+			return fmt.Errorf("saving redis key %s: %v", k, err)
+		}
+		ttl, err := redis.Int64(conn.Do("PTTL", k))
+		if err != nil {
+			return fmt.Errorf("saving redis key %s: %v", k, err)
+		}
+		if ttl < 0 {
+			ttl = 0
+		}
+		saved = append(saved, savedKey{Key: k, TTL: ttl, Dump: dump})
+	}
+	b, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "redis.json"), append(b, '\n'), 0644)
+}
+
+// Load empties the test DB and restores the keys saved in
+// dir/redis.json. A missing file loads an empty DB.
+func (r *FakeRedis) Load(dir string) error {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "redis.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var saved []savedKey
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &saved); err != nil {
+			return fmt.Errorf("loading redis: %v", err)
+		}
+	}
+	conn := r.Pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("FLUSHDB"); err != nil {
+		return fmt.Errorf("loading redis: %v", err)
+	}
+	for _, k := range saved {
+		if _, err := conn.Do("RESTORE", k.Key, k.TTL, k.Dump); err != nil {
+			return fmt.Errorf("loading redis key %s: %v", k.Key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package redistest
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// mapConn is a redis.Conn backed by a map, supporting just the
+// commands Save and Load use. DUMP serializes a value as its bytes.
+type mapConn struct {
+	nopConn
+	values map[string]string
+	ttls   map[string]int64
+}
+
+func (c mapConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	switch cmd {
+	case "FLUSHDB":
+		for k := range c.values {
+			delete(c.values, k)
+			delete(c.ttls, k)
+		}
+		return "OK", nil
+	case "SCAN":
+		keys := []interface{}{}
+		for k := range c.values {
+			keys = append(keys, []byte(k))
+		}
+		return []interface{}{[]byte("0"), keys}, nil
+	case "DUMP":
+		v, ok := c.values[args[0].(string)]
+		if !ok {
+			return nil, nil
+		}
+		return []byte(v), nil
+	case "PTTL":
+		if ttl, ok := c.ttls[args[0].(string)]; ok {
+			return ttl, nil
+		}
+		return int64(-1), nil
+	case "RESTORE":
+		k := args[0].(string)
+		c.values[k] = string(args[2].([]byte))
+		if ttl := args[1].(int64); ttl > 0 {
+			c.ttls[k] = ttl
+		}
+		return "OK", nil
+	}
+	return nil, nil
+}
+
+func TestSaveLoad(t *testing.T) {
+	conn := mapConn{values: make(map[string]string), ttls: make(map[string]int64)}
+	r := New(WithDialer(func() (redis.Conn, error) { return conn, nil }))
+	defer r.Close()
+
+	conn.values["a"] = "1"
+	conn.values["b"] = "2"
+	conn.ttls["b"] = 5000
+	dir := t.TempDir()
+	if err := r.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Do("FLUSHDB")
+	conn.values["stale"] = "x"
+	if err := r.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for k, v := range conn.values {
+		keys = append(keys, k+"="+v)
+	}
+	sort.Strings(keys)
+	if got := fmt.Sprint(keys); got != "[a=1 b=2]" {
+		t.Errorf("loaded %s, want [a=1 b=2]", got)
+	}
+	if conn.ttls["b"] != 5000 || conn.ttls["a"] != 0 {
+		t.Errorf("loaded TTLs %v, want b's only", conn.ttls)
+	}
+
+	if err := r.Load(t.TempDir()); err != nil {
+		t.Errorf("loading a missing file: %v", err)
+	}
+	if len(conn.values) != 0 {
+		t.Errorf("DB not emptied by loading nothing: %v", conn.values)
+	}
+}
@@ -0,0 +1,196 @@
+package redistest
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// Torture concurrently exercises a redis pool with a mix of GET, SET,
+// INCR, MULTI/EXEC transactions and pub/sub, checking that every reply
+// is consistent with what was written. Run it with -race against the
+// pool used by a wrapper under test to flush out synchronization bugs
+// such as shared connections or mixed-up replies.
+type Torture struct {
+	// Workers is the number of goroutines issuing commands. It
+	// defaults to 32.
+	Workers int
+
+	// KeyPrefix is prepended to every key and channel used. It
+	// defaults to "torture:".
+	KeyPrefix string
+
+	// Extra operations, such as calls into the wrapper under test, are
+	// mixed in with the built-in ones.
+	Extra []func(conn redis.Conn) error
+}
+
+// TortureStats counts what a Torture run did.
+type TortureStats struct {
+	Operations   int64
+	Transactions int64
+	Published    int64
+	Received     int64
+	Errors       int64
+}
+
+// RunTorture runs a Torture with the default settings against pool for
+// d, reporting any errors or inconsistencies on t.
+func RunTorture(t TestingT, pool *redis.Pool, d time.Duration) TortureStats {
+	t.Helper()
+	return (&Torture{}).Run(t, pool, d)
+}
+
+// Run exercises pool for d, reporting any errors or inconsistencies on
+// t. The keys it uses are deleted afterwards.
+func (rt *Torture) Run(t TestingT, pool *redis.Pool, d time.Duration) TortureStats {
+	t.Helper()
+	workers := rt.Workers
+	if workers < 1 {
+		workers = 32
+	}
+	prefix := rt.KeyPrefix
+	if prefix == "" {
+		prefix = "torture:"
+	}
+	counterKey := prefix + "counter"
+	channel := prefix + "channel"
+
+	var stats TortureStats
+	var incrs int64
+	var errMu sync.Mutex
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		atomic.AddInt64(&stats.Errors, 1)
+		errMu.Lock()
+		if len(errs) < 10 {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+		errMu.Unlock()
+	}
+
+	// Subscriber
+	psc := redis.PubSubConn{Conn: pool.Get()}
+	if err := psc.Subscribe(channel); err != nil {
+		t.Errorf("subscribing to %s: %v", channel, err)
+		psc.Close()
+		return stats
+	}
+	subDone := make(chan struct{})
+	go func() {
+		defer close(subDone)
+		for {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				atomic.AddInt64(&stats.Received, 1)
+			case redis.Subscription:
+				if v.Count == 0 {
+					return
+				}
+			case error:
+				return
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(d)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(w)))
+			key := fmt.Sprintf("%sworker:%d", prefix, w)
+			listKey := fmt.Sprintf("%slist:%d", prefix, w)
+
+			for i := 0; time.Now().Before(deadline); i++ {
+				conn := pool.Get()
+				switch n := rnd.Intn(5 + len(rt.Extra)); n {
+				case 0:
+					want := fmt.Sprintf("%d:%d", w, i)
+					if _, err := conn.Do("SET", key, want); err != nil {
+						fail("SET %s: %v", key, err)
+					} else if got, err := redis.String(conn.Do("GET", key)); err != nil {
+						fail("GET %s: %v", key, err)
+					} else if got != want {
+						fail("GET %s = %q right after SET %q", key, got, want)
+					}
+				case 1:
+					if _, err := conn.Do("INCR", counterKey); err != nil {
+						fail("INCR %s: %v", counterKey, err)
+					} else {
+						atomic.AddInt64(&incrs, 1)
+					}
+				case 2:
+					conn.Send("MULTI")
+					conn.Send("INCR", counterKey)
+					conn.Send("RPUSH", listKey, i)
+					reply, err := redis.Values(conn.Do("EXEC"))
+					if err != nil {
+						fail("EXEC: %v", err)
+					} else if len(reply) != 2 {
+						fail("EXEC returned %d replies, want 2", len(reply))
+					} else {
+						atomic.AddInt64(&incrs, 1)
+						atomic.AddInt64(&stats.Transactions, 1)
+					}
+				case 3:
+					if _, err := conn.Do("PUBLISH", channel, i); err != nil {
+						fail("PUBLISH: %v", err)
+					} else {
+						atomic.AddInt64(&stats.Published, 1)
+					}
+				case 4:
+					if _, err := redis.Strings(conn.Do("LRANGE", listKey, 0, 9)); err != nil {
+						fail("LRANGE %s: %v", listKey, err)
+					}
+				default:
+					if err := rt.Extra[n-5](conn); err != nil {
+						fail("extra operation %d: %v", n-5, err)
+					}
+				}
+				if err := conn.Close(); err != nil {
+					fail("closing connection: %v", err)
+				}
+				atomic.AddInt64(&stats.Operations, 1)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	conn := pool.Get()
+	defer conn.Close()
+	if got, err := redis.Int64(conn.Do("GET", counterKey)); err != nil && err != redis.ErrNil {
+		fail("GET %s: %v", counterKey, err)
+	} else if want := atomic.LoadInt64(&incrs); got != want {
+		fail("counter %s = %d, want %d successful increments", counterKey, got, want)
+	}
+
+	// Give the subscriber a moment to drain before unsubscribing.
+	time.Sleep(50 * time.Millisecond)
+	// The subscriber stops once it reads the unsubscribe reply, and the
+	// connection is closed only after that, not while it is reading.
+	psc.Unsubscribe()
+	<-subDone
+	psc.Close()
+	if stats.Published > 0 && atomic.LoadInt64(&stats.Received) == 0 {
+		fail("published %d messages but the subscriber received none", stats.Published)
+	}
+
+	keys, _ := redis.Strings(conn.Do("KEYS", prefix+"*"))
+	for _, k := range keys {
+		conn.Do("DEL", k)
+	}
+
+	for _, e := range errs {
+		t.Errorf("redis torture: %s", e)
+	}
+	if stats.Errors > int64(len(errs)) {
+		t.Errorf("redis torture: %d more errors", stats.Errors-int64(len(errs)))
+	}
+	return stats
+}
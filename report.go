@@ -0,0 +1,257 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Reporter tallies how a test used the fakes it is attached to with
+// WithReporter: messages per queue, objects per bucket, and calls and
+// time per AWS operation and redis command. Printed at the end of a
+// test, the summary makes N+1 access patterns stand out, such as a
+// hundred GetObject calls where one ListObjects would do.
+type Reporter struct {
+	mu      sync.Mutex
+	queues  map[string]*QueueActivity
+	buckets map[string]*BucketActivity
+	ops     map[string]*OperationStats
+}
+
+// InteractionSummary is what a Reporter saw.
+type InteractionSummary struct {
+	Queues     map[string]QueueActivity  `json:"queues"`
+	Buckets    map[string]BucketActivity `json:"buckets"`
+	Operations map[string]OperationStats `json:"operations"`
+}
+
+// QueueActivity counts the messages that went through an SQS queue.
+type QueueActivity struct {
+	Sent     int `json:"sent"`
+	Received int `json:"received"`
+	Deleted  int `json:"deleted"`
+}
+
+// BucketActivity counts the objects written to, read from and deleted
+// from an S3 bucket.
+type BucketActivity struct {
+	Written int `json:"written"`
+	Read    int `json:"read"`
+	Deleted int `json:"deleted"`
+}
+
+// OperationStats describes the calls to one AWS operation, such as
+// "s3:PutObject", or redis command, such as "redis:GET".
+type OperationStats struct {
+	Calls   int           `json:"calls"`
+	Errors  int           `json:"errors"`
+	Retries int           `json:"retries"`
+	Total   time.Duration `json:"total_ns"`
+}
+
+// NewReporter returns an empty Reporter.
+func NewReporter() *Reporter {
+	return &Reporter{
+		queues:  make(map[string]*QueueActivity),
+		buckets: make(map[string]*BucketActivity),
+		ops:     make(map[string]*OperationStats),
+	}
+}
+
+// WithReporter attaches rep to a fake. Requests the fake makes while
+// it is being set up are not counted.
+func WithReporter(rep *Reporter) Option {
+	return func(o *options) {
+		o.signHooks = append(o.signHooks, rep.signHook)
+		o.reporter = rep
+	}
+}
+
+func (rep *Reporter) signHook(r *request.Request) {
+	if r.RetryCount == 0 {
+		onFinish(r, rep.awsCall)
+	}
+}
+
+func (rep *Reporter) awsCall(r *request.Request, d time.Duration) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	rep.op(operationName(r), d, r.Error != nil)
+	rep.ops[operationName(r)].Retries += r.RetryCount
+	if r.Error != nil {
+		return
+	}
+
+	queue := func() *QueueActivity {
+		name := resourceName(r)
+		if rep.queues[name] == nil {
+			rep.queues[name] = &QueueActivity{}
+		}
+		return rep.queues[name]
+	}
+	bucket := func() *BucketActivity {
+		name := paramString(r, "Bucket")
+		if rep.buckets[name] == nil {
+			rep.buckets[name] = &BucketActivity{}
+		}
+		return rep.buckets[name]
+	}
+	switch p := r.Params.(type) {
+	case *sqs.SendMessageInput:
+		queue().Sent++
+	case *sqs.SendMessageBatchInput:
+		queue().Sent += len(p.Entries)
+	case *sqs.ReceiveMessageInput:
+		if out, ok := r.Data.(*sqs.ReceiveMessageOutput); ok {
+			queue().Received += len(out.Messages)
+		}
+	case *sqs.DeleteMessageInput:
+		queue().Deleted++
+	case *sqs.DeleteMessageBatchInput:
+		queue().Deleted += len(p.Entries)
+	case *s3.PutObjectInput, *s3.CopyObjectInput, *s3.CompleteMultipartUploadInput:
+		bucket().Written++
+	case *s3.GetObjectInput:
+		bucket().Read++
+	case *s3.DeleteObjectInput:
+		bucket().Deleted++
+	case *s3.DeleteObjectsInput:
+		if p.Delete != nil {
+			bucket().Deleted += len(p.Delete.Objects)
+		}
+	}
+}
+
+func (rep *Reporter) redisCommand(cmd string, d time.Duration) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	rep.op("redis:"+strings.ToUpper(cmd), d, false)
+}
+
+func (rep *Reporter) op(name string, d time.Duration, failed bool) {
+	st := rep.ops[name]
+	if st == nil {
+		st = &OperationStats{}
+		rep.ops[name] = st
+	}
+	st.Calls++
+	st.Total += d
+	if failed {
+		st.Errors++
+	}
+}
+
+// Summary returns what the Reporter has seen so far.
+func (rep *Reporter) Summary() InteractionSummary {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	s := InteractionSummary{
+		Queues:     make(map[string]QueueActivity, len(rep.queues)),
+		Buckets:    make(map[string]BucketActivity, len(rep.buckets)),
+		Operations: make(map[string]OperationStats, len(rep.ops)),
+	}
+	for k, v := range rep.queues {
+		s.Queues[k] = *v
+	}
+	for k, v := range rep.buckets {
+		s.Buckets[k] = *v
+	}
+	for k, v := range rep.ops {
+		s.Operations[k] = *v
+	}
+	return s
+}
+
+// Reset forgets everything the Reporter has seen.
+func (rep *Reporter) Reset() {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	rep.queues = make(map[string]*QueueActivity)
+	rep.buckets = make(map[string]*BucketActivity)
+	rep.ops = make(map[string]*OperationStats)
+}
+
+// ReportAtEnd logs the summary to t when the test finishes. If
+// $TESTUTIL_REPORT_DIR is set, the summary is also written there as
+// JSON, in a file named after the test, for collecting across a CI
+// run.
+func (rep *Reporter) ReportAtEnd(t CleanupT) {
+	t.Cleanup(func() {
+		s := rep.Summary()
+		t.Logf("%s", s)
+		dir := os.Getenv("TESTUTIL_REPORT_DIR")
+		if dir == "" {
+			return
+		}
+		b, err := json.MarshalIndent(s, "", "  ")
+		if err == nil {
+			err = os.MkdirAll(dir, 0755)
+		}
+		if err == nil {
+			path := filepath.Join(dir, unsafeFileChars.ReplaceAllString(t.Name(), "_")+".json")
+			err = ioutil.WriteFile(path, b, 0644)
+		}
+		if err != nil {
+			t.Logf("Could not write interaction summary: %v", err)
+		}
+	})
+}
+
+// String formats the summary as a table, with the busiest operations
+// first.
+func (s InteractionSummary) String() string {
+	if len(s.Queues) == 0 && len(s.Buckets) == 0 && len(s.Operations) == 0 {
+		return "fake interactions: none"
+	}
+	var b strings.Builder
+	b.WriteString("fake interactions:\n")
+	for _, name := range sortedKeys(s.Queues) {
+		q := s.Queues[name]
+		fmt.Fprintf(&b, "  sqs queue %s: %d sent, %d received, %d deleted\n", name, q.Sent, q.Received, q.Deleted)
+	}
+	for _, name := range sortedKeys(s.Buckets) {
+		bk := s.Buckets[name]
+		fmt.Fprintf(&b, "  s3 bucket %s: %d written, %d read, %d deleted\n", name, bk.Written, bk.Read, bk.Deleted)
+	}
+	names := sortedKeys(s.Operations)
+	sort.SliceStable(names, func(i, j int) bool {
+		return s.Operations[names[i]].Calls > s.Operations[names[j]].Calls
+	})
+	width := 0
+	for _, name := range names {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	for _, name := range names {
+		op := s.Operations[name]
+		fmt.Fprintf(&b, "  %-*s %5d calls  %v", width, name, op.Calls, op.Total.Round(time.Microsecond))
+		if op.Retries > 0 {
+			fmt.Fprintf(&b, "  %d retries", op.Retries)
+		}
+		if op.Errors > 0 {
+			fmt.Fprintf(&b, "  %d failed", op.Errors)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,93 @@
+package testutil
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+func TestReporter(t *testing.T) {
+	rep := NewReporter()
+
+	stub := &stubSQS{batches: [][]stubMessage{
+		{{id: "1", body: "a", receiveCount: 1}, {id: "2", body: "b", receiveCount: 1}},
+	}}
+	q := newStubFakeSQS(t, stub)
+	installHooks(newOptions([]Option{WithReporter(rep)}), &q.Session.Handlers, &q.Client.Handlers)
+	if msgs, err := q.ReceiveMessages(10, 0); err != nil || len(msgs) != 2 {
+		t.Fatalf("ReceiveMessages = %d messages, %v", len(msgs), err)
+	}
+
+	k := NewFakeKMS(WithReporter(rep))
+	defer k.Close()
+	key := k.CreateKey("")
+	for i := 0; i < 3; i++ {
+		k.Client.Encrypt(&kms.EncryptInput{KeyId: aws.String(key), Plaintext: []byte("x")})
+	}
+	k.Client.Decrypt(&kms.DecryptInput{CiphertextBlob: []byte("garbage")})
+
+	r := newStubRedis(WithReporter(rep))
+	defer r.Close()
+	conn := r.Pool.Get()
+	defer conn.Close()
+	conn.Do("get", "a")
+	conn.Do("GET", "b")
+	conn.Do("SET", "a", "1")
+
+	s := rep.Summary()
+	if got := s.Queues["jobs"]; got != (QueueActivity{Received: 2}) {
+		t.Errorf("queue activity = %+v", got)
+	}
+	for op, want := range map[string]OperationStats{
+		"kms:Encrypt":        {Calls: 3},
+		"kms:Decrypt":        {Calls: 1, Errors: 1},
+		"sqs:ReceiveMessage": {Calls: 1},
+		"redis:GET":          {Calls: 2},
+		"redis:SET":          {Calls: 1},
+	} {
+		got := s.Operations[op]
+		if got.Calls != want.Calls || got.Errors != want.Errors {
+			t.Errorf("%s: %d calls, %d errors; want %d, %d", op, got.Calls, got.Errors, want.Calls, want.Errors)
+		}
+	}
+
+	lines := strings.Split(s.String(), "\n")
+	if len(lines) != 7 || lines[1] != "  sqs queue jobs: 0 sent, 2 received, 0 deleted" ||
+		!strings.HasPrefix(lines[2], "  kms:Encrypt ") {
+		t.Errorf("String() =\n%s", s)
+	}
+
+	rep.Reset()
+	if got := rep.Summary().String(); got != "fake interactions: none" {
+		t.Errorf("after Reset, String() = %q", got)
+	}
+}
+
+func TestReporterReportAtEnd(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TESTUTIL_REPORT_DIR", dir)
+	rep := NewReporter()
+	rep.op("s3:PutObject", 0, false)
+
+	ft := &failingT{name: "TestBatch/upload"}
+	rep.ReportAtEnd(ft)
+	for _, f := range ft.cleanups {
+		f()
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "TestBatch_upload.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s InteractionSummary
+	if err := json.Unmarshal(b, &s); err != nil || s.Operations["s3:PutObject"].Calls != 1 {
+		t.Errorf("report = %s (%v)", b, err)
+	}
+	if len(ft.logs) != 1 {
+		t.Errorf("logged %q, want the summary", ft.logs)
+	}
+}
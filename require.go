@@ -0,0 +1,64 @@
+package testutil
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// installHints says how to install the executables the fakes use.
+var installHints = map[string]string{
+	"redis-server": "install redis (`brew install redis` or `apt-get install redis-server`)",
+	"fake_sqs":     "`gem install fake_sqs`",
+	"fakes3":       "`gem install fakes3`",
+	"java":         "install a Java runtime, which DynamoDB Local needs",
+	"docker":       "install Docker (https://docs.docker.com/get-docker/)",
+	"minio":        "`brew install minio/stable/minio` or download it from https://min.io/download",
+}
+
+// FatalT is the part of testing.TB used by RequireBinaries. *testing.T
+// and *testing.B satisfy it, as does any Logger.
+type FatalT interface {
+	Fatalf(format string, args ...interface{})
+}
+
+// RequireBinaries checks that each of names is an executable in $PATH,
+// and otherwise fails t once, listing every missing one with how to
+// install it, rather than leaving each fake to time out in turn. Call
+// it at the start of an integration test or from TestMain with a
+// Logger.
+func RequireBinaries(t FatalT, names ...string) {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+	var problems []string
+	for _, name := range names {
+		if _, err := exec.LookPath(name); err == nil {
+			continue
+		}
+		problem := name + " is not in $PATH"
+		if path := findInPath(name); path != "" {
+			problem = fmt.Sprintf("%s is in $PATH at %s but is not executable; chmod +x it", name, path)
+		} else if hint := installHints[name]; hint != "" {
+			problem += ": " + hint
+		}
+		problems = append(problems, problem)
+	}
+	if len(problems) > 0 {
+		t.Fatalf("missing test dependencies:\n  %s", strings.Join(problems, "\n  "))
+	}
+}
+
+// findInPath returns the first file called name in $PATH, executable
+// or not.
+func findInPath(name string) string {
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+			return path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,44 @@
+package testutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+type fatalT struct {
+	fatal string
+}
+
+func (f *fatalT) Fatalf(format string, args ...interface{}) {
+	f.fatal = fmt.Sprintf(format, args...)
+}
+
+func TestRequireBinaries(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("executable bits don't apply on Windows")
+	}
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "fakes3"), []byte("#!/bin/sh\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "redis-server"), []byte("#!/bin/sh\n"), 0755)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	ft := &fatalT{}
+	RequireBinaries(ft, "redis-server", "fake_sqs", "fakes3", "no-such-binary-testutil")
+	want := "missing test dependencies:\n" +
+		"  fake_sqs is not in $PATH: `gem install fake_sqs`\n" +
+		"  fakes3 is in $PATH at " + filepath.Join(dir, "fakes3") + " but is not executable; chmod +x it\n" +
+		"  no-such-binary-testutil is not in $PATH"
+	if ft.fatal != want {
+		t.Errorf("failed with\n%s\nwant\n%s", ft.fatal, want)
+	}
+
+	ft = &fatalT{}
+	RequireBinaries(ft, "redis-server")
+	if ft.fatal != "" {
+		t.Errorf("failed with %q", ft.fatal)
+	}
+}
@@ -0,0 +1,373 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// PutString stores s as the object bucket/key with a text/plain
+// content type.
+func (s *FakeS3) PutString(bucket, key, str string) error {
+	return s.put(bucket, key, []byte(str), "text/plain; charset=utf-8")
+}
+
+// PutJSON stores the JSON encoding of v as the object bucket/key with
+// an application/json content type.
+func (s *FakeS3) PutJSON(bucket, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.put(bucket, key, b, "application/json")
+}
+
+// PutFile stores the contents of the local file at path as the object
+// bucket/key. The content type is guessed from the file's extension,
+// falling back to sniffing its contents.
+func (s *FakeS3) PutFile(bucket, key, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return s.put(bucket, key, b, contentType(path, b))
+}
+
+func (s *FakeS3) put(bucket, key string, body []byte, contentType string) error {
+	_, err := s.Client.PutObject(&s3.PutObjectInput{
+		Bucket:      &bucket,
+		Key:         &key,
+		Body:        bytes.NewReader(body),
+		ContentType: &contentType,
+	})
+	return err
+}
+
+// LoadFixtures stores every file under the local directory dir as an
+// object in bucket, keyed by its path relative to dir with forward
+// slashes, so that test data can be seeded from files checked in beside
+// the test. Content types are guessed as by PutFile. Objects already in
+// the bucket are kept unless a file has the same key.
+func (s *FakeS3) LoadFixtures(bucket, dir string) error {
+	return filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		return s.PutFile(bucket, filepath.ToSlash(rel), name)
+	})
+}
+
+// DumpBucket writes every object in bucket to a file under the local
+// directory dir, its key giving the file's path, the inverse of
+// LoadFixtures. Keys that aren't clean relative paths, such as "a//b"
+// or "dir/", can't be written.
+func (s *FakeS3) DumpBucket(bucket, dir string) error {
+	keys, err := s.listKeys(bucket, "")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		body, err := s.GetBytes(bucket, key)
+		if err != nil {
+			return err
+		}
+		if err := writeObjectFile(dir, key, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeObjectFile writes body to the file for key under dir.
+func writeObjectFile(dir, key string, body []byte) error {
+	if path.Clean(key) != key || path.IsAbs(key) || strings.HasPrefix(key, "../") {
+		return fmt.Errorf("key %q can't be stored as a file", key)
+	}
+	name := filepath.Join(dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, body, 0644)
+}
+
+// GetString returns the contents of the object bucket/key.
+func (s *FakeS3) GetString(bucket, key string) (string, error) {
+	b, err := s.GetBytes(bucket, key)
+	return string(b), err
+}
+
+// GetJSON unmarshals the contents of the object bucket/key into v.
+func (s *FakeS3) GetJSON(bucket, key string, v interface{}) error {
+	b, err := s.GetBytes(bucket, key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// GetBytes returns the contents of the object bucket/key.
+func (s *FakeS3) GetBytes(bucket, key string) ([]byte, error) {
+	out, err := s.Client.GetObject(&s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+// DownloadToFile writes the contents of the object bucket/key to the
+// local file at path, creating any missing parent directories.
+func (s *FakeS3) DownloadToFile(bucket, key, path string) error {
+	out, err := s.Client.GetObject(&s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, out.Body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// CopyObject copies the object srcBucket/srcKey to dstBucket/dstKey.
+// Objects larger than the multipart copy threshold (5GB by default,
+// the largest single copy S3 allows; see WithMultipartCopy) are copied
+// in parts with UploadPartCopy.
+func (s *FakeS3) CopyObject(srcBucket, srcKey, dstBucket, dstKey string) error {
+	head, err := s.Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: &srcBucket,
+		Key:    &srcKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	source := copySource(srcBucket, srcKey)
+	size := aws.Int64Value(head.ContentLength)
+	if size <= s.multipartCopyThreshold {
+		_, err = s.Client.CopyObject(&s3.CopyObjectInput{
+			Bucket:     &dstBucket,
+			Key:        &dstKey,
+			CopySource: &source,
+		})
+		return err
+	}
+	return s.multipartCopy(source, size, head.ContentType, dstBucket, dstKey)
+}
+
+func (s *FakeS3) multipartCopy(source string, size int64, contentType *string, dstBucket, dstKey string) error {
+	upload, err := s.Client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:      &dstBucket,
+		Key:         &dstKey,
+		ContentType: contentType,
+	})
+	if err != nil {
+		return err
+	}
+
+	var parts []*s3.CompletedPart
+	for start, n := int64(0), int64(1); start < size; start, n = start+s.copyPartSize, n+1 {
+		end := start + s.copyPartSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		out, err := s.Client.UploadPartCopy(&s3.UploadPartCopyInput{
+			Bucket:          &dstBucket,
+			Key:             &dstKey,
+			UploadId:        upload.UploadId,
+			PartNumber:      aws.Int64(n),
+			CopySource:      &source,
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if err != nil {
+			s.Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   &dstBucket,
+				Key:      &dstKey,
+				UploadId: upload.UploadId,
+			})
+			return err
+		}
+		parts = append(parts, &s3.CompletedPart{
+			ETag:       out.CopyPartResult.ETag,
+			PartNumber: aws.Int64(n),
+		})
+	}
+
+	_, err = s.Client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          &dstBucket,
+		Key:             &dstKey,
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+// MoveObject copies srcBucket/srcKey to dstBucket/dstKey and then
+// deletes the source. Moving an object onto itself leaves it alone,
+// only checking that it exists.
+func (s *FakeS3) MoveObject(srcBucket, srcKey, dstBucket, dstKey string) error {
+	if srcBucket == dstBucket && srcKey == dstKey {
+		_, err := s.Client.HeadObject(&s3.HeadObjectInput{Bucket: &srcBucket, Key: &srcKey})
+		return err
+	}
+	if err := s.CopyObject(srcBucket, srcKey, dstBucket, dstKey); err != nil {
+		return err
+	}
+	_, err := s.Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: &srcBucket,
+		Key:    &srcKey,
+	})
+	return err
+}
+
+// CopyPrefix copies every object in srcBucket whose key starts with
+// srcPrefix into dstBucket, replacing srcPrefix with dstPrefix in each
+// key. It returns the destination keys written.
+func (s *FakeS3) CopyPrefix(srcBucket, srcPrefix, dstBucket, dstPrefix string) ([]string, error) {
+	keys, err := s.listKeys(srcBucket, srcPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var copied []string
+	for _, key := range keys {
+		dstKey := dstPrefix + strings.TrimPrefix(key, srcPrefix)
+		if err := s.CopyObject(srcBucket, key, dstBucket, dstKey); err != nil {
+			return copied, err
+		}
+		copied = append(copied, dstKey)
+	}
+	return copied, nil
+}
+
+// AssertObjectExists checks that the object bucket/key exists, waiting
+// for it as set by WithAssertTimeout.
+func (s *FakeS3) AssertObjectExists(t TestingT, bucket, key string) {
+	t.Helper()
+	var err error
+	if !eventually(s.assertTimeout, func() bool {
+		_, err = s.Client.HeadObject(&s3.HeadObjectInput{Bucket: &bucket, Key: &key})
+		return err == nil
+	}) {
+		t.Errorf("s3://%s/%s does not exist%s: %v", bucket, key, withinString(s.assertTimeout), err)
+	}
+}
+
+// AssertObjectBody checks that the object bucket/key holds want,
+// waiting for it as set by WithAssertTimeout.
+func (s *FakeS3) AssertObjectBody(t TestingT, bucket, key string, want []byte) {
+	t.Helper()
+	var got []byte
+	var err error
+	if eventually(s.assertTimeout, func() bool {
+		got, err = s.GetBytes(bucket, key)
+		return err == nil && bytes.Equal(got, want)
+	}) {
+		return
+	}
+	if err != nil {
+		t.Errorf("s3://%s/%s does not exist%s: %v", bucket, key, withinString(s.assertTimeout), err)
+		return
+	}
+	t.Errorf("s3://%s/%s has %d bytes %s, want %d bytes %s", bucket, key, len(got), quoteSample(got), len(want), quoteSample(want))
+}
+
+// AssertObjectCount checks that bucket holds n objects whose keys
+// start with prefix, waiting for it as set by WithAssertTimeout.
+func (s *FakeS3) AssertObjectCount(t TestingT, bucket, prefix string, n int) {
+	t.Helper()
+	var keys []string
+	var err error
+	if eventually(s.assertTimeout, func() bool {
+		keys, err = s.listKeys(bucket, prefix)
+		return err == nil && len(keys) == n
+	}) {
+		return
+	}
+	if err != nil {
+		t.Errorf("listing s3://%s/%s: %v", bucket, prefix, err)
+		return
+	}
+	sample := keys
+	if len(sample) > 5 {
+		sample = append(sample[:5:5], "...")
+	}
+	t.Errorf("s3://%s/%s has %d object(s)%s, want %d: %v", bucket, prefix, len(keys), withinString(s.assertTimeout), n, sample)
+}
+
+// quoteSample quotes up to the first 100 bytes of b, for failure
+// messages.
+func quoteSample(b []byte) string {
+	if len(b) > 100 {
+		return strconv.Quote(string(b[:100])) + "..."
+	}
+	return strconv.Quote(string(b))
+}
+
+// listKeys returns the keys of every object in bucket that starts with
+// prefix.
+func (s *FakeS3) listKeys(bucket, prefix string) ([]string, error) {
+	var keys []string
+	err := s.Client.ListObjectsPages(&s3.ListObjectsInput{
+		Bucket: &bucket,
+		Prefix: &prefix,
+	}, func(page *s3.ListObjectsOutput, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	return keys, err
+}
+
+// copySource returns the x-amz-copy-source value for bucket/key: the
+// key is path escaped a segment at a time, keeping its slashes, so a
+// "+" in it isn't read back as a space.
+func copySource(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return bucket + "/" + strings.Join(segments, "/")
+}
+
+// contentType guesses the content type of a file from its name and
+// contents.
+func contentType(name string, body []byte) string {
+	if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+		return ct
+	}
+	return http.DetectContentType(body)
+}
@@ -0,0 +1,158 @@
+package testutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestContentType(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"data.json", "{}", "application/json"},
+		{"page.html", "", "text/html; charset=utf-8"},
+		{"README", "plain old text", "text/plain; charset=utf-8"},
+	}
+	for _, c := range cases {
+		if got := contentType(c.name, []byte(c.body)); got != c.want {
+			t.Errorf("contentType(%q) = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCopySource(t *testing.T) {
+	cases := []struct {
+		bucket, key string
+		want        string
+	}{
+		{"uploads", "a.txt", "uploads/a.txt"},
+		{"uploads", "dir/a b+c.txt", "uploads/dir/a%20b+c.txt"},
+		{"uploads", "100%/x?y", "uploads/100%25/x%3Fy"},
+	}
+	for _, c := range cases {
+		if got := copySource(c.bucket, c.key); got != c.want {
+			t.Errorf("copySource(%q, %q) = %q, want %q", c.bucket, c.key, got, c.want)
+		}
+	}
+}
+
+func ExampleFakeS3_PutJSON() {
+	s := NewFakeS3("json-bucket")
+	defer s.Close()
+
+	type config struct {
+		Name    string `json:"name"`
+		Retries int    `json:"retries"`
+	}
+	s.PutJSON("json-bucket", "config.json", config{Name: "worker", Retries: 3})
+
+	var c config
+	if err := s.GetJSON("json-bucket", "config.json", &c); err != nil {
+		// handle error
+	}
+
+	fmt.Println(c.Name, c.Retries)
+	// Output:
+	// worker 3
+}
+
+func ExampleFakeS3_CopyPrefix() {
+	s := NewFakeS3("archive-bucket")
+	defer s.Close()
+
+	s.PutString("archive-bucket", "incoming/a.txt", "a")
+	s.PutString("archive-bucket", "incoming/b.txt", "b")
+
+	keys, err := s.CopyPrefix("archive-bucket", "incoming/", "archive-bucket", "archive/2016/")
+	if err != nil {
+		// handle error
+	}
+
+	fmt.Println(keys)
+	// Output:
+	// [archive/2016/a.txt archive/2016/b.txt]
+}
+
+func TestLoadFixtures(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{
+		"users/1.json": `{"name":"jo"}`,
+		"readme.txt":   "hello",
+	} {
+		if err := writeObjectFile(dir, name, []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := NewFakeS3("fixtures", WithInProcess())
+	defer s.Close()
+	s.PutString("fixtures", "kept.txt", "kept")
+
+	if err := s.LoadFixtures("fixtures", dir); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := s.listKeys("fixtures", "")
+	if fmt.Sprint(keys) != "[kept.txt readme.txt users/1.json]" {
+		t.Errorf("keys = %v", keys)
+	}
+	head, err := s.Client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("fixtures"), Key: aws.String("users/1.json")})
+	if err != nil || aws.StringValue(head.ContentType) != "application/json" {
+		t.Errorf("HeadObject = %v, %v", head, err)
+	}
+
+	out := t.TempDir()
+	if err := s.DumpBucket("fixtures", out); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(out, "users", "1.json"))
+	if err != nil || string(b) != `{"name":"jo"}` {
+		t.Errorf("dumped users/1.json = %q, %v", b, err)
+	}
+
+	if err := s.LoadFixtures("fixtures", filepath.Join(dir, "missing")); err == nil {
+		t.Error("loading a missing directory succeeded")
+	}
+}
+
+func TestFakeS3Asserts(t *testing.T) {
+	s := NewFakeS3("uploads", WithInProcess(), WithAssertTimeout(time.Second))
+	defer s.Close()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.PutString("uploads", "reports/1.csv", "a,b")
+		s.PutString("uploads", "reports/2.csv", "c,d")
+	}()
+	s.AssertObjectExists(t, "uploads", "reports/1.csv")
+	s.AssertObjectBody(t, "uploads", "reports/2.csv", []byte("c,d"))
+	s.AssertObjectCount(t, "uploads", "reports/", 2)
+
+	s = NewFakeS3("uploads", WithInProcess(), WithAssertTimeout(0))
+	defer s.Close()
+	s.PutString("uploads", "a.txt", "hello")
+	rt := &recordingT{}
+	s.AssertObjectExists(rt, "uploads", "missing.txt")
+	s.AssertObjectBody(rt, "uploads", "a.txt", []byte("goodbye"))
+	s.AssertObjectCount(rt, "uploads", "", 2)
+	want := []string{
+		"s3://uploads/missing.txt does not exist",
+		`s3://uploads/a.txt has 5 bytes "hello", want 7 bytes "goodbye"`,
+		"s3://uploads/ has 1 object(s), want 2: [a.txt]",
+	}
+	if len(rt.errors) != len(want) {
+		t.Fatalf("errors = %q", rt.errors)
+	}
+	for i, w := range want {
+		if !strings.HasPrefix(rt.errors[i], w) {
+			t.Errorf("error %d = %q, want %q", i, rt.errors[i], w)
+		}
+	}
+}
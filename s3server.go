@@ -0,0 +1,576 @@
+package testutil
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const s3XMLNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// s3Server serves the S3 REST API from a MemoryS3, for WithInProcess.
+// Besides what MemoryS3 supports it implements multipart uploads,
+// including UploadPartCopy. Buckets are addressed path-style.
+type s3Server struct {
+	mem *MemoryS3
+
+	mu      sync.Mutex
+	uploads map[string]*s3Upload
+	next    int
+}
+
+// s3Upload is a multipart upload in progress.
+type s3Upload struct {
+	bucket, key string
+	contentType string
+	metadata    map[string]*string
+	parts       map[int64][]byte
+}
+
+func newS3Server(o *options) *s3Server {
+	return &s3Server{mem: NewMemoryS3(WithClock(o.clock)), uploads: make(map[string]*s3Upload)}
+}
+
+func (h *s3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket, key := parts[0], ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	q := r.URL.Query()
+	_, uploads := q["uploads"]
+	_, deletes := q["delete"]
+	uploadID := q.Get("uploadId")
+	copySource := r.Header.Get("X-Amz-Copy-Source")
+
+	var result interface{}
+	var err error
+	status := http.StatusOK
+	switch {
+	case bucket == "" && r.Method == "GET":
+		result, err = h.listBuckets()
+	case bucket == "":
+		err = s3Error(405, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+	case key == "" && r.Method == "PUT":
+		_, err = h.mem.CreateBucket(&s3.CreateBucketInput{Bucket: &bucket})
+	case key == "" && r.Method == "DELETE":
+		_, err = h.mem.DeleteBucket(&s3.DeleteBucketInput{Bucket: &bucket})
+		status = http.StatusNoContent
+	case key == "" && r.Method == "HEAD":
+		_, err = h.mem.HeadBucket(&s3.HeadBucketInput{Bucket: &bucket})
+	case key == "" && r.Method == "POST" && deletes:
+		result, err = h.deleteObjects(bucket, r.Body)
+	case key == "" && r.Method == "GET" && q.Get("list-type") == "2":
+		result, err = h.listObjectsV2(bucket, q)
+	case key == "" && r.Method == "GET":
+		result, err = h.listObjects(bucket, q)
+	case r.Method == "POST" && uploads:
+		result, err = h.createMultipartUpload(bucket, key, r.Header)
+	case r.Method == "POST" && uploadID != "":
+		result, err = h.completeMultipartUpload(bucket, key, uploadID, r.Body)
+	case r.Method == "PUT" && uploadID != "":
+		result, err = h.uploadPart(bucket, key, uploadID, q.Get("partNumber"), copySource, r, w.Header())
+	case r.Method == "DELETE" && uploadID != "":
+		err = h.abortMultipartUpload(bucket, key, uploadID)
+		status = http.StatusNoContent
+	case r.Method == "PUT" && copySource != "":
+		result, err = h.copyObject(bucket, key, copySource, r.Header)
+	case r.Method == "PUT":
+		err = h.putObject(bucket, key, r, w.Header())
+	case r.Method == "GET":
+		err = h.getObject(bucket, key, r.Header.Get("Range"), w)
+	case r.Method == "HEAD":
+		err = h.headObject(bucket, key, w.Header())
+	case r.Method == "DELETE":
+		_, err = h.mem.DeleteObject(&s3.DeleteObjectInput{Bucket: &bucket, Key: &key})
+		status = http.StatusNoContent
+	default:
+		err = s3Error(405, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+	}
+	if err != nil {
+		writeS3Error(w, r, err)
+		return
+	}
+	if result == nil {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(result)
+}
+
+// writeS3Error writes err as an S3 error document. Errors from
+// MemoryS3 keep their status and code.
+func writeS3Error(w http.ResponseWriter, r *http.Request, err error) {
+	status, code, message := http.StatusInternalServerError, "InternalError", err.Error()
+	if e, ok := err.(awserr.RequestFailure); ok {
+		status, code, message = e.StatusCode(), e.Code(), e.Message()
+	}
+	if r.Method == "HEAD" {
+		// HEAD responses have no body.
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName   xml.Name `xml:"Error"`
+		Code      string
+		Message   string
+		Resource  string
+		RequestID string `xml:"RequestId"`
+	}{Code: code, Message: message, Resource: r.URL.Path, RequestID: randomUUID()})
+}
+
+// writeObjectHeaders sets the headers describing an object.
+func writeObjectHeaders(hdr http.Header, contentType, etag *string, lastModified *time.Time, metadata map[string]*string) {
+	hdr.Set("Accept-Ranges", "bytes")
+	hdr.Set("Content-Type", aws.StringValue(contentType))
+	hdr.Set("ETag", aws.StringValue(etag))
+	hdr.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	for k, v := range metadata {
+		hdr.Set("X-Amz-Meta-"+k, aws.StringValue(v))
+	}
+}
+
+// requestMetadata returns the user metadata sent with a request.
+func requestMetadata(hdr http.Header) map[string]*string {
+	var metadata map[string]*string
+	for k := range hdr {
+		if strings.HasPrefix(k, "X-Amz-Meta-") {
+			if metadata == nil {
+				metadata = make(map[string]*string)
+			}
+			metadata[strings.TrimPrefix(k, "X-Amz-Meta-")] = aws.String(hdr.Get(k))
+		}
+	}
+	return metadata
+}
+
+type s3BucketEntry struct {
+	Name         string
+	CreationDate string
+}
+
+type s3ListBucketsResult struct {
+	XMLName xml.Name        `xml:"ListAllMyBucketsResult"`
+	XMLNS   string          `xml:"xmlns,attr"`
+	OwnerID string          `xml:"Owner>ID"`
+	Buckets []s3BucketEntry `xml:"Buckets>Bucket"`
+}
+
+func (h *s3Server) listBuckets() (interface{}, error) {
+	out, err := h.mem.ListBuckets(&s3.ListBucketsInput{})
+	if err != nil {
+		return nil, err
+	}
+	result := &s3ListBucketsResult{XMLNS: s3XMLNS, OwnerID: aws.StringValue(out.Owner.ID)}
+	for _, b := range out.Buckets {
+		result.Buckets = append(result.Buckets, s3BucketEntry{Name: aws.StringValue(b.Name), CreationDate: formatISO8601(*b.CreationDate)})
+	}
+	return result, nil
+}
+
+type s3ObjectEntry struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type s3CommonPrefix struct {
+	Prefix string
+}
+
+// s3ListBucketResult is the response to both versions of ListObjects;
+// each leaves the other's fields empty.
+type s3ListBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	XMLNS                 string   `xml:"xmlns,attr"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	Marker                *string
+	NextMarker            string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	KeyCount              *int64
+	MaxKeys               int64
+	IsTruncated           bool
+	Contents              []s3ObjectEntry
+	CommonPrefixes        []s3CommonPrefix
+}
+
+func newS3ListBucketResult(bucket string, q url.Values, objs []*s3.Object, prefixes []*s3.CommonPrefix, truncated bool) *s3ListBucketResult {
+	result := &s3ListBucketResult{
+		XMLNS:       s3XMLNS,
+		Name:        bucket,
+		Prefix:      q.Get("prefix"),
+		Delimiter:   q.Get("delimiter"),
+		MaxKeys:     1000,
+		IsTruncated: truncated,
+	}
+	if n, err := strconv.ParseInt(q.Get("max-keys"), 10, 64); err == nil && n > 0 && n < 1000 {
+		result.MaxKeys = n
+	}
+	for _, obj := range objs {
+		result.Contents = append(result.Contents, s3ObjectEntry{
+			Key:          aws.StringValue(obj.Key),
+			LastModified: formatISO8601(*obj.LastModified),
+			ETag:         aws.StringValue(obj.ETag),
+			Size:         aws.Int64Value(obj.Size),
+			StorageClass: aws.StringValue(obj.StorageClass),
+		})
+	}
+	for _, p := range prefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{aws.StringValue(p.Prefix)})
+	}
+	return result
+}
+
+// optionalParam returns the query parameter name, or nil if it wasn't
+// given.
+func optionalParam(q url.Values, name string) *string {
+	if _, ok := q[name]; !ok {
+		return nil
+	}
+	return aws.String(q.Get(name))
+}
+
+func maxKeysParam(q url.Values) *int64 {
+	n, err := strconv.ParseInt(q.Get("max-keys"), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+func (h *s3Server) listObjects(bucket string, q url.Values) (interface{}, error) {
+	out, err := h.mem.ListObjects(&s3.ListObjectsInput{
+		Bucket:    &bucket,
+		Prefix:    optionalParam(q, "prefix"),
+		Delimiter: optionalParam(q, "delimiter"),
+		Marker:    optionalParam(q, "marker"),
+		MaxKeys:   maxKeysParam(q),
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := newS3ListBucketResult(bucket, q, out.Contents, out.CommonPrefixes, aws.BoolValue(out.IsTruncated))
+	result.Marker = aws.String(q.Get("marker"))
+	result.NextMarker = aws.StringValue(out.NextMarker)
+	return result, nil
+}
+
+func (h *s3Server) listObjectsV2(bucket string, q url.Values) (interface{}, error) {
+	out, err := h.mem.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket:            &bucket,
+		Prefix:            optionalParam(q, "prefix"),
+		Delimiter:         optionalParam(q, "delimiter"),
+		StartAfter:        optionalParam(q, "start-after"),
+		ContinuationToken: optionalParam(q, "continuation-token"),
+		MaxKeys:           maxKeysParam(q),
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := newS3ListBucketResult(bucket, q, out.Contents, out.CommonPrefixes, aws.BoolValue(out.IsTruncated))
+	result.KeyCount = out.KeyCount
+	result.StartAfter = q.Get("start-after")
+	result.ContinuationToken = q.Get("continuation-token")
+	result.NextContinuationToken = aws.StringValue(out.NextContinuationToken)
+	return result, nil
+}
+
+func (h *s3Server) deleteObjects(bucket string, body io.Reader) (interface{}, error) {
+	var req struct {
+		Quiet   bool
+		Objects []struct {
+			Key string
+		} `xml:"Object"`
+	}
+	if err := xml.NewDecoder(body).Decode(&req); err != nil {
+		return nil, s3Error(400, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema")
+	}
+	in := &s3.DeleteObjectsInput{Bucket: &bucket, Delete: &s3.Delete{Quiet: aws.Bool(req.Quiet)}}
+	for _, obj := range req.Objects {
+		in.Delete.Objects = append(in.Delete.Objects, &s3.ObjectIdentifier{Key: aws.String(obj.Key)})
+	}
+	out, err := h.mem.DeleteObjects(in)
+	if err != nil {
+		return nil, err
+	}
+	type deleted struct {
+		Key string
+	}
+	result := struct {
+		XMLName xml.Name `xml:"DeleteResult"`
+		XMLNS   string   `xml:"xmlns,attr"`
+		Deleted []deleted
+	}{XMLNS: s3XMLNS}
+	for _, d := range out.Deleted {
+		result.Deleted = append(result.Deleted, deleted{aws.StringValue(d.Key)})
+	}
+	return &result, nil
+}
+
+func (h *s3Server) putObject(bucket, key string, r *http.Request, hdr http.Header) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	out, err := h.mem.PutObject(&s3.PutObjectInput{
+		Bucket:      &bucket,
+		Key:         &key,
+		Body:        bytes.NewReader(body),
+		ContentType: optionalHeader(r.Header, "Content-Type"),
+		Metadata:    requestMetadata(r.Header),
+	})
+	if err != nil {
+		return err
+	}
+	hdr.Set("ETag", aws.StringValue(out.ETag))
+	return nil
+}
+
+// optionalHeader returns the header name, or nil if it wasn't sent.
+func optionalHeader(hdr http.Header, name string) *string {
+	if v := hdr.Get(name); v != "" {
+		return &v
+	}
+	return nil
+}
+
+func (h *s3Server) getObject(bucket, key, byteRange string, w http.ResponseWriter) error {
+	in := &s3.GetObjectInput{Bucket: &bucket, Key: &key}
+	if byteRange != "" {
+		in.Range = &byteRange
+	}
+	out, err := h.mem.GetObject(in)
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+	writeObjectHeaders(w.Header(), out.ContentType, out.ETag, out.LastModified, out.Metadata)
+	w.Header().Set("Content-Length", strconv.FormatInt(aws.Int64Value(out.ContentLength), 10))
+	if out.ContentRange != nil {
+		w.Header().Set("Content-Range", *out.ContentRange)
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	_, err = io.Copy(w, out.Body)
+	return err
+}
+
+func (h *s3Server) headObject(bucket, key string, hdr http.Header) error {
+	out, err := h.mem.HeadObject(&s3.HeadObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return err
+	}
+	writeObjectHeaders(hdr, out.ContentType, out.ETag, out.LastModified, out.Metadata)
+	hdr.Set("Content-Length", strconv.FormatInt(aws.Int64Value(out.ContentLength), 10))
+	return nil
+}
+
+type s3CopyResult struct {
+	ETag         string
+	LastModified string
+}
+
+// parseCopySource returns the bucket and key named by an
+// X-Amz-Copy-Source header. Like S3, it path unescapes the header, so
+// a "+" in it is a plus sign rather than a space.
+func parseCopySource(source string) (bucket, key string, err error) {
+	unescaped, err := url.PathUnescape(strings.TrimPrefix(source, "/"))
+	parts := strings.SplitN(unescaped, "/", 2)
+	if err != nil || len(parts) != 2 {
+		return "", "", s3Error(400, "InvalidArgument", "invalid copy source %q", source)
+	}
+	return parts[0], parts[1], nil
+}
+
+func (h *s3Server) copyObject(bucket, key, source string, hdr http.Header) (interface{}, error) {
+	srcBucket, srcKey, err := parseCopySource(source)
+	if err != nil {
+		return nil, err
+	}
+	out, err := h.mem.CopyObject(&s3.CopyObjectInput{
+		Bucket:            &bucket,
+		Key:               &key,
+		CopySource:        aws.String(url.PathEscape(srcBucket + "/" + srcKey)),
+		MetadataDirective: optionalHeader(hdr, "X-Amz-Metadata-Directive"),
+		ContentType:       optionalHeader(hdr, "Content-Type"),
+		Metadata:          requestMetadata(hdr),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &struct {
+		XMLName xml.Name `xml:"CopyObjectResult"`
+		s3CopyResult
+	}{s3CopyResult: s3CopyResult{
+		ETag:         aws.StringValue(out.CopyObjectResult.ETag),
+		LastModified: formatISO8601(*out.CopyObjectResult.LastModified),
+	}}, nil
+}
+
+func (h *s3Server) createMultipartUpload(bucket, key string, hdr http.Header) (interface{}, error) {
+	if _, err := h.mem.HeadBucket(&s3.HeadBucketInput{Bucket: &bucket}); err != nil {
+		return nil, s3Error(404, "NoSuchBucket", "The specified bucket does not exist")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.next++
+	id := fmt.Sprintf("upload-%d", h.next)
+	h.uploads[id] = &s3Upload{
+		bucket:      bucket,
+		key:         key,
+		contentType: hdr.Get("Content-Type"),
+		metadata:    requestMetadata(hdr),
+		parts:       make(map[int64][]byte),
+	}
+	return &struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		XMLNS    string   `xml:"xmlns,attr"`
+		Bucket   string
+		Key      string
+		UploadID string `xml:"UploadId"`
+	}{XMLNS: s3XMLNS, Bucket: bucket, Key: key, UploadID: id}, nil
+}
+
+// upload returns the upload with id for bucket/key. h.mu must be held.
+func (h *s3Server) upload(bucket, key, id string) (*s3Upload, error) {
+	u := h.uploads[id]
+	if u == nil || u.bucket != bucket || u.key != key {
+		return nil, s3Error(404, "NoSuchUpload", "The specified upload does not exist. The upload ID may be invalid, or the upload may have been aborted or completed.")
+	}
+	return u, nil
+}
+
+func (h *s3Server) uploadPart(bucket, key, id, partNumber, source string, r *http.Request, hdr http.Header) (interface{}, error) {
+	n, err := strconv.ParseInt(partNumber, 10, 64)
+	if err != nil || n < 1 || n > 10000 {
+		return nil, s3Error(400, "InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive")
+	}
+	var body []byte
+	if source != "" {
+		srcBucket, srcKey, err := parseCopySource(source)
+		if err != nil {
+			return nil, err
+		}
+		in := &s3.GetObjectInput{Bucket: &srcBucket, Key: &srcKey, Range: optionalHeader(r.Header, "X-Amz-Copy-Source-Range")}
+		out, err := h.mem.GetObject(in)
+		if err != nil {
+			return nil, err
+		}
+		body, _ = ioutil.ReadAll(out.Body)
+	} else if body, err = ioutil.ReadAll(r.Body); err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	u, err := h.upload(bucket, key, id)
+	if err != nil {
+		return nil, err
+	}
+	u.parts[n] = body
+	etag := `"` + md5Hex(string(body)) + `"`
+	if source == "" {
+		hdr.Set("ETag", etag)
+		return nil, nil
+	}
+	return &struct {
+		XMLName xml.Name `xml:"CopyPartResult"`
+		s3CopyResult
+	}{s3CopyResult: s3CopyResult{ETag: etag, LastModified: formatISO8601(h.mem.clock.Now())}}, nil
+}
+
+func (h *s3Server) completeMultipartUpload(bucket, key, id string, body io.Reader) (interface{}, error) {
+	var req struct {
+		Parts []struct {
+			PartNumber int64
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(body).Decode(&req); err != nil || len(req.Parts) == 0 {
+		return nil, s3Error(400, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema")
+	}
+
+	h.mu.Lock()
+	u, err := h.upload(bucket, key, id)
+	if err != nil {
+		h.mu.Unlock()
+		return nil, err
+	}
+	var object bytes.Buffer
+	sums := md5.New()
+	for i, p := range req.Parts {
+		part, ok := u.parts[p.PartNumber]
+		if !ok || p.ETag != `"`+md5Hex(string(part))+`"` {
+			h.mu.Unlock()
+			return nil, s3Error(400, "InvalidPart", "One or more of the specified parts could not be found. The part might not have been uploaded, or the specified entity tag might not have matched the part's entity tag.")
+		}
+		if i > 0 && p.PartNumber <= req.Parts[i-1].PartNumber {
+			h.mu.Unlock()
+			return nil, s3Error(400, "InvalidPartOrder", "The list of parts was not in ascending order. The parts list must be specified in order by part number.")
+		}
+		object.Write(part)
+		sum := md5.Sum(part)
+		sums.Write(sum[:])
+	}
+	delete(h.uploads, id)
+	h.mu.Unlock()
+
+	in := &s3.PutObjectInput{Bucket: &bucket, Key: &key, Body: bytes.NewReader(object.Bytes()), Metadata: u.metadata}
+	if u.contentType != "" {
+		in.ContentType = &u.contentType
+	}
+	if _, err := h.mem.PutObject(in); err != nil {
+		return nil, err
+	}
+	// Multipart ETags are the MD5 of the parts' MD5s, with the number
+	// of parts.
+	etag := fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sums.Sum(nil)), len(req.Parts))
+	h.mem.mu.Lock()
+	if b := h.mem.buckets[bucket]; b != nil && b.objects[key] != nil {
+		b.objects[key].etag = etag
+	}
+	h.mem.mu.Unlock()
+	return &struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		XMLNS   string   `xml:"xmlns,attr"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{XMLNS: s3XMLNS, Bucket: bucket, Key: key, ETag: etag}, nil
+}
+
+func (h *s3Server) abortMultipartUpload(bucket, key, id string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, err := h.upload(bucket, key, id); err != nil {
+		return err
+	}
+	delete(h.uploads, id)
+	return nil
+}
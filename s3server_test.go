@@ -0,0 +1,229 @@
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func ExampleWithInProcess() {
+	s := NewFakeS3("uploads", WithInProcess())
+	defer s.Close()
+
+	s.PutString("uploads", "hello.txt", "hello")
+	str, _ := s.GetString("uploads", "hello.txt")
+
+	fmt.Println(str)
+	// Output:
+	// hello
+}
+
+func TestInProcessS3Objects(t *testing.T) {
+	s := NewFakeS3("uploads", WithInProcess())
+	defer s.Close()
+
+	_, err := s.Client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String("uploads"),
+		Key:         aws.String("dir/a b.txt"),
+		Body:        strings.NewReader("hello, world"),
+		ContentType: aws.String("text/plain"),
+		Metadata:    map[string]*string{"owner": aws.String("jo")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := s.Client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("uploads"), Key: aws.String("dir/a b.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.Int64Value(head.ContentLength) != 12 || aws.StringValue(head.ContentType) != "text/plain" || aws.StringValue(head.Metadata["Owner"]) != "jo" {
+		t.Errorf("HeadObject = %v", head)
+	}
+	get, err := s.Client.GetObject(&s3.GetObjectInput{Bucket: aws.String("uploads"), Key: aws.String("dir/a b.txt"), Range: aws.String("bytes=7-")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(get.Body)
+	if string(body) != "world" || aws.StringValue(get.ContentRange) != "bytes 7-11/12" {
+		t.Errorf("ranged GetObject = %q, %s", body, aws.StringValue(get.ContentRange))
+	}
+
+	if err := s.CopyObject("uploads", "dir/a b.txt", "uploads", "copy.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if str, err := s.GetString("uploads", "copy.txt"); err != nil || str != "hello, world" {
+		t.Errorf("copy = %q, %v", str, err)
+	}
+
+	// A "+" in the source key is kept, not read as a space.
+	if err := s.PutString("uploads", "a+b.txt", "plus"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CopyObject("uploads", "a+b.txt", "uploads", "plus-copy.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if str, err := s.GetString("uploads", "plus-copy.txt"); err != nil || str != "plus" {
+		t.Errorf("copy of a+b.txt = %q, %v", str, err)
+	}
+
+	if _, err := s.Client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String("uploads"), Key: aws.String("copy.txt")}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Client.GetObject(&s3.GetObjectInput{Bucket: aws.String("uploads"), Key: aws.String("copy.txt")})
+	if !isErrorCode(err, "NoSuchKey") {
+		t.Errorf("GetObject after delete = %v", err)
+	}
+	_, err = s.Client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("uploads"), Key: aws.String("copy.txt")})
+	if !isErrorCode(err, "NotFound") {
+		t.Errorf("HeadObject after delete = %v", err)
+	}
+	_, err = s.Client.PutObject(&s3.PutObjectInput{Bucket: aws.String("missing"), Key: aws.String("a"), Body: strings.NewReader("")})
+	if !isErrorCode(err, "NoSuchBucket") {
+		t.Errorf("PutObject to missing bucket = %v", err)
+	}
+}
+
+func TestInProcessS3List(t *testing.T) {
+	s := NewFakeS3("uploads", WithInProcess())
+	defer s.Close()
+	for _, key := range []string{"a.txt", "logs/1", "logs/2", "z.txt"} {
+		if err := s.PutString("uploads", key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var pages [][]string
+	err := s.Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String("uploads"),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int64(2),
+	}, func(out *s3.ListObjectsV2Output, last bool) bool {
+		var page []string
+		for _, obj := range out.Contents {
+			page = append(page, aws.StringValue(obj.Key))
+		}
+		for _, p := range out.CommonPrefixes {
+			page = append(page, aws.StringValue(p.Prefix))
+		}
+		pages = append(pages, page)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"a.txt", "logs/"}, {"z.txt"}}; !reflect.DeepEqual(pages, want) {
+		t.Errorf("ListObjectsV2 pages = %v, want %v", pages, want)
+	}
+
+	keys, err := s.listKeys("uploads", "logs/")
+	if err != nil || !reflect.DeepEqual(keys, []string{"logs/1", "logs/2"}) {
+		t.Errorf("ListObjects = %v, %v", keys, err)
+	}
+
+	out, err := s.Client.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String("uploads"),
+		Delete: &s3.Delete{Objects: []*s3.ObjectIdentifier{{Key: aws.String("a.txt")}, {Key: aws.String("z.txt")}}},
+	})
+	if err != nil || len(out.Deleted) != 2 {
+		t.Fatalf("DeleteObjects = %v, %v", out, err)
+	}
+	if keys, _ := s.listKeys("uploads", ""); len(keys) != 2 {
+		t.Errorf("keys after DeleteObjects = %v", keys)
+	}
+}
+
+func TestInProcessS3Multipart(t *testing.T) {
+	s := NewFakeS3("uploads", WithInProcess(), WithMultipartCopy(4, 4))
+	defer s.Close()
+	bucket, key := aws.String("uploads"), aws.String("big")
+
+	upload, err := s.Client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: bucket, Key: key, ContentType: aws.String("text/plain")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts []*s3.CompletedPart
+	for i, body := range []string{"hello, ", "world"} {
+		n := aws.Int64(int64(i + 1))
+		out, err := s.Client.UploadPart(&s3.UploadPartInput{Bucket: bucket, Key: key, UploadId: upload.UploadId, PartNumber: n, Body: bytes.NewReader([]byte(body))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: out.ETag, PartNumber: n})
+	}
+	if _, err := s.GetString("uploads", "big"); !isErrorCode(err, "NoSuchKey") {
+		t.Errorf("object visible before upload completed: %v", err)
+	}
+	done, err := s.Client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          bucket,
+		Key:             key,
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(aws.StringValue(done.ETag), `-2"`) {
+		t.Errorf("multipart ETag = %s", aws.StringValue(done.ETag))
+	}
+	if str, err := s.GetString("uploads", "big"); err != nil || str != "hello, world" {
+		t.Errorf("completed upload = %q, %v", str, err)
+	}
+
+	// Larger than the copy threshold, so copied with UploadPartCopy.
+	if err := s.CopyObject("uploads", "big", "uploads", "big-copy"); err != nil {
+		t.Fatal(err)
+	}
+	if str, err := s.GetString("uploads", "big-copy"); err != nil || str != "hello, world" {
+		t.Errorf("multipart copy = %q, %v", str, err)
+	}
+
+	_, err = s.Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{Bucket: bucket, Key: key, UploadId: upload.UploadId})
+	if !isErrorCode(err, "NoSuchUpload") {
+		t.Errorf("aborting a completed upload = %v", err)
+	}
+}
+
+func TestMoveObjectOntoItself(t *testing.T) {
+	s := NewFakeS3("uploads", WithInProcess())
+	defer s.Close()
+	if err := s.PutString("uploads", "a.txt", "kept"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MoveObject("uploads", "a.txt", "uploads", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if str, err := s.GetString("uploads", "a.txt"); err != nil || str != "kept" {
+		t.Errorf("after moving onto itself = %q, %v", str, err)
+	}
+	if err := s.MoveObject("uploads", "missing", "uploads", "missing"); err == nil {
+		t.Error("moving a missing object onto itself succeeded")
+	}
+}
+
+func TestInProcessS3Separate(t *testing.T) {
+	a, err := StartFakeS3(context.Background(), "uploads", WithInProcess())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := StartFakeS3(context.Background(), "uploads", WithInProcess())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	a.PutString("uploads", "a.txt", "A")
+	if _, err := b.GetString("uploads", "a.txt"); !isErrorCode(err, "NoSuchKey") {
+		t.Errorf("in-process fakes share objects: %v", err)
+	}
+	if a.backend.Name != "in-process" {
+		t.Errorf("backend = %+v", a.backend)
+	}
+}
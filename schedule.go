@@ -0,0 +1,328 @@
+package testutil
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// FailureSchedule injects faults into chosen calls to the AWS fakes, as
+// described by a small language, so that a failure scenario from a
+// postmortem can be kept as a regression test. Attach it with
+// WithFailureSchedule.
+//
+// A schedule is a list of rules separated by semicolons or newlines.
+// Each rule names an operation, what happens to it, and optionally
+// which calls and when:
+//
+//	3rd sqs:ReceiveMessage errors
+//	every 2nd s3:GetObject drops
+//	first 5 kms:* throttles
+//	s3:PutObject latency 2s between t=5s and t=8s
+//	sqs:SendMessage errors InvalidParameterValue after t=10s
+//
+// Operations are named as in Policy, and a bare operation name such as
+// "ReceiveMessage" matches it in any service. The faults are:
+//
+//	errors         the service's retryable 5xx error
+//	errors CODE    a 400 error with the given code
+//	throttles      the service's throttling error
+//	drops          the connection drops before a response arrives
+//	latency D      the call is delayed by D, then goes through
+//
+// Calls to a rule's operation are counted from 1, retries included, so
+// "1st s3:PutObject errors" fails the first attempt and lets the SDK's
+// retry through. Times are measured from when the schedule was parsed
+// or last started. The first rule that applies to a call decides its
+// fault.
+type FailureSchedule struct {
+	rules []*scheduleRule
+
+	mu    sync.Mutex
+	clock Clock
+	start time.Time
+	fired []ScheduledFault
+}
+
+// ScheduledFault is a fault injected by a FailureSchedule.
+type ScheduledFault struct {
+	// Rule is the rule that caused the fault, as written.
+	Rule string `json:"rule"`
+
+	// Operation is the operation affected, such as "sqs:SendMessage".
+	Operation string `json:"operation"`
+
+	// Call is which call to the rule's operation was affected,
+	// counting from 1.
+	Call int `json:"call"`
+
+	// At is when the call was made, relative to the start of the
+	// schedule.
+	At time.Duration `json:"at"`
+}
+
+func (f ScheduledFault) String() string {
+	return fmt.Sprintf("%s call %d at t=%v: %s", f.Operation, f.Call, f.At, f.Rule)
+}
+
+type scheduleRule struct {
+	text      string
+	operation string
+
+	// The rule applies to the nth call if n == nth, if n is a
+	// multiple of every, or if n <= first. All calls match if all
+	// three are 0.
+	nth, every, first int
+
+	// The rule applies from after until before; a zero before means
+	// forever.
+	after, before time.Duration
+
+	kind    string // "error", "throttle", "drop" or "latency"
+	code    string
+	latency time.Duration
+
+	calls int
+}
+
+var ordinal = regexp.MustCompile(`^(\d+)(st|nd|rd|th)$`)
+
+// ParseFailureSchedule parses a schedule written in the language
+// described on FailureSchedule. The schedule starts right away, using
+// the system clock.
+func ParseFailureSchedule(spec string) (*FailureSchedule, error) {
+	s := &FailureSchedule{}
+	for _, line := range strings.FieldsFunc(spec, func(r rune) bool { return r == ';' || r == '\n' }) {
+		text := strings.Join(strings.Fields(line), " ")
+		if text == "" {
+			continue
+		}
+		rule, err := parseScheduleRule(text)
+		if err != nil {
+			return nil, fmt.Errorf("failure schedule: %q: %v", text, err)
+		}
+		s.rules = append(s.rules, rule)
+	}
+	s.Start(SystemClock)
+	return s, nil
+}
+
+// MustParseFailureSchedule is like ParseFailureSchedule but panics if
+// spec is invalid.
+func MustParseFailureSchedule(spec string) *FailureSchedule {
+	s, err := ParseFailureSchedule(spec)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func parseScheduleRule(text string) (*scheduleRule, error) {
+	rule := &scheduleRule{text: text}
+	words := strings.Fields(text)
+	next := func() string {
+		if len(words) == 0 {
+			return ""
+		}
+		w := words[0]
+		words = words[1:]
+		return w
+	}
+	nth := func(w string) (int, error) {
+		m := ordinal.FindStringSubmatch(w)
+		if m == nil {
+			return 0, fmt.Errorf("expected an ordinal such as 3rd, got %q", w)
+		}
+		n, _ := strconv.Atoi(m[1])
+		if n == 0 {
+			return 0, fmt.Errorf("calls are counted from 1st")
+		}
+		return n, nil
+	}
+	at := func() (time.Duration, error) {
+		w := next()
+		d, err := time.ParseDuration(strings.TrimPrefix(w, "t="))
+		if err != nil {
+			return 0, fmt.Errorf("expected a time such as t=5s, got %q", w)
+		}
+		return d, nil
+	}
+
+	var err error
+	w := next()
+	switch {
+	case w == "every":
+		if rule.every, err = nth(next()); err != nil {
+			return nil, err
+		}
+		w = next()
+	case w == "first":
+		if rule.first, err = strconv.Atoi(next()); err != nil || rule.first < 1 {
+			return nil, fmt.Errorf("expected a number of calls after first")
+		}
+		w = next()
+	case ordinal.MatchString(w):
+		if rule.nth, err = nth(w); err != nil {
+			return nil, err
+		}
+		w = next()
+	}
+	if w == "" {
+		return nil, fmt.Errorf("missing operation")
+	}
+	rule.operation = w
+
+	switch w := next(); w {
+	case "errors":
+		rule.kind = "error"
+		if len(words) > 0 && words[0] != "between" && words[0] != "after" && words[0] != "before" {
+			rule.code = next()
+		}
+	case "throttles":
+		rule.kind = "throttle"
+	case "drops":
+		rule.kind = "drop"
+	case "latency":
+		rule.kind = "latency"
+		if rule.latency, err = time.ParseDuration(next()); err != nil || rule.latency <= 0 {
+			return nil, fmt.Errorf("expected a duration after latency")
+		}
+	case "":
+		return nil, fmt.Errorf("missing fault")
+	default:
+		return nil, fmt.Errorf("unknown fault %q", w)
+	}
+
+	switch w := next(); w {
+	case "between":
+		if rule.after, err = at(); err != nil {
+			return nil, err
+		}
+		if next() != "and" {
+			return nil, fmt.Errorf("expected between T and T")
+		}
+		if rule.before, err = at(); err != nil {
+			return nil, err
+		}
+		if rule.before <= rule.after {
+			return nil, fmt.Errorf("window ends before it starts")
+		}
+	case "after":
+		if rule.after, err = at(); err != nil {
+			return nil, err
+		}
+	case "before":
+		if rule.before, err = at(); err != nil {
+			return nil, err
+		}
+	case "":
+	default:
+		return nil, fmt.Errorf("unexpected %q", w)
+	}
+	if len(words) > 0 {
+		return nil, fmt.Errorf("unexpected %q", strings.Join(words, " "))
+	}
+	return rule, nil
+}
+
+// Start restarts the schedule's time at t=0 and its call counts at 0,
+// and makes it read the time from clock, which may be a FakeClock. It
+// forgets the faults injected so far.
+func (s *FailureSchedule) Start(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+	s.start = clock.Now()
+	s.fired = nil
+	for _, rule := range s.rules {
+		rule.calls = 0
+	}
+}
+
+// Fired returns the faults injected so far, in the order they were
+// injected.
+func (s *FailureSchedule) Fired() []ScheduledFault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ScheduledFault(nil), s.fired...)
+}
+
+// WithFailureSchedule injects the faults described by s into a fake's
+// requests. Requests the fake makes while being set up are spared.
+func WithFailureSchedule(s *FailureSchedule) Option {
+	return func(o *options) {
+		o.sendHooks = append(o.sendHooks, s.sendHook)
+	}
+}
+
+// decide returns the rule, if any, that applies to the next call to
+// operation.
+func (s *FailureSchedule) decide(operation string) *scheduleRule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now().Sub(s.start)
+	var applied *scheduleRule
+	for _, rule := range s.rules {
+		if !rule.matches(operation) {
+			continue
+		}
+		rule.calls++
+		if applied != nil || !rule.applies(now) {
+			continue
+		}
+		applied = rule
+		s.fired = append(s.fired, ScheduledFault{Rule: rule.text, Operation: operation, Call: rule.calls, At: now})
+	}
+	return applied
+}
+
+func (rule *scheduleRule) matches(operation string) bool {
+	if !strings.Contains(rule.operation, ":") && rule.operation != "*" {
+		i := strings.Index(operation, ":")
+		return operation[i+1:] == rule.operation
+	}
+	return matchOperation(rule.operation, operation)
+}
+
+func (rule *scheduleRule) applies(now time.Duration) bool {
+	if now < rule.after || (rule.before > 0 && now >= rule.before) {
+		return false
+	}
+	n := rule.calls
+	switch {
+	case rule.nth > 0:
+		return n == rule.nth
+	case rule.every > 0:
+		return n%rule.every == 0
+	case rule.first > 0:
+		return n <= rule.first
+	}
+	return true
+}
+
+func (s *FailureSchedule) sendHook(r *request.Request) *http.Response {
+	rule := s.decide(operationName(r))
+	if rule == nil {
+		return nil
+	}
+	switch rule.kind {
+	case "error":
+		if rule.code != "" {
+			return errorResponse(r, http.StatusBadRequest, rule.code, "Injected by failure schedule")
+		}
+		return serverErrorResponse(r)
+	case "throttle":
+		return throttledResponse(r)
+	case "drop":
+		dropRequest(r, errChaosDrop)
+	case "latency":
+		time.Sleep(rule.latency)
+	}
+	return nil
+}
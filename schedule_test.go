@@ -0,0 +1,72 @@
+package testutil
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+func TestFailureSchedule(t *testing.T) {
+	s := MustParseFailureSchedule(`
+		2nd kms:Encrypt errors AccessDenied
+		every 3rd Encrypt drops; kms:Encrypt throttles between t=5s and t=8s
+		kms:Decrypt latency 1ms`)
+	clock := NewFakeClock(time.Unix(0, 0))
+	s.Start(clock)
+	k := NewFakeKMS(WithFailureSchedule(s))
+	defer k.Close()
+	key := k.CreateKey("")
+
+	var outcomes []string
+	for i := 0; i < 5; i++ {
+		req, _ := k.Client.EncryptRequest(&kms.EncryptInput{KeyId: aws.String(key), Plaintext: []byte("x")})
+		req.Retryer = client.DefaultRetryer{NumMaxRetries: 0}
+		outcomes = append(outcomes, errorCode(req.Send()))
+		if i == 3 {
+			clock.Advance(6 * time.Second)
+		}
+	}
+	want := []string{"", "AccessDenied", "RequestError", "", "ThrottlingException"}
+	if !reflect.DeepEqual(outcomes, want) {
+		t.Errorf("outcomes = %q, want %q", outcomes, want)
+	}
+
+	// The 6th call is dropped, and the retry goes through.
+	clock.Advance(3 * time.Second)
+	out, err := k.Client.Encrypt(&kms.EncryptInput{KeyId: aws.String(key), Plaintext: []byte("x")})
+	if err != nil {
+		t.Fatalf("Encrypt after the window: %v", err)
+	}
+	if _, err := k.Client.Decrypt(&kms.DecryptInput{CiphertextBlob: out.CiphertextBlob}); err != nil {
+		t.Errorf("Decrypt: %v", err)
+	}
+
+	fired := s.Fired()
+	if len(fired) != 5 || fired[1].Rule != "every 3rd Encrypt drops" || fired[2].At != 6*time.Second {
+		t.Errorf("Fired = %v", fired)
+	}
+	if last := fired[len(fired)-1]; last.Operation != "kms:Decrypt" || last.Call != 1 {
+		t.Errorf("last fault = %v, want the Decrypt latency", last)
+	}
+}
+
+func TestParseFailureScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"3rd",
+		"0th s3:PutObject errors",
+		"s3:PutObject explodes",
+		"s3:PutObject latency",
+		"first none s3:PutObject drops",
+		"s3:PutObject drops between t=8s and t=5s",
+		"s3:PutObject drops after soon",
+		"s3:PutObject drops please",
+	} {
+		if _, err := ParseFailureSchedule(spec); err == nil {
+			t.Errorf("ParseFailureSchedule(%q) succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package testutil
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// maxRequestSkew is how far a request's signing time may be from the
+// server's before AWS rejects it.
+const maxRequestSkew = 15 * time.Minute
+
+// amzDateFormat is the format of the X-Amz-Date header.
+const amzDateFormat = "20060102T150405Z"
+
+// WithClockSkew makes an AWS fake's clients sign requests as if their
+// clock were off by skew, and makes the fake reject requests whose
+// signing time is more than 15 minutes from the real time, as AWS
+// does: S3 with 403 RequestTimeTooSkewed and SQS with 403
+// SignatureDoesNotMatch. Use it to trigger signature-expiry handling
+// deterministically.
+func WithClockSkew(skew time.Duration) Option {
+	return func(o *options) {
+		o.signHooks = append(o.signHooks, skewedSigningTime(SystemClock, skew))
+		o.sendHooks = append(o.sendHooks, rejectSkewedRequests(SystemClock))
+	}
+}
+
+// WithSigningClock makes an AWS fake's clients sign requests with the
+// time of clock instead of the real time, and makes the fake reject
+// requests whose signing time is more than 15 minutes from the real
+// time. With a FakeClock, tests can move the client's clock relative to
+// the fake's while a test runs.
+func WithSigningClock(clock Clock) Option {
+	return func(o *options) {
+		o.signHooks = append(o.signHooks, skewedSigningTime(clock, 0))
+		o.sendHooks = append(o.sendHooks, rejectSkewedRequests(SystemClock))
+	}
+}
+
+// skewedSigningTime returns a Sign handler that makes the signer use
+// clock's time plus skew.
+func skewedSigningTime(clock Clock, skew time.Duration) func(*request.Request) {
+	return func(r *request.Request) {
+		r.Time = clock.Now().Add(skew)
+		// The signer prefers the time of the previous signature when
+		// retrying, which would undo the skew.
+		r.LastSignedAt = time.Time{}
+	}
+}
+
+// rejectSkewedRequests returns a sendHook that fails requests signed
+// more than maxRequestSkew away from server's time.
+func rejectSkewedRequests(server Clock) sendHook {
+	return func(r *request.Request) *http.Response {
+		signed, err := time.Parse(amzDateFormat, r.HTTPRequest.Header.Get("X-Amz-Date"))
+		if err != nil {
+			return nil
+		}
+		now := server.Now()
+		diff := signed.Sub(now)
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff <= maxRequestSkew {
+			return nil
+		}
+
+		if r.ClientInfo.ServiceName == "s3" {
+			return errorResponse(r, http.StatusForbidden, "RequestTimeTooSkewed",
+				"The difference between the request time and the current time is too large.")
+		}
+		return errorResponse(r, http.StatusForbidden, "SignatureDoesNotMatch",
+			fmt.Sprintf("Signature expired: %s is now earlier than %s (%s - 15 min.)",
+				signed.Format(amzDateFormat), now.Add(-maxRequestSkew).UTC().Format(amzDateFormat), now.UTC().Format(amzDateFormat)))
+	}
+}
@@ -0,0 +1,66 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestWithClockSkew(t *testing.T) {
+	o := newOptions([]Option{WithClockSkew(-time.Hour)})
+	cfg := fakeAWSConfig("http://127.0.0.1:1", o).WithMaxRetries(0)
+	sess := session.New(cfg)
+
+	s3Client := s3.New(sess)
+	installHooks(o, &s3Client.Handlers)
+	_, err := s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("key"),
+	})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "RequestTimeTooSkewed" {
+		t.Errorf("expected RequestTimeTooSkewed from S3, got %v", err)
+	}
+
+	sqsClient := sqs.New(sess)
+	installHooks(o, &sqsClient.Handlers)
+	_, err = sqsClient.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String("http://127.0.0.1:1/queue"),
+		MessageBody: aws.String("hello"),
+	})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "SignatureDoesNotMatch" {
+		t.Errorf("expected SignatureDoesNotMatch from SQS, got %v", err)
+	}
+}
+
+func TestWithSigningClock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	o := newOptions([]Option{WithSigningClock(clock)})
+	cfg := fakeAWSConfig("http://127.0.0.1:1", o).WithMaxRetries(0)
+	client := s3.New(session.New(cfg))
+	installHooks(o, &client.Handlers)
+
+	get := func() error {
+		_, err := client.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("key"),
+		})
+		return err
+	}
+
+	// Within the allowed skew the request is sent, and fails only
+	// because nothing is listening.
+	clock.Advance(10 * time.Minute)
+	if aerr, ok := get().(awserr.Error); !ok || aerr.Code() == "RequestTimeTooSkewed" {
+		t.Errorf("expected a connection error, got %v", aerr)
+	}
+
+	clock.Advance(10 * time.Minute)
+	if aerr, ok := get().(awserr.Error); !ok || aerr.Code() != "RequestTimeTooSkewed" {
+		t.Errorf("expected RequestTimeTooSkewed, got %v", aerr)
+	}
+}
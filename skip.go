@@ -0,0 +1,69 @@
+package testutil
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// SkipT is the part of testing.TB used by the Skip helpers. *testing.T
+// and *testing.B satisfy it.
+type SkipT interface {
+	Helper()
+	Skipf(format string, args ...interface{})
+}
+
+// SkipWithoutRedis skips the test if redis isn't listening on its
+// default port, so that contributors running unit tests only aren't
+// blocked by integration-test infrastructure.
+func SkipWithoutRedis(t SkipT) {
+	t.Helper()
+	SkipWithout(t, RedisDependency)
+}
+
+// SkipWithoutDocker skips the test if the docker command is missing or
+// can't reach a Docker daemon.
+func SkipWithoutDocker(t SkipT) {
+	t.Helper()
+	docker, err := exec.LookPath("docker")
+	if err != nil {
+		t.Skipf("Skipping: docker is not in $PATH")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, docker, "info").CombinedOutput(); err != nil {
+		t.Skipf("Skipping: docker can't reach a daemon (%v): %s", err, lastLine(out))
+	}
+}
+
+// SkipWithoutBinary skips the test if name isn't an executable in
+// $PATH.
+func SkipWithoutBinary(t SkipT, name string) {
+	t.Helper()
+	SkipWithout(t, Dependency{Name: name, Binary: name, Hint: "install it to run this test"})
+}
+
+// SkipWithout skips the test if any of deps fails Preflight, giving the
+// problems found as the reason.
+func SkipWithout(t SkipT, deps ...Dependency) {
+	t.Helper()
+	if err := Preflight(deps...); err != nil {
+		t.Skipf("Skipping: %v", err)
+	}
+}
+
+// lastLine returns the last line of out, which is where commands usually
+// say what went wrong.
+func lastLine(out []byte) string {
+	s := string(out)
+	for len(s) > 0 && (s[len(s)-1] == '\n' || s[len(s)-1] == '\r') {
+		s = s[:len(s)-1]
+	}
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] == '\n' {
+			return s[i+1:]
+		}
+	}
+	return s
+}
@@ -0,0 +1,40 @@
+package testutil
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type skippingT struct {
+	skipped string
+}
+
+func (s *skippingT) Helper() {}
+
+func (s *skippingT) Skipf(format string, args ...interface{}) {
+	s.skipped = fmt.Sprintf(format, args...)
+}
+
+func TestSkipWithoutBinary(t *testing.T) {
+	st := &skippingT{}
+	SkipWithoutBinary(st, "no-such-binary-testutil")
+	if !strings.HasPrefix(st.skipped, "Skipping: preflight checks failed:\n  no-such-binary-testutil needs no-such-binary-testutil, which is not in $PATH") {
+		t.Errorf("skipped with %q", st.skipped)
+	}
+
+	st = &skippingT{}
+	SkipWithoutBinary(st, "go")
+	if st.skipped != "" {
+		t.Errorf("skipped with go in $PATH: %q", st.skipped)
+	}
+}
+
+func TestSkipWithout(t *testing.T) {
+	free, _ := FreePort()
+	st := &skippingT{}
+	SkipWithout(st, Dependency{Name: "redis", Addr: fmt.Sprintf("127.0.0.1:%d", free), Hint: "start it"})
+	if !strings.Contains(st.skipped, fmt.Sprintf("nothing is listening on port %d for redis", free)) {
+		t.Errorf("skipped with %q", st.skipped)
+	}
+}
@@ -0,0 +1,120 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Save and Load keep fake state in a directory, so that an expensive
+// seeded environment can be reused across test runs or checked in as a
+// fixture. Several fakes can share a directory:
+//
+//	dir/s3/{bucket}/{key}  each object's content
+//	dir/sqs/{queue}.json   each queue's waiting messages
+//	dir/redis.json         the redis test DB, see redistest.FakeRedis.Save
+
+// savedMessage is a message as stored by FakeSQS.Save.
+type savedMessage struct {
+	Body       string                                `json:"body"`
+	Attributes map[string]*sqs.MessageAttributeValue `json:"attributes,omitempty"`
+}
+
+// Save writes every object in the fake's bucket under dir/s3/{bucket}.
+// Object keys become file paths, so keys that aren't clean relative
+// paths, such as "a//b" or "dir/", can't be saved.
+func (s *FakeS3) Save(dir string) error {
+	root := filepath.Join(dir, "s3", s.bucket)
+	if err := os.RemoveAll(root); err != nil {
+		return err
+	}
+	if err := s.DumpBucket(s.bucket, root); err != nil {
+		return fmt.Errorf("saving bucket %s: %v", s.bucket, err)
+	}
+	return nil
+}
+
+// saveObject writes body as the saved object bucket/key in dir.
+func saveObject(dir, bucket, key string, body []byte) error {
+	return writeObjectFile(filepath.Join(dir, "s3", bucket), key, body)
+}
+
+// Load replaces the contents of the fake's bucket with the objects
+// saved under dir/s3/{bucket}. Content types are guessed from the keys
+// and contents, as by PutFile. A missing directory loads an empty
+// bucket.
+func (s *FakeS3) Load(dir string) error {
+	s.Reset()
+	root := filepath.Join(dir, "s3", s.bucket)
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil
+	}
+	if err := s.LoadFixtures(s.bucket, root); err != nil {
+		return fmt.Errorf("loading bucket %s: %v", s.bucket, err)
+	}
+	return nil
+}
+
+// Save writes the messages waiting in the fake's queue, with their
+// message attributes, to dir/sqs/{queue}.json. Messages in flight
+// aren't saved, and reading the queue counts as a receive of each
+// message, as for the admin API.
+func (s *FakeSQS) Save(dir string) error {
+	name := queueNameFromURL(s.URL)
+	msgs, err := peekMessages(s)
+	if err != nil {
+		return fmt.Errorf("saving queue %s: %v", name, err)
+	}
+	saved := make([]savedMessage, len(msgs))
+	for i, m := range msgs {
+		saved[i] = savedMessage{Body: aws.StringValue(m.Body), Attributes: m.MessageAttributes}
+	}
+	return saveMessages(dir, name, saved)
+}
+
+// saveMessages writes msgs as the saved messages of queue in dir.
+func saveMessages(dir, queue string, msgs []savedMessage) error {
+	b, err := json.MarshalIndent(msgs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "sqs"), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "sqs", queue+".json"), append(b, '\n'), 0644)
+}
+
+// Load purges the fake's queue and sends it the messages saved in
+// dir/sqs/{queue}.json, in order. A missing file loads an empty queue.
+func (s *FakeSQS) Load(dir string) error {
+	name := queueNameFromURL(s.URL)
+	b, err := ioutil.ReadFile(filepath.Join(dir, "sqs", name+".json"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var saved []savedMessage
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &saved); err != nil {
+			return fmt.Errorf("loading queue %s: %v", name, err)
+		}
+	}
+	if _, err := s.Client.PurgeQueue(&sqs.PurgeQueueInput{QueueUrl: &s.URL}); err != nil {
+		return fmt.Errorf("loading queue %s: %v", name, err)
+	}
+	for _, m := range saved {
+		_, err := s.Client.SendMessage(&sqs.SendMessageInput{
+			QueueUrl:          &s.URL,
+			MessageBody:       aws.String(m.Body),
+			MessageAttributes: m.Attributes,
+		})
+		if err != nil {
+			return fmt.Errorf("loading queue %s: %v", name, err)
+		}
+	}
+	return nil
+}
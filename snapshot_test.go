@@ -0,0 +1,82 @@
+package testutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	sqsSrv := httptest.NewServer(&memSQS{})
+	defer sqsSrv.Close()
+	q := NewFakeSQS("jobs", WithEndpoint(sqsSrv.URL), WithLogger(t))
+	defer q.Close()
+	s3Srv := httptest.NewServer(&memS3{objects: make(map[string]string)})
+	defer s3Srv.Close()
+	s := NewFakeS3("assets", WithEndpoint(s3Srv.URL), WithLogger(t))
+	defer s.Close()
+
+	q.SendMessage("one", nil)
+	q.SendMessage("two", nil)
+	s.PutString("assets", "a.txt", "A")
+	s.PutString("assets", "dir/b.json", `{"b":1}`)
+
+	dir := t.TempDir()
+	if err := q.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "s3", "assets", "dir", "b.json")); err != nil || string(b) != `{"b":1}` {
+		t.Errorf("saved object = %q, %v", b, err)
+	}
+
+	q.Reset()
+	q.SendMessage("stale", nil)
+	s.Reset()
+	s.PutString("assets", "stale.txt", "stale")
+	if err := q.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Load(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := peekMessages(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bodies []string
+	for _, m := range msgs {
+		bodies = append(bodies, *m.Body)
+	}
+	if fmt.Sprint(bodies) != "[one two]" {
+		t.Errorf("loaded messages %q, want [one two]", bodies)
+	}
+	keys, err := s.listKeys("assets", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(keys) != "[a.txt dir/b.json]" {
+		t.Errorf("loaded keys %q, want [a.txt dir/b.json]", keys)
+	}
+
+	empty := t.TempDir()
+	if err := q.Load(empty); err != nil {
+		t.Errorf("loading a missing queue: %v", err)
+	}
+	if err := s.Load(empty); err != nil {
+		t.Errorf("loading a missing bucket: %v", err)
+	}
+	if keys, _ := s.listKeys("assets", ""); len(keys) != 0 {
+		t.Errorf("bucket not emptied by loading nothing: %v", keys)
+	}
+
+	s.PutString("assets", "dir/", "")
+	if err := s.Save(dir); err == nil {
+		t.Error("saved a key that isn't a clean path")
+	}
+}
@@ -0,0 +1,563 @@
+package testutil
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// snsTimeFormat is the format of the Timestamp of an SNS notification.
+const snsTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// FakeSNS is an in-process fake of SNS that fans the messages published
+// to its topics out to FakeSQS queues subscribed to them, so that a
+// publish, queue and consume flow can be tested end to end. Queues are
+// subscribed with SubscribeQueue, or through Client with the sqs
+// protocol and an ARN from QueueARN, the way the code under test would.
+//
+// Deliveries happen before Publish returns. Like SNS, a queue receives
+// the JSON notification envelope, an SNSMessage, unless the
+// subscription has RawMessageDelivery set, in which case it receives
+// the message itself with its message attributes. Envelopes are signed
+// by an SNSSigner the fake starts on its first delivery, so
+// VerifySNSSignature accepts them. Subscriptions need no confirmation,
+// and only the sqs protocol is supported.
+type FakeSNS struct {
+	// Client is an SNS client set up for the fake.
+	Client *sns.SNS
+
+	// Session is an AWS Session that uses the fake config.
+	Session *session.Session
+
+	// Config is the AWS config the Session was built from.
+	Config *aws.Config
+
+	// URL is the fake's endpoint.
+	URL string
+
+	region     string
+	clock      Clock
+	logger     Logger
+	srv        *httptest.Server
+	unregister func()
+
+	signerOnce sync.Once
+	signer     *SNSSigner
+
+	mu        sync.Mutex
+	topics    map[string]*snsTopic
+	subs      map[string]*snsSubscription
+	queues    map[string]*FakeSQS
+	published map[string][]SNSMessage
+}
+
+type snsTopic struct {
+	arn  string
+	subs []string
+}
+
+type snsSubscription struct {
+	arn      string
+	topic    string
+	protocol string
+	endpoint string
+	attrs    map[string]string
+}
+
+func (s *snsSubscription) raw() bool {
+	return s.attrs["RawMessageDelivery"] == "true"
+}
+
+// NewFakeSNS starts a FakeSNS with no topics. Notification timestamps
+// come from the Clock set with WithClock.
+func NewFakeSNS(opts ...Option) *FakeSNS {
+	o := newOptions(opts)
+	f := &FakeSNS{
+		region:    o.region,
+		clock:     o.clock,
+		logger:    o.logger,
+		topics:    make(map[string]*snsTopic),
+		subs:      make(map[string]*snsSubscription),
+		queues:    make(map[string]*FakeSQS),
+		published: make(map[string][]SNSMessage),
+	}
+	if f.region == "" {
+		f.region = defaultRegion
+	}
+	f.srv = httptest.NewServer(&queryHandler{
+		xmlns: "http://sns.amazonaws.com/doc/2010-03-31/",
+		operations: map[string]func(url.Values) (interface{}, error){
+			"CreateTopic":               f.createTopic,
+			"DeleteTopic":               f.deleteTopic,
+			"ListTopics":                f.listTopics,
+			"GetTopicAttributes":        f.getTopicAttributes,
+			"Subscribe":                 f.subscribe,
+			"Unsubscribe":               f.unsubscribe,
+			"ListSubscriptions":         f.listSubscriptions,
+			"ListSubscriptionsByTopic":  f.listSubscriptionsByTopic,
+			"GetSubscriptionAttributes": f.getSubscriptionAttributes,
+			"SetSubscriptionAttributes": f.setSubscriptionAttributes,
+			"Publish":                   f.publish,
+		},
+	})
+	f.URL = f.srv.URL
+	f.Config = fakeAWSConfig(f.URL, o)
+	f.Session = session.New(f.Config)
+	f.Client = sns.New(f.Session)
+	installHooks(o, &f.Session.Handlers, &f.Client.Handlers)
+	f.unregister = OnInterrupt(f.Close)
+	return f
+}
+
+// Close stops the fake.
+func (f *FakeSNS) Close() {
+	if f.unregister != nil {
+		f.unregister()
+	}
+	f.srv.Close()
+	// Running the once keeps a later delivery from starting a signer.
+	f.signerOnce.Do(func() {})
+	if f.signer != nil {
+		f.signer.Close()
+	}
+}
+
+// CreateTopic creates the topic name, if it doesn't exist, and returns
+// its ARN.
+func (f *FakeSNS) CreateTopic(name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.topic(name).arn
+}
+
+// QueueARN registers q as a queue the fake can deliver to and returns
+// its ARN, for subscribing q through Client.
+func (f *FakeSNS) QueueARN(q *FakeSQS) string {
+	arn := fmt.Sprintf("arn:aws:sqs:%s:%s:%s", f.region, fakeAccountID, queueNameFromURL(q.URL))
+	f.mu.Lock()
+	f.queues[arn] = q
+	f.mu.Unlock()
+	return arn
+}
+
+// SubscribeQueue subscribes q to the topic topicARN and returns the
+// subscription's ARN. With raw, q receives published messages as they
+// are, rather than wrapped in a notification envelope.
+func (f *FakeSNS) SubscribeQueue(topicARN string, q *FakeSQS, raw bool) (string, error) {
+	endpoint := f.QueueARN(q)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.addSubscription(topicARN, "sqs", endpoint)
+	if err != nil {
+		return "", err
+	}
+	s.attrs["RawMessageDelivery"] = strconv.FormatBool(raw)
+	return s.arn, nil
+}
+
+// Published returns the messages published to the topic topicARN, in
+// order, as they would be delivered in a notification envelope.
+func (f *FakeSNS) Published(topicARN string) []SNSMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]SNSMessage(nil), f.published[topicARN]...)
+}
+
+// topic returns the topic name, creating it if needed. f.mu must be
+// held.
+func (f *FakeSNS) topic(name string) *snsTopic {
+	arn := fmt.Sprintf("arn:aws:sns:%s:%s:%s", f.region, fakeAccountID, name)
+	t, ok := f.topics[arn]
+	if !ok {
+		t = &snsTopic{arn: arn}
+		f.topics[arn] = t
+	}
+	return t
+}
+
+func (f *FakeSNS) lookupTopic(arn string) (*snsTopic, error) {
+	t, ok := f.topics[arn]
+	if !ok {
+		return nil, newAWSError(http.StatusNotFound, "NotFound", "Topic does not exist")
+	}
+	return t, nil
+}
+
+func (f *FakeSNS) lookupSubscription(arn string) (*snsSubscription, error) {
+	s, ok := f.subs[arn]
+	if !ok {
+		return nil, newAWSError(http.StatusNotFound, "NotFound", "Subscription does not exist")
+	}
+	return s, nil
+}
+
+// addSubscription subscribes endpoint to the topic topicARN, returning
+// the existing subscription if there is one. f.mu must be held.
+func (f *FakeSNS) addSubscription(topicARN, protocol, endpoint string) (*snsSubscription, error) {
+	t, err := f.lookupTopic(topicARN)
+	if err != nil {
+		return nil, err
+	}
+	if protocol != "sqs" {
+		return nil, newAWSError(http.StatusBadRequest, "InvalidParameter", "Invalid parameter: protocol %s is not supported by the fake", protocol)
+	}
+	for _, arn := range t.subs {
+		if s := f.subs[arn]; s.endpoint == endpoint {
+			return s, nil
+		}
+	}
+	s := &snsSubscription{
+		arn:      topicARN + ":" + randomUUID(),
+		topic:    topicARN,
+		protocol: protocol,
+		endpoint: endpoint,
+		attrs:    map[string]string{"RawMessageDelivery": "false"},
+	}
+	f.subs[s.arn] = s
+	t.subs = append(t.subs, s.arn)
+	return s, nil
+}
+
+func (f *FakeSNS) createTopic(form url.Values) (interface{}, error) {
+	name := form.Get("Name")
+	if name == "" || strings.ContainsAny(name, ":/ ") {
+		return nil, newAWSError(http.StatusBadRequest, "InvalidParameter", "Invalid parameter: Topic Name")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return struct{ TopicArn string }{f.topic(name).arn}, nil
+}
+
+func (f *FakeSNS) deleteTopic(form url.Values) (interface{}, error) {
+	arn := form.Get("TopicArn")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Like SNS, deleting a topic that doesn't exist succeeds.
+	if t, ok := f.topics[arn]; ok {
+		for _, s := range t.subs {
+			delete(f.subs, s)
+		}
+		delete(f.topics, arn)
+	}
+	return nil, nil
+}
+
+type snsTopicMember struct {
+	TopicArn string
+}
+
+func (f *FakeSNS) listTopics(form url.Values) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var topics []snsTopicMember
+	for _, arn := range sortedKeys(f.topics) {
+		topics = append(topics, snsTopicMember{arn})
+	}
+	return struct {
+		Topics []snsTopicMember `xml:"Topics>member"`
+	}{topics}, nil
+}
+
+type snsAttributeEntry struct {
+	Key   string `xml:"key"`
+	Value string `xml:"value"`
+}
+
+// snsAttributes returns attrs as entries of a query protocol map.
+func snsAttributes(attrs map[string]string) []snsAttributeEntry {
+	var entries []snsAttributeEntry
+	for _, k := range sortedKeys(attrs) {
+		entries = append(entries, snsAttributeEntry{k, attrs[k]})
+	}
+	return entries
+}
+
+func (f *FakeSNS) getTopicAttributes(form url.Values) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, err := f.lookupTopic(form.Get("TopicArn"))
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		Attributes []snsAttributeEntry `xml:"Attributes>entry"`
+	}{snsAttributes(map[string]string{
+		"TopicArn":               t.arn,
+		"Owner":                  fakeAccountID,
+		"DisplayName":            "",
+		"SubscriptionsConfirmed": strconv.Itoa(len(t.subs)),
+		"SubscriptionsPending":   "0",
+		"SubscriptionsDeleted":   "0",
+	})}, nil
+}
+
+func (f *FakeSNS) subscribe(form url.Values) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.addSubscription(form.Get("TopicArn"), form.Get("Protocol"), form.Get("Endpoint"))
+	if err != nil {
+		return nil, err
+	}
+	return struct{ SubscriptionArn string }{s.arn}, nil
+}
+
+func (f *FakeSNS) unsubscribe(form url.Values) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.lookupSubscription(form.Get("SubscriptionArn"))
+	if err != nil {
+		return nil, err
+	}
+	delete(f.subs, s.arn)
+	if t, ok := f.topics[s.topic]; ok {
+		kept := t.subs[:0]
+		for _, arn := range t.subs {
+			if arn != s.arn {
+				kept = append(kept, arn)
+			}
+		}
+		t.subs = kept
+	}
+	return nil, nil
+}
+
+type snsSubscriptionMember struct {
+	Endpoint        string
+	Owner           string
+	Protocol        string
+	SubscriptionArn string
+	TopicArn        string
+}
+
+func (f *FakeSNS) subscriptionMembers(arns []string) interface{} {
+	var members []snsSubscriptionMember
+	for _, arn := range arns {
+		s := f.subs[arn]
+		members = append(members, snsSubscriptionMember{
+			Endpoint:        s.endpoint,
+			Owner:           fakeAccountID,
+			Protocol:        s.protocol,
+			SubscriptionArn: s.arn,
+			TopicArn:        s.topic,
+		})
+	}
+	return struct {
+		Subscriptions []snsSubscriptionMember `xml:"Subscriptions>member"`
+	}{members}
+}
+
+func (f *FakeSNS) listSubscriptions(form url.Values) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var arns []string
+	for _, t := range sortedKeys(f.topics) {
+		arns = append(arns, f.topics[t].subs...)
+	}
+	return f.subscriptionMembers(arns), nil
+}
+
+func (f *FakeSNS) listSubscriptionsByTopic(form url.Values) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, err := f.lookupTopic(form.Get("TopicArn"))
+	if err != nil {
+		return nil, err
+	}
+	return f.subscriptionMembers(t.subs), nil
+}
+
+func (f *FakeSNS) getSubscriptionAttributes(form url.Values) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.lookupSubscription(form.Get("SubscriptionArn"))
+	if err != nil {
+		return nil, err
+	}
+	attrs := map[string]string{
+		"SubscriptionArn":              s.arn,
+		"TopicArn":                     s.topic,
+		"Protocol":                     s.protocol,
+		"Endpoint":                     s.endpoint,
+		"Owner":                        fakeAccountID,
+		"PendingConfirmation":          "false",
+		"ConfirmationWasAuthenticated": "true",
+	}
+	for k, v := range s.attrs {
+		attrs[k] = v
+	}
+	return struct {
+		Attributes []snsAttributeEntry `xml:"Attributes>entry"`
+	}{snsAttributes(attrs)}, nil
+}
+
+func (f *FakeSNS) setSubscriptionAttributes(form url.Values) (interface{}, error) {
+	name, value := form.Get("AttributeName"), form.Get("AttributeValue")
+	switch name {
+	case "RawMessageDelivery":
+		if value != "true" && value != "false" {
+			return nil, newAWSError(http.StatusBadRequest, "InvalidParameter", "Invalid parameter: RawMessageDelivery: Invalid value [%s]. Must be true or false.", value)
+		}
+	case "DeliveryPolicy":
+	default:
+		return nil, newAWSError(http.StatusBadRequest, "InvalidParameter", "Invalid parameter: AttributeName %s is not supported by the fake", name)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, err := f.lookupSubscription(form.Get("SubscriptionArn"))
+	if err != nil {
+		return nil, err
+	}
+	s.attrs[name] = value
+	return nil, nil
+}
+
+// snsDelivery is a delivery worked out under f.mu and made after it is
+// released.
+type snsDelivery struct {
+	sub   snsSubscription
+	queue *FakeSQS
+}
+
+func (f *FakeSNS) publish(form url.Values) (interface{}, error) {
+	topicARN := form.Get("TopicArn")
+	if topicARN == "" {
+		if form.Get("TargetArn") != "" {
+			return nil, newAWSError(http.StatusBadRequest, "InvalidParameter", "Invalid parameter: publishing to a TargetArn is not supported by the fake")
+		}
+		return nil, newAWSError(http.StatusBadRequest, "InvalidParameter", "Invalid parameter: TopicArn or TargetArn Reason: no value for required parameter")
+	}
+	msg := SNSMessage{
+		Type:              "Notification",
+		MessageID:         randomUUID(),
+		TopicArn:          topicARN,
+		Subject:           form.Get("Subject"),
+		Message:           form.Get("Message"),
+		Timestamp:         f.clock.Now().UTC().Format(snsTimeFormat),
+		SignatureVersion:  "1",
+		MessageAttributes: snsMessageAttributes(form),
+	}
+	if msg.Message == "" {
+		return nil, newAWSError(http.StatusBadRequest, "InvalidParameter", "Invalid parameter: Empty message")
+	}
+	var structured map[string]string
+	if form.Get("MessageStructure") == "json" {
+		if err := json.Unmarshal([]byte(msg.Message), &structured); err != nil {
+			return nil, newAWSError(http.StatusBadRequest, "InvalidParameter", "Invalid parameter: Message Structure - JSON message body failed to parse")
+		}
+		if _, ok := structured["default"]; !ok {
+			return nil, newAWSError(http.StatusBadRequest, "InvalidParameter", "Invalid parameter: Message Structure - No default entry in JSON message body")
+		}
+		msg.Message = structured["default"]
+	}
+
+	f.mu.Lock()
+	t, err := f.lookupTopic(topicARN)
+	if err != nil {
+		f.mu.Unlock()
+		return nil, err
+	}
+	f.published[topicARN] = append(f.published[topicARN], msg)
+	var pending []snsDelivery
+	for _, arn := range t.subs {
+		s := f.subs[arn]
+		pending = append(pending, snsDelivery{sub: *s, queue: f.queues[s.endpoint]})
+	}
+	f.mu.Unlock()
+
+	for _, d := range pending {
+		m := msg
+		if body, ok := structured["sqs"]; ok {
+			m.Message = body
+		}
+		m.UnsubscribeURL = f.URL + "/?" + url.Values{"Action": {"Unsubscribe"}, "SubscriptionArn": {d.sub.arn}}.Encode()
+		if err := f.deliver(d, m); err != nil {
+			f.logger.Logf("sns: topic %s could not deliver to %s: %v", topicARN, d.sub.endpoint, err)
+		}
+	}
+	return struct{ MessageId string }{msg.MessageID}, nil
+}
+
+// deliver sends m to a subscribed queue.
+func (f *FakeSNS) deliver(d snsDelivery, m SNSMessage) error {
+	if d.queue == nil {
+		return fmt.Errorf("no queue registered for %s", d.sub.endpoint)
+	}
+	in := &sqs.SendMessageInput{QueueUrl: &d.queue.URL}
+	if d.sub.raw() {
+		in.MessageBody = aws.String(m.Message)
+		in.MessageAttributes = snsToSQSAttributes(m.MessageAttributes)
+	} else {
+		if err := f.sign(&m); err != nil {
+			return err
+		}
+		body, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		in.MessageBody = aws.String(string(body))
+	}
+	_, err := d.queue.Client.SendMessage(in)
+	return err
+}
+
+// sign signs m, starting the fake's signer if it isn't running yet.
+// The signing key is generated on first use, since it is slow to make
+// and most tests publish no wrapped notifications.
+func (f *FakeSNS) sign(m *SNSMessage) error {
+	f.signerOnce.Do(func() {
+		f.signer = NewSNSSigner(WithLogger(f.logger))
+	})
+	if f.signer == nil {
+		return errors.New("the fake is closed")
+	}
+	return f.signer.Sign(m)
+}
+
+// snsMessageAttributes returns the message attributes of a Publish
+// request, given as MessageAttributes.entry.N.Name and
+// MessageAttributes.entry.N.Value.* parameters.
+func snsMessageAttributes(form url.Values) map[string]SNSMessageAttribute {
+	var ret map[string]SNSMessageAttribute
+	for _, m := range queryMembers(form, "MessageAttributes.entry") {
+		if ret == nil {
+			ret = make(map[string]SNSMessageAttribute)
+		}
+		a := SNSMessageAttribute{Type: form.Get(m + "Value.DataType"), Value: form.Get(m + "Value.StringValue")}
+		if strings.HasPrefix(a.Type, "Binary") {
+			a.Value = form.Get(m + "Value.BinaryValue")
+		}
+		ret[form.Get(m+"Name")] = a
+	}
+	return ret
+}
+
+// snsToSQSAttributes returns attrs as SQS message attributes, as raw
+// message delivery passes them on.
+func snsToSQSAttributes(attrs map[string]SNSMessageAttribute) map[string]*sqs.MessageAttributeValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	ret := make(map[string]*sqs.MessageAttributeValue, len(attrs))
+	for k, a := range attrs {
+		v := &sqs.MessageAttributeValue{DataType: aws.String(a.Type)}
+		if strings.HasPrefix(a.Type, "Binary") {
+			v.BinaryValue, _ = base64.StdEncoding.DecodeString(a.Value)
+		} else {
+			v.StringValue = aws.String(a.Value)
+		}
+		ret[k] = v
+	}
+	return ret
+}
@@ -0,0 +1,227 @@
+package testutil
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1"   // for crypto.SHA1
+	_ "crypto/sha256" // for crypto.SHA256
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+// SNSMessage is a message as SNS delivers it to HTTP subscriptions.
+type SNSMessage struct {
+	Type             string
+	MessageID        string `json:"MessageId"`
+	Token            string `json:",omitempty"`
+	TopicArn         string
+	Subject          string `json:",omitempty"`
+	Message          string
+	Timestamp        string
+	SignatureVersion string
+	Signature        string
+	SigningCertURL   string
+	SubscribeURL     string `json:",omitempty"`
+	UnsubscribeURL   string `json:",omitempty"`
+
+	MessageAttributes map[string]SNSMessageAttribute `json:",omitempty"`
+}
+
+// SNSMessageAttribute is a message attribute of an SNSMessage.
+type SNSMessageAttribute struct {
+	Type  string
+	Value string
+}
+
+// SNSSigner signs SNS messages with a test certificate it serves over
+// HTTP, the way SNS does, so endpoints that validate signatures can be
+// tested with real ones. Verifiers must accept the certificate URL,
+// which is on localhost rather than an amazonaws.com host.
+type SNSSigner struct {
+	// CertURL is the URL of the signing certificate.
+	CertURL string
+
+	key        *rsa.PrivateKey
+	srv        *httptest.Server
+	unregister func()
+}
+
+// NewSNSSigner generates a signing key and certificate and starts
+// serving the certificate.
+func NewSNSSigner(opts ...Option) *SNSSigner {
+	o := newOptions(opts)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		o.logger.Fatalf("Error generating SNS signing key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		o.logger.Fatalf("Error creating SNS signing certificate: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	s := &SNSSigner{key: key}
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+		w.Write(certPEM)
+	}))
+	s.CertURL = s.srv.URL + "/SimpleNotificationService.pem"
+	s.unregister = OnInterrupt(s.Close)
+	return s
+}
+
+// Close stops serving the certificate.
+func (s *SNSSigner) Close() {
+	if s.unregister != nil {
+		s.unregister()
+	}
+	s.srv.Close()
+}
+
+// Sign sets m's SigningCertURL and Signature. It signs with
+// SignatureVersion 1 (SHA1) unless m.SignatureVersion is "2" (SHA256).
+// Any later change to the signed fields invalidates the signature.
+func (s *SNSSigner) Sign(m *SNSMessage) error {
+	if m.SignatureVersion == "" {
+		m.SignatureVersion = "1"
+	}
+	hash, err := snsSignatureHash(m.SignatureVersion)
+	if err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(snsStringToSign(m))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, hash, h.Sum(nil))
+	if err != nil {
+		return err
+	}
+	m.SigningCertURL = s.CertURL
+	m.Signature = base64.StdEncoding.EncodeToString(sig)
+	return nil
+}
+
+// Deliver signs m and POSTs it to endpoint with the headers SNS sends,
+// as an HTTP subscription delivery.
+func (s *SNSSigner) Deliver(endpoint string, m *SNSMessage) (*http.Response, error) {
+	if err := s.Sign(m); err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=UTF-8")
+	req.Header.Set("x-amz-sns-message-type", m.Type)
+	req.Header.Set("x-amz-sns-message-id", m.MessageID)
+	req.Header.Set("x-amz-sns-topic-arn", m.TopicArn)
+	return http.DefaultClient.Do(req)
+}
+
+// VerifySNSSignature checks m's signature against the certificate at
+// its SigningCertURL, fetched with client, or http.DefaultClient if
+// client is nil. It does not restrict which hosts certificates may be
+// fetched from; production verifiers should.
+func VerifySNSSignature(m *SNSMessage, client *http.Client) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	hash, err := snsSignatureHash(m.SignatureVersion)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("decoding SNS signature: %v", err)
+	}
+
+	resp, err := client.Get(m.SigningCertURL)
+	if err != nil {
+		return fmt.Errorf("fetching SNS signing certificate: %v", err)
+	}
+	defer resp.Body.Close()
+	certPEM, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("fetching SNS signing certificate: %v", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return errors.New("SNS signing certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("parsing SNS signing certificate: %v", err)
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("SNS signing certificate does not hold an RSA key")
+	}
+
+	h := hash.New()
+	h.Write(snsStringToSign(m))
+	if err := rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), sig); err != nil {
+		return fmt.Errorf("invalid SNS signature: %v", err)
+	}
+	return nil
+}
+
+func snsSignatureHash(version string) (crypto.Hash, error) {
+	switch version {
+	case "1":
+		return crypto.SHA1, nil
+	case "2":
+		return crypto.SHA256, nil
+	}
+	return 0, fmt.Errorf("unsupported SNS SignatureVersion %q", version)
+}
+
+// snsStringToSign builds the string SNS signs for m: the names and
+// values of its signed fields, in order, each followed by a newline.
+func snsStringToSign(m *SNSMessage) []byte {
+	var fields []string
+	if m.Type == "Notification" {
+		fields = []string{"Message", m.Message, "MessageId", m.MessageID}
+		if m.Subject != "" {
+			fields = append(fields, "Subject", m.Subject)
+		}
+		fields = append(fields, "Timestamp", m.Timestamp, "TopicArn", m.TopicArn, "Type", m.Type)
+	} else {
+		fields = []string{
+			"Message", m.Message,
+			"MessageId", m.MessageID,
+			"SubscribeURL", m.SubscribeURL,
+			"Timestamp", m.Timestamp,
+			"Token", m.Token,
+			"TopicArn", m.TopicArn,
+			"Type", m.Type,
+		}
+	}
+	var b bytes.Buffer
+	for _, f := range fields {
+		b.WriteString(f)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,70 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSNSSignature(t *testing.T) {
+	s := NewSNSSigner(WithLogger(t))
+	defer s.Close()
+
+	for _, version := range []string{"1", "2"} {
+		m := &SNSMessage{
+			Type:             "Notification",
+			MessageID:        "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+			TopicArn:         "arn:aws:sns:us-east-1:123456789012:events",
+			Subject:          "hello",
+			Message:          `{"id":1}`,
+			Timestamp:        "2012-05-02T00:54:06.655Z",
+			SignatureVersion: version,
+		}
+		if err := s.Sign(m); err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifySNSSignature(m, nil); err != nil {
+			t.Errorf("version %s: %v", version, err)
+		}
+
+		m.Message = `{"id":2}`
+		if err := VerifySNSSignature(m, nil); err == nil {
+			t.Errorf("version %s: expected tampered message to fail verification", version)
+		}
+	}
+}
+
+func TestSNSSignerDeliver(t *testing.T) {
+	s := NewSNSSigner(WithLogger(t))
+	defer s.Close()
+
+	var got SNSMessage
+	var msgType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msgType = r.Header.Get("x-amz-sns-message-type")
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	resp, err := s.Deliver(srv.URL, &SNSMessage{
+		Type:         "SubscriptionConfirmation",
+		MessageID:    "1",
+		Token:        "token",
+		TopicArn:     "arn:aws:sns:us-east-1:123456789012:events",
+		Message:      "You have chosen to subscribe",
+		SubscribeURL: "http://example.com/confirm",
+		Timestamp:    "2012-05-02T00:54:06.655Z",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if msgType != "SubscriptionConfirmation" {
+		t.Errorf("x-amz-sns-message-type = %q", msgType)
+	}
+	if err := VerifySNSSignature(&got, nil); err != nil {
+		t.Errorf("delivered message does not verify: %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package testutil
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+func TestFakeSNSDeliversToQueues(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 4, 9, 12, 0, 0, 0, time.UTC))
+	f := NewFakeSNS(WithClock(clock), WithLogger(t))
+	defer f.Close()
+	wrapped := NewFakeSQS("orders-audit", WithInProcess())
+	defer wrapped.Close()
+	raw := NewFakeSQS("orders-worker", WithInProcess())
+	defer raw.Close()
+
+	topic := f.CreateTopic("orders")
+	if _, err := f.SubscribeQueue(topic, wrapped, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.SubscribeQueue(topic, raw, true); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := f.Client.Publish(&sns.PublishInput{
+		TopicArn: &topic,
+		Subject:  aws.String("placed"),
+		Message:  aws.String(`{"id": 7}`),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			"kind": {DataType: aws.String("String"), StringValue: aws.String("order")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := wrapped.ReceiveMessages(10, 0)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("wrapped queue received %v, %v", msgs, err)
+	}
+	var env SNSMessage
+	if err := json.Unmarshal([]byte(aws.StringValue(msgs[0].Body)), &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.Type != "Notification" || env.MessageID != aws.StringValue(out.MessageId) || env.TopicArn != topic ||
+		env.Subject != "placed" || env.Message != `{"id": 7}` || env.Timestamp != "2018-04-09T12:00:00.000Z" {
+		t.Errorf("envelope = %+v", env)
+	}
+	if a := env.MessageAttributes["kind"]; a.Type != "String" || a.Value != "order" {
+		t.Errorf("envelope attributes = %v", env.MessageAttributes)
+	}
+	if err := VerifySNSSignature(&env, nil); err != nil {
+		t.Errorf("envelope signature: %v", err)
+	}
+
+	msgs, err = raw.ReceiveMessages(10, 0)
+	if err != nil || len(msgs) != 1 || aws.StringValue(msgs[0].Body) != `{"id": 7}` {
+		t.Fatalf("raw queue received %v, %v", msgs, err)
+	}
+	AssertAttribute(t, msgs[0], "kind", "order")
+
+	if p := f.Published(topic); len(p) != 1 || p[0].MessageID != env.MessageID {
+		t.Errorf("Published = %+v", p)
+	}
+}
+
+func TestFakeSNSClientSubscriptions(t *testing.T) {
+	f := NewFakeSNS(WithLogger(t))
+	defer f.Close()
+	q := NewFakeSQS("jobs", WithInProcess())
+	defer q.Close()
+
+	created, err := f.Client.CreateTopic(&sns.CreateTopicInput{Name: aws.String("jobs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := f.Client.CreateTopic(&sns.CreateTopicInput{Name: aws.String("jobs")})
+	if err != nil || aws.StringValue(again.TopicArn) != aws.StringValue(created.TopicArn) {
+		t.Errorf("creating a topic twice = %v, %v", again, err)
+	}
+	sub, err := f.Client.Subscribe(&sns.SubscribeInput{
+		TopicArn: created.TopicArn,
+		Protocol: aws.String("sqs"),
+		Endpoint: aws.String(f.QueueARN(q)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Client.SetSubscriptionAttributes(&sns.SetSubscriptionAttributesInput{
+		SubscriptionArn: sub.SubscriptionArn,
+		AttributeName:   aws.String("RawMessageDelivery"),
+		AttributeValue:  aws.String("true"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := f.Client.GetSubscriptionAttributes(&sns.GetSubscriptionAttributesInput{SubscriptionArn: sub.SubscriptionArn})
+	if err != nil || aws.StringValue(attrs.Attributes["RawMessageDelivery"]) != "true" {
+		t.Errorf("GetSubscriptionAttributes = %v, %v", attrs, err)
+	}
+	list, err := f.Client.ListSubscriptionsByTopic(&sns.ListSubscriptionsByTopicInput{TopicArn: created.TopicArn})
+	if err != nil || len(list.Subscriptions) != 1 || aws.StringValue(list.Subscriptions[0].Endpoint) != f.QueueARN(q) {
+		t.Errorf("ListSubscriptionsByTopic = %v, %v", list, err)
+	}
+	topics, err := f.Client.ListTopics(&sns.ListTopicsInput{})
+	if err != nil || len(topics.Topics) != 1 {
+		t.Errorf("ListTopics = %v, %v", topics, err)
+	}
+
+	// A message structure picks the sqs message, or the default.
+	if _, err := f.Client.Publish(&sns.PublishInput{
+		TopicArn:         created.TopicArn,
+		MessageStructure: aws.String("json"),
+		Message:          aws.String(`{"default": "everyone", "sqs": "queues"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, _ := q.ReceiveMessages(10, 0); len(msgs) != 1 || aws.StringValue(msgs[0].Body) != "queues" {
+		t.Errorf("received %v", msgs)
+	}
+
+	if _, err := f.Client.Unsubscribe(&sns.UnsubscribeInput{SubscriptionArn: sub.SubscriptionArn}); err != nil {
+		t.Fatal(err)
+	}
+	f.Client.Publish(&sns.PublishInput{TopicArn: created.TopicArn, Message: aws.String("dropped")})
+	if msgs, _ := q.ReceiveMessages(10, 0); len(msgs) != 0 {
+		t.Errorf("received %v after unsubscribing", msgs)
+	}
+}
+
+func TestFakeSNSErrors(t *testing.T) {
+	f := NewFakeSNS(WithLogger(t))
+	defer f.Close()
+	q := NewFakeSQS("jobs", WithInProcess())
+	defer q.Close()
+
+	missing := "arn:aws:sns:us-east-1:123456789012:missing"
+	if _, err := f.Client.Publish(&sns.PublishInput{TopicArn: &missing, Message: aws.String("x")}); !isErrorCode(err, "NotFound") {
+		t.Errorf("publishing to a missing topic = %v", err)
+	}
+	if _, err := f.SubscribeQueue(missing, q, false); err == nil {
+		t.Errorf("subscribing to a missing topic = %v", err)
+	}
+	topic := f.CreateTopic("jobs")
+	if _, err := f.Client.Subscribe(&sns.SubscribeInput{TopicArn: &topic, Protocol: aws.String("email"), Endpoint: aws.String("a@example.com")}); !isErrorCode(err, "InvalidParameter") {
+		t.Errorf("subscribing an email address = %v", err)
+	}
+	if _, err := f.Client.Publish(&sns.PublishInput{
+		TopicArn:         &topic,
+		MessageStructure: aws.String("json"),
+		Message:          aws.String(`{"sqs": "no default"}`),
+	}); !isErrorCode(err, "InvalidParameter") {
+		t.Errorf("publishing a structure without a default = %v", err)
+	}
+
+	// A subscription to a queue the fake doesn't know is logged, and
+	// doesn't fail the publish.
+	if _, err := f.Client.Subscribe(&sns.SubscribeInput{
+		TopicArn: &topic,
+		Protocol: aws.String("sqs"),
+		Endpoint: aws.String("arn:aws:sqs:us-east-1:123456789012:unknown"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Client.Publish(&sns.PublishInput{TopicArn: &topic, Message: aws.String("x")}); err != nil {
+		t.Errorf("publishing with an unknown queue subscribed = %v", err)
+	}
+}
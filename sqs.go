@@ -0,0 +1,450 @@
+package testutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// maxReceiveWait is the longest long-poll SQS allows.
+const maxReceiveWait = 20 * time.Second
+
+// SendMessage sends body to the fake queue with the given string
+// message attributes, such as trace IDs or content types.
+func (s *FakeSQS) SendMessage(body string, attrs map[string]string) (*sqs.SendMessageOutput, error) {
+	return s.Client.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:          &s.URL,
+		MessageBody:       &body,
+		MessageAttributes: StringAttributes(attrs),
+	})
+}
+
+// SendGroupMessage sends body to a FIFO fake queue in message group
+// group. If dedupID is empty, the queue must have content-based
+// deduplication.
+//
+// The SDK this package vendors predates FIFO queues, so the group and
+// deduplication ID are added to the request as it is built.
+func (s *FakeSQS) SendGroupMessage(body, group, dedupID string, attrs map[string]string) (*sqs.SendMessageOutput, error) {
+	req, out := s.Client.SendMessageRequest(&sqs.SendMessageInput{
+		QueueUrl:          &s.URL,
+		MessageBody:       &body,
+		MessageAttributes: StringAttributes(attrs),
+	})
+	req.Handlers.Build.PushBack(func(r *request.Request) {
+		if r.Error != nil {
+			return
+		}
+		form, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			r.Error = err
+			return
+		}
+		extra := url.Values{"MessageGroupId": {group}}
+		if dedupID != "" {
+			extra.Set("MessageDeduplicationId", dedupID)
+		}
+		r.SetBufferBody(append(form, "&"+extra.Encode()...))
+	})
+	return out, req.Send()
+}
+
+// SeedMessages sends each of bodies to the fake queue, in order, in
+// batches of ten, to set a test up.
+func (s *FakeSQS) SeedMessages(bodies ...string) error {
+	for len(bodies) > 0 {
+		n := len(bodies)
+		if n > 10 {
+			n = 10
+		}
+		entries := make([]*sqs.SendMessageBatchRequestEntry, n)
+		for i, body := range bodies[:n] {
+			entries[i] = &sqs.SendMessageBatchRequestEntry{Id: aws.String(strconv.Itoa(i)), MessageBody: aws.String(body)}
+		}
+		out, err := s.Client.SendMessageBatch(&sqs.SendMessageBatchInput{QueueUrl: &s.URL, Entries: entries})
+		if err != nil {
+			return err
+		}
+		if len(out.Failed) > 0 {
+			f := out.Failed[0]
+			i, _ := strconv.Atoi(aws.StringValue(f.Id))
+			return fmt.Errorf("seeding %s with %q: %s: %s", s.URL, bodies[i], aws.StringValue(f.Code), aws.StringValue(f.Message))
+		}
+		bodies = bodies[n:]
+	}
+	return nil
+}
+
+// SeedJSON sends the JSON encoding of each of vs to the fake queue, as
+// SeedMessages does.
+func (s *FakeSQS) SeedJSON(vs ...interface{}) error {
+	bodies := make([]string, len(vs))
+	for i, v := range vs {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		bodies[i] = string(b)
+	}
+	return s.SeedMessages(bodies...)
+}
+
+// ReceiveAll drains the fake queue: it receives and deletes messages
+// until none has arrived for timeout, and returns them in the order
+// received. Messages in flight when it is called aren't waited for
+// unless they become visible again within timeout.
+func (s *FakeSQS) ReceiveAll(timeout time.Duration) ([]*sqs.Message, error) {
+	var all []*sqs.Message
+	deadline := time.Now().Add(timeout)
+	for {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return all, nil
+		}
+		if wait > maxReceiveWait {
+			wait = maxReceiveWait
+		}
+		msgs, err := s.ReceiveMessages(10, wait)
+		if err != nil {
+			return all, err
+		}
+		if len(msgs) == 0 {
+			if wait < time.Second {
+				time.Sleep(wait)
+			}
+			continue
+		}
+		if err := s.deleteMessages(msgs); err != nil {
+			return all, err
+		}
+		all = append(all, msgs...)
+		deadline = time.Now().Add(timeout)
+	}
+}
+
+// deleteMessages deletes received msgs, of which there are at most
+// ten, from the fake queue.
+func (s *FakeSQS) deleteMessages(msgs []*sqs.Message) error {
+	entries := make([]*sqs.DeleteMessageBatchRequestEntry, len(msgs))
+	for i, m := range msgs {
+		entries[i] = &sqs.DeleteMessageBatchRequestEntry{Id: aws.String(strconv.Itoa(i)), ReceiptHandle: m.ReceiptHandle}
+	}
+	out, err := s.Client.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{QueueUrl: &s.URL, Entries: entries})
+	if err != nil {
+		return err
+	}
+	if len(out.Failed) > 0 {
+		f := out.Failed[0]
+		i, _ := strconv.Atoi(aws.StringValue(f.Id))
+		return fmt.Errorf("deleting message %s: %s: %s", aws.StringValue(msgs[i].MessageId), aws.StringValue(f.Code), aws.StringValue(f.Message))
+	}
+	return nil
+}
+
+// PurgeQueue deletes every message in the fake queue, including those
+// in flight. Unlike Reset, it returns any error.
+func (s *FakeSQS) PurgeQueue() error {
+	_, err := s.Client.PurgeQueue(&sqs.PurgeQueueInput{QueueUrl: &s.URL})
+	return err
+}
+
+// ReceiveMessages receives up to max messages from the fake queue,
+// waiting up to wait for them to arrive. Unlike a bare ReceiveMessage
+// call it asks for every message attribute and system attribute, so
+// they are available to AssertAttribute and AssertSystemAttribute.
+func (s *FakeSQS) ReceiveMessages(max int64, wait time.Duration) ([]*sqs.Message, error) {
+	return s.ReceiveMessagesContext(context.Background(), max, wait)
+}
+
+// ReceiveMessagesContext is like ReceiveMessages, but abandons the
+// long poll as soon as ctx is done.
+func (s *FakeSQS) ReceiveMessagesContext(ctx context.Context, max int64, wait time.Duration) ([]*sqs.Message, error) {
+	req, out := s.Client.ReceiveMessageRequest(&sqs.ReceiveMessageInput{
+		QueueUrl:              &s.URL,
+		MaxNumberOfMessages:   &max,
+		WaitTimeSeconds:       aws.Int64(int64(wait / time.Second)),
+		AttributeNames:        aws.StringSlice([]string{"All"}),
+		MessageAttributeNames: aws.StringSlice([]string{"All"}),
+	})
+	if err := sendWithContext(ctx, req); err != nil {
+		return nil, err
+	}
+	return out.Messages, nil
+}
+
+// ReceiveJSON long-polls q until a message arrives or timeout elapses,
+// unmarshals the message body into a T, deletes the message and
+// returns the value. If the body can't be unmarshaled the message is
+// left on the queue and the error is returned.
+func ReceiveJSON[T any](q MessageQueue, timeout time.Duration) (T, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	v, err := ReceiveJSONContext[T](ctx, q)
+	if err == context.DeadlineExceeded {
+		err = fmt.Errorf("no message received on %s within %v", q, timeout)
+	}
+	return v, err
+}
+
+// ReceiveJSONContext is like ReceiveJSON, but polls until ctx is done
+// rather than for a fixed timeout. It returns ctx's error if no
+// message arrives in time.
+func ReceiveJSONContext[T any](ctx context.Context, q MessageQueue) (T, error) {
+	var v T
+	for {
+		if err := ctx.Err(); err != nil {
+			return v, err
+		}
+		wait := maxReceiveWait
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			wait = time.Until(deadline)
+		}
+		msgs, err := q.ReceiveMessagesContext(ctx, 1, wait)
+		if err != nil {
+			if ctx.Err() != nil {
+				return v, ctx.Err()
+			}
+			return v, err
+		}
+		if len(msgs) == 0 {
+			if wait < time.Second {
+				select {
+				case <-ctx.Done():
+				case <-time.After(wait):
+				}
+			}
+			continue
+		}
+
+		msg := msgs[0]
+		if err := json.Unmarshal([]byte(aws.StringValue(msg.Body)), &v); err != nil {
+			return v, fmt.Errorf("unmarshaling message %s: %v", aws.StringValue(msg.MessageId), err)
+		}
+		return v, q.DeleteMessage(aws.StringValue(msg.ReceiptHandle))
+	}
+}
+
+// DeleteMessage deletes the received message with the given receipt
+// handle from the fake queue.
+func (s *FakeSQS) DeleteMessage(receiptHandle string) error {
+	_, err := s.Client.DeleteMessage(&sqs.DeleteMessageInput{
+		QueueUrl:      &s.URL,
+		ReceiptHandle: &receiptHandle,
+	})
+	return err
+}
+
+// ChangeMessageVisibility makes the received message with the given
+// receipt handle visible again after visibility, which is rounded down
+// to whole seconds.
+func (s *FakeSQS) ChangeMessageVisibility(receiptHandle string, visibility time.Duration) error {
+	_, err := s.Client.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          &s.URL,
+		ReceiptHandle:     &receiptHandle,
+		VisibilityTimeout: aws.Int64(int64(visibility / time.Second)),
+	})
+	return err
+}
+
+// String returns the queue's URL.
+func (s *FakeSQS) String() string {
+	return s.URL
+}
+
+// StringAttributes converts attrs into SQS message attributes of type
+// String.
+func StringAttributes(attrs map[string]string) map[string]*sqs.MessageAttributeValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+	ret := make(map[string]*sqs.MessageAttributeValue, len(attrs))
+	for k, v := range attrs {
+		ret[k] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(v),
+		}
+	}
+	return ret
+}
+
+// AssertAttribute checks that msg carries the message attribute name
+// with string value want.
+func AssertAttribute(t TestingT, msg *sqs.Message, name, want string) {
+	t.Helper()
+	attr, ok := msg.MessageAttributes[name]
+	if !ok {
+		t.Errorf("message %s has no attribute %q (attributes: %v)",
+			aws.StringValue(msg.MessageId), name, attributeNames(msg))
+		return
+	}
+	if got := aws.StringValue(attr.StringValue); got != want {
+		t.Errorf("message %s attribute %q = %q, want %q",
+			aws.StringValue(msg.MessageId), name, got, want)
+	}
+}
+
+// AssertSystemAttribute checks that msg carries the system attribute
+// name (for example "ApproximateReceiveCount") with value want. System
+// attributes are only returned by receives that ask for them, such as
+// ReceiveMessages.
+func AssertSystemAttribute(t TestingT, msg *sqs.Message, name, want string) {
+	t.Helper()
+	got, ok := msg.Attributes[name]
+	if !ok {
+		t.Errorf("message %s has no system attribute %q", aws.StringValue(msg.MessageId), name)
+		return
+	}
+	if aws.StringValue(got) != want {
+		t.Errorf("message %s system attribute %q = %q, want %q",
+			aws.StringValue(msg.MessageId), name, aws.StringValue(got), want)
+	}
+}
+
+func attributeNames(msg *sqs.Message) []string {
+	names := make([]string, 0, len(msg.MessageAttributes))
+	for k := range msg.MessageAttributes {
+		names = append(names, k)
+	}
+	return names
+}
+
+// AssertMessageCount checks that n messages are waiting on the fake
+// queue within timeout, polling its ApproximateNumberOfMessages
+// attribute. Messages in flight aren't counted. On failure it reports
+// the last count and a sample of the waiting messages' bodies.
+func (s *FakeSQS) AssertMessageCount(t TestingT, n int, timeout time.Duration) {
+	t.Helper()
+	var got int
+	var err error
+	if eventually(timeout, func() bool {
+		got, err = queueDepth(s)
+		return err == nil && got == n
+	}) {
+		return
+	}
+	if err != nil {
+		t.Errorf("counting messages on %s: %v", s, err)
+		return
+	}
+	msgs, _ := peekMessages(s)
+	t.Errorf("%s has %d message(s) waiting, want %d%s%s", s, got, n, withinString(timeout), sampleBodies(msgs))
+}
+
+// AssertReceived checks that a message matching m arrives on the fake
+// queue within timeout, deletes it and returns it, or nil if the check
+// failed. It is Expect(t).Queue(s).ToReceive(m).Within(timeout).
+func (s *FakeSQS) AssertReceived(t TestingT, m MessageMatcher, timeout time.Duration) *sqs.Message {
+	t.Helper()
+	return Expect(t).Queue(s).ToReceive(m).Within(timeout)
+}
+
+// AbandonMessages receives up to max messages with the given
+// visibility timeout and leaves them undeleted, as a consumer that
+// crashed mid-processing would, so that they reappear on the queue once
+// visibility expires.
+func (s *FakeSQS) AbandonMessages(max int64, visibility time.Duration) ([]*sqs.Message, error) {
+	out, err := s.Client.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:            &s.URL,
+		MaxNumberOfMessages: &max,
+		VisibilityTimeout:   aws.Int64(int64(visibility / time.Second)),
+		AttributeNames:      aws.StringSlice([]string{"All"}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Messages, nil
+}
+
+// AssertMessageRedelivered checks that the message msgID, which must
+// already have been received, reappears on the queue within the given
+// time without having been deleted. Other messages received while
+// looking for it are made visible again straight away, and so is msgID
+// once found, so the consumer under test can still process them. The
+// redelivered message is returned, or nil if the check failed.
+func (s *FakeSQS) AssertMessageRedelivered(t TestingT, msgID string, within time.Duration) *sqs.Message {
+	t.Helper()
+	return assertMessageRedelivered(t, s, msgID, within)
+}
+
+// AssertMessageNotRedelivered checks that the message msgID doesn't
+// reappear on the queue within the given time, as when a consumer
+// deleted it after processing it. It takes the full duration when the
+// check passes.
+func (s *FakeSQS) AssertMessageNotRedelivered(t TestingT, msgID string, within time.Duration) {
+	t.Helper()
+	assertMessageNotRedelivered(t, s, msgID, within)
+}
+
+func assertMessageRedelivered(t TestingT, q MessageQueue, msgID string, within time.Duration) *sqs.Message {
+	t.Helper()
+	msg, err := findMessage(q, msgID, within)
+	switch {
+	case err != nil:
+		t.Errorf("looking for message %s on %s: %v", msgID, q, err)
+		return nil
+	case msg == nil:
+		t.Errorf("message %s was not redelivered on %s within %v", msgID, q, within)
+		return nil
+	}
+	if count := aws.StringValue(msg.Attributes["ApproximateReceiveCount"]); count == "1" {
+		t.Errorf("message %s is on %s but had not been received before", msgID, q)
+		return nil
+	}
+	return msg
+}
+
+func assertMessageNotRedelivered(t TestingT, q MessageQueue, msgID string, within time.Duration) {
+	t.Helper()
+	msg, err := findMessage(q, msgID, within)
+	switch {
+	case err != nil:
+		t.Errorf("looking for message %s on %s: %v", msgID, q, err)
+	case msg != nil:
+		t.Errorf("message %s was redelivered on %s (receive count %s)",
+			msgID, q, aws.StringValue(msg.Attributes["ApproximateReceiveCount"]))
+	}
+}
+
+// findMessage polls q for the message msgID for up to within, making
+// every message it receives, including msgID, visible again. It
+// returns nil if msgID doesn't turn up.
+func findMessage(q MessageQueue, msgID string, within time.Duration) (*sqs.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), within)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	for ctx.Err() == nil {
+		wait := time.Second
+		if d := time.Until(deadline); d < wait {
+			wait = d
+		}
+		msgs, err := q.ReceiveMessagesContext(ctx, 10, wait)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil
+			}
+			return nil, err
+		}
+		var found *sqs.Message
+		for _, m := range msgs {
+			if aws.StringValue(m.MessageId) == msgID {
+				found = m
+			}
+			q.ChangeMessageVisibility(aws.StringValue(m.ReceiptHandle), 0)
+		}
+		if found != nil {
+			return found, nil
+		}
+		if len(msgs) == 0 && wait < time.Second {
+			<-ctx.Done()
+		}
+	}
+	return nil, nil
+}
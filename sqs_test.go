@@ -0,0 +1,228 @@
+package testutil
+
+import (
+	"crypto/md5"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// recordingT is a TestingT that records failures instead of reporting
+// them, for testing the assertion helpers themselves.
+type recordingT struct {
+	errors []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertAttribute(t *testing.T) {
+	msg := &sqs.Message{
+		MessageId:         aws.String("1"),
+		MessageAttributes: StringAttributes(map[string]string{"trace-id": "abc"}),
+		Attributes:        map[string]*string{"ApproximateReceiveCount": aws.String("1")},
+	}
+
+	rt := &recordingT{}
+	AssertAttribute(rt, msg, "trace-id", "abc")
+	AssertSystemAttribute(rt, msg, "ApproximateReceiveCount", "1")
+	if len(rt.errors) != 0 {
+		t.Errorf("unexpected failures: %v", rt.errors)
+	}
+
+	AssertAttribute(rt, msg, "trace-id", "xyz")
+	AssertAttribute(rt, msg, "content-type", "application/json")
+	AssertSystemAttribute(rt, msg, "SentTimestamp", "0")
+	if len(rt.errors) != 3 {
+		t.Errorf("got %d failures, want 3: %v", len(rt.errors), rt.errors)
+	}
+}
+
+func ExampleFakeSQS_SendMessage() {
+	s := NewFakeSQS("attribute-queue")
+	defer s.Close()
+
+	s.SendMessage("Hello!", map[string]string{"content-type": "text/plain"})
+	msgs, err := s.ReceiveMessages(1, 3*time.Second)
+	if err != nil {
+		// handle error
+	}
+
+	fmt.Println(*msgs[0].MessageAttributes["content-type"].StringValue)
+	// Output:
+	// text/plain
+}
+
+func ExampleReceiveJSON() {
+	s := NewFakeSQS("json-queue")
+	defer s.Close()
+
+	type job struct {
+		ID   int    `json:"id"`
+		Kind string `json:"kind"`
+	}
+	s.SendMessage(`{"id": 42, "kind": "resize"}`, nil)
+
+	j, err := ReceiveJSON[job](s, 5*time.Second)
+	if err != nil {
+		// handle error
+	}
+
+	fmt.Println(j.ID, j.Kind)
+	// Output:
+	// 42 resize
+}
+
+// stubSQS serves ReceiveMessage from a script of batches, one per
+// call, and records the receipt handles made visible again.
+type stubSQS struct {
+	mu       sync.Mutex
+	batches  [][]stubMessage
+	released []string
+}
+
+type stubMessage struct {
+	id, body     string
+	receiveCount int
+}
+
+func (s *stubSQS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Form.Get("Action") {
+	case "ReceiveMessage":
+		var batch []stubMessage
+		if len(s.batches) > 0 {
+			batch, s.batches = s.batches[0], s.batches[1:]
+		}
+		fmt.Fprint(w, "<ReceiveMessageResponse><ReceiveMessageResult>")
+		for _, m := range batch {
+			fmt.Fprintf(w, "<Message><MessageId>%s</MessageId><ReceiptHandle>rh-%s</ReceiptHandle><Body>%s</Body><MD5OfBody>%x</MD5OfBody>"+
+				"<Attribute><Name>ApproximateReceiveCount</Name><Value>%d</Value></Attribute></Message>",
+				m.id, m.id, m.body, md5.Sum([]byte(m.body)), m.receiveCount)
+		}
+		fmt.Fprint(w, "</ReceiveMessageResult><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></ReceiveMessageResponse>")
+	case "ChangeMessageVisibility":
+		s.released = append(s.released, r.Form.Get("ReceiptHandle"))
+		fmt.Fprint(w, "<ChangeMessageVisibilityResponse><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></ChangeMessageVisibilityResponse>")
+	}
+}
+
+func newStubFakeSQS(t *testing.T, stub *stubSQS) *FakeSQS {
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+	s := &FakeSQS{Config: fakeAWSConfig(srv.URL, newOptions(nil)), URL: srv.URL + "/jobs"}
+	s.Session = session.New(s.Config)
+	s.Client = sqs.New(s.Session)
+	return s
+}
+
+func TestAssertMessageRedelivered(t *testing.T) {
+	stub := &stubSQS{batches: [][]stubMessage{
+		{{id: "other", body: "b", receiveCount: 1}},
+		{},
+		{{id: "m1", body: "a", receiveCount: 2}},
+	}}
+	q := newStubFakeSQS(t, stub)
+
+	msg := q.AssertMessageRedelivered(t, "m1", 5*time.Second)
+	if msg == nil || aws.StringValue(msg.Body) != "a" {
+		t.Fatalf("got %v", msg)
+	}
+	if want := []string{"rh-other", "rh-m1"}; !reflect.DeepEqual(stub.released, want) {
+		t.Errorf("made visible %q, want %q", stub.released, want)
+	}
+
+	stub.batches = [][]stubMessage{{{id: "m2", body: "c", receiveCount: 1}}}
+	rec := &recordingT{}
+	q.AssertMessageRedelivered(rec, "m2", time.Second)
+	if len(rec.errors) != 1 || !strings.Contains(rec.errors[0], "had not been received before") {
+		t.Errorf("unexpected failures %q", rec.errors)
+	}
+
+	rec = &recordingT{}
+	q.AssertMessageNotRedelivered(rec, "m3", 100*time.Millisecond)
+	if len(rec.errors) != 0 {
+		t.Errorf("unexpected failures %q", rec.errors)
+	}
+}
+
+func TestSeedAndReceiveAll(t *testing.T) {
+	s := NewFakeSQS("jobs", WithInProcess())
+	defer s.Close()
+
+	var bodies []string
+	for i := 0; i < 12; i++ {
+		bodies = append(bodies, fmt.Sprint(i))
+	}
+	if err := s.SeedMessages(bodies...); err != nil {
+		t.Fatal(err)
+	}
+	type job struct {
+		ID int `json:"id"`
+	}
+	if err := s.SeedJSON(job{12}, job{13}); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := s.ReceiveAll(100 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range msgs {
+		got = append(got, aws.StringValue(m.Body))
+	}
+	want := append(bodies, `{"id":12}`, `{"id":13}`)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("received %q, want %q", got, want)
+	}
+	if again, _ := s.ReceiveAll(0); len(again) != 0 {
+		t.Errorf("messages left after draining: %v", again)
+	}
+
+	s.SeedMessages("a", "b")
+	if err := s.PurgeQueue(); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, _ := s.ReceiveMessages(10, 0); len(msgs) != 0 {
+		t.Errorf("received after purge: %v", msgs)
+	}
+}
+
+func TestFakeSQSAsserts(t *testing.T) {
+	s := NewFakeSQS("jobs", WithInProcess())
+	defer s.Close()
+	s.SeedMessages("order-1", "order-2")
+
+	s.AssertMessageCount(t, 2, time.Second)
+	rt := &recordingT{}
+	s.AssertMessageCount(rt, 3, 50*time.Millisecond)
+	if len(rt.errors) != 1 || !strings.Contains(rt.errors[0], `has 2 message(s) waiting, want 3 within 50ms; messages seen include "order-1", "order-2"`) {
+		t.Errorf("errors = %q", rt.errors)
+	}
+
+	if msg := s.AssertReceived(t, BodyContaining("order-2"), time.Second); aws.StringValue(msg.Body) != "order-2" {
+		t.Errorf("received %v", msg)
+	}
+	rt = &recordingT{}
+	if msg := s.AssertReceived(rt, BodyContaining("order-3"), 100*time.Millisecond); msg != nil {
+		t.Errorf("received %v", msg)
+	}
+	if len(rt.errors) != 1 || !strings.Contains(rt.errors[0], `messages seen include "order-1"`) {
+		t.Errorf("errors = %q", rt.errors)
+	}
+}
@@ -0,0 +1,441 @@
+package testutil
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// sqsServer serves the SQS query API from a MemorySQS, for
+// WithInProcess. Unlike MemorySQS it can send to FIFO queues, taking
+// the message group and deduplication ID from the request.
+type sqsServer struct {
+	mem *MemorySQS
+	srv *httptest.Server
+
+	// ctx is cancelled on close to end long polls.
+	ctx    context.Context
+	cancel func()
+}
+
+// startSQSServer starts an sqsServer. Its queue URLs are under the
+// server's URL.
+func startSQSServer(o *options) *sqsServer {
+	h := &sqsServer{mem: NewMemorySQS(WithRegion(o.region), WithClock(o.clock), WithLogger(o.logger))}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	h.srv = httptest.NewServer(&queryHandler{
+		xmlns: "http://queue.amazonaws.com/doc/2012-11-05/",
+		operations: map[string]func(url.Values) (interface{}, error){
+			"CreateQueue":                  h.createQueue,
+			"GetQueueUrl":                  h.getQueueURL,
+			"DeleteQueue":                  h.deleteQueue,
+			"ListQueues":                   h.listQueues,
+			"GetQueueAttributes":           h.getQueueAttributes,
+			"SetQueueAttributes":           h.setQueueAttributes,
+			"PurgeQueue":                   h.purgeQueue,
+			"SendMessage":                  h.sendMessage,
+			"SendMessageBatch":             h.sendMessageBatch,
+			"DeleteMessage":                h.deleteMessage,
+			"DeleteMessageBatch":           h.deleteMessageBatch,
+			"ChangeMessageVisibility":      h.changeMessageVisibility,
+			"ChangeMessageVisibilityBatch": h.changeMessageVisibilityBatch,
+		},
+		waitOperations: map[string]func(context.Context, url.Values) (interface{}, error){
+			"ReceiveMessage": h.receiveMessage,
+		},
+		stop: h.ctx.Done(),
+	})
+	h.mem.endpoint = h.srv.URL
+	return h
+}
+
+// close ends any long polls and stops the server.
+func (h *sqsServer) close() {
+	h.cancel()
+	h.srv.Close()
+}
+
+type sqsQueueURLResult struct {
+	QueueURL string `xml:"QueueUrl"`
+}
+
+func (h *sqsServer) createQueue(form url.Values) (interface{}, error) {
+	out, err := h.mem.CreateQueue(&sqs.CreateQueueInput{
+		QueueName:  aws.String(form.Get("QueueName")),
+		Attributes: queryMap(form, "Attribute"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sqsQueueURLResult{aws.StringValue(out.QueueUrl)}, nil
+}
+
+func (h *sqsServer) getQueueURL(form url.Values) (interface{}, error) {
+	out, err := h.mem.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: aws.String(form.Get("QueueName"))})
+	if err != nil {
+		return nil, err
+	}
+	return &sqsQueueURLResult{aws.StringValue(out.QueueUrl)}, nil
+}
+
+func (h *sqsServer) deleteQueue(form url.Values) (interface{}, error) {
+	_, err := h.mem.DeleteQueue(&sqs.DeleteQueueInput{QueueUrl: aws.String(form.Get("QueueUrl"))})
+	return nil, err
+}
+
+func (h *sqsServer) listQueues(form url.Values) (interface{}, error) {
+	out, err := h.mem.ListQueues(&sqs.ListQueuesInput{QueueNamePrefix: aws.String(form.Get("QueueNamePrefix"))})
+	if err != nil {
+		return nil, err
+	}
+	return &struct {
+		QueueURLs []string `xml:"QueueUrl"`
+	}{aws.StringValueSlice(out.QueueUrls)}, nil
+}
+
+type sqsAttribute struct {
+	Name  string
+	Value string
+}
+
+// sqsAttributes returns attrs sorted by name.
+func sqsAttributes(attrs map[string]*string) []sqsAttribute {
+	var ret []sqsAttribute
+	for _, k := range sortedKeys(attrs) {
+		ret = append(ret, sqsAttribute{k, aws.StringValue(attrs[k])})
+	}
+	return ret
+}
+
+func (h *sqsServer) getQueueAttributes(form url.Values) (interface{}, error) {
+	out, err := h.mem.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(form.Get("QueueUrl")),
+		AttributeNames: queryStrings(form, "AttributeName"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &struct {
+		Attributes []sqsAttribute `xml:"Attribute"`
+	}{sqsAttributes(out.Attributes)}, nil
+}
+
+func (h *sqsServer) setQueueAttributes(form url.Values) (interface{}, error) {
+	_, err := h.mem.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		QueueUrl:   aws.String(form.Get("QueueUrl")),
+		Attributes: queryMap(form, "Attribute"),
+	})
+	return nil, err
+}
+
+func (h *sqsServer) purgeQueue(form url.Values) (interface{}, error) {
+	_, err := h.mem.PurgeQueue(&sqs.PurgeQueueInput{QueueUrl: aws.String(form.Get("QueueUrl"))})
+	return nil, err
+}
+
+// queryMessageAttributes returns the message attributes given as
+// prefix + "MessageAttribute.N" parameters.
+func queryMessageAttributes(form url.Values, prefix string) (map[string]*sqs.MessageAttributeValue, error) {
+	var ret map[string]*sqs.MessageAttributeValue
+	for _, m := range queryMembers(form, prefix+"MessageAttribute") {
+		v := &sqs.MessageAttributeValue{DataType: aws.String(form.Get(m + "Value.DataType"))}
+		if s, ok := form[m+"Value.StringValue"]; ok {
+			v.StringValue = aws.String(s[0])
+		}
+		if b := form.Get(m + "Value.BinaryValue"); b != "" {
+			var err error
+			if v.BinaryValue, err = base64.StdEncoding.DecodeString(b); err != nil {
+				return nil, invalidParameter("invalid binary value for message attribute %s", form.Get(m+"Name"))
+			}
+		}
+		if ret == nil {
+			ret = make(map[string]*sqs.MessageAttributeValue)
+		}
+		ret[form.Get(m+"Name")] = v
+	}
+	return ret, nil
+}
+
+// md5OfMessageAttributes returns the checksum SQS sends for message
+// attributes, or "" if there are none.
+func md5OfMessageAttributes(attrs map[string]*sqs.MessageAttributeValue) string {
+	if len(attrs) == 0 {
+		return ""
+	}
+	sum := md5.New()
+	field := func(b []byte) {
+		binary.Write(sum, binary.BigEndian, uint32(len(b)))
+		sum.Write(b)
+	}
+	for _, k := range sortedKeys(attrs) {
+		v := attrs[k]
+		field([]byte(k))
+		field([]byte(aws.StringValue(v.DataType)))
+		if v.StringValue != nil {
+			sum.Write([]byte{1})
+			field([]byte(*v.StringValue))
+		} else {
+			sum.Write([]byte{2})
+			field(v.BinaryValue)
+		}
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+type sqsSendMessageResult struct {
+	MessageID              string `xml:"MessageId"`
+	MD5OfMessageBody       string
+	MD5OfMessageAttributes string `xml:",omitempty"`
+	SequenceNumber         string `xml:",omitempty"`
+}
+
+// send sends the message given by the parameters starting with prefix
+// to the queue at queueURL.
+func (h *sqsServer) send(queueURL string, form url.Values, prefix string) (*sqsSendMessageResult, error) {
+	q, err := h.mem.queue(&queueURL)
+	if err != nil {
+		return nil, err
+	}
+	body := form.Get(prefix + "MessageBody")
+	if body == "" {
+		return nil, awserr.NewRequestFailure(awserr.New("MissingParameter", "The request must contain the parameter MessageBody.", nil), 400, "")
+	}
+	attrs, err := queryMessageAttributes(form, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var msg *memoryMessage
+	if q.fifo {
+		msg, err = q.sendFIFO(body, attrs, form.Get(prefix+"MessageGroupId"), form.Get(prefix+"MessageDeduplicationId"))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		msg = q.send(body, attrs)
+	}
+	return &sqsSendMessageResult{
+		MessageID:              msg.id,
+		MD5OfMessageBody:       md5Hex(msg.body),
+		MD5OfMessageAttributes: md5OfMessageAttributes(msg.attrs),
+		SequenceNumber:         msg.sequence,
+	}, nil
+}
+
+func (h *sqsServer) sendMessage(form url.Values) (interface{}, error) {
+	return h.send(form.Get("QueueUrl"), form, "")
+}
+
+type sqsBatchResultErrorEntry struct {
+	ID          string `xml:"Id"`
+	Code        string
+	Message     string
+	SenderFault bool
+}
+
+func newSQSBatchResultErrorEntry(id string, err error) sqsBatchResultErrorEntry {
+	e := toAWSError(err)
+	return sqsBatchResultErrorEntry{ID: id, Code: e.Code, Message: e.Message, SenderFault: e.Status < 500}
+}
+
+func (h *sqsServer) sendMessageBatch(form url.Values) (interface{}, error) {
+	queueURL := form.Get("QueueUrl")
+	if _, err := h.mem.queue(&queueURL); err != nil {
+		return nil, err
+	}
+	entries := queryMembers(form, "SendMessageBatchRequestEntry")
+	if err := checkBatch(len(entries)); err != nil {
+		return nil, err
+	}
+	type entry struct {
+		ID string `xml:"Id"`
+		sqsSendMessageResult
+	}
+	var result struct {
+		Successful []entry                    `xml:"SendMessageBatchResultEntry"`
+		Failed     []sqsBatchResultErrorEntry `xml:"BatchResultErrorEntry"`
+	}
+	for _, m := range entries {
+		id := form.Get(m + "Id")
+		sent, err := h.send(queueURL, form, m)
+		if err != nil {
+			result.Failed = append(result.Failed, newSQSBatchResultErrorEntry(id, err))
+			continue
+		}
+		result.Successful = append(result.Successful, entry{id, *sent})
+	}
+	return &result, nil
+}
+
+type sqsMessageAttributeValue struct {
+	StringValue string `xml:",omitempty"`
+	BinaryValue string `xml:",omitempty"`
+	DataType    string
+}
+
+type sqsMessageAttribute struct {
+	Name  string
+	Value sqsMessageAttributeValue
+}
+
+type sqsMessage struct {
+	MessageID              string `xml:"MessageId"`
+	ReceiptHandle          string
+	MD5OfBody              string
+	Body                   string
+	MD5OfMessageAttributes string                `xml:",omitempty"`
+	Attributes             []sqsAttribute        `xml:"Attribute"`
+	MessageAttributes      []sqsMessageAttribute `xml:"MessageAttribute"`
+}
+
+// receiveMessage long-polls until the client goes away or the server
+// closes, so that a poll the client has given up on doesn't take a
+// message it will never see.
+func (h *sqsServer) receiveMessage(ctx context.Context, form url.Values) (interface{}, error) {
+	in := &sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(form.Get("QueueUrl")),
+		AttributeNames:        queryStrings(form, "AttributeName"),
+		MessageAttributeNames: queryStrings(form, "MessageAttributeName"),
+	}
+	for param, v := range map[string]**int64{
+		"MaxNumberOfMessages": &in.MaxNumberOfMessages,
+		"VisibilityTimeout":   &in.VisibilityTimeout,
+		"WaitTimeSeconds":     &in.WaitTimeSeconds,
+	} {
+		if s := form.Get(param); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, invalidParameter("invalid %s %q", param, s)
+			}
+			*v = &n
+		}
+	}
+	out, err := h.mem.receiveMessage(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Messages []sqsMessage `xml:"Message"`
+	}
+	for _, msg := range out.Messages {
+		m := sqsMessage{
+			MessageID:              aws.StringValue(msg.MessageId),
+			ReceiptHandle:          aws.StringValue(msg.ReceiptHandle),
+			MD5OfBody:              aws.StringValue(msg.MD5OfBody),
+			Body:                   aws.StringValue(msg.Body),
+			MD5OfMessageAttributes: md5OfMessageAttributes(msg.MessageAttributes),
+			Attributes:             sqsAttributes(msg.Attributes),
+		}
+		for _, k := range sortedKeys(msg.MessageAttributes) {
+			v := msg.MessageAttributes[k]
+			value := sqsMessageAttributeValue{StringValue: aws.StringValue(v.StringValue), DataType: aws.StringValue(v.DataType)}
+			if v.BinaryValue != nil {
+				value.BinaryValue = base64.StdEncoding.EncodeToString(v.BinaryValue)
+			}
+			m.MessageAttributes = append(m.MessageAttributes, sqsMessageAttribute{k, value})
+		}
+		result.Messages = append(result.Messages, m)
+	}
+	return &result, nil
+}
+
+func (h *sqsServer) deleteMessage(form url.Values) (interface{}, error) {
+	_, err := h.mem.DeleteMessage(&sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(form.Get("QueueUrl")),
+		ReceiptHandle: aws.String(form.Get("ReceiptHandle")),
+	})
+	return nil, err
+}
+
+// sqsBatchResult is the result of DeleteMessageBatch and
+// ChangeMessageVisibilityBatch, whose successful entries have only
+// IDs.
+type sqsBatchResult struct {
+	Successful []sqsBatchResultEntry
+	Failed     []sqsBatchResultErrorEntry `xml:"BatchResultErrorEntry"`
+}
+
+type sqsBatchResultEntry struct {
+	XMLName xml.Name
+	ID      string `xml:"Id"`
+}
+
+func newSQSBatchResult(entryName string, successful []*string, failed []*sqs.BatchResultErrorEntry) *sqsBatchResult {
+	result := &sqsBatchResult{}
+	for _, id := range successful {
+		result.Successful = append(result.Successful, sqsBatchResultEntry{xml.Name{Local: entryName}, aws.StringValue(id)})
+	}
+	for _, e := range failed {
+		result.Failed = append(result.Failed, sqsBatchResultErrorEntry{
+			ID:          aws.StringValue(e.Id),
+			Code:        aws.StringValue(e.Code),
+			Message:     aws.StringValue(e.Message),
+			SenderFault: aws.BoolValue(e.SenderFault),
+		})
+	}
+	return result
+}
+
+func (h *sqsServer) deleteMessageBatch(form url.Values) (interface{}, error) {
+	in := &sqs.DeleteMessageBatchInput{QueueUrl: aws.String(form.Get("QueueUrl"))}
+	for _, m := range queryMembers(form, "DeleteMessageBatchRequestEntry") {
+		in.Entries = append(in.Entries, &sqs.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(form.Get(m + "Id")),
+			ReceiptHandle: aws.String(form.Get(m + "ReceiptHandle")),
+		})
+	}
+	out, err := h.mem.DeleteMessageBatch(in)
+	if err != nil {
+		return nil, err
+	}
+	var ids []*string
+	for _, e := range out.Successful {
+		ids = append(ids, e.Id)
+	}
+	return newSQSBatchResult("DeleteMessageBatchResultEntry", ids, out.Failed), nil
+}
+
+func (h *sqsServer) changeMessageVisibility(form url.Values) (interface{}, error) {
+	n, err := strconv.ParseInt(form.Get("VisibilityTimeout"), 10, 64)
+	if err != nil {
+		return nil, invalidParameter("invalid VisibilityTimeout %q", form.Get("VisibilityTimeout"))
+	}
+	_, err = h.mem.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(form.Get("QueueUrl")),
+		ReceiptHandle:     aws.String(form.Get("ReceiptHandle")),
+		VisibilityTimeout: &n,
+	})
+	return nil, err
+}
+
+func (h *sqsServer) changeMessageVisibilityBatch(form url.Values) (interface{}, error) {
+	in := &sqs.ChangeMessageVisibilityBatchInput{QueueUrl: aws.String(form.Get("QueueUrl"))}
+	for _, m := range queryMembers(form, "ChangeMessageVisibilityBatchRequestEntry") {
+		e := &sqs.ChangeMessageVisibilityBatchRequestEntry{
+			Id:            aws.String(form.Get(m + "Id")),
+			ReceiptHandle: aws.String(form.Get(m + "ReceiptHandle")),
+		}
+		if n, err := strconv.ParseInt(form.Get(m+"VisibilityTimeout"), 10, 64); err == nil {
+			e.VisibilityTimeout = &n
+		}
+		in.Entries = append(in.Entries, e)
+	}
+	out, err := h.mem.ChangeMessageVisibilityBatch(in)
+	if err != nil {
+		return nil, err
+	}
+	var ids []*string
+	for _, e := range out.Successful {
+		ids = append(ids, e.Id)
+	}
+	return newSQSBatchResult("ChangeMessageVisibilityBatchResultEntry", ids, out.Failed), nil
+}
@@ -0,0 +1,215 @@
+package testutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestInProcessSQS(t *testing.T) {
+	s := NewFakeSQS("jobs", WithInProcess())
+	defer s.Close()
+
+	sent, err := s.SendMessage("hello", map[string]string{"trace": "t-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := s.ReceiveMessages(10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || aws.StringValue(msgs[0].MessageId) != aws.StringValue(sent.MessageId) || aws.StringValue(msgs[0].Body) != "hello" {
+		t.Fatalf("received %v", msgs)
+	}
+	if v := msgs[0].MessageAttributes["trace"]; v == nil || aws.StringValue(v.StringValue) != "t-1" {
+		t.Errorf("message attributes = %v", msgs[0].MessageAttributes)
+	}
+
+	// In flight, so not received again until its visibility changes.
+	if again, _ := s.ReceiveMessages(10, 0); len(again) != 0 {
+		t.Errorf("in-flight message received again: %v", again)
+	}
+	if err := s.ChangeMessageVisibility(aws.StringValue(msgs[0].ReceiptHandle), 0); err != nil {
+		t.Fatal(err)
+	}
+	msgs, _ = s.ReceiveMessages(10, 0)
+	if len(msgs) != 1 || aws.StringValue(msgs[0].Attributes["ApproximateReceiveCount"]) != "2" {
+		t.Fatalf("redelivered %v", msgs)
+	}
+	if err := s.DeleteMessage(aws.StringValue(msgs[0].ReceiptHandle)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteMessage(aws.StringValue(msgs[0].ReceiptHandle)); !isErrorCode(err, "ReceiptHandleIsInvalid") {
+		t.Errorf("deleting twice = %v", err)
+	}
+
+	s.SendMessage("a", nil)
+	if _, err := s.Client.PurgeQueue(&sqs.PurgeQueueInput{QueueUrl: &s.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, _ := s.ReceiveMessages(10, 0); len(msgs) != 0 {
+		t.Errorf("received after purge: %v", msgs)
+	}
+}
+
+func TestInProcessSQSLongPoll(t *testing.T) {
+	s := NewFakeSQS("jobs", WithInProcess())
+	defer s.Close()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		s.SendMessage("late", nil)
+	}()
+	msgs, err := s.ReceiveMessages(1, 5*time.Second)
+	if err != nil || len(msgs) != 1 || aws.StringValue(msgs[0].Body) != "late" {
+		t.Fatalf("long poll = %v, %v", msgs, err)
+	}
+
+	// Close ends long polls rather than waiting them out.
+	go s.ReceiveMessages(1, 20*time.Second)
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	s.Close()
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Close took %v", d)
+	}
+}
+
+func TestInProcessSQSCancelledLongPoll(t *testing.T) {
+	s := NewFakeSQS("jobs", WithInProcess())
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	msgs, err := s.ReceiveMessagesContext(ctx, 1, 5*time.Second)
+	if err == nil {
+		t.Fatalf("cancelled long poll = %v, %v", msgs, err)
+	}
+	// The server gives up the poll too, rather than taking the next
+	// message for a client that has gone.
+	time.Sleep(50 * time.Millisecond)
+	s.SendMessage("after", nil)
+	time.Sleep(50 * time.Millisecond)
+	msgs, err = s.ReceiveMessages(1, 0)
+	if err != nil || len(msgs) != 1 || aws.StringValue(msgs[0].Body) != "after" {
+		t.Fatalf("receive after a cancelled poll = %v, %v", msgs, err)
+	}
+}
+
+func TestInProcessSQSQueues(t *testing.T) {
+	s := NewFakeSQS("jobs", WithInProcess())
+	defer s.Close()
+
+	other, err := s.Client.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String("jobs-dlq")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := s.Client.ListQueues(&sqs.ListQueuesInput{QueueNamePrefix: aws.String("jobs")})
+	if err != nil || len(list.QueueUrls) != 2 {
+		t.Fatalf("ListQueues = %v, %v", list, err)
+	}
+
+	batch, err := s.Client.SendMessageBatch(&sqs.SendMessageBatchInput{
+		QueueUrl: other.QueueUrl,
+		Entries: []*sqs.SendMessageBatchRequestEntry{
+			{Id: aws.String("1"), MessageBody: aws.String("one")},
+			{Id: aws.String("2"), MessageBody: aws.String("two")},
+		},
+	})
+	if err != nil || len(batch.Successful) != 2 {
+		t.Fatalf("SendMessageBatch = %v, %v", batch, err)
+	}
+	if msgs, _ := s.ReceiveMessages(10, 0); len(msgs) != 0 {
+		t.Errorf("messages sent to one queue received from another: %v", msgs)
+	}
+	out, err := s.Client.ReceiveMessage(&sqs.ReceiveMessageInput{QueueUrl: other.QueueUrl, MaxNumberOfMessages: aws.Int64(10)})
+	if err != nil || len(out.Messages) != 2 {
+		t.Fatalf("ReceiveMessage = %v, %v", out, err)
+	}
+	del, err := s.Client.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
+		QueueUrl: other.QueueUrl,
+		Entries: []*sqs.DeleteMessageBatchRequestEntry{
+			{Id: aws.String("1"), ReceiptHandle: out.Messages[0].ReceiptHandle},
+			{Id: aws.String("bad"), ReceiptHandle: aws.String("nope")},
+		},
+	})
+	if err != nil || len(del.Successful) != 1 || len(del.Failed) != 1 || aws.StringValue(del.Failed[0].Id) != "bad" {
+		t.Errorf("DeleteMessageBatch = %v, %v", del, err)
+	}
+	attrs, err := s.Client.GetQueueAttributes(&sqs.GetQueueAttributesInput{QueueUrl: other.QueueUrl, AttributeNames: aws.StringSlice([]string{"All"})})
+	if err != nil || aws.StringValue(attrs.Attributes["ApproximateNumberOfMessagesNotVisible"]) != "1" {
+		t.Errorf("GetQueueAttributes = %v, %v", attrs, err)
+	}
+}
+
+func TestInProcessSQSFIFO(t *testing.T) {
+	s := NewFakeSQS("jobs.fifo", WithInProcess(), WithQueueAttributes(map[string]string{
+		"FifoQueue":                 "true",
+		"ContentBasedDeduplication": "true",
+	}))
+	defer s.Close()
+
+	a1, err := s.SendGroupMessage("a1", "a", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SendGroupMessage("a2", "a", "", nil)
+	s.SendGroupMessage("b1", "b", "", nil)
+	dup, err := s.SendGroupMessage("a1", "a", "", nil)
+	if err != nil || aws.StringValue(dup.MessageId) != aws.StringValue(a1.MessageId) {
+		t.Errorf("duplicate send = %v, %v; want message %s", dup, err, aws.StringValue(a1.MessageId))
+	}
+	if _, err := s.SendMessage("no group", nil); !isErrorCode(err, "MissingParameter") {
+		t.Errorf("send without a group = %v", err)
+	}
+
+	receive := func(max int64) []string {
+		msgs, err := s.ReceiveMessages(max, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var bodies []string
+		for _, m := range msgs {
+			bodies = append(bodies, aws.StringValue(m.Body))
+			if m.Attributes["SequenceNumber"] == nil || m.Attributes["MessageGroupId"] == nil {
+				t.Errorf("FIFO attributes missing from %v", m)
+			}
+			if aws.StringValue(m.Body) == "a1" {
+				defer s.DeleteMessage(aws.StringValue(m.ReceiptHandle))
+			}
+		}
+		return bodies
+	}
+	if got := receive(1); len(got) != 1 || got[0] != "a1" {
+		t.Fatalf("first receive = %v", got)
+	}
+	// a1 is deleted, so a2 is next in its group.
+	if got := receive(10); len(got) != 2 || got[0] != "a2" || got[1] != "b1" {
+		t.Errorf("second receive = %v", got)
+	}
+}
+
+func TestInProcessSQSFIFOBlocksGroup(t *testing.T) {
+	s := NewFakeSQS("jobs.fifo", WithInProcess(), WithQueueAttributes(map[string]string{"FifoQueue": "true"}))
+	defer s.Close()
+
+	s.SendGroupMessage("a1", "a", "1", nil)
+	s.SendGroupMessage("a2", "a", "2", nil)
+	s.SendGroupMessage("b1", "b", "3", nil)
+	if _, err := s.SendGroupMessage("a3", "a", "", nil); !isErrorCode(err, "InvalidParameterValue") {
+		t.Errorf("send without a deduplication ID = %v", err)
+	}
+
+	first, _ := s.ReceiveMessages(1, 0)
+	if len(first) != 1 || aws.StringValue(first[0].Body) != "a1" {
+		t.Fatalf("first receive = %v", first)
+	}
+	// a1 is in flight, so group a is blocked.
+	rest, _ := s.ReceiveMessages(10, 0)
+	if len(rest) != 1 || aws.StringValue(rest[0].Body) != "b1" {
+		t.Errorf("receive with a1 in flight = %v", rest)
+	}
+}
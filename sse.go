@@ -0,0 +1,165 @@
+package testutil
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+const (
+	sseKMS              = "aws:kms"
+	s3ManagedKeyAlias   = "alias/aws/s3"
+	sseHeader           = "X-Amz-Server-Side-Encryption"
+	sseKMSKeyIDHeader   = "X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"
+	s3DataKeySize       = 32
+	s3EncryptionContext = "aws:s3:arn"
+)
+
+// WithKMS makes a FakeS3 encrypt objects uploaded with SSE-KMS using
+// k, the way S3 does: each upload generates a data key under the
+// requested key, or under the AWS managed key alias/aws/s3 if none is
+// given, and each download decrypts it. The calls show up in k's call
+// log, so key selection can be checked end to end, and a missing or
+// disabled key fails the S3 request with the error S3 would return.
+// Responses carry the SSE headers fakes3 leaves out.
+func WithKMS(k *FakeKMS) Option {
+	return func(o *options) {
+		o.kms = k
+	}
+}
+
+// sseKMSObjects tracks which objects a FakeS3 has stored with SSE-KMS
+// and their encrypted data keys.
+type sseKMSObjects struct {
+	kms *FakeKMS
+
+	mu      sync.Mutex
+	objects map[string]sseKMSObject
+}
+
+type sseKMSObject struct {
+	keyID   string
+	dataKey []byte
+}
+
+func newSSEKMSObjects(k *FakeKMS) *sseKMSObjects {
+	return &sseKMSObjects{kms: k, objects: make(map[string]sseKMSObject)}
+}
+
+// install adds the SSE-KMS handlers to each set of handlers. The send
+// hook must be installed along with the fake's other send hooks, so it
+// is returned rather than installed here.
+func (s *sseKMSObjects) install(handlers ...*request.Handlers) sendHook {
+	for _, h := range handlers {
+		h.UnmarshalMeta.PushFront(s.addHeaders)
+	}
+	return s.sendHook
+}
+
+func (s *sseKMSObjects) sendHook(r *request.Request) *http.Response {
+	bucket, key := paramString(r, "Bucket"), paramString(r, "Key")
+	if key == "" {
+		return nil
+	}
+	name := bucket + "/" + key
+	encCtx := map[string]string{s3EncryptionContext: "arn:aws:s3:::" + name}
+
+	switch r.Operation.Name {
+	case "PutObject", "CopyObject", "CreateMultipartUpload":
+		if paramString(r, "ServerSideEncryption") != sseKMS {
+			s.forget(name)
+			return nil
+		}
+		ref := paramString(r, "SSEKMSKeyId")
+		if ref == "" {
+			ref = s.kms.managedKey(s3ManagedKeyAlias)
+		}
+		keyID, _, blob, err := s.kms.newDataKey("GenerateDataKey", ref, s3DataKeySize, encCtx)
+		if err != nil {
+			return kmsErrorResponse(r, err)
+		}
+		s.mu.Lock()
+		s.objects[name] = sseKMSObject{keyID: keyID, dataKey: blob}
+		s.mu.Unlock()
+
+	case "GetObject", "HeadObject":
+		obj, ok := s.lookup(name)
+		if !ok {
+			return nil
+		}
+		if _, _, err := s.kms.decryptBlob("Decrypt", obj.dataKey, encCtx); err != nil {
+			return kmsErrorResponse(r, err)
+		}
+
+	case "DeleteObject":
+		s.forget(name)
+	}
+	return nil
+}
+
+// addHeaders adds the SSE-KMS response headers for encrypted objects,
+// so that they are unmarshaled into the output as S3 would return
+// them.
+func (s *sseKMSObjects) addHeaders(r *request.Request) {
+	if r.HTTPResponse == nil || r.HTTPResponse.StatusCode >= 300 {
+		return
+	}
+	obj, ok := s.lookup(paramString(r, "Bucket") + "/" + paramString(r, "Key"))
+	if !ok {
+		return
+	}
+	r.HTTPResponse.Header.Set(sseHeader, sseKMS)
+	r.HTTPResponse.Header.Set(sseKMSKeyIDHeader, s.kms.KeyARN(obj.keyID))
+}
+
+func (s *sseKMSObjects) lookup(name string) (sseKMSObject, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[name]
+	return obj, ok
+}
+
+func (s *sseKMSObjects) forget(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, name)
+}
+
+// kmsErrorResponse reports a KMS error through S3 the way S3 does, as
+// a 400 with the KMS error code prefixed by "KMS.".
+func kmsErrorResponse(r *request.Request, err error) *http.Response {
+	code, message := "KMS.InternalFailure", err.Error()
+	if e, ok := err.(*awsError); ok {
+		code, message = "KMS."+e.Code, e.Message
+	}
+	return errorResponse(r, http.StatusBadRequest, code, message)
+}
+
+// SSEKMSKeyID returns the ID of the KMS key the object bucket/key was
+// encrypted under, or "" if it wasn't uploaded with SSE-KMS.
+func (s *FakeS3) SSEKMSKeyID(bucket, key string) string {
+	if s.sse == nil {
+		return ""
+	}
+	obj, _ := s.sse.lookup(bucket + "/" + key)
+	return obj.keyID
+}
+
+// AssertSSEKMS checks that the object bucket/key was uploaded with
+// SSE-KMS under the KMS key keyID. The FakeS3 must have been created
+// with WithKMS.
+func (s *FakeS3) AssertSSEKMS(t TestingT, bucket, key, keyID string) {
+	t.Helper()
+	if s.sse == nil {
+		t.Errorf("FakeS3 was not created with WithKMS")
+		return
+	}
+	got := s.SSEKMSKeyID(bucket, key)
+	switch {
+	case got == "":
+		t.Errorf("s3 object %s/%s is not encrypted with SSE-KMS", bucket, key)
+	case got != keyID:
+		t.Errorf("s3 object %s/%s is encrypted under KMS key %s, want %s", bucket, key, got, keyID)
+	}
+}
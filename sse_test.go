@@ -0,0 +1,71 @@
+package testutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestSSEKMS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+	}))
+	defer srv.Close()
+	k := NewFakeKMS()
+	defer k.Close()
+	keyID := k.CreateKey("uploads")
+
+	o := newOptions([]Option{WithKMS(k)})
+	client := s3.New(session.New(fakeAWSConfig(srv.URL, o)))
+	fake := &FakeS3{Client: client, sse: newSSEKMSObjects(k)}
+	addSendHooks([]sendHook{fake.sse.install(&client.Handlers)}, &client.Handlers)
+
+	put, err := client.PutObject(&s3.PutObjectInput{
+		Bucket:               aws.String("b"),
+		Key:                  aws.String("k"),
+		Body:                 strings.NewReader("hello"),
+		ServerSideEncryption: aws.String("aws:kms"),
+		SSEKMSKeyId:          aws.String("alias/uploads"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := aws.StringValue(put.SSEKMSKeyId); got != k.KeyARN(keyID) {
+		t.Errorf("SSEKMSKeyId = %q, want %q", got, k.KeyARN(keyID))
+	}
+	fake.AssertSSEKMS(t, "b", "k", keyID)
+	k.AssertCalled(t, "GenerateDataKey", keyID)
+
+	if _, err := client.GetObject(&s3.GetObjectInput{Bucket: aws.String("b"), Key: aws.String("k")}); err != nil {
+		t.Fatal(err)
+	}
+	k.AssertCalled(t, "Decrypt", keyID)
+	if ctx := k.CallsTo("Decrypt")[0].EncryptionContext; ctx["aws:s3:arn"] != "arn:aws:s3:::b/k" {
+		t.Errorf("Decrypt encryption context = %v", ctx)
+	}
+
+	k.DisableKey(keyID, true)
+	_, err = client.GetObject(&s3.GetObjectInput{Bucket: aws.String("b"), Key: aws.String("k")})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "KMS.DisabledException" {
+		t.Errorf("expected KMS.DisabledException, got %v", err)
+	}
+
+	_, err = client.PutObject(&s3.PutObjectInput{
+		Bucket:               aws.String("b"),
+		Key:                  aws.String("default"),
+		Body:                 strings.NewReader("hello"),
+		ServerSideEncryption: aws.String("aws:kms"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.SSEKMSKeyID("b", "default"); got == "" || got == keyID {
+		t.Errorf("expected the AWS managed key to be used by default, got %q", got)
+	}
+}
@@ -0,0 +1,858 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StateMachine runs Amazon States Language definitions in process, so
+// that workflows can be tested without Step Functions Local. Task
+// states call Go handlers registered with HandleTask in place of
+// Lambda functions.
+//
+// It supports the Pass, Task, Choice, Wait, Succeed, Fail, Parallel
+// and Map states, with InputPath, Parameters, ResultSelector,
+// ResultPath, OutputPath, Retry and Catch. Paths are limited to plain
+// field and index references such as "$.order.items[0]", and Retry
+// intervals and Wait states don't wait. Executions run synchronously.
+type StateMachine struct {
+	// Name is the state machine's name, used in the $$ context object.
+	Name string
+
+	def   *aslMachine
+	clock Clock
+
+	mu         sync.Mutex
+	tasks      map[string]TaskHandler
+	executions []*Execution
+}
+
+// TaskHandler handles a Task state, receiving its effective input and
+// returning its result, as a Lambda function would. Returning a
+// *TaskError fails the task with that error name; any other error
+// fails it with States.TaskFailed.
+type TaskHandler func(input json.RawMessage) (json.RawMessage, error)
+
+// JSONTask adapts a function taking and returning Go values to a
+// TaskHandler, decoding its input from and encoding its result to
+// JSON.
+func JSONTask[In, Out any](f func(In) (Out, error)) TaskHandler {
+	return func(input json.RawMessage) (json.RawMessage, error) {
+		var in In
+		if err := json.Unmarshal(input, &in); err != nil {
+			return nil, &TaskError{Name: "States.TaskFailed", Cause: err.Error()}
+		}
+		out, err := f(in)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(out)
+	}
+}
+
+// TaskError is an error with a name that Retry and Catch clauses can
+// match, like the errorType of a Lambda function error.
+type TaskError struct {
+	Name  string
+	Cause string
+}
+
+func (e *TaskError) Error() string {
+	return e.Name + ": " + e.Cause
+}
+
+// Execution statuses.
+const (
+	ExecutionSucceeded = "SUCCEEDED"
+	ExecutionFailed    = "FAILED"
+)
+
+// Execution is a finished run of a StateMachine.
+type Execution struct {
+	Name   string
+	Status string
+	Input  json.RawMessage
+	Output json.RawMessage
+
+	// Error and Cause describe why a failed execution failed.
+	Error string
+	Cause string
+
+	// Steps lists the states entered, in order, including those in
+	// Parallel branches and Map iterations.
+	Steps []ExecutionStep
+}
+
+// ExecutionStep records a state entered during an Execution.
+type ExecutionStep struct {
+	State  string
+	Type   string
+	Input  json.RawMessage
+	Output json.RawMessage
+	Error  string
+}
+
+// NewStateMachine parses definition, an Amazon States Language
+// document. Context object times come from the Clock set with
+// WithClock; other options are ignored.
+func NewStateMachine(name, definition string, opts ...Option) (*StateMachine, error) {
+	var def aslMachine
+	if err := json.Unmarshal([]byte(definition), &def); err != nil {
+		return nil, fmt.Errorf("stepfunctions: invalid definition: %v", err)
+	}
+	if err := def.check(); err != nil {
+		return nil, fmt.Errorf("stepfunctions: %v", err)
+	}
+	return &StateMachine{
+		Name:  name,
+		def:   &def,
+		clock: newOptions(opts).clock,
+		tasks: make(map[string]TaskHandler),
+	}, nil
+}
+
+// HandleTask registers h for Task states whose Resource is resource or
+// a Lambda function ARN ending in ":function:" + resource. Tasks using
+// the "arn:aws:states:::lambda:invoke" integration are matched by
+// their FunctionName parameter instead, and h receives the Payload.
+func (m *StateMachine) HandleTask(resource string, h TaskHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tasks[resource] = h
+}
+
+// Start runs an execution with the JSON encoding of input. It never
+// returns nil; check the Execution's Status, or use AssertSucceeded.
+func (m *StateMachine) Start(input interface{}) *Execution {
+	m.mu.Lock()
+	name := fmt.Sprintf("execution-%d", len(m.executions)+1)
+	m.mu.Unlock()
+	return m.StartExecution(name, input)
+}
+
+// StartExecution is like Start, but names the execution.
+func (m *StateMachine) StartExecution(name string, input interface{}) *Execution {
+	exec := &Execution{Name: name}
+	m.mu.Lock()
+	m.executions = append(m.executions, exec)
+	m.mu.Unlock()
+
+	b, err := json.Marshal(input)
+	if input == nil {
+		b = []byte("{}")
+	}
+	var doc interface{}
+	if err == nil {
+		err = json.Unmarshal(b, &doc)
+	}
+	if err != nil {
+		exec.Status, exec.Error, exec.Cause = ExecutionFailed, "States.Runtime", err.Error()
+		return exec
+	}
+	exec.Input = b
+
+	r := &aslRun{m: m, exec: exec, context: map[string]interface{}{
+		"Execution": map[string]interface{}{
+			"Id":        fmt.Sprintf("arn:aws:states:%s:%s:execution:%s:%s", defaultRegion, fakeAccountID, m.Name, name),
+			"Name":      name,
+			"Input":     doc,
+			"StartTime": m.clock.Now().UTC().Format(time.RFC3339),
+		},
+		"StateMachine": map[string]interface{}{
+			"Id":   fmt.Sprintf("arn:aws:states:%s:%s:stateMachine:%s", defaultRegion, fakeAccountID, m.Name),
+			"Name": m.Name,
+		},
+	}}
+	out, serr := r.run(m.def.StartAt, m.def.States, doc)
+	if serr != nil {
+		exec.Status, exec.Error, exec.Cause = ExecutionFailed, serr.Name, serr.Cause
+		return exec
+	}
+	exec.Status = ExecutionSucceeded
+	exec.Output, _ = json.Marshal(out)
+	return exec
+}
+
+// Executions returns every execution started, in order.
+func (m *StateMachine) Executions() []*Execution {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Execution(nil), m.executions...)
+}
+
+// DecodeOutput unmarshals the execution's output into v.
+func (e *Execution) DecodeOutput(v interface{}) error {
+	return json.Unmarshal(e.Output, v)
+}
+
+// Visited returns the names of the states entered, in order.
+func (e *Execution) Visited() []string {
+	var names []string
+	for _, s := range e.Steps {
+		names = append(names, s.State)
+	}
+	return names
+}
+
+// AssertSucceeded checks that the execution succeeded.
+func (e *Execution) AssertSucceeded(t TestingT) {
+	t.Helper()
+	if e.Status != ExecutionSucceeded {
+		t.Errorf("execution %s %s with %s: %s (visited %q)", e.Name, strings.ToLower(e.Status), e.Error, e.Cause, e.Visited())
+	}
+}
+
+// AssertFailed checks that the execution failed with the error name
+// errorName.
+func (e *Execution) AssertFailed(t TestingT, errorName string) {
+	t.Helper()
+	switch {
+	case e.Status != ExecutionFailed:
+		t.Errorf("execution %s %s, want it to fail with %s", e.Name, strings.ToLower(e.Status), errorName)
+	case e.Error != errorName:
+		t.Errorf("execution %s failed with %s: %s, want %s", e.Name, e.Error, e.Cause, errorName)
+	}
+}
+
+// AssertVisited checks that the execution entered states in the given
+// order, possibly with other states in between.
+func (e *Execution) AssertVisited(t TestingT, states ...string) {
+	t.Helper()
+	visited := e.Visited()
+	i := 0
+	for _, name := range visited {
+		if i < len(states) && name == states[i] {
+			i++
+		}
+	}
+	if i < len(states) {
+		t.Errorf("execution %s visited %q, want %q in order", e.Name, visited, states)
+	}
+}
+
+// aslMachine is a state machine definition, or a Parallel branch or
+// Map iterator.
+type aslMachine struct {
+	StartAt string
+	States  map[string]*aslState
+}
+
+func (d *aslMachine) check() error {
+	if _, ok := d.States[d.StartAt]; !ok {
+		return fmt.Errorf("StartAt state %q is not defined", d.StartAt)
+	}
+	for name, s := range d.States {
+		if s.Next != "" {
+			if _, ok := d.States[s.Next]; !ok {
+				return fmt.Errorf("state %s: Next state %q is not defined", name, s.Next)
+			}
+		}
+		for _, b := range s.Branches {
+			if err := b.check(); err != nil {
+				return fmt.Errorf("state %s: %v", name, err)
+			}
+		}
+		if it := s.iterator(); it != nil {
+			if err := it.check(); err != nil {
+				return fmt.Errorf("state %s: %v", name, err)
+			}
+		}
+		switch s.Type {
+		case "Pass", "Task", "Choice", "Wait", "Succeed", "Fail", "Parallel", "Map":
+		default:
+			return fmt.Errorf("state %s: unsupported type %q", name, s.Type)
+		}
+	}
+	return nil
+}
+
+type aslState struct {
+	Type           string
+	Next           string
+	End            bool
+	Resource       string
+	InputPath      aslPath
+	OutputPath     aslPath
+	ResultPath     aslPath
+	Parameters     interface{}
+	ResultSelector interface{}
+	Result         interface{}
+	Retry          []aslRetrier
+	Catch          []aslCatcher
+
+	Choices []aslChoice
+	Default string
+
+	Error string
+	Cause string
+
+	Branches      []*aslMachine
+	ItemsPath     aslPath
+	Iterator      *aslMachine
+	ItemProcessor *aslMachine
+}
+
+func (s *aslState) iterator() *aslMachine {
+	if s.ItemProcessor != nil {
+		return s.ItemProcessor
+	}
+	return s.Iterator
+}
+
+// aslPath is a path field, which may be absent, null or a path.
+type aslPath struct {
+	set  bool
+	null bool
+	path string
+}
+
+func (p *aslPath) UnmarshalJSON(b []byte) error {
+	p.set = true
+	if string(b) == "null" {
+		p.null = true
+		return nil
+	}
+	return json.Unmarshal(b, &p.path)
+}
+
+// or returns the path, or def if it wasn't set.
+func (p aslPath) or(def string) aslPath {
+	if !p.set {
+		return aslPath{set: true, path: def}
+	}
+	return p
+}
+
+type aslRetrier struct {
+	ErrorEquals []string
+	MaxAttempts *int
+}
+
+type aslCatcher struct {
+	ErrorEquals []string
+	Next        string
+	ResultPath  aslPath
+}
+
+type aslChoice struct {
+	Next string
+
+	Variable  string
+	And       []aslChoice
+	Or        []aslChoice
+	Not       *aslChoice
+	IsPresent *bool
+	IsNull    *bool
+
+	StringEquals             *string
+	StringLessThan           *string
+	StringGreaterThan        *string
+	StringMatches            *string
+	NumericEquals            *float64
+	NumericLessThan          *float64
+	NumericGreaterThan       *float64
+	NumericLessThanEquals    *float64
+	NumericGreaterThanEquals *float64
+	BooleanEquals            *bool
+}
+
+// aslError is an error raised by a state.
+type aslError struct {
+	Name  string
+	Cause string
+}
+
+func runtimeError(format string, args ...interface{}) *aslError {
+	return &aslError{Name: "States.Runtime", Cause: fmt.Sprintf(format, args...)}
+}
+
+// maxTransitions bounds the states one execution may enter, so that a
+// definition that loops forever fails instead of hanging the test.
+const maxTransitions = 10000
+
+// aslRun is the state of one execution.
+type aslRun struct {
+	m           *StateMachine
+	exec        *Execution
+	context     map[string]interface{}
+	transitions int
+}
+
+func (r *aslRun) run(startAt string, states map[string]*aslState, input interface{}) (interface{}, *aslError) {
+	name := startAt
+	for {
+		if r.transitions++; r.transitions > maxTransitions {
+			return nil, runtimeError("execution exceeded %d state transitions", maxTransitions)
+		}
+		s := states[name]
+		out, next, err := r.step(name, s, input)
+
+		step := ExecutionStep{State: name, Type: s.Type}
+		step.Input, _ = json.Marshal(input)
+		if err != nil {
+			step.Error = err.Name
+		} else {
+			step.Output, _ = json.Marshal(out)
+		}
+		r.exec.Steps = append(r.exec.Steps, step)
+
+		if err != nil {
+			return nil, err
+		}
+		if next == "" {
+			return out, nil
+		}
+		name, input = next, out
+	}
+}
+
+// step runs the state s and returns its output and the next state,
+// which is empty if the execution (or branch) ends.
+func (r *aslRun) step(name string, s *aslState, input interface{}) (interface{}, string, *aslError) {
+	r.context["State"] = map[string]interface{}{
+		"Name":        name,
+		"EnteredTime": r.m.clock.Now().UTC().Format(time.RFC3339),
+	}
+	effective, err := r.selectPath(input, s.InputPath.or("$"))
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch s.Type {
+	case "Succeed", "Wait":
+		out, err := r.selectPath(effective, s.OutputPath.or("$"))
+		if s.Type == "Succeed" {
+			return out, "", err
+		}
+		return out, s.Next, err
+
+	case "Fail":
+		return nil, "", &aslError{Name: s.Error, Cause: s.Cause}
+
+	case "Choice":
+		next := s.Default
+		for _, c := range s.Choices {
+			if r.choose(c, effective) {
+				next = c.Next
+				break
+			}
+		}
+		if next == "" {
+			return nil, "", &aslError{Name: "States.NoChoiceMatched", Cause: fmt.Sprintf("no choice rule in %s matched and there is no Default", name)}
+		}
+		out, err := r.selectPath(effective, s.OutputPath.or("$"))
+		return out, next, err
+	}
+
+	if s.Parameters != nil && s.Type != "Map" {
+		if effective, err = r.parameters(s.Parameters, effective); err != nil {
+			return nil, "", err
+		}
+	}
+
+	var result interface{}
+	switch s.Type {
+	case "Pass":
+		result = effective
+		if s.Result != nil {
+			result = s.Result
+		}
+	case "Task", "Parallel", "Map":
+		result, err = r.attempt(s, func() (interface{}, *aslError) {
+			switch s.Type {
+			case "Parallel":
+				return r.parallel(s, effective)
+			case "Map":
+				return r.mapItems(s, effective)
+			}
+			return r.task(s, effective)
+		})
+		if err != nil {
+			for _, c := range s.Catch {
+				if matchesError(c.ErrorEquals, err.Name) {
+					out, perr := r.setPath(input, c.ResultPath.or("$"), map[string]interface{}{"Error": err.Name, "Cause": err.Cause})
+					return out, c.Next, perr
+				}
+			}
+			return nil, "", err
+		}
+		if s.ResultSelector != nil {
+			if result, err = r.parameters(s.ResultSelector, result); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	out, err := r.setPath(input, s.ResultPath.or("$"), result)
+	if err != nil {
+		return nil, "", err
+	}
+	if out, err = r.selectPath(out, s.OutputPath.or("$")); err != nil {
+		return nil, "", err
+	}
+	if s.End {
+		return out, "", nil
+	}
+	return out, s.Next, nil
+}
+
+// attempt calls f, retrying it as the state's Retry clauses allow.
+func (r *aslRun) attempt(s *aslState, f func() (interface{}, *aslError)) (interface{}, *aslError) {
+	attempts := make([]int, len(s.Retry))
+	for {
+		out, err := f()
+		if err == nil {
+			return out, nil
+		}
+		retried := false
+		for i, rt := range s.Retry {
+			if !matchesError(rt.ErrorEquals, err.Name) {
+				continue
+			}
+			max := 3
+			if rt.MaxAttempts != nil {
+				max = *rt.MaxAttempts
+			}
+			if attempts[i] < max {
+				attempts[i]++
+				retried = true
+			}
+			break
+		}
+		if !retried {
+			return nil, err
+		}
+	}
+}
+
+func matchesError(names []string, name string) bool {
+	for _, n := range names {
+		if n == name || n == "States.ALL" && name != "States.Runtime" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *aslRun) task(s *aslState, input interface{}) (interface{}, *aslError) {
+	resource, payload, wrap := s.Resource, input, false
+	if s.Resource == "arn:aws:states:::lambda:invoke" {
+		params, _ := input.(map[string]interface{})
+		fn, _ := params["FunctionName"].(string)
+		resource, payload, wrap = fn, params["Payload"], true
+	}
+
+	r.m.mu.Lock()
+	h := r.m.tasks[resource]
+	if h == nil {
+		if i := strings.LastIndex(resource, ":function:"); i >= 0 {
+			h = r.m.tasks[resource[i+len(":function:"):]]
+		}
+	}
+	r.m.mu.Unlock()
+	if h == nil {
+		return nil, runtimeError("no handler registered for task resource %s", resource)
+	}
+
+	in, _ := json.Marshal(payload)
+	out, err := h(in)
+	if err != nil {
+		if te, ok := err.(*TaskError); ok {
+			return nil, &aslError{Name: te.Name, Cause: te.Cause}
+		}
+		return nil, &aslError{Name: "States.TaskFailed", Cause: err.Error()}
+	}
+	var result interface{}
+	if len(out) > 0 {
+		if err := json.Unmarshal(out, &result); err != nil {
+			return nil, &aslError{Name: "States.TaskFailed", Cause: fmt.Sprintf("task returned invalid JSON: %v", err)}
+		}
+	}
+	if wrap {
+		return map[string]interface{}{"Payload": result, "StatusCode": 200}, nil
+	}
+	return result, nil
+}
+
+func (r *aslRun) parallel(s *aslState, input interface{}) (interface{}, *aslError) {
+	results := make([]interface{}, len(s.Branches))
+	for i, b := range s.Branches {
+		out, err := r.run(b.StartAt, b.States, input)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = out
+	}
+	return results, nil
+}
+
+func (r *aslRun) mapItems(s *aslState, input interface{}) (interface{}, *aslError) {
+	v, err := r.selectPath(input, s.ItemsPath.or("$"))
+	if err != nil {
+		return nil, err
+	}
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, runtimeError("ItemsPath of %s does not select an array", s.Type)
+	}
+	it := s.iterator()
+	results := make([]interface{}, len(items))
+	for i, item := range items {
+		if s.Parameters != nil {
+			r.context["Map"] = map[string]interface{}{
+				"Item": map[string]interface{}{"Index": i, "Value": item},
+			}
+			if item, err = r.parameters(s.Parameters, input); err != nil {
+				return nil, err
+			}
+		}
+		out, err := r.run(it.StartAt, it.States, item)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = out
+	}
+	delete(r.context, "Map")
+	return results, nil
+}
+
+// parameters evaluates a Parameters or ResultSelector template against
+// input: fields whose names end in ".$" are replaced by the value at
+// their path.
+func (r *aslRun) parameters(tmpl, input interface{}) (interface{}, *aslError) {
+	switch t := tmpl.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			if strings.HasSuffix(k, ".$") {
+				p, ok := v.(string)
+				if !ok {
+					return nil, runtimeError("the value of %s must be a path", k)
+				}
+				val, err := r.selectPath(input, aslPath{set: true, path: p})
+				if err != nil {
+					return nil, err
+				}
+				out[strings.TrimSuffix(k, ".$")] = val
+				continue
+			}
+			val, err := r.parameters(v, input)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = val
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, v := range t {
+			val, err := r.parameters(v, input)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = val
+		}
+		return out, nil
+	}
+	return tmpl, nil
+}
+
+// selectPath returns the value at p in v. A null path selects an empty
+// object. Paths starting with "$$" select from the context object.
+func (r *aslRun) selectPath(v interface{}, p aslPath) (interface{}, *aslError) {
+	if p.null {
+		return map[string]interface{}{}, nil
+	}
+	path := p.path
+	if strings.HasPrefix(path, "$$") {
+		v, path = r.context, path[1:]
+	}
+	tokens, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, tok := range tokens {
+		var ok bool
+		if v, ok = pathChild(v, tok); !ok {
+			return nil, &aslError{Name: "States.Runtime", Cause: fmt.Sprintf("path %s does not match the input", p.path)}
+		}
+	}
+	return v, nil
+}
+
+// setPath returns v with the value at p replaced by val. A null path
+// discards val and returns v unchanged.
+func (r *aslRun) setPath(v interface{}, p aslPath, val interface{}) (interface{}, *aslError) {
+	if p.null {
+		return v, nil
+	}
+	tokens, err := parsePath(p.path)
+	if err != nil {
+		return nil, err
+	}
+	return setPathTokens(v, tokens, val)
+}
+
+func setPathTokens(v interface{}, tokens []interface{}, val interface{}) (interface{}, *aslError) {
+	if len(tokens) == 0 {
+		return val, nil
+	}
+	field, ok := tokens[0].(string)
+	if !ok {
+		return nil, runtimeError("ResultPath cannot use array indexes")
+	}
+	m, _ := v.(map[string]interface{})
+	out := make(map[string]interface{}, len(m)+1)
+	for k, x := range m {
+		out[k] = x
+	}
+	child, err := setPathTokens(out[field], tokens[1:], val)
+	if err != nil {
+		return nil, err
+	}
+	out[field] = child
+	return out, nil
+}
+
+// parsePath splits a path such as "$.a.b[0]" into field names and
+// indexes.
+func parsePath(path string) ([]interface{}, *aslError) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, runtimeError("path %q must start with $", path)
+	}
+	var tokens []interface{}
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			if end == 0 {
+				return nil, runtimeError("invalid path %q", path)
+			}
+			tokens = append(tokens, rest[1:1+end])
+			rest = rest[1+end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, runtimeError("invalid path %q", path)
+			}
+			n, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, runtimeError("unsupported path %q", path)
+			}
+			tokens = append(tokens, n)
+			rest = rest[end+1:]
+		default:
+			return nil, runtimeError("invalid path %q", path)
+		}
+	}
+	return tokens, nil
+}
+
+func pathChild(v, tok interface{}) (interface{}, bool) {
+	switch tok := tok.(type) {
+	case string:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		child, ok := m[tok]
+		return child, ok
+	case int:
+		a, ok := v.([]interface{})
+		if !ok || tok < 0 || tok >= len(a) {
+			return nil, false
+		}
+		return a[tok], true
+	}
+	return nil, false
+}
+
+// choose reports whether the choice rule c matches input.
+func (r *aslRun) choose(c aslChoice, input interface{}) bool {
+	switch {
+	case c.And != nil:
+		for _, sub := range c.And {
+			if !r.choose(sub, input) {
+				return false
+			}
+		}
+		return true
+	case c.Or != nil:
+		for _, sub := range c.Or {
+			if r.choose(sub, input) {
+				return true
+			}
+		}
+		return false
+	case c.Not != nil:
+		return !r.choose(*c.Not, input)
+	}
+
+	v, err := r.selectPath(input, aslPath{set: true, path: c.Variable})
+	present := err == nil
+	if c.IsPresent != nil {
+		return present == *c.IsPresent
+	}
+	if !present {
+		return false
+	}
+	if c.IsNull != nil {
+		return (v == nil) == *c.IsNull
+	}
+
+	s, isString := v.(string)
+	n, isNumber := v.(float64)
+	switch {
+	case c.StringEquals != nil:
+		return isString && s == *c.StringEquals
+	case c.StringLessThan != nil:
+		return isString && s < *c.StringLessThan
+	case c.StringGreaterThan != nil:
+		return isString && s > *c.StringGreaterThan
+	case c.StringMatches != nil:
+		return isString && matchWildcard(*c.StringMatches, s)
+	case c.NumericEquals != nil:
+		return isNumber && n == *c.NumericEquals
+	case c.NumericLessThan != nil:
+		return isNumber && n < *c.NumericLessThan
+	case c.NumericGreaterThan != nil:
+		return isNumber && n > *c.NumericGreaterThan
+	case c.NumericLessThanEquals != nil:
+		return isNumber && n <= *c.NumericLessThanEquals
+	case c.NumericGreaterThanEquals != nil:
+		return isNumber && n >= *c.NumericGreaterThanEquals
+	case c.BooleanEquals != nil:
+		return reflect.DeepEqual(v, *c.BooleanEquals)
+	}
+	return false
+}
+
+// matchWildcard matches s against pattern, in which * matches any run
+// of characters.
+func matchWildcard(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 {
+			return strings.HasSuffix(s, part)
+		}
+		j := strings.Index(s, part)
+		if j < 0 {
+			return false
+		}
+		s = s[j+len(part):]
+	}
+	return s == ""
+}
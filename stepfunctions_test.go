@@ -0,0 +1,134 @@
+package testutil
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const orderWorkflow = `{
+	"StartAt": "Validate",
+	"States": {
+		"Validate": {
+			"Type": "Task",
+			"Resource": "arn:aws:lambda:us-east-1:123456789012:function:validate",
+			"ResultPath": "$.validation",
+			"Next": "IsValid"
+		},
+		"IsValid": {
+			"Type": "Choice",
+			"Choices": [{"Variable": "$.validation.ok", "BooleanEquals": true, "Next": "Charge"}],
+			"Default": "Rejected"
+		},
+		"Charge": {
+			"Type": "Task",
+			"Resource": "arn:aws:states:::lambda:invoke",
+			"Parameters": {"FunctionName": "charge", "Payload": {"amount.$": "$.total"}},
+			"ResultSelector": {"receipt.$": "$.Payload.receipt"},
+			"ResultPath": "$.charge",
+			"Retry": [{"ErrorEquals": ["PaymentTimeout"], "MaxAttempts": 2}],
+			"Catch": [{"ErrorEquals": ["States.ALL"], "ResultPath": "$.error", "Next": "Rejected"}],
+			"Next": "Done"
+		},
+		"Rejected": {"Type": "Fail", "Error": "OrderRejected", "Cause": "order could not be processed"},
+		"Done": {"Type": "Succeed", "OutputPath": "$.charge"}
+	}
+}`
+
+type order struct {
+	Total float64 `json:"total"`
+}
+
+func newOrderWorkflow(t *testing.T, charge TaskHandler) *StateMachine {
+	m, err := NewStateMachine("orders", orderWorkflow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.HandleTask("validate", JSONTask(func(o order) (map[string]bool, error) {
+		return map[string]bool{"ok": o.Total > 0}, nil
+	}))
+	m.HandleTask("charge", charge)
+	return m
+}
+
+func TestStateMachineSucceeds(t *testing.T) {
+	failures := 2
+	m := newOrderWorkflow(t, JSONTask(func(in struct{ Amount float64 }) (map[string]string, error) {
+		if failures > 0 {
+			failures--
+			return nil, &TaskError{Name: "PaymentTimeout", Cause: "gateway timed out"}
+		}
+		return map[string]string{"receipt": "r-1"}, nil
+	}))
+
+	exec := m.Start(order{Total: 25})
+	exec.AssertSucceeded(t)
+	exec.AssertVisited(t, "Validate", "IsValid", "Charge", "Done")
+
+	var out struct{ Receipt string }
+	if err := exec.DecodeOutput(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Receipt != "r-1" {
+		t.Errorf("output = %s", exec.Output)
+	}
+}
+
+func TestStateMachineFails(t *testing.T) {
+	m := newOrderWorkflow(t, func(json.RawMessage) (json.RawMessage, error) {
+		return nil, errors.New("card declined")
+	})
+
+	m.Start(order{Total: 0}).AssertFailed(t, "OrderRejected")
+
+	exec := m.Start(order{Total: 10})
+	exec.AssertFailed(t, "OrderRejected")
+	exec.AssertVisited(t, "Charge", "Rejected")
+	if got := exec.Steps[len(exec.Steps)-2]; got.State != "Charge" || got.Error != "" {
+		t.Errorf("Charge step = %+v, want it caught", got)
+	}
+	if n := len(m.Executions()); n != 2 {
+		t.Errorf("recorded %d executions, want 2", n)
+	}
+}
+
+func TestStateMachineParallelAndMap(t *testing.T) {
+	m, err := NewStateMachine("fanout", `{
+		"StartAt": "Fan",
+		"States": {
+			"Fan": {
+				"Type": "Parallel",
+				"End": true,
+				"Branches": [
+					{"StartAt": "Count", "States": {"Count": {"Type": "Pass", "InputPath": "$.items[1]", "End": true}}},
+					{"StartAt": "Each", "States": {"Each": {
+						"Type": "Map",
+						"ItemsPath": "$.items",
+						"Parameters": {"n.$": "$$.Map.Item.Value", "i.$": "$$.Map.Item.Index"},
+						"Iterator": {"StartAt": "Double", "States": {"Double": {"Type": "Task", "Resource": "double", "End": true}}},
+						"End": true
+					}}}
+				]
+			}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.HandleTask("double", JSONTask(func(in struct{ N, I int }) (int, error) {
+		return in.N*2 + in.I, nil
+	}))
+
+	exec := m.Start(map[string][]int{"items": {1, 2, 3}})
+	exec.AssertSucceeded(t)
+	if got := string(exec.Output); got != "[2,[2,5,8]]" {
+		t.Errorf("output = %s", got)
+	}
+}
+
+func TestNewStateMachineInvalid(t *testing.T) {
+	_, err := NewStateMachine("bad", `{"StartAt": "A", "States": {"A": {"Type": "Pass", "Next": "B"}}}`)
+	if err == nil {
+		t.Error("expected an error for an undefined Next state")
+	}
+}
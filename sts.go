@@ -0,0 +1,337 @@
+package testutil
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// FakeSTS is an in-process fake of STS that issues temporary
+// credentials from AssumeRole and GetSessionToken. Expiration is
+// measured with the Clock given by WithClock, so with a FakeClock a
+// test can run a worker's credentials past their expiry in an instant
+// and check that it refreshes them.
+//
+// Credentials from a FakeSTS are accepted by any fake, since the fakes
+// don't check signatures. Pass WithCredentialExpiry to a fake to make
+// it reject them once they expire, as AWS does.
+type FakeSTS struct {
+	// Client is an STS client set up for the fake.
+	Client *sts.STS
+
+	// Session is an AWS Session that uses the fake config.
+	Session *session.Session
+
+	// Config is the AWS config the Session was built from.
+	Config *aws.Config
+
+	// URL is the fake's endpoint.
+	URL string
+
+	clock      Clock
+	srv        *httptest.Server
+	unregister func()
+
+	mu     sync.Mutex
+	issued []STSCredentials
+	keys   map[string]int
+}
+
+// STSCredentials are temporary credentials issued by a FakeSTS.
+type STSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+
+	// RoleARN and SessionName are those given to AssumeRole. They are
+	// empty for other credentials.
+	RoleARN     string
+	SessionName string
+}
+
+// NewFakeSTS starts a FakeSTS.
+func NewFakeSTS(opts ...Option) *FakeSTS {
+	o := newOptions(opts)
+	f := &FakeSTS{
+		clock: o.clock,
+		keys:  make(map[string]int),
+	}
+	f.srv = httptest.NewServer(&queryHandler{
+		xmlns: "https://sts.amazonaws.com/doc/2011-06-15/",
+		operations: map[string]func(url.Values) (interface{}, error){
+			"AssumeRole":        f.assumeRole,
+			"GetSessionToken":   f.getSessionToken,
+			"GetCallerIdentity": f.getCallerIdentity,
+		},
+	})
+	f.URL = f.srv.URL
+	f.Config = fakeAWSConfig(f.URL, o)
+	f.Session = session.New(f.Config)
+	f.Client = sts.New(f.Session)
+	installHooks(o, &f.Session.Handlers, &f.Client.Handlers)
+	f.unregister = OnInterrupt(f.Close)
+	return f
+}
+
+// Close stops the fake.
+func (f *FakeSTS) Close() {
+	if f.unregister != nil {
+		f.unregister()
+	}
+	f.srv.Close()
+}
+
+// AssumeRoleCredentials returns credentials that assume roleARN
+// through the fake with the SDK's stscreds provider, timed by the
+// fake's clock. Passing them to application code exercises its real
+// refresh path. opts configure the provider, for example its Duration
+// (15 minutes by default) or ExpiryWindow.
+func (f *FakeSTS) AssumeRoleCredentials(roleARN string, opts ...func(*stscreds.AssumeRoleProvider)) *credentials.Credentials {
+	return stscreds.NewCredentialsWithClient(f.Client, roleARN, append([]func(*stscreds.AssumeRoleProvider){
+		func(p *stscreds.AssumeRoleProvider) { p.CurrentTime = f.clock.Now },
+	}, opts...)...)
+}
+
+// Issued returns the credentials the fake has issued, oldest first.
+func (f *FakeSTS) Issued() []STSCredentials {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]STSCredentials(nil), f.issued...)
+}
+
+// Expire makes every credential issued so far expire now.
+func (f *FakeSTS) Expire() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.clock.Now()
+	for i := range f.issued {
+		if f.issued[i].Expiration.After(now) {
+			f.issued[i].Expiration = now
+		}
+	}
+}
+
+// expired reports whether accessKeyID was issued by the fake and has
+// expired.
+func (f *FakeSTS) expired(accessKeyID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i, ok := f.keys[accessKeyID]
+	return ok && !f.clock.Now().Before(f.issued[i].Expiration)
+}
+
+func (f *FakeSTS) issue(roleARN, sessionName string, ttl time.Duration) STSCredentials {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := STSCredentials{
+		AccessKeyID:     fmt.Sprintf("ASIAFAKE%012d", len(f.issued)+1),
+		SecretAccessKey: randomToken()[:40],
+		SessionToken:    randomToken(),
+		Expiration:      f.clock.Now().Add(ttl),
+		RoleARN:         roleARN,
+		SessionName:     sessionName,
+	}
+	f.keys[c.AccessKeyID] = len(f.issued)
+	f.issued = append(f.issued, c)
+	return c
+}
+
+type stsCredentials struct {
+	AccessKeyID     string `xml:"AccessKeyId"`
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      string
+}
+
+type stsAssumedRoleUser struct {
+	Arn           string
+	AssumedRoleID string `xml:"AssumedRoleId"`
+}
+
+func (c STSCredentials) xml() stsCredentials {
+	return stsCredentials{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		SessionToken:    c.SessionToken,
+		Expiration:      formatISO8601(c.Expiration),
+	}
+}
+
+// stsDuration reads the DurationSeconds parameter, which AWS requires
+// to be at least 15 minutes.
+func stsDuration(form url.Values, def time.Duration) (time.Duration, error) {
+	s := form.Get("DurationSeconds")
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 900 {
+		return 0, newAWSError(http.StatusBadRequest, "ValidationError", "DurationSeconds must be at least 900, not %q", s)
+	}
+	return time.Duration(n) * time.Second, nil
+}
+
+func (f *FakeSTS) assumeRole(form url.Values) (interface{}, error) {
+	roleARN, sessionName := form.Get("RoleArn"), form.Get("RoleSessionName")
+	if !strings.HasPrefix(roleARN, "arn:aws:iam::") {
+		return nil, newAWSError(http.StatusBadRequest, "ValidationError", "%q is not a role ARN", roleARN)
+	}
+	ttl, err := stsDuration(form, time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	c := f.issue(roleARN, sessionName, ttl)
+	account, role := stsRole(roleARN)
+	return struct {
+		Credentials     stsCredentials
+		AssumedRoleUser stsAssumedRoleUser
+	}{
+		Credentials: c.xml(),
+		AssumedRoleUser: stsAssumedRoleUser{
+			Arn:           fmt.Sprintf("arn:aws:sts::%s:assumed-role/%s/%s", account, role, sessionName),
+			AssumedRoleID: "AROAFAKE:" + sessionName,
+		},
+	}, nil
+}
+
+func (f *FakeSTS) getSessionToken(form url.Values) (interface{}, error) {
+	ttl, err := stsDuration(form, 12*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ Credentials stsCredentials }{f.issue("", "", ttl).xml()}, nil
+}
+
+func (f *FakeSTS) getCallerIdentity(url.Values) (interface{}, error) {
+	return struct {
+		Account string
+		Arn     string
+		UserID  string `xml:"UserId"`
+	}{fakeAccountID, "arn:aws:iam::" + fakeAccountID + ":user/testutil", "AIDAFAKE"}, nil
+}
+
+// stsRole splits a role ARN into its account and role name.
+func stsRole(roleARN string) (account, role string) {
+	parts := strings.SplitN(roleARN, ":", 6)
+	if len(parts) == 6 {
+		account = parts[4]
+		role = parts[5][strings.LastIndex(parts[5], "/")+1:]
+	}
+	return account, role
+}
+
+// ExpiringProvider is a credentials.Provider whose credentials last a
+// fixed time by a Clock. Each retrieval issues a new access key, so
+// tests can tell which credentials signed a request.
+type ExpiringProvider struct {
+	clock Clock
+	ttl   time.Duration
+	sts   *FakeSTS
+
+	mu         sync.Mutex
+	expiration time.Time
+	retrievals int
+}
+
+// NewExpiringProvider returns a provider whose credentials expire ttl
+// after they are retrieved, by clock.
+func NewExpiringProvider(clock Clock, ttl time.Duration) *ExpiringProvider {
+	return &ExpiringProvider{clock: clock, ttl: ttl}
+}
+
+// ExpiringProvider returns a provider like NewExpiringProvider does,
+// using the fake's clock, whose credentials are issued by the fake so
+// that fakes given WithCredentialExpiry(f) reject them once expired.
+func (f *FakeSTS) ExpiringProvider(ttl time.Duration) *ExpiringProvider {
+	return &ExpiringProvider{clock: f.clock, ttl: ttl, sts: f}
+}
+
+// Retrieve issues new credentials.
+func (p *ExpiringProvider) Retrieve() (credentials.Value, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.retrievals++
+	var c STSCredentials
+	if p.sts != nil {
+		c = p.sts.issue("", "", p.ttl)
+	} else {
+		c = STSCredentials{
+			AccessKeyID:     fmt.Sprintf("ASIAEXPIRING%08d", p.retrievals),
+			SecretAccessKey: randomToken()[:40],
+			SessionToken:    randomToken(),
+			Expiration:      p.clock.Now().Add(p.ttl),
+		}
+	}
+	p.expiration = c.Expiration
+	return credentials.Value{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		SessionToken:    c.SessionToken,
+		ProviderName:    "ExpiringProvider",
+	}, nil
+}
+
+// IsExpired reports whether the current credentials have expired.
+func (p *ExpiringProvider) IsExpired() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.retrievals == 0 || !p.clock.Now().Before(p.expiration)
+}
+
+// Retrievals returns how many times credentials have been retrieved.
+func (p *ExpiringProvider) Retrievals() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.retrievals
+}
+
+// ExpiresAt returns when the current credentials expire.
+func (p *ExpiringProvider) ExpiresAt() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.expiration
+}
+
+// WithCredentialExpiry makes an AWS fake reject requests signed with
+// credentials issued by f once they have expired by f's clock, with
+// the ExpiredToken error AWS returns. The SDK expires its cached
+// credentials on that error and retries, so code using a refreshing
+// provider recovers while code holding on to stale keys fails.
+func WithCredentialExpiry(f *FakeSTS) Option {
+	return func(o *options) {
+		o.sendHooks = append(o.sendHooks, func(r *request.Request) *http.Response {
+			if key := signingKeyID(r); key != "" && f.expired(key) {
+				return errorResponse(r, http.StatusForbidden, "ExpiredToken",
+					"The security token included in the request is expired")
+			}
+			return nil
+		})
+	}
+}
+
+// signingKeyID returns the access key ID r was signed with.
+func signingKeyID(r *request.Request) string {
+	auth := r.HTTPRequest.Header.Get("Authorization")
+	i := strings.Index(auth, "Credential=")
+	if i < 0 {
+		return ""
+	}
+	cred := auth[i+len("Credential="):]
+	if j := strings.IndexAny(cred, "/, "); j >= 0 {
+		cred = cred[:j]
+	}
+	return cred
+}
@@ -0,0 +1,83 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+func TestFakeSTSAssumeRoleCredentialsRefresh(t *testing.T) {
+	clock := NewFakeClock(time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC))
+	f := NewFakeSTS(WithClock(clock), WithLogger(t))
+	defer f.Close()
+
+	creds := f.AssumeRoleCredentials("arn:aws:iam::123456789012:role/worker")
+	first, err := creds.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(10 * time.Minute)
+	if again, _ := creds.Get(); again.AccessKeyID != first.AccessKeyID {
+		t.Errorf("credentials refreshed after 10m: %s then %s", first.AccessKeyID, again.AccessKeyID)
+	}
+	clock.Advance(10 * time.Minute)
+	second, err := creds.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.AccessKeyID == first.AccessKeyID {
+		t.Error("credentials were not refreshed after they expired")
+	}
+
+	issued := f.Issued()
+	if len(issued) != 2 {
+		t.Fatalf("issued %d credentials, want 2", len(issued))
+	}
+	if want := clock.Now().Add(15 * time.Minute); !issued[1].Expiration.Equal(want) {
+		t.Errorf("Expiration = %v, want %v", issued[1].Expiration, want)
+	}
+	if issued[0].RoleARN != "arn:aws:iam::123456789012:role/worker" {
+		t.Errorf("RoleARN = %q", issued[0].RoleARN)
+	}
+}
+
+func TestWithCredentialExpiry(t *testing.T) {
+	clock := NewFakeClock(time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC))
+	f := NewFakeSTS(WithClock(clock), WithLogger(t))
+	defer f.Close()
+
+	provider := f.ExpiringProvider(time.Hour)
+	k := NewFakeKMS(WithCredentialsProvider(provider), WithCredentialExpiry(f))
+	defer k.Close()
+	key := k.CreateKey("")
+	encrypt := func(k *FakeKMS) error {
+		_, err := k.Client.Encrypt(&kms.EncryptInput{KeyId: aws.String(key), Plaintext: []byte("x")})
+		return err
+	}
+
+	if err := encrypt(k); err != nil {
+		t.Fatal(err)
+	}
+	// The provider still thinks its credentials are good, so the SDK
+	// only finds out from the ExpiredToken error, and refreshes.
+	f.Expire()
+	if err := encrypt(k); err != nil {
+		t.Fatalf("request after expiry: %v", err)
+	}
+	if n := provider.Retrievals(); n != 2 {
+		t.Errorf("Retrievals = %d, want 2", n)
+	}
+
+	stale := f.Issued()[1]
+	clock.Advance(2 * time.Hour)
+	k2 := NewFakeKMS(WithCredentials(stale.AccessKeyID, stale.SecretAccessKey), WithCredentialExpiry(f))
+	defer k2.Close()
+	k2.CreateKey("") // the same ID as key, since IDs are sequential
+	err := encrypt(k2)
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "ExpiredToken" {
+		t.Errorf("request with stale keys: %v, want ExpiredToken", err)
+	}
+}
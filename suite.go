@@ -0,0 +1,69 @@
+package testutil
+
+import "fmt"
+
+// AWSFixture provisions an Environment for a test suite and resets it
+// before each test. It has the SetupSuite, TearDownSuite and SetupTest
+// methods testify's suite package looks for, so embedding it in a
+// suite wires it into the suite's lifecycle without this package
+// depending on testify:
+//
+//	type OrdersSuite struct {
+//		suite.Suite
+//		testutil.AWSFixture
+//	}
+//
+//	func TestOrders(t *testing.T) {
+//		suite.Run(t, &OrdersSuite{AWSFixture: testutil.AWSFixture{
+//			Spec: testutil.EnvSpec{Queues: []testutil.QueueSpec{{Name: "orders"}}},
+//		}})
+//	}
+//
+//	func (s *OrdersSuite) TestPlaceOrder() {
+//		q := s.Env.Queue("orders")
+//		...
+//	}
+//
+// A suite that defines its own hooks shadows the fixture's, and must
+// call them itself, for example s.AWSFixture.SetupTest() at the start
+// of its SetupTest.
+//
+// The hooks can't report failures to a *testing.T, so they panic
+// instead, which testify reports as a failure of the suite or test.
+type AWSFixture struct {
+	// Spec declares the resources to provision, and Options are passed
+	// to NewEnvironment.
+	Spec    EnvSpec
+	Options []Option
+
+	// Env is the provisioned environment, from SetupSuite until
+	// TearDownSuite.
+	Env *Environment
+}
+
+// SetupSuite provisions Env.
+func (f *AWSFixture) SetupSuite() {
+	env, err := NewEnvironment(f.Spec, f.Options...)
+	if err != nil {
+		panic(fmt.Sprintf("testutil: AWSFixture: %v", err))
+	}
+	f.Env = env
+}
+
+// SetupTest resets Env to the state Spec declares.
+func (f *AWSFixture) SetupTest() {
+	if f.Env == nil {
+		panic("testutil: AWSFixture: SetupTest called before SetupSuite")
+	}
+	if err := f.Env.Reset(); err != nil {
+		panic(fmt.Sprintf("testutil: AWSFixture: %v", err))
+	}
+}
+
+// TearDownSuite tears Env down.
+func (f *AWSFixture) TearDownSuite() {
+	if f.Env != nil {
+		f.Env.Close()
+		f.Env = nil
+	}
+}
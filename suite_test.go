@@ -0,0 +1,48 @@
+package testutil
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAWSFixture(t *testing.T) {
+	sqsSrv := httptest.NewServer(&memSQS{})
+	defer sqsSrv.Close()
+	s3Srv := httptest.NewServer(&memS3{objects: make(map[string]string)})
+	defer s3Srv.Close()
+
+	f := &AWSFixture{
+		Spec: EnvSpec{
+			Buckets:    []BucketSpec{{Name: "uploads", Objects: map[string]string{"seed.txt": "seed"}}},
+			Queues:     []QueueSpec{{Name: "jobs", Messages: []string{"first"}}},
+			S3Options:  []Option{WithEndpoint(s3Srv.URL)},
+			SQSOptions: []Option{WithEndpoint(sqsSrv.URL)},
+		},
+		Options: []Option{WithLogger(t)},
+	}
+	// testify finds the hooks through an embedded AWSFixture.
+	var suite interface {
+		SetupSuite()
+		SetupTest()
+		TearDownSuite()
+	} = struct{ *AWSFixture }{f}
+	suite.SetupSuite()
+
+	for i := 0; i < 2; i++ {
+		suite.SetupTest()
+		uploads := f.Env.Bucket("uploads")
+		if keys, err := uploads.Keys(""); err != nil || len(keys) != 1 || keys[0] != "seed.txt" {
+			t.Errorf("test %d: keys = %q, %v; want just the seed", i, keys, err)
+		}
+		if msgs, err := f.Env.Queue("jobs").ReceiveMessages(10, 0); err != nil || len(msgs) != 1 {
+			t.Errorf("test %d: received %v, %v; want just the seed", i, msgs, err)
+		}
+		uploads.PutString("left-over.txt", "x")
+		f.Env.Queue("jobs").SendMessage("left over", nil)
+	}
+
+	suite.TearDownSuite()
+	if f.Env != nil {
+		t.Error("Env not cleared by TearDownSuite")
+	}
+}
@@ -0,0 +1,222 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Corruption is a way of damaging rows of a generated Table, for
+// testing how import pipelines handle bad input.
+type Corruption int
+
+const (
+	// NoCorruption leaves every row intact.
+	NoCorruption Corruption = iota
+	// Truncated cuts a row off halfway through, as if the file had
+	// been partially written.
+	Truncated
+	// InvalidUTF8 inserts a byte that is not valid UTF-8 into a row.
+	InvalidUTF8
+	// Malformed breaks a row's syntax: an unterminated quote in CSV, a
+	// missing closing brace in NDJSON.
+	Malformed
+	// WrongFieldCount drops a row's last field in CSV, and its last
+	// member in NDJSON.
+	WrongFieldCount
+	// EmptyRow replaces a row with a blank line.
+	EmptyRow
+)
+
+// Table generates tabular fixture files from rows of type T, which
+// must be a struct. In CSV, columns are T's exported fields in order,
+// named by their `csv` tag or else the field name; a tag of "-" skips
+// the field. In NDJSON, each row is T's JSON encoding.
+type Table[T any] struct {
+	// Rows is the number of rows to generate.
+	Rows int
+
+	// Row returns row i, counting from 0.
+	Row func(i int) T
+
+	// Corrupt damages the rows listed in CorruptRows, or the last row
+	// if CorruptRows is empty.
+	Corrupt     Corruption
+	CorruptRows []int
+}
+
+// CSV returns the table as CSV with a header line.
+func (t Table[T]) CSV() ([]byte, error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("testutil: CSV rows must be structs, not %v", typ)
+	}
+	fields, header := csvFields(typ)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(header)
+	w.Flush()
+	for i := 0; i < t.Rows; i++ {
+		v := reflect.ValueOf(t.Row(i))
+		record := make([]string, len(fields))
+		for j, f := range fields {
+			record[j] = csvValue(v.Field(f))
+		}
+		if t.corrupted(i) && t.Corrupt == WrongFieldCount && len(record) > 0 {
+			record = record[:len(record)-1]
+		}
+
+		var line bytes.Buffer
+		lw := csv.NewWriter(&line)
+		lw.Write(record)
+		lw.Flush()
+		if err := lw.Error(); err != nil {
+			return nil, err
+		}
+		row := bytes.TrimSuffix(line.Bytes(), []byte("\n"))
+		if t.corrupted(i) {
+			row = corruptRow(row, t.Corrupt, `"`, nil)
+		}
+		buf.Write(row)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), w.Error()
+}
+
+// NDJSON returns the table as newline-delimited JSON.
+func (t Table[T]) NDJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	for i := 0; i < t.Rows; i++ {
+		row, err := json.Marshal(t.Row(i))
+		if err != nil {
+			return nil, err
+		}
+		if t.corrupted(i) {
+			if t.Corrupt == WrongFieldCount {
+				row, err = dropLastMember(row)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				row = corruptRow(row, t.Corrupt, "", []byte("}"))
+			}
+		}
+		buf.Write(row)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func (t Table[T]) corrupted(i int) bool {
+	if t.Corrupt == NoCorruption {
+		return false
+	}
+	if len(t.CorruptRows) == 0 {
+		return i == t.Rows-1
+	}
+	for _, r := range t.CorruptRows {
+		if r == i {
+			return true
+		}
+	}
+	return false
+}
+
+// corruptRow applies c to row. Malformed rows get openQuote prepended
+// and closer removed from the end.
+func corruptRow(row []byte, c Corruption, openQuote string, closer []byte) []byte {
+	switch c {
+	case Truncated:
+		return row[:len(row)/2]
+	case InvalidUTF8:
+		mid := len(row) / 2
+		return append(append(append([]byte(nil), row[:mid]...), 0xff), row[mid:]...)
+	case Malformed:
+		return append([]byte(openQuote), bytes.TrimSuffix(row, closer)...)
+	case EmptyRow:
+		return nil
+	}
+	return row
+}
+
+// dropLastMember removes the last member from the JSON object row,
+// keeping the other members in order.
+func dropLastMember(row []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(row))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	var members [][]byte
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		k, _ := json.Marshal(key)
+		members = append(members, append(append(k, ':'), value...))
+	}
+	if len(members) > 0 {
+		members = members[:len(members)-1]
+	}
+	return append(append([]byte("{"), bytes.Join(members, []byte(","))...), '}'), nil
+}
+
+func csvFields(typ reflect.Type) (fields []int, header []string) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Tag.Get("csv")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, i)
+		header = append(header, name)
+	}
+	return fields, header
+}
+
+func csvValue(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// PutCSV generates t as CSV and stores it as the object bucket/key
+// with a text/csv content type.
+func PutCSV[T any](s *FakeS3, bucket, key string, t Table[T]) error {
+	b, err := t.CSV()
+	if err != nil {
+		return err
+	}
+	return s.put(bucket, key, b, "text/csv")
+}
+
+// PutNDJSON generates t as newline-delimited JSON and stores it as the
+// object bucket/key with an application/x-ndjson content type.
+func PutNDJSON[T any](s *FakeS3, bucket, key string, t Table[T]) error {
+	b, err := t.NDJSON()
+	if err != nil {
+		return err
+	}
+	return s.put(bucket, key, b, "application/x-ndjson")
+}
@@ -0,0 +1,74 @@
+package testutil
+
+import (
+	"fmt"
+	"testing"
+)
+
+type tableRow struct {
+	ID     int    `json:"id" csv:"id"`
+	Name   string `json:"name"`
+	Secret string `json:"-" csv:"-"`
+}
+
+func rowTable(rows int, c Corruption) Table[tableRow] {
+	return Table[tableRow]{
+		Rows:    rows,
+		Row:     func(i int) tableRow { return tableRow{ID: i + 1, Name: fmt.Sprintf("name %d", i+1)} },
+		Corrupt: c,
+	}
+}
+
+func TestTableCSV(t *testing.T) {
+	for _, tc := range []struct {
+		c    Corruption
+		want string
+	}{
+		{NoCorruption, "id,Name\n1,name 1\n2,name 2\n"},
+		{Truncated, "id,Name\n1,name 1\n2,na\n"},
+		{InvalidUTF8, "id,Name\n1,name 1\n2,na\xffme 2\n"},
+		{Malformed, "id,Name\n1,name 1\n\"2,name 2\n"},
+		{WrongFieldCount, "id,Name\n1,name 1\n2\n"},
+		{EmptyRow, "id,Name\n1,name 1\n\n"},
+	} {
+		got, err := rowTable(2, tc.c).CSV()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("corruption %d: got %q, want %q", tc.c, got, tc.want)
+		}
+	}
+}
+
+func TestTableNDJSON(t *testing.T) {
+	for _, tc := range []struct {
+		c    Corruption
+		want string
+	}{
+		{NoCorruption, "{\"id\":1,\"name\":\"name 1\"}\n"},
+		{Malformed, "{\"id\":1,\"name\":\"name 1\"\n"},
+		{WrongFieldCount, "{\"id\":1}\n"},
+	} {
+		got, err := rowTable(1, tc.c).NDJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("corruption %d: got %q, want %q", tc.c, got, tc.want)
+		}
+	}
+}
+
+func TestTableCorruptRows(t *testing.T) {
+	tbl := rowTable(3, EmptyRow)
+	tbl.CorruptRows = []int{0}
+	got, err := tbl.NDJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "\n{\"id\":2,\"name\":\"name 2\"}\n{\"id\":3,\"name\":\"name 3\"}\n"
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+package testutil
+
+import (
+	"context"
+	"time"
+)
+
+// TB is the part of testing.TB used by the T constructors and helpers.
+// *testing.T and *testing.B satisfy it.
+type TB interface {
+	Logger
+	Helper()
+	Cleanup(func())
+}
+
+// The T constructors below are like their namesakes, but log to t,
+// report failures with t.Fatalf rather than exiting the test binary,
+// and close the fake with t.Cleanup, so callers need no defer. Options
+// given override the Logger.
+
+// NewFakeRedisT is NewFakeRedis for a test.
+func NewFakeRedisT(t TB, opts ...Option) *FakeRedis {
+	t.Helper()
+	r, err := StartFakeRedis(withTestLogger(t, opts)...)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(r.Close)
+	return r
+}
+
+// NewFakeSQST is NewFakeSQS for a test.
+func NewFakeSQST(t TB, queueName string, opts ...Option) *FakeSQS {
+	t.Helper()
+	s, err := StartFakeSQS(context.Background(), queueName, withTestLogger(t, opts)...)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// NewFakeS3T is NewFakeS3 for a test.
+func NewFakeS3T(t TB, bucketName string, opts ...Option) *FakeS3 {
+	t.Helper()
+	s, err := StartFakeS3(context.Background(), bucketName, withTestLogger(t, opts)...)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// NewFakeDynamoT is NewFakeDynamo for a test.
+func NewFakeDynamoT(t TB, opts ...Option) *FakeDynamo {
+	t.Helper()
+	return closeOnCleanup(t, NewFakeDynamo(withTestLogger(t, opts)...))
+}
+
+// NewEnvironmentT is NewEnvironment for a test.
+func NewEnvironmentT(t TB, spec EnvSpec, opts ...Option) *Environment {
+	t.Helper()
+	e, err := NewEnvironment(spec, withTestLogger(t, opts)...)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return closeOnCleanup(t, e)
+}
+
+// NewFakeKMST is NewFakeKMS for a test.
+func NewFakeKMST(t TB, opts ...Option) *FakeKMS {
+	t.Helper()
+	return closeOnCleanup(t, NewFakeKMS(withTestLogger(t, opts)...))
+}
+
+// NewFakeSTST is NewFakeSTS for a test.
+func NewFakeSTST(t TB, opts ...Option) *FakeSTS {
+	t.Helper()
+	return closeOnCleanup(t, NewFakeSTS(withTestLogger(t, opts)...))
+}
+
+// NewFakeCognitoT is NewFakeCognito for a test.
+func NewFakeCognitoT(t TB, opts ...Option) *FakeCognito {
+	t.Helper()
+	return closeOnCleanup(t, NewFakeCognito(withTestLogger(t, opts)...))
+}
+
+// NewFakeEventBridgeT is NewFakeEventBridge for a test.
+func NewFakeEventBridgeT(t TB, opts ...Option) *FakeEventBridge {
+	t.Helper()
+	return closeOnCleanup(t, NewFakeEventBridge(withTestLogger(t, opts)...))
+}
+
+// NewFakeFirehoseT is NewFakeFirehose for a test.
+func NewFakeFirehoseT(t TB, s3 *FakeS3, opts ...Option) *FakeFirehose {
+	t.Helper()
+	return closeOnCleanup(t, NewFakeFirehose(s3, withTestLogger(t, opts)...))
+}
+
+// NewFakeSNST is NewFakeSNS for a test.
+func NewFakeSNST(t TB, opts ...Option) *FakeSNS {
+	t.Helper()
+	return closeOnCleanup(t, NewFakeSNS(withTestLogger(t, opts)...))
+}
+
+// WaitForT calls try until it returns true, failing t with Fatalf if it
+// doesn't within timeout. It polls as set by WithPollInterval and
+// WithPollBackoff.
+func WaitForT(t TB, try func() bool, timeout time.Duration, opts ...Option) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if WaitForContext(ctx, try, opts...) != nil {
+		t.Fatalf("condition not met within %v", timeout)
+	}
+}
+
+// WaitForValueT is WaitForValue for a test: it fails t with Fatalf if
+// fn doesn't succeed within timeout.
+func WaitForValueT[V any](t TB, fn func() (V, bool), timeout time.Duration, opts ...Option) V {
+	t.Helper()
+	v, err := WaitForValue(fn, timeout, opts...)
+	if err != nil {
+		t.Fatalf("%v; last value: %v", err, v)
+	}
+	return v
+}
+
+// withTestLogger returns opts, preceded by logging to t.
+func withTestLogger(t TB, opts []Option) []Option {
+	return append([]Option{WithLogger(t)}, opts...)
+}
+
+// closeOnCleanup closes c when t finishes, and returns it.
+func closeOnCleanup[C interface{ Close() }](t TB, c C) C {
+	t.Cleanup(c.Close)
+	return c
+}
@@ -0,0 +1,97 @@
+package testutil
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fatalTB is a TB whose Fatalf records the failure and stops the
+// calling function with a panic, which catchFatal recovers.
+type fatalTB struct {
+	cleanupT
+	fatal string
+}
+
+type fatalPanic struct{}
+
+func (t *fatalTB) Logf(format string, args ...interface{}) {}
+
+func (t *fatalTB) Fatalf(format string, args ...interface{}) {
+	t.fatal = fmt.Sprintf(format, args...)
+	panic(fatalPanic{})
+}
+
+// catchFatal calls fn, recovering from a fatalTB's Fatalf.
+func catchFatal(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(fatalPanic); !ok {
+				panic(r)
+			}
+		}
+	}()
+	fn()
+}
+
+func TestTConstructorsCleanUp(t *testing.T) {
+	var s *FakeS3
+	var q *FakeSQS
+	t.Run("test", func(t *testing.T) {
+		s = NewFakeS3T(t, "uploads", WithInProcess())
+		q = NewFakeSQST(t, "jobs", WithInProcess())
+		if err := s.PutString("uploads", "a.txt", "A"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := q.SendMessage("hello", nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if _, err := s.GetString("uploads", "a.txt"); err == nil {
+		t.Error("FakeS3 still serving after its test finished")
+	}
+	if _, err := q.SendMessage("hello", nil); err == nil {
+		t.Error("FakeSQS still serving after its test finished")
+	}
+}
+
+func TestTConstructorsFail(t *testing.T) {
+	ft := &fatalTB{}
+	catchFatal(func() {
+		NewFakeS3T(ft, "uploads", WithEndpoint("http://127.0.0.1:1"), WithInProcess())
+		t.Error("NewFakeS3T returned after failing")
+	})
+	if !strings.Contains(ft.fatal, "could not connect to fakes3") {
+		t.Errorf("NewFakeS3T failed with %q", ft.fatal)
+	}
+	if len(ft.cleanups) != 0 {
+		t.Errorf("%d cleanups registered for a fake that didn't start", len(ft.cleanups))
+	}
+}
+
+func TestWaitForT(t *testing.T) {
+	calls := 0
+	WaitForT(t, func() bool { calls++; return calls == 3 }, time.Second, WithPollInterval(time.Millisecond))
+	if calls != 3 {
+		t.Errorf("try called %d times, want 3", calls)
+	}
+
+	ft := &fatalTB{}
+	catchFatal(func() {
+		WaitForT(ft, func() bool { return false }, 20*time.Millisecond)
+	})
+	if ft.fatal != "condition not met within 20ms" {
+		t.Errorf("WaitForT failed with %q", ft.fatal)
+	}
+
+	catchFatal(func() {
+		WaitForValueT(ft, func() (int, bool) { return 7, false }, 20*time.Millisecond)
+	})
+	if !strings.Contains(ft.fatal, "last value: 7") {
+		t.Errorf("WaitForValueT failed with %q", ft.fatal)
+	}
+	if v := WaitForValueT(t, func() (string, bool) { return "ok", true }, time.Second); v != "ok" {
+		t.Errorf("WaitForValueT = %q", v)
+	}
+}
@@ -0,0 +1,194 @@
+package testutil
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// WithStrictTeardown makes a fake's Close check that the test removed
+// everything it created through the fake: buckets and objects for
+// FakeS3, queues for FakeSQS, tables for FakeDynamo and keys for
+// FakeRedis. FakeS3 and FakeSQS then delete their own bucket or queue
+// and check that it is gone. Anything left behind is reported to the
+// Logger's Errorf as a list, so a test run with WithLogger(t) fails
+// instead of leaving state for the next run on shared infrastructure.
+func WithStrictTeardown() Option {
+	return func(o *options) {
+		o.strictTeardown = true
+	}
+}
+
+const createdHandlerName = "testutil.RecordCreated"
+
+// createdResources records the resources created through a fake's
+// clients, in the order they were created.
+type createdResources struct {
+	mu    sync.Mutex
+	names []string
+	seen  map[string]bool
+}
+
+// newCreatedResources returns a createdResources that records the
+// successful requests to the given operations, using name to derive
+// each resource's name, or nil if strict teardown isn't enabled.
+func newCreatedResources(o *options, ops map[string]bool, name func(*request.Request) string, handlers ...*request.Handlers) *createdResources {
+	if !o.strictTeardown {
+		return nil
+	}
+	c := &createdResources{seen: make(map[string]bool)}
+	for _, h := range handlers {
+		h.Unmarshal.PushBackNamed(request.NamedHandler{
+			Name: createdHandlerName,
+			Fn: func(r *request.Request) {
+				if r.Error == nil && ops[operationName(r)] {
+					c.add(name(r))
+				}
+			},
+		})
+	}
+	return c
+}
+
+func (c *createdResources) add(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.seen[name] {
+		c.seen[name] = true
+		c.names = append(c.names, name)
+	}
+}
+
+func (c *createdResources) list() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.names...)
+}
+
+// reportLeftovers reports resources a fake's Close found, if any.
+func reportLeftovers(logger Logger, fake string, leftovers []string) {
+	if len(leftovers) == 0 {
+		return
+	}
+	logger.Errorf("%s: %d resource(s) left behind after teardown:\n  %s",
+		fake, len(leftovers), strings.Join(leftovers, "\n  "))
+}
+
+// isNotFound reports whether err says the resource doesn't exist.
+func isNotFound(err error) bool {
+	if rf, ok := err.(awserr.RequestFailure); ok && rf.StatusCode() == 404 {
+		return true
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "NoSuchBucket", "NoSuchKey", "NotFound",
+			"AWS.SimpleQueueService.NonExistentQueue", "ResourceNotFoundException":
+			return true
+		}
+	}
+	return false
+}
+
+var s3CreateOps = map[string]bool{
+	"s3:CreateBucket":            true,
+	"s3:PutObject":               true,
+	"s3:CopyObject":              true,
+	"s3:CompleteMultipartUpload": true,
+}
+
+// verifyTeardown checks that the objects and buckets created through s
+// are gone, then deletes s's own bucket and checks that it is too.
+func (s *FakeS3) verifyTeardown() {
+	var leftovers []string
+	check := func(what string, err error) {
+		switch {
+		case err == nil:
+			leftovers = append(leftovers, what)
+		case !isNotFound(err):
+			leftovers = append(leftovers, fmt.Sprintf("%s (could not check: %v)", what, err))
+		}
+	}
+	for _, name := range s.created.list() {
+		if i := strings.Index(name, "/"); i >= 0 {
+			_, err := s.Client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(name[:i]), Key: aws.String(name[i+1:])})
+			check("s3 object s3://"+name, err)
+		} else if name != s.bucket {
+			_, err := s.Client.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(name)})
+			check("s3 bucket "+name, err)
+		}
+	}
+	if s.bucket != "" {
+		if _, err := s.Client.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String(s.bucket)}); err != nil && !isNotFound(err) {
+			s.logger.Logf("Could not delete S3 bucket %s: %v", s.bucket, err)
+		}
+		_, err := s.Client.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(s.bucket)})
+		check("s3 bucket "+s.bucket, err)
+	}
+	reportLeftovers(s.logger, "FakeS3", leftovers)
+}
+
+var sqsCreateOps = map[string]bool{"sqs:CreateQueue": true}
+
+// verifyTeardown checks that the queues created through s are gone,
+// then deletes s's own queue and checks that it is too.
+func (s *FakeSQS) verifyTeardown() {
+	var leftovers []string
+	check := func(name string) {
+		_, err := s.Client.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: aws.String(name)})
+		switch {
+		case err == nil:
+			leftovers = append(leftovers, "sqs queue "+name)
+		case !isNotFound(err):
+			leftovers = append(leftovers, fmt.Sprintf("sqs queue %s (could not check: %v)", name, err))
+		}
+	}
+	own := queueNameFromURL(s.URL)
+	for _, name := range s.created.list() {
+		if name != own {
+			check(name)
+		}
+	}
+	if s.URL != "" {
+		if _, err := s.Client.DeleteQueue(&sqs.DeleteQueueInput{QueueUrl: aws.String(s.URL)}); err != nil && !isNotFound(err) {
+			s.logger.Logf("Could not delete SQS queue %s: %v", own, err)
+		}
+		check(own)
+	}
+	reportLeftovers(s.logger, "FakeSQS", leftovers)
+}
+
+var dynamoCreateOps = map[string]bool{"dynamodb:CreateTable": true}
+
+// verifyTeardown checks that the tables created through d, and every
+// table with d's prefix, are gone. It is called after Close has
+// deleted the prefixed tables.
+func (d *FakeDynamo) verifyTeardown() {
+	var leftovers []string
+	prefixed, err := d.tables()
+	if err != nil {
+		leftovers = append(leftovers, fmt.Sprintf("dynamodb tables %s* (could not check: %v)", d.TablePrefix, err))
+	}
+	for _, name := range prefixed {
+		leftovers = append(leftovers, "dynamodb table "+name)
+	}
+	for _, name := range d.created.list() {
+		if strings.HasPrefix(name, d.TablePrefix) {
+			continue
+		}
+		_, err := d.Client.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(name)})
+		switch {
+		case err == nil:
+			leftovers = append(leftovers, "dynamodb table "+name)
+		case !isNotFound(err):
+			leftovers = append(leftovers, fmt.Sprintf("dynamodb table %s (could not check: %v)", name, err))
+		}
+	}
+	reportLeftovers(d.logger, "FakeDynamo", leftovers)
+}
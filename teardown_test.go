@@ -0,0 +1,173 @@
+package testutil
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// recordingLogger is a Logger that records errors instead of failing.
+type recordingLogger struct {
+	mu     sync.Mutex
+	errors []string
+}
+
+func (l *recordingLogger) Logf(format string, args ...interface{}) {}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Fatalf(format string, args ...interface{}) {
+	l.Errorf(format, args...)
+}
+
+// stubQueues serves CreateQueue, GetQueueUrl and DeleteQueue from a
+// set of queue names.
+type stubQueues struct {
+	mu     sync.Mutex
+	queues map[string]bool
+}
+
+func (s *stubQueues) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	action := r.Form.Get("Action")
+	name := r.Form.Get("QueueName")
+	if u := r.Form.Get("QueueUrl"); u != "" {
+		name = queueNameFromURL(u)
+	}
+	switch action {
+	case "CreateQueue":
+		s.queues[name] = true
+	case "DeleteQueue":
+		delete(s.queues, name)
+	case "GetQueueUrl":
+		if !s.queues[name] {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "<ErrorResponse><Error><Type>Sender</Type><Code>AWS.SimpleQueueService.NonExistentQueue</Code><Message>no queue</Message></Error><RequestId>1</RequestId></ErrorResponse>")
+			return
+		}
+	}
+	fmt.Fprintf(w, "<%[1]sResponse><%[1]sResult><QueueUrl>http://%[2]s/%[3]s</QueueUrl></%[1]sResult></%[1]sResponse>", action, r.Host, name)
+}
+
+func TestFakeSQSStrictTeardown(t *testing.T) {
+	stub := &stubQueues{queues: make(map[string]bool)}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	logger := &recordingLogger{}
+	s := NewFakeSQS("jobs", WithEndpoint(srv.URL), WithStrictTeardown(), WithLogger(logger))
+	for _, name := range []string{"tidy", "leaky"} {
+		if _, err := s.Client.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String(name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Client.DeleteQueue(&sqs.DeleteQueueInput{QueueUrl: aws.String(srv.URL + "/tidy")}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if stub.queues["jobs"] {
+		t.Error("Close did not delete the fake's own queue")
+	}
+	if len(logger.errors) != 1 || !strings.Contains(logger.errors[0], "1 resource(s) left behind") ||
+		!strings.Contains(logger.errors[0], "sqs queue leaky") {
+		t.Errorf("errors = %q, want one listing sqs queue leaky", logger.errors)
+	}
+}
+
+func TestFakeSQSCloseWithoutStrictTeardown(t *testing.T) {
+	stub := &stubQueues{queues: make(map[string]bool)}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	logger := &recordingLogger{}
+	s := NewFakeSQS("jobs", WithEndpoint(srv.URL), WithLogger(logger))
+	s.Client.CreateQueue(&sqs.CreateQueueInput{QueueName: aws.String("leaky")})
+	s.Close()
+
+	if !stub.queues["jobs"] || len(logger.errors) > 0 {
+		t.Errorf("queues = %v, errors = %q; want Close to leave everything alone", stub.queues, logger.errors)
+	}
+}
+
+func TestFakeDynamoStrictTeardown(t *testing.T) {
+	tables := map[string]bool{"shared_orders": true}
+	var mu sync.Mutex
+	type request struct{ TableName string }
+	srv := httptest.NewServer(&jsonRPCHandler{
+		targetPrefix: "DynamoDB_20120810",
+		operations: map[string]func([]byte) (interface{}, error){
+			"ListTables": func([]byte) (interface{}, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				names := []string{}
+				for name := range tables {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				return map[string][]string{"TableNames": names}, nil
+			},
+			"CreateTable": func(body []byte) (interface{}, error) {
+				var req request
+				decodeJSONRequest(body, &req)
+				mu.Lock()
+				tables[req.TableName] = true
+				mu.Unlock()
+				return map[string]interface{}{}, nil
+			},
+			"DeleteTable": func(body []byte) (interface{}, error) {
+				var req request
+				decodeJSONRequest(body, &req)
+				mu.Lock()
+				delete(tables, req.TableName)
+				mu.Unlock()
+				return map[string]interface{}{}, nil
+			},
+			"DescribeTable": func(body []byte) (interface{}, error) {
+				var req request
+				decodeJSONRequest(body, &req)
+				mu.Lock()
+				defer mu.Unlock()
+				if !tables[req.TableName] {
+					return nil, newAWSError(400, "ResourceNotFoundException", "table %s not found", req.TableName)
+				}
+				return map[string]interface{}{"Table": map[string]string{"TableName": req.TableName}}, nil
+			},
+		},
+	})
+	defer srv.Close()
+
+	logger := &recordingLogger{}
+	d := NewFakeDynamo(WithEndpoint(srv.URL), WithTablePrefix("ci_"), WithStrictTeardown(), WithLogger(logger))
+	for _, name := range []string{d.Table("orders"), "leaked_users"} {
+		_, err := d.Client.CreateTable(&dynamodb.CreateTableInput{
+			TableName:             aws.String(name),
+			AttributeDefinitions:  []*dynamodb.AttributeDefinition{{AttributeName: aws.String("id"), AttributeType: aws.String("S")}},
+			KeySchema:             []*dynamodb.KeySchemaElement{{AttributeName: aws.String("id"), KeyType: aws.String("HASH")}},
+			ProvisionedThroughput: &dynamodb.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(1), WriteCapacityUnits: aws.Int64(1)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Close()
+
+	if len(logger.errors) != 1 || !strings.Contains(logger.errors[0], "dynamodb table leaked_users") ||
+		strings.Contains(logger.errors[0], "orders") {
+		t.Errorf("errors = %q, want one listing only dynamodb table leaked_users", logger.errors)
+	}
+}
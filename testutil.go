@@ -1,96 +1,220 @@
 // testutil contains utility functions for integration-style
-// tests. The S3 and SQS utilities require the fakes3 and fake_sqs
-// gems, respectively, to be installed and available in the $PATH.
+// tests. The S3 and SQS utilities use the fakes3 and fake_sqs gems,
+// respectively, if they are installed, and serve S3 and SQS in process
+// otherwise.
+//
+// The Redis helpers and the polling helpers are also importable on
+// their own, from redistest and waitfor, by tests that shouldn't link
+// the AWS SDK; the names here wrap them. The SQS and S3 fakes have no
+// such subpackages: they share this package's options, request hooks
+// and reporting, which are built on the SDK's request types, so a test
+// binary using them links the SDK either way.
 package testutil
 
 import (
 	"bytes"
-	"io"
-	"log"
+	"context"
+	"fmt"
 	"net"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/garyburd/redigo/redis"
+	"github.com/rainforestapp/testutil/redistest"
+	"github.com/rainforestapp/testutil/waitfor"
 )
 
 const (
-	sqsEndpoint = "http://0.0.0.0:4568"
-	s3Port      = "4569"
-	redisPort   = "6379"
-	redisTestDB = 9
+	defaultSQSEndpoint = "http://0.0.0.0:4568"
+	defaultS3Endpoint  = "http://0.0.0.0:4569"
+	defaultRegion      = "us-east-1"
+
+	fakeAccessKeyID     = "abc123"
+	fakeSecretAccessKey = "SEKRIT"
 )
 
 // FakeRedis holds a redis pool for for testing. It requires a local
-// redis server to be installed and running. All tests will be run on
-// DB 9, which will be flushed before and after usage, so do not run
-// against a server where you need values from DB 9!
+// redis server to be installed and running. Each FakeRedis claims a
+// database no other test is using, which will be flushed before and
+// after usage: redistest.DB, whatever it holds, or else an empty one.
+// So do not run against a server where you need values from DB 9!
+//
+// It is a redistest.FakeRedis wired up to this package's Options;
+// tests that only need redis can import redistest instead, without the
+// AWS SDK.
 type FakeRedis struct {
-	Pool *redis.Pool
+	*redistest.FakeRedis
+
+	backend    BackendVersion
+	proc       *process
+	container  *container
+	detach     func()
+	unregister func()
 }
 
 // NewFakeRedis creates sets up a redis DB for testing and returns a
 // pointer to a FakeRedis object.
-func NewFakeRedis() *FakeRedis {
-	r := new(FakeRedis)
-	r.Pool = &redis.Pool{
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", ":"+redisPort)
-			if err != nil {
-				log.Fatal("Error connecting to redis, is it running? Error:", err)
-			}
-			// Use DB 9 as a test db
-			_, err = c.Do("SELECT", redisTestDB)
-			if err != nil {
-				return nil, err
-			}
+func NewFakeRedis(opts ...Option) *FakeRedis {
+	r, err := StartFakeRedis(opts...)
+	if err != nil {
+		newOptions(opts).logger.Fatalf("%v", err)
+	}
+	return r
+}
+
+// NewFakeRedisDB is like NewFakeRedis, but uses database db rather than
+// claiming a free one, for tests that need a particular database.
+// Nothing stops other tests using db at the same time.
+func NewFakeRedisDB(db int, opts ...Option) *FakeRedis {
+	return NewFakeRedis(append(opts, func(o *options) { o.redisDB = db })...)
+}
 
-			return c, nil
-		},
+// StartFakeRedis is like NewFakeRedis, but returns an error if the test
+// DB can't be prepared, rather than reporting it to the Logger with
+// Fatalf, which by default exits the test binary without cleaning up
+// other fakes.
+func StartFakeRedis(opts ...Option) (*FakeRedis, error) {
+	o := newOptions(opts)
+	r := &FakeRedis{}
+	ropts := redisOptions(o)
+	switch {
+	case o.docker:
+		c, addr, err := startContainer(context.Background(), dockerRedis, o)
+		if err != nil {
+			return nil, err
+		}
+		r.container = c
+		if err := dockerRedisReady(context.Background(), c, addr); err != nil {
+			r.stopServer()
+			return nil, err
+		}
+		ropts = append(ropts, redistest.WithAddr(addr))
+	case o.launch:
+		dep := RedisDependency
+		dep.Name, dep.Hint = "redis-server", "install redis"
+		p, endpoint, err := launchFake(dep, func(port int, dir string) []string {
+			return []string{"--port", strconv.Itoa(port), "--bind", "127.0.0.1", "--dir", dir, "--save", "", "--appendonly", "no"}
+		}, o.processLogger())
+		if err != nil {
+			return nil, err
+		}
+		r.proc = p
+		addr := strings.TrimPrefix(endpoint, "http://")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var dialer net.Dialer
+		err = p.waitReady(ctx, func() bool {
+			c, err := dialer.DialContext(ctx, "tcp", addr)
+			if err == nil {
+				c.Close()
+			}
+			return err == nil
+		}, 10*time.Millisecond)
+		if err != nil {
+			r.stopServer()
+			return nil, err
+		}
+		ropts = append(ropts, redistest.WithAddr(addr))
+	}
+	fr, err := redistest.Start(ropts...)
+	if err != nil {
+		r.stopServer()
+		return nil, err
+	}
+	r.FakeRedis = fr
+	r.backend = BackendVersion{Name: "redis", Version: r.ServerVersion()}
+	checkBackend(o, r.backend)
+	if o.admin != nil {
+		r.detach = o.admin.addRedis(r)
 	}
+	r.unregister = OnInterrupt(r.Close)
 
-	c := r.Pool.Get()
-	c.Do("FLUSHDB")
-	c.Close()
+	return r, nil
+}
 
-	return r
+// redisOptions translates o into options for redistest, hooking up
+// the reporter, network partitions and chaos runs.
+func redisOptions(o *options) []redistest.Option {
+	np := o.partition
+	ret := []redistest.Option{
+		redistest.WithLogger(o.logger),
+		redistest.WithDialRetry(o.dialAttempts, o.dialBackoff),
+		redistest.WithDialHint(func() string { return preflightHint(RedisDependency) }),
+		redistest.WithDialHook(func() error {
+			if np != nil && np.refuse() {
+				return errPartitioned
+			}
+			return nil
+		}),
+		redistest.WithCommandHook(func(conn redis.Conn, cmd string) error {
+			if np != nil && np.refuse() {
+				conn.Close()
+				return errPartitioned
+			}
+			return chaosRedis(conn, cmd)
+		}),
+	}
+	if o.strictTeardown {
+		ret = append(ret, redistest.WithStrictTeardown())
+	}
+	if o.redisDB >= 0 {
+		ret = append(ret, redistest.WithDB(o.redisDB))
+	}
+	if o.reporter != nil {
+		ret = append(ret, redistest.WithObserver(o.reporter.redisCommand))
+	}
+	return ret
 }
 
-// Close cleans up after a redis test.
+// Close cleans up after a redis test. With WithStrictTeardown it
+// first reports any keys the test left behind.
 func (r *FakeRedis) Close() {
-	conn := r.Pool.Get()
-	conn.Do("FLUSHDB")
-	conn.Close()
+	if r.unregister != nil {
+		r.unregister()
+	}
+	if r.detach != nil {
+		r.detach()
+	}
+	r.FakeRedis.Close()
+	r.stopServer()
+}
 
-	r.Pool.Close()
+// stopServer stops any redis server the fake started.
+func (r *FakeRedis) stopServer() {
+	if r.proc != nil {
+		r.proc.stop()
+	}
+	if r.container != nil {
+		r.container.stop()
+	}
 }
 
 // ListenRedisChan subscribes to redis channel c and signals the
-// returned channel when it receives messages.
-func ListenRedisChan(pool *redis.Pool, c string) chan struct{} {
-	ret := make(chan struct{})
-	go func() {
-		psc := redis.PubSubConn{Conn: pool.Get()}
-		defer psc.Close()
-
-		psc.Subscribe(c)
-		for {
-			switch v := psc.Receive().(type) {
-			case redis.Message:
-				ret <- struct{}{}
-			case redis.Subscription:
-			case error:
-				log.Fatal("Subscription error:", v)
-			}
-		}
-	}()
+// returned channel when it receives messages. Subscription errors are
+// reported to the configured Logger and stop the listener. It listens
+// until the test binary exits; ListenRedisMessages can be stopped.
+func ListenRedisChan(pool *redis.Pool, c string, opts ...Option) chan struct{} {
+	o := newOptions(opts)
+	return redistest.ListenChan(pool, c, redistest.WithLogger(o.logger))
+}
 
-	return ret
+// ListenRedisMessages subscribes to redis channel c and sends the
+// messages published to it on the returned message channel until ctx
+// is canceled. See redistest.ListenMessages.
+func ListenRedisMessages(ctx context.Context, pool *redis.Pool, c string) (<-chan redis.Message, <-chan error) {
+	return redistest.ListenMessages(ctx, pool, c)
 }
 
 // FakeSQS holds an SQS client and queue for a fake_sqs instance. It
@@ -104,35 +228,177 @@ type FakeSQS struct {
 	// Session is an AWS Session that uses the fake config.
 	Session *session.Session
 
+	// Config is the AWS config the Session was built from. Code that
+	// constructs its own clients can use it to talk to the same fake.
+	Config *aws.Config
+
 	// URL is the URL for a fake SQS queue.
 	URL string
+
+	// Endpoint is the URL of the SQS server. It is on a random free
+	// port when the fake starts its own server.
+	Endpoint string
+
+	logger     Logger
+	backend    BackendVersion
+	proc       *process
+	container  *container
+	srv        *sqsServer
+	created    *createdResources
+	detach     func()
+	unregister func()
 }
 
 // NewFakeSQS starts a fake_sqs process and creates a queue with name
 // queueName. It returns a FakeSQS object with an SQS client and a URL
-// for the newly-created queue.
-func NewFakeSQS(queueName string) *FakeSQS {
-	s := new(FakeSQS)
+// for the newly-created queue. By default it talks to fake_sqs on
+// port 4568; use WithEndpoint to point it elsewhere, WithLaunch to
+// launch a fake_sqs of its own, WithDocker to run ElasticMQ in a
+// container instead, WithInProcess to serve SQS from the test process,
+// and WithQueueAttributes to create the
+// queue with a non-default configuration. If fake_sqs isn't installed,
+// and isn't listening on its port either unless WithLaunch is given,
+// SQS is served in process, as with WithInProcess.
+func NewFakeSQS(queueName string, opts ...Option) *FakeSQS {
+	return NewFakeSQSContext(context.Background(), queueName, opts...)
+}
+
+// NewFakeSQSContext is like NewFakeSQS, but stops waiting for fake_sqs
+// as soon as ctx is done, for suites run under a deadline.
+func NewFakeSQSContext(ctx context.Context, queueName string, opts ...Option) *FakeSQS {
+	s, err := StartFakeSQS(ctx, queueName, opts...)
+	if err != nil {
+		newOptions(opts).logger.Fatalf("%v", err)
+	}
+	return s
+}
 
-	s.Session = session.New(fakeAWSConfig(sqsEndpoint))
+// StartFakeSQS is like NewFakeSQSContext, but returns an error if
+// fake_sqs can't be reached or the queue can't be created, rather than
+// reporting it to the Logger with Fatalf, which by default exits the
+// test binary without cleaning up other fakes.
+func StartFakeSQS(ctx context.Context, queueName string, opts ...Option) (*FakeSQS, error) {
+	o := newOptions(opts)
+	s := &FakeSQS{logger: o.logger}
+
+	endpoint := o.endpoint
+	if endpoint == "" && !o.inProcess && !o.docker && FakeSQSDependency.missing(o.launch) {
+		o.logger.Logf("fake_sqs is not installed; serving SQS in process")
+		o.inProcess = true
+	}
+	switch {
+	case endpoint == "" && o.inProcess:
+		s.srv = startSQSServer(o)
+		endpoint = s.srv.srv.URL
+	case endpoint == "" && o.docker:
+		var addr string
+		var err error
+		s.container, addr, err = startContainer(ctx, dockerSQS, o)
+		if err != nil {
+			return nil, err
+		}
+		endpoint = "http://" + addr
+	case endpoint == "" && o.launch:
+		var err error
+		s.proc, endpoint, err = launchFake(FakeSQSDependency, func(port int, dir string) []string {
+			return []string{"-o", "127.0.0.1", "-p", strconv.Itoa(port), "--database", filepath.Join(dir, "database.yml")}
+		}, o.processLogger())
+		if err != nil {
+			return nil, err
+		}
+	case endpoint == "":
+		endpoint = defaultSQSEndpoint
+	}
+
+	s.Endpoint = endpoint
+	s.Config = fakeAWSConfig(endpoint, o)
+	s.Session = session.New(s.Config)
+	s.Client = sqs.New(s.Session)
+	timeout := 10 * time.Second
+	if s.container != nil {
+		timeout = dockerReadyTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var lastErr error
 	tryConnect := func() bool {
-		s.Client = sqs.New(s.Session)
-		_, err := s.Client.CreateQueue(&sqs.CreateQueueInput{
-			QueueName: &queueName,
+		req, _ := s.Client.CreateQueueRequest(&sqs.CreateQueueInput{
+			QueueName:  &queueName,
+			Attributes: o.queueAttributes,
+		})
+		lastErr = sendWithContext(ctx, req)
+		return lastErr == nil
+	}
+	var err error
+	switch {
+	case s.container != nil:
+		err = s.container.waitReady(ctx, func() error {
+			tryConnect()
+			return lastErr
 		})
-		return err == nil
+		lastErr = nil
+	case s.proc != nil:
+		err = s.proc.waitReady(ctx, tryConnect, 10*time.Millisecond)
+	default:
+		err = waitfor.Context(ctx, tryConnect, 10*time.Millisecond)
 	}
-	fail := func() {
-		log.Fatal("fake_sqs failed to start in a reasonable amount of time")
+	if err != nil {
+		if s.proc != nil {
+			s.proc.stop()
+		}
+		if s.container != nil {
+			s.container.stop()
+		}
+		if s.srv != nil {
+			s.srv.close()
+		}
+		if lastErr != nil {
+			err = fmt.Errorf("%v (last error: %v)", err, lastErr)
+		}
+		return nil, fmt.Errorf("fake_sqs failed to start: %v%s", err, preflightHint(endpointDependency(FakeSQSDependency, endpoint)))
 	}
-	WaitFor(tryConnect, fail, 10*time.Second)
-	s.URL = sqsEndpoint + "/" + queueName
+	s.URL = strings.TrimSuffix(endpoint, "/") + "/" + queueName
+	switch {
+	case s.srv != nil:
+		s.backend = BackendVersion{Name: "in-process"}
+	case s.container != nil:
+		s.backend = s.container.backendVersion(dockerSQS)
+	default:
+		s.backend = detectBackend(FakeSQSDependency, endpoint)
+	}
+	checkBackend(o, s.backend)
+	installHooks(o, &s.Session.Handlers, &s.Client.Handlers)
+	s.created = newCreatedResources(o, sqsCreateOps, resourceName, &s.Session.Handlers, &s.Client.Handlers)
+	if o.admin != nil {
+		s.detach = o.admin.addQueue(queueName, s)
+	}
+	s.unregister = OnInterrupt(s.Close)
 
-	return s
+	return s, nil
 }
 
-// Close cleans up after a fake_sqs process.
+// Close cleans up after a fake_sqs process. With WithStrictTeardown it
+// also deletes the queue and reports anything the test left behind.
+// If the fake launched fake_sqs, or serves SQS itself, Close stops it.
 func (s *FakeSQS) Close() {
+	if s.unregister != nil {
+		s.unregister()
+	}
+	if s.detach != nil {
+		s.detach()
+	}
+	if s.created != nil {
+		s.verifyTeardown()
+	}
+	if s.proc != nil {
+		s.proc.stop()
+	}
+	if s.container != nil {
+		s.container.stop()
+	}
+	if s.srv != nil {
+		s.srv.close()
+	}
 }
 
 // FakeS3 holds a client for a fakes3 server. It requires the fakes3
@@ -144,15 +410,115 @@ type FakeS3 struct {
 
 	// Session is an AWS Session that uses the fake config.
 	Session *session.Session
+
+	// Config is the AWS config the Session was built from. Code that
+	// constructs its own clients can use it to talk to the same fake.
+	Config *aws.Config
+
+	// Endpoint is the URL of the S3 server. It is on a random free
+	// port when the fake starts its own server.
+	Endpoint string
+
+	logger                 Logger
+	multipartCopyThreshold int64
+	copyPartSize           int64
+	assertTimeout          time.Duration
+	sse                    *sseKMSObjects
+	bucket                 string
+	backend                BackendVersion
+	proc                   *process
+	container              *container
+	srv                    *httptest.Server
+	created                *createdResources
+	detach                 func()
+	unregister             func()
 }
 
 // NewFakeS3 starts a fakes3 process and creates a bucket with name
-// bucketName. It returns a pointer to a FakeS3.
-func NewFakeS3(bucketName string) *FakeS3 {
-	s := new(FakeS3)
+// bucketName. It returns a pointer to a FakeS3. By default it talks to
+// fakes3 on port 4569 using path-style addressing; see WithEndpoint,
+// WithLaunch, WithDocker, WithInProcess and WithPathStyle. If fakes3
+// isn't installed, and isn't listening on its port either unless
+// WithLaunch is given, S3 is served in process, as with WithInProcess.
+func NewFakeS3(bucketName string, opts ...Option) *FakeS3 {
+	return NewFakeS3Context(context.Background(), bucketName, opts...)
+}
+
+// NewFakeS3Context is like NewFakeS3, but stops waiting for fakes3 as
+// soon as ctx is done, for suites run under a deadline.
+func NewFakeS3Context(ctx context.Context, bucketName string, opts ...Option) *FakeS3 {
+	s, err := StartFakeS3(ctx, bucketName, opts...)
+	if err != nil {
+		newOptions(opts).logger.Fatalf("%v", err)
+	}
+	return s
+}
+
+// StartFakeS3 is like NewFakeS3Context, but returns an error if fakes3
+// can't be reached or the bucket can't be created, rather than
+// reporting it to the Logger with Fatalf, which by default exits the
+// test binary without cleaning up other fakes.
+func StartFakeS3(ctx context.Context, bucketName string, opts ...Option) (*FakeS3, error) {
+	o := newOptions(opts)
+	s := &FakeS3{
+		logger:                 o.logger,
+		multipartCopyThreshold: o.multipartCopyThreshold,
+		copyPartSize:           o.copyPartSize,
+		assertTimeout:          o.assertTimeout,
+		bucket:                 bucketName,
+	}
+
+	endpoint := o.endpoint
+	if endpoint == "" && !o.inProcess && !o.docker && FakeS3Dependency.missing(o.launch) {
+		o.logger.Logf("fakes3 is not installed; serving S3 in process")
+		o.inProcess = true
+	}
+	switch {
+	case endpoint == "" && o.inProcess:
+		s.srv = httptest.NewServer(newS3Server(o))
+		endpoint = s.srv.URL
+		o.pathStyle = true
+	case endpoint == "" && o.docker:
+		var addr string
+		var err error
+		s.container, addr, err = startContainer(ctx, dockerS3, o)
+		if err != nil {
+			return nil, err
+		}
+		endpoint = "http://" + addr
+	case endpoint == "" && o.launch:
+		var err error
+		s.proc, endpoint, err = launchFake(FakeS3Dependency, func(port int, dir string) []string {
+			return []string{"-r", dir, "-a", "127.0.0.1", "-p", strconv.Itoa(port)}
+		}, o.processLogger())
+		if err != nil {
+			return nil, err
+		}
+	case endpoint == "":
+		endpoint = defaultS3Endpoint
+	}
+	// fail stops any fakes3, container or server started before
+	// returning err.
+	fail := func(err error) (*FakeS3, error) {
+		if s.proc != nil {
+			s.proc.stop()
+		}
+		if s.container != nil {
+			s.container.stop()
+		}
+		if s.srv != nil {
+			s.srv.Close()
+		}
+		return nil, err
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fail(fmt.Errorf("invalid S3 endpoint %q: %v", endpoint, err))
+	}
 
+	var dialer net.Dialer
 	tryConnect := func() bool {
-		c, err := net.Dial("tcp", ":"+s3Port)
+		c, err := dialer.DialContext(ctx, "tcp", u.Host)
 		if err == nil {
 			c.Close()
 			return true
@@ -160,57 +526,162 @@ func NewFakeS3(bucketName string) *FakeS3 {
 			return false
 		}
 	}
-	fail := func() {
-		log.Fatal("Could not connect to fakes3")
+	switch {
+	case s.container != nil:
+		// Docker publishes the port before the server in the
+		// container listens, so readiness is checked by creating the
+		// bucket below instead.
+	case s.proc != nil:
+		// Ruby takes a while to boot.
+		waitCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		err = s.proc.waitReady(waitCtx, tryConnect, 10*time.Millisecond)
+	default:
+		waitCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		defer cancel()
+		err = waitfor.Context(waitCtx, tryConnect, 10*time.Millisecond)
+	}
+	if err != nil {
+		return fail(fmt.Errorf("could not connect to fakes3: %v%s", err, preflightHint(endpointDependency(FakeS3Dependency, endpoint))))
 	}
-	WaitFor(tryConnect, fail, 3*time.Second)
 
-	s.Session = session.New(fakeAWSConfig("http://0.0.0.0:" + s3Port))
+	s.Endpoint = endpoint
+	s.Config = fakeAWSConfig(endpoint, o)
+	s.Session = session.New(s.Config)
 	s.Client = s3.New(s.Session)
-	_, err := s.Client.CreateBucket(&s3.CreateBucketInput{
-		Bucket: &bucketName,
-	})
+	createBucket := func() error {
+		req, _ := s.Client.CreateBucketRequest(&s3.CreateBucketInput{
+			Bucket: &bucketName,
+		})
+		return sendWithContext(ctx, req)
+	}
+	if s.container != nil {
+		err = s.container.waitReady(ctx, createBucket)
+	} else {
+		err = createBucket()
+	}
 	if err != nil {
-		log.Fatal("Error creating S3 bucket:", err)
+		return fail(fmt.Errorf("creating S3 bucket %s: %v%s", bucketName, err, preflightHint(endpointDependency(FakeS3Dependency, endpoint))))
+	}
+	switch {
+	case s.srv != nil:
+		s.backend = BackendVersion{Name: "in-process"}
+	case s.container != nil:
+		s.backend = s.container.backendVersion(dockerS3)
+	default:
+		s.backend = detectBackend(FakeS3Dependency, endpoint)
+	}
+	checkBackend(o, s.backend)
+	if o.kms != nil {
+		s.sse = newSSEKMSObjects(o.kms)
+		o.sendHooks = append(o.sendHooks, s.sse.install(&s.Session.Handlers, &s.Client.Handlers))
 	}
+	installHooks(o, &s.Session.Handlers, &s.Client.Handlers)
+	s.created = newCreatedResources(o, s3CreateOps, resourceName, &s.Session.Handlers, &s.Client.Handlers)
+	if o.admin != nil {
+		s.detach = o.admin.addBucket(bucketName, s)
+	}
+	s.unregister = OnInterrupt(s.Close)
 
-	return s
+	return s, nil
 }
 
-// Close cleans up after and kills a fakes3 instance.
+// Close cleans up after and kills a fakes3 instance. With
+// WithStrictTeardown it also deletes the bucket and reports anything
+// the test left behind. If the fake launched fakes3, or serves S3
+// itself, Close stops it and removes its data.
 func (s *FakeS3) Close() {
+	if s.unregister != nil {
+		s.unregister()
+	}
+	if s.detach != nil {
+		s.detach()
+	}
+	if s.created != nil {
+		s.verifyTeardown()
+	}
+	if s.proc != nil {
+		s.proc.stop()
+	}
+	if s.container != nil {
+		s.container.stop()
+	}
+	if s.srv != nil {
+		s.srv.Close()
+	}
 }
 
 // fakeAWSConfig returns a fake AWS config set up at endpoint. It is
 // useful for interacting with fake SQS and S3.
-func fakeAWSConfig(endpoint string) *aws.Config {
+func fakeAWSConfig(endpoint string, o *options) *aws.Config {
+	region := o.region
+	if region == "" {
+		region = defaultRegion
+	}
 	// The client library needs access keys even though fake s3/sqs
-	// don't
-	os.Setenv("AWS_ACCESS_KEY", "abc123")
-	os.Setenv("AWS_SECRET_KEY", "SEKRIT")
+	// don't. They are set on the config rather than in the
+	// environment so that they don't leak into other tests.
+	creds := o.credentials
+	if creds == nil {
+		creds = credentials.NewStaticCredentials(fakeAccessKeyID, fakeSecretAccessKey, "")
+	}
 	return &aws.Config{
-		Region:           aws.String("us-east-1"),
+		Region:           aws.String(region),
+		Credentials:      creds,
 		DisableSSL:       aws.Bool(true),
 		Endpoint:         &endpoint,
-		S3ForcePathStyle: aws.Bool(true),
+		S3ForcePathStyle: aws.Bool(o.pathStyle),
+		HTTPClient:       o.httpClient,
 	}
 }
 
 // WaitFor runs the try function repeatedly until it returns true. If
 // the try function does not return true within the timeout period,
-// fail is called. WaitFor is mostly useful for checking conditions
-// asynchronously in tests; it probably shouldn't be used for
-// production code.
+// fail is called. It is waitfor.Condition, kept here for
+// compatibility; WaitForContext can be cancelled and returns an error
+// instead.
 func WaitFor(try func() bool, fail func(), timeout time.Duration) {
-	start := time.Now()
-	for {
-		if try() {
-			return
-		} else if time.Now().Sub(start) > timeout {
-			fail()
-			return
-		}
+	waitfor.Condition(try, fail, timeout)
+}
+
+// WaitForValue calls fn until it reports success, and returns the value
+// it produced then. If fn doesn't succeed within timeout, WaitForValue
+// returns the last value it produced and an error. fn is polled every
+// 10ms, or as set by WithPollInterval and WithPollBackoff.
+func WaitForValue[T any](fn func() (T, bool), timeout time.Duration, opts ...Option) (T, error) {
+	o := newOptions(opts)
+	var v T
+	try := func() bool {
+		var ok bool
+		v, ok = fn()
+		return ok
 	}
+	if try() {
+		return v, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if o.poll(ctx, try) != nil {
+		return v, fmt.Errorf("condition not met within %v", timeout)
+	}
+	return v, nil
+}
+
+// WaitForContext calls try until it returns true, returning nil, or
+// until ctx is done, returning ctx's error. Unlike WaitFor it sleeps
+// between tries: try is polled every 10ms, or as set by
+// WithPollInterval, and WithPollBackoff makes the interval grow.
+func WaitForContext(ctx context.Context, try func() bool, opts ...Option) error {
+	return newOptions(opts).poll(ctx, try)
+}
+
+// poll calls try until it returns true or ctx is done, at the interval
+// set by WithPollInterval and WithPollBackoff.
+func (o *options) poll(ctx context.Context, try func() bool) error {
+	if o.pollBackoff > o.pollInterval {
+		return waitfor.Backoff(ctx, try, o.pollInterval, o.pollBackoff)
+	}
+	return waitfor.Context(ctx, try, o.pollInterval)
 }
 
 // ShouldCrash checks that the code under test, contained in the try function,
@@ -221,52 +692,102 @@ func WaitFor(try func() bool, fail func(), timeout time.Duration) {
 //
 // In order for ShouldCrash to work properly, it should only be called once per
 // test; otherwise subsequent conditions will not be tested.
+// ShouldCrashID and ShouldCrashT allow more.
 //
 // This uses a technique from https://talks.golang.org/2014/testing.slide#23
 func ShouldCrash(testName string, try func(), fail func()) {
-	if os.Getenv("SHOULD_CRASH") == "1" {
-		try()
-		os.Exit(0)
+	res, err := ShouldCrashResult(testName, try)
+	if err != nil || !res.Crashed {
+		fail()
 	}
-	cmd := exec.Command(os.Args[0], "-test.run="+testName)
-	cmd.Env = append(os.Environ(), "SHOULD_CRASH=1")
-	err := cmd.Run()
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
-		return
-	}
-	fail()
 }
 
-// CaptureStdOut takes a function that prints to os.Stdout and returns the
-// output as a string. If any error occurs when the given function is called,
-// an empty string and the error is returned to the caller of CaptureStdOut.
-func CaptureStdout(printFunction func() error) (string, error) {
-	r, w, err := os.Pipe()
-	if err != nil {
-		return "", err
-	}
-
-	originalStdOut := os.Stdout
-	os.Stdout = w
-	defer func() {
-		os.Stdout = originalStdOut
-	}()
+// CrashT is the part of testing.TB ShouldCrashT uses.
+type CrashT interface {
+	TestingT
+	FatalT
+	Name() string
+}
 
-	err = printFunction()
+// ShouldCrashT checks that try exits the program with a non-zero exit
+// code, re-running only t, which may be a subtest, and only the
+// ShouldCrashT call with the same id, in a subprocess. It fails t with
+// the subprocess's output if try doesn't crash, and returns the result
+// for checking the fatal message.
+func ShouldCrashT(t CrashT, id string, try func()) CrashResult {
+	t.Helper()
+	res, err := ShouldCrashID(runPattern(t.Name()), id, try)
 	if err != nil {
-		return "", err
+		t.Fatalf("running crash %q: %v", id, err)
 	}
-
-	err = w.Close()
-	if err != nil {
-		return "", err
+	if !res.Crashed {
+		t.Errorf("crash %q exited successfully; output:\n%s%s", id, res.Stdout, res.Stderr)
 	}
+	return res
+}
 
-	buf := bytes.Buffer{}
-	_, err = io.Copy(&buf, r)
-	if err != nil {
-		return "", err
+// runPattern returns a -test.run pattern matching only the test or
+// subtest called name.
+func runPattern(name string) string {
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		parts[i] = "^" + regexp.QuoteMeta(p) + "$"
 	}
+	return strings.Join(parts, "/")
+}
+
+// CrashResult is what happened to the subprocess ShouldCrashResult ran.
+type CrashResult struct {
+	// Crashed is whether the subprocess exited with a non-zero exit
+	// code.
+	Crashed bool
+
+	// ExitCode is the subprocess's exit code, or -1 if it was killed by
+	// a signal.
+	ExitCode int
+
+	// Stdout and Stderr are what the subprocess printed, where the
+	// message of a log.Fatal can be found.
+	Stdout string
+	Stderr string
+}
+
+// ShouldCrashResult is like ShouldCrash, but returns what the subprocess
+// did, including its output and exit code, so the test can check why it
+// crashed or see why it didn't. The error is only for a subprocess that
+// couldn't be run.
+func ShouldCrashResult(testName string, try func()) (CrashResult, error) {
+	return ShouldCrashID(testName, "", try)
+}
 
-	return buf.String(), nil
+// ShouldCrashID is like ShouldCrashResult, but the subprocess only runs
+// the try function of the ShouldCrashID call with the same id, so a
+// test can make several crash assertions, each with its own id. In the
+// subprocess, calls with other ids return at once, reporting a crash,
+// without running try. testName is passed to -test.run, so may name a
+// subtest; see ShouldCrashT.
+func ShouldCrashID(testName, id string, try func()) (CrashResult, error) {
+	if os.Getenv("SHOULD_CRASH") == "1" {
+		if os.Getenv("SHOULD_CRASH_ID") != id {
+			return CrashResult{Crashed: true}, nil
+		}
+		try()
+		os.Exit(0)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(os.Args[0], "-test.run="+testName)
+	cmd.Env = append(os.Environ(), "SHOULD_CRASH=1", "SHOULD_CRASH_ID="+id)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		return CrashResult{}, err
+	}
+	code := cmd.ProcessState.ExitCode()
+	return CrashResult{
+		Crashed:  code != 0,
+		ExitCode: code,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+	}, nil
 }
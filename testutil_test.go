@@ -2,13 +2,20 @@ package testutil
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/garyburd/redigo/redis"
+	"github.com/rainforestapp/testutil/redistest"
 )
 
 func ExampleFakeRedis() {
@@ -47,7 +54,7 @@ func ExampleFakeSQS() {
 		// handle error
 	}
 
-	fmt.Printf(*out.Messages[0].Body)
+	fmt.Println(*out.Messages[0].Body)
 	// Output:
 	// Hello!
 }
@@ -118,6 +125,61 @@ func ExampleShouldCrash_second() {
 	// failed!
 }
 
+func TestShouldCrashResult(t *testing.T) {
+	res, err := ShouldCrashResult("TestShouldCrashResult", func() {
+		fmt.Println("about to crash")
+		log.Fatal("disk full")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Crashed || res.ExitCode != 1 || !strings.Contains(res.Stderr, "disk full") || !strings.Contains(res.Stdout, "about to crash") {
+		t.Errorf("ShouldCrashResult = %+v", res)
+	}
+}
+
+func TestShouldCrashResultNoCrash(t *testing.T) {
+	res, err := ShouldCrashResult("TestShouldCrashResultNoCrash", func() {
+		fmt.Fprint(os.Stderr, "all good")
+	})
+	if err != nil || res.Crashed || res.ExitCode != 0 || res.Stderr != "all good" {
+		t.Errorf("ShouldCrashResult = %+v, %v", res, err)
+	}
+}
+
+// namedT is a recordingT with a name, for ShouldCrashT.
+type namedT struct {
+	recordingT
+	name string
+}
+
+func (t *namedT) Name() string { return t.name }
+
+func (t *namedT) Fatalf(format string, args ...interface{}) {
+	t.Errorf(format, args...)
+}
+
+func TestShouldCrashT(t *testing.T) {
+	for _, msg := range []string{"disk full", "out of memory"} {
+		t.Run(msg, func(t *testing.T) {
+			res := ShouldCrashT(t, "fatal", func() { log.Fatal(msg) })
+			if !strings.Contains(res.Stderr, msg) {
+				t.Errorf("stderr = %q", res.Stderr)
+			}
+			res = ShouldCrashT(t, "exit", func() { os.Exit(3) })
+			if res.ExitCode != 3 {
+				t.Errorf("exit code = %d", res.ExitCode)
+			}
+		})
+	}
+
+	rt := &namedT{name: t.Name()}
+	ShouldCrashT(rt, "none", func() { fmt.Print("still here") })
+	if len(rt.errors) != 1 || !strings.Contains(rt.errors[0], `crash "none" exited successfully; output:`+"\nstill here") {
+		t.Errorf("errors = %q", rt.errors)
+	}
+}
+
 func ExampleCaptureStdout() {
 	printFn := func() error {
 		fmt.Println("This goes to stdout")
@@ -128,3 +190,97 @@ func ExampleCaptureStdout() {
 	// Output:
 	// true
 }
+
+func ExampleWaitForValue() {
+	results := make(chan string, 1)
+	go func() { results <- "done" }()
+
+	result, err := WaitForValue(func() (string, bool) {
+		select {
+		case r := <-results:
+			return r, true
+		default:
+			return "", false
+		}
+	}, time.Second)
+	fmt.Println(result, err)
+	// Output:
+	// done <nil>
+}
+
+func TestWaitForValueTimeout(t *testing.T) {
+	calls := 0
+	v, err := WaitForValue(func() (int, bool) {
+		calls++
+		return calls, false
+	}, 50*time.Millisecond, WithPollInterval(20*time.Millisecond))
+	if err == nil {
+		t.Fatal("WaitForValue succeeded")
+	}
+	if v != calls || calls < 2 || calls > 5 {
+		t.Errorf("returned %d after %d calls", v, calls)
+	}
+}
+
+func TestWaitForContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := WaitForContext(ctx, func() bool {
+		calls++
+		if calls == 3 {
+			cancel()
+		}
+		return false
+	}, WithPollInterval(time.Millisecond))
+	if err != context.Canceled || calls != 3 {
+		t.Errorf("WaitForContext = %v after %d calls; want %v after 3", err, calls, context.Canceled)
+	}
+
+	// With backoff the interval grows from 1ms to 40ms, so 100ms
+	// allows far fewer tries than polling every 1ms would.
+	calls = 0
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = WaitForContext(ctx, func() bool { calls++; return false }, WithPollInterval(time.Millisecond), WithPollBackoff(40*time.Millisecond))
+	if err != context.DeadlineExceeded || calls > 15 {
+		t.Errorf("WaitForContext with backoff = %v after %d calls", err, calls)
+	}
+}
+
+// nopConn is a redis.Conn that accepts every command and replies with
+// nil.
+type nopConn struct{}
+
+func (nopConn) Close() error                                   { return nil }
+func (nopConn) Err() error                                     { return nil }
+func (nopConn) Do(string, ...interface{}) (interface{}, error) { return nil, nil }
+func (nopConn) Send(string, ...interface{}) error              { return nil }
+func (nopConn) Flush() error                                   { return nil }
+func (nopConn) Receive() (interface{}, error)                  { return nil, nil }
+
+// newStubRedis returns a redistest.FakeRedis configured from opts the
+// way NewFakeRedis does, whose connections are nopConns.
+func newStubRedis(opts ...Option) *redistest.FakeRedis {
+	ropts := redisOptions(newOptions(opts))
+	ropts = append(ropts, redistest.WithDialer(func() (redis.Conn, error) { return nopConn{}, nil }))
+	return redistest.New(ropts...)
+}
+
+func TestStartErrors(t *testing.T) {
+	// Nothing listens on a port that was just closed.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := "http://" + l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if s, err := StartFakeSQS(ctx, "jobs", WithEndpoint(endpoint)); s != nil || err == nil || !strings.Contains(err.Error(), "fake_sqs failed to start") {
+		t.Errorf("StartFakeSQS = %v, %v", s, err)
+	}
+	if s, err := StartFakeS3(ctx, "uploads", WithEndpoint(endpoint)); s != nil || err == nil || !strings.Contains(err.Error(), "could not connect to fakes3") {
+		t.Errorf("StartFakeS3 = %v, %v", s, err)
+	}
+}
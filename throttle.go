@@ -0,0 +1,138 @@
+package testutil
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Throttle rate limits requests to the AWS fakes per operation, so that
+// client-side backoff and retry configuration can be exercised. Attach
+// it with WithThrottle. Requests over the limit fail the way the real
+// services throttle: S3 returns 503 SlowDown and SQS returns 400
+// ThrottlingException, both of which the SDK retries.
+//
+// Operations are named as in Policy, for example "s3:PutObject"; "*"
+// sets a limit for every operation without its own.
+type Throttle struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	limits    map[string]*tokenBucket
+	allowed   map[string]int
+	throttled map[string]int
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewThrottle returns a Throttle with no limits.
+func NewThrottle() *Throttle {
+	return &Throttle{
+		now:       time.Now,
+		limits:    make(map[string]*tokenBucket),
+		allowed:   make(map[string]int),
+		throttled: make(map[string]int),
+	}
+}
+
+// Limit allows operation perSecond requests per second on average, with
+// bursts of up to burst requests. A perSecond of 0 throttles every
+// request beyond the initial burst. It returns th to allow chaining.
+func (th *Throttle) Limit(operation string, perSecond float64, burst int) *Throttle {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	th.limits[operation] = &tokenBucket{
+		rate:   perSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   th.now(),
+	}
+	return th
+}
+
+// Allowed returns how many requests for operation were let through.
+func (th *Throttle) Allowed(operation string) int {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	return th.allowed[operation]
+}
+
+// Throttled returns how many requests for operation were rejected.
+func (th *Throttle) Throttled(operation string) int {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	return th.throttled[operation]
+}
+
+// Reset clears the counters and refills every limit's burst.
+func (th *Throttle) Reset() {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	th.allowed = make(map[string]int)
+	th.throttled = make(map[string]int)
+	for _, b := range th.limits {
+		b.tokens = b.burst
+		b.last = th.now()
+	}
+}
+
+// take reports whether a request for operation is within its limit,
+// updating the counters either way.
+func (th *Throttle) take(operation string) bool {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+
+	b, ok := th.limits[operation]
+	if !ok {
+		b = th.limits["*"]
+	}
+	if b == nil {
+		th.allowed[operation]++
+		return true
+	}
+
+	now := th.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		th.throttled[operation]++
+		return false
+	}
+	b.tokens--
+	th.allowed[operation]++
+	return true
+}
+
+func (th *Throttle) sendHook(r *request.Request) *http.Response {
+	if th.take(operationName(r)) {
+		return nil
+	}
+	return throttledResponse(r)
+}
+
+// throttledResponse returns the error r's service returns when it
+// throttles a request.
+func throttledResponse(r *request.Request) *http.Response {
+	if r.ClientInfo.ServiceName == "s3" {
+		return errorResponse(r, http.StatusServiceUnavailable, "SlowDown", "Please reduce your request rate.")
+	}
+	return errorResponse(r, http.StatusBadRequest, "ThrottlingException", "Rate exceeded")
+}
+
+// WithThrottle attaches th to an AWS fake. Requests the fake makes
+// while it is being set up are not throttled or counted.
+func WithThrottle(th *Throttle) Option {
+	return func(o *options) {
+		o.sendHooks = append(o.sendHooks, th.sendHook)
+	}
+}
@@ -0,0 +1,57 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestThrottleTake(t *testing.T) {
+	now := time.Unix(0, 0)
+	th := NewThrottle().Limit("sqs:SendMessage", 1, 2)
+	th.now = func() time.Time { return now }
+	th.Reset()
+
+	for i, want := range []bool{true, true, false} {
+		if got := th.take("sqs:SendMessage"); got != want {
+			t.Errorf("request %d: got %v, want %v", i, got, want)
+		}
+	}
+	now = now.Add(time.Second)
+	if !th.take("sqs:SendMessage") {
+		t.Error("expected a token to be refilled after a second")
+	}
+	if !th.take("sqs:ReceiveMessage") {
+		t.Error("expected unlimited operation to be allowed")
+	}
+
+	if got := th.Allowed("sqs:SendMessage"); got != 3 {
+		t.Errorf("Allowed = %d, want 3", got)
+	}
+	if got := th.Throttled("sqs:SendMessage"); got != 1 {
+		t.Errorf("Throttled = %d, want 1", got)
+	}
+}
+
+func TestThrottleSendHookRetries(t *testing.T) {
+	th := NewThrottle().Limit("*", 0, 0)
+	o := newOptions([]Option{WithThrottle(th)})
+	cfg := fakeAWSConfig("http://127.0.0.1:1", o).WithMaxRetries(1)
+	client := sqs.New(session.New(cfg))
+	addSendHooks(o.sendHooks, &client.Handlers)
+
+	_, err := client.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String("http://127.0.0.1:1/queue"),
+		MessageBody: aws.String("hello"),
+	})
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "ThrottlingException" {
+		t.Fatalf("expected ThrottlingException, got %v", err)
+	}
+	if got := th.Throttled("sqs:SendMessage"); got != 2 {
+		t.Errorf("Throttled = %d, want 2 (one attempt and one retry)", got)
+	}
+}
@@ -0,0 +1,83 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// FakeTimeServer is an HTTP "time service" that reports the time of a
+// Clock, for testing code that syncs with or measures drift against a
+// remote clock. Every response carries the time in its Date header;
+// the body is JSON of the form
+//
+//	{"unixtime": 1467331200, "datetime": "2016-07-01T00:00:00Z"}
+//
+// Combine it with a FakeClock to control exactly what time is served.
+type FakeTimeServer struct {
+	// URL is the base URL of the server.
+	URL string
+
+	server *httptest.Server
+	clock  Clock
+
+	mu       sync.Mutex
+	offset   time.Duration
+	requests int
+}
+
+type timeResponse struct {
+	UnixTime int64  `json:"unixtime"`
+	DateTime string `json:"datetime"`
+}
+
+// NewFakeTimeServer starts a FakeTimeServer serving the time of clock.
+func NewFakeTimeServer(clock Clock) *FakeTimeServer {
+	s := &FakeTimeServer{clock: clock}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	return s
+}
+
+func (s *FakeTimeServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	now := s.Now()
+	s.mu.Lock()
+	s.requests++
+	s.mu.Unlock()
+
+	w.Header().Set("Date", now.UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timeResponse{
+		UnixTime: now.Unix(),
+		DateTime: now.UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// Now returns the time the server currently reports.
+func (s *FakeTimeServer) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clock.Now().Add(s.offset)
+}
+
+// SetOffset makes the server report its clock's time plus offset,
+// simulating a remote clock that has drifted from the local one.
+func (s *FakeTimeServer) SetOffset(offset time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset = offset
+}
+
+// Requests returns the number of requests served.
+func (s *FakeTimeServer) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Close shuts down the server.
+func (s *FakeTimeServer) Close() {
+	s.server.Close()
+}
@@ -0,0 +1,34 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+func ExampleFakeTimeServer() {
+	clock := NewFakeClock(time.Date(2016, 7, 1, 0, 0, 0, 0, time.UTC))
+	s := NewFakeTimeServer(clock)
+	defer s.Close()
+
+	// The remote clock is five minutes fast
+	s.SetOffset(5 * time.Minute)
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		// handle error
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		DateTime string `json:"datetime"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+
+	fmt.Println(resp.Header.Get("Date"))
+	fmt.Println(body.DateTime)
+	// Output:
+	// Fri, 01 Jul 2016 00:05:00 GMT
+	// 2016-07-01T00:05:00Z
+}
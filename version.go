@@ -0,0 +1,222 @@
+package testutil
+
+import (
+	"context"
+	"net"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BackendVersion identifies the server a fake talks to.
+type BackendVersion struct {
+	// Name is the server's name: "redis", "fakes3", "fake_sqs" or
+	// "minio".
+	Name string
+
+	// Version is the server's version, or "" if it couldn't be
+	// detected.
+	Version string
+}
+
+func (b BackendVersion) String() string {
+	if b.Version == "" {
+		return b.Name + " (unknown version)"
+	}
+	return b.Name + " " + b.Version
+}
+
+// Incompatibility describes versions of a backend known to misbehave
+// under the fakes.
+type Incompatibility struct {
+	// Backend is the BackendVersion.Name it applies to.
+	Backend string
+
+	// Versions is a space-separated list of constraints, all of which
+	// a version must meet, such as "<6.0.0" or ">=1.0.0 <1.2.0".
+	// Versions are compared number by number, so minio's
+	// RELEASE.2023-05-04T21-44-30Z compares as 2023.05.04.21.44.30.
+	Versions string
+
+	// Problem says what goes wrong, and what to use instead.
+	Problem string
+}
+
+// KnownIncompatibilities are checked by every fake that can tell which
+// version of its backend it is talking to. Add to it from TestMain to
+// warn about versions your own tests have trouble with.
+var KnownIncompatibilities = []Incompatibility{
+	{
+		Backend:  "redis",
+		Versions: "<2.8.0",
+		Problem:  "it has no SCAN, which LeakAudit uses to find leftover keys; use redis 2.8 or later",
+	},
+	{
+		Backend:  "redis",
+		Versions: "<6.0.0",
+		Problem:  "it can't speak RESP3 or track keys, so RESP3Conn doesn't work; use redis 6 or later",
+	},
+}
+
+// WithSkipIncompatible makes a fake skip t when its backend is a
+// version listed in KnownIncompatibilities, instead of logging a
+// warning and carrying on.
+func WithSkipIncompatible(t SkipT) Option {
+	return func(o *options) {
+		o.skipIncompatible = t
+	}
+}
+
+// checkBackend warns about, or skips the test for, each known problem
+// with b.
+func checkBackend(o *options, b BackendVersion) {
+	if b.Version == "" {
+		return
+	}
+	for _, inc := range KnownIncompatibilities {
+		if inc.Backend != b.Name || !versionIn(b.Version, inc.Versions) {
+			continue
+		}
+		if o.skipIncompatible != nil {
+			o.skipIncompatible.Helper()
+			o.skipIncompatible.Skipf("Skipping: %s is incompatible: %s", b, inc.Problem)
+			return
+		}
+		o.logger.Logf("WARNING: %s is incompatible: %s", b, inc.Problem)
+	}
+}
+
+var versionNumbers = regexp.MustCompile(`\d+`)
+
+// compareVersions compares versions a and b number by number, returning
+// -1, 0 or 1. Missing trailing numbers count as 0.
+func compareVersions(a, b string) int {
+	x := versionNumbers.FindAllString(a, -1)
+	y := versionNumbers.FindAllString(b, -1)
+	for i := 0; i < len(x) || i < len(y); i++ {
+		var m, n int
+		if i < len(x) {
+			m, _ = strconv.Atoi(x[i])
+		}
+		if i < len(y) {
+			n, _ = strconv.Atoi(y[i])
+		}
+		switch {
+		case m < n:
+			return -1
+		case m > n:
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionIn reports whether version meets every constraint in
+// constraints.
+func versionIn(version, constraints string) bool {
+	for _, c := range strings.Fields(constraints) {
+		op := c[:len(c)-len(strings.TrimLeft(c, "<>=!"))]
+		cmp := compareVersions(version, c[len(op):])
+		var ok bool
+		switch op {
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "!=":
+			ok = cmp != 0
+		default:
+			ok = cmp == 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// detectBackend works out which server is listening at endpoint, which
+// is expected to be dep, and its version. Only servers on this machine
+// can be identified.
+func detectBackend(dep Dependency, endpoint string) BackendVersion {
+	b := BackendVersion{Name: dep.Name}
+	host, port, err := net.SplitHostPort(endpointDependency(dep, endpoint).Addr)
+	if err != nil || !isLoopback(host) {
+		return b
+	}
+	n, _ := strconv.Atoi(port)
+	_, command := portOwner(n)
+	switch {
+	case command == "" || matchesCommand(command, dep.Processes):
+	case matchesCommand(command, []string{"minio"}):
+		b.Name = "minio"
+	default:
+		// Something else, perhaps a stub or a forwarded port.
+		return b
+	}
+	b.Version = installedVersion(b.Name)
+	return b
+}
+
+var installedVersions sync.Map
+
+// installedVersion returns the version of the installed executable or
+// gem called name, or "".
+func installedVersion(name string) string {
+	if v, ok := installedVersions.Load(name); ok {
+		return v.(string)
+	}
+	var v string
+	switch name {
+	case "fakes3", "fake_sqs":
+		// gem list prints "fakes3 (2.0.0, 1.2.1)", newest first, and
+		// the newest is the one the gem's executable runs.
+		out := commandOutput("gem", "list", "--local", "--exact", name)
+		if i := strings.Index(out, name+" ("); i >= 0 {
+			if f := strings.FieldsFunc(out[i+len(name)+2:], func(r rune) bool { return r == ',' || r == ')' || r == ' ' }); len(f) > 0 {
+				v = f[0]
+			}
+		}
+	case "minio":
+		// "minio version RELEASE.2023-05-04T21-44-30Z (commit-id=...)"
+		if f := strings.Fields(commandOutput("minio", "--version")); len(f) >= 3 && f[1] == "version" {
+			v = f[2]
+		}
+	}
+	installedVersions.Store(name, v)
+	return v
+}
+
+func commandOutput(name string, args ...string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+// Backend returns the redis server the fake talks to, and its version.
+func (r *FakeRedis) Backend() BackendVersion {
+	return r.backend
+}
+
+// Backend returns the server the fake talks to, and its version if it
+// could be detected.
+func (s *FakeS3) Backend() BackendVersion {
+	return s.backend
+}
+
+// Backend returns the server the fake talks to, and its version if it
+// could be detected.
+func (s *FakeSQS) Backend() BackendVersion {
+	return s.backend
+}
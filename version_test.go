@@ -0,0 +1,79 @@
+package testutil
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestVersionIn(t *testing.T) {
+	for _, tc := range []struct {
+		version, constraints string
+		want                 bool
+	}{
+		{"5.0.7", "<6.0.0", true},
+		{"6.0.0", "<6.0.0", false},
+		{"6", "<6.0.0", false},
+		{"7.2.4", ">=7 <7.2.5", true},
+		{"7.2.5", ">=7 <7.2.5", false},
+		{"1.2.1", "1.2.1", true},
+		{"1.2.1", "!=1.2.1", false},
+		{"0.2.10", ">0.2.9", true},
+		{"RELEASE.2023-05-04T21-44-30Z", "<RELEASE.2023-06-01T00-00-00Z", true},
+		{"RELEASE.2023-05-04T21-44-30Z", ">=2023.5.4.22", false},
+	} {
+		if got := versionIn(tc.version, tc.constraints); got != tc.want {
+			t.Errorf("versionIn(%q, %q) = %v, want %v", tc.version, tc.constraints, got, tc.want)
+		}
+	}
+}
+
+// logLogger is a Logger that records what is logged. It is safe for
+// concurrent use, as by the goroutines copying process output, under
+// recordingLogger's lock.
+type logLogger struct {
+	recordingLogger
+	logs []string
+}
+
+func (l *logLogger) Logf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logs = append(l.logs, fmt.Sprintf(format, args...))
+}
+
+// lines returns what has been logged so far.
+func (l *logLogger) lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.logs...)
+}
+
+func TestCheckBackend(t *testing.T) {
+	old := KnownIncompatibilities
+	defer func() { KnownIncompatibilities = old }()
+	KnownIncompatibilities = append(old, Incompatibility{
+		Backend:  "fakes3",
+		Versions: ">=0.2.0 <0.3.0",
+		Problem:  "it mangles metadata",
+	})
+
+	logger := &logLogger{}
+	o := newOptions([]Option{WithLogger(logger)})
+	checkBackend(o, BackendVersion{Name: "fakes3", Version: "1.2.1"})
+	checkBackend(o, BackendVersion{Name: "fakes3"})
+	checkBackend(o, BackendVersion{Name: "redis", Version: "7.2.4"})
+	if len(logger.lines()) != 0 {
+		t.Errorf("warned %q about compatible versions", logger.lines())
+	}
+	checkBackend(o, BackendVersion{Name: "fakes3", Version: "0.2.5"})
+	if want := []string{"WARNING: fakes3 0.2.5 is incompatible: it mangles metadata"}; fmt.Sprint(logger.lines()) != fmt.Sprint(want) {
+		t.Errorf("logged %q, want %q", logger.lines(), want)
+	}
+
+	st := &skippingT{}
+	o = newOptions([]Option{WithLogger(logger), WithSkipIncompatible(st)})
+	checkBackend(o, BackendVersion{Name: "redis", Version: "5.0.7"})
+	if want := "Skipping: redis 5.0.7 is incompatible: it can't speak RESP3 or track keys, so RESP3Conn doesn't work; use redis 6 or later"; st.skipped != want {
+		t.Errorf("skipped with %q, want %q", st.skipped, want)
+	}
+}
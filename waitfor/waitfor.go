@@ -0,0 +1,82 @@
+// Package waitfor polls for conditions that become true asynchronously,
+// such as a background worker finishing, in tests. It depends only on
+// the standard library.
+package waitfor
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Condition runs the try function repeatedly until it returns true. If
+// the try function does not return true within the timeout period,
+// fail is called. Condition is mostly useful for checking conditions
+// asynchronously in tests; it probably shouldn't be used for
+// production code.
+func Condition(try func() bool, fail func(), timeout time.Duration) {
+	start := time.Now()
+	for {
+		if try() {
+			return
+		} else if time.Now().Sub(start) > timeout {
+			fail()
+			return
+		}
+		// Yield rather than spin, so that whatever try is waiting for
+		// gets the CPU.
+		time.Sleep(conditionPause)
+	}
+}
+
+// conditionPause is how long Condition sleeps between tries.
+const conditionPause = time.Millisecond
+
+// Context calls try every interval until it returns true or ctx is
+// done, in which case it returns ctx's error.
+func Context(ctx context.Context, try func() bool, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if try() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Backoff calls try until it returns true or ctx is done, in which
+// case it returns ctx's error. It waits about interval after the first
+// failed try, doubling the wait after each one up to max. Each wait is
+// randomized between half and all of it, so that pollers started
+// together spread out.
+func Backoff(ctx context.Context, try func() bool, interval, max time.Duration) error {
+	if max < interval {
+		max = interval
+	}
+	for wait := interval; ; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if try() {
+			return nil
+		}
+		timer := time.NewTimer(wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if wait *= 2; wait > max {
+			wait = max
+		}
+	}
+}
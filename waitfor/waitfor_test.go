@@ -0,0 +1,56 @@
+package waitfor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCondition(t *testing.T) {
+	calls := 0
+	Condition(func() bool { calls++; return calls == 3 }, func() { t.Error("fail called") }, time.Second)
+	if calls != 3 {
+		t.Errorf("try called %d times, want 3", calls)
+	}
+
+	failed := false
+	Condition(func() bool { return false }, func() { failed = true }, 10*time.Millisecond)
+	if !failed {
+		t.Error("fail not called after the timeout")
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	calls := 0
+	err := Context(ctx, func() bool { calls++; return false }, time.Millisecond)
+	if err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if calls == 0 {
+		t.Error("expected try to be called before the deadline")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	var tries []time.Time
+	err := Backoff(context.Background(), func() bool {
+		tries = append(tries, time.Now())
+		return len(tries) == 5
+	}, 4*time.Millisecond, 16*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The waits are at least 2, 4, 8 and 8ms.
+	if d := tries[4].Sub(tries[0]); d < 22*time.Millisecond {
+		t.Errorf("4 waits took %v; want backoff", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Backoff(ctx, func() bool { return true }, time.Millisecond, time.Second); err != context.Canceled {
+		t.Errorf("Backoff with a cancelled context = %v", err)
+	}
+}